@@ -0,0 +1,393 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsapi
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// ctxIDKey is used to tag the contexts of Client calls.
+	ctxIDKey ctxTagKey = iota
+	ctxOpID            = "KBAPI"
+)
+
+type ctxTagKey int
+
+// Client makes KBFS calls on behalf of another Go program.  It is
+// safe to use from multiple goroutines.
+type Client struct {
+	config libkbfs.Config
+	log    logger.Logger
+}
+
+// NewClient returns a Client that uses the given running KBFS
+// instance.
+func NewClient(config libkbfs.Config) *Client {
+	return &Client{
+		config: config,
+		log:    config.MakeLogger("api"),
+	}
+}
+
+func (c *Client) makeContext(ctx context.Context) context.Context {
+	ctx = libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, c.log)
+	return libkbfs.CtxWithRequestSource(ctx, libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendAPI,
+	})
+}
+
+// translateErr turns KBFS errors into the standard os errors where
+// there is one, so callers can use os.IsNotExist and friends.
+func translateErr(err error) error {
+	switch cause := errors.Cause(err).(type) {
+	case nil:
+		return nil
+	case libkbfs.NoSuchNameError, libfs.TlfDoesNotExist:
+		return os.ErrNotExist
+	case libkbfs.TlfAccessError, libkbfs.ReadAccessError,
+		libkbfs.WriteAccessError, libkbfs.OpNotPermittedError:
+		return os.ErrPermission
+	case libkbfs.NotDirError, libkbfs.NotFileError:
+		return os.ErrInvalid
+	case libkbfs.NameExistsError:
+		return os.ErrExist
+	default:
+		if cause == os.ErrNotExist || cause == os.ErrExist ||
+			cause == os.ErrPermission || cause == os.ErrInvalid ||
+			cause == ErrInvalidPath {
+			return cause
+		}
+		return err
+	}
+}
+
+// getFS returns a file system rooted at dir, which must be a
+// directory path.
+func (c *Client) getFS(ctx context.Context, dir string) (*libfs.FS, error) {
+	folder, inner, err := parsePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, c.config.KBPKI(), c.config.MDOps(), folder.Name,
+		folder.Type.tlfType())
+	if err != nil {
+		return nil, err
+	}
+	fs, err := libfs.NewFS(
+		ctx, c.config, h, inner, "", keybase1.MDPriorityNormal)
+	if err != nil {
+		if exitEarly, _ := libfs.FilterTLFEarlyExitError(
+			ctx, err, c.log, h.GetCanonicalName()); exitEarly {
+			return nil, libfs.TlfDoesNotExist{}
+		}
+		return nil, err
+	}
+	return fs, nil
+}
+
+// getParentFS returns a file system rooted at the parent directory of
+// p, along with the name of p in it.  If p is the root of a folder,
+// the file system is rooted there instead, and the name is "".
+func (c *Client) getParentFS(ctx context.Context, p string) (
+	fs *libfs.FS, name string, err error) {
+	_, inner, err := parsePath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	p = path.Clean(p)
+	if inner == "" {
+		fs, err = c.getFS(ctx, p)
+		return fs, "", err
+	}
+	fs, err = c.getFS(ctx, path.Dir(p))
+	return fs, path.Base(p), err
+}
+
+func toFileInfo(fi os.FileInfo) FileInfo {
+	t := File
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		t = Dir
+	case mode&os.ModeSymlink != 0:
+		t = Symlink
+	case mode&0100 != 0:
+		t = Executable
+	}
+	return FileInfo{
+		Name:    fi.Name(),
+		Type:    t,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+}
+
+// Stat returns information about the entry at p, following any
+// symlinks.
+func (c *Client) Stat(ctx context.Context, p string) (
+	fi FileInfo, err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Stat %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "Stat done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	fs, name, err := c.getParentFS(ctx, p)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if name == "" {
+		name = "."
+	}
+	osfi, err := fs.Stat(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fi = toFileInfo(osfi)
+	if name == "." {
+		fi.Name = path.Base(path.Clean(p))
+	}
+	return fi, nil
+}
+
+// List returns the entries of the directory at p.  Symlinks in the
+// directory are not followed.
+func (c *Client) List(ctx context.Context, p string) (
+	fis []FileInfo, err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "List %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "List done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	fs, err := c.getFS(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	osfis, err := fs.ReadDir("")
+	if err != nil {
+		return nil, err
+	}
+	fis = make([]FileInfo, 0, len(osfis))
+	for _, osfi := range osfis {
+		fis = append(fis, toFileInfo(osfi))
+	}
+	return fis, nil
+}
+
+// FileHandle is an open KBFS file.  Writes made through it are flushed
+// to the servers in the background; call Client.Sync to wait for them.
+type FileHandle struct {
+	f billy.File
+}
+
+var _ io.ReadWriteSeeker = (*FileHandle)(nil)
+var _ io.ReaderAt = (*FileHandle)(nil)
+var _ io.Closer = (*FileHandle)(nil)
+
+// Read implements the io.Reader interface for FileHandle.
+func (fh *FileHandle) Read(p []byte) (n int, err error) {
+	n, err = fh.f.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, translateErr(err)
+}
+
+// ReadAt implements the io.ReaderAt interface for FileHandle.
+func (fh *FileHandle) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = fh.f.ReadAt(p, off)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, translateErr(err)
+}
+
+// Write implements the io.Writer interface for FileHandle.
+func (fh *FileHandle) Write(p []byte) (n int, err error) {
+	n, err = fh.f.Write(p)
+	return n, translateErr(err)
+}
+
+// Seek implements the io.Seeker interface for FileHandle.
+func (fh *FileHandle) Seek(offset int64, whence int) (int64, error) {
+	n, err := fh.f.Seek(offset, whence)
+	return n, translateErr(err)
+}
+
+// Close implements the io.Closer interface for FileHandle.
+func (fh *FileHandle) Close() error {
+	return translateErr(fh.f.Close())
+}
+
+func (c *Client) openFile(ctx context.Context, p string, flag int,
+	perm os.FileMode) (fh *FileHandle, err error) {
+	fs, name, err := c.getParentFS(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.WithStack(os.ErrInvalid)
+	}
+	f, err := fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &FileHandle{f: f}, nil
+}
+
+// Open opens the file at p for reading.  ctx is used for all later
+// calls on the returned handle.
+func (c *Client) Open(ctx context.Context, p string) (
+	fh *FileHandle, err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Open %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "Open done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	return c.openFile(ctx, p, os.O_RDONLY, 0)
+}
+
+// Create creates the file at p, or truncates it if it already
+// exists, and opens it for reading and writing.  The parent directory
+// must already exist.  ctx is used for all later calls on the
+// returned handle.
+func (c *Client) Create(ctx context.Context, p string) (
+	fh *FileHandle, err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Create %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "Create done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	return c.openFile(ctx, p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// ReadFile returns the contents of the file at p.
+func (c *Client) ReadFile(ctx context.Context, p string) (
+	data []byte, err error) {
+	fh, err := c.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	data, err = ioutil.ReadAll(fh)
+	return data, translateErr(err)
+}
+
+// WriteFile replaces the contents of the file at p with data,
+// creating the file if needed, and waits until the change has been
+// flushed from this device.
+func (c *Client) WriteFile(ctx context.Context, p string, data []byte) (
+	err error) {
+	fh, err := c.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	err = fh.Close()
+	if err != nil {
+		return err
+	}
+	return c.Sync(ctx, p)
+}
+
+// Sync waits until all changes to the folder containing p have been
+// flushed from this device.
+func (c *Client) Sync(ctx context.Context, p string) (err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Sync %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "Sync done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	folder, _, err := parsePath(p)
+	if err != nil {
+		return err
+	}
+	fs, err := c.getFS(ctx, folder.Path())
+	if err != nil {
+		return err
+	}
+	return fs.SyncAll()
+}
+
+// Favorites returns the current user's favorite folders.
+func (c *Client) Favorites(ctx context.Context) (
+	folders []Folder, err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Favorites")
+	defer func() {
+		c.log.CDebugf(ctx, "Favorites done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	favs, err := c.config.KBFSOps().GetFavorites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folders = make([]Folder, 0, len(favs))
+	for _, fav := range favs {
+		folders = append(folders, Folder{
+			Name: fav.Name,
+			Type: folderTypeFromTlfType(fav.Type),
+		})
+	}
+	return folders, nil
+}
+
+// AddFavorite adds folder to the current user's favorites.
+func (c *Client) AddFavorite(ctx context.Context, folder Folder) (
+	err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "AddFavorite %s", folder.Path())
+	defer func() {
+		c.log.CDebugf(ctx, "AddFavorite done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	return c.config.KBFSOps().AddFavorite(ctx, libkbfs.Favorite{
+		Name: folder.Name,
+		Type: folder.Type.tlfType(),
+	})
+}
+
+// RemoveFavorite removes folder from the current user's favorites.
+func (c *Client) RemoveFavorite(ctx context.Context, folder Folder) (
+	err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "RemoveFavorite %s", folder.Path())
+	defer func() {
+		c.log.CDebugf(ctx, "RemoveFavorite done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	return c.config.KBFSOps().DeleteFavorite(ctx, libkbfs.Favorite{
+		Name: folder.Name,
+		Type: folder.Type.tlfType(),
+	})
+}
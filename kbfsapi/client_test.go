@@ -0,0 +1,123 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsapi
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	folder, inner, err := parsePath("/keybase/private/u1,u2/a/b/")
+	require.NoError(t, err)
+	require.Equal(t, Folder{Name: "u1,u2", Type: PrivateFolder}, folder)
+	require.Equal(t, "a/b", inner)
+	require.Equal(t, "/keybase/private/u1,u2", folder.Path())
+
+	folder, inner, err = parsePath("/keybase/team/t1")
+	require.NoError(t, err)
+	require.Equal(t, Folder{Name: "t1", Type: TeamFolder}, folder)
+	require.Equal(t, "", inner)
+
+	for _, p := range []string{
+		"", "/", "/keybase", "/keybase/private", "/keybase/secret/u1",
+		"/kbfs/private/u1", "keybase/private/u1",
+	} {
+		_, _, err := parsePath(p)
+		require.Equal(t, ErrInvalidPath, errors.Cause(err), p)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	c := NewClient(config)
+
+	root := "/keybase/private/user1"
+	_, err := c.Stat(ctx, root+"/a")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Watch the folder root before writing to it")
+	events := make(chan Event, 1)
+	stop, err := c.Watch(ctx, root, events)
+	require.NoError(t, err)
+	defer stop()
+
+	err = c.WriteFile(ctx, root+"/a", []byte("hello"))
+	require.NoError(t, err)
+	select {
+	case e := <-events:
+		require.Equal(t, root, e.Path)
+		require.Equal(t, []string{"a"}, e.Names)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a watch event")
+	}
+	stop()
+
+	data, err := c.ReadFile(ctx, root+"/a")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	fh, err := c.Create(ctx, root+"/b")
+	require.NoError(t, err)
+	_, err = fh.Write([]byte("world!"))
+	require.NoError(t, err)
+	err = fh.Close()
+	require.NoError(t, err)
+	err = c.Sync(ctx, root+"/b")
+	require.NoError(t, err)
+
+	fi, err := c.Stat(ctx, root+"/b")
+	require.NoError(t, err)
+	require.Equal(t, "b", fi.Name)
+	require.Equal(t, File, fi.Type)
+	require.Equal(t, int64(6), fi.Size)
+
+	fi, err = c.Stat(ctx, root)
+	require.NoError(t, err)
+	require.Equal(t, "user1", fi.Name)
+	require.Equal(t, Dir, fi.Type)
+
+	fis, err := c.List(ctx, root)
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name)
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"a", "b"}, names)
+
+	_, err = c.Open(ctx, root+"/c")
+	require.True(t, os.IsNotExist(err))
+	_, err = c.List(ctx, "/keybase/private")
+	require.Equal(t, ErrInvalidPath, err)
+}
+
+func TestClientFavorites(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1", "user2")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	c := NewClient(config)
+
+	folder := Folder{Name: "user1,user2", Type: PublicFolder}
+	err := c.AddFavorite(ctx, folder)
+	require.NoError(t, err)
+	folders, err := c.Favorites(ctx)
+	require.NoError(t, err)
+	require.Contains(t, folders, folder)
+
+	err = c.RemoveFavorite(ctx, folder)
+	require.NoError(t, err)
+	folders, err = c.Favorites(ctx)
+	require.NoError(t, err)
+	require.NotContains(t, folders, folder)
+}
@@ -0,0 +1,20 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package kbfsapi is a small, stable API for Go programs that want to
+// use KBFS by path, without tracking changes to the libkbfs
+// interfaces.
+//
+// Paths always have the form /keybase/<type>/<folder>/<path>, where
+// <type> is "private", "public" or "team", regardless of where (or
+// whether) KBFS is mounted on the local machine.
+//
+// The API is versioned according to semantic versioning, and Version
+// holds the current version.  Within a major version, exported names
+// are never removed and their behavior never changes in incompatible
+// ways.  New methods, constants and struct fields may be added in
+// minor versions, so callers should use field names when building
+// the structs defined here.  Apart from the libkbfs.Config used to
+// make a Client, no libkbfs types are part of the API.
+package kbfsapi
@@ -0,0 +1,152 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsapi
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// Version is the semantic version of this package's API.
+const Version = "1.0.0"
+
+// ErrInvalidPath is returned for paths that don't have the form
+// /keybase/<type>/<folder>[/<path>].
+var ErrInvalidPath = errors.New("Invalid KBFS path")
+
+// FolderType is the type of a top-level folder.
+type FolderType int
+
+const (
+	// PrivateFolder is a folder readable only by its members.
+	PrivateFolder FolderType = iota + 1
+	// PublicFolder is a folder readable by anyone.
+	PublicFolder
+	// TeamFolder is a folder belonging to a team.
+	TeamFolder
+)
+
+func (t FolderType) String() string {
+	switch t {
+	case PrivateFolder:
+		return "private"
+	case PublicFolder:
+		return "public"
+	case TeamFolder:
+		return "team"
+	default:
+		return fmt.Sprintf("FolderType(%d)", int(t))
+	}
+}
+
+func folderTypeFromTlfType(t tlf.Type) FolderType {
+	switch t {
+	case tlf.Private:
+		return PrivateFolder
+	case tlf.Public:
+		return PublicFolder
+	case tlf.SingleTeam:
+		return TeamFolder
+	default:
+		return 0
+	}
+}
+
+func (t FolderType) tlfType() tlf.Type {
+	switch t {
+	case PrivateFolder:
+		return tlf.Private
+	case PublicFolder:
+		return tlf.Public
+	case TeamFolder:
+		return tlf.SingleTeam
+	default:
+		return tlf.Unknown
+	}
+}
+
+// Folder identifies a top-level folder, like the private folder
+// shared by alice and bob, which has the Name "alice,bob".
+type Folder struct {
+	Name string
+	Type FolderType
+}
+
+// Path returns the path of the root of the folder.
+func (f Folder) Path() string {
+	return path.Join("/keybase", f.Type.String(), f.Name)
+}
+
+// parsePath splits p into the folder it is in, and the path of p
+// within that folder ("" for the root of the folder).
+func parsePath(p string) (folder Folder, inner string, err error) {
+	p = path.Clean(p)
+	parts := strings.SplitN(p, "/", 5)
+	if len(parts) < 4 || parts[0] != "" || parts[1] != "keybase" ||
+		parts[3] == "" {
+		return Folder{}, "", errors.WithStack(ErrInvalidPath)
+	}
+	t, err := tlf.ParseTlfTypeFromPath(parts[2])
+	if err != nil {
+		return Folder{}, "", errors.WithStack(ErrInvalidPath)
+	}
+	folder = Folder{Name: parts[3], Type: folderTypeFromTlfType(t)}
+	if len(parts) == 5 {
+		inner = parts[4]
+	}
+	return folder, inner, nil
+}
+
+// EntryType is the type of a directory entry.
+type EntryType int
+
+const (
+	// File is a regular file.
+	File EntryType = iota + 1
+	// Executable is a regular file that may be executed.
+	Executable
+	// Dir is a directory.
+	Dir
+	// Symlink is a symbolic link.
+	Symlink
+)
+
+func (t EntryType) String() string {
+	switch t {
+	case File:
+		return "file"
+	case Executable:
+		return "executable"
+	case Dir:
+		return "dir"
+	case Symlink:
+		return "symlink"
+	default:
+		return fmt.Sprintf("EntryType(%d)", int(t))
+	}
+}
+
+// FileInfo describes a directory entry.
+type FileInfo struct {
+	Name    string
+	Type    EntryType
+	Size    int64
+	ModTime time.Time
+}
+
+// Event says that something at a watched path has changed.
+type Event struct {
+	// Path is the watched path.
+	Path string
+	// Names lists the entries that were added, removed or renamed,
+	// when the watched path is a directory.  It is empty when the
+	// contents of a watched file changed.
+	Names []string
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsapi
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// watcher is an Observer that queues an Event for each batch of
+// changes that touches a single node.
+type watcher struct {
+	path string
+	// node is held so that it stays in the node cache, and keeps its
+	// ID, for as long as the watch lasts.
+	node  libkbfs.Node
+	queue *libkbfs.InfiniteChannelWrapper
+}
+
+var _ libkbfs.Observer = (*watcher)(nil)
+
+func (w *watcher) LocalChange(
+	context.Context, libkbfs.Node, libkbfs.WriteRange) {
+	// Local writes are announced again in BatchChanges once they
+	// are synced, so ignore them here.
+}
+
+func (w *watcher) BatchChanges(
+	_ context.Context, changes []libkbfs.NodeChange, _ []libkbfs.NodeID) {
+	// The watcher is registered with a filter for w.node, so every
+	// change is for it.
+	for _, change := range changes {
+		var names []string
+		if len(change.DirUpdated) > 0 {
+			names = append(names, change.DirUpdated...)
+		}
+		w.queue.In() <- Event{Path: w.path, Names: names}
+	}
+}
+
+func (w *watcher) TlfHandleChange(context.Context, *libkbfs.TlfHandle) {}
+
+// Watch sends an Event to ch whenever the file at p is written, or,
+// if p is a directory, whenever entries are added to, removed from
+// or renamed within it.  Changes made on other devices are included
+// once this device learns about them.  Events are queued rather than
+// dropped when ch isn't ready for them.  The watch lasts until the
+// returned stop function is called; ch is never closed.
+func (c *Client) Watch(ctx context.Context, p string, ch chan<- Event) (
+	stop func(), err error) {
+	ctx = c.makeContext(ctx)
+	c.log.CDebugf(ctx, "Watch %s", p)
+	defer func() {
+		c.log.CDebugf(ctx, "Watch done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	fs, name, err := c.getParentFS(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	node := fs.RootNode()
+	if name != "" {
+		node, _, err = c.config.KBFSOps().Lookup(ctx, node, name)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			// Symlinks have no node of their own to watch.
+			return nil, errors.WithStack(os.ErrInvalid)
+		}
+	}
+
+	w := &watcher{
+		path:  p,
+		node:  node,
+		queue: libkbfs.NewInfiniteChannelWrapper(),
+	}
+	fbs := []libkbfs.FolderBranch{node.GetFolderBranch()}
+	err = c.config.Notifier().RegisterForChangesWithFilter(
+		fbs, w, libkbfs.ObserverFilter{Root: node})
+	if err != nil {
+		w.queue.Close()
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		for e := range w.queue.Out() {
+			select {
+			case ch <- e.(Event):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			err := c.config.Notifier().UnregisterFromChanges(fbs, w)
+			if err != nil {
+				c.log.CDebugf(
+					context.Background(), "Couldn't unregister watch: %+v", err)
+			}
+			w.queue.Close()
+			close(stopCh)
+		})
+	}, nil
+}
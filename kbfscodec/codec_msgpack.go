@@ -7,6 +7,7 @@ package kbfscodec
 import (
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/keybase/go-codec/codec"
 	"github.com/pkg/errors"
@@ -121,11 +122,29 @@ func (es extSlice) ReadExt(v interface{}, buf []byte) {
 	}
 }
 
+// parkedEncoderBuf is the output buffer of idle pooled encoders, so
+// that they don't hold on to the last buffer they encoded into.  It
+// is never written to.
+var parkedEncoderBuf = []byte{}
+
+// codecPools caches encoders and decoders across calls.  Besides
+// saving the allocation of the Encoder or Decoder itself, a reused
+// one keeps the encoding functions it has already looked up for
+// each type, which would otherwise be rebuilt on every call.
+type codecPools struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
 // CodecMsgpack implements the Codec interface using msgpack
 // marshaling and unmarshaling.
 type CodecMsgpack struct {
 	h        codec.Handle
 	ExtCodec *CodecMsgpack
+	// pools is replaced whenever a type is registered, since the
+	// cached encoders and decoders may have looked up that type
+	// already.
+	pools *codecPools
 }
 
 // newCodecMsgpackHelper constructs a new CodecMsgpack that may or may
@@ -142,8 +161,8 @@ func newCodecMsgpackHelper(handleUnknownFields bool) *CodecMsgpack {
 	// types.
 	handleNoExt := handle
 	handleNoExt.WriteExt = false
-	ExtCodec := &CodecMsgpack{&handleNoExt, nil}
-	return &CodecMsgpack{&handle, ExtCodec}
+	ExtCodec := &CodecMsgpack{&handleNoExt, nil, &codecPools{}}
+	return &CodecMsgpack{&handle, ExtCodec, &codecPools{}}
 }
 
 // NewMsgpack constructs a new CodecMsgpack.
@@ -159,29 +178,51 @@ func NewMsgpackNoUnknownFields() *CodecMsgpack {
 
 // Decode implements the Codec interface for CodecMsgpack
 func (c *CodecMsgpack) Decode(buf []byte, obj interface{}) error {
-	err := codec.NewDecoderBytes(buf, c.h).Decode(obj)
+	pools := c.pools
+	d, ok := pools.decoders.Get().(*codec.Decoder)
+	if ok {
+		d.ResetBytes(buf)
+	} else {
+		d = codec.NewDecoderBytes(buf, c.h)
+	}
+	err := d.Decode(obj)
 	if err != nil {
+		// The decoder might be in a bad state, so don't reuse it.
 		return errors.Wrap(err, "failed to decode")
 	}
+	d.ResetBytes(nil)
+	pools.decoders.Put(d)
 	return nil
 }
 
 // Encode implements the Codec interface for CodecMsgpack
 func (c *CodecMsgpack) Encode(obj interface{}) (buf []byte, err error) {
-	err = codec.NewEncoderBytes(&buf, c.h).Encode(obj)
+	pools := c.pools
+	e, ok := pools.encoders.Get().(*codec.Encoder)
+	if ok {
+		e.ResetBytes(&buf)
+	} else {
+		e = codec.NewEncoderBytes(&buf, c.h)
+	}
+	err = e.Encode(obj)
 	if err != nil {
+		// The encoder might be in a bad state, so don't reuse it.
 		return nil, errors.Wrap(err, "failed to encode")
 	}
+	e.ResetBytes(&parkedEncoderBuf)
+	pools.encoders.Put(e)
 	return buf, nil
 }
 
 // RegisterType implements the Codec interface for CodecMsgpack
 func (c *CodecMsgpack) RegisterType(rt reflect.Type, code ExtCode) {
 	c.h.(*codec.MsgpackHandle).SetExt(rt, uint64(code), ext{c.ExtCodec})
+	c.pools = &codecPools{}
 }
 
 // RegisterIfaceSliceType implements the Codec interface for CodecMsgpack
 func (c *CodecMsgpack) RegisterIfaceSliceType(rt reflect.Type, code ExtCode,
 	typer func(interface{}) reflect.Value) {
 	c.h.(*codec.MsgpackHandle).SetExt(rt, uint64(code), extSlice{c, typer})
+	c.pools = &codecPools{}
 }
@@ -5,6 +5,9 @@
 package kbfscodec
 
 import (
+	"bytes"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,3 +33,61 @@ func TestCodecEncodeMap(t *testing.T) {
 
 	require.Equal(t, b1, b2)
 }
+
+// TestCodecReuse tests that the encoders and decoders reused across
+// calls don't leak state from one call into the next, or into the
+// results of earlier calls.
+func TestCodecReuse(t *testing.T) {
+	type testStruct struct {
+		A int
+		B string
+		C []byte
+	}
+
+	codec := NewMsgpack()
+
+	var encoded [][]byte
+	for i := 0; i < 20; i++ {
+		b, err := codec.Encode(testStruct{
+			A: i,
+			B: fmt.Sprintf("string %d", i),
+			C: bytes.Repeat([]byte{byte(i)}, i*100),
+		})
+		require.NoError(t, err)
+		encoded = append(encoded, b)
+	}
+
+	// A failed decode must not affect later ones.
+	var bad testStruct
+	err := codec.Decode([]byte{0xc1}, &bad)
+	require.Error(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(encoded))
+	for i, b := range encoded {
+		wg.Add(1)
+		go func(i int, b []byte) {
+			defer wg.Done()
+			var s testStruct
+			err := codec.Decode(b, &s)
+			if err != nil {
+				errs <- err
+				return
+			}
+			expected := testStruct{
+				A: i,
+				B: fmt.Sprintf("string %d", i),
+				C: bytes.Repeat([]byte{byte(i)}, i*100),
+			}
+			if s.A != expected.A || s.B != expected.B ||
+				!bytes.Equal(s.C, expected.C) {
+				errs <- fmt.Errorf("Decoded %d: %+v", i, s)
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
@@ -5,6 +5,8 @@
 package kbfscrypto
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding"
 	"encoding/hex"
 	"encoding/json"
@@ -583,6 +585,22 @@ func MakeRandomBlockCryptKeyServerHalf() (
 	return serverHalf, nil
 }
 
+// MakeConvergentBlockCryptKeyServerHalf derives the server-side of a
+// block crypt key from the TLF crypt key and the padded, encoded
+// block it will encrypt, so that identical blocks in a TLF get
+// identical keys.  The block server, which stores the server half,
+// doesn't know the TLF crypt key, so it learns nothing about the
+// block from it, besides which other blocks are identical to it.
+func MakeConvergentBlockCryptKeyServerHalf(
+	tlfCryptKey TLFCryptKey, paddedEncodedBlock []byte) BlockCryptKeyServerHalf {
+	mac := hmac.New(sha256.New, tlfCryptKey.data[:])
+	mac.Write([]byte("KBFS convergent block key"))
+	mac.Write(paddedEncodedBlock)
+	var data [32]byte
+	copy(data[:], mac.Sum(nil))
+	return MakeBlockCryptKeyServerHalf(data)
+}
+
 // ParseBlockCryptKeyServerHalf returns a BlockCryptKeyServerHalf
 // containing the given hex-encoded data, or an error.
 func ParseBlockCryptKeyServerHalf(s string) (BlockCryptKeyServerHalf, error) {
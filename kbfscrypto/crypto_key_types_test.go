@@ -310,6 +310,24 @@ func TestRandomBlockCryptKeyServerHalf(t *testing.T) {
 	require.NotEqual(t, k1, k2)
 }
 
+// Test that MakeConvergentBlockCryptKeyServerHalf() depends on both
+// the TLF crypt key and the block.
+func TestConvergentBlockCryptKeyServerHalf(t *testing.T) {
+	tlfKey1, err := MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+	tlfKey2, err := MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+
+	k := MakeConvergentBlockCryptKeyServerHalf(tlfKey1, []byte{1, 2})
+	require.NotEqual(t, BlockCryptKeyServerHalf{}, k)
+	require.Equal(t, k,
+		MakeConvergentBlockCryptKeyServerHalf(tlfKey1, []byte{1, 2}))
+	require.NotEqual(t, k,
+		MakeConvergentBlockCryptKeyServerHalf(tlfKey1, []byte{1, 3}))
+	require.NotEqual(t, k,
+		MakeConvergentBlockCryptKeyServerHalf(tlfKey2, []byte{1, 2}))
+}
+
 // Test that MaskTLFCryptKey() returns bytes that are different from
 // the server half and the key, and that UnmaskTLFCryptKey() undoes
 // the masking properly.
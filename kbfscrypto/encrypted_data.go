@@ -5,6 +5,8 @@
 package kbfscrypto
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"reflect"
@@ -67,13 +69,21 @@ func encryptData(data []byte, key [32]byte) (encryptedData, error) {
 		return encryptedData{}, err
 	}
 
+	return encryptDataWithNonce(data, key, nonce), nil
+}
+
+// encryptDataWithNonce encrypts the given data with the given
+// symmetric key and nonce.  A nonce must never be used twice with the
+// same key for different data.
+func encryptDataWithNonce(
+	data []byte, key [32]byte, nonce [24]byte) encryptedData {
 	sealedData := secretbox.Seal(nil, data, &nonce, &key)
 
 	return encryptedData{
 		Version:       EncryptionSecretbox,
 		Nonce:         nonce[:],
 		EncryptedData: sealedData,
-	}, nil
+	}
 }
 
 // decryptData decrypts the given encrypted data with the given
@@ -183,6 +193,25 @@ func EncryptPaddedEncodedBlock(paddedEncodedBlock []byte, key BlockCryptKey) (
 	return EncryptedBlock{encryptedData}, nil
 }
 
+// EncryptPaddedEncodedBlockConvergent is like
+// EncryptPaddedEncodedBlock, but derives the nonce from the key and
+// the block, so that the same block encrypted with the same key
+// always gives the same encrypted block.  The key should itself be
+// derived from the block, with
+// MakeConvergentBlockCryptKeyServerHalf, so that a nonce is only
+// ever reused for identical data.
+func EncryptPaddedEncodedBlockConvergent(
+	paddedEncodedBlock []byte, key BlockCryptKey) EncryptedBlock {
+	keyData := key.Data()
+	mac := hmac.New(sha256.New, keyData[:])
+	mac.Write([]byte("KBFS convergent block nonce"))
+	mac.Write(paddedEncodedBlock)
+	var nonce [24]byte
+	copy(nonce[:], mac.Sum(nil))
+	return EncryptedBlock{
+		encryptDataWithNonce(paddedEncodedBlock, keyData, nonce)}
+}
+
 // DecryptBlock decrypts a block, but does not unpad or decode it.
 func DecryptBlock(encryptedBlock EncryptedBlock, key BlockCryptKey) (
 	[]byte, error) {
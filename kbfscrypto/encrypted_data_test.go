@@ -65,6 +65,25 @@ func TestDecryptDataFailure(t *testing.T) {
 	assert.Equal(t, libkb.DecryptionError{}, errors.Cause(err))
 }
 
+// Test that EncryptPaddedEncodedBlockConvergent() always encrypts a
+// block the same way, and that the result can be decrypted.
+func TestEncryptPaddedEncodedBlockConvergent(t *testing.T) {
+	tlfCryptKey := MakeTLFCryptKey([32]byte{0x1})
+	block := []byte{0x20, 0x30}
+	serverHalf := MakeConvergentBlockCryptKeyServerHalf(tlfCryptKey, block)
+	key := UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
+
+	encryptedBlock := EncryptPaddedEncodedBlockConvergent(block, key)
+	require.Equal(t, encryptedBlock,
+		EncryptPaddedEncodedBlockConvergent(block, key))
+	require.NotEqual(t, encryptedBlock,
+		EncryptPaddedEncodedBlockConvergent([]byte{0x20, 0x31}, key))
+
+	decryptedBlock, err := DecryptBlock(encryptedBlock, key)
+	require.NoError(t, err)
+	require.Equal(t, block, decryptedBlock)
+}
+
 // Test that EncryptTLFCryptKeyClientHalf() encrypts its passed-in
 // client half properly.
 func TestCryptoCommonEncryptTLFCryptKeyClientHalf(t *testing.T) {
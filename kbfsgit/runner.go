@@ -1817,12 +1817,16 @@ func (r *runner) processCommand(
 			if len(cmdParts) == 0 {
 				if len(fetchBatch) > 0 {
 					if r.cloning {
+						ctx = libkbfs.CtxWithRequestSource(ctx,
+							libkbfs.RequestSource{Action: "git clone"})
 						r.log.CDebugf(ctx, "Processing clone")
 						err = r.handleClone(ctx)
 						if err != nil {
 							return err
 						}
 					} else {
+						ctx = libkbfs.CtxWithRequestSource(ctx,
+							libkbfs.RequestSource{Action: "git fetch"})
 						r.log.CDebugf(ctx, "Processing fetch batch")
 						err = r.handleFetchBatch(ctx, fetchBatch)
 						if err != nil {
@@ -1832,6 +1836,8 @@ func (r *runner) processCommand(
 					fetchBatch = nil
 					continue
 				} else if len(pushBatch) > 0 {
+					ctx = libkbfs.CtxWithRequestSource(ctx,
+						libkbfs.RequestSource{Action: "git push"})
 					r.log.CDebugf(ctx, "Processing push batch")
 					_, err = r.handlePushBatch(ctx, pushBatch)
 					if err != nil {
@@ -1849,6 +1855,8 @@ func (r *runner) processCommand(
 			case gitCmdCapabilities:
 				err = r.handleCapabilities()
 			case gitCmdList:
+				ctx = libkbfs.CtxWithRequestSource(ctx,
+					libkbfs.RequestSource{Action: "git list"})
 				err = r.handleList(ctx, cmdParts[1:])
 			case gitCmdFetch:
 				if len(pushBatch) > 0 {
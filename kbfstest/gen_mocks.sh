@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+mockgen -package="kbfstest" github.com/keybase/kbfs/libkbfs KBFSOps,MDOps,BlockOps,KBPKI,Crypto > mocks.go
+go fmt mocks.go
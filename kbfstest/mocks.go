@@ -0,0 +1,1777 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/keybase/kbfs/libkbfs (interfaces: KBFSOps,MDOps,BlockOps,KBPKI,Crypto)
+
+// Package kbfstest is a generated GoMock package.
+package kbfstest
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	libkb "github.com/keybase/client/go/libkb"
+	chat1 "github.com/keybase/client/go/protocol/chat1"
+	keybase1 "github.com/keybase/client/go/protocol/keybase1"
+	kbfsblock "github.com/keybase/kbfs/kbfsblock"
+	kbfscrypto "github.com/keybase/kbfs/kbfscrypto"
+	kbfsmd "github.com/keybase/kbfs/kbfsmd"
+	libkbfs "github.com/keybase/kbfs/libkbfs"
+	tlf "github.com/keybase/kbfs/tlf"
+	context "golang.org/x/net/context"
+	os "os"
+	reflect "reflect"
+	time "time"
+)
+
+// MockKBFSOps is a mock of KBFSOps interface
+type MockKBFSOps struct {
+	ctrl     *gomock.Controller
+	recorder *MockKBFSOpsMockRecorder
+}
+
+// MockKBFSOpsMockRecorder is the mock recorder for MockKBFSOps
+type MockKBFSOpsMockRecorder struct {
+	mock *MockKBFSOps
+}
+
+// NewMockKBFSOps creates a new mock instance
+func NewMockKBFSOps(ctrl *gomock.Controller) *MockKBFSOps {
+	mock := &MockKBFSOps{ctrl: ctrl}
+	mock.recorder = &MockKBFSOpsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockKBFSOps) EXPECT() *MockKBFSOpsMockRecorder {
+	return m.recorder
+}
+
+// GetFavorites mocks base method
+func (m *MockKBFSOps) GetFavorites(ctx context.Context) ([]libkbfs.Favorite, error) {
+	ret := m.ctrl.Call(m, "GetFavorites", ctx)
+	ret0, _ := ret[0].([]libkbfs.Favorite)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFavorites indicates an expected call of GetFavorites
+func (mr *MockKBFSOpsMockRecorder) GetFavorites(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFavorites", reflect.TypeOf((*MockKBFSOps)(nil).GetFavorites), ctx)
+}
+
+// RefreshCachedFavorites mocks base method
+func (m *MockKBFSOps) RefreshCachedFavorites(ctx context.Context) {
+	m.ctrl.Call(m, "RefreshCachedFavorites", ctx)
+}
+
+// RefreshCachedFavorites indicates an expected call of RefreshCachedFavorites
+func (mr *MockKBFSOpsMockRecorder) RefreshCachedFavorites(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshCachedFavorites", reflect.TypeOf((*MockKBFSOps)(nil).RefreshCachedFavorites), ctx)
+}
+
+// AddFavorite mocks base method
+func (m *MockKBFSOps) AddFavorite(ctx context.Context, fav libkbfs.Favorite) error {
+	ret := m.ctrl.Call(m, "AddFavorite", ctx, fav)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddFavorite indicates an expected call of AddFavorite
+func (mr *MockKBFSOpsMockRecorder) AddFavorite(ctx, fav interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddFavorite", reflect.TypeOf((*MockKBFSOps)(nil).AddFavorite), ctx, fav)
+}
+
+// DeleteFavorite mocks base method
+func (m *MockKBFSOps) DeleteFavorite(ctx context.Context, fav libkbfs.Favorite) error {
+	ret := m.ctrl.Call(m, "DeleteFavorite", ctx, fav)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteFavorite indicates an expected call of DeleteFavorite
+func (mr *MockKBFSOpsMockRecorder) DeleteFavorite(ctx, fav interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFavorite", reflect.TypeOf((*MockKBFSOps)(nil).DeleteFavorite), ctx, fav)
+}
+
+// GetTLFCryptKeys mocks base method
+func (m *MockKBFSOps) GetTLFCryptKeys(ctx context.Context, tlfHandle *libkbfs.TlfHandle) ([]kbfscrypto.TLFCryptKey, tlf.ID, error) {
+	ret := m.ctrl.Call(m, "GetTLFCryptKeys", ctx, tlfHandle)
+	ret0, _ := ret[0].([]kbfscrypto.TLFCryptKey)
+	ret1, _ := ret[1].(tlf.ID)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetTLFCryptKeys indicates an expected call of GetTLFCryptKeys
+func (mr *MockKBFSOpsMockRecorder) GetTLFCryptKeys(ctx, tlfHandle interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTLFCryptKeys", reflect.TypeOf((*MockKBFSOps)(nil).GetTLFCryptKeys), ctx, tlfHandle)
+}
+
+// GetTLFID mocks base method
+func (m *MockKBFSOps) GetTLFID(ctx context.Context, tlfHandle *libkbfs.TlfHandle) (tlf.ID, error) {
+	ret := m.ctrl.Call(m, "GetTLFID", ctx, tlfHandle)
+	ret0, _ := ret[0].(tlf.ID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTLFID indicates an expected call of GetTLFID
+func (mr *MockKBFSOpsMockRecorder) GetTLFID(ctx, tlfHandle interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTLFID", reflect.TypeOf((*MockKBFSOps)(nil).GetTLFID), ctx, tlfHandle)
+}
+
+// GetTLFHandle mocks base method
+func (m *MockKBFSOps) GetTLFHandle(ctx context.Context, node libkbfs.Node) (*libkbfs.TlfHandle, error) {
+	ret := m.ctrl.Call(m, "GetTLFHandle", ctx, node)
+	ret0, _ := ret[0].(*libkbfs.TlfHandle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTLFHandle indicates an expected call of GetTLFHandle
+func (mr *MockKBFSOpsMockRecorder) GetTLFHandle(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTLFHandle", reflect.TypeOf((*MockKBFSOps)(nil).GetTLFHandle), ctx, node)
+}
+
+// GetOrCreateRootNode mocks base method
+func (m *MockKBFSOps) GetOrCreateRootNode(ctx context.Context, h *libkbfs.TlfHandle, branch libkbfs.BranchName) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "GetOrCreateRootNode", ctx, h, branch)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetOrCreateRootNode indicates an expected call of GetOrCreateRootNode
+func (mr *MockKBFSOpsMockRecorder) GetOrCreateRootNode(ctx, h, branch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrCreateRootNode", reflect.TypeOf((*MockKBFSOps)(nil).GetOrCreateRootNode), ctx, h, branch)
+}
+
+// GetRootNode mocks base method
+func (m *MockKBFSOps) GetRootNode(ctx context.Context, h *libkbfs.TlfHandle, branch libkbfs.BranchName) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "GetRootNode", ctx, h, branch)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetRootNode indicates an expected call of GetRootNode
+func (mr *MockKBFSOpsMockRecorder) GetRootNode(ctx, h, branch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRootNode", reflect.TypeOf((*MockKBFSOps)(nil).GetRootNode), ctx, h, branch)
+}
+
+// GetDirChildren mocks base method
+func (m *MockKBFSOps) GetDirChildren(ctx context.Context, dir libkbfs.Node) (map[string]libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "GetDirChildren", ctx, dir)
+	ret0, _ := ret[0].(map[string]libkbfs.EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDirChildren indicates an expected call of GetDirChildren
+func (mr *MockKBFSOpsMockRecorder) GetDirChildren(ctx, dir interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirChildren", reflect.TypeOf((*MockKBFSOps)(nil).GetDirChildren), ctx, dir)
+}
+
+// Lookup mocks base method
+func (m *MockKBFSOps) Lookup(ctx context.Context, dir libkbfs.Node, name string) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "Lookup", ctx, dir, name)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Lookup indicates an expected call of Lookup
+func (mr *MockKBFSOpsMockRecorder) Lookup(ctx, dir, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockKBFSOps)(nil).Lookup), ctx, dir, name)
+}
+
+// Stat mocks base method
+func (m *MockKBFSOps) Stat(ctx context.Context, node libkbfs.Node) (libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "Stat", ctx, node)
+	ret0, _ := ret[0].(libkbfs.EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat
+func (mr *MockKBFSOpsMockRecorder) Stat(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockKBFSOps)(nil).Stat), ctx, node)
+}
+
+// GetNodeByPath mocks base method
+func (m *MockKBFSOps) GetNodeByPath(ctx context.Context, folderBranch libkbfs.FolderBranch, p string) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "GetNodeByPath", ctx, folderBranch, p)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetNodeByPath indicates an expected call of GetNodeByPath
+func (mr *MockKBFSOpsMockRecorder) GetNodeByPath(ctx, folderBranch, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeByPath", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeByPath), ctx, folderBranch, p)
+}
+
+// StatByPath mocks base method
+func (m *MockKBFSOps) StatByPath(ctx context.Context, folderBranch libkbfs.FolderBranch, p string) (libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "StatByPath", ctx, folderBranch, p)
+	ret0, _ := ret[0].(libkbfs.EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatByPath indicates an expected call of StatByPath
+func (mr *MockKBFSOpsMockRecorder) StatByPath(ctx, folderBranch, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatByPath", reflect.TypeOf((*MockKBFSOps)(nil).StatByPath), ctx, folderBranch, p)
+}
+
+// CreateDir mocks base method
+func (m *MockKBFSOps) CreateDir(ctx context.Context, dir libkbfs.Node, name string) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CreateDir", ctx, dir, name)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateDir indicates an expected call of CreateDir
+func (mr *MockKBFSOpsMockRecorder) CreateDir(ctx, dir, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDir", reflect.TypeOf((*MockKBFSOps)(nil).CreateDir), ctx, dir, name)
+}
+
+// CreateFile mocks base method
+func (m *MockKBFSOps) CreateFile(ctx context.Context, dir libkbfs.Node, name string, isExec bool, excl libkbfs.Excl) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CreateFile", ctx, dir, name, isExec, excl)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateFile indicates an expected call of CreateFile
+func (mr *MockKBFSOpsMockRecorder) CreateFile(ctx, dir, name, isExec, excl interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateFile", reflect.TypeOf((*MockKBFSOps)(nil).CreateFile), ctx, dir, name, isExec, excl)
+}
+
+// CreateLink mocks base method
+func (m *MockKBFSOps) CreateLink(ctx context.Context, dir libkbfs.Node, fromName, toPath string) (libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CreateLink", ctx, dir, fromName, toPath)
+	ret0, _ := ret[0].(libkbfs.EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateLink indicates an expected call of CreateLink
+func (mr *MockKBFSOpsMockRecorder) CreateLink(ctx, dir, fromName, toPath interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLink", reflect.TypeOf((*MockKBFSOps)(nil).CreateLink), ctx, dir, fromName, toPath)
+}
+
+// RemoveDir mocks base method
+func (m *MockKBFSOps) RemoveDir(ctx context.Context, dir libkbfs.Node, dirName string) error {
+	ret := m.ctrl.Call(m, "RemoveDir", ctx, dir, dirName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveDir indicates an expected call of RemoveDir
+func (mr *MockKBFSOpsMockRecorder) RemoveDir(ctx, dir, dirName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveDir", reflect.TypeOf((*MockKBFSOps)(nil).RemoveDir), ctx, dir, dirName)
+}
+
+// RemoveEntry mocks base method
+func (m *MockKBFSOps) RemoveEntry(ctx context.Context, dir libkbfs.Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveEntry", ctx, dir, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveEntry indicates an expected call of RemoveEntry
+func (mr *MockKBFSOpsMockRecorder) RemoveEntry(ctx, dir, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveEntry", reflect.TypeOf((*MockKBFSOps)(nil).RemoveEntry), ctx, dir, name)
+}
+
+// RemoveAll mocks base method
+func (m *MockKBFSOps) RemoveAll(ctx context.Context, dir libkbfs.Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveAll", ctx, dir, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAll indicates an expected call of RemoveAll
+func (mr *MockKBFSOpsMockRecorder) RemoveAll(ctx, dir, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAll", reflect.TypeOf((*MockKBFSOps)(nil).RemoveAll), ctx, dir, name)
+}
+
+// CopyFile mocks base method
+func (m *MockKBFSOps) CopyFile(ctx context.Context, srcNode libkbfs.Node, dstDir libkbfs.Node, dstName string) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CopyFile", ctx, srcNode, dstDir, dstName)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CopyFile indicates an expected call of CopyFile
+func (mr *MockKBFSOpsMockRecorder) CopyFile(ctx, srcNode, dstDir, dstName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFile", reflect.TypeOf((*MockKBFSOps)(nil).CopyFile), ctx, srcNode, dstDir, dstName)
+}
+
+// Rename mocks base method
+func (m *MockKBFSOps) Rename(ctx context.Context, oldParent libkbfs.Node, oldName string, newParent libkbfs.Node, newName string) error {
+	ret := m.ctrl.Call(m, "Rename", ctx, oldParent, oldName, newParent, newName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rename indicates an expected call of Rename
+func (mr *MockKBFSOpsMockRecorder) Rename(ctx, oldParent, oldName, newParent, newName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockKBFSOps)(nil).Rename), ctx, oldParent, oldName, newParent, newName)
+}
+
+// RenameWithFlags mocks base method
+func (m *MockKBFSOps) RenameWithFlags(ctx context.Context, oldParent libkbfs.Node, oldName string, newParent libkbfs.Node, newName string, flags libkbfs.RenameFlags) error {
+	ret := m.ctrl.Call(m, "RenameWithFlags", ctx, oldParent, oldName, newParent, newName, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameWithFlags indicates an expected call of RenameWithFlags
+func (mr *MockKBFSOpsMockRecorder) RenameWithFlags(ctx, oldParent, oldName, newParent, newName, flags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameWithFlags", reflect.TypeOf((*MockKBFSOps)(nil).RenameWithFlags), ctx, oldParent, oldName, newParent, newName, flags)
+}
+
+// RenameExchange mocks base method
+func (m *MockKBFSOps) RenameExchange(ctx context.Context, oldParent libkbfs.Node, oldName string, newParent libkbfs.Node, newName string) error {
+	ret := m.ctrl.Call(m, "RenameExchange", ctx, oldParent, oldName, newParent, newName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameExchange indicates an expected call of RenameExchange
+func (mr *MockKBFSOpsMockRecorder) RenameExchange(ctx, oldParent, oldName, newParent, newName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameExchange", reflect.TypeOf((*MockKBFSOps)(nil).RenameExchange), ctx, oldParent, oldName, newParent, newName)
+}
+
+// Read mocks base method
+func (m *MockKBFSOps) Read(ctx context.Context, file libkbfs.Node, dest []byte, off int64) (int64, error) {
+	ret := m.ctrl.Call(m, "Read", ctx, file, dest, off)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read
+func (mr *MockKBFSOpsMockRecorder) Read(ctx, file, dest, off interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockKBFSOps)(nil).Read), ctx, file, dest, off)
+}
+
+// Write mocks base method
+func (m *MockKBFSOps) Write(ctx context.Context, file libkbfs.Node, data []byte, off int64) error {
+	ret := m.ctrl.Call(m, "Write", ctx, file, data, off)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Write indicates an expected call of Write
+func (mr *MockKBFSOpsMockRecorder) Write(ctx, file, data, off interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockKBFSOps)(nil).Write), ctx, file, data, off)
+}
+
+// WriteWithFlags mocks base method
+func (m *MockKBFSOps) WriteWithFlags(ctx context.Context, file libkbfs.Node, data []byte, off int64, flags libkbfs.WriteFlags) (int64, error) {
+	ret := m.ctrl.Call(m, "WriteWithFlags", ctx, file, data, off, flags)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WriteWithFlags indicates an expected call of WriteWithFlags
+func (mr *MockKBFSOpsMockRecorder) WriteWithFlags(ctx, file, data, off, flags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteWithFlags", reflect.TypeOf((*MockKBFSOps)(nil).WriteWithFlags), ctx, file, data, off, flags)
+}
+
+// Truncate mocks base method
+func (m *MockKBFSOps) Truncate(ctx context.Context, file libkbfs.Node, size uint64) error {
+	ret := m.ctrl.Call(m, "Truncate", ctx, file, size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Truncate indicates an expected call of Truncate
+func (mr *MockKBFSOpsMockRecorder) Truncate(ctx, file, size interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Truncate", reflect.TypeOf((*MockKBFSOps)(nil).Truncate), ctx, file, size)
+}
+
+// SetEx mocks base method
+func (m *MockKBFSOps) SetEx(ctx context.Context, file libkbfs.Node, ex bool) error {
+	ret := m.ctrl.Call(m, "SetEx", ctx, file, ex)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEx indicates an expected call of SetEx
+func (mr *MockKBFSOpsMockRecorder) SetEx(ctx, file, ex interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEx", reflect.TypeOf((*MockKBFSOps)(nil).SetEx), ctx, file, ex)
+}
+
+// SetMode mocks base method
+func (m *MockKBFSOps) SetMode(ctx context.Context, node libkbfs.Node, mode os.FileMode) error {
+	ret := m.ctrl.Call(m, "SetMode", ctx, node, mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMode indicates an expected call of SetMode
+func (mr *MockKBFSOpsMockRecorder) SetMode(ctx, node, mode interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMode", reflect.TypeOf((*MockKBFSOps)(nil).SetMode), ctx, node, mode)
+}
+
+// SetMtime mocks base method
+func (m *MockKBFSOps) SetMtime(ctx context.Context, file libkbfs.Node, mtime *time.Time) error {
+	ret := m.ctrl.Call(m, "SetMtime", ctx, file, mtime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMtime indicates an expected call of SetMtime
+func (mr *MockKBFSOpsMockRecorder) SetMtime(ctx, file, mtime interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMtime", reflect.TypeOf((*MockKBFSOps)(nil).SetMtime), ctx, file, mtime)
+}
+
+// GetXattr mocks base method
+func (m *MockKBFSOps) GetXattr(ctx context.Context, node libkbfs.Node, name string) ([]byte, error) {
+	ret := m.ctrl.Call(m, "GetXattr", ctx, node, name)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetXattr indicates an expected call of GetXattr
+func (mr *MockKBFSOpsMockRecorder) GetXattr(ctx, node, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetXattr", reflect.TypeOf((*MockKBFSOps)(nil).GetXattr), ctx, node, name)
+}
+
+// ListXattr mocks base method
+func (m *MockKBFSOps) ListXattr(ctx context.Context, node libkbfs.Node) ([]string, error) {
+	ret := m.ctrl.Call(m, "ListXattr", ctx, node)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListXattr indicates an expected call of ListXattr
+func (mr *MockKBFSOpsMockRecorder) ListXattr(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListXattr", reflect.TypeOf((*MockKBFSOps)(nil).ListXattr), ctx, node)
+}
+
+// SetXattr mocks base method
+func (m *MockKBFSOps) SetXattr(ctx context.Context, node libkbfs.Node, name string, value []byte) error {
+	ret := m.ctrl.Call(m, "SetXattr", ctx, node, name, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetXattr indicates an expected call of SetXattr
+func (mr *MockKBFSOpsMockRecorder) SetXattr(ctx, node, name, value interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetXattr", reflect.TypeOf((*MockKBFSOps)(nil).SetXattr), ctx, node, name, value)
+}
+
+// RemoveXattr mocks base method
+func (m *MockKBFSOps) RemoveXattr(ctx context.Context, node libkbfs.Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveXattr", ctx, node, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveXattr indicates an expected call of RemoveXattr
+func (mr *MockKBFSOpsMockRecorder) RemoveXattr(ctx, node, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveXattr", reflect.TypeOf((*MockKBFSOps)(nil).RemoveXattr), ctx, node, name)
+}
+
+// SyncAll mocks base method
+func (m *MockKBFSOps) SyncAll(ctx context.Context, folderBranch libkbfs.FolderBranch) error {
+	ret := m.ctrl.Call(m, "SyncAll", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SyncAll indicates an expected call of SyncAll
+func (mr *MockKBFSOpsMockRecorder) SyncAll(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncAll", reflect.TypeOf((*MockKBFSOps)(nil).SyncAll), ctx, folderBranch)
+}
+
+// FolderStatus mocks base method
+func (m *MockKBFSOps) FolderStatus(ctx context.Context, folderBranch libkbfs.FolderBranch) (libkbfs.FolderBranchStatus, <-chan libkbfs.StatusUpdate, error) {
+	ret := m.ctrl.Call(m, "FolderStatus", ctx, folderBranch)
+	ret0, _ := ret[0].(libkbfs.FolderBranchStatus)
+	ret1, _ := ret[1].(<-chan libkbfs.StatusUpdate)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// FolderStatus indicates an expected call of FolderStatus
+func (mr *MockKBFSOpsMockRecorder) FolderStatus(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FolderStatus", reflect.TypeOf((*MockKBFSOps)(nil).FolderStatus), ctx, folderBranch)
+}
+
+// ClearFolderErrors mocks base method
+func (m *MockKBFSOps) ClearFolderErrors(ctx context.Context, folderBranch libkbfs.FolderBranch) error {
+	ret := m.ctrl.Call(m, "ClearFolderErrors", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearFolderErrors indicates an expected call of ClearFolderErrors
+func (mr *MockKBFSOpsMockRecorder) ClearFolderErrors(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearFolderErrors", reflect.TypeOf((*MockKBFSOps)(nil).ClearFolderErrors), ctx, folderBranch)
+}
+
+// GetQuotaInfo mocks base method
+func (m *MockKBFSOps) GetQuotaInfo(ctx context.Context) (*kbfsblock.QuotaInfo, error) {
+	ret := m.ctrl.Call(m, "GetQuotaInfo", ctx)
+	ret0, _ := ret[0].(*kbfsblock.QuotaInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetQuotaInfo indicates an expected call of GetQuotaInfo
+func (mr *MockKBFSOpsMockRecorder) GetQuotaInfo(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQuotaInfo", reflect.TypeOf((*MockKBFSOps)(nil).GetQuotaInfo), ctx)
+}
+
+// Status mocks base method
+func (m *MockKBFSOps) Status(ctx context.Context) (libkbfs.KBFSStatus, <-chan libkbfs.StatusUpdate, error) {
+	ret := m.ctrl.Call(m, "Status", ctx)
+	ret0, _ := ret[0].(libkbfs.KBFSStatus)
+	ret1, _ := ret[1].(<-chan libkbfs.StatusUpdate)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Status indicates an expected call of Status
+func (mr *MockKBFSOpsMockRecorder) Status(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockKBFSOps)(nil).Status), ctx)
+}
+
+// UnstageForTesting mocks base method
+func (m *MockKBFSOps) UnstageForTesting(ctx context.Context, folderBranch libkbfs.FolderBranch) error {
+	ret := m.ctrl.Call(m, "UnstageForTesting", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnstageForTesting indicates an expected call of UnstageForTesting
+func (mr *MockKBFSOpsMockRecorder) UnstageForTesting(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnstageForTesting", reflect.TypeOf((*MockKBFSOps)(nil).UnstageForTesting), ctx, folderBranch)
+}
+
+// GetUnmergedChanges mocks base method
+func (m *MockKBFSOps) GetUnmergedChanges(ctx context.Context, folderBranch libkbfs.FolderBranch) (libkbfs.UnmergedChanges, error) {
+	ret := m.ctrl.Call(m, "GetUnmergedChanges", ctx, folderBranch)
+	ret0, _ := ret[0].(libkbfs.UnmergedChanges)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnmergedChanges indicates an expected call of GetUnmergedChanges
+func (mr *MockKBFSOpsMockRecorder) GetUnmergedChanges(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnmergedChanges", reflect.TypeOf((*MockKBFSOps)(nil).GetUnmergedChanges), ctx, folderBranch)
+}
+
+// Unstage mocks base method
+func (m *MockKBFSOps) Unstage(ctx context.Context, folderBranch libkbfs.FolderBranch, expected libkbfs.UnmergedChanges) error {
+	ret := m.ctrl.Call(m, "Unstage", ctx, folderBranch, expected)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unstage indicates an expected call of Unstage
+func (mr *MockKBFSOpsMockRecorder) Unstage(ctx, folderBranch, expected interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unstage", reflect.TypeOf((*MockKBFSOps)(nil).Unstage), ctx, folderBranch, expected)
+}
+
+// RequestRekey mocks base method
+func (m *MockKBFSOps) RequestRekey(ctx context.Context, id tlf.ID) {
+	m.ctrl.Call(m, "RequestRekey", ctx, id)
+}
+
+// RequestRekey indicates an expected call of RequestRekey
+func (mr *MockKBFSOpsMockRecorder) RequestRekey(ctx, id interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestRekey", reflect.TypeOf((*MockKBFSOps)(nil).RequestRekey), ctx, id)
+}
+
+// SyncFromServer mocks base method
+func (m *MockKBFSOps) SyncFromServer(ctx context.Context, folderBranch libkbfs.FolderBranch, lockBeforeGet *keybase1.LockID) error {
+	ret := m.ctrl.Call(m, "SyncFromServer", ctx, folderBranch, lockBeforeGet)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SyncFromServer indicates an expected call of SyncFromServer
+func (mr *MockKBFSOpsMockRecorder) SyncFromServer(ctx, folderBranch, lockBeforeGet interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncFromServer", reflect.TypeOf((*MockKBFSOps)(nil).SyncFromServer), ctx, folderBranch, lockBeforeGet)
+}
+
+// GetUpdateHistory mocks base method
+func (m *MockKBFSOps) GetUpdateHistory(ctx context.Context, folderBranch libkbfs.FolderBranch) (libkbfs.TLFUpdateHistory, error) {
+	ret := m.ctrl.Call(m, "GetUpdateHistory", ctx, folderBranch)
+	ret0, _ := ret[0].(libkbfs.TLFUpdateHistory)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpdateHistory indicates an expected call of GetUpdateHistory
+func (mr *MockKBFSOpsMockRecorder) GetUpdateHistory(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpdateHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetUpdateHistory), ctx, folderBranch)
+}
+
+// GetEditHistory mocks base method
+func (m *MockKBFSOps) GetEditHistory(ctx context.Context, folderBranch libkbfs.FolderBranch) (libkbfs.TlfWriterEdits, error) {
+	ret := m.ctrl.Call(m, "GetEditHistory", ctx, folderBranch)
+	ret0, _ := ret[0].(libkbfs.TlfWriterEdits)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEditHistory indicates an expected call of GetEditHistory
+func (mr *MockKBFSOpsMockRecorder) GetEditHistory(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEditHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetEditHistory), ctx, folderBranch)
+}
+
+// GetUsageBreakdown mocks base method
+func (m *MockKBFSOps) GetUsageBreakdown(ctx context.Context, folderBranch libkbfs.FolderBranch) (libkbfs.UsageBreakdown, error) {
+	ret := m.ctrl.Call(m, "GetUsageBreakdown", ctx, folderBranch)
+	ret0, _ := ret[0].(libkbfs.UsageBreakdown)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsageBreakdown indicates an expected call of GetUsageBreakdown
+func (mr *MockKBFSOpsMockRecorder) GetUsageBreakdown(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsageBreakdown", reflect.TypeOf((*MockKBFSOps)(nil).GetUsageBreakdown), ctx, folderBranch)
+}
+
+// SetAdvisoryLock mocks base method
+func (m *MockKBFSOps) SetAdvisoryLock(ctx context.Context, file libkbfs.Node, lock libkbfs.AdvisoryLock, wait bool) error {
+	ret := m.ctrl.Call(m, "SetAdvisoryLock", ctx, file, lock, wait)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAdvisoryLock indicates an expected call of SetAdvisoryLock
+func (mr *MockKBFSOpsMockRecorder) SetAdvisoryLock(ctx, file, lock, wait interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAdvisoryLock", reflect.TypeOf((*MockKBFSOps)(nil).SetAdvisoryLock), ctx, file, lock, wait)
+}
+
+// GetAdvisoryLock mocks base method
+func (m *MockKBFSOps) GetAdvisoryLock(ctx context.Context, file libkbfs.Node, lock libkbfs.AdvisoryLock) (libkbfs.AdvisoryLock, error) {
+	ret := m.ctrl.Call(m, "GetAdvisoryLock", ctx, file, lock)
+	ret0, _ := ret[0].(libkbfs.AdvisoryLock)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAdvisoryLock indicates an expected call of GetAdvisoryLock
+func (mr *MockKBFSOpsMockRecorder) GetAdvisoryLock(ctx, file, lock interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAdvisoryLock", reflect.TypeOf((*MockKBFSOps)(nil).GetAdvisoryLock), ctx, file, lock)
+}
+
+// CreateTempFile mocks base method
+func (m *MockKBFSOps) CreateTempFile(ctx context.Context, dir libkbfs.Node, isExec bool) (libkbfs.Node, libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CreateTempFile", ctx, dir, isExec)
+	ret0, _ := ret[0].(libkbfs.Node)
+	ret1, _ := ret[1].(libkbfs.EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateTempFile indicates an expected call of CreateTempFile
+func (mr *MockKBFSOpsMockRecorder) CreateTempFile(ctx, dir, isExec interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTempFile", reflect.TypeOf((*MockKBFSOps)(nil).CreateTempFile), ctx, dir, isExec)
+}
+
+// LinkTempFile mocks base method
+func (m *MockKBFSOps) LinkTempFile(ctx context.Context, dir libkbfs.Node, name string, file libkbfs.Node) (libkbfs.EntryInfo, error) {
+	ret := m.ctrl.Call(m, "LinkTempFile", ctx, dir, name, file)
+	ret0, _ := ret[0].(libkbfs.EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LinkTempFile indicates an expected call of LinkTempFile
+func (mr *MockKBFSOpsMockRecorder) LinkTempFile(ctx, dir, name, file interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkTempFile", reflect.TypeOf((*MockKBFSOps)(nil).LinkTempFile), ctx, dir, name, file)
+}
+
+// GetDirChildrenPaged mocks base method
+func (m *MockKBFSOps) GetDirChildrenPaged(ctx context.Context, dir libkbfs.Node, cursor string, maxEntries int) ([]libkbfs.DirChild, string, error) {
+	ret := m.ctrl.Call(m, "GetDirChildrenPaged", ctx, dir, cursor, maxEntries)
+	ret0, _ := ret[0].([]libkbfs.DirChild)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetDirChildrenPaged indicates an expected call of GetDirChildrenPaged
+func (mr *MockKBFSOpsMockRecorder) GetDirChildrenPaged(ctx, dir, cursor, maxEntries interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirChildrenPaged", reflect.TypeOf((*MockKBFSOps)(nil).GetDirChildrenPaged), ctx, dir, cursor, maxEntries)
+}
+
+// GetSubtreeUsage mocks base method
+func (m *MockKBFSOps) GetSubtreeUsage(ctx context.Context, node libkbfs.Node) (libkbfs.SubtreeUsage, error) {
+	ret := m.ctrl.Call(m, "GetSubtreeUsage", ctx, node)
+	ret0, _ := ret[0].(libkbfs.SubtreeUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubtreeUsage indicates an expected call of GetSubtreeUsage
+func (mr *MockKBFSOpsMockRecorder) GetSubtreeUsage(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubtreeUsage", reflect.TypeOf((*MockKBFSOps)(nil).GetSubtreeUsage), ctx, node)
+}
+
+// GetNodeMetadata mocks base method
+func (m *MockKBFSOps) GetNodeMetadata(ctx context.Context, node libkbfs.Node) (libkbfs.NodeMetadata, error) {
+	ret := m.ctrl.Call(m, "GetNodeMetadata", ctx, node)
+	ret0, _ := ret[0].(libkbfs.NodeMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNodeMetadata indicates an expected call of GetNodeMetadata
+func (mr *MockKBFSOpsMockRecorder) GetNodeMetadata(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeMetadata", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeMetadata), ctx, node)
+}
+
+// GetNodeHistory mocks base method
+func (m *MockKBFSOps) GetNodeHistory(ctx context.Context, node libkbfs.Node) ([]libkbfs.NodeRevision, error) {
+	ret := m.ctrl.Call(m, "GetNodeHistory", ctx, node)
+	ret0, _ := ret[0].([]libkbfs.NodeRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNodeHistory indicates an expected call of GetNodeHistory
+func (mr *MockKBFSOpsMockRecorder) GetNodeHistory(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeHistory), ctx, node)
+}
+
+// ReadAtRevision mocks base method
+func (m *MockKBFSOps) ReadAtRevision(ctx context.Context, file libkbfs.Node, rev kbfsmd.Revision, dest []byte, off int64) (int64, error) {
+	ret := m.ctrl.Call(m, "ReadAtRevision", ctx, file, rev, dest, off)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAtRevision indicates an expected call of ReadAtRevision
+func (mr *MockKBFSOpsMockRecorder) ReadAtRevision(ctx, file, rev, dest, off interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAtRevision", reflect.TypeOf((*MockKBFSOps)(nil).ReadAtRevision), ctx, file, rev, dest, off)
+}
+
+// Shutdown mocks base method
+func (m *MockKBFSOps) Shutdown(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "Shutdown", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Shutdown indicates an expected call of Shutdown
+func (mr *MockKBFSOpsMockRecorder) Shutdown(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockKBFSOps)(nil).Shutdown), ctx)
+}
+
+// PushConnectionStatusChange mocks base method
+func (m *MockKBFSOps) PushConnectionStatusChange(service string, newStatus error) {
+	m.ctrl.Call(m, "PushConnectionStatusChange", service, newStatus)
+}
+
+// PushConnectionStatusChange indicates an expected call of PushConnectionStatusChange
+func (mr *MockKBFSOpsMockRecorder) PushConnectionStatusChange(service, newStatus interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PushConnectionStatusChange", reflect.TypeOf((*MockKBFSOps)(nil).PushConnectionStatusChange), service, newStatus)
+}
+
+// PushStatusChange mocks base method
+func (m *MockKBFSOps) PushStatusChange() {
+	m.ctrl.Call(m, "PushStatusChange")
+}
+
+// PushStatusChange indicates an expected call of PushStatusChange
+func (mr *MockKBFSOpsMockRecorder) PushStatusChange() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PushStatusChange", reflect.TypeOf((*MockKBFSOps)(nil).PushStatusChange))
+}
+
+// ClearPrivateFolderMD mocks base method
+func (m *MockKBFSOps) ClearPrivateFolderMD(ctx context.Context) {
+	m.ctrl.Call(m, "ClearPrivateFolderMD", ctx)
+}
+
+// ClearPrivateFolderMD indicates an expected call of ClearPrivateFolderMD
+func (mr *MockKBFSOpsMockRecorder) ClearPrivateFolderMD(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearPrivateFolderMD", reflect.TypeOf((*MockKBFSOps)(nil).ClearPrivateFolderMD), ctx)
+}
+
+// ResetForUser mocks base method
+func (m *MockKBFSOps) ResetForUser(ctx context.Context, uid keybase1.UID) bool {
+	ret := m.ctrl.Call(m, "ResetForUser", ctx, uid)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// ResetForUser indicates an expected call of ResetForUser
+func (mr *MockKBFSOpsMockRecorder) ResetForUser(ctx, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetForUser", reflect.TypeOf((*MockKBFSOps)(nil).ResetForUser), ctx, uid)
+}
+
+// AuditLocalPlaintext mocks base method
+func (m *MockKBFSOps) AuditLocalPlaintext(ctx context.Context) ([]libkbfs.LocalPlaintextLeak, error) {
+	ret := m.ctrl.Call(m, "AuditLocalPlaintext", ctx)
+	ret0, _ := ret[0].([]libkbfs.LocalPlaintextLeak)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuditLocalPlaintext indicates an expected call of AuditLocalPlaintext
+func (mr *MockKBFSOpsMockRecorder) AuditLocalPlaintext(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditLocalPlaintext", reflect.TypeOf((*MockKBFSOps)(nil).AuditLocalPlaintext), ctx)
+}
+
+// ForceFastForward mocks base method
+func (m *MockKBFSOps) ForceFastForward(ctx context.Context) {
+	m.ctrl.Call(m, "ForceFastForward", ctx)
+}
+
+// ForceFastForward indicates an expected call of ForceFastForward
+func (mr *MockKBFSOpsMockRecorder) ForceFastForward(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceFastForward", reflect.TypeOf((*MockKBFSOps)(nil).ForceFastForward), ctx)
+}
+
+// TeamNameChanged mocks base method
+func (m *MockKBFSOps) TeamNameChanged(ctx context.Context, tid keybase1.TeamID) {
+	m.ctrl.Call(m, "TeamNameChanged", ctx, tid)
+}
+
+// TeamNameChanged indicates an expected call of TeamNameChanged
+func (mr *MockKBFSOpsMockRecorder) TeamNameChanged(ctx, tid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TeamNameChanged", reflect.TypeOf((*MockKBFSOps)(nil).TeamNameChanged), ctx, tid)
+}
+
+// TeamAbandoned mocks base method
+func (m *MockKBFSOps) TeamAbandoned(ctx context.Context, tid keybase1.TeamID) {
+	m.ctrl.Call(m, "TeamAbandoned", ctx, tid)
+}
+
+// TeamAbandoned indicates an expected call of TeamAbandoned
+func (mr *MockKBFSOpsMockRecorder) TeamAbandoned(ctx, tid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TeamAbandoned", reflect.TypeOf((*MockKBFSOps)(nil).TeamAbandoned), ctx, tid)
+}
+
+// MigrateToImplicitTeam mocks base method
+func (m *MockKBFSOps) MigrateToImplicitTeam(ctx context.Context, id tlf.ID) error {
+	ret := m.ctrl.Call(m, "MigrateToImplicitTeam", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MigrateToImplicitTeam indicates an expected call of MigrateToImplicitTeam
+func (mr *MockKBFSOpsMockRecorder) MigrateToImplicitTeam(ctx, id interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigrateToImplicitTeam", reflect.TypeOf((*MockKBFSOps)(nil).MigrateToImplicitTeam), ctx, id)
+}
+
+// KickoffAllOutstandingRekeys mocks base method
+func (m *MockKBFSOps) KickoffAllOutstandingRekeys() error {
+	ret := m.ctrl.Call(m, "KickoffAllOutstandingRekeys")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// KickoffAllOutstandingRekeys indicates an expected call of KickoffAllOutstandingRekeys
+func (mr *MockKBFSOpsMockRecorder) KickoffAllOutstandingRekeys() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "KickoffAllOutstandingRekeys", reflect.TypeOf((*MockKBFSOps)(nil).KickoffAllOutstandingRekeys))
+}
+
+// NewNotificationChannel mocks base method
+func (m *MockKBFSOps) NewNotificationChannel(ctx context.Context, handle *libkbfs.TlfHandle, convID chat1.ConversationID, channelName string) {
+	m.ctrl.Call(m, "NewNotificationChannel", ctx, handle, convID, channelName)
+}
+
+// NewNotificationChannel indicates an expected call of NewNotificationChannel
+func (mr *MockKBFSOpsMockRecorder) NewNotificationChannel(ctx, handle, convID, channelName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewNotificationChannel", reflect.TypeOf((*MockKBFSOps)(nil).NewNotificationChannel), ctx, handle, convID, channelName)
+}
+
+// MockMDOps is a mock of MDOps interface
+type MockMDOps struct {
+	ctrl     *gomock.Controller
+	recorder *MockMDOpsMockRecorder
+}
+
+// MockMDOpsMockRecorder is the mock recorder for MockMDOps
+type MockMDOpsMockRecorder struct {
+	mock *MockMDOps
+}
+
+// NewMockMDOps creates a new mock instance
+func NewMockMDOps(ctrl *gomock.Controller) *MockMDOps {
+	mock := &MockMDOps{ctrl: ctrl}
+	mock.recorder = &MockMDOpsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockMDOps) EXPECT() *MockMDOpsMockRecorder {
+	return m.recorder
+}
+
+// GetIDForHandle mocks base method
+func (m *MockMDOps) GetIDForHandle(ctx context.Context, handle *libkbfs.TlfHandle) (tlf.ID, error) {
+	ret := m.ctrl.Call(m, "GetIDForHandle", ctx, handle)
+	ret0, _ := ret[0].(tlf.ID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIDForHandle indicates an expected call of GetIDForHandle
+func (mr *MockMDOpsMockRecorder) GetIDForHandle(ctx, handle interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIDForHandle", reflect.TypeOf((*MockMDOps)(nil).GetIDForHandle), ctx, handle)
+}
+
+// ValidateLatestHandleNotFinal mocks base method
+func (m *MockMDOps) ValidateLatestHandleNotFinal(ctx context.Context, h *libkbfs.TlfHandle) (bool, error) {
+	ret := m.ctrl.Call(m, "ValidateLatestHandleNotFinal", ctx, h)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ValidateLatestHandleNotFinal indicates an expected call of ValidateLatestHandleNotFinal
+func (mr *MockMDOpsMockRecorder) ValidateLatestHandleNotFinal(ctx, h interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateLatestHandleNotFinal", reflect.TypeOf((*MockMDOps)(nil).ValidateLatestHandleNotFinal), ctx, h)
+}
+
+// GetForTLF mocks base method
+func (m *MockMDOps) GetForTLF(ctx context.Context, id tlf.ID, lockBeforeGet *keybase1.LockID) (libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "GetForTLF", ctx, id, lockBeforeGet)
+	ret0, _ := ret[0].(libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetForTLF indicates an expected call of GetForTLF
+func (mr *MockMDOpsMockRecorder) GetForTLF(ctx, id, lockBeforeGet interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForTLF", reflect.TypeOf((*MockMDOps)(nil).GetForTLF), ctx, id, lockBeforeGet)
+}
+
+// GetUnmergedForTLF mocks base method
+func (m *MockMDOps) GetUnmergedForTLF(ctx context.Context, id tlf.ID, bid kbfsmd.BranchID) (libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "GetUnmergedForTLF", ctx, id, bid)
+	ret0, _ := ret[0].(libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnmergedForTLF indicates an expected call of GetUnmergedForTLF
+func (mr *MockMDOpsMockRecorder) GetUnmergedForTLF(ctx, id, bid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnmergedForTLF", reflect.TypeOf((*MockMDOps)(nil).GetUnmergedForTLF), ctx, id, bid)
+}
+
+// GetRange mocks base method
+func (m *MockMDOps) GetRange(ctx context.Context, id tlf.ID, start, stop kbfsmd.Revision, lockID *keybase1.LockID) ([]libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "GetRange", ctx, id, start, stop, lockID)
+	ret0, _ := ret[0].([]libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRange indicates an expected call of GetRange
+func (mr *MockMDOpsMockRecorder) GetRange(ctx, id, start, stop, lockID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRange", reflect.TypeOf((*MockMDOps)(nil).GetRange), ctx, id, start, stop, lockID)
+}
+
+// GetUnmergedRange mocks base method
+func (m *MockMDOps) GetUnmergedRange(ctx context.Context, id tlf.ID, bid kbfsmd.BranchID, start, stop kbfsmd.Revision) ([]libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "GetUnmergedRange", ctx, id, bid, start, stop)
+	ret0, _ := ret[0].([]libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnmergedRange indicates an expected call of GetUnmergedRange
+func (mr *MockMDOpsMockRecorder) GetUnmergedRange(ctx, id, bid, start, stop interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnmergedRange", reflect.TypeOf((*MockMDOps)(nil).GetUnmergedRange), ctx, id, bid, start, stop)
+}
+
+// Put mocks base method
+func (m *MockMDOps) Put(ctx context.Context, rmd *libkbfs.RootMetadata, verifyingKey kbfscrypto.VerifyingKey, lockContext *keybase1.LockContext, priority keybase1.MDPriority) (libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "Put", ctx, rmd, verifyingKey, lockContext, priority)
+	ret0, _ := ret[0].(libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Put indicates an expected call of Put
+func (mr *MockMDOpsMockRecorder) Put(ctx, rmd, verifyingKey, lockContext, priority interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockMDOps)(nil).Put), ctx, rmd, verifyingKey, lockContext, priority)
+}
+
+// PutUnmerged mocks base method
+func (m *MockMDOps) PutUnmerged(ctx context.Context, rmd *libkbfs.RootMetadata, verifyingKey kbfscrypto.VerifyingKey) (libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "PutUnmerged", ctx, rmd, verifyingKey)
+	ret0, _ := ret[0].(libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutUnmerged indicates an expected call of PutUnmerged
+func (mr *MockMDOpsMockRecorder) PutUnmerged(ctx, rmd, verifyingKey interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutUnmerged", reflect.TypeOf((*MockMDOps)(nil).PutUnmerged), ctx, rmd, verifyingKey)
+}
+
+// PruneBranch mocks base method
+func (m *MockMDOps) PruneBranch(ctx context.Context, id tlf.ID, bid kbfsmd.BranchID) error {
+	ret := m.ctrl.Call(m, "PruneBranch", ctx, id, bid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PruneBranch indicates an expected call of PruneBranch
+func (mr *MockMDOpsMockRecorder) PruneBranch(ctx, id, bid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneBranch", reflect.TypeOf((*MockMDOps)(nil).PruneBranch), ctx, id, bid)
+}
+
+// ResolveBranch mocks base method
+func (m *MockMDOps) ResolveBranch(ctx context.Context, id tlf.ID, bid kbfsmd.BranchID, blocksToDelete []kbfsblock.ID, rmd *libkbfs.RootMetadata, verifyingKey kbfscrypto.VerifyingKey) (libkbfs.ImmutableRootMetadata, error) {
+	ret := m.ctrl.Call(m, "ResolveBranch", ctx, id, bid, blocksToDelete, rmd, verifyingKey)
+	ret0, _ := ret[0].(libkbfs.ImmutableRootMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveBranch indicates an expected call of ResolveBranch
+func (mr *MockMDOpsMockRecorder) ResolveBranch(ctx, id, bid, blocksToDelete, rmd, verifyingKey interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveBranch", reflect.TypeOf((*MockMDOps)(nil).ResolveBranch), ctx, id, bid, blocksToDelete, rmd, verifyingKey)
+}
+
+// GetLatestHandleForTLF mocks base method
+func (m *MockMDOps) GetLatestHandleForTLF(ctx context.Context, id tlf.ID) (tlf.Handle, error) {
+	ret := m.ctrl.Call(m, "GetLatestHandleForTLF", ctx, id)
+	ret0, _ := ret[0].(tlf.Handle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLatestHandleForTLF indicates an expected call of GetLatestHandleForTLF
+func (mr *MockMDOpsMockRecorder) GetLatestHandleForTLF(ctx, id interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatestHandleForTLF", reflect.TypeOf((*MockMDOps)(nil).GetLatestHandleForTLF), ctx, id)
+}
+
+// MockBlockOps is a mock of BlockOps interface
+type MockBlockOps struct {
+	ctrl     *gomock.Controller
+	recorder *MockBlockOpsMockRecorder
+}
+
+// MockBlockOpsMockRecorder is the mock recorder for MockBlockOps
+type MockBlockOpsMockRecorder struct {
+	mock *MockBlockOps
+}
+
+// NewMockBlockOps creates a new mock instance
+func NewMockBlockOps(ctrl *gomock.Controller) *MockBlockOps {
+	mock := &MockBlockOps{ctrl: ctrl}
+	mock.recorder = &MockBlockOpsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockBlockOps) EXPECT() *MockBlockOpsMockRecorder {
+	return m.recorder
+}
+
+// BlockRetriever mocks base method
+func (m *MockBlockOps) BlockRetriever() libkbfs.BlockRetriever {
+	ret := m.ctrl.Call(m, "BlockRetriever")
+	ret0, _ := ret[0].(libkbfs.BlockRetriever)
+	return ret0
+}
+
+// BlockRetriever indicates an expected call of BlockRetriever
+func (mr *MockBlockOpsMockRecorder) BlockRetriever() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockRetriever", reflect.TypeOf((*MockBlockOps)(nil).BlockRetriever))
+}
+
+// Get mocks base method
+func (m *MockBlockOps) Get(ctx context.Context, kmd libkbfs.KeyMetadata, blockPtr libkbfs.BlockPointer, block libkbfs.Block, cacheLifetime libkbfs.BlockCacheLifetime) error {
+	ret := m.ctrl.Call(m, "Get", ctx, kmd, blockPtr, block, cacheLifetime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Get indicates an expected call of Get
+func (mr *MockBlockOpsMockRecorder) Get(ctx, kmd, blockPtr, block, cacheLifetime interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockBlockOps)(nil).Get), ctx, kmd, blockPtr, block, cacheLifetime)
+}
+
+// GetEncodedSize mocks base method
+func (m *MockBlockOps) GetEncodedSize(ctx context.Context, kmd libkbfs.KeyMetadata, blockPtr libkbfs.BlockPointer) (uint32, error) {
+	ret := m.ctrl.Call(m, "GetEncodedSize", ctx, kmd, blockPtr)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEncodedSize indicates an expected call of GetEncodedSize
+func (mr *MockBlockOpsMockRecorder) GetEncodedSize(ctx, kmd, blockPtr interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEncodedSize", reflect.TypeOf((*MockBlockOps)(nil).GetEncodedSize), ctx, kmd, blockPtr)
+}
+
+// Ready mocks base method
+func (m *MockBlockOps) Ready(ctx context.Context, kmd libkbfs.KeyMetadata, block libkbfs.Block) (kbfsblock.ID, int, libkbfs.ReadyBlockData, error) {
+	ret := m.ctrl.Call(m, "Ready", ctx, kmd, block)
+	ret0, _ := ret[0].(kbfsblock.ID)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(libkbfs.ReadyBlockData)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// Ready indicates an expected call of Ready
+func (mr *MockBlockOpsMockRecorder) Ready(ctx, kmd, block interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ready", reflect.TypeOf((*MockBlockOps)(nil).Ready), ctx, kmd, block)
+}
+
+// Delete mocks base method
+func (m *MockBlockOps) Delete(ctx context.Context, tlfID tlf.ID, ptrs []libkbfs.BlockPointer) (map[kbfsblock.ID]int, error) {
+	ret := m.ctrl.Call(m, "Delete", ctx, tlfID, ptrs)
+	ret0, _ := ret[0].(map[kbfsblock.ID]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockBlockOpsMockRecorder) Delete(ctx, tlfID, ptrs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockBlockOps)(nil).Delete), ctx, tlfID, ptrs)
+}
+
+// Archive mocks base method
+func (m *MockBlockOps) Archive(ctx context.Context, tlfID tlf.ID, ptrs []libkbfs.BlockPointer) error {
+	ret := m.ctrl.Call(m, "Archive", ctx, tlfID, ptrs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Archive indicates an expected call of Archive
+func (mr *MockBlockOpsMockRecorder) Archive(ctx, tlfID, ptrs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Archive", reflect.TypeOf((*MockBlockOps)(nil).Archive), ctx, tlfID, ptrs)
+}
+
+// TogglePrefetcher mocks base method
+func (m *MockBlockOps) TogglePrefetcher(enable bool) <-chan struct{} {
+	ret := m.ctrl.Call(m, "TogglePrefetcher", enable)
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// TogglePrefetcher indicates an expected call of TogglePrefetcher
+func (mr *MockBlockOpsMockRecorder) TogglePrefetcher(enable interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TogglePrefetcher", reflect.TypeOf((*MockBlockOps)(nil).TogglePrefetcher), enable)
+}
+
+// Prefetcher mocks base method
+func (m *MockBlockOps) Prefetcher() libkbfs.Prefetcher {
+	ret := m.ctrl.Call(m, "Prefetcher")
+	ret0, _ := ret[0].(libkbfs.Prefetcher)
+	return ret0
+}
+
+// Prefetcher indicates an expected call of Prefetcher
+func (mr *MockBlockOpsMockRecorder) Prefetcher() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Prefetcher", reflect.TypeOf((*MockBlockOps)(nil).Prefetcher))
+}
+
+// Shutdown mocks base method
+func (m *MockBlockOps) Shutdown() {
+	m.ctrl.Call(m, "Shutdown")
+}
+
+// Shutdown indicates an expected call of Shutdown
+func (mr *MockBlockOpsMockRecorder) Shutdown() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockBlockOps)(nil).Shutdown))
+}
+
+// MockKBPKI is a mock of KBPKI interface
+type MockKBPKI struct {
+	ctrl     *gomock.Controller
+	recorder *MockKBPKIMockRecorder
+}
+
+// MockKBPKIMockRecorder is the mock recorder for MockKBPKI
+type MockKBPKIMockRecorder struct {
+	mock *MockKBPKI
+}
+
+// NewMockKBPKI creates a new mock instance
+func NewMockKBPKI(ctrl *gomock.Controller) *MockKBPKI {
+	mock := &MockKBPKI{ctrl: ctrl}
+	mock.recorder = &MockKBPKIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockKBPKI) EXPECT() *MockKBPKIMockRecorder {
+	return m.recorder
+}
+
+// GetCurrentSession mocks base method
+func (m *MockKBPKI) GetCurrentSession(ctx context.Context) (libkbfs.SessionInfo, error) {
+	ret := m.ctrl.Call(m, "GetCurrentSession", ctx)
+	ret0, _ := ret[0].(libkbfs.SessionInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCurrentSession indicates an expected call of GetCurrentSession
+func (mr *MockKBPKIMockRecorder) GetCurrentSession(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCurrentSession", reflect.TypeOf((*MockKBPKI)(nil).GetCurrentSession), ctx)
+}
+
+// Resolve mocks base method
+func (m *MockKBPKI) Resolve(ctx context.Context, assertion string) (libkb.NormalizedUsername, keybase1.UserOrTeamID, error) {
+	ret := m.ctrl.Call(m, "Resolve", ctx, assertion)
+	ret0, _ := ret[0].(libkb.NormalizedUsername)
+	ret1, _ := ret[1].(keybase1.UserOrTeamID)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Resolve indicates an expected call of Resolve
+func (mr *MockKBPKIMockRecorder) Resolve(ctx, assertion interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockKBPKI)(nil).Resolve), ctx, assertion)
+}
+
+// ResolveImplicitTeam mocks base method
+func (m *MockKBPKI) ResolveImplicitTeam(ctx context.Context, assertions, suffix string, tlfType tlf.Type) (libkbfs.ImplicitTeamInfo, error) {
+	ret := m.ctrl.Call(m, "ResolveImplicitTeam", ctx, assertions, suffix, tlfType)
+	ret0, _ := ret[0].(libkbfs.ImplicitTeamInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveImplicitTeam indicates an expected call of ResolveImplicitTeam
+func (mr *MockKBPKIMockRecorder) ResolveImplicitTeam(ctx, assertions, suffix, tlfType interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveImplicitTeam", reflect.TypeOf((*MockKBPKI)(nil).ResolveImplicitTeam), ctx, assertions, suffix, tlfType)
+}
+
+// ResolveImplicitTeamByID mocks base method
+func (m *MockKBPKI) ResolveImplicitTeamByID(ctx context.Context, teamID keybase1.TeamID, tlfType tlf.Type) (libkbfs.ImplicitTeamInfo, error) {
+	ret := m.ctrl.Call(m, "ResolveImplicitTeamByID", ctx, teamID, tlfType)
+	ret0, _ := ret[0].(libkbfs.ImplicitTeamInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveImplicitTeamByID indicates an expected call of ResolveImplicitTeamByID
+func (mr *MockKBPKIMockRecorder) ResolveImplicitTeamByID(ctx, teamID, tlfType interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveImplicitTeamByID", reflect.TypeOf((*MockKBPKI)(nil).ResolveImplicitTeamByID), ctx, teamID, tlfType)
+}
+
+// ResolveTeamTLFID mocks base method
+func (m *MockKBPKI) ResolveTeamTLFID(ctx context.Context, teamID keybase1.TeamID) (tlf.ID, error) {
+	ret := m.ctrl.Call(m, "ResolveTeamTLFID", ctx, teamID)
+	ret0, _ := ret[0].(tlf.ID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveTeamTLFID indicates an expected call of ResolveTeamTLFID
+func (mr *MockKBPKIMockRecorder) ResolveTeamTLFID(ctx, teamID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveTeamTLFID", reflect.TypeOf((*MockKBPKI)(nil).ResolveTeamTLFID), ctx, teamID)
+}
+
+// Identify mocks base method
+func (m *MockKBPKI) Identify(ctx context.Context, assertion, reason string) (libkb.NormalizedUsername, keybase1.UserOrTeamID, error) {
+	ret := m.ctrl.Call(m, "Identify", ctx, assertion, reason)
+	ret0, _ := ret[0].(libkb.NormalizedUsername)
+	ret1, _ := ret[1].(keybase1.UserOrTeamID)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Identify indicates an expected call of Identify
+func (mr *MockKBPKIMockRecorder) Identify(ctx, assertion, reason interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Identify", reflect.TypeOf((*MockKBPKI)(nil).Identify), ctx, assertion, reason)
+}
+
+// IdentifyImplicitTeam mocks base method
+func (m *MockKBPKI) IdentifyImplicitTeam(ctx context.Context, assertions, suffix string, tlfType tlf.Type, reason string) (libkbfs.ImplicitTeamInfo, error) {
+	ret := m.ctrl.Call(m, "IdentifyImplicitTeam", ctx, assertions, suffix, tlfType, reason)
+	ret0, _ := ret[0].(libkbfs.ImplicitTeamInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IdentifyImplicitTeam indicates an expected call of IdentifyImplicitTeam
+func (mr *MockKBPKIMockRecorder) IdentifyImplicitTeam(ctx, assertions, suffix, tlfType, reason interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IdentifyImplicitTeam", reflect.TypeOf((*MockKBPKI)(nil).IdentifyImplicitTeam), ctx, assertions, suffix, tlfType, reason)
+}
+
+// GetNormalizedUsername mocks base method
+func (m *MockKBPKI) GetNormalizedUsername(ctx context.Context, id keybase1.UserOrTeamID) (libkb.NormalizedUsername, error) {
+	ret := m.ctrl.Call(m, "GetNormalizedUsername", ctx, id)
+	ret0, _ := ret[0].(libkb.NormalizedUsername)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNormalizedUsername indicates an expected call of GetNormalizedUsername
+func (mr *MockKBPKIMockRecorder) GetNormalizedUsername(ctx, id interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNormalizedUsername", reflect.TypeOf((*MockKBPKI)(nil).GetNormalizedUsername), ctx, id)
+}
+
+// GetCurrentMerkleRoot mocks base method
+func (m *MockKBPKI) GetCurrentMerkleRoot(ctx context.Context) (keybase1.MerkleRootV2, error) {
+	ret := m.ctrl.Call(m, "GetCurrentMerkleRoot", ctx)
+	ret0, _ := ret[0].(keybase1.MerkleRootV2)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCurrentMerkleRoot indicates an expected call of GetCurrentMerkleRoot
+func (mr *MockKBPKIMockRecorder) GetCurrentMerkleRoot(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCurrentMerkleRoot", reflect.TypeOf((*MockKBPKI)(nil).GetCurrentMerkleRoot), ctx)
+}
+
+// VerifyMerkleRoot mocks base method
+func (m *MockKBPKI) VerifyMerkleRoot(ctx context.Context, root keybase1.MerkleRootV2, kbfsRoot keybase1.KBFSRoot) error {
+	ret := m.ctrl.Call(m, "VerifyMerkleRoot", ctx, root, kbfsRoot)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyMerkleRoot indicates an expected call of VerifyMerkleRoot
+func (mr *MockKBPKIMockRecorder) VerifyMerkleRoot(ctx, root, kbfsRoot interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyMerkleRoot", reflect.TypeOf((*MockKBPKI)(nil).VerifyMerkleRoot), ctx, root, kbfsRoot)
+}
+
+// IsTeamWriter mocks base method
+func (m *MockKBPKI) IsTeamWriter(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID, verifyingKey kbfscrypto.VerifyingKey) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamWriter", ctx, tid, uid, verifyingKey)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamWriter indicates an expected call of IsTeamWriter
+func (mr *MockKBPKIMockRecorder) IsTeamWriter(ctx, tid, uid, verifyingKey interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamWriter", reflect.TypeOf((*MockKBPKI)(nil).IsTeamWriter), ctx, tid, uid, verifyingKey)
+}
+
+// IsTeamReader mocks base method
+func (m *MockKBPKI) IsTeamReader(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamReader", ctx, tid, uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamReader indicates an expected call of IsTeamReader
+func (mr *MockKBPKIMockRecorder) IsTeamReader(ctx, tid, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamReader", reflect.TypeOf((*MockKBPKI)(nil).IsTeamReader), ctx, tid, uid)
+}
+
+// ListResolvedTeamMembers mocks base method
+func (m *MockKBPKI) ListResolvedTeamMembers(ctx context.Context, tid keybase1.TeamID) ([]keybase1.UID, []keybase1.UID, error) {
+	ret := m.ctrl.Call(m, "ListResolvedTeamMembers", ctx, tid)
+	ret0, _ := ret[0].([]keybase1.UID)
+	ret1, _ := ret[1].([]keybase1.UID)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListResolvedTeamMembers indicates an expected call of ListResolvedTeamMembers
+func (mr *MockKBPKIMockRecorder) ListResolvedTeamMembers(ctx, tid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListResolvedTeamMembers", reflect.TypeOf((*MockKBPKI)(nil).ListResolvedTeamMembers), ctx, tid)
+}
+
+// GetTeamTLFCryptKeys mocks base method
+func (m *MockKBPKI) GetTeamTLFCryptKeys(ctx context.Context, tid keybase1.TeamID, desiredKeyGen kbfsmd.KeyGen) (map[kbfsmd.KeyGen]kbfscrypto.TLFCryptKey, kbfsmd.KeyGen, error) {
+	ret := m.ctrl.Call(m, "GetTeamTLFCryptKeys", ctx, tid, desiredKeyGen)
+	ret0, _ := ret[0].(map[kbfsmd.KeyGen]kbfscrypto.TLFCryptKey)
+	ret1, _ := ret[1].(kbfsmd.KeyGen)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetTeamTLFCryptKeys indicates an expected call of GetTeamTLFCryptKeys
+func (mr *MockKBPKIMockRecorder) GetTeamTLFCryptKeys(ctx, tid, desiredKeyGen interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamTLFCryptKeys", reflect.TypeOf((*MockKBPKI)(nil).GetTeamTLFCryptKeys), ctx, tid, desiredKeyGen)
+}
+
+// GetTeamRootID mocks base method
+func (m *MockKBPKI) GetTeamRootID(ctx context.Context, tid keybase1.TeamID) (keybase1.TeamID, error) {
+	ret := m.ctrl.Call(m, "GetTeamRootID", ctx, tid)
+	ret0, _ := ret[0].(keybase1.TeamID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTeamRootID indicates an expected call of GetTeamRootID
+func (mr *MockKBPKIMockRecorder) GetTeamRootID(ctx, tid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamRootID", reflect.TypeOf((*MockKBPKI)(nil).GetTeamRootID), ctx, tid)
+}
+
+// PutGitMetadata mocks base method
+func (m *MockKBPKI) PutGitMetadata(ctx context.Context, folder keybase1.Folder, repoID keybase1.RepoID, metadata keybase1.GitLocalMetadata) error {
+	ret := m.ctrl.Call(m, "PutGitMetadata", ctx, folder, repoID, metadata)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutGitMetadata indicates an expected call of PutGitMetadata
+func (mr *MockKBPKIMockRecorder) PutGitMetadata(ctx, folder, repoID, metadata interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGitMetadata", reflect.TypeOf((*MockKBPKI)(nil).PutGitMetadata), ctx, folder, repoID, metadata)
+}
+
+// HasVerifyingKey mocks base method
+func (m *MockKBPKI) HasVerifyingKey(ctx context.Context, uid keybase1.UID, verifyingKey kbfscrypto.VerifyingKey, atServerTime time.Time) error {
+	ret := m.ctrl.Call(m, "HasVerifyingKey", ctx, uid, verifyingKey, atServerTime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HasVerifyingKey indicates an expected call of HasVerifyingKey
+func (mr *MockKBPKIMockRecorder) HasVerifyingKey(ctx, uid, verifyingKey, atServerTime interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasVerifyingKey", reflect.TypeOf((*MockKBPKI)(nil).HasVerifyingKey), ctx, uid, verifyingKey, atServerTime)
+}
+
+// GetCryptPublicKeys mocks base method
+func (m *MockKBPKI) GetCryptPublicKeys(ctx context.Context, uid keybase1.UID) ([]kbfscrypto.CryptPublicKey, error) {
+	ret := m.ctrl.Call(m, "GetCryptPublicKeys", ctx, uid)
+	ret0, _ := ret[0].([]kbfscrypto.CryptPublicKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCryptPublicKeys indicates an expected call of GetCryptPublicKeys
+func (mr *MockKBPKIMockRecorder) GetCryptPublicKeys(ctx, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCryptPublicKeys", reflect.TypeOf((*MockKBPKI)(nil).GetCryptPublicKeys), ctx, uid)
+}
+
+// FavoriteAdd mocks base method
+func (m *MockKBPKI) FavoriteAdd(ctx context.Context, folder keybase1.Folder) error {
+	ret := m.ctrl.Call(m, "FavoriteAdd", ctx, folder)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FavoriteAdd indicates an expected call of FavoriteAdd
+func (mr *MockKBPKIMockRecorder) FavoriteAdd(ctx, folder interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FavoriteAdd", reflect.TypeOf((*MockKBPKI)(nil).FavoriteAdd), ctx, folder)
+}
+
+// FavoriteDelete mocks base method
+func (m *MockKBPKI) FavoriteDelete(ctx context.Context, folder keybase1.Folder) error {
+	ret := m.ctrl.Call(m, "FavoriteDelete", ctx, folder)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FavoriteDelete indicates an expected call of FavoriteDelete
+func (mr *MockKBPKIMockRecorder) FavoriteDelete(ctx, folder interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FavoriteDelete", reflect.TypeOf((*MockKBPKI)(nil).FavoriteDelete), ctx, folder)
+}
+
+// FavoriteList mocks base method
+func (m *MockKBPKI) FavoriteList(ctx context.Context) (keybase1.FavoritesResult, error) {
+	ret := m.ctrl.Call(m, "FavoriteList", ctx)
+	ret0, _ := ret[0].(keybase1.FavoritesResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FavoriteList indicates an expected call of FavoriteList
+func (mr *MockKBPKIMockRecorder) FavoriteList(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FavoriteList", reflect.TypeOf((*MockKBPKI)(nil).FavoriteList), ctx)
+}
+
+// CreateTeamTLF mocks base method
+func (m *MockKBPKI) CreateTeamTLF(ctx context.Context, teamID keybase1.TeamID, tlfID tlf.ID) error {
+	ret := m.ctrl.Call(m, "CreateTeamTLF", ctx, teamID, tlfID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateTeamTLF indicates an expected call of CreateTeamTLF
+func (mr *MockKBPKIMockRecorder) CreateTeamTLF(ctx, teamID, tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTeamTLF", reflect.TypeOf((*MockKBPKI)(nil).CreateTeamTLF), ctx, teamID, tlfID)
+}
+
+// Notify mocks base method
+func (m *MockKBPKI) Notify(ctx context.Context, notification *keybase1.FSNotification) error {
+	ret := m.ctrl.Call(m, "Notify", ctx, notification)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Notify indicates an expected call of Notify
+func (mr *MockKBPKIMockRecorder) Notify(ctx, notification interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockKBPKI)(nil).Notify), ctx, notification)
+}
+
+// MockCrypto is a mock of Crypto interface
+type MockCrypto struct {
+	ctrl     *gomock.Controller
+	recorder *MockCryptoMockRecorder
+}
+
+// MockCryptoMockRecorder is the mock recorder for MockCrypto
+type MockCryptoMockRecorder struct {
+	mock *MockCrypto
+}
+
+// NewMockCrypto creates a new mock instance
+func NewMockCrypto(ctrl *gomock.Controller) *MockCrypto {
+	mock := &MockCrypto{ctrl: ctrl}
+	mock.recorder = &MockCryptoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockCrypto) EXPECT() *MockCryptoMockRecorder {
+	return m.recorder
+}
+
+// MakeRandomTlfID mocks base method
+func (m *MockCrypto) MakeRandomTlfID(t tlf.Type) (tlf.ID, error) {
+	ret := m.ctrl.Call(m, "MakeRandomTlfID", t)
+	ret0, _ := ret[0].(tlf.ID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeRandomTlfID indicates an expected call of MakeRandomTlfID
+func (mr *MockCryptoMockRecorder) MakeRandomTlfID(t interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeRandomTlfID", reflect.TypeOf((*MockCrypto)(nil).MakeRandomTlfID), t)
+}
+
+// MakeRandomBranchID mocks base method
+func (m *MockCrypto) MakeRandomBranchID() (kbfsmd.BranchID, error) {
+	ret := m.ctrl.Call(m, "MakeRandomBranchID")
+	ret0, _ := ret[0].(kbfsmd.BranchID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeRandomBranchID indicates an expected call of MakeRandomBranchID
+func (mr *MockCryptoMockRecorder) MakeRandomBranchID() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeRandomBranchID", reflect.TypeOf((*MockCrypto)(nil).MakeRandomBranchID))
+}
+
+// MakeTemporaryBlockID mocks base method
+func (m *MockCrypto) MakeTemporaryBlockID() (kbfsblock.ID, error) {
+	ret := m.ctrl.Call(m, "MakeTemporaryBlockID")
+	ret0, _ := ret[0].(kbfsblock.ID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeTemporaryBlockID indicates an expected call of MakeTemporaryBlockID
+func (mr *MockCryptoMockRecorder) MakeTemporaryBlockID() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeTemporaryBlockID", reflect.TypeOf((*MockCrypto)(nil).MakeTemporaryBlockID))
+}
+
+// MakeBlockRefNonce mocks base method
+func (m *MockCrypto) MakeBlockRefNonce() (kbfsblock.RefNonce, error) {
+	ret := m.ctrl.Call(m, "MakeBlockRefNonce")
+	ret0, _ := ret[0].(kbfsblock.RefNonce)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeBlockRefNonce indicates an expected call of MakeBlockRefNonce
+func (mr *MockCryptoMockRecorder) MakeBlockRefNonce() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeBlockRefNonce", reflect.TypeOf((*MockCrypto)(nil).MakeBlockRefNonce))
+}
+
+// MakeRandomTLFEphemeralKeys mocks base method
+func (m *MockCrypto) MakeRandomTLFEphemeralKeys() (kbfscrypto.TLFEphemeralPublicKey, kbfscrypto.TLFEphemeralPrivateKey, error) {
+	ret := m.ctrl.Call(m, "MakeRandomTLFEphemeralKeys")
+	ret0, _ := ret[0].(kbfscrypto.TLFEphemeralPublicKey)
+	ret1, _ := ret[1].(kbfscrypto.TLFEphemeralPrivateKey)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// MakeRandomTLFEphemeralKeys indicates an expected call of MakeRandomTLFEphemeralKeys
+func (mr *MockCryptoMockRecorder) MakeRandomTLFEphemeralKeys() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeRandomTLFEphemeralKeys", reflect.TypeOf((*MockCrypto)(nil).MakeRandomTLFEphemeralKeys))
+}
+
+// MakeRandomTLFKeys mocks base method
+func (m *MockCrypto) MakeRandomTLFKeys() (kbfscrypto.TLFPublicKey, kbfscrypto.TLFPrivateKey, kbfscrypto.TLFCryptKey, error) {
+	ret := m.ctrl.Call(m, "MakeRandomTLFKeys")
+	ret0, _ := ret[0].(kbfscrypto.TLFPublicKey)
+	ret1, _ := ret[1].(kbfscrypto.TLFPrivateKey)
+	ret2, _ := ret[2].(kbfscrypto.TLFCryptKey)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// MakeRandomTLFKeys indicates an expected call of MakeRandomTLFKeys
+func (mr *MockCryptoMockRecorder) MakeRandomTLFKeys() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeRandomTLFKeys", reflect.TypeOf((*MockCrypto)(nil).MakeRandomTLFKeys))
+}
+
+// MakeRandomBlockCryptKeyServerHalf mocks base method
+func (m *MockCrypto) MakeRandomBlockCryptKeyServerHalf() (kbfscrypto.BlockCryptKeyServerHalf, error) {
+	ret := m.ctrl.Call(m, "MakeRandomBlockCryptKeyServerHalf")
+	ret0, _ := ret[0].(kbfscrypto.BlockCryptKeyServerHalf)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeRandomBlockCryptKeyServerHalf indicates an expected call of MakeRandomBlockCryptKeyServerHalf
+func (mr *MockCryptoMockRecorder) MakeRandomBlockCryptKeyServerHalf() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeRandomBlockCryptKeyServerHalf", reflect.TypeOf((*MockCrypto)(nil).MakeRandomBlockCryptKeyServerHalf))
+}
+
+// EncryptPrivateMetadata mocks base method
+func (m *MockCrypto) EncryptPrivateMetadata(pmd libkbfs.PrivateMetadata, key kbfscrypto.TLFCryptKey) (kbfscrypto.EncryptedPrivateMetadata, error) {
+	ret := m.ctrl.Call(m, "EncryptPrivateMetadata", pmd, key)
+	ret0, _ := ret[0].(kbfscrypto.EncryptedPrivateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EncryptPrivateMetadata indicates an expected call of EncryptPrivateMetadata
+func (mr *MockCryptoMockRecorder) EncryptPrivateMetadata(pmd, key interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncryptPrivateMetadata", reflect.TypeOf((*MockCrypto)(nil).EncryptPrivateMetadata), pmd, key)
+}
+
+// DecryptPrivateMetadata mocks base method
+func (m *MockCrypto) DecryptPrivateMetadata(encryptedPMD kbfscrypto.EncryptedPrivateMetadata, key kbfscrypto.TLFCryptKey) (libkbfs.PrivateMetadata, error) {
+	ret := m.ctrl.Call(m, "DecryptPrivateMetadata", encryptedPMD, key)
+	ret0, _ := ret[0].(libkbfs.PrivateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecryptPrivateMetadata indicates an expected call of DecryptPrivateMetadata
+func (mr *MockCryptoMockRecorder) DecryptPrivateMetadata(encryptedPMD, key interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptPrivateMetadata", reflect.TypeOf((*MockCrypto)(nil).DecryptPrivateMetadata), encryptedPMD, key)
+}
+
+// EncryptBlock mocks base method
+func (m *MockCrypto) EncryptBlock(block libkbfs.Block, key kbfscrypto.BlockCryptKey) (int, kbfscrypto.EncryptedBlock, error) {
+	ret := m.ctrl.Call(m, "EncryptBlock", block, key)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(kbfscrypto.EncryptedBlock)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// EncryptBlock indicates an expected call of EncryptBlock
+func (mr *MockCryptoMockRecorder) EncryptBlock(block, key interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncryptBlock", reflect.TypeOf((*MockCrypto)(nil).EncryptBlock), block, key)
+}
+
+// DecryptBlock mocks base method
+func (m *MockCrypto) DecryptBlock(encryptedBlock kbfscrypto.EncryptedBlock, key kbfscrypto.BlockCryptKey, block libkbfs.Block) error {
+	ret := m.ctrl.Call(m, "DecryptBlock", encryptedBlock, key, block)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DecryptBlock indicates an expected call of DecryptBlock
+func (mr *MockCryptoMockRecorder) DecryptBlock(encryptedBlock, key, block interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptBlock", reflect.TypeOf((*MockCrypto)(nil).DecryptBlock), encryptedBlock, key, block)
+}
+
+// Sign mocks base method
+func (m *MockCrypto) Sign(arg0 context.Context, arg1 []byte) (kbfscrypto.SignatureInfo, error) {
+	ret := m.ctrl.Call(m, "Sign", arg0, arg1)
+	ret0, _ := ret[0].(kbfscrypto.SignatureInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Sign indicates an expected call of Sign
+func (mr *MockCryptoMockRecorder) Sign(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sign", reflect.TypeOf((*MockCrypto)(nil).Sign), arg0, arg1)
+}
+
+// SignForKBFS mocks base method
+func (m *MockCrypto) SignForKBFS(arg0 context.Context, arg1 []byte) (kbfscrypto.SignatureInfo, error) {
+	ret := m.ctrl.Call(m, "SignForKBFS", arg0, arg1)
+	ret0, _ := ret[0].(kbfscrypto.SignatureInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SignForKBFS indicates an expected call of SignForKBFS
+func (mr *MockCryptoMockRecorder) SignForKBFS(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignForKBFS", reflect.TypeOf((*MockCrypto)(nil).SignForKBFS), arg0, arg1)
+}
+
+// SignToString mocks base method
+func (m *MockCrypto) SignToString(arg0 context.Context, arg1 []byte) (string, error) {
+	ret := m.ctrl.Call(m, "SignToString", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SignToString indicates an expected call of SignToString
+func (mr *MockCryptoMockRecorder) SignToString(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignToString", reflect.TypeOf((*MockCrypto)(nil).SignToString), arg0, arg1)
+}
+
+// DecryptTLFCryptKeyClientHalf mocks base method
+func (m *MockCrypto) DecryptTLFCryptKeyClientHalf(ctx context.Context, publicKey kbfscrypto.TLFEphemeralPublicKey, encryptedClientHalf kbfscrypto.EncryptedTLFCryptKeyClientHalf) (kbfscrypto.TLFCryptKeyClientHalf, error) {
+	ret := m.ctrl.Call(m, "DecryptTLFCryptKeyClientHalf", ctx, publicKey, encryptedClientHalf)
+	ret0, _ := ret[0].(kbfscrypto.TLFCryptKeyClientHalf)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecryptTLFCryptKeyClientHalf indicates an expected call of DecryptTLFCryptKeyClientHalf
+func (mr *MockCryptoMockRecorder) DecryptTLFCryptKeyClientHalf(ctx, publicKey, encryptedClientHalf interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptTLFCryptKeyClientHalf", reflect.TypeOf((*MockCrypto)(nil).DecryptTLFCryptKeyClientHalf), ctx, publicKey, encryptedClientHalf)
+}
+
+// DecryptTLFCryptKeyClientHalfAny mocks base method
+func (m *MockCrypto) DecryptTLFCryptKeyClientHalfAny(ctx context.Context, keys []libkbfs.EncryptedTLFCryptKeyClientAndEphemeral, promptPaper bool) (kbfscrypto.TLFCryptKeyClientHalf, int, error) {
+	ret := m.ctrl.Call(m, "DecryptTLFCryptKeyClientHalfAny", ctx, keys, promptPaper)
+	ret0, _ := ret[0].(kbfscrypto.TLFCryptKeyClientHalf)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// DecryptTLFCryptKeyClientHalfAny indicates an expected call of DecryptTLFCryptKeyClientHalfAny
+func (mr *MockCryptoMockRecorder) DecryptTLFCryptKeyClientHalfAny(ctx, keys, promptPaper interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptTLFCryptKeyClientHalfAny", reflect.TypeOf((*MockCrypto)(nil).DecryptTLFCryptKeyClientHalfAny), ctx, keys, promptPaper)
+}
+
+// DecryptTeamMerkleLeaf mocks base method
+func (m *MockCrypto) DecryptTeamMerkleLeaf(ctx context.Context, teamID keybase1.TeamID, publicKey kbfscrypto.TLFEphemeralPublicKey, encryptedMerkleLeaf kbfscrypto.EncryptedMerkleLeaf, minKeyGen keybase1.PerTeamKeyGeneration) ([]byte, error) {
+	ret := m.ctrl.Call(m, "DecryptTeamMerkleLeaf", ctx, teamID, publicKey, encryptedMerkleLeaf, minKeyGen)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecryptTeamMerkleLeaf indicates an expected call of DecryptTeamMerkleLeaf
+func (mr *MockCryptoMockRecorder) DecryptTeamMerkleLeaf(ctx, teamID, publicKey, encryptedMerkleLeaf, minKeyGen interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptTeamMerkleLeaf", reflect.TypeOf((*MockCrypto)(nil).DecryptTeamMerkleLeaf), ctx, teamID, publicKey, encryptedMerkleLeaf, minKeyGen)
+}
+
+// Shutdown mocks base method
+func (m *MockCrypto) Shutdown() {
+	m.ctrl.Call(m, "Shutdown")
+}
+
+// Shutdown indicates an expected call of Shutdown
+func (mr *MockCryptoMockRecorder) Shutdown() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockCrypto)(nil).Shutdown))
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfstest
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/keybase/kbfs/kbfsapi"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+var _ libkbfs.KBFSOps = (*MockKBFSOps)(nil)
+var _ libkbfs.MDOps = (*MockMDOps)(nil)
+var _ libkbfs.BlockOps = (*MockBlockOps)(nil)
+var _ libkbfs.KBPKI = (*MockKBPKI)(nil)
+var _ libkbfs.Crypto = (*MockCrypto)(nil)
+
+// TestMockKBFSOps checks that a mock can stand in for the real
+// KBFSOps underneath code outside of libkbfs.
+func TestMockKBFSOps(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	kbfsOps := NewMockKBFSOps(ctrl)
+	realOps := config.KBFSOps()
+	config.SetKBFSOps(kbfsOps)
+	defer config.SetKBFSOps(realOps)
+
+	kbfsOps.EXPECT().GetFavorites(gomock.Any()).Return(
+		[]libkbfs.Favorite{{Name: "user1,user2", Type: tlf.Private}}, nil)
+	folders, err := kbfsapi.NewClient(config).Favorites(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []kbfsapi.Folder{{
+		Name: "user1,user2",
+		Type: kbfsapi.PrivateFolder,
+	}}, folders)
+}
@@ -76,7 +76,15 @@ func realMain() (exitStatus int) {
 	kbfsParams.EnableJournal = false
 	kbfsParams.DiskCacheMode = libkbfs.DiskCacheModeOff
 
-	ctx := context.Background()
+	cmd := flag.Arg(0)
+	args := flag.Args()[1:]
+
+	ctx := libkbfs.CtxWithRequestSource(context.Background(),
+		libkbfs.RequestSource{
+			Frontend: libkbfs.RequestFrontendCLI,
+			PID:      os.Getpid(),
+			Action:   cmd,
+		})
 	config, err := libkbfs.Init(ctx, kbCtx, *kbfsParams, nil, nil, log)
 	if err != nil {
 		printError("kbfs", err)
@@ -89,9 +97,6 @@ func realMain() (exitStatus int) {
 	// figure out some other way to log the full folder-branch
 	// name for kbfsfuse but not for kbfs.
 
-	cmd := flag.Arg(0)
-	args := flag.Args()[1:]
-
 	switch cmd {
 	case "stat":
 		return stat(ctx, config, args)
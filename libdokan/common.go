@@ -88,6 +88,8 @@ func errToDokan(err error) error {
 		return dokan.ErrObjectNameNotFound
 	case kbfsmd.ServerErrorUnauthorized:
 		return dokan.ErrAccessDenied
+	case libkbfs.OpNotPermittedError:
+		return dokan.ErrAccessDenied
 	case nil:
 		return nil
 	}
@@ -0,0 +1,15 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/libfs"
+)
+
+// NewDebugBundleFile returns a special read file that contains a
+// zip archive of redacted diagnostics.
+func NewDebugBundleFile(fs *FS) *SpecialReadFile {
+	return &SpecialReadFile{read: libfs.GetEncodedDebugBundle(fs.config), fs: fs}
+}
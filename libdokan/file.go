@@ -96,15 +96,15 @@ func (f *File) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, off
 	f.folder.fs.logEnter(ctx, "WriteFile")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
 
+	// An offset of -1 means append, which KBFSOps does atomically
+	// at whatever the end of the file is at the time.
+	var flags libkbfs.WriteFlags
 	if offset == -1 {
-		ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
-		if err != nil {
-			return 0, err
-		}
-		offset = int64(ei.Size)
+		flags = libkbfs.WriteAppend
 	}
 
-	err = f.folder.fs.config.KBFSOps().Write(ctx, f.node, bs, offset)
+	_, err = f.folder.fs.config.KBFSOps().WriteWithFlags(
+		ctx, f.node, bs, offset, flags)
 	return len(bs), err
 }
 
@@ -119,6 +119,9 @@ func (f *FS) WithContext(ctx context.Context) (context.Context, context.CancelFu
 	if err != nil {
 		panic(err)
 	}
+	ctx = libkbfs.CtxWithRequestSource(ctx, libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendDokan,
+	})
 	return ctx, cancel
 }
 
@@ -311,6 +314,10 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		return oc.returnFileNoCleanup(NewErrorFile(f))
 	case libfs.MetricsFileName == ps[psl-1]:
 		return oc.returnFileNoCleanup(NewMetricsFile(f))
+	case libfs.DebugBundleFileName == ps[psl-1]:
+		return oc.returnFileNoCleanup(NewDebugBundleFile(f))
+	case libfs.SelfTestFileName == ps[psl-1]:
+		return oc.returnFileNoCleanup(NewSelfTestFile(f))
 		// TODO: Make the two cases below available from any
 		// directory.
 	case libfs.ProfileListDirName == ps[0]:
@@ -350,6 +357,21 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 			fs:     f,
 			enable: false,
 		})
+	case libfs.LogPathsFullFileName == ps[0]:
+		return oc.returnFileNoCleanup(&LogPathsFile{
+			fs:   f,
+			mode: libkbfs.LogPathsFull,
+		})
+	case libfs.LogPathsHashedFileName == ps[0]:
+		return oc.returnFileNoCleanup(&LogPathsFile{
+			fs:   f,
+			mode: libkbfs.LogPathsHashed,
+		})
+	case libfs.LogPathsNoneFileName == ps[0]:
+		return oc.returnFileNoCleanup(&LogPathsFile{
+			fs:   f,
+			mode: libkbfs.LogPathsNone,
+		})
 
 	case ".kbfs_unmount" == ps[0]:
 		os.Exit(0)
@@ -0,0 +1,37 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// LogPathsFile represents a write-only file where any write of at
+// least one byte changes how much of file names and paths KBFS
+// reveals in its logs.
+type LogPathsFile struct {
+	fs   *FS
+	mode libkbfs.LogPathsMode
+	specialWriteFile
+}
+
+// WriteFile performs writes for dokan.
+func (f *LogPathsFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.fs.logEnter(ctx, "LogPathsFile WriteFile")
+	defer func() { f.fs.reportErr(ctx, libkbfs.WriteMode, err) }()
+	f.fs.log.CDebugf(ctx, "LogPathsFile (mode: %s) Write", f.mode)
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	err = f.fs.config.SetLogPathsMode(f.mode)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
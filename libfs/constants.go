@@ -8,6 +8,16 @@ package libfs
 // reached from any KBFS directory.
 const MetricsFileName = ".kbfs_metrics"
 
+// DebugBundleFileName is the name of the KBFS debug bundle file --
+// reading it returns a zip archive of redacted diagnostics to share
+// with support.  It can be reached from any KBFS directory.
+const DebugBundleFileName = ".kbfs_debug_bundle"
+
+// SelfTestFileName is the name of the KBFS self-test file -- reading
+// it runs a self-test in the current user's private folder and
+// returns the results.  It can be reached from any KBFS directory.
+const SelfTestFileName = ".kbfs_self_test"
+
 // ReclaimQuotaFileName is the name of the KBFS quota-reclaiming file
 // -- it can be reached anywhere within a top-level folder.
 const ReclaimQuotaFileName = ".kbfs_reclaim_quota"
@@ -86,6 +96,20 @@ const EnableDebugServerFileName = ".kbfs_enable_debug_server"
 // debug HTTP server. It's accessible anywhere outside a TLF.
 const DisableDebugServerFileName = ".kbfs_disable_debug_server"
 
+// LogPathsFullFileName is the name of the file to make KBFS log file
+// names and paths as-is. It's accessible anywhere outside a TLF.
+const LogPathsFullFileName = ".kbfs_log_paths_full"
+
+// LogPathsHashedFileName is the name of the file to make KBFS replace
+// file names and paths in its logs with opaque tokens. It's
+// accessible anywhere outside a TLF.
+const LogPathsHashedFileName = ".kbfs_log_paths_hashed"
+
+// LogPathsNoneFileName is the name of the file to make KBFS leave
+// file names and paths out of its logs. It's accessible anywhere
+// outside a TLF.
+const LogPathsNoneFileName = ".kbfs_log_paths_none"
+
 // EditHistoryName is the name of the KBFS TLF edit history file --
 // it can be reached anywhere within a top-level folder.
 const EditHistoryName = ".kbfs_edit_history"
@@ -0,0 +1,26 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedDebugBundle returns a function that generates a fresh
+// debug bundle for the debug bundle file.
+func GetEncodedDebugBundle(config libkbfs.Config) func(context.Context) ([]byte, time.Time, error) {
+	return func(ctx context.Context) ([]byte, time.Time, error) {
+		var buf bytes.Buffer
+		err := config.GenerateDebugBundle(ctx, &buf)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return buf.Bytes(), config.Clock().Now(), nil
+	}
+}
@@ -26,7 +26,10 @@ type File struct {
 	filename string
 	node     libkbfs.Node
 	readOnly bool
-	offset   int64
+	// appendMode is true if the file was opened with O_APPEND, so
+	// that every write goes to the end of the file.
+	appendMode bool
+	offset     int64
 
 	lockedLock sync.Mutex
 	locked     bool
@@ -53,13 +56,18 @@ func (f *File) Write(p []byte) (n int, err error) {
 		return 0, errors.New("Trying to write a read-only file")
 	}
 
+	var flags libkbfs.WriteFlags
+	if f.appendMode {
+		flags = libkbfs.WriteAppend
+	}
 	origOffset := atomic.LoadInt64(&f.offset)
-	err = f.fs.config.KBFSOps().Write(f.fs.ctx, f.node, p, origOffset)
+	writtenOffset, err := f.fs.config.KBFSOps().WriteWithFlags(
+		f.fs.ctx, f.node, p, origOffset, flags)
 	if err != nil {
 		return 0, err
 	}
 
-	f.updateOffset(origOffset, int64(len(p)))
+	f.updateOffset(writtenOffset, int64(len(p)))
 	return len(p), nil
 }
 
@@ -36,22 +36,19 @@ func (fi *FileInfo) Size() int64 {
 
 // Mode implements the os.FileInfo interface for FileInfo.
 func (fi *FileInfo) Mode() os.FileMode {
-	mode, err := WritePermMode(
-		fi.fs.ctx, fi.node, os.FileMode(0), fi.fs.config.KBPKI(), fi.fs.h)
+	mode, err := PermMode(
+		fi.fs.ctx, fi.node, fi.ei, fi.fs.config.KBPKI(), fi.fs.h)
 	if err != nil {
 		fi.fs.log.CWarningf(
 			fi.fs.ctx, "Couldn't get mode for file %s: %+v", fi.Name(), err)
-		mode = os.FileMode(0)
+		mode = fi.ei.Perm() &^ 0222
 	}
 
-	mode |= 0400
 	switch fi.ei.Type {
 	case libkbfs.Dir:
-		mode |= os.ModeDir | 0100
+		mode |= os.ModeDir
 	case libkbfs.Sym:
 		mode |= os.ModeSymlink
-	case libkbfs.Exec:
-		mode |= 0100
 	}
 	return mode
 }
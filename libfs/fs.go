@@ -371,7 +371,8 @@ func (fs *FS) mkdirAll(filename string, perm os.FileMode) (err error) {
 		switch errors.Cause(err).(type) {
 		case libkbfs.NameExistsError:
 			// The child directory already exists.
-		case libkbfs.WriteAccessError, libkbfs.WriteToReadonlyNodeError:
+		case libkbfs.WriteAccessError, libkbfs.WriteToReadonlyNodeError,
+			libkbfs.ReadOnlyError, libkbfs.MDFormatReadOnlyError:
 			// If the child already exists, this doesn't matter.
 			var lookupErr error
 			child, _, lookupErr = fs.config.KBFSOps().Lookup(fs.ctx, n, p)
@@ -392,7 +393,8 @@ func (fs *FS) ensureParentDir(filename string) error {
 	err := fs.mkdirAll(path.Dir(filename), 0755)
 	if err != nil && !os.IsExist(err) {
 		switch errors.Cause(err).(type) {
-		case libkbfs.WriteAccessError, libkbfs.WriteToReadonlyNodeError:
+		case libkbfs.WriteAccessError, libkbfs.WriteToReadonlyNodeError,
+			libkbfs.ReadOnlyError, libkbfs.MDFormatReadOnlyError:
 			// We're not allowed to create any of the parent
 			// directories automatically, so give back a proper
 			// isNotExist error.
@@ -447,11 +449,12 @@ func (fs *FS) OpenFile(filename string, flag int, perm os.FileMode) (
 	}
 
 	return &File{
-		fs:       fs,
-		filename: filename,
-		node:     n,
-		readOnly: flag == os.O_RDONLY,
-		offset:   offset,
+		fs:         fs,
+		filename:   filename,
+		node:       n,
+		readOnly:   flag == os.O_RDONLY,
+		appendMode: flag&os.O_APPEND != 0,
+		offset:     offset,
 	}, nil
 }
 
@@ -691,8 +694,7 @@ func (fs *FS) Chmod(name string, mode os.FileMode) (err error) {
 		return err
 	}
 
-	isExec := (mode & 0100) != 0
-	return fs.config.KBFSOps().SetEx(fs.ctx, n, isExec)
+	return fs.config.KBFSOps().SetMode(fs.ctx, n, mode.Perm())
 }
 
 // Lchown implements the billy.Filesystem interface for FS.
@@ -61,3 +61,21 @@ func WritePermMode(
 
 	return original, nil
 }
+
+// PermMode returns the permission bits that should be shown for an
+// entry with info `ei`: the ones returned by `ei.Perm()`, minus all
+// the write bits if the currently logged-in user can't write to
+// `node` in the folder described by `h`.
+func PermMode(
+	ctx context.Context, node libkbfs.Node, ei libkbfs.EntryInfo,
+	kbpki libkbfs.KBPKI, h *libkbfs.TlfHandle) (os.FileMode, error) {
+	writeMode, err := WritePermMode(ctx, node, 0, kbpki, h)
+	if err != nil {
+		return 0, err
+	}
+	perm := ei.Perm()
+	if writeMode == 0 {
+		perm &^= 0222
+	}
+	return perm, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedSelfTest returns a function that runs a fresh self-test
+// in the current user's private folder, and returns its results as
+// serialized JSON.
+func GetEncodedSelfTest(config libkbfs.Config) func(context.Context) ([]byte, time.Time, error) {
+	return func(ctx context.Context) ([]byte, time.Time, error) {
+		result := libkbfs.RunSelfTest(ctx, config, "", tlf.Private)
+		data, err := PrettyJSON(result)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return data, result.Start.Add(result.Duration), nil
+	}
+}
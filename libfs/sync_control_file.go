@@ -41,20 +41,25 @@ func (a SyncAction) Execute(
 		panic("zero fb in SyncAction.Execute")
 	}
 
+	var synced bool
 	switch a {
 	case SyncEnable:
-		err = c.SetTlfSyncState(fb.Tlf, true)
+		synced = true
 
 	case SyncDisable:
-		err = c.SetTlfSyncState(fb.Tlf, false)
+		synced = false
 
 	default:
 		return fmt.Errorf("Unknown action %s", a)
 	}
+	// Make sure the folder is running, so that its sync starts right
+	// away.
+	_, _, err = c.KBFSOps().GetRootNode(ctx, h, fb.Branch)
 	if err != nil {
 		return err
 	}
-	// Re-trigger prefetches.
-	_, _, err = c.KBFSOps().GetRootNode(ctx, h, fb.Branch)
-	return err
+	if kbfsOps, ok := c.KBFSOps().(*libkbfs.KBFSOpsStandard); ok {
+		return kbfsOps.SetFolderSyncState(ctx, fb.Tlf, synced)
+	}
+	return c.SetTlfSyncState(fb.Tlf, synced)
 }
@@ -0,0 +1,18 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+)
+
+// NewDebugBundleFile returns a special read file that contains a
+// zip archive of redacted diagnostics.
+func NewDebugBundleFile(fs *FS, entryValid *time.Duration) *SpecialReadFile {
+	*entryValid = 0
+	return &SpecialReadFile{read: libfs.GetEncodedDebugBundle(fs.config)}
+}
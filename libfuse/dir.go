@@ -19,6 +19,7 @@ import (
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/sysutils"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -351,15 +352,16 @@ func (f *Folder) tlfHandleChangeInvalidate(ctx context.Context,
 	}
 }
 
-func (f *Folder) writePermMode(ctx context.Context,
-	node libkbfs.Node, original os.FileMode) (os.FileMode, error) {
+func (f *Folder) permMode(ctx context.Context,
+	node libkbfs.Node, ei libkbfs.EntryInfo) (os.FileMode, error) {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
-	return libfs.WritePermMode(ctx, node, original, f.fs.config.KBPKI(), f.h)
+	return libfs.PermMode(ctx, node, ei, f.fs.config.KBPKI(), f.h)
 }
 
 // fillAttrWithUIDAndWritePerm sets attributes based on the entry info, and
-// pops in correct UID and write permissions. It only handles fields common to
+// pops in correct UID and permission bits, without write permissions
+// if the user can't write to the folder. It only handles fields common to
 // all entryinfo types.
 func (f *Folder) fillAttrWithUIDAndWritePerm(
 	ctx context.Context, node libkbfs.Node, ei *libkbfs.EntryInfo,
@@ -373,9 +375,11 @@ func (f *Folder) fillAttrWithUIDAndWritePerm(
 
 	a.Uid = uint32(os.Getuid())
 
-	if a.Mode, err = f.writePermMode(ctx, node, a.Mode); err != nil {
+	perm, err := f.permMode(ctx, node, *ei)
+	if err != nil {
 		return err
 	}
+	a.Mode = a.Mode&^os.ModePerm | perm
 
 	return nil
 }
@@ -499,7 +503,7 @@ func (d *Dir) attr(ctx context.Context, a *fuse.Attr) (err error) {
 		return err
 	}
 
-	a.Mode |= os.ModeDir | 0500
+	a.Mode |= os.ModeDir
 	a.Inode = d.inode
 	return nil
 }
@@ -845,13 +849,19 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
 	if valid.Mode() {
-		// You can't set the mode on KBFS directories, but we don't
-		// want to return EPERM because that unnecessarily fails some
-		// applications like unzip.  Instead ignore it, print a debug
-		// message, and advertise this behavior on the
-		// "understand_kbfs" doc online.
-		d.folder.fs.log.CDebugf(ctx, "Ignoring unsupported attempt to set "+
-			"the mode on a directory")
+		err := d.folder.fs.config.KBFSOps().SetMode(
+			ctx, d.node, req.Mode.Perm())
+		if _, ok := errors.Cause(err).(libkbfs.InvalidParentPathError); ok {
+			// You can't set the mode on a TLF root, but we don't
+			// want to return EPERM because that unnecessarily fails
+			// some applications like unzip.  Instead ignore it,
+			// print a debug message, and advertise this behavior on
+			// the "understand_kbfs" doc online.
+			d.folder.fs.log.CDebugf(ctx, "Ignoring unsupported attempt "+
+				"to set the mode on a TLF root")
+		} else if err != nil {
+			return err
+		}
 		valid &^= fuse.SetattrMode
 	}
 
@@ -52,6 +52,14 @@ func filterError(err error) error {
 		return errorWithErrno{err, syscall.ENOENT}
 	case libkbfs.WriteToReadonlyNodeError:
 		return errorWithErrno{err, syscall.EACCES}
+	case libkbfs.ReadOnlyError:
+		return errorWithErrno{err, syscall.EROFS}
+	case libkbfs.MDFormatReadOnlyError:
+		return errorWithErrno{err, syscall.EROFS}
+	case libkbfs.TimeoutError:
+		return errorWithErrno{err, syscall.ETIMEDOUT}
+	case libkbfs.OpNotPermittedError:
+		return errorWithErrno{err, syscall.EACCES}
 	case libkbfs.UnsupportedOpInUnlinkedDirError:
 		return errorWithErrno{err, syscall.ENOENT}
 	case libkbfs.NeedSelfRekeyError:
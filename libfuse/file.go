@@ -70,10 +70,6 @@ func (f *File) fillAttrWithMode(
 		ctx, f.node, ei, a); err != nil {
 		return err
 	}
-	a.Mode |= 0400
-	if ei.Type == libkbfs.Exec {
-		a.Mode |= 0100
-	}
 
 	a.Inode = f.inode
 	return nil
@@ -240,8 +236,16 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest,
 	defer func() { err = f.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
 	f.eiCache.destroy()
-	if err := f.folder.fs.config.KBFSOps().Write(
-		ctx, f.node, req.Data, req.Offset); err != nil {
+	// The kernel picks the offset of an O_APPEND write from its own
+	// idea of the file size, which can be stale when several
+	// processes append at once, so let KBFSOps find the real end of
+	// the file instead.
+	var flags libkbfs.WriteFlags
+	if req.FileFlags&fuse.OpenAppend != 0 {
+		flags = libkbfs.WriteAppend
+	}
+	if _, err := f.folder.fs.config.KBFSOps().WriteWithFlags(
+		ctx, f.node, req.Data, req.Offset, flags); err != nil {
 		return err
 	}
 	resp.Size = len(req.Data)
@@ -272,10 +276,8 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 	}
 
 	if valid.Mode() {
-		// Unix has 3 exec bits, KBFS has one; we follow the user-exec bit.
-		exec := req.Mode&0100 != 0
-		err := f.folder.fs.config.KBFSOps().SetEx(
-			ctx, f.node, exec)
+		err := f.folder.fs.config.KBFSOps().SetMode(
+			ctx, f.node, req.Mode.Perm())
 		if err != nil {
 			return err
 		}
@@ -5,6 +5,7 @@
 package libfuse
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -312,14 +313,27 @@ func (f *FS) WithContext(ctx context.Context) context.Context {
 		panic(err) // this should never happen
 	}
 
-	return ctx
+	return libkbfs.CtxWithRequestSource(ctx, libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendFUSE,
+	})
+}
+
+// fuseRequestAction returns a short name for the kind of FUSE request
+// req is, like "Lookup".
+func fuseRequestAction(req fuse.Request) string {
+	action := strings.TrimPrefix(fmt.Sprintf("%T", req), "*fuse.")
+	return strings.TrimSuffix(action, "Request")
 }
 
 // Serve FS. Will block.
 func (f *FS) Serve(ctx context.Context) error {
 	srv := fs.New(f.conn, &fs.Config{
-		WithContext: func(ctx context.Context, _ fuse.Request) context.Context {
-			return f.WithContext(ctx)
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			ctx = f.WithContext(ctx)
+			return libkbfs.CtxWithRequestSource(ctx, libkbfs.RequestSource{
+				PID:    int(req.Hdr().Pid),
+				Action: fuseRequestAction(req),
+			})
 		},
 	})
 	f.fuse = srv
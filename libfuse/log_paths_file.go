@@ -0,0 +1,51 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// LogPathsFile represents a write-only file where any write of at
+// least one byte changes how much of file names and paths KBFS
+// reveals in its logs.
+type LogPathsFile struct {
+	fs   *FS
+	mode libkbfs.LogPathsMode
+}
+
+var _ fs.Node = (*LogPathsFile)(nil)
+
+// Attr implements the fs.Node interface for LogPathsFile.
+func (f *LogPathsFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*LogPathsFile)(nil)
+
+var _ fs.HandleWriter = (*LogPathsFile)(nil)
+
+// Write implements the fs.HandleWriter interface for LogPathsFile.
+func (f *LogPathsFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.fs.log.CDebugf(ctx, "LogPathsFile (mode: %s) Write", f.mode)
+	defer func() { err = f.fs.processError(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	err = f.fs.config.SetLogPathsMode(f.mode)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -1307,7 +1307,7 @@ func TestRemoveFileWhileOpenSetEx(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if g, e := fi.Mode().String(), `-rwx------`; g != e {
+	if g, e := fi.Mode().String(), `-rwxr-xr-x`; g != e {
 		t.Errorf("wrong mode: %q != %q", g, e)
 	}
 	syncAndClose(t, f)
@@ -1789,7 +1789,7 @@ func TestChmodExec(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if g, e := fi.Mode().String(), `-rwx------`; g != e {
+	if g, e := fi.Mode().String(), `-rwxr--r--`; g != e {
 		t.Errorf("wrong mode: %q != %q", g, e)
 	}
 }
@@ -1818,7 +1818,7 @@ func TestChmodNonExec(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if g, e := fi.Mode().String(), `-rw-------`; g != e {
+	if g, e := fi.Mode().String(), `-rw-r-xr-x`; g != e {
 		t.Errorf("wrong mode: %q != %q", g, e)
 	}
 }
@@ -1860,7 +1860,7 @@ func TestChownFileIgnored(t *testing.T) {
 	}
 }
 
-func TestChmodDirIgnored(t *testing.T) {
+func TestChmodDir(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
 	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
@@ -1874,9 +1874,16 @@ func TestChmodDirIgnored(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := os.Chmod(p, 0655); err != nil {
-		t.Fatalf("Expecting the dir chmod to get swallowed silently, "+
-			"but got: %v", err)
+	if err := os.Chmod(p, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ioutil.Lstat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := fi.Mode().String(), `drwxr-x---`; g != e {
+		t.Errorf("wrong mode: %q != %q", g, e)
 	}
 }
 
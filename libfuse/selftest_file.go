@@ -0,0 +1,18 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+)
+
+// NewSelfTestFile returns a special read file that runs a self-test
+// each time it is read.
+func NewSelfTestFile(fs *FS, entryValid *time.Duration) *SpecialReadFile {
+	*entryValid = 0
+	return &SpecialReadFile{read: libfs.GetEncodedSelfTest(fs.config)}
+}
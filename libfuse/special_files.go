@@ -21,6 +21,10 @@ func handleCommonSpecialFile(
 		return NewErrorFile(fs, entryValid)
 	case libfs.MetricsFileName:
 		return NewMetricsFile(fs, entryValid)
+	case libfs.DebugBundleFileName:
+		return NewDebugBundleFile(fs, entryValid)
+	case libfs.SelfTestFileName:
+		return NewSelfTestFile(fs, entryValid)
 	case libfs.ProfileListDirName:
 		return ProfileList{}
 	case libfs.ResetCachesFileName:
@@ -64,6 +68,13 @@ func handleNonTLFSpecialFile(
 		return &DebugServerFile{fs: fs, enable: true}
 	case libfs.DisableDebugServerFileName:
 		return &DebugServerFile{fs: fs, enable: false}
+
+	case libfs.LogPathsFullFileName:
+		return &LogPathsFile{fs: fs, mode: libkbfs.LogPathsFull}
+	case libfs.LogPathsHashedFileName:
+		return &LogPathsFile{fs: fs, mode: libkbfs.LogPathsHashed}
+	case libfs.LogPathsNoneFileName:
+		return &LogPathsFile{fs: fs, mode: libkbfs.LogPathsNone}
 	}
 
 	return nil
@@ -89,8 +89,13 @@ func Init(ctx context.Context, gitKBFSParams libkbfs.InitParams,
 	// Assign a unique ID to each remote-helper instance, since
 	// they'll all share the same log.
 	ctx, err = libkbfs.NewContextWithCancellationDelayer(
-		libkbfs.CtxWithRandomIDReplayable(
-			ctx, ctxGitIDKey, ctxGitOpID, log))
+		libkbfs.CtxWithRequestSource(
+			libkbfs.CtxWithRandomIDReplayable(
+				ctx, ctxGitIDKey, ctxGitOpID, log),
+			libkbfs.RequestSource{
+				Frontend: libkbfs.RequestFrontendGit,
+				PID:      os.Getpid(),
+			}))
 	if err != nil {
 		return ctx, nil, err
 	}
@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libhttpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A capability token grants read access to a single file or subtree
+// until it expires, unlike the tokens from NewToken, which grant
+// access to everything the user can read.  It's made of the expiry
+// time and the path, followed by a MAC over both, keyed with a key
+// that's generated when the server starts; so capability tokens
+// needn't be remembered by the server, but don't survive a restart.
+// The parts are base64-encoded and separated by a ".", which never
+// appears in the hex-encoded tokens from NewToken.
+
+const capabilityKeySize = 32
+
+type capabilityKey [capabilityKeySize]byte
+
+var capabilityTokenEncoding = base64.RawURLEncoding
+
+// cleanScopePath cleans `fsPath`, which starts with the TLF type and
+// name, and makes sure it's within a TLF.
+func cleanScopePath(fsPath string) (string, error) {
+	cleaned := strings.Trim(path.Clean("/"+fsPath), "/")
+	if len(strings.Split(cleaned, "/")) < 2 {
+		return "", errors.Errorf("%q isn't within a TLF", fsPath)
+	}
+	return cleaned, nil
+}
+
+func (k capabilityKey) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// newCapabilityToken returns a token granting access to `fsPath`,
+// and everything under it, until `expiresAt`.
+func newCapabilityToken(key capabilityKey, fsPath string,
+	expiresAt time.Time) (string, error) {
+	fsPath, err := cleanScopePath(fsPath)
+	if err != nil {
+		return "", err
+	}
+	payload := make([]byte, 8+len(fsPath))
+	binary.BigEndian.PutUint64(payload, uint64(expiresAt.Unix()))
+	copy(payload[8:], fsPath)
+	return capabilityTokenEncoding.EncodeToString(payload) + "." +
+		capabilityTokenEncoding.EncodeToString(key.mac(payload)), nil
+}
+
+// checkCapabilityToken returns the path `token` is scoped to, if it's
+// a capability token made with `key`, that hasn't expired by `now`,
+// and that grants access to `fsPath`.  Since the check only looks at
+// `fsPath` itself, callers must make sure symlinks under the scope
+// can't lead out of it.
+func checkCapabilityToken(key capabilityKey, token, fsPath string,
+	now time.Time) (scope string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errors.New("not a capability token")
+	}
+	payload, err := capabilityTokenEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	mac, err := capabilityTokenEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !hmac.Equal(mac, key.mac(payload)) || len(payload) < 8 {
+		return "", errors.New("invalid capability token")
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !now.Before(expiresAt) {
+		return "", errors.Errorf("capability token expired at %s", expiresAt)
+	}
+	scope = string(payload[8:])
+	fsPath = strings.Trim(path.Clean("/"+fsPath), "/")
+	if fsPath != scope && !strings.HasPrefix(fsPath, scope+"/") {
+		return "", errors.Errorf(
+			"capability token for %q doesn't cover %q", scope, fsPath)
+	}
+	return scope, nil
+}
+
+// NewCapabilityToken returns a token that a HTTP client can use to
+// read the file or directory at `fsPath`, and anything under it, for
+// the next `validFor`.  `fsPath` starts with the TLF type and name,
+// like the paths the server serves; for example,
+// "private/alice,bob/photos".
+func (s *Server) NewCapabilityToken(fsPath string, validFor time.Duration) (
+	token string, err error) {
+	return newCapabilityToken(
+		s.capabilityKey, fsPath, s.config.Clock().Now().Add(validFor))
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libhttpserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityToken(t *testing.T) {
+	key := capabilityKey{1}
+	now := time.Unix(1000, 0)
+	token, err := newCapabilityToken(
+		key, "/private/alice,bob/photos/", now.Add(time.Minute))
+	require.NoError(t, err)
+
+	for _, covered := range []string{
+		"private/alice,bob/photos",
+		"private/alice,bob/photos/cat.jpg",
+		"private/alice,bob/photos/2018/../cat.jpg",
+	} {
+		scope, err := checkCapabilityToken(key, token, covered, now)
+		require.NoError(t, err, covered)
+		require.Equal(t, "private/alice,bob/photos", scope)
+	}
+
+	for _, notCovered := range []string{
+		"private/alice,bob",
+		"private/alice,bob/photos-private/cat.jpg",
+		"private/alice,bob/photos/../secret.txt",
+		"public/alice,bob/photos/cat.jpg",
+	} {
+		_, err = checkCapabilityToken(key, token, notCovered, now)
+		require.Error(t, err, notCovered)
+	}
+
+	t.Log("Expired tokens are rejected")
+	_, err = checkCapabilityToken(
+		key, token, "private/alice,bob/photos", now.Add(time.Minute))
+	require.Error(t, err)
+
+	t.Log("Tokens made with another key are rejected")
+	_, err = checkCapabilityToken(
+		capabilityKey{2}, token, "private/alice,bob/photos", now)
+	require.Error(t, err)
+
+	t.Log("Tampered tokens are rejected")
+	wider, err := newCapabilityToken(
+		key, "private/alice,bob", now.Add(time.Minute))
+	require.NoError(t, err)
+	forged := wider[:strings.Index(wider, ".")] +
+		token[strings.Index(token, "."):]
+	_, err = checkCapabilityToken(key, forged, "private/alice,bob/a.txt", now)
+	require.Error(t, err)
+
+	t.Log("Tokens must be scoped within a TLF")
+	_, err = newCapabilityToken(key, "private", now.Add(time.Minute))
+	require.Error(t, err)
+}
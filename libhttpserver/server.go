@@ -8,9 +8,10 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"errors"
 	"io"
+	"mime"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -23,6 +24,7 @@ import (
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/libmime"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 )
 
 const tokenCacheSize = 64
@@ -36,8 +38,10 @@ type Server struct {
 	g      *libkb.GlobalContext
 	cancel func()
 
-	tokens *lru.Cache
-	fs     *lru.Cache
+	tokens        *lru.Cache
+	capabilityKey capabilityKey
+	fs            *lru.Cache
+	derived       *libkbfs.DerivedDataCache
 
 	startedLock sync.RWMutex
 	started     bool
@@ -89,8 +93,8 @@ func (e obsoleteTrackingFS) isObsolete() bool {
 	}
 }
 
-func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
-	toStrip string, fs http.FileSystem, err error) {
+func (s *Server) getFS(ctx context.Context, requestPath string) (
+	toStrip string, fs *libfs.FS, err error) {
 	fields := strings.Split(requestPath, "/")
 	if len(fields) < 2 {
 		return "", nil, errors.New("bad path")
@@ -106,7 +110,7 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 	if fsCached, ok := s.fs.Get(toStrip); ok {
 		if fsCachedTyped, ok := fsCached.(obsoleteTrackingFS); ok {
 			if !fsCachedTyped.isObsolete() {
-				return toStrip, fsCachedTyped.fs.ToHTTPFileSystem(ctx), nil
+				return toStrip, fsCachedTyped.fs, nil
 			}
 		}
 	}
@@ -130,33 +134,220 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 
 	s.fs.Add(toStrip, obsoleteTrackingFS{fs: tlfFS, ch: fsLifeCh})
 
+	return toStrip, tlfFS, nil
+}
+
+func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
+	toStrip string, fs http.FileSystem, err error) {
+	toStrip, tlfFS, err := s.getFS(ctx, requestPath)
+	if err != nil {
+		return "", nil, err
+	}
 	return toStrip, tlfFS.ToHTTPFileSystem(ctx), nil
 }
 
+// getNode returns the node at `requestPath`, which starts with the
+// TLF type and name.
+func (s *Server) getNode(ctx context.Context, requestPath string) (
+	libkbfs.Node, error) {
+	toStrip, tlfFS, err := s.getFS(ctx, requestPath)
+	if err != nil {
+		return nil, err
+	}
+	node := tlfFS.RootNode()
+	for _, name := range strings.Split(
+		strings.TrimPrefix(requestPath, toStrip), "/") {
+		if name == "" {
+			continue
+		}
+		node, _, err = s.config.KBFSOps().Lookup(ctx, node, name)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			// A symlink.
+			return nil, libkbfs.NoSuchNameError{Name: name}
+		}
+	}
+	return node, nil
+}
+
+// getScopedHTTPFileSystem is like getHTTPFileSystem, but for requests
+// made with a capability token scoped to `scope`.  If the scope is a
+// directory, the returned file system is chrooted to it, so symlinks
+// under it can't lead out of it.  A file has nothing under it, so a
+// file's scope is served from the root of its TLF.
+func (s *Server) getScopedHTTPFileSystem(ctx context.Context, scope string) (
+	toStrip string, fs http.FileSystem, err error) {
+	toStrip, tlfFS, err := s.getFS(ctx, scope)
+	if err != nil {
+		return "", nil, err
+	}
+	tlfFS = tlfFS.WithContext(ctx)
+	subdir := strings.TrimPrefix(strings.TrimPrefix(scope, toStrip), "/")
+	fi, err := tlfFS.Stat(subdir)
+	if err != nil {
+		return "", nil, err
+	}
+	if !fi.IsDir() {
+		return toStrip, tlfFS.ToHTTPFileSystem(ctx), nil
+	}
+	scopedFS, err := tlfFS.ChrootAsLibFS(subdir)
+	if err != nil {
+		return "", nil, err
+	}
+	return scope, scopedFS.ToHTTPFileSystem(ctx), nil
+}
+
+// checkToken checks that the request's token, either one from
+// NewToken or a capability token, grants access to `fsPath`.  For a
+// capability token, it also returns the path the token is scoped to.
+func (s *Server) checkToken(
+	w http.ResponseWriter, req *http.Request, fsPath string) (
+	scope string, ok bool) {
+	token := req.URL.Query().Get("token")
+	if len(token) == 0 {
+		s.logger.Info("Missing token")
+		s.handleInvalidToken(w)
+		return "", false
+	}
+	if s.tokens.Contains(token) {
+		return "", true
+	}
+	scope, err := checkCapabilityToken(
+		s.capabilityKey, token, fsPath, s.config.Clock().Now())
+	if err != nil {
+		s.logger.Info("Invalid token %q: %v", token, err)
+		s.handleInvalidToken(w)
+		return "", false
+	}
+	return scope, true
+}
+
 // serve accepts "/<fs path>?token=<token>"
 // For example:
 //     /team/keybase/file.txt?token=1234567890abcdef1234567890abcdef
 func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
 	s.logger.Debug("Incoming request from %q: %s", req.UserAgent(), req.URL)
-	token := req.URL.Query().Get("token")
-	if len(token) == 0 || !s.tokens.Contains(token) {
-		s.logger.Info("Invalid token %q", token)
-		s.handleInvalidToken(w)
+	scope, ok := s.checkToken(w, req, req.URL.Path)
+	if !ok {
 		return
 	}
-	toStrip, fs, err := s.getHTTPFileSystem(req.Context(), req.URL.Path)
-	if err != nil {
+	ctx := libkbfs.CtxWithRequestSource(req.Context(), libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendHTTP,
+		Action:   req.Method,
+	})
+	var toStrip string
+	var fs http.FileSystem
+	var err error
+	if scope == "" {
+		toStrip, fs, err = s.getHTTPFileSystem(ctx, req.URL.Path)
+	} else {
+		toStrip, fs, err = s.getScopedHTTPFileSystem(ctx, scope)
+	}
+	switch {
+	case os.IsNotExist(errors.Cause(err)):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
 		s.logger.Warning("Bad request; error=%v", err)
 		s.handleBadRequest(w)
 		return
 	}
+	if scope != "" && req.URL.Path == toStrip {
+		// The file server can't see that the chroot directory was
+		// requested without a trailing slash, so redirect like it
+		// would, to keep the relative links in its listing working.
+		target := path.Base(toStrip) + "/"
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	s.setSniffedContentType(ctx, w, req.URL.Path)
 	http.StripPrefix(toStrip, http.FileServer(fs)).ServeHTTP(
 		newContentTypeOverridingResponseWriter(w), req)
 }
 
+// setSniffedContentType sets the Content-Type of the response to the
+// type KBFS sniffed for the file at `requestPath`, if the file's
+// extension doesn't give it one.  This saves the file server from
+// reading the start of the file again to sniff it itself.
+func (s *Server) setSniffedContentType(
+	ctx context.Context, w http.ResponseWriter, requestPath string) {
+	if mime.TypeByExtension(path.Ext(requestPath)) != "" {
+		return
+	}
+	node, err := s.getNode(ctx, requestPath)
+	if err != nil {
+		// Let the file server deal with it.
+		return
+	}
+	ei, err := s.config.KBFSOps().Stat(ctx, node)
+	if err != nil || ei.MimeType == "" {
+		return
+	}
+	w.Header().Set("Content-Type", ei.MimeType)
+}
+
+var derivedDataKinds = map[string]libkbfs.DerivedDataKind{
+	string(libkbfs.DerivedDataThumbnail): libkbfs.DerivedDataThumbnail,
+	string(libkbfs.DerivedDataPreview):   libkbfs.DerivedDataPreview,
+}
+
+// serveDerived accepts "/<kind>/<fs path>?token=<token>", where kind
+// is "thumbnail" or "preview".  For example:
+//     /thumbnail/team/keybase/cat.jpg?token=1234567890abcdef1234567890abcdef
+func (s *Server) serveDerived(w http.ResponseWriter, req *http.Request) {
+	s.logger.Debug("Incoming request from %q: %s", req.UserAgent(), req.URL)
+	fields := strings.SplitN(req.URL.Path, "/", 2)
+	if len(fields) < 2 {
+		fields = append(fields, "")
+	}
+	if _, ok := s.checkToken(w, req, fields[1]); !ok {
+		return
+	}
+	kind, ok := derivedDataKinds[fields[0]]
+	if !ok || fields[1] == "" {
+		s.handleBadRequest(w)
+		return
+	}
+	ctx := libkbfs.CtxWithRequestSource(req.Context(), libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendHTTP,
+		Action:   req.Method,
+	})
+	node, err := s.getNode(ctx, fields[1])
+	switch errors.Cause(err).(type) {
+	case nil:
+	case libkbfs.NoSuchNameError:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	default:
+		s.logger.Warning("Bad request; error=%v", err)
+		s.handleBadRequest(w)
+		return
+	}
+	data, err := s.derived.Get(ctx, node, kind)
+	switch errors.Cause(err).(type) {
+	case nil:
+	case libkbfs.NoDerivedDataGeneratorError:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	default:
+		s.logger.Warning("Couldn't generate a %s; error=%v", kind, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", data.MimeType)
+	w.Write(data.Data)
+}
+
 const portStart = 16723
 const portEnd = 18000
 const requestPathRoot = "/files/"
+const derivedRequestPathRoot = "/derived/"
 
 func (s *Server) idempotentlyStart() (err error) {
 	s.startedLock.Lock()
@@ -170,6 +361,8 @@ func (s *Server) idempotentlyStart() (err error) {
 	}
 	s.server.Handle(requestPathRoot,
 		http.StripPrefix(requestPathRoot, http.HandlerFunc(s.serve)))
+	s.server.Handle(derivedRequestPathRoot, http.StripPrefix(
+		derivedRequestPathRoot, http.HandlerFunc(s.serveDerived)))
 	s.started = true
 	return nil
 }
@@ -196,9 +389,15 @@ func (s *Server) monitorAppState(ctx context.Context) {
 	}
 }
 
-// New creates and starts a new server.
+// New creates and starts a new server.  The server only ever needs to
+// read from KBFS, so requests made through it are limited to that.
 func New(g *libkb.GlobalContext, config libkbfs.Config) (
 	s *Server, err error) {
+	config.SetFrontendCapabilities(
+		libkbfs.RequestFrontendHTTP, libkbfs.OpCapsReadOnly)
+	if kbfsOps, ok := config.KBFSOps().(*libkbfs.KBFSOpsStandard); ok {
+		kbfsOps.SetMimeTypeSniffing(libkbfs.RequestFrontendHTTP, true)
+	}
 	s = &Server{
 		g:      g,
 		config: config,
@@ -209,9 +408,17 @@ func New(g *libkb.GlobalContext, config libkbfs.Config) (
 	if s.tokens, err = lru.New(tokenCacheSize); err != nil {
 		return nil, err
 	}
+	if _, err = rand.Read(s.capabilityKey[:]); err != nil {
+		return nil, err
+	}
 	if s.fs, err = lru.New(fsCacheSize); err != nil {
 		return nil, err
 	}
+	if s.derived, err = libkbfs.NewDerivedDataCache(
+		config, libkbfs.DefaultDerivedDataCacheCapacity); err != nil {
+		return nil, err
+	}
+	s.derived.RegisterGenerator(libkbfs.NewImageGenerator())
 	if err = s.idempotentlyStart(); err != nil {
 		return nil, err
 	}
@@ -5,12 +5,17 @@
 package libhttpserver
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/png"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
@@ -90,4 +95,175 @@ func TestServerDefault(t *testing.T) {
 		"http://%s/files/blah/alice,bob/non-existent?token=%s", addr, token))
 	require.NoError(t, err)
 	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	capToken, err := s.NewCapabilityToken(
+		"private/alice,bob/test.txt", time.Minute)
+	require.NoError(t, err)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/files/private/alice,bob/test.txt?token=%s", addr, capToken))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/files/private/alice,bob/non-existent?token=%s",
+		addr, capToken))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	expiredToken, err := s.NewCapabilityToken(
+		"private/alice,bob/test.txt", -time.Minute)
+	require.NoError(t, err)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/files/private/alice,bob/test.txt?token=%s",
+		addr, expiredToken))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestServerCapabilityTokenSymlinks(t *testing.T) {
+	kbfsConfig, shutdown := makeTestKBFSConfig(t)
+	defer shutdown()
+
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, kbfsConfig.KBPKI(), kbfsConfig.MDOps(), "alice,bob", tlf.Private)
+	require.NoError(t, err)
+	kbfsOps := kbfsConfig.KBFSOps()
+	root, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	secret, _, err := kbfsOps.CreateFile(ctx, root, "secret.txt", false, false)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, secret, []byte("secret"), 0)
+	require.NoError(t, err)
+	scoped, _, err := kbfsOps.CreateDir(ctx, root, "scoped")
+	require.NoError(t, err)
+	n, _, err := kbfsOps.CreateFile(ctx, scoped, "ok.txt", false, false)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("ok"), 0)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, scoped, "link", "../secret.txt")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, scoped, "okLink", "ok.txt")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(kbfsConfig)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx,
+		root.GetFolderBranch().Tlf, nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	s, err := New(libkb.NewGlobalContext().Init(), kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+	capToken, err := s.NewCapabilityToken(
+		"private/alice,bob/scoped", time.Minute)
+	require.NoError(t, err)
+
+	get := func(fsPath, token string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf(
+			"http://%s/files/%s?token=%s", addr, fsPath, token))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("private/alice,bob/scoped", capToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `href="ok.txt"`)
+
+	code, body = get("private/alice,bob/scoped/ok.txt", capToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body)
+
+	code, body = get("private/alice,bob/scoped/okLink", capToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body)
+
+	t.Log("A symlink out of the scope isn't followed")
+	code, body = get("private/alice,bob/scoped/link", capToken)
+	require.NotEqual(t, http.StatusOK, code)
+	require.NotContains(t, body, "secret")
+
+	t.Log("The same symlink works with a token for everything")
+	code, body = get("private/alice,bob/scoped/link", token)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "secret", body)
+}
+
+func TestServerDerived(t *testing.T) {
+	kbfsConfig, shutdown := makeTestKBFSConfig(t)
+	defer shutdown()
+
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, kbfsConfig.KBPKI(), kbfsConfig.MDOps(), "alice,bob", tlf.Private)
+	require.NoError(t, err)
+	kbfsOps := kbfsConfig.KBFSOps()
+	root, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	n, _, err := kbfsOps.CreateFile(ctx, root, "test.png", false, false)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 512, 512)))
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, buf.Bytes(), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(kbfsConfig)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx,
+		root.GetFolderBranch().Tlf, nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	s, err := New(libkb.NewGlobalContext().Init(), kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+
+	resp, err := http.Get(fmt.Sprintf(
+		"http://%s/derived/thumbnail/private/alice,bob/test.png", addr))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/derived/thumbnail/private/alice,bob/test.png?token=%s",
+		addr, token))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	img, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 256, 256), img.Bounds())
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/derived/thumbnail/private/alice,bob/test.txt?token=%s",
+		addr, token))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/derived/thumbnail/private/alice,bob/missing.png?token=%s",
+		addr, token))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf(
+		"http://%s/derived/sketch/private/alice,bob/test.png?token=%s",
+		addr, token))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
@@ -0,0 +1,364 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+// advisoryLockTryTimeout is how long a non-waiting advisory lock
+// request waits for the MD server to say whether another device
+// holds the file's lock.
+const advisoryLockTryTimeout = 2 * time.Second
+
+// AdvisoryLockType is the type of an advisory file lock.
+type AdvisoryLockType int
+
+const (
+	// AdvisoryUnlock removes the caller's locks in a range.
+	AdvisoryUnlock AdvisoryLockType = iota
+	// AdvisoryReadLock is a shared lock: any number of owners can
+	// hold read locks on overlapping ranges at once.
+	AdvisoryReadLock
+	// AdvisoryWriteLock is an exclusive lock: no other owner can
+	// hold any lock that overlaps its range.
+	AdvisoryWriteLock
+)
+
+func (t AdvisoryLockType) String() string {
+	switch t {
+	case AdvisoryUnlock:
+		return "unlock"
+	case AdvisoryReadLock:
+		return "read"
+	case AdvisoryWriteLock:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+// AdvisoryLock describes an advisory lock on a byte range of a file,
+// like an fcntl(2) record lock.  A whole-file lock is one with `Off`
+// 0 and `End` -1.
+type AdvisoryLock struct {
+	Type AdvisoryLockType
+	// Owner identifies who holds the lock, like a process or an
+	// open file description.  Locks with the same owner never
+	// conflict; a new lock replaces the owner's old locks in its
+	// range instead.
+	Owner uint64
+	// Off and End delimit the half-inclusive range [Off, End).  An
+	// `End` of -1 means the range extends to the end of the file,
+	// however big the file gets.
+	Off, End int64
+}
+
+func (l AdvisoryLock) overlaps(off, end int64) bool {
+	return (l.End == -1 || off < l.End) && (end == -1 || l.Off < end)
+}
+
+func (l AdvisoryLock) conflictsWith(other AdvisoryLock) bool {
+	return l.Owner != other.Owner && l.overlaps(other.Off, other.End) &&
+		(l.Type == AdvisoryWriteLock || other.Type == AdvisoryWriteLock)
+}
+
+// fileAdvisoryLocks holds the advisory locks taken on one file by
+// this device.
+type fileAdvisoryLocks struct {
+	// lockID names the file's lock in the MD server's lock namespace
+	// for the TLF.  It's derived from the path the file had when
+	// its first lock was taken.
+	lockID keybase1.LockID
+	locks  []AdvisoryLock
+	// serverLocked is true once the MD server lock is held, and
+	// serverLocking is true while it's being taken.
+	serverLocked  bool
+	serverLocking bool
+	// changedCh is closed, and replaced, whenever any lock on the
+	// file is released, or taking the MD server lock finishes.
+	changedCh chan struct{}
+}
+
+func (fal *fileAdvisoryLocks) signalChanged() {
+	close(fal.changedCh)
+	fal.changedCh = make(chan struct{})
+}
+
+// conflict returns a lock that conflicts with `lock`, if any.
+func (fal *fileAdvisoryLocks) conflict(lock AdvisoryLock) (
+	AdvisoryLock, bool) {
+	for _, l := range fal.locks {
+		if l.conflictsWith(lock) {
+			return l, true
+		}
+	}
+	return AdvisoryLock{}, false
+}
+
+// remove removes the parts of `owner`'s locks that fall within [off,
+// end), splitting locks that straddle the range.  It returns whether
+// anything was removed.
+func (fal *fileAdvisoryLocks) remove(owner uint64, off, end int64) bool {
+	removed := false
+	locks := make([]AdvisoryLock, 0, len(fal.locks))
+	for _, l := range fal.locks {
+		if l.Owner != owner || !l.overlaps(off, end) {
+			locks = append(locks, l)
+			continue
+		}
+		removed = true
+		if l.Off < off {
+			before := l
+			before.End = off
+			locks = append(locks, before)
+		}
+		if end != -1 && (l.End == -1 || l.End > end) {
+			after := l
+			after.Off = end
+			locks = append(locks, after)
+		}
+	}
+	fal.locks = locks
+	return removed
+}
+
+// advisoryLockManager keeps track of the advisory locks on the files
+// of one folder-branch.  Between owners on this device, locks behave
+// like POSIX record locks.  To keep other devices out, the manager
+// also holds a per-file lock in the MD server's lock namespace for
+// the TLF, for as long as this device holds any lock on the file.
+// Since that lock is exclusive and covers the whole file, any lock
+// on a file excludes every lock on it from other devices, even
+// shared or non-overlapping ones.  The MD server expires its locks
+// after a while, so the cross-device protection is only meant for
+// short critical sections, like database transactions.
+type advisoryLockManager struct {
+	config Config
+	fb     FolderBranch
+	log    logger.Logger
+
+	lock  sync.Mutex
+	files map[NodeID]*fileAdvisoryLocks
+}
+
+func newAdvisoryLockManager(
+	config Config, fb FolderBranch, log logger.Logger) *advisoryLockManager {
+	return &advisoryLockManager{
+		config: config,
+		fb:     fb,
+		log:    log,
+		files:  make(map[NodeID]*fileAdvisoryLocks),
+	}
+}
+
+// advisoryLockIDForPath returns the MD server lock ID used for the
+// advisory locks on the file at `p`.  Every client must agree on it,
+// so it must never change.
+func advisoryLockIDForPath(p path) keybase1.LockID {
+	names := make([]string, 0, len(p.path)-1)
+	for _, node := range p.path[1:] {
+		names = append(names, node.Name)
+	}
+	return keybase1.LockIDFromBytes(
+		[]byte("advisory/" + strings.Join(names, "/")))
+}
+
+// takeServerLock takes the MD server lock `lockID`.  If `wait` is
+// false, it gives up with an AdvisoryLockConflictError if the lock
+// isn't free soon.
+func (alm *advisoryLockManager) takeServerLock(
+	ctx context.Context, lockID keybase1.LockID, wait bool) error {
+	if wait {
+		return alm.config.MDServer().Lock(ctx, alm.fb.Tlf, lockID)
+	}
+	tryCtx, cancel := context.WithTimeout(ctx, advisoryLockTryTimeout)
+	defer cancel()
+	err := alm.config.MDServer().Lock(tryCtx, alm.fb.Tlf, lockID)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return AdvisoryLockConflictError{}
+	}
+	return err
+}
+
+func (alm *advisoryLockManager) releaseServerLock(
+	ctx context.Context, lockID keybase1.LockID) {
+	err := alm.config.MDServer().ReleaseLock(ctx, alm.fb.Tlf, lockID)
+	if err != nil {
+		alm.log.CDebugf(ctx, "Couldn't release advisory lock %d: %+v",
+			lockID, err)
+	}
+}
+
+// setLock takes `lock` on the file with node ID `id`, whose current
+// path is `p`.  If `wait` is false and a conflicting lock is held, it
+// returns an AdvisoryLockConflictError instead of waiting for the
+// conflicting lock to be released.
+func (alm *advisoryLockManager) setLock(ctx context.Context, id NodeID,
+	p path, lock AdvisoryLock, wait bool) error {
+	for {
+		alm.lock.Lock()
+		fal, ok := alm.files[id]
+		if !ok {
+			fal = &fileAdvisoryLocks{
+				lockID:    advisoryLockIDForPath(p),
+				changedCh: make(chan struct{}),
+			}
+			alm.files[id] = fal
+		}
+
+		var waitCh chan struct{}
+		if conflict, ok := fal.conflict(lock); ok {
+			if !wait {
+				alm.lock.Unlock()
+				return AdvisoryLockConflictError{&conflict}
+			}
+			waitCh = fal.changedCh
+		} else if fal.serverLocking {
+			waitCh = fal.changedCh
+		}
+		if waitCh != nil {
+			alm.lock.Unlock()
+			select {
+			case <-waitCh:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if fal.serverLocked {
+			fal.remove(lock.Owner, lock.Off, lock.End)
+			fal.locks = append(fal.locks, lock)
+			alm.lock.Unlock()
+			return nil
+		}
+
+		// Take the MD server lock without holding `alm.lock`, since
+		// it can take a long time, and then check for conflicts
+		// again.
+		fal.serverLocking = true
+		alm.lock.Unlock()
+		err := alm.takeServerLock(ctx, fal.lockID, wait)
+		alm.lock.Lock()
+		fal.serverLocking = false
+		fal.serverLocked = err == nil
+		if err != nil && len(fal.locks) == 0 {
+			delete(alm.files, id)
+		}
+		fal.signalChanged()
+		alm.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unlock releases `owner`'s locks within [off, end) on the file with
+// node ID `id`, and the MD server lock if that was this device's last
+// lock on the file.
+func (alm *advisoryLockManager) unlock(
+	ctx context.Context, id NodeID, owner uint64, off, end int64) {
+	alm.lock.Lock()
+	defer alm.lock.Unlock()
+	fal, ok := alm.files[id]
+	if !ok || !fal.remove(owner, off, end) {
+		return
+	}
+	fal.signalChanged()
+	if len(fal.locks) > 0 || fal.serverLocking {
+		return
+	}
+	delete(alm.files, id)
+	// Release the server lock while still holding `alm.lock`, so
+	// that a new lock on the file can't take the server lock before
+	// it's released.
+	if fal.serverLocked {
+		alm.releaseServerLock(ctx, fal.lockID)
+	}
+}
+
+// getConflict returns the first lock held on this device that
+// conflicts with `lock`, if any.  Locks held by other devices aren't
+// reported.
+func (alm *advisoryLockManager) getConflict(
+	id NodeID, lock AdvisoryLock) (AdvisoryLock, bool) {
+	alm.lock.Lock()
+	defer alm.lock.Unlock()
+	fal, ok := alm.files[id]
+	if !ok {
+		return AdvisoryLock{}, false
+	}
+	return fal.conflict(lock)
+}
+
+// shutdown releases all the MD server locks held for advisory locks.
+func (alm *advisoryLockManager) shutdown(ctx context.Context) {
+	alm.lock.Lock()
+	defer alm.lock.Unlock()
+	for id, fal := range alm.files {
+		if fal.serverLocked {
+			alm.releaseServerLock(ctx, fal.lockID)
+		}
+		delete(alm.files, id)
+		fal.signalChanged()
+	}
+}
+
+// SetAdvisoryLock implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetAdvisoryLock(ctx context.Context,
+	file Node, lock AdvisoryLock, wait bool) (err error) {
+	fbo.log.CDebugf(ctx, "SetAdvisoryLock %s %s %d [%d, %d) wait=%t",
+		getNodeIDStr(file), lock.Type, lock.Owner, lock.Off, lock.End, wait)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetAdvisoryLock %s done: %+v",
+			getNodeIDStr(file), err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return err
+	}
+	// Files can be unlocked even after they're unlinked.
+	if lock.Type == AdvisoryUnlock {
+		fbo.advisoryLocks.unlock(
+			ctx, file.GetID(), lock.Owner, lock.Off, lock.End)
+		return nil
+	}
+	p, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return err
+	}
+	return fbo.advisoryLocks.setLock(ctx, file.GetID(), p, lock, wait)
+}
+
+// GetAdvisoryLock implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetAdvisoryLock(ctx context.Context,
+	file Node, lock AdvisoryLock) (conflict AdvisoryLock, err error) {
+	fbo.log.CDebugf(ctx, "GetAdvisoryLock %s %s %d [%d, %d)",
+		getNodeIDStr(file), lock.Type, lock.Owner, lock.Off, lock.End)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetAdvisoryLock %s done: %+v",
+			getNodeIDStr(file), err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return AdvisoryLock{}, err
+	}
+	conflict, ok := fbo.advisoryLocks.getConflict(file.GetID(), lock)
+	if !ok {
+		return AdvisoryLock{Type: AdvisoryUnlock}, nil
+	}
+	return conflict, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvisoryLocks(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	requireConflict := func(lock AdvisoryLock) {
+		err := kbfsOps.SetAdvisoryLock(ctx, fNode, lock, false)
+		conflictErr, ok := errors.Cause(err).(AdvisoryLockConflictError)
+		require.True(t, ok, "Unexpected error: %+v", err)
+		require.NotNil(t, conflictErr.Conflict)
+		conflict, err := kbfsOps.GetAdvisoryLock(ctx, fNode, lock)
+		require.NoError(t, err)
+		require.Equal(t, *conflictErr.Conflict, conflict)
+	}
+
+	t.Log("Overlapping read locks don't conflict")
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryReadLock, Owner: 1, Off: 0, End: 10}, false)
+	require.NoError(t, err)
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryReadLock, Owner: 2, Off: 5, End: 15}, false)
+	require.NoError(t, err)
+	requireConflict(AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 3, Off: 0, End: -1})
+	conflict, err := kbfsOps.GetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 3, Off: 15, End: -1})
+	require.NoError(t, err)
+	require.Equal(t, AdvisoryUnlock, conflict.Type)
+
+	t.Log("An owner can upgrade its own lock, unless others hold it too")
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 1, Off: 0, End: 5}, false)
+	require.NoError(t, err)
+	requireConflict(AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 1, Off: 0, End: 10})
+
+	t.Log("Unlocking part of a range keeps the rest locked")
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryUnlock, Owner: 2, Off: 0, End: -1}, false)
+	require.NoError(t, err)
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryUnlock, Owner: 1, Off: 2, End: 8}, false)
+	require.NoError(t, err)
+	err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 3, Off: 2, End: 8}, false)
+	require.NoError(t, err)
+	requireConflict(AdvisoryLock{
+		Type: AdvisoryWriteLock, Owner: 2, Off: 8, End: 9})
+
+	t.Log("Another device can't lock the file at all")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	fNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "f")
+	require.NoError(t, err)
+	lock2 := AdvisoryLock{Type: AdvisoryReadLock, Owner: 1, Off: 100, End: 200}
+	err = kbfsOps2.SetAdvisoryLock(ctx, fNode2, lock2, false)
+	require.Equal(t, AdvisoryLockConflictError{}, errors.Cause(err))
+
+	t.Log("Once this device unlocks everything, the other device gets it")
+	lockedCh := make(chan error, 1)
+	go func() {
+		lockedCh <- kbfsOps2.SetAdvisoryLock(ctx, fNode2, lock2, true)
+	}()
+	select {
+	case err := <-lockedCh:
+		t.Fatalf("Lock was taken early: %+v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	for _, owner := range []uint64{1, 3} {
+		err = kbfsOps.SetAdvisoryLock(ctx, fNode, AdvisoryLock{
+			Type: AdvisoryUnlock, Owner: owner, Off: 0, End: -1}, false)
+		require.NoError(t, err)
+	}
+	select {
+	case err := <-lockedCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	err = kbfsOps2.SetAdvisoryLock(ctx, fNode2, AdvisoryLock{
+		Type: AdvisoryUnlock, Owner: 1, Off: 0, End: -1}, false)
+	require.NoError(t, err)
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+// BlockCompression says how each encoded block is compressed before
+// it's padded and encrypted.  The algorithm is recorded in the
+// block's padding header, so blocks written with any compression, or
+// none, can always be read, whatever the current setting is.  Blocks
+// that don't get smaller are stored uncompressed.  Compressed blocks
+// are written under CompressedBlocksDataVer, so clients that predate
+// compression get a NewDataVersionError instead of misreading the
+// header; configs with an older DataVersion() don't compress at all.
+type BlockCompression int
+
+const (
+	// BlockCompressionNone stores blocks uncompressed.  Blocks
+	// written before compression existed look like this.
+	BlockCompressionNone BlockCompression = iota
+	// BlockCompressionSnappy compresses blocks with snappy, which is
+	// fast enough not to slow down writes noticeably.
+	BlockCompressionSnappy
+	// BlockCompressionFlate compresses blocks with DEFLATE, which is
+	// slower than snappy, but usually saves more space.
+	BlockCompressionFlate
+)
+
+func (c BlockCompression) String() string {
+	switch c {
+	case BlockCompressionNone:
+		return "none"
+	case BlockCompressionSnappy:
+		return "snappy"
+	case BlockCompressionFlate:
+		return "flate"
+	default:
+		return fmt.Sprintf("BlockCompression(%d)", int(c))
+	}
+}
+
+// ParseBlockCompression parses a BlockCompression from its string
+// form.
+func ParseBlockCompression(s string) (BlockCompression, error) {
+	switch s {
+	case "none":
+		return BlockCompressionNone, nil
+	case "snappy":
+		return BlockCompressionSnappy, nil
+	case "flate":
+		return BlockCompressionFlate, nil
+	default:
+		return 0, errors.Errorf("Unknown block compression %q", s)
+	}
+}
+
+// Set implements the flag.Value interface for BlockCompression.
+func (c *BlockCompression) Set(s string) error {
+	compression, err := ParseBlockCompression(s)
+	if err != nil {
+		return err
+	}
+	*c = compression
+	return nil
+}
+
+// The compression algorithm of a block is kept in the top bits of
+// the length prefix of its padded form, which are always zero in
+// blocks written before compression existed.
+const (
+	blockCompressionShift   = 30
+	blockCompressionLenMask = 1<<blockCompressionShift - 1
+)
+
+// maxDecompressedBlockSize bounds how big a compressed block may
+// claim to be once decompressed.
+const maxDecompressedBlockSize = blockCompressionLenMask
+
+// compressBlock compresses `encodedBlock` with `c`.  If that doesn't
+// make it any smaller, it returns `encodedBlock` itself, along with
+// BlockCompressionNone.
+func compressBlock(encodedBlock []byte, c BlockCompression) (
+	[]byte, BlockCompression, error) {
+	var compressed []byte
+	switch c {
+	case BlockCompressionNone:
+		return encodedBlock, BlockCompressionNone, nil
+	case BlockCompressionSnappy:
+		compressed = snappy.Encode(nil, encodedBlock)
+	case BlockCompressionFlate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		if _, err := w.Write(encodedBlock); err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		compressed = buf.Bytes()
+	default:
+		return nil, 0, errors.Errorf("Unknown block compression %s", c)
+	}
+
+	if len(compressed) >= len(encodedBlock) {
+		return encodedBlock, BlockCompressionNone, nil
+	}
+	return compressed, c, nil
+}
+
+// decompressBlock undoes compressBlock.
+func decompressBlock(block []byte, c BlockCompression) ([]byte, error) {
+	switch c {
+	case BlockCompressionNone:
+		return block, nil
+	case BlockCompressionSnappy:
+		n, err := snappy.DecodedLen(block)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if n > maxDecompressedBlockSize {
+			return nil, errors.Errorf(
+				"Decompressed block would be %d bytes", n)
+		}
+		decoded, err := snappy.Decode(nil, block)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return decoded, nil
+	case BlockCompressionFlate:
+		r := flate.NewReader(bytes.NewReader(block))
+		defer r.Close()
+		decoded, err := ioutil.ReadAll(
+			io.LimitReader(r, maxDecompressedBlockSize+1))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(decoded) > maxDecompressedBlockSize {
+			return nil, errors.Errorf(
+				"Decompressed block is over %d bytes",
+				maxDecompressedBlockSize)
+		}
+		return decoded, nil
+	default:
+		return nil, errors.Errorf("Unknown block compression %s", c)
+	}
+}
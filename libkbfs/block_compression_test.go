@@ -0,0 +1,73 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlockCompression(t *testing.T) {
+	for _, c := range []BlockCompression{
+		BlockCompressionNone, BlockCompressionSnappy,
+		BlockCompressionFlate} {
+		parsed, err := ParseBlockCompression(c.String())
+		require.NoError(t, err)
+		require.Equal(t, c, parsed)
+	}
+	_, err := ParseBlockCompression("lzma")
+	require.Error(t, err)
+}
+
+func TestCryptoCommonEncryptBlockCompressed(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+	key := kbfscrypto.BlockCryptKey{}
+	block := &FileBlock{Contents: make([]byte, 64*1024)}
+	for i := range block.Contents {
+		block.Contents[i] = byte(i % 7)
+	}
+
+	plainSize, _, uncompressed, err := c.encryptBlockWithPadding(
+		block, key, BlockPaddingMinimal, BlockCompressionNone)
+	require.NoError(t, err)
+	for _, compression := range []BlockCompression{
+		BlockCompressionSnappy, BlockCompressionFlate} {
+		compressedSize, used, compressed, err := c.encryptBlockWithPadding(
+			block, key, BlockPaddingMinimal, compression)
+		require.NoError(t, err)
+		require.Equal(t, compression, used)
+		require.True(t, compressedSize < plainSize, "%s", compression)
+		require.True(t, len(compressed.EncryptedData) <
+			len(uncompressed.EncryptedData), "%s", compression)
+
+		var decrypted FileBlock
+		err = c.DecryptBlock(compressed, key, &decrypted)
+		require.NoError(t, err)
+		require.Equal(t, block.Contents, decrypted.Contents)
+	}
+
+	t.Log("Uncompressed blocks, like those written before " +
+		"compression existed, are still readable")
+	var decrypted FileBlock
+	err = c.DecryptBlock(uncompressed, key, &decrypted)
+	require.NoError(t, err)
+	require.Equal(t, block.Contents, decrypted.Contents)
+}
+
+func TestCompressBlockIncompressible(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+	for _, compression := range []BlockCompression{
+		BlockCompressionSnappy, BlockCompressionFlate} {
+		compressed, used, err := compressBlock(data, compression)
+		require.NoError(t, err)
+		require.Equal(t, BlockCompressionNone, used)
+		require.Equal(t, data, compressed)
+	}
+}
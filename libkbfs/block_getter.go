@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"fmt"
 
+	"github.com/keybase/client/go/logger"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"golang.org/x/net/context"
@@ -22,13 +23,21 @@ type blockGetter interface {
 // realBlockGetter obtains real blocks using the APIs available in Config.
 type realBlockGetter struct {
 	config blockOpsConfig
+	log    logger.Logger
 }
 
 // getBlock implements the interface for realBlockGetter.
 func (bg *realBlockGetter) getBlock(ctx context.Context, kmd KeyMetadata, blockPtr BlockPointer, block Block) error {
 	bserv := bg.config.BlockServer()
-	buf, blockServerHalf, err := bserv.Get(
-		ctx, kmd.TlfID(), blockPtr.ID, blockPtr.Context)
+	var buf []byte
+	var blockServerHalf kbfscrypto.BlockCryptKeyServerHalf
+	err := runWithRetry(ctx, bg.config.RetryPolicy(),
+		bg.config.MetricsRegistry(), bg.log, "BlockOps.Get",
+		func() (err error) {
+			buf, blockServerHalf, err = bserv.Get(
+				ctx, kmd.TlfID(), blockPtr.ID, blockPtr.Context)
+			return err
+		})
 	if err != nil {
 		// Temporary code to track down bad block
 		// requests. Remove when not needed anymore.
@@ -412,6 +412,33 @@ func (j *blockJournal) putData(
 	ctx context.Context, id kbfsblock.ID, context kbfsblock.Context,
 	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) (
 	putData bool, err error) {
+	return j.doPutData(ctx, true, id, context, buf, serverHalf)
+}
+
+// putDataAgain is like putData, but for blocks being put with a
+// non-zero refnonce, like the ones keyed by their contents (see
+// BlockSplitPolicy.Dedup).  Such a block may already be in the
+// journal under another reference, in which case only the new
+// reference is added.
+func (j *blockJournal) putDataAgain(
+	ctx context.Context, id kbfsblock.ID, context kbfsblock.Context,
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) (
+	putData bool, err error) {
+	hasData, err := j.s.hasData(id)
+	if err != nil {
+		return false, err
+	}
+	if hasData {
+		return false, j.addReference(ctx, id, context)
+	}
+	return j.doPutData(ctx, false, id, context, buf, serverHalf)
+}
+
+func (j *blockJournal) doPutData(
+	ctx context.Context, isRegularPut bool, id kbfsblock.ID,
+	context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) (
+	putData bool, err error) {
 	j.log.CDebugf(ctx, "Putting %d bytes of data for block %s with context %v",
 		len(buf), id, context)
 	defer func() {
@@ -427,7 +454,8 @@ func (j *blockJournal) putData(
 		return false, err
 	}
 
-	putData, err = j.s.put(true, id, context, buf, serverHalf, next.String())
+	putData, err = j.s.put(
+		isRegularPut, id, context, buf, serverHalf, next.String())
 	if err != nil {
 		return false, err
 	}
@@ -696,10 +724,19 @@ func (j *blockJournal) getNextEntriesToFlush(
 				return blockEntriesToFlush{}, kbfsmd.RevisionUninitialized, err
 			}
 
+			// Only putDataAgain puts blocks with a non-zero
+			// refnonce, and the server may already have those
+			// under another reference, so try just adding a
+			// reference to them first.
+			convergent := bctx.GetRefNonce() != kbfsblock.ZeroRefNonce
 			entries.puts.addNewBlock(
 				BlockPointer{ID: id, Context: bctx},
 				nil, /* only used by folderBranchOps */
-				ReadyBlockData{data, serverHalf}, nil)
+				ReadyBlockData{
+					buf:        data,
+					serverHalf: serverHalf,
+					convergent: convergent,
+				}, nil)
 
 		case addRefOp:
 			id, bctx, err := entry.getSingleContext()
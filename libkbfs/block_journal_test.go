@@ -257,6 +257,76 @@ func TestBlockJournalDuplicatePut(t *testing.T) {
 	require.Equal(t, int64(filesPerBlockMax), j.getStoredFiles())
 }
 
+func TestBlockJournalPutDataAgain(t *testing.T) {
+	ctx, cancel, tempdir, log, j := setupBlockJournalTest(t)
+	defer teardownBlockJournalTest(t, ctx, cancel, tempdir, j)
+
+	blockServer := NewBlockServerMemory(log)
+	tlfID := tlf.FakeID(1, tlf.Private)
+	bcache := NewBlockCacheStandard(0, 0)
+	reporter := NewReporterSimple(nil, 0)
+
+	uid1 := keybase1.MakeTestUID(1)
+	makeNewContext := func() kbfsblock.Context {
+		nonce, err := kbfsblock.MakeRefNonce()
+		require.NoError(t, err)
+		return kbfsblock.MakeContext(
+			uid1.AsUserOrTeam(), uid1.AsUserOrTeam(), nonce,
+			keybase1.BlockType_DATA)
+	}
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+
+	t.Log("Store one block on the server already")
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	err = blockServer.Put(ctx, tlfID, bID, kbfsblock.MakeFirstContext(
+		uid1.AsUserOrTeam(), keybase1.BlockType_DATA), data, serverHalf)
+	require.NoError(t, err)
+
+	t.Log("Putting the block again twice only stores it once")
+	bCtx := makeNewContext()
+	putData, err := j.putDataAgain(ctx, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	require.True(t, putData)
+	bCtx2 := makeNewContext()
+	putData, err = j.putDataAgain(ctx, bID, bCtx2, data, serverHalf)
+	require.NoError(t, err)
+	require.False(t, putData)
+	require.Equal(t, uint64(2), j.length())
+	require.Equal(t, int64(len(data)), j.getStoredBytes())
+	require.Equal(t, int64(len(data)), j.getUnflushedBytes())
+	getAndCheckBlockData(ctx, t, j, bID, bCtx, data, serverHalf)
+	getAndCheckBlockData(ctx, t, j, bID, bCtx2, data, serverHalf)
+
+	t.Log("Put a block the server doesn't have yet")
+	data2 := []byte{5, 6, 7, 8}
+	bID2, err := kbfsblock.MakePermanentID(data2)
+	require.NoError(t, err)
+	bCtx3 := makeNewContext()
+	putData, err = j.putDataAgain(ctx, bID2, bCtx3, data2, serverHalf)
+	require.NoError(t, err)
+	require.True(t, putData)
+
+	t.Log("Flushing references the stored block, and uploads the new one")
+	for i := 0; i < 3; i++ {
+		flushBlockJournalOne(
+			ctx, t, j, blockServer, bcache, reporter, tlfID)
+	}
+	require.Equal(t, uint64(0), j.length())
+	for _, bCtx := range []kbfsblock.Context{bCtx, bCtx2} {
+		buf, key, err := blockServer.Get(ctx, tlfID, bID, bCtx)
+		require.NoError(t, err)
+		require.Equal(t, data, buf)
+		require.Equal(t, serverHalf, key)
+	}
+	buf, key, err := blockServer.Get(ctx, tlfID, bID2, bCtx3)
+	require.NoError(t, err)
+	require.Equal(t, data2, buf)
+	require.Equal(t, serverHalf, key)
+}
+
 func TestBlockJournalAddReference(t *testing.T) {
 	ctx, cancel, tempdir, _, j := setupBlockJournalTest(t)
 	defer teardownBlockJournalTest(t, ctx, cancel, tempdir, j)
@@ -22,6 +22,10 @@ type blockOpsConfig interface {
 	diskBlockCacheGetter
 	syncedTlfGetterSetter
 	initModeGetter
+	networkConstraintsGetter
+	blockSplitPolicyGetter
+	retryPolicyGetter
+	metricsRegistryGetter
 }
 
 // BlockOpsStandard implements the BlockOps interface by relaying
@@ -37,7 +41,7 @@ var _ BlockOps = (*BlockOpsStandard)(nil)
 // NewBlockOpsStandard creates a new BlockOpsStandard
 func NewBlockOpsStandard(config blockOpsConfig,
 	queueSize, prefetchQueueSize int) *BlockOpsStandard {
-	bg := &realBlockGetter{config: config}
+	bg := &realBlockGetter{config: config, log: config.MakeLogger("")}
 	qConfig := &realBlockRetrievalConfig{
 		blockRetrievalPartialConfig: config,
 		bg: bg,
@@ -130,14 +134,41 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 		return
 	}
 
-	// New server key half for the block.
-	serverHalf, err := crypto.MakeRandomBlockCryptKeyServerHalf()
-	if err != nil {
-		return
+	var serverHalf kbfscrypto.BlockCryptKeyServerHalf
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	policy := b.config.BlockSplitPolicy()
+	compression := policy.Compression
+	if b.config.DataVersion() < CompressedBlocksDataVer {
+		// Compressed blocks need a data version this config
+		// can't write yet.
+		compression = BlockCompressionNone
+	}
+	var compressed BlockCompression
+	pe, canPad := crypto.(blockPaddingEncrypter)
+	convergent := canPad && policy.Dedup
+	switch {
+	case convergent:
+		plainSize, compressed, serverHalf, encryptedBlock, err =
+			pe.encryptBlockConvergent(
+				block, tlfCryptKey, policy.Padding, compression)
+	case canPad:
+		// New server key half for the block.
+		serverHalf, err = crypto.MakeRandomBlockCryptKeyServerHalf()
+		if err != nil {
+			return
+		}
+		blockKey := kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
+		plainSize, compressed, encryptedBlock, err =
+			pe.encryptBlockWithPadding(
+				block, blockKey, policy.Padding, compression)
+	default:
+		serverHalf, err = crypto.MakeRandomBlockCryptKeyServerHalf()
+		if err != nil {
+			return
+		}
+		blockKey := kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
+		plainSize, encryptedBlock, err = crypto.EncryptBlock(block, blockKey)
 	}
-
-	blockKey := kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
-	plainSize, encryptedBlock, err := crypto.EncryptBlock(block, blockKey)
 	if err != nil {
 		return
 	}
@@ -150,6 +181,8 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 	readyBlockData = ReadyBlockData{
 		buf:        buf,
 		serverHalf: serverHalf,
+		convergent: convergent,
+		compressed: compressed != BlockCompressionNone,
 	}
 
 	encodedSize := readyBlockData.GetEncodedSize()
@@ -179,7 +212,12 @@ func (b *BlockOpsStandard) Delete(ctx context.Context, tlfID tlf.ID,
 	for _, ptr := range ptrs {
 		contexts[ptr.ID] = append(contexts[ptr.ID], ptr.Context)
 	}
-	return b.config.BlockServer().RemoveBlockReferences(ctx, tlfID, contexts)
+	err = b.retry(ctx, "Delete", func() (err error) {
+		liveCounts, err = b.config.BlockServer().RemoveBlockReferences(
+			ctx, tlfID, contexts)
+		return err
+	})
+	return liveCounts, err
 }
 
 // Archive implements the BlockOps interface for BlockOpsStandard.
@@ -190,7 +228,18 @@ func (b *BlockOpsStandard) Archive(ctx context.Context, tlfID tlf.ID,
 		contexts[ptr.ID] = append(contexts[ptr.ID], ptr.Context)
 	}
 
-	return b.config.BlockServer().ArchiveBlockReferences(ctx, tlfID, contexts)
+	return b.retry(ctx, "Archive", func() error {
+		return b.config.BlockServer().ArchiveBlockReferences(
+			ctx, tlfID, contexts)
+	})
+}
+
+// retry runs fn, a call to the block server made on behalf of the
+// named BlockOps method, under the config's retry policy.
+func (b *BlockOpsStandard) retry(
+	ctx context.Context, method string, fn func() error) error {
+	return runWithRetry(ctx, b.config.RetryPolicy(),
+		b.config.MetricsRegistry(), b.log.Logger, "BlockOps."+method, fn)
 }
 
 // TogglePrefetcher implements the BlockOps interface for BlockOpsStandard.
@@ -18,6 +18,7 @@ import (
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -53,6 +54,10 @@ func (kmd fakeKeyMetadata) TlfID() tlf.ID {
 	return kmd.tlfID
 }
 
+func (kmd fakeKeyMetadata) LatestKeyGeneration() kbfsmd.KeyGen {
+	return kbfsmd.FirstValidKeyGen + kbfsmd.KeyGen(len(kmd.keys)) - 1
+}
+
 type fakeBlockKeyGetter struct{}
 
 func (kg fakeBlockKeyGetter) GetTLFCryptKeyForEncryption(
@@ -87,6 +92,7 @@ type testBlockOpsConfig struct {
 	diskBlockCacheGetter
 	*testSyncedTlfGetterSetter
 	initModeGetter
+	*testNetworkConstraintsGetter
 }
 
 var _ blockOpsConfig = (*testBlockOpsConfig)(nil)
@@ -111,6 +117,18 @@ func (config testBlockOpsConfig) DataVersion() DataVer {
 	return ChildHolesDataVer
 }
 
+func (config testBlockOpsConfig) BlockSplitPolicy() BlockSplitPolicy {
+	return DefaultBlockSplitPolicy()
+}
+
+func (config testBlockOpsConfig) RetryPolicy() RetryPolicy {
+	return nil
+}
+
+func (config testBlockOpsConfig) MetricsRegistry() metrics.Registry {
+	return nil
+}
+
 func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	lm := newTestLogMaker(t)
 	codecGetter := newTestCodecGetter()
@@ -120,7 +138,8 @@ func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	dbcg := newTestDiskBlockCacheGetter(t, nil)
 	stgs := newTestSyncedTlfGetterSetter()
 	return testBlockOpsConfig{codecGetter, lm, bserver, crypto, cache, dbcg,
-		stgs, testInitModeGetter{InitDefault}}
+		stgs, testInitModeGetter{InitDefault},
+		&testNetworkConstraintsGetter{}}
 }
 
 // TestBlockOpsReadySuccess checks that BlockOpsStandard.Ready()
@@ -167,6 +186,127 @@ func TestBlockOpsReadySuccess(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+type dedupBlockOpsConfig struct {
+	testBlockOpsConfig
+}
+
+func (config dedupBlockOpsConfig) BlockSplitPolicy() BlockSplitPolicy {
+	policy := DefaultBlockSplitPolicy()
+	policy.Dedup = true
+	return policy
+}
+
+// TestBlockOpsReadyDedup checks that BlockOpsStandard.Ready() gives
+// identical blocks the same ID and data when dedup is on.
+func TestBlockOpsReadyDedup(t *testing.T) {
+	config := dedupBlockOpsConfig{makeTestBlockOpsConfig(t)}
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops.Shutdown()
+
+	tlfID := tlf.FakeID(0, tlf.Private)
+	var latestKeyGen kbfsmd.KeyGen = 5
+	kmd := makeFakeKeyMetadata(tlfID, latestKeyGen)
+
+	ctx := context.Background()
+	block := &FileBlock{Contents: []byte{1, 2, 3, 4, 5}}
+	id, _, readyBlockData, err := bops.Ready(ctx, kmd, block)
+	require.NoError(t, err)
+	require.True(t, readyBlockData.convergent)
+
+	id2, _, readyBlockData2, err := bops.Ready(
+		ctx, kmd, &FileBlock{Contents: []byte{1, 2, 3, 4, 5}})
+	require.NoError(t, err)
+	require.Equal(t, id, id2)
+	require.Equal(t, readyBlockData, readyBlockData2)
+
+	id3, _, _, err := bops.Ready(
+		ctx, kmd, &FileBlock{Contents: []byte{1, 2, 3, 4, 6}})
+	require.NoError(t, err)
+	require.NotEqual(t, id, id3)
+
+	t.Log("The block decrypts like any other")
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	err = config.Codec().Decode(readyBlockData.buf, &encryptedBlock)
+	require.NoError(t, err)
+	blockCryptKey := kbfscrypto.UnmaskBlockCryptKey(
+		readyBlockData.serverHalf,
+		kmd.keys[latestKeyGen-kbfsmd.FirstValidKeyGen])
+	decryptedBlock := &FileBlock{}
+	err = config.cryptoPure().DecryptBlock(
+		encryptedBlock, blockCryptKey, decryptedBlock)
+	require.NoError(t, err)
+	require.Equal(t, block.Contents, decryptedBlock.Contents)
+}
+
+type compressingBlockOpsConfig struct {
+	testBlockOpsConfig
+	dataVer DataVer
+}
+
+func (config compressingBlockOpsConfig) DataVersion() DataVer {
+	return config.dataVer
+}
+
+func (config compressingBlockOpsConfig) BlockSplitPolicy() BlockSplitPolicy {
+	policy := DefaultBlockSplitPolicy()
+	policy.Compression = BlockCompressionSnappy
+	return policy
+}
+
+// TestBlockOpsReadyCompressedDataVersion checks that compressed blocks
+// get CompressedBlocksDataVer, so that clients with an older data
+// version refuse to read them, and that configs with an older data
+// version don't compress blocks at all.
+func TestBlockOpsReadyCompressedDataVersion(t *testing.T) {
+	testConfig := makeTestBlockOpsConfig(t)
+	config := compressingBlockOpsConfig{
+		testConfig, CompressedBlocksDataVer}
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops.Shutdown()
+	oldConfig := compressingBlockOpsConfig{
+		testConfig, AtLeastTwoLevelsOfChildrenDataVer}
+	oldBops := NewBlockOpsStandard(oldConfig,
+		testBlockRetrievalWorkerQueueSize, testPrefetchWorkerQueueSize)
+	defer oldBops.Shutdown()
+
+	tlfID := tlf.FakeID(0, tlf.Private)
+	kmd := makeFakeKeyMetadata(tlfID, kbfsmd.FirstValidKeyGen)
+	chargedTo := keybase1.MakeTestUID(1).AsUserOrTeam()
+
+	ctx := context.Background()
+	block := &FileBlock{Contents: make([]byte, 4096)}
+	info, _, readyBlockData, err := ReadyBlock(ctx, config.BlockCache(),
+		bops, config.cryptoPure(), kmd, block, chargedTo,
+		keybase1.BlockType_DATA)
+	require.NoError(t, err)
+	require.True(t, readyBlockData.compressed)
+	require.Equal(t, CompressedBlocksDataVer, info.DataVer)
+	err = config.bserver.Put(ctx, tlfID, info.ID, info.Context,
+		readyBlockData.buf, readyBlockData.serverHalf)
+	require.NoError(t, err)
+
+	t.Log("An older client gets a version error, not a garbled block")
+	err = oldBops.Get(
+		ctx, kmd, info.BlockPointer, &FileBlock{}, NoCacheEntry)
+	require.IsType(t, NewDataVersionError{}, errors.Cause(err))
+
+	decryptedBlock := &FileBlock{}
+	err = bops.Get(ctx, kmd, info.BlockPointer, decryptedBlock, NoCacheEntry)
+	require.NoError(t, err)
+	require.Equal(t, block.Contents, decryptedBlock.Contents)
+
+	t.Log("A config with an older data version doesn't compress")
+	info, _, readyBlockData, err = ReadyBlock(ctx, oldConfig.BlockCache(),
+		oldBops, oldConfig.cryptoPure(), kmd,
+		&FileBlock{Contents: make([]byte, 8192)}, chargedTo,
+		keybase1.BlockType_DATA)
+	require.NoError(t, err)
+	require.False(t, readyBlockData.compressed)
+	require.Equal(t, FirstValidDataVer, info.DataVer)
+}
+
 // TestBlockOpsReadyFailKeyGet checks that BlockOpsStandard.Ready()
 // fails properly if we fail to retrieve the key.
 func TestBlockOpsReadyFailKeyGet(t *testing.T) {
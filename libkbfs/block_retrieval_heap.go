@@ -20,6 +20,9 @@ func (brh blockRetrievalHeap) Less(i, j int) bool {
 	if reqI.priority < reqJ.priority {
 		return false
 	}
+	if reqI.fairOrder != reqJ.fairOrder {
+		return reqI.fairOrder < reqJ.fairOrder
+	}
 	return reqI.insertionOrder < reqJ.insertionOrder
 }
 
@@ -36,6 +36,7 @@ type blockRetrievalPartialConfig interface {
 	diskBlockCacheGetter
 	syncedTlfGetterSetter
 	initModeGetter
+	networkConstraintsGetter
 }
 
 type blockRetrievalConfig interface {
@@ -87,6 +88,10 @@ type blockRetrieval struct {
 	// state of global request counter when this retrieval was created;
 	// maintains FIFO
 	insertionOrder uint64
+	// the fair-queueing tag of the retrieval: among retrievals of the
+	// same priority, lower tags are processed first, which interleaves
+	// the requests of different TLFs
+	fairOrder uint64
 }
 
 // blockPtrLookup is used to uniquely identify block retrieval requests. The
@@ -100,12 +105,14 @@ type blockPtrLookup struct {
 }
 
 // blockRetrievalQueue manages block retrieval requests. Higher priority
-// requests are executed first. Requests are executed in FIFO order within a
-// given priority level.
+// requests are executed first. Within a given priority level, requests
+// for different TLFs take turns, so that one TLF with many outstanding
+// requests can't hold up the others, and requests for the same TLF are
+// executed in FIFO order.
 type blockRetrievalQueue struct {
 	config blockRetrievalConfig
 	log    logger.Logger
-	// protects ptrs, insertionCount, and the heap
+	// protects ptrs, insertionCount, fairness state, and the heap
 	mtx sync.RWMutex
 	// queued or in progress retrievals
 	ptrs map[blockPtrLookup]*blockRetrieval
@@ -113,6 +120,11 @@ type blockRetrievalQueue struct {
 	// capacity: ~584 years at 1 billion requests/sec
 	insertionCount uint64
 	heap           *blockRetrievalHeap
+	// the fair-queueing tag of the most recently started retrieval
+	virtualTime uint64
+	// the fair-queueing tag of the last queued retrieval for each TLF
+	// that may still have retrievals in the heap
+	lastFairOrders map[tlf.ID]uint64
 
 	// These are notification channels to maximize the time that each request
 	// is in the heap, allowing preemption as long as possible. This way, a
@@ -144,6 +156,7 @@ func newBlockRetrievalQueue(numWorkers int, numPrefetchWorkers int,
 		log:              config.MakeLogger(""),
 		ptrs:             make(map[blockPtrLookup]*blockRetrieval),
 		heap:             &blockRetrievalHeap{},
+		lastFairOrders:   make(map[tlf.ID]uint64),
 		workerCh:         workerCh,
 		prefetchWorkerCh: prefetchWorkerCh,
 		doneCh:           make(chan struct{}),
@@ -162,13 +175,38 @@ func newBlockRetrievalQueue(numWorkers int, numPrefetchWorkers int,
 	return q
 }
 
+// nextFairOrderLocked returns the fair-queueing tag for a new
+// retrieval for the given TLF.  The tag comes just after the TLF's
+// previous retrieval, but never before the retrieval that started
+// most recently, so a TLF that has been idle gets its turn right
+// away instead of catching up on the turns it skipped.
+func (brq *blockRetrievalQueue) nextFairOrderLocked(tlfID tlf.ID) uint64 {
+	fairOrder := brq.virtualTime
+	if last := brq.lastFairOrders[tlfID]; last > fairOrder {
+		fairOrder = last
+	}
+	fairOrder++
+	brq.lastFairOrders[tlfID] = fairOrder
+	return fairOrder
+}
+
 func (brq *blockRetrievalQueue) popIfNotEmpty() *blockRetrieval {
 	brq.mtx.Lock()
 	defer brq.mtx.Unlock()
-	if brq.heap.Len() > 0 {
-		return heap.Pop(brq.heap).(*blockRetrieval)
+	if brq.heap.Len() == 0 {
+		return nil
+	}
+	retrieval := heap.Pop(brq.heap).(*blockRetrieval)
+	if retrieval.fairOrder > brq.virtualTime {
+		brq.virtualTime = retrieval.fairOrder
+	}
+	// Forget TLFs whose retrievals have all been started, since the
+	// virtual time will take precedence for them from now on.
+	tlfID := retrieval.kmd.TlfID()
+	if brq.lastFairOrders[tlfID] <= brq.virtualTime {
+		delete(brq.lastFairOrders, tlfID)
 	}
-	return nil
+	return retrieval
 }
 
 func (brq *blockRetrievalQueue) shutdownRetrieval() {
@@ -344,6 +382,7 @@ func (brq *blockRetrievalQueue) request(ctx context.Context,
 				index:          -1,
 				priority:       priority,
 				insertionOrder: brq.insertionCount,
+				fairOrder:      brq.nextFairOrderLocked(kmd.TlfID()),
 				cacheLifetime:  lifetime,
 			}
 			br.ctx, br.cancelFunc = NewCoalescingContext(ctx)
@@ -23,6 +23,7 @@ type testBlockRetrievalConfig struct {
 	*testDiskBlockCacheGetter
 	*testSyncedTlfGetterSetter
 	initModeGetter
+	*testNetworkConstraintsGetter
 }
 
 func newTestBlockRetrievalConfig(t *testing.T, bg blockGetter,
@@ -35,6 +36,7 @@ func newTestBlockRetrievalConfig(t *testing.T, bg blockGetter,
 		newTestDiskBlockCacheGetter(t, dbc),
 		newTestSyncedTlfGetterSetter(),
 		testInitModeGetter{InitDefault},
+		&testNetworkConstraintsGetter{},
 	}
 }
 
@@ -205,6 +207,42 @@ func TestBlockRetrievalQueueMultipleRequestsSameBlock(t *testing.T) {
 	require.Equal(t, block, br.requests[1].block)
 }
 
+func TestBlockRetrievalQueueFairBetweenTlfs(t *testing.T) {
+	t.Log("Requests from a TLF with a backlog don't hold up requests " +
+		"of the same priority from another TLF.")
+	q := initBlockRetrievalQueueTest(t)
+	require.NotNil(t, q)
+	defer q.Shutdown()
+
+	ctx := context.Background()
+	kmdA := emptyKeyMetadata{tlf.FakeID(1, tlf.Private), 1}
+	kmdB := emptyKeyMetadata{tlf.FakeID(2, tlf.Private), 1}
+	ptrA1 := makeRandomBlockPointer(t)
+	ptrA2 := makeRandomBlockPointer(t)
+	ptrA3 := makeRandomBlockPointer(t)
+	ptrB1 := makeRandomBlockPointer(t)
+	ptrB2 := makeRandomBlockPointer(t)
+	block := &FileBlock{}
+	t.Log("Request three blocks from TLF A, then two from TLF B.")
+	for _, ptr := range []BlockPointer{ptrA1, ptrA2, ptrA3} {
+		_ = q.Request(ctx, defaultOnDemandRequestPriority, kmdA, ptr,
+			block, NoCacheEntry)
+	}
+	for _, ptr := range []BlockPointer{ptrB1, ptrB2} {
+		_ = q.Request(ctx, defaultOnDemandRequestPriority, kmdB, ptr,
+			block, NoCacheEntry)
+	}
+
+	t.Log("The TLFs take turns.")
+	for _, ptr := range []BlockPointer{ptrA1, ptrB1, ptrA2, ptrB2, ptrA3} {
+		br := q.popIfNotEmpty()
+		require.Equal(t, ptr, br.blockPtr)
+		q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+	}
+	require.Len(t, *q.heap, 0)
+	require.Len(t, q.lastFairOrders, 0)
+}
+
 func TestBlockRetrievalQueueElevatePriorityExistingRequest(t *testing.T) {
 	t.Log("Elevate the priority on an existing request.")
 	q := initBlockRetrievalQueueTest(t)
@@ -0,0 +1,179 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+)
+
+// BlockPadding says how much zero padding is added to each encoded
+// block before it's encrypted.  Padding hides the exact size of a
+// block from the block server, at the cost of extra storage and
+// bandwidth.  Every block records its unpadded length, so blocks
+// written with any padding can always be read, whatever the current
+// setting is.
+type BlockPadding int
+
+const (
+	// BlockPaddingPowerOfTwo pads each block up to the next power
+	// of two.  This hides the most about file sizes, but a block
+	// just over a power of two is almost doubled in size.
+	BlockPaddingPowerOfTwo BlockPadding = iota
+	// BlockPaddingMinimal pads each block up to the next multiple of
+	// the minimum block size, which suits workloads of large media
+	// files, whose sizes aren't worth hiding.
+	BlockPaddingMinimal
+)
+
+func (p BlockPadding) String() string {
+	switch p {
+	case BlockPaddingPowerOfTwo:
+		return "pow2"
+	case BlockPaddingMinimal:
+		return "minimal"
+	default:
+		return fmt.Sprintf("BlockPadding(%d)", int(p))
+	}
+}
+
+// ParseBlockPadding parses a BlockPadding from its string form.
+func ParseBlockPadding(s string) (BlockPadding, error) {
+	switch s {
+	case "pow2":
+		return BlockPaddingPowerOfTwo, nil
+	case "minimal":
+		return BlockPaddingMinimal, nil
+	default:
+		return 0, errors.Errorf("Unknown block padding %q", s)
+	}
+}
+
+// Set implements the flag.Value interface for BlockPadding.
+func (p *BlockPadding) Set(s string) error {
+	padding, err := ParseBlockPadding(s)
+	if err != nil {
+		return err
+	}
+	*p = padding
+	return nil
+}
+
+// paddedLen returns how many bytes an encoded block of length `n`
+// takes up once padded.
+func (p BlockPadding) paddedLen(n int) int {
+	if p == BlockPaddingMinimal {
+		if n <= minBlockSize {
+			return minBlockSize
+		}
+		return (n + minBlockSize - 1) / minBlockSize * minBlockSize
+	}
+	return powerOfTwoEqualOrGreater(n)
+}
+
+// maxBlockSizeBytesLimit is the biggest MaxBlockSize a
+// BlockSplitPolicy may ask for.  Block servers and LAN peers can
+// count on no encoded block being bigger than this (plus the small
+// encryption overhead), and size their buffers and message limits
+// accordingly.
+const maxBlockSizeBytesLimit = 8 * MaxBlockSizeBytesDefault
+
+// BlockSplitPolicy says how files are split into blocks, and how
+// blocks are padded.  It only affects blocks written from now on;
+// readers follow the offsets and pointers recorded in each file, so
+// existing files, and files written by clients with a different
+// policy, are read the same way as always.
+type BlockSplitPolicy struct {
+	// MaxBlockSize is the desired size, in bytes, of each encoded
+	// file block.  Bigger blocks mean fewer block fetches for
+	// large media files; smaller ones waste less space on small
+	// files.
+	MaxBlockSize int64
+	// MaxPtrsPerBlock is the fan-out of indirect file blocks.  If
+	// it's zero, it's derived from MaxBlockSize.
+	MaxPtrsPerBlock int
+	// BlockChangeEmbedMaxSize is the biggest estimated size of the
+	// block changes of a revision that are embedded in its MD,
+	// rather than put in a block of their own.
+	BlockChangeEmbedMaxSize uint64
+	// Padding says how blocks are padded before being encrypted.
+	Padding BlockPadding
+	// ContentDefined, if true, ends blocks where the file's contents
+	// match a fingerprint, instead of every MaxBlockSize bytes, so
+	// that an insertion near the start of a big file doesn't shift
+	// the contents of every block after it.  See
+	// BlockSplitterFingerprint.
+	ContentDefined bool
+	// Compression says how blocks are compressed before being
+	// padded and encrypted.
+	Compression BlockCompression
+	// Dedup, if true, keys each block by its contents and the TLF
+	// crypt key, rather than by a random key, so that identical
+	// blocks written to the same TLF at the same key generation,
+	// even by different clients, are encrypted identically and
+	// stored only once by the block server; each copy just adds a
+	// reference to the stored block.  This lets anyone who can
+	// read the TLF tell whether a block with given contents is in
+	// it.  In TLFs with journaling enabled, such blocks are put in
+	// the journal, and are only uploaded when flushed if the block
+	// server doesn't have them yet.
+	Dedup bool
+}
+
+// DefaultBlockSplitPolicy returns the block split policy KBFS starts
+// out with.
+func DefaultBlockSplitPolicy() BlockSplitPolicy {
+	return BlockSplitPolicy{
+		MaxBlockSize:            MaxBlockSizeBytesDefault,
+		BlockChangeEmbedMaxSize: 8 * 1024,
+		Padding:                 BlockPaddingPowerOfTwo,
+	}
+}
+
+// NewBlockSplitterFromPolicy makes a BlockSplitter that splits file
+// blocks according to `p`.
+func NewBlockSplitterFromPolicy(p BlockSplitPolicy, codec kbfscodec.Codec) (
+	BlockSplitter, error) {
+	if p.MaxBlockSize <= minBlockSize ||
+		p.MaxBlockSize > maxBlockSizeBytesLimit {
+		return nil, errors.Errorf(
+			"Max block size %d must be bigger than %d and at most %d",
+			p.MaxBlockSize, minBlockSize, maxBlockSizeBytesLimit)
+	}
+	if p.MaxPtrsPerBlock < 0 || p.MaxPtrsPerBlock == 1 {
+		return nil, errors.Errorf(
+			"Invalid max pointers per block %d", p.MaxPtrsPerBlock)
+	}
+	if p.Padding != BlockPaddingPowerOfTwo &&
+		p.Padding != BlockPaddingMinimal {
+		return nil, errors.Errorf("Unknown block padding %s", p.Padding)
+	}
+	if p.Compression < BlockCompressionNone ||
+		p.Compression > BlockCompressionFlate {
+		return nil, errors.Errorf(
+			"Unknown block compression %s", p.Compression)
+	}
+
+	bsplit, err := NewBlockSplitterSimple(
+		p.MaxBlockSize, p.BlockChangeEmbedMaxSize, codec)
+	if err != nil {
+		return nil, err
+	}
+	if p.MaxPtrsPerBlock > 0 {
+		// Each pointer needs at least `bpSize` bytes of the block.
+		if maxPtrs := int(bsplit.maxSize / int64(bpSize)); p.MaxPtrsPerBlock > maxPtrs {
+			return nil, errors.Errorf(
+				"%d pointers don't fit in a block of %d bytes; the max is %d",
+				p.MaxPtrsPerBlock, p.MaxBlockSize, maxPtrs)
+		}
+		bsplit.maxPtrsPerBlock = p.MaxPtrsPerBlock
+	}
+	if p.ContentDefined {
+		return newBlockSplitterFingerprint(*bsplit), nil
+	}
+	return bsplit, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockPaddingPaddedLen(t *testing.T) {
+	require.Equal(t, minBlockSize, BlockPaddingPowerOfTwo.paddedLen(1))
+	require.Equal(t, 4096, BlockPaddingPowerOfTwo.paddedLen(2049))
+	require.Equal(t, minBlockSize, BlockPaddingMinimal.paddedLen(1))
+	require.Equal(t, 2048+minBlockSize, BlockPaddingMinimal.paddedLen(2049))
+	require.Equal(t, 2048, BlockPaddingMinimal.paddedLen(2048))
+
+	for _, p := range []BlockPadding{
+		BlockPaddingPowerOfTwo, BlockPaddingMinimal} {
+		parsed, err := ParseBlockPadding(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseBlockPadding("none")
+	require.Error(t, err)
+}
+
+func TestNewBlockSplitterFromPolicy(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+
+	bsplitI, err := NewBlockSplitterFromPolicy(
+		DefaultBlockSplitPolicy(), codec)
+	require.NoError(t, err)
+	bsplit := bsplitI.(*BlockSplitterSimple)
+	expected, err := NewBlockSplitterSimple(
+		MaxBlockSizeBytesDefault, 8*1024, codec)
+	require.NoError(t, err)
+	require.Equal(t, expected, bsplit)
+
+	t.Log("A bigger block size gives bigger blocks and more pointers")
+	p := DefaultBlockSplitPolicy()
+	p.MaxBlockSize = 4 * MaxBlockSizeBytesDefault
+	bigI, err := NewBlockSplitterFromPolicy(p, codec)
+	require.NoError(t, err)
+	big := bigI.(*BlockSplitterSimple)
+	require.True(t, big.maxSize > bsplit.maxSize)
+	require.True(t, big.maxPtrsPerBlock > bsplit.maxPtrsPerBlock)
+
+	t.Log("The fan-out can be overridden")
+	p.MaxPtrsPerBlock = 16
+	smallI, err := NewBlockSplitterFromPolicy(p, codec)
+	require.NoError(t, err)
+	small := smallI.(*BlockSplitterSimple)
+	require.Equal(t, big.maxSize, small.maxSize)
+	require.Equal(t, 16, small.maxPtrsPerBlock)
+
+	t.Log("Content-defined chunking keeps the same limits")
+	p.ContentDefined = true
+	fpI, err := NewBlockSplitterFromPolicy(p, codec)
+	require.NoError(t, err)
+	fp := fpI.(*BlockSplitterFingerprint)
+	require.Equal(t, *small, fp.BlockSplitterSimple)
+
+	t.Log("Bad policies are rejected")
+	for _, bad := range []BlockSplitPolicy{
+		{MaxBlockSize: minBlockSize},
+		{MaxBlockSize: maxBlockSizeBytesLimit + 1},
+		{MaxBlockSize: MaxBlockSizeBytesDefault, MaxPtrsPerBlock: 1},
+		{MaxBlockSize: MaxBlockSizeBytesDefault, MaxPtrsPerBlock: 1 << 20},
+		{MaxBlockSize: MaxBlockSizeBytesDefault, Padding: BlockPadding(5)},
+		{MaxBlockSize: MaxBlockSizeBytesDefault,
+			Compression: BlockCompression(5)},
+	} {
+		_, err := NewBlockSplitterFromPolicy(bad, codec)
+		require.Error(t, err, "%+v", bad)
+	}
+}
+
+func TestCryptoCommonEncryptBlockMinimalPadding(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+	key := kbfscrypto.BlockCryptKey{}
+	block := &FileBlock{Contents: make([]byte, 5000)}
+
+	_, _, pow2Block, err := c.encryptBlockWithPadding(
+		block, key, BlockPaddingPowerOfTwo, BlockCompressionNone)
+	require.NoError(t, err)
+	_, _, minimalBlock, err := c.encryptBlockWithPadding(
+		block, key, BlockPaddingMinimal, BlockCompressionNone)
+	require.NoError(t, err)
+	require.True(t, len(minimalBlock.EncryptedData) <
+		len(pow2Block.EncryptedData))
+
+	t.Log("Blocks decrypt the same way, whatever their padding")
+	for _, eb := range []kbfscrypto.EncryptedBlock{pow2Block, minimalBlock} {
+		var decrypted FileBlock
+		err = c.DecryptBlock(eb, key, &decrypted)
+		require.NoError(t, err)
+		require.Equal(t, block.Contents, decrypted.Contents)
+	}
+}
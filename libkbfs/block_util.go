@@ -35,10 +35,32 @@ func putBlockToServer(ctx context.Context, bserv BlockServer, tlfID tlf.ID,
 		// existing block.
 		err = bserv.AddBlockReference(ctx, tlfID, blockPtr.ID,
 			blockPtr.Context)
+		if readyBlockData.convergent && isMissingBlockError(err) {
+			// The block was keyed by its contents, in the hope that
+			// an identical one was already stored, but it wasn't
+			// (or isn't anymore), so store it now under this
+			// reference.
+			err = bserv.PutAgain(ctx, tlfID, blockPtr.ID, blockPtr.Context,
+				readyBlockData.buf, readyBlockData.serverHalf)
+		}
 	}
 	return err
 }
 
+// isMissingBlockError returns true if `err` says that a block can't
+// be referenced because it isn't stored, or only has archived
+// references.
+func isMissingBlockError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case kbfsblock.ServerErrorBlockNonExistent,
+		kbfsblock.ServerErrorBlockDeleted,
+		kbfsblock.ServerErrorBlockArchived:
+		return true
+	default:
+		return false
+	}
+}
+
 // PutBlockCheckLimitErrs is a thin wrapper around putBlockToServer (which
 // calls either bserver.Put or bserver.AddBlockReference) that reports
 // quota and disk limit errors.
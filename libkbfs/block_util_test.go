@@ -75,6 +75,38 @@ func TestBlockUtilPutIncRefSuccess(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestBlockUtilPutConvergentMissing(t *testing.T) {
+	mockCtrl, ctr, bserver, ctx := blockUtilInit(t)
+	defer blockUtilShutdown(mockCtrl, ctr)
+
+	id := kbfsblock.FakeID(1)
+	encData := []byte{1, 2, 3, 4}
+	nonce := kbfsblock.RefNonce([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	blockPtr := BlockPointer{
+		ID: id,
+		Context: kbfsblock.Context{
+			RefNonce: nonce,
+		},
+	}
+
+	tlfID := tlf.FakeID(0, tlf.Private)
+
+	readyBlockData := ReadyBlockData{
+		buf:        encData,
+		convergent: true,
+	}
+
+	// The block isn't stored yet, so it's put under the new
+	// reference.
+	bserver.EXPECT().AddBlockReference(ctx, tlfID, id,
+		blockPtr.Context).Return(kbfsblock.ServerErrorBlockNonExistent{})
+	bserver.EXPECT().PutAgain(ctx, tlfID, id, blockPtr.Context,
+		readyBlockData.buf, readyBlockData.serverHalf).Return(nil)
+
+	err := putBlockToServer(ctx, bserver, tlfID, blockPtr, readyBlockData)
+	require.NoError(t, err)
+}
+
 func TestBlockUtilPutFail(t *testing.T) {
 	mockCtrl, ctr, bserver, ctx := blockUtilInit(t)
 	defer blockUtilShutdown(mockCtrl, ctr)
@@ -0,0 +1,120 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+const (
+	// BServerDefaultHedgeBudget is the default fraction of block
+	// reads that may be hedged.
+	BServerDefaultHedgeBudget = 0.05
+	// bserverHedgeBurst is the most hedges that can be saved up
+	// while reads are fast, to spend once they slow down.
+	bserverHedgeBurst = 10
+)
+
+// blockServerHedger decides when a slow block read should be sent
+// again, and limits how many reads are sent twice.  Each read earns
+// budget hedges, up to bserverHedgeBurst, and each hedge spends one.
+type blockServerHedger struct {
+	delay  time.Duration
+	budget float64
+
+	lock   sync.Mutex
+	tokens float64
+}
+
+func newBlockServerHedger(
+	delay time.Duration, budget float64) *blockServerHedger {
+	return &blockServerHedger{
+		delay:  delay,
+		budget: budget,
+	}
+}
+
+func (h *blockServerHedger) earn() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.tokens += h.budget
+	if h.tokens > bserverHedgeBurst {
+		h.tokens = bserverHedgeBurst
+	}
+}
+
+func (h *blockServerHedger) spend() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+type getBlockResult struct {
+	res keybase1.GetBlockRes
+	err error
+}
+
+// EnableHedgedGets makes b send a second copy of any block read that
+// hasn't finished after delay, over the next get connection, and use
+// whichever answer comes back first.  At most the given fraction of
+// reads, on average, are sent twice.  It must be called before b is
+// used.
+func (b *BlockServerRemote) EnableHedgedGets(
+	delay time.Duration, budget float64) {
+	b.hedger = newBlockServerHedger(delay, budget)
+}
+
+// getBlock sends a GetBlock RPC, hedging it if that's enabled.
+func (b *BlockServerRemote) getBlock(
+	ctx context.Context, arg keybase1.GetBlockArg) (
+	keybase1.GetBlockRes, error) {
+	if b.hedger == nil {
+		return b.getConn().getClient().GetBlock(ctx, arg)
+	}
+	b.hedger.earn()
+
+	ctx, cancel := context.WithCancel(ctx)
+	// Canceling stops whichever request loses the race.
+	defer cancel()
+	resCh := make(chan getBlockResult, 2)
+	get := func(c *blockServerRemoteClientHandler) {
+		res, err := c.getClient().GetBlock(ctx, arg)
+		resCh <- getBlockResult{res, err}
+	}
+	go get(b.getConn())
+
+	timer := time.NewTimer(b.hedger.delay)
+	defer timer.Stop()
+	select {
+	case r := <-resCh:
+		return r.res, r.err
+	case <-timer.C:
+	}
+
+	if !b.hedger.spend() {
+		r := <-resCh
+		return r.res, r.err
+	}
+	b.log.CDebugf(ctx, "GetBlock for %s took longer than %s; sending again",
+		arg.Bid.BlockHash, b.hedger.delay)
+	go get(b.getConn())
+
+	r := <-resCh
+	if r.err != nil {
+		// The other request might still succeed.
+		if r2 := <-resCh; r2.err == nil {
+			return r2.res, nil
+		}
+	}
+	return r.res, r.err
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// slowBServerClient answers GetBlock calls only after a delay, unless
+// they are canceled first.
+type slowBServerClient struct {
+	keybase1.BlockInterface
+	delay    time.Duration
+	canceled chan struct{}
+}
+
+func (c *slowBServerClient) GetBlock(
+	ctx context.Context, arg keybase1.GetBlockArg) (
+	keybase1.GetBlockRes, error) {
+	select {
+	case <-time.After(c.delay):
+		return c.BlockInterface.GetBlock(ctx, arg)
+	case <-ctx.Done():
+		close(c.canceled)
+		return keybase1.GetBlockRes{}, ctx.Err()
+	}
+}
+
+func TestBServerRemoteHedgedGet(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+	slow := &slowBServerClient{
+		BlockInterface: &fc,
+		delay:          time.Second,
+		canceled:       make(chan struct{}),
+	}
+	fast := &countingBServerClient{BlockInterface: &fc}
+	log := b.getConns[0].log
+	b.getConns = []*blockServerRemoteClientHandler{
+		{log: log, client: slow},
+		{log: log, client: fast},
+	}
+	// Start the round-robin at the slow connection.
+	b.nextGetConn = uint32(len(b.getConns) - 1)
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	ctx := context.Background()
+	err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	t.Log("With no budget, the slow read isn't hedged")
+	b.EnableHedgedGets(time.Millisecond, 0)
+	slow.delay = 10 * time.Millisecond
+	buf, _, err := b.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, 0, fast.gets)
+
+	t.Log("With budget, the hedge wins and the slow read is canceled")
+	b.EnableHedgedGets(time.Millisecond, 1)
+	slow.delay = time.Minute
+	b.nextGetConn = uint32(len(b.getConns) - 1)
+	buf, _, err = b.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, 1, fast.gets)
+	select {
+	case <-slow.canceled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Slow read wasn't canceled")
+	}
+}
@@ -0,0 +1,476 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/context"
+)
+
+const (
+	// lanPeerBlockPath is the HTTP path on which LAN peers serve
+	// blocks.
+	lanPeerBlockPath = "/kbfs/block"
+	// lanPeerRequestMaxAge bounds how far the time in a request may
+	// be from the serving peer's clock, to limit replays.
+	lanPeerRequestMaxAge = time.Minute
+	// lanPeerTimeout limits how long a single peer may take to
+	// answer, before falling back to the next peer or the server.
+	lanPeerTimeout = 2 * time.Second
+	// lanPeerRetryDelay is how long to skip a peer after failing to
+	// connect to it.
+	lanPeerRetryDelay = time.Minute
+	// lanPeerMaxMessageBytes bounds the size of requests and
+	// responses.  A response holds one encrypted block, of at most
+	// maxBlockSizeBytesLimit bytes plus some overhead, so this
+	// leaves plenty of room.
+	lanPeerMaxMessageBytes = 2 * maxBlockSizeBytesLimit
+)
+
+// CtxLANPeerTagKey is the type used for unique context tags within
+// BlockServerLANPeers.
+type CtxLANPeerTagKey int
+
+const (
+	// CtxLANPeerIDKey is the type of the tag for unique operation IDs
+	// within BlockServerLANPeers.
+	CtxLANPeerIDKey CtxLANPeerTagKey = iota
+)
+
+// CtxLANPeerOpID is the display name for the unique operation
+// BlockServerLANPeers ID tag.
+const CtxLANPeerOpID = "LANPID"
+
+// lanPeerBlockRequestBody is the signed part of a request for a
+// block from a LAN peer.
+type lanPeerBlockRequestBody struct {
+	TlfID   tlf.ID
+	BlockID kbfsblock.ID
+	// UID is the requesting user, whose device signed the request.
+	UID keybase1.UID
+	// Time is when the request was made, in Unix nanoseconds.
+	Time int64
+	// ReplyKey is the ephemeral public key that the block's server
+	// half is sealed to.
+	ReplyKey [32]byte
+}
+
+type lanPeerBlockRequest struct {
+	// Body is an encoded lanPeerBlockRequestBody.
+	Body []byte
+	Sig  kbfscrypto.SignatureInfo
+}
+
+// lanPeerBlockResponseBody is the signed part of a LAN peer's reply.
+// It doesn't include the block itself, which is verified by its ID.
+type lanPeerBlockResponseBody struct {
+	BlockID kbfsblock.ID
+	// ReplyKey is copied from the request, to tie the reply to it.
+	ReplyKey [32]byte
+	// UID is the serving user, whose device signed the reply.
+	UID keybase1.UID
+	// SealedServerHalf is the block's server half, boxed from
+	// PeerKey to ReplyKey.
+	SealedServerHalf []byte
+	Nonce            [24]byte
+	PeerKey          [32]byte
+}
+
+type lanPeerBlockResponse struct {
+	// Body is an encoded lanPeerBlockResponseBody.
+	Body []byte
+	Sig  kbfscrypto.SignatureInfo
+	Buf  []byte
+}
+
+// blockServerLANPeersConfig specifies the interfaces that a
+// BlockServerLANPeers needs to perform its functions.
+type blockServerLANPeersConfig interface {
+	codecGetter
+	cryptoGetter
+	clockGetter
+	diskBlockCacheGetter
+	logMaker
+	KBPKI() KBPKI
+	MDOps() MDOps
+}
+
+// BlockServerLANPeers delegates to another BlockServer instance, but
+// first tries to get blocks from other devices on the local network
+// that have them in their disk block caches.  It can also serve the
+// blocks in this device's disk block cache to those devices.
+//
+// Both sides of an exchange sign their messages with their device
+// keys, and a block is only handed to, or accepted from, a user who
+// can read its TLF.  The block data is checked against its ID, and
+// its key server half is only sent encrypted.
+type BlockServerLANPeers struct {
+	BlockServer
+	config blockServerLANPeersConfig
+	log    logger.Logger
+	peers  []string
+	client *http.Client
+
+	lock sync.Mutex
+	// downUntil holds the peers that couldn't be reached recently.
+	downUntil map[string]time.Time
+	listener  net.Listener
+	server    *http.Server
+}
+
+var _ BlockServer = (*BlockServerLANPeers)(nil)
+
+// NewBlockServerLANPeers creates and returns a new
+// BlockServerLANPeers instance with the given delegate, which tries
+// to get blocks from the LAN peers at the given "host:port"
+// addresses before asking the delegate.
+func NewBlockServerLANPeers(delegate BlockServer,
+	config blockServerLANPeersConfig, peers []string) *BlockServerLANPeers {
+	return &BlockServerLANPeers{
+		BlockServer: delegate,
+		config:      config,
+		log:         config.MakeLogger("LANP"),
+		peers:       peers,
+		client:      &http.Client{Timeout: lanPeerTimeout},
+		downUntil:   make(map[string]time.Time),
+	}
+}
+
+// isLANPeerReader returns whether the user `uid` may read the blocks
+// of TLF `tlfID`.
+func isLANPeerReader(ctx context.Context, config blockServerLANPeersConfig,
+	tlfID tlf.ID, uid keybase1.UID) (bool, error) {
+	if tlfID.Type() == tlf.Public {
+		return true, nil
+	}
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	if uid == session.UID {
+		return true, nil
+	}
+	head, err := config.MDOps().GetForTLF(ctx, tlfID, nil)
+	if err != nil {
+		return false, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return false, errors.Errorf("No metadata for TLF %s", tlfID)
+	}
+	return isReaderFromHandle(ctx, head.GetTlfHandle(), config.KBPKI(), uid)
+}
+
+// checkLANPeerSig checks that `sig` is a valid signature of `body` by
+// a current device of `uid`.
+func checkLANPeerSig(ctx context.Context, config blockServerLANPeersConfig,
+	body []byte, sig kbfscrypto.SignatureInfo, uid keybase1.UID) error {
+	if err := kbfscrypto.Verify(body, sig); err != nil {
+		return err
+	}
+	return config.KBPKI().HasVerifyingKey(
+		ctx, uid, sig.VerifyingKey, config.Clock().Now())
+}
+
+func (b *BlockServerLANPeers) isPeerDown(peer string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	until, ok := b.downUntil[peer]
+	if !ok {
+		return false
+	}
+	if b.config.Clock().Now().After(until) {
+		delete(b.downUntil, peer)
+		return false
+	}
+	return true
+}
+
+func (b *BlockServerLANPeers) setPeerDown(peer string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.downUntil[peer] = b.config.Clock().Now().Add(lanPeerRetryDelay)
+}
+
+func (b *BlockServerLANPeers) getFromPeer(ctx context.Context, peer string,
+	tlfID tlf.ID, id kbfsblock.ID) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	session, err := b.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	replyPub, replyPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.WithStack(err)
+	}
+	codec := b.config.Codec()
+	body, err := codec.Encode(lanPeerBlockRequestBody{
+		TlfID:    tlfID,
+		BlockID:  id,
+		UID:      session.UID,
+		Time:     b.config.Clock().Now().UnixNano(),
+		ReplyKey: *replyPub,
+	})
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	sig, err := b.config.Crypto().Sign(ctx, body)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	reqBuf, err := codec.Encode(lanPeerBlockRequest{Body: body, Sig: sig})
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+
+	req, err := http.NewRequest(
+		"POST", "http://"+peer+lanPeerBlockPath, bytes.NewReader(reqBuf))
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.WithStack(err)
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() == nil {
+			b.setPeerDown(peer)
+		}
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.Errorf("LAN peer %s answered %s", peer, resp.Status)
+	}
+	respBuf, err := ioutil.ReadAll(
+		io.LimitReader(resp.Body, lanPeerMaxMessageBytes))
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.WithStack(err)
+	}
+
+	var blockResp lanPeerBlockResponse
+	if err := codec.Decode(respBuf, &blockResp); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	var respBody lanPeerBlockResponseBody
+	if err := codec.Decode(blockResp.Body, &respBody); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	if respBody.BlockID != id || respBody.ReplyKey != *replyPub {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			errors.Errorf("LAN peer %s answered a different request", peer)
+	}
+	err = checkLANPeerSig(
+		ctx, b.config, blockResp.Body, blockResp.Sig, respBody.UID)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	isReader, err := isLANPeerReader(ctx, b.config, tlfID, respBody.UID)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	if !isReader {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, errors.Errorf(
+			"LAN peer %s is run by %s, who can't read %s",
+			peer, respBody.UID, tlfID)
+	}
+	if err := kbfsblock.VerifyID(blockResp.Buf, id); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	data, ok := box.Open(nil, respBody.SealedServerHalf,
+		&respBody.Nonce, &respBody.PeerKey, replyPriv)
+	if !ok || len(data) != 32 {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, errors.Errorf(
+			"Couldn't open the server half from LAN peer %s", peer)
+	}
+	var serverHalfData [32]byte
+	copy(serverHalfData[:], data)
+	return blockResp.Buf,
+		kbfscrypto.MakeBlockCryptKeyServerHalf(serverHalfData), nil
+}
+
+// Get implements the BlockServer interface for BlockServerLANPeers.
+func (b *BlockServerLANPeers) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	for _, peer := range b.peers {
+		if b.isPeerDown(peer) {
+			continue
+		}
+		buf, serverHalf, err := b.getFromPeer(ctx, peer, tlfID, id)
+		if err != nil {
+			b.log.CDebugf(ctx, "Couldn't get block %s from LAN peer %s: %+v",
+				id, peer, err)
+			continue
+		}
+		b.log.CDebugf(ctx, "Got block %s from LAN peer %s", id, peer)
+		return buf, serverHalf, nil
+	}
+	return b.BlockServer.Get(ctx, tlfID, id, context)
+}
+
+// serveBlock answers the encoded block request `reqBuf`.  It returns
+// a nil response if the block isn't cached here.
+func (b *BlockServerLANPeers) serveBlock(
+	ctx context.Context, reqBuf []byte) (*lanPeerBlockResponse, error) {
+	codec := b.config.Codec()
+	var req lanPeerBlockRequest
+	if err := codec.Decode(reqBuf, &req); err != nil {
+		return nil, err
+	}
+	var reqBody lanPeerBlockRequestBody
+	if err := codec.Decode(req.Body, &reqBody); err != nil {
+		return nil, err
+	}
+	age := b.config.Clock().Now().Sub(time.Unix(0, reqBody.Time))
+	if age > lanPeerRequestMaxAge || age < -lanPeerRequestMaxAge {
+		return nil, errors.Errorf("Request is %s old", age)
+	}
+	err := checkLANPeerSig(ctx, b.config, req.Body, req.Sig, reqBody.UID)
+	if err != nil {
+		return nil, err
+	}
+	isReader, err := isLANPeerReader(ctx, b.config, reqBody.TlfID, reqBody.UID)
+	if err != nil {
+		return nil, err
+	}
+	if !isReader {
+		return nil, errors.Errorf("%s can't read %s", reqBody.UID, reqBody.TlfID)
+	}
+
+	dbc := b.config.DiskBlockCache()
+	if dbc == nil {
+		return nil, nil
+	}
+	buf, serverHalf, _, err := dbc.Get(ctx, reqBody.TlfID, reqBody.BlockID)
+	if _, ok := errors.Cause(err).(NoSuchBlockError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	peerPub, peerPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var nonce [24]byte
+	if err := kbfscrypto.RandRead(nonce[:]); err != nil {
+		return nil, err
+	}
+	serverHalfData := serverHalf.Data()
+	session, err := b.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := codec.Encode(lanPeerBlockResponseBody{
+		BlockID:  reqBody.BlockID,
+		ReplyKey: reqBody.ReplyKey,
+		UID:      session.UID,
+		SealedServerHalf: box.Seal(nil, serverHalfData[:], &nonce,
+			&reqBody.ReplyKey, peerPriv),
+		Nonce:   nonce,
+		PeerKey: *peerPub,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := b.config.Crypto().Sign(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return &lanPeerBlockResponse{Body: body, Sig: sig, Buf: buf}, nil
+}
+
+// ServeHTTP implements the http.Handler interface for
+// BlockServerLANPeers.
+func (b *BlockServerLANPeers) ServeHTTP(
+	w http.ResponseWriter, r *http.Request) {
+	ctx := CtxWithRandomIDReplayable(
+		r.Context(), CtxLANPeerIDKey, CtxLANPeerOpID, b.log)
+	if r.Method != "POST" || r.URL.Path != lanPeerBlockPath {
+		http.NotFound(w, r)
+		return
+	}
+	reqBuf, err := ioutil.ReadAll(
+		io.LimitReader(r.Body, lanPeerMaxMessageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := b.serveBlock(ctx, reqBuf)
+	if err != nil {
+		b.log.CDebugf(ctx, "Refusing LAN peer %s: %+v", r.RemoteAddr, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if resp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	respBuf, err := b.config.Codec().Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b.log.CDebugf(ctx, "Serving a block to LAN peer %s", r.RemoteAddr)
+	_, _ = w.Write(respBuf)
+}
+
+// Serve starts serving the blocks in this device's disk block cache
+// to LAN peers, on the given "host:port" address.  It returns the
+// address actually listened on.
+func (b *BlockServerLANPeers) Serve(addr string) (net.Addr, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.listener != nil {
+		return nil, errors.New("Already serving LAN peers")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b.listener = l
+	b.server = &http.Server{
+		Handler:      b,
+		ReadTimeout:  lanPeerTimeout,
+		WriteTimeout: lanPeerTimeout,
+	}
+	go func() {
+		err := b.server.Serve(l)
+		if err != http.ErrServerClosed {
+			b.log.Warning("LAN peer server stopped: %+v", err)
+		}
+	}()
+	return l.Addr(), nil
+}
+
+// Shutdown implements the BlockServer interface for
+// BlockServerLANPeers.
+func (b *BlockServerLANPeers) Shutdown(ctx context.Context) {
+	b.lock.Lock()
+	server := b.server
+	b.server, b.listener = nil, nil
+	b.lock.Unlock()
+	if server != nil {
+		server.Close()
+	}
+	b.BlockServer.Shutdown(ctx)
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testLANPeerConfig is a Config whose disk block cache is replaced.
+type testLANPeerConfig struct {
+	Config
+	cache DiskBlockCache
+}
+
+func (c testLANPeerConfig) DiskBlockCache() DiskBlockCache {
+	return c.cache
+}
+
+func makeLANPeerTestBlock(t *testing.T) (
+	kbfsblock.ID, []byte, kbfscrypto.BlockCryptKeyServerHalf) {
+	buf := make([]byte, 100)
+	err := kbfscrypto.RandRead(buf)
+	require.NoError(t, err)
+	id, err := kbfsblock.MakePermanentID(buf)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	return id, buf, serverHalf
+}
+
+func TestBlockServerLANPeers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	config1 := MakeTestConfigOrBust(t, "u1", "u2")
+	ctx := context.Background()
+	defer CheckConfigAndShutdown(ctx, t, config1)
+	rootNode := GetRootNodeOrBust(ctx, t, config1, "u1", tlf.Private)
+	tlfID := rootNode.GetFolderBranch().Tlf
+
+	serverCache := NewMockDiskBlockCache(ctrl)
+	server := NewBlockServerLANPeers(
+		NewBlockServerMemory(config1.MakeLogger("")),
+		testLANPeerConfig{config1, serverCache}, nil)
+	addr, err := server.Serve("127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Shutdown(ctx)
+
+	config2 := ConfigAsUser(config1, "u1")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	delegate := NewMockBlockServer(ctrl)
+	client := NewBlockServerLANPeers(
+		delegate, config2, []string{addr.String()})
+
+	t.Log("Another device of the same user gets a cached block")
+	id, buf, serverHalf := makeLANPeerTestBlock(t)
+	serverCache.EXPECT().Get(gomock.Any(), tlfID, id).Return(
+		buf, serverHalf, NoPrefetch, nil)
+	gotBuf, gotServerHalf, err := client.Get(ctx, tlfID, id, kbfsblock.Context{})
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+	require.Equal(t, serverHalf, gotServerHalf)
+
+	t.Log("Uncached blocks come from the block server")
+	id2, buf2, serverHalf2 := makeLANPeerTestBlock(t)
+	serverCache.EXPECT().Get(gomock.Any(), tlfID, id2).Return(
+		nil, kbfscrypto.BlockCryptKeyServerHalf{}, NoPrefetch,
+		NoSuchBlockError{id2})
+	delegate.EXPECT().Get(gomock.Any(), tlfID, id2, kbfsblock.Context{}).
+		Return(buf2, serverHalf2, nil)
+	gotBuf, gotServerHalf, err = client.Get(ctx, tlfID, id2, kbfsblock.Context{})
+	require.NoError(t, err)
+	require.Equal(t, buf2, gotBuf)
+	require.Equal(t, serverHalf2, gotServerHalf)
+
+	t.Log("Blocks that don't match their IDs are ignored")
+	serverCache.EXPECT().Get(gomock.Any(), tlfID, id).Return(
+		buf2, serverHalf, NoPrefetch, nil)
+	delegate.EXPECT().Get(gomock.Any(), tlfID, id, kbfsblock.Context{}).
+		Return(buf, serverHalf, nil)
+	gotBuf, _, err = client.Get(ctx, tlfID, id, kbfsblock.Context{})
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+
+	t.Log("Users who can't read the TLF get nothing from the peer")
+	config3 := ConfigAsUser(config1, "u2")
+	defer CheckConfigAndShutdown(ctx, t, config3)
+	client3 := NewBlockServerLANPeers(
+		delegate, config3, []string{addr.String()})
+	delegate.EXPECT().Get(gomock.Any(), tlfID, id, kbfsblock.Context{}).
+		Return(nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			kbfsblock.ServerErrorUnauthorized{})
+	_, _, err = client3.Get(ctx, tlfID, id, kbfsblock.Context{})
+	require.IsType(t, kbfsblock.ServerErrorUnauthorized{}, err)
+
+	t.Log("Unreachable peers are skipped for a while")
+	server.Shutdown(ctx)
+	delegate.EXPECT().Get(gomock.Any(), tlfID, id, kbfsblock.Context{}).
+		Return(buf, serverHalf, nil).Times(2)
+	_, _, err = client.Get(ctx, tlfID, id, kbfsblock.Context{})
+	require.NoError(t, err)
+	require.True(t, client.isPeerDown(addr.String()))
+	_, _, err = client.Get(ctx, tlfID, id, kbfsblock.Context{})
+	require.NoError(t, err)
+}
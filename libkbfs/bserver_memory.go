@@ -23,6 +23,10 @@ type blockMemEntry struct {
 	blockData     []byte
 	keyServerHalf kbfscrypto.BlockCryptKeyServerHalf
 	refs          blockRefMap
+	// chargedTo and blockType come from the context of the first
+	// put, and determine whose quota the block counts against.
+	chargedTo keybase1.UserOrTeamID
+	blockType keybase1.BlockType
 }
 
 // BlockServerMemory implements the BlockServer interface by just
@@ -162,6 +166,8 @@ func (b *BlockServerMemory) doPut(isRegularPut bool, tlfID tlf.ID, id kbfsblock.
 			blockData:     data,
 			keyServerHalf: serverHalf,
 			refs:          refs,
+			chargedTo:     context.GetCreator(),
+			blockType:     context.GetBlockType(),
 		}
 	}
 
@@ -408,19 +414,50 @@ func (b *BlockServerMemory) Shutdown(ctx context.Context) {
 // RefreshAuthToken implements the BlockServer interface for BlockServerMemory.
 func (b *BlockServerMemory) RefreshAuthToken(_ context.Context) {}
 
-// GetUserQuotaInfo implements the BlockServer interface for BlockServerMemory.
+// getQuotaInfo adds up the sizes of all the stored blocks for which
+// isChargedTo returns true, per TLF.
+func (b *BlockServerMemory) getQuotaInfo(
+	isChargedTo func(keybase1.UserOrTeamID) bool) (
+	*kbfsblock.QuotaInfo, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.m == nil {
+		return nil, errBlockServerMemoryShutdown
+	}
+
+	info := kbfsblock.NewQuotaInfo()
+	info.Limit = math.MaxInt64
+	for _, entry := range b.m {
+		if !isChargedTo(entry.chargedTo) {
+			continue
+		}
+		usage := kbfsblock.UsageWrite
+		if entry.blockType == keybase1.BlockType_GIT {
+			usage = kbfsblock.UsageGitWrite
+		}
+		info.AccumOne(len(entry.blockData), entry.tlfID.String(), usage)
+	}
+	return info, nil
+}
+
+// GetUserQuotaInfo implements the BlockServer interface for
+// BlockServerMemory.  BlockServerMemory doesn't know which user is
+// asking, so the usage covers the blocks of every user, but not of
+// teams.
 func (b *BlockServerMemory) GetUserQuotaInfo(ctx context.Context) (info *kbfsblock.QuotaInfo, err error) {
 	if err := checkContext(ctx); err != nil {
 		return nil, err
 	}
 
-	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return b.getQuotaInfo(func(chargedTo keybase1.UserOrTeamID) bool {
+		return !chargedTo.IsTeamOrSubteam()
+	})
 }
 
 // GetTeamQuotaInfo implements the BlockServer interface for BlockServerMemory.
 func (b *BlockServerMemory) GetTeamQuotaInfo(
-	ctx context.Context, _ keybase1.TeamID) (
+	ctx context.Context, tid keybase1.TeamID) (
 	info *kbfsblock.QuotaInfo, err error) {
 	if err := checkContext(ctx); err != nil {
 		return nil, err
@@ -428,6 +465,7 @@ func (b *BlockServerMemory) GetTeamQuotaInfo(
 
 	// TODO: check team membership and return error if not a reader?
 
-	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return b.getQuotaInfo(func(chargedTo keybase1.UserOrTeamID) bool {
+		return chargedTo == tid.AsUserOrTeam()
+	})
 }
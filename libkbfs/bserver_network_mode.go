@@ -0,0 +1,107 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// BlockServerNetworkMode delegates to another BlockServer instance,
+// but refuses the calls that the current NetworkMode doesn't allow.
+// It should sit directly in front of the remote server, below any
+// journal, so that local journal writes are unaffected.
+type BlockServerNetworkMode struct {
+	BlockServer
+	config networkModeGetter
+}
+
+var _ BlockServer = BlockServerNetworkMode{}
+
+// NewBlockServerNetworkMode creates and returns a new
+// BlockServerNetworkMode instance with the given delegate, which
+// consults the given config for the current network mode.
+func NewBlockServerNetworkMode(
+	delegate BlockServer, config networkModeGetter) BlockServerNetworkMode {
+	return BlockServerNetworkMode{delegate, config}
+}
+
+func (b BlockServerNetworkMode) checkDownload(op string) error {
+	if mode := b.config.NetworkMode(); !mode.downloadsEnabled() {
+		return NetworkModeError{mode, op}
+	}
+	return nil
+}
+
+func (b BlockServerNetworkMode) checkUpload(op string) error {
+	if mode := b.config.NetworkMode(); !mode.uploadsEnabled() {
+		return NetworkModeError{mode, op}
+	}
+	return nil
+}
+
+// Get implements the BlockServer interface for BlockServerNetworkMode.
+func (b BlockServerNetworkMode) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	if err := b.checkDownload("Block get"); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return b.BlockServer.Get(ctx, tlfID, id, context)
+}
+
+// Put implements the BlockServer interface for BlockServerNetworkMode.
+func (b BlockServerNetworkMode) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	if err := b.checkUpload("Block put"); err != nil {
+		return err
+	}
+	return b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// PutAgain implements the BlockServer interface for
+// BlockServerNetworkMode.
+func (b BlockServerNetworkMode) PutAgain(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	if err := b.checkUpload("Block put"); err != nil {
+		return err
+	}
+	return b.BlockServer.PutAgain(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// AddBlockReference implements the BlockServer interface for
+// BlockServerNetworkMode.
+func (b BlockServerNetworkMode) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	if err := b.checkUpload("Block reference add"); err != nil {
+		return err
+	}
+	return b.BlockServer.AddBlockReference(ctx, tlfID, id, context)
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// BlockServerNetworkMode.
+func (b BlockServerNetworkMode) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	if err := b.checkUpload("Block reference removal"); err != nil {
+		return nil, err
+	}
+	return b.BlockServer.RemoveBlockReferences(ctx, tlfID, contexts)
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// BlockServerNetworkMode.
+func (b BlockServerNetworkMode) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	if err := b.checkUpload("Block reference archival"); err != nil {
+		return err
+	}
+	return b.BlockServer.ArchiveBlockReferences(ctx, tlfID, contexts)
+}
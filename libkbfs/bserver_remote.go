@@ -5,7 +5,9 @@
 package libkbfs
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/keybase/backoff"
@@ -26,6 +28,9 @@ const (
 	// BServerPingTimeout is how long to wait for a ping response
 	// before breaking the connection and trying to reconnect.
 	BServerPingTimeout = 30 * time.Second
+	// BServerDefaultGetConnections is the default number of
+	// connections used for reading blocks from the block server.
+	BServerDefaultGetConnections = 1
 )
 
 // blockServerRemoteAuthTokenRefresher is a helper struct for
@@ -40,6 +45,11 @@ type blockServerRemoteClientHandler struct {
 	connOpts      rpc.ConnectionOpts
 	rpcLogFactory rpc.LogFactory
 	pinger        pinger
+	// dialer, if non-nil, makes the connections to srvRemote.
+	dialer Dialer
+	// statusChanged, if non-nil, is called with nil when the
+	// connection comes up, and with an error when it goes down.
+	statusChanged func(err error)
 
 	connMu sync.RWMutex
 	conn   *rpc.Connection
@@ -48,7 +58,8 @@ type blockServerRemoteClientHandler struct {
 
 func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 	signer kbfscrypto.Signer, csg CurrentSessionGetter, srvRemote rpc.Remote,
-	rpcLogFactory rpc.LogFactory) *blockServerRemoteClientHandler {
+	rpcLogFactory rpc.LogFactory,
+	dialer Dialer) *blockServerRemoteClientHandler {
 	deferLog := log.CloneWithAddedDepth(1)
 	b := &blockServerRemoteClientHandler{
 		name:          name,
@@ -57,6 +68,7 @@ func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 		csg:           csg,
 		srvRemote:     srvRemote,
 		rpcLogFactory: rpcLogFactory,
+		dialer:        dialer,
 	}
 
 	b.pinger = pinger{
@@ -91,11 +103,19 @@ func (b *blockServerRemoteClientHandler) initNewConnection() {
 		b.conn.Shutdown()
 	}
 
-	b.conn = rpc.NewTLSConnection(
-		b.srvRemote, kbfscrypto.GetRootCerts(
-			b.srvRemote.Peek(), libkb.GetBundledCAsFromHost),
-		kbfsblock.ServerErrorUnwrapper{}, b, b.rpcLogFactory,
-		logger.LogOutputWithDepthAdder{Logger: b.log}, b.connOpts)
+	rootCerts := kbfscrypto.GetRootCerts(
+		b.srvRemote.Peek(), libkb.GetBundledCAsFromHost)
+	logOutput := logger.LogOutputWithDepthAdder{Logger: b.log}
+	if b.dialer != nil {
+		b.conn = rpc.NewConnectionWithTransport(
+			b, newDialerTransport(b.dialer, b.srvRemote, rootCerts,
+				b.rpcLogFactory, b.connOpts.WrapErrorFunc),
+			kbfsblock.ServerErrorUnwrapper{}, logOutput, b.connOpts)
+	} else {
+		b.conn = rpc.NewTLSConnection(
+			b.srvRemote, rootCerts, kbfsblock.ServerErrorUnwrapper{}, b,
+			b.rpcLogFactory, logOutput, b.connOpts)
+	}
 	b.client = keybase1.BlockClient{Cli: b.conn.GetClient()}
 }
 
@@ -217,9 +237,16 @@ func (b *blockServerRemoteClientHandler) OnConnect(ctx context.Context,
 
 	// Start pinging.
 	b.pinger.resetTicker(BServerDefaultPingIntervalSeconds)
+	b.pushStatusChange(nil)
 	return nil
 }
 
+func (b *blockServerRemoteClientHandler) pushStatusChange(err error) {
+	if b.statusChanged != nil {
+		b.statusChanged(err)
+	}
+}
+
 // OnConnectError implements the ConnectionHandler interface.
 func (b *blockServerRemoteClientHandler) OnConnectError(err error, wait time.Duration) {
 	b.log.Warning("%s: connection error: %v; retrying in %s", b.name, err, wait)
@@ -227,6 +254,7 @@ func (b *blockServerRemoteClientHandler) OnConnectError(err error, wait time.Dur
 		b.authToken.Shutdown()
 	}
 	b.pinger.cancelTicker()
+	b.pushStatusChange(err)
 	// TODO: it might make sense to show something to the user if this is
 	// due to authentication, for example.
 }
@@ -241,6 +269,7 @@ func (b *blockServerRemoteClientHandler) OnDisconnected(ctx context.Context,
 	status rpc.DisconnectStatus) {
 	if status == rpc.StartingNonFirstConnection {
 		b.log.CWarningf(ctx, "%s: disconnected", b.name)
+		b.pushStatusChange(errDisconnected{})
 	}
 	if b.authToken != nil {
 		b.authToken.Shutdown()
@@ -284,9 +313,11 @@ func (b *blockServerRemoteClientHandler) pingOnce(ctx context.Context) {
 
 type blockServerRemoteConfig interface {
 	diskBlockCacheGetter
+	kbfsOpsGetter
 	codecGetter
 	signerGetter
 	currentSessionGetterGetter
+	dialerGetter
 	logMaker
 }
 
@@ -300,16 +331,23 @@ type BlockServerRemote struct {
 	blkSrvRemote rpc.Remote
 
 	putConn *blockServerRemoteClientHandler
-	getConn *blockServerRemoteClientHandler
+	// getConns is a pool of connections used for reads, which are
+	// handed out round-robin by getConn.
+	getConns    []*blockServerRemoteClientHandler
+	nextGetConn uint32
+	// hedger is non-nil if slow reads should be hedged.
+	hedger *blockServerHedger
 }
 
 // Test that BlockServerRemote fully implements the BlockServer interface.
 var _ BlockServer = (*BlockServerRemote)(nil)
 
 // NewBlockServerRemote constructs a new BlockServerRemote for the
-// given address.
+// given address, which reads blocks over numGetConns connections.
+// Values of numGetConns below 1 are treated as 1.
 func NewBlockServerRemote(config blockServerRemoteConfig,
-	blkSrvRemote rpc.Remote, rpcLogFactory rpc.LogFactory) *BlockServerRemote {
+	blkSrvRemote rpc.Remote, rpcLogFactory rpc.LogFactory,
+	numGetConns int) *BlockServerRemote {
 	log := config.MakeLogger("BSR")
 	deferLog := log.CloneWithAddedDepth(1)
 	bs := &BlockServerRemote{
@@ -318,20 +356,41 @@ func NewBlockServerRemote(config blockServerRemoteConfig,
 		deferLog:     traceLogger{deferLog},
 		blkSrvRemote: blkSrvRemote,
 	}
-	// Use two separate auth clients -- one for writes and one for
-	// reads.  This allows small reads to avoid getting trapped behind
-	// large asynchronous writes.  TODO: use some real network QoS to
-	// achieve better prioritization within the actual network.
+	// Use separate auth clients for writes and for reads.  This
+	// allows small reads to avoid getting trapped behind large
+	// asynchronous writes.  Reads can also be spread over several
+	// connections, since a single connection can limit throughput on
+	// fast links.  TODO: use some real network QoS to achieve better
+	// prioritization within the actual network.
 	bs.putConn = newBlockServerRemoteClientHandler(
 		"BlockServerRemotePut", log, config.Signer(),
-		config.CurrentSessionGetter(), blkSrvRemote, rpcLogFactory)
-	bs.getConn = newBlockServerRemoteClientHandler(
-		"BlockServerRemoteGet", log, config.Signer(),
-		config.CurrentSessionGetter(), blkSrvRemote, rpcLogFactory)
+		config.CurrentSessionGetter(), blkSrvRemote, rpcLogFactory,
+		config.Dialer())
+	// Only the put connection reports the block server's status, so
+	// that several get connections don't fight over it.
+	bs.putConn.statusChanged = func(err error) {
+		if kbfsOps := config.KBFSOps(); kbfsOps != nil {
+			kbfsOps.PushConnectionStatusChange(BlockServiceName, err)
+		}
+	}
+	if numGetConns < 1 {
+		numGetConns = 1
+	}
+	for i := 0; i < numGetConns; i++ {
+		name := "BlockServerRemoteGet"
+		if numGetConns > 1 {
+			name = fmt.Sprintf("%s%d", name, i)
+		}
+		bs.getConns = append(bs.getConns, newBlockServerRemoteClientHandler(
+			name, log, config.Signer(), config.CurrentSessionGetter(),
+			blkSrvRemote, rpcLogFactory, config.Dialer()))
+	}
 
 	bs.shutdownFn = func() {
 		bs.putConn.shutdown()
-		bs.getConn.shutdown()
+		for _, c := range bs.getConns {
+			c.shutdown()
+		}
 	}
 	return bs
 }
@@ -350,11 +409,11 @@ func newBlockServerRemoteWithClient(config blockServerRemoteConfig,
 			deferLog: deferLog,
 			client:   client,
 		},
-		getConn: &blockServerRemoteClientHandler{
+		getConns: []*blockServerRemoteClientHandler{{
 			log:      log,
 			deferLog: deferLog,
 			client:   client,
-		},
+		}},
 	}
 	return bs
 }
@@ -364,10 +423,26 @@ func (b *BlockServerRemote) RemoteAddress() string {
 	return b.blkSrvRemote.String()
 }
 
+// numGetConns returns the number of connections used for reads.
+func (b *BlockServerRemote) numGetConns() int {
+	return len(b.getConns)
+}
+
+// getConn returns the next read connection to use.
+func (b *BlockServerRemote) getConn() *blockServerRemoteClientHandler {
+	if len(b.getConns) == 1 {
+		return b.getConns[0]
+	}
+	i := atomic.AddUint32(&b.nextGetConn, 1)
+	return b.getConns[int(i%uint32(len(b.getConns)))]
+}
+
 // RefreshAuthToken implements the AuthTokenRefreshHandler interface.
 func (b *BlockServerRemote) RefreshAuthToken(ctx context.Context) {
 	b.putConn.RefreshAuthToken(ctx)
-	b.getConn.RefreshAuthToken(ctx)
+	for _, c := range b.getConns {
+		c.RefreshAuthToken(ctx)
+	}
 }
 
 // Get implements the BlockServer interface for BlockServerRemote.
@@ -397,7 +472,7 @@ func (b *BlockServerRemote) Get(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 	}()
 
 	arg := kbfsblock.MakeGetBlockArg(tlfID, id, context)
-	res, err := b.getConn.getClient().GetBlock(ctx, arg)
+	res, err := b.getBlock(ctx, arg)
 	return kbfsblock.ParseGetBlockRes(res, err)
 }
 
@@ -532,7 +607,7 @@ func (b *BlockServerRemote) GetUserQuotaInfo(ctx context.Context) (info *kbfsblo
 	defer func() {
 		b.log.LazyTrace(ctx, "BServer: GetUserQuotaInfo done (err=%v)", err)
 	}()
-	res, err := b.getConn.getClient().GetUserQuotaInfo(ctx)
+	res, err := b.getConn().getClient().GetUserQuotaInfo(ctx)
 	return kbfsblock.ParseGetQuotaInfoRes(b.config.Codec(), res, err)
 }
 
@@ -545,7 +620,7 @@ func (b *BlockServerRemote) GetTeamQuotaInfo(
 	defer func() {
 		b.log.LazyTrace(ctx, "BServer: GetTeamQuotaInfo done (err=%v)", err)
 	}()
-	res, err := b.getConn.getClient().GetTeamQuotaInfo(ctx, tid)
+	res, err := b.getConn().getClient().GetTeamQuotaInfo(ctx, tid)
 	return kbfsblock.ParseGetQuotaInfoRes(b.config.Codec(), res, err)
 }
 
@@ -554,6 +629,8 @@ func (b *BlockServerRemote) Shutdown(ctx context.Context) {
 	if b.shutdownFn != nil {
 		b.shutdownFn()
 	}
-	b.getConn.shutdown()
+	for _, c := range b.getConns {
+		c.shutdown()
+	}
 	b.putConn.shutdown()
 }
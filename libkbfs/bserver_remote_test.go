@@ -75,6 +75,10 @@ type testBlockServerRemoteConfig struct {
 
 var _ blockServerRemoteConfig = (*testBlockServerRemoteConfig)(nil)
 
+func (c testBlockServerRemoteConfig) Dialer() Dialer {
+	return nil
+}
+
 func (c testBlockServerRemoteConfig) Signer() kbfscrypto.Signer {
 	return c.signer
 }
@@ -87,6 +91,10 @@ func (c testBlockServerRemoteConfig) DiskBlockCache() DiskBlockCache {
 	return c.diskBlockCache
 }
 
+func (c testBlockServerRemoteConfig) KBFSOps() KBFSOps {
+	return nil
+}
+
 // Test that putting a block, and getting it back, works
 func TestBServerRemotePutAndGet(t *testing.T) {
 	currentUID := keybase1.MakeTestUID(1)
@@ -153,3 +161,58 @@ func TestBServerRemotePutCanceled(t *testing.T) {
 	}
 	testRPCWithCanceledContext(t, serverConn, f)
 }
+
+type countingBServerClient struct {
+	keybase1.BlockInterface
+	gets int
+}
+
+func (c *countingBServerClient) GetBlock(
+	ctx context.Context, arg keybase1.GetBlockArg) (
+	keybase1.GetBlockRes, error) {
+	c.gets++
+	return c.BlockInterface.GetBlock(ctx, arg)
+}
+
+// Test that reads are spread over all of the get connections.
+func TestBServerRemoteGetConnPool(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+	clients := make([]*countingBServerClient, 3)
+	log := b.getConns[0].log
+	b.getConns = nil
+	for i := range clients {
+		clients[i] = &countingBServerClient{BlockInterface: &fc}
+		b.getConns = append(b.getConns, &blockServerRemoteClientHandler{
+			log:    log,
+			client: clients[i],
+		})
+	}
+	require.Equal(t, 3, b.numGetConns())
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	ctx := context.Background()
+	err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	for i := 0; i < 6; i++ {
+		buf, _, err := b.Get(ctx, tlfID, bID, bCtx)
+		require.NoError(t, err)
+		require.Equal(t, data, buf)
+	}
+	for _, c := range clients {
+		require.Equal(t, 2, c.gets)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// StorageTier says which backend of a BlockServerTiered holds a
+// block.
+type StorageTier int
+
+const (
+	// StorageTierHot is the regular block server.
+	StorageTierHot StorageTier = iota
+	// StorageTierCold is the cheaper, slower block server that blocks
+	// of old revisions are moved to.
+	StorageTierCold
+)
+
+func (t StorageTier) String() string {
+	switch t {
+	case StorageTierHot:
+		return "hot"
+	case StorageTierCold:
+		return "cold"
+	default:
+		return fmt.Sprintf("StorageTier(%d)", int(t))
+	}
+}
+
+func isBlockNonExistent(err error) bool {
+	_, ok := errors.Cause(err).(kbfsblock.ServerErrorBlockNonExistent)
+	return ok
+}
+
+// BlockServerTiered delegates to a hot BlockServer instance, but
+// moves the blocks of old revisions of some TLFs to a cold
+// BlockServer instance.  A block belongs only to old revisions once
+// all its references have been archived, so for TLFs with cold
+// storage enabled, archived block references are moved to the cold
+// server, and the block is removed from the hot server if no live
+// references remain there.  Gets of blocks missing from the hot
+// server transparently fall back to the cold server.
+//
+// It should sit directly below any journal, so that journal flushes
+// of archived references trigger the move.
+type BlockServerTiered struct {
+	BlockServer
+	cold BlockServer
+	log  logger.Logger
+
+	lock    sync.RWMutex
+	coldTLF map[tlf.ID]bool
+}
+
+var _ BlockServer = (*BlockServerTiered)(nil)
+
+// NewBlockServerTiered creates and returns a new BlockServerTiered
+// instance with the given hot and cold delegates.  Cold storage
+// starts out disabled for every TLF.
+func NewBlockServerTiered(
+	hot, cold BlockServer, log logger.Logger) *BlockServerTiered {
+	return &BlockServerTiered{
+		BlockServer: hot,
+		cold:        cold,
+		log:         log,
+		coldTLF:     make(map[tlf.ID]bool),
+	}
+}
+
+// SetColdStorage sets whether blocks of old revisions of the given
+// TLF are moved to cold storage from now on.  Disabling it doesn't
+// move any blocks back.
+func (b *BlockServerTiered) SetColdStorage(tlfID tlf.ID, enabled bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if enabled {
+		b.coldTLF[tlfID] = true
+	} else {
+		delete(b.coldTLF, tlfID)
+	}
+}
+
+// ColdStorageEnabled returns whether blocks of old revisions of the
+// given TLF are moved to cold storage.
+func (b *BlockServerTiered) ColdStorageEnabled(tlfID tlf.ID) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.coldTLF[tlfID]
+}
+
+// StorageTier returns which tier holds the given block reference.
+func (b *BlockServerTiered) StorageTier(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (StorageTier, error) {
+	_, _, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	if err == nil {
+		return StorageTierHot, nil
+	} else if !isBlockNonExistent(err) {
+		return 0, err
+	}
+	_, _, err = b.cold.Get(ctx, tlfID, id, context)
+	if err != nil {
+		return 0, err
+	}
+	return StorageTierCold, nil
+}
+
+// Get implements the BlockServer interface for BlockServerTiered.
+func (b *BlockServerTiered) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	if !isBlockNonExistent(err) {
+		return buf, serverHalf, err
+	}
+	b.log.CDebugf(ctx, "Getting block %s from cold storage", id)
+	coldBuf, coldServerHalf, coldErr := b.cold.Get(ctx, tlfID, id, context)
+	if isBlockNonExistent(coldErr) {
+		// Report the original error.
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return coldBuf, coldServerHalf, coldErr
+}
+
+// AddBlockReference implements the BlockServer interface for
+// BlockServerTiered.
+func (b *BlockServerTiered) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	err := b.BlockServer.AddBlockReference(ctx, tlfID, id, context)
+	if !isBlockNonExistent(err) {
+		return err
+	}
+	// The block may have been moved to cold storage already.
+	coldErr := b.cold.AddBlockReference(ctx, tlfID, id, context)
+	if isBlockNonExistent(coldErr) {
+		return err
+	}
+	return coldErr
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// BlockServerTiered.
+func (b *BlockServerTiered) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	liveCounts, err = b.BlockServer.RemoveBlockReferences(ctx, tlfID, contexts)
+	if err != nil {
+		return nil, err
+	}
+	coldCounts, err := b.cold.RemoveBlockReferences(ctx, tlfID, contexts)
+	if err != nil {
+		return nil, err
+	}
+	for id, count := range coldCounts {
+		liveCounts[id] += count
+	}
+	return liveCounts, nil
+}
+
+// moveToCold copies the block with the given archived references to
+// the cold server, and then removes them from the hot server.
+func (b *BlockServerTiered) moveToCold(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, contexts []kbfsblock.Context) error {
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, contexts[0])
+	if err != nil {
+		return err
+	}
+	// The cold server needs the block's first reference before any
+	// others can be added.  If that reference is still live on the
+	// hot server, it stays live on the cold server too, until it is
+	// archived or removed there as well.
+	first := kbfsblock.MakeFirstContext(
+		contexts[0].GetCreator(), contexts[0].GetBlockType())
+	err = b.cold.Put(ctx, tlfID, id, first, buf, serverHalf)
+	if err != nil {
+		return err
+	}
+	for _, context := range contexts {
+		if context.GetRefNonce() == kbfsblock.ZeroRefNonce {
+			continue
+		}
+		err := b.cold.AddBlockReference(ctx, tlfID, id, context)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.cold.ArchiveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id: contexts})
+	if err != nil {
+		return err
+	}
+
+	_, err = b.BlockServer.RemoveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id: contexts})
+	return err
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// BlockServerTiered.
+func (b *BlockServerTiered) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	err := b.BlockServer.ArchiveBlockReferences(ctx, tlfID, contexts)
+	if isBlockNonExistent(err) {
+		// Some of the blocks may have been moved to cold storage
+		// already, so try them one at a time.
+		for id, idContexts := range contexts {
+			single := kbfsblock.ContextMap{id: idContexts}
+			err := b.BlockServer.ArchiveBlockReferences(ctx, tlfID, single)
+			if isBlockNonExistent(err) {
+				err = b.cold.ArchiveBlockReferences(ctx, tlfID, single)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if !b.ColdStorageEnabled(tlfID) {
+		return nil
+	}
+
+	for id, idContexts := range contexts {
+		if len(idContexts) == 0 {
+			continue
+		}
+		// Moving is best-effort: a block left behind is still
+		// available from the hot server.
+		if err := b.moveToCold(ctx, tlfID, id, idContexts); err != nil {
+			b.log.CWarningf(ctx, "Couldn't move block %s to cold storage: %+v",
+				id, err)
+		}
+	}
+	return nil
+}
+
+// Shutdown implements the BlockServer interface for
+// BlockServerTiered.
+func (b *BlockServerTiered) Shutdown(ctx context.Context) {
+	b.BlockServer.Shutdown(ctx)
+	b.cold.Shutdown(ctx)
+}
+
+// GetBlockServerTiered returns the BlockServerTiered for the given
+// config, if cold storage is configured.
+func GetBlockServerTiered(config Config) (*BlockServerTiered, error) {
+	bserver := config.BlockServer()
+	if jbserver, ok := bserver.(journalBlockServer); ok {
+		bserver = jbserver.BlockServer
+	}
+	tiered, ok := bserver.(*BlockServerTiered)
+	if !ok {
+		return nil, errors.New("Cold storage not configured")
+	}
+	return tiered, nil
+}
+
+// GetNodeStorageTier returns which storage tier holds the block
+// backing the given node.  Blocks of nodes in the current revision
+// of a folder are normally hot; the nodes of unlinked files can be
+// backed by cold blocks.
+func GetNodeStorageTier(
+	ctx context.Context, config Config, node Node) (StorageTier, error) {
+	tiered, err := GetBlockServerTiered(config)
+	if err != nil {
+		return 0, err
+	}
+	md, err := config.KBFSOps().GetNodeMetadata(ctx, node)
+	if err != nil {
+		return 0, err
+	}
+	ptr := md.BlockInfo.BlockPointer
+	return tiered.StorageTier(
+		ctx, node.GetFolderBranch().Tlf, ptr.ID, ptr.Context)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestBlockServerTiered(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	hot := NewBlockServerMemory(log)
+	cold := NewBlockServerMemory(log)
+	b := NewBlockServerTiered(hot, cold, log)
+	ctx := context.Background()
+	defer b.Shutdown(ctx)
+
+	tlfID := tlf.FakeID(1, tlf.Private)
+	uid := keybase1.MakeTestUID(1).AsUserOrTeam()
+	putBlock := func() (kbfsblock.ID, []byte, kbfsblock.Context) {
+		id, buf, serverHalf := makeLANPeerTestBlock(t)
+		bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+		err := b.Put(ctx, tlfID, id, bCtx, buf, serverHalf)
+		require.NoError(t, err)
+		return id, buf, bCtx
+	}
+
+	t.Log("Archived blocks stay hot without cold storage")
+	id1, _, bCtx1 := putBlock()
+	err := b.ArchiveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id1: {bCtx1}})
+	require.NoError(t, err)
+	tier, err := b.StorageTier(ctx, tlfID, id1, bCtx1)
+	require.NoError(t, err)
+	require.Equal(t, StorageTierHot, tier)
+
+	t.Log("Archived blocks move to cold storage")
+	b.SetColdStorage(tlfID, true)
+	require.True(t, b.ColdStorageEnabled(tlfID))
+	id2, buf2, bCtx2 := putBlock()
+	nonce, err := kbfsblock.MakeRefNonce()
+	require.NoError(t, err)
+	bCtx2b := kbfsblock.MakeContext(uid, uid, nonce, keybase1.BlockType_DATA)
+	err = b.AddBlockReference(ctx, tlfID, id2, bCtx2b)
+	require.NoError(t, err)
+	err = b.ArchiveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id2: {bCtx2, bCtx2b}})
+	require.NoError(t, err)
+	_, _, err = hot.Get(ctx, tlfID, id2, bCtx2)
+	require.True(t, isBlockNonExistent(err))
+	tier, err = b.StorageTier(ctx, tlfID, id2, bCtx2b)
+	require.NoError(t, err)
+	require.Equal(t, StorageTierCold, tier)
+
+	t.Log("Cold blocks can still be read and referenced")
+	buf, _, err := b.Get(ctx, tlfID, id2, bCtx2)
+	require.NoError(t, err)
+	require.Equal(t, buf2, buf)
+	err = b.ArchiveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id2: {bCtx2}, id1: {bCtx1}})
+	require.NoError(t, err)
+
+	t.Log("Removing references removes them from both tiers")
+	liveCounts, err := b.RemoveBlockReferences(ctx, tlfID,
+		kbfsblock.ContextMap{id1: {bCtx1}, id2: {bCtx2, bCtx2b}})
+	require.NoError(t, err)
+	require.Equal(t, 0, liveCounts[id1])
+	require.Equal(t, 0, liveCounts[id2])
+	_, _, err = b.Get(ctx, tlfID, id2, bCtx2)
+	require.True(t, isBlockNonExistent(err))
+
+	t.Log("Live references of a partly-archived block stay hot")
+	id3, _, bCtx3 := putBlock()
+	nonce, err = kbfsblock.MakeRefNonce()
+	require.NoError(t, err)
+	bCtx3b := kbfsblock.MakeContext(uid, uid, nonce, keybase1.BlockType_DATA)
+	err = b.AddBlockReference(ctx, tlfID, id3, bCtx3b)
+	require.NoError(t, err)
+	err = b.ArchiveBlockReferences(
+		ctx, tlfID, kbfsblock.ContextMap{id3: {bCtx3b}})
+	require.NoError(t, err)
+	tier, err = b.StorageTier(ctx, tlfID, id3, bCtx3)
+	require.NoError(t, err)
+	require.Equal(t, StorageTierHot, tier)
+	tier, err = b.StorageTier(ctx, tlfID, id3, bCtx3b)
+	require.NoError(t, err)
+	require.Equal(t, StorageTierCold, tier)
+
+	b.SetColdStorage(tlfID, false)
+	require.False(t, b.ColdStorageEnabled(tlfID))
+}
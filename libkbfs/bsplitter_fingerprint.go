@@ -0,0 +1,135 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfscodec"
+)
+
+// gearTable maps each byte value to a pseudo-random 64-bit number
+// for the rolling hash of BlockSplitterFingerprint.  It must never
+// change, or clients would stop agreeing on where blocks end.
+var gearTable = makeGearTable()
+
+func makeGearTable() (table [256]uint64) {
+	// splitmix64, with a fixed seed.
+	x := uint64(0x6b626673) // "kbfs"
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// BlockSplitterFingerprint implements the BlockSplitter interface by
+// ending blocks where a rolling hash of the last 64 bytes of the
+// file matches a fixed pattern, rather than every `maxSize` bytes.
+// Since block boundaries then depend only on the nearby contents of
+// the file, inserting or removing bytes near the start of a file
+// only changes the blocks around the edit, while the blocks after it
+// keep the same contents.
+//
+// Blocks are never smaller than `minSize` (except at the end of a
+// file), nor bigger than the `maxSize` of the embedded
+// BlockSplitterSimple, which also decides the indirect block fan-out
+// and whether block changes are embedded.
+type BlockSplitterFingerprint struct {
+	BlockSplitterSimple
+	minSize int64
+	mask    uint64
+}
+
+var _ BlockSplitter = (*BlockSplitterFingerprint)(nil)
+
+// NewBlockSplitterFingerprint creates a new BlockSplitterFingerprint
+// whose blocks are, on average, about half of the desired block size,
+// and never bigger than it.
+func NewBlockSplitterFingerprint(desiredBlockSize int64,
+	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
+	*BlockSplitterFingerprint, error) {
+	bsplit, err := NewBlockSplitterSimple(
+		desiredBlockSize, blockChangeEmbedMaxSize, codec)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockSplitterFingerprint(*bsplit), nil
+}
+
+func newBlockSplitterFingerprint(
+	bsplit BlockSplitterSimple) *BlockSplitterFingerprint {
+	// Skip the first quarter of each block, and then look for a
+	// boundary with probability 1/2^bits per byte, where 2^bits is
+	// about another quarter of the max size.  That gives blocks of
+	// about half the max size on average, and only about one block
+	// in 20 has to be cut at the max size.
+	minSize := bsplit.maxSize / 4
+	bits := uint(0)
+	for int64(1)<<(bits+1) <= minSize {
+		bits++
+	}
+	return &BlockSplitterFingerprint{
+		BlockSplitterSimple: bsplit,
+		minSize:             minSize,
+		mask:                uint64(1)<<bits - 1,
+	}
+}
+
+// nextSplit returns the length of the block that starts at the
+// beginning of `data`, or -1 if `data` ends before the block does.
+func (b *BlockSplitterFingerprint) nextSplit(data []byte) int64 {
+	var hash uint64
+	for i, c := range data {
+		n := int64(i) + 1
+		if n > b.maxSize {
+			return b.maxSize
+		}
+		// Each byte is shifted out of the hash after 64 more bytes,
+		// so the hash only depends on the last 64 bytes.
+		hash = hash<<1 + gearTable[c]
+		if n >= b.minSize && hash&b.mask == 0 {
+			return n
+		}
+	}
+	if int64(len(data)) >= b.maxSize {
+		return b.maxSize
+	}
+	return -1
+}
+
+// CopyUntilSplit implements the BlockSplitter interface for
+// BlockSplitterFingerprint.  If the copy extends the block, the
+// block ends at the first boundary found in the newly-added bytes.
+func (b *BlockSplitterFingerprint) CopyUntilSplit(
+	block *FileBlock, lastBlock bool, data []byte, off int64) int64 {
+	oldLen := int64(len(block.Contents))
+	n := b.BlockSplitterSimple.CopyUntilSplit(block, lastBlock, data, off)
+	newLen := int64(len(block.Contents))
+	if newLen <= oldLen {
+		return n
+	}
+
+	// Only cut the block within the bytes that were just added;
+	// the existing bytes are fixed up by CheckSplit when the file
+	// is synced.
+	splitAt := b.nextSplit(block.Contents)
+	if splitAt > oldLen && splitAt > off && splitAt < newLen {
+		block.Contents = block.Contents[:splitAt]
+		n = splitAt - off
+	}
+	return n
+}
+
+// CheckSplit implements the BlockSplitter interface for
+// BlockSplitterFingerprint.
+func (b *BlockSplitterFingerprint) CheckSplit(block *FileBlock) int64 {
+	splitAt := b.nextSplit(block.Contents)
+	if splitAt == int64(len(block.Contents)) {
+		return 0
+	}
+	return splitAt
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestBsplitterFingerprint() *BlockSplitterFingerprint {
+	return newBlockSplitterFingerprint(BlockSplitterSimple{4096, 5, 10})
+}
+
+// fingerprintChunks splits `data` into blocks the way `bsplit` would
+// if `data` were written sequentially.
+func fingerprintChunks(
+	bsplit *BlockSplitterFingerprint, data []byte) (chunks []string) {
+	for len(data) > 0 {
+		n := bsplit.nextSplit(data)
+		if n < 0 {
+			n = int64(len(data))
+		}
+		chunks = append(chunks, string(data[:n]))
+		data = data[n:]
+	}
+	return chunks
+}
+
+func TestBsplitterFingerprintSizes(t *testing.T) {
+	bsplit := makeTestBsplitterFingerprint()
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := fingerprintChunks(bsplit, data)
+	for i, c := range chunks {
+		require.True(t, int64(len(c)) <= bsplit.maxSize)
+		if i < len(chunks)-1 {
+			require.True(t, int64(len(c)) >= bsplit.minSize)
+		}
+	}
+	// On average, blocks should be well under the max size.
+	require.True(t, int64(len(chunks)) > 2*int64(len(data))/bsplit.maxSize)
+}
+
+func TestBsplitterFingerprintInsertion(t *testing.T) {
+	bsplit := makeTestBsplitterFingerprint()
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	chunks := fingerprintChunks(bsplit, data)
+	oldChunks := make(map[string]bool, len(chunks))
+	for _, c := range chunks {
+		oldChunks[c] = true
+	}
+
+	t.Log("Insert one byte near the start of the file")
+	newData := append([]byte(nil), data[:100]...)
+	newData = append(newData, 0xff)
+	newData = append(newData, data[100:]...)
+	newChunks := fingerprintChunks(bsplit, newData)
+	changed := 0
+	for _, c := range newChunks {
+		if !oldChunks[c] {
+			changed++
+		}
+	}
+	require.True(t, changed <= 2, "%d of %d blocks changed",
+		changed, len(newChunks))
+}
+
+func TestBsplitterFingerprintCopyUntilSplit(t *testing.T) {
+	bsplit := makeTestBsplitterFingerprint()
+	data := make([]byte, 3*bsplit.maxSize)
+	rand.New(rand.NewSource(2)).Read(data)
+	splitAt := bsplit.nextSplit(data)
+	require.True(t, splitAt > 0)
+
+	t.Log("Appending stops at the first boundary")
+	fblock := NewFileBlock().(*FileBlock)
+	n := bsplit.CopyUntilSplit(fblock, true, data, 0)
+	require.Equal(t, splitAt, n)
+	require.Equal(t, data[:splitAt], fblock.Contents)
+	require.Equal(t, int64(0), bsplit.CheckSplit(fblock))
+
+	t.Log("Appending in pieces finds the same boundary")
+	fblock = NewFileBlock().(*FileBlock)
+	var copied int64
+	for copied < splitAt {
+		end := copied + 100
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		n := bsplit.CopyUntilSplit(fblock, true, data[copied:end], copied)
+		require.True(t, n > 0)
+		copied += n
+	}
+	require.Equal(t, splitAt, copied)
+	require.Equal(t, data[:splitAt], fblock.Contents)
+
+	t.Log("A block that runs past its boundary must be split")
+	fblock.Contents = append([]byte(nil), data[:bsplit.maxSize]...)
+	if splitAt < bsplit.maxSize {
+		require.Equal(t, splitAt, bsplit.CheckSplit(fblock))
+	}
+
+	t.Log("A block that stops before its boundary needs more bytes")
+	fblock.Contents = append([]byte(nil), data[:bsplit.minSize]...)
+	require.Equal(t, int64(-1), bsplit.CheckSplit(fblock))
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	stdpath "path"
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// compareTreesReadSize is how much file data is read at a time when
+// hashing file contents.
+const compareTreesReadSize = 512 * 1024
+
+// TreeDiff describes how one subtree differs from another.  All the
+// paths are relative to the roots of the compared subtrees, and each
+// list is sorted.
+type TreeDiff struct {
+	// Added lists the entries that only exist in the second
+	// subtree.  A directory that only exists there is listed by
+	// itself, without its children.
+	Added []string
+	// Removed lists the entries that only exist in the first
+	// subtree, in the same way.
+	Removed []string
+	// Modified lists the entries that exist in both subtrees, but
+	// with different types or contents.  Directories are never
+	// listed as modified themselves; their differing children are
+	// listed instead.
+	Modified []string
+}
+
+// hashFileContents returns the SHA-256 hash of the contents of the
+// file `node`, which is `size` bytes long.
+func hashFileContents(
+	ctx context.Context, kbfsOps KBFSOps, node Node, size uint64) (
+	[]byte, error) {
+	h := sha256.New()
+	buf := make([]byte, compareTreesReadSize)
+	for off := int64(0); off < int64(size); {
+		n, err := kbfsOps.Read(ctx, node, buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		h.Write(buf[:n])
+		off += n
+	}
+	return h.Sum(nil), nil
+}
+
+type treeComparer struct {
+	kbfsOps  KBFSOps
+	sameTlf  bool
+	treeDiff TreeDiff
+}
+
+// sameBlocks returns true if `nodeA` and `nodeB` are known to have
+// identical contents without looking at them, because they point to
+// the same block.  That can only happen within a single TLF.
+func (tc *treeComparer) sameBlocks(
+	ctx context.Context, nodeA, nodeB Node) (bool, error) {
+	if !tc.sameTlf {
+		return false, nil
+	}
+	mdA, err := tc.kbfsOps.GetNodeMetadata(ctx, nodeA)
+	if err != nil {
+		return false, err
+	}
+	mdB, err := tc.kbfsOps.GetNodeMetadata(ctx, nodeB)
+	if err != nil {
+		return false, err
+	}
+	return mdA.BlockInfo.ID == mdB.BlockInfo.ID, nil
+}
+
+func (tc *treeComparer) compareFiles(ctx context.Context,
+	nodeA Node, eiA EntryInfo, nodeB Node, eiB EntryInfo) (bool, error) {
+	if eiA.Size != eiB.Size {
+		return false, nil
+	}
+	same, err := tc.sameBlocks(ctx, nodeA, nodeB)
+	if err != nil {
+		return false, err
+	}
+	if same {
+		return true, nil
+	}
+	hashA, err := hashFileContents(ctx, tc.kbfsOps, nodeA, eiA.Size)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := hashFileContents(ctx, tc.kbfsOps, nodeB, eiB.Size)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
+
+func (tc *treeComparer) compareDirs(
+	ctx context.Context, p string, dirA, dirB Node) error {
+	same, err := tc.sameBlocks(ctx, dirA, dirB)
+	if err != nil {
+		return err
+	}
+	if same {
+		return nil
+	}
+
+	childrenA, err := tc.kbfsOps.GetDirChildren(ctx, dirA)
+	if err != nil {
+		return err
+	}
+	childrenB, err := tc.kbfsOps.GetDirChildren(ctx, dirB)
+	if err != nil {
+		return err
+	}
+
+	for name := range childrenA {
+		if _, ok := childrenB[name]; !ok {
+			tc.treeDiff.Removed = append(
+				tc.treeDiff.Removed, stdpath.Join(p, name))
+		}
+	}
+	for name, eiB := range childrenB {
+		childPath := stdpath.Join(p, name)
+		eiA, ok := childrenA[name]
+		if !ok {
+			tc.treeDiff.Added = append(tc.treeDiff.Added, childPath)
+			continue
+		}
+
+		// Exec and regular files with the same contents still
+		// differ, since their types are different.
+		if eiA.Type != eiB.Type {
+			tc.treeDiff.Modified = append(tc.treeDiff.Modified, childPath)
+			continue
+		}
+		if eiA.Type == Sym {
+			if eiA.SymPath != eiB.SymPath {
+				tc.treeDiff.Modified = append(
+					tc.treeDiff.Modified, childPath)
+			}
+			continue
+		}
+
+		childA, _, err := tc.kbfsOps.Lookup(ctx, dirA, name)
+		if err != nil {
+			return err
+		}
+		childB, _, err := tc.kbfsOps.Lookup(ctx, dirB, name)
+		if err != nil {
+			return err
+		}
+		if eiA.Type == Dir {
+			err = tc.compareDirs(ctx, childPath, childA, childB)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		same, err := tc.compareFiles(ctx, childA, eiA, childB, eiB)
+		if err != nil {
+			return err
+		}
+		if !same {
+			tc.treeDiff.Modified = append(tc.treeDiff.Modified, childPath)
+		}
+	}
+	return nil
+}
+
+// CompareTrees returns the differences between the subtree rooted at
+// the directory `nodeA` and the one rooted at the directory `nodeB`,
+// which may be in different TLFs.  Files of the same size are
+// compared by the SHA-256 hashes of their contents, which means
+// reading every byte of both files, so comparing large trees across
+// TLFs is expensive.  Only within a single TLF can entries that point
+// to the same block (for example, unchanged subtrees, or files made
+// by KBFSOps.CopyFile) be skipped without reading them.  Mtimes and
+// ctimes are ignored.
+func CompareTrees(ctx context.Context, kbfsOps KBFSOps, nodeA, nodeB Node) (
+	TreeDiff, error) {
+	tc := &treeComparer{
+		kbfsOps: kbfsOps,
+		sameTlf: nodeA.GetFolderBranch() == nodeB.GetFolderBranch(),
+	}
+	err := tc.compareDirs(ctx, "", nodeA, nodeB)
+	if err != nil {
+		return TreeDiff{}, err
+	}
+	sort.Strings(tc.treeDiff.Added)
+	sort.Strings(tc.treeDiff.Removed)
+	sort.Strings(tc.treeDiff.Modified)
+	return tc.treeDiff, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareTrees(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps()
+	makeFile := func(dir Node, name, data string) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte(data), 0)
+		require.NoError(t, err)
+	}
+	makeTree := func(root Node, bContents string) Node {
+		makeFile(root, "a", "same")
+		makeFile(root, "b", bContents)
+		dNode, _, err := kbfsOps.CreateDir(ctx, root, "d")
+		require.NoError(t, err)
+		makeFile(dNode, "c", "same")
+		err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+		require.NoError(t, err)
+		return dNode
+	}
+
+	t.Log("Make similar trees in a private and a public TLF")
+	privRoot := GetRootNodeOrBust(
+		ctx, t, config, u1.String(), tlf.Private)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	privD := makeTree(privRoot, "old")
+	pubD := makeTree(pubRoot, "new")
+	makeFile(privRoot, "removed", "x")
+	makeFile(pubD, "added", "y")
+	_, _, err := kbfsOps.CreateDir(ctx, pubRoot, "newdir")
+	require.NoError(t, err)
+
+	diff, err := CompareTrees(ctx, kbfsOps, privRoot, pubRoot)
+	require.NoError(t, err)
+	require.Equal(t, []string{"d/added", "newdir"}, diff.Added)
+	require.Equal(t, []string{"removed"}, diff.Removed)
+	require.Equal(t, []string{"b"}, diff.Modified)
+
+	t.Log("Identical subtrees within one TLF have no differences")
+	copyD, _, err := kbfsOps.CreateDir(ctx, privRoot, "copy")
+	require.NoError(t, err)
+	makeFile(copyD, "c", "same")
+	diff, err = CompareTrees(ctx, kbfsOps, privD, copyD)
+	require.NoError(t, err)
+	require.Empty(t, diff.Added)
+	require.Empty(t, diff.Removed)
+	require.Empty(t, diff.Modified)
+
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, pubRoot.GetFolderBranch())
+	require.NoError(t, err)
+}
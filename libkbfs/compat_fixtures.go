@@ -0,0 +1,491 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CompatFixtureKind says what a CompatFixture holds.
+type CompatFixtureKind string
+
+const (
+	// CompatFixtureMD is a signed root metadata object, as sent to
+	// the MD server.
+	CompatFixtureMD CompatFixtureKind = "md"
+	// CompatFixtureFileBlock is an encrypted direct file block, as
+	// sent to the block server.
+	CompatFixtureFileBlock CompatFixtureKind = "file-block"
+	// CompatFixtureDirBlock is an encrypted directory block, as sent
+	// to the block server.
+	CompatFixtureDirBlock CompatFixtureKind = "dir-block"
+)
+
+// CompatFixture is a piece of encoded and encrypted data exactly as
+// KBFS stores it on the servers.  Fixtures generated by one build can
+// be saved and checked with VerifyCompatFixture by every later build,
+// on any platform, to make sure that changes to the code don't
+// accidentally change how existing data is decoded.  Each fixture is
+// made from fixed, fake keys and contents, so verifying it only needs
+// the fixture itself.
+type CompatFixture struct {
+	Name string
+	Kind CompatFixtureKind
+	// MetadataVer is the metadata version of an MD fixture.
+	MetadataVer kbfsmd.MetadataVer `json:",omitempty"`
+	// Data is the encoded RootMetadataSigned for an MD fixture, or
+	// the encoded EncryptedBlock for a block fixture.
+	Data []byte
+	// WriterKeyBundle and ReaderKeyBundle are the encoded key
+	// bundles of an MD fixture with segregated key bundles.
+	WriterKeyBundle []byte `json:",omitempty"`
+	ReaderKeyBundle []byte `json:",omitempty"`
+	// BlockID and ServerHalf are the ID and server half of the key
+	// of a block fixture.
+	BlockID    *kbfsblock.ID                       `json:",omitempty"`
+	ServerHalf *kbfscrypto.BlockCryptKeyServerHalf `json:",omitempty"`
+}
+
+// The fixed inputs the fixtures are made from.  These must never
+// change, or fixtures saved by earlier builds will stop verifying.
+var (
+	compatFixtureTlfID = tlf.FakeID(1, tlf.Private)
+	compatFixtureUID   = keybase1.MakeTestUID(1)
+	// compatFixtureTLFCryptKey is the TLF crypt key that
+	// kbfsmd.FakeInitialRekey uses.
+	compatFixtureTLFCryptKey = kbfscrypto.MakeTLFCryptKey([32]byte{0x1})
+	compatFixtureServerHalf  = kbfscrypto.MakeBlockCryptKeyServerHalf(
+		[32]byte{0x2})
+	compatFixtureContents = bytes.Repeat(
+		[]byte("KBFS compatibility fixture. "), 16)
+)
+
+func compatFixtureSigningKey() kbfscrypto.SigningKey {
+	return kbfscrypto.MakeFakeSigningKeyOrBust("kbfs compat fixtures")
+}
+
+func compatFixtureFileEntry() DirEntry {
+	return DirEntry{
+		BlockInfo: BlockInfo{
+			BlockPointer: BlockPointer{
+				ID:      kbfsblock.FakeID(2),
+				KeyGen:  kbfsmd.FirstValidKeyGen,
+				DataVer: FirstValidDataVer,
+				Context: kbfsblock.MakeFirstContext(
+					compatFixtureUID.AsUserOrTeam(),
+					keybase1.BlockType_DATA),
+			},
+			EncodedSize: 512,
+		},
+		EntryInfo: EntryInfo{
+			Type:  File,
+			Size:  uint64(len(compatFixtureContents)),
+			Mtime: 1500000000000000000,
+			Ctime: 1500000000000000000,
+			Mode:  0640,
+		},
+		Xattrs: map[string][]byte{"user.compat": []byte("fixture")},
+	}
+}
+
+func compatFixtureRootEntry() DirEntry {
+	return DirEntry{
+		BlockInfo: BlockInfo{
+			BlockPointer: BlockPointer{
+				ID:      kbfsblock.FakeID(1),
+				KeyGen:  kbfsmd.FirstValidKeyGen,
+				DataVer: FirstValidDataVer,
+				Context: kbfsblock.MakeFirstContext(
+					compatFixtureUID.AsUserOrTeam(),
+					keybase1.BlockType_DATA),
+			},
+			EncodedSize: 256,
+		},
+		EntryInfo: EntryInfo{
+			Type:  Dir,
+			Size:  256,
+			Mtime: 1500000000000000000,
+			Ctime: 1500000000000000000,
+		},
+	}
+}
+
+func compatFixturePrivateMetadata() PrivateMetadata {
+	return PrivateMetadata{
+		Dir:            compatFixtureRootEntry(),
+		LastGCRevision: kbfsmd.RevisionInitial,
+	}
+}
+
+func compatFixtureBlock(kind CompatFixtureKind) (Block, error) {
+	switch kind {
+	case CompatFixtureFileBlock:
+		return &FileBlock{Contents: compatFixtureContents}, nil
+	case CompatFixtureDirBlock:
+		return &DirBlock{Children: map[string]DirEntry{
+			"f": compatFixtureFileEntry(),
+		}}, nil
+	default:
+		return nil, errors.Errorf("Unknown block fixture kind %s", kind)
+	}
+}
+
+func generateCompatMDFixture(ctx context.Context, codec kbfscodec.Codec,
+	ver kbfsmd.MetadataVer) (CompatFixture, error) {
+	bh, err := tlf.MakeHandle(
+		[]keybase1.UserOrTeamID{compatFixtureUID.AsUserOrTeam()},
+		nil, nil, nil, nil)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	brmd, err := kbfsmd.MakeInitialRootMetadata(ver, compatFixtureTlfID, bh)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	extra := kbfsmd.FakeInitialRekey(brmd, bh, kbfscrypto.TLFPublicKey{})
+	brmd.SetLastModifyingWriter(compatFixtureUID)
+	brmd.SetLastModifyingUser(compatFixtureUID)
+
+	crypto := MakeCryptoCommon(codec)
+	encryptedPmd, err := crypto.EncryptPrivateMetadata(
+		compatFixturePrivateMetadata(), compatFixtureTLFCryptKey)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	serializedPmd, err := codec.Encode(encryptedPmd)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	brmd.SetSerializedPrivateMetadata(serializedPmd)
+
+	signer := kbfscrypto.SigningKeySigner{Key: compatFixtureSigningKey()}
+	err = brmd.SignWriterMetadataInternally(ctx, codec, signer)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	rmds, err := kbfsmd.SignRootMetadata(ctx, codec, signer, signer, brmd)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	buf, err := kbfsmd.EncodeRootMetadataSigned(codec, rmds)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+
+	// The MD reports the version it's encoded with, which can be
+	// older than `ver` for a plain TLF.
+	fixture := CompatFixture{
+		Name:        fmt.Sprintf("md-v%d", rmds.Version()),
+		Kind:        CompatFixtureMD,
+		MetadataVer: rmds.Version(),
+		Data:        buf,
+	}
+	if extraV3, ok := extra.(*kbfsmd.ExtraMetadataV3); ok {
+		fixture.WriterKeyBundle, err = codec.Encode(
+			extraV3.GetWriterKeyBundle())
+		if err != nil {
+			return CompatFixture{}, err
+		}
+		fixture.ReaderKeyBundle, err = codec.Encode(
+			extraV3.GetReaderKeyBundle())
+		if err != nil {
+			return CompatFixture{}, err
+		}
+	}
+	return fixture, nil
+}
+
+func makeCompatBlockFixture(codec kbfscodec.Codec, name string,
+	kind CompatFixtureKind, serverHalf kbfscrypto.BlockCryptKeyServerHalf,
+	encryptedBlock kbfscrypto.EncryptedBlock) (CompatFixture, error) {
+	buf, err := codec.Encode(encryptedBlock)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	id, err := kbfsblock.MakePermanentID(buf)
+	if err != nil {
+		return CompatFixture{}, err
+	}
+	return CompatFixture{
+		Name:       name,
+		Kind:       kind,
+		Data:       buf,
+		BlockID:    &id,
+		ServerHalf: &serverHalf,
+	}, nil
+}
+
+func generateCompatBlockFixtures(codec kbfscodec.Codec) (
+	[]CompatFixture, error) {
+	crypto := MakeCryptoCommon(codec)
+	var fixtures []CompatFixture
+
+	// Blocks written through Crypto.EncryptBlock, with an
+	// independent server half, and without compression.
+	block, err := compatFixtureBlock(CompatFixtureFileBlock)
+	if err != nil {
+		return nil, err
+	}
+	_, encryptedBlock, err := crypto.EncryptBlock(block,
+		kbfscrypto.UnmaskBlockCryptKey(
+			compatFixtureServerHalf, compatFixtureTLFCryptKey))
+	if err != nil {
+		return nil, err
+	}
+	fixture, err := makeCompatBlockFixture(codec, "file-block-legacy",
+		CompatFixtureFileBlock, compatFixtureServerHalf, encryptedBlock)
+	if err != nil {
+		return nil, err
+	}
+	fixtures = append(fixtures, fixture)
+
+	for _, kind := range []CompatFixtureKind{
+		CompatFixtureFileBlock, CompatFixtureDirBlock} {
+		for _, padding := range []BlockPadding{
+			BlockPaddingPowerOfTwo, BlockPaddingMinimal} {
+			for _, compression := range []BlockCompression{
+				BlockCompressionNone, BlockCompressionSnappy,
+				BlockCompressionFlate} {
+				block, err := compatFixtureBlock(kind)
+				if err != nil {
+					return nil, err
+				}
+				_, _, serverHalf, encryptedBlock, err :=
+					crypto.encryptBlockConvergent(block,
+						compatFixtureTLFCryptKey, padding, compression)
+				if err != nil {
+					return nil, err
+				}
+				fixture, err := makeCompatBlockFixture(codec,
+					fmt.Sprintf("%s-%s-%s", kind, padding, compression),
+					kind, serverHalf, encryptedBlock)
+				if err != nil {
+					return nil, err
+				}
+				fixtures = append(fixtures, fixture)
+			}
+		}
+	}
+	return fixtures, nil
+}
+
+// GenerateCompatFixtures makes a fixture for each metadata version
+// this build can write, and for each kind of block, padding and
+// compression, using `codec`, which should be the codec used by
+// Config.Codec().
+func GenerateCompatFixtures(ctx context.Context, codec kbfscodec.Codec) (
+	[]CompatFixture, error) {
+	var fixtures []CompatFixture
+	for _, ver := range []kbfsmd.MetadataVer{
+		kbfsmd.PreExtraMetadataVer, kbfsmd.SegregatedKeyBundlesVer} {
+		fixture, err := generateCompatMDFixture(ctx, codec, ver)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+
+	blockFixtures, err := generateCompatBlockFixtures(codec)
+	if err != nil {
+		return nil, err
+	}
+	return append(fixtures, blockFixtures...), nil
+}
+
+func verifyCompatMDFixture(ctx context.Context, codec kbfscodec.Codec,
+	fixture CompatFixture) error {
+	rmds, err := kbfsmd.DecodeRootMetadataSigned(codec, compatFixtureTlfID,
+		fixture.MetadataVer, defaultClientMetadataVer, fixture.Data)
+	if err != nil {
+		return err
+	}
+
+	var extra kbfsmd.ExtraMetadata
+	if fixture.MetadataVer >= kbfsmd.SegregatedKeyBundlesVer {
+		var wkb kbfsmd.TLFWriterKeyBundleV3
+		err = codec.Decode(fixture.WriterKeyBundle, &wkb)
+		if err != nil {
+			return err
+		}
+		var rkb kbfsmd.TLFReaderKeyBundleV3
+		err = codec.Decode(fixture.ReaderKeyBundle, &rkb)
+		if err != nil {
+			return err
+		}
+		extra = kbfsmd.NewExtraMetadataV3(wkb, rkb, false, false)
+	}
+	err = rmds.IsValidAndSigned(ctx, codec, nil, extra)
+	if err != nil {
+		return err
+	}
+
+	verifyingKey := compatFixtureSigningKey().GetVerifyingKey()
+	md := rmds.MD
+	switch {
+	case rmds.Version() != fixture.MetadataVer:
+		return errors.Errorf("Decoded version %d, expected %d",
+			rmds.Version(), fixture.MetadataVer)
+	case md.TlfID() != compatFixtureTlfID:
+		return errors.Errorf("Decoded TLF ID %s, expected %s",
+			md.TlfID(), compatFixtureTlfID)
+	case md.RevisionNumber() != kbfsmd.RevisionInitial:
+		return errors.Errorf("Decoded revision %d, expected %d",
+			md.RevisionNumber(), kbfsmd.RevisionInitial)
+	case md.LatestKeyGeneration() != kbfsmd.FirstValidKeyGen:
+		return errors.Errorf("Decoded key generation %d, expected %d",
+			md.LatestKeyGeneration(), kbfsmd.FirstValidKeyGen)
+	case md.LastModifyingWriter() != compatFixtureUID:
+		return errors.Errorf("Decoded last writer %s, expected %s",
+			md.LastModifyingWriter(), compatFixtureUID)
+	case rmds.SigInfo.VerifyingKey != verifyingKey ||
+		rmds.GetWriterMetadataSigInfo().VerifyingKey != verifyingKey:
+		return errors.New("MD wasn't signed by the fixture key")
+	}
+
+	var encryptedPmd kbfscrypto.EncryptedPrivateMetadata
+	err = codec.Decode(md.GetSerializedPrivateMetadata(), &encryptedPmd)
+	if err != nil {
+		return err
+	}
+	pmd, err := MakeCryptoCommon(codec).DecryptPrivateMetadata(
+		encryptedPmd, compatFixtureTLFCryptKey)
+	if err != nil {
+		return err
+	}
+	expectedPmd := compatFixturePrivateMetadata()
+	if pmd.LastGCRevision != expectedPmd.LastGCRevision {
+		return errors.Errorf("Decoded last GC revision %d, expected %d",
+			pmd.LastGCRevision, expectedPmd.LastGCRevision)
+	}
+	equal, err := kbfscodec.Equal(codec, pmd.Dir, expectedPmd.Dir)
+	if err != nil {
+		return err
+	}
+	if !equal {
+		return errors.Errorf("Decoded root entry %+v, expected %+v",
+			pmd.Dir, expectedPmd.Dir)
+	}
+	return nil
+}
+
+func verifyCompatBlockFixture(
+	codec kbfscodec.Codec, fixture CompatFixture) error {
+	if fixture.BlockID == nil || fixture.ServerHalf == nil {
+		return errors.Errorf("Block fixture %s is missing its ID or "+
+			"server half", fixture.Name)
+	}
+	err := kbfsblock.VerifyID(fixture.Data, *fixture.BlockID)
+	if err != nil {
+		return err
+	}
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	err = codec.Decode(fixture.Data, &encryptedBlock)
+	if err != nil {
+		return err
+	}
+
+	expected, err := compatFixtureBlock(fixture.Kind)
+	if err != nil {
+		return err
+	}
+	block := expected.NewEmpty()
+	key := kbfscrypto.UnmaskBlockCryptKey(
+		*fixture.ServerHalf, compatFixtureTLFCryptKey)
+	err = MakeCryptoCommon(codec).DecryptBlock(encryptedBlock, key, block)
+	if err != nil {
+		return err
+	}
+	equal, err := kbfscodec.Equal(codec, block, expected)
+	if err != nil {
+		return err
+	}
+	if !equal {
+		return errors.Errorf("Decoded block %+v, expected %+v",
+			block, expected)
+	}
+	return nil
+}
+
+// VerifyCompatFixture checks that this build can still decode,
+// verify and decrypt `fixture`, which may have been made by an
+// earlier build, and that it gets back exactly the contents the
+// fixture was made from.
+func VerifyCompatFixture(ctx context.Context, codec kbfscodec.Codec,
+	fixture CompatFixture) error {
+	var err error
+	switch fixture.Kind {
+	case CompatFixtureMD:
+		err = verifyCompatMDFixture(ctx, codec, fixture)
+	case CompatFixtureFileBlock, CompatFixtureDirBlock:
+		err = verifyCompatBlockFixture(codec, fixture)
+	default:
+		err = errors.Errorf("Unknown fixture kind %s", fixture.Kind)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Compat fixture %s", fixture.Name)
+	}
+	return nil
+}
+
+// WriteCompatFixtures writes each of `fixtures` to its own JSON file,
+// named after the fixture, in `dir`, creating `dir` if needed.
+func WriteCompatFixtures(dir string, fixtures []CompatFixture) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, fixture := range fixtures {
+		buf, err := json.MarshalIndent(fixture, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = ioutil.WriteFile(
+			filepath.Join(dir, fixture.Name+".json"), buf, 0600)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// ReadCompatFixtures reads all the fixtures written to `dir` by
+// WriteCompatFixtures, sorted by name.  A missing `dir` has no
+// fixtures.
+func ReadCompatFixtures(dir string) ([]CompatFixture, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Strings(names)
+	fixtures := make([]CompatFixture, 0, len(names))
+	for _, name := range names {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var fixture CompatFixture
+		err = json.Unmarshal(buf, &fixture)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't parse %s", name)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
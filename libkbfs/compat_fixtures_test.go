@@ -0,0 +1,80 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+var updateCompatFixtures = flag.Bool("update-compat-fixtures", false,
+	"Write new golden compat fixtures to "+compatFixturesDir)
+
+var compatFixturesDir = filepath.Join("testdata", "compat")
+
+func TestCompatFixturesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	codec := kbfscodec.NewMsgpack()
+	fixtures, err := GenerateCompatFixtures(ctx, codec)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "compat_fixtures")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+	err = WriteCompatFixtures(dir, fixtures)
+	require.NoError(t, err)
+	readFixtures, err := ReadCompatFixtures(dir)
+	require.NoError(t, err)
+	require.Len(t, readFixtures, len(fixtures))
+
+	for _, fixture := range readFixtures {
+		err := VerifyCompatFixture(ctx, codec, fixture)
+		require.NoError(t, err)
+
+		t.Logf("Corrupting %s makes it fail", fixture.Name)
+		fixture.Data = append([]byte(nil), fixture.Data...)
+		fixture.Data[len(fixture.Data)-1] ^= 0x1
+		err = VerifyCompatFixture(ctx, codec, fixture)
+		require.Error(t, err)
+	}
+}
+
+// TestCompatFixturesGolden checks that the fixtures saved by earlier
+// builds can still be read.  Golden fixtures should only ever be
+// added, never regenerated, since that would defeat the purpose.
+func TestCompatFixturesGolden(t *testing.T) {
+	ctx := context.Background()
+	codec := kbfscodec.NewMsgpack()
+	if *updateCompatFixtures {
+		fixtures, err := GenerateCompatFixtures(ctx, codec)
+		require.NoError(t, err)
+		err = WriteCompatFixtures(compatFixturesDir, fixtures)
+		require.NoError(t, err)
+	}
+
+	fixtures, err := ReadCompatFixtures(compatFixturesDir)
+	require.NoError(t, err)
+	if len(fixtures) == 0 {
+		t.Skipf("No golden fixtures in %s; run with "+
+			"-update-compat-fixtures to write them", compatFixturesDir)
+	}
+	for _, fixture := range fixtures {
+		fixture := fixture // capture range variable.
+		t.Run(fixture.Name, func(t *testing.T) {
+			err := VerifyCompatFixture(ctx, codec, fixture)
+			require.NoError(t, err)
+		})
+	}
+}
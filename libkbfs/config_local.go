@@ -5,7 +5,10 @@
 package libkbfs
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -61,6 +64,8 @@ const (
 	keyBundlesCacheCapacityBytes = 10 * cache.MB
 	// folder name for persisted config parameters.
 	syncedTlfConfigFolderName = "synced_tlf_config"
+	// folder name for persisted per-TLF exclude patterns.
+	excludePatternsConfigFolderName = "exclude_patterns_config"
 
 	// By default, this will be the block type given to all blocks
 	// that aren't explicitly some other type.
@@ -100,17 +105,27 @@ type ConfigLocal struct {
 	noBGFlush        bool // logic opposite so the default value is the common setting
 	rwpWaitTime      time.Duration
 	diskLimiter      DiskLimiter
+	diskSpaceMonitor *diskSpaceMonitor
 	syncedTlfs       map[tlf.ID]bool
+	excludePatterns  map[tlf.ID]*ExcludePatterns
 	defaultBlockType keybase1.BlockType
 	kbfsService      *KBFSService
 	kbCtx            Context
 	rootNodeWrappers []func(Node) Node
 
-	maxNameBytes  uint32
-	maxDirBytes   uint64
-	rekeyQueue    RekeyQueue
-	storageRoot   string
-	diskCacheMode DiskCacheMode
+	maxNameBytes   uint32
+	maxDirBytes    uint64
+	rekeyQueue     RekeyQueue
+	storageRoot    string
+	diskCacheMode  DiskCacheMode
+	networkMode    NetworkMode
+	netConstraints NetworkConstraints
+	dialer         Dialer
+	logPathsMode   LogPathsMode
+	logRedactKey   []byte
+	// logFilePath is where the KBFS log is written, if anywhere;
+	// it's set once during init.
+	logFilePath string
 
 	traceLock    sync.RWMutex
 	traceEnabled bool
@@ -135,6 +150,36 @@ type ConfigLocal struct {
 	// before syncing a set of changes to the servers.
 	bgFlushPeriod time.Duration
 
+	// opTimeouts says how long each class of KBFSOps call may run.
+	opTimeouts OpTimeoutPolicy
+	// retryPolicy says when failed MD and block server calls are
+	// retried.
+	retryPolicy RetryPolicy
+
+	// frontendCaps restricts which classes of KBFSOps calls each
+	// frontend may make.  Frontends that aren't in it may make any
+	// call.
+	frontendCaps map[RequestFrontend]OpCapabilities
+
+	// maxSymlinkLevels limits how many symlinks are followed while
+	// resolving a single path.
+	maxSymlinkLevels int
+
+	// dirListingWorkers limits how many children of a directory
+	// are fetched in parallel while listing it.
+	dirListingWorkers int
+
+	// readAhead controls how sequential file reads are read ahead.
+	readAhead ReadAheadParams
+
+	// remoteChangePolicy controls what folders do when they're
+	// changed remotely while they have unsynced local changes.
+	remoteChangePolicy RemoteChangePolicy
+
+	// blockSplitPolicy controls how newly-written file blocks are
+	// split and padded; bsplit is kept in line with it.
+	blockSplitPolicy BlockSplitPolicy
+
 	// metadataVersion is the version to use when creating new metadata.
 	metadataVersion kbfsmd.MetadataVer
 
@@ -185,6 +230,53 @@ func (m *DiskCacheMode) Set(s string) error {
 	return nil
 }
 
+// LogPathsMode says how much of a file name or path KBFS may reveal
+// in its logs.
+type LogPathsMode int
+
+var _ flag.Value = (*LogPathsMode)(nil)
+
+const (
+	// LogPathsFull indicates to log names and paths as-is.
+	LogPathsFull LogPathsMode = iota
+	// LogPathsHashed indicates to replace each name or path with a
+	// token that is stable for the life of the process, so that
+	// operations on the same file can still be matched up.
+	LogPathsHashed
+	// LogPathsNone indicates to leave names and paths out of logs
+	// entirely.
+	LogPathsNone
+)
+
+// String outputs a human-readable description of this LogPathsMode.
+func (m LogPathsMode) String() string {
+	switch m {
+	case LogPathsFull:
+		return "full"
+	case LogPathsHashed:
+		return "hashed"
+	case LogPathsNone:
+		return "none"
+	}
+	return "unknown"
+}
+
+// Set parses a string representing a log paths mode, and sets this
+// LogPathsMode to the corresponding value.
+func (m *LogPathsMode) Set(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "full":
+		*m = LogPathsFull
+	case "hashed":
+		*m = LogPathsHashed
+	case "none":
+		*m = LogPathsNone
+	default:
+		return errors.Errorf("Unknown log paths mode %q", s)
+	}
+	return nil
+}
+
 var _ Config = (*ConfigLocal)(nil)
 
 // LocalUser represents a fake KBFS user, useful for testing.
@@ -383,6 +475,7 @@ func NewConfigLocal(mode InitMode,
 	if diskCacheMode == DiskCacheModeLocal {
 		config.loadSyncedTlfsLocked()
 	}
+	config.loadExcludePatternsLocked()
 	config.SetClock(wallClock{})
 	config.SetReporter(NewReporterSimple(config.Clock(), 10))
 	config.SetConflictRenamer(WriterDeviceDateConflictRenamer{config})
@@ -410,6 +503,12 @@ func NewConfigLocal(mode InitMode,
 	config.tlfValidDuration = tlfValidDurationDefault
 	config.bgFlushDirOpBatchSize = bgFlushDirOpBatchSizeDefault
 	config.bgFlushPeriod = bgFlushPeriodDefault
+	config.opTimeouts = DefaultOpTimeoutPolicy()
+	config.retryPolicy = DefaultRetryPolicy()
+	config.maxSymlinkLevels = DefaultMaxSymlinkLevels
+	config.dirListingWorkers = DefaultDirListingWorkers
+	config.readAhead = DefaultReadAheadParams()
+	config.blockSplitPolicy = DefaultBlockSplitPolicy()
 	config.metadataVersion = defaultClientMetadataVer
 	config.defaultBlockType = defaultBlockTypeDefault
 	config.quotaUsage =
@@ -742,6 +841,26 @@ func (c *ConfigLocal) SetBlockSplitter(b BlockSplitter) {
 	c.bsplit = b
 }
 
+// BlockSplitPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockSplitPolicy() BlockSplitPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blockSplitPolicy
+}
+
+// SetBlockSplitPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetBlockSplitPolicy(p BlockSplitPolicy) error {
+	bsplit, err := NewBlockSplitterFromPolicy(p, c.Codec())
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockSplitPolicy = p
+	c.bsplit = bsplit
+	return nil
+}
+
 // Notifier implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) Notifier() Notifier {
 	c.lock.RLock()
@@ -800,7 +919,7 @@ func (c *ConfigLocal) SetMetadataVersion(mdVer kbfsmd.MetadataVer) {
 
 // DataVersion implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) DataVersion() DataVer {
-	return AtLeastTwoLevelsOfChildrenDataVer
+	return CompressedBlocksDataVer
 }
 
 // DefaultBlockType implements the Config interface for ConfigLocal.
@@ -876,11 +995,26 @@ func (c *ConfigLocal) QuotaReclamationPeriod() time.Duration {
 	return c.qrPeriod
 }
 
+// SetQuotaReclamationPeriod sets how often each TLF checks for
+// quota to reclaim.  A period of 0 disables quota reclamation.  It
+// only affects TLFs that are opened after the call.
+func (c *ConfigLocal) SetQuotaReclamationPeriod(p time.Duration) {
+	c.qrPeriod = p
+}
+
 // QuotaReclamationMinUnrefAge implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) QuotaReclamationMinUnrefAge() time.Duration {
 	return c.qrUnrefAge
 }
 
+// SetQuotaReclamationMinUnrefAge sets how long a block must have
+// been unreferenced before quota reclamation deletes it, i.e. how
+// far back the file history that can still be restored reaches.  It
+// only affects TLFs that are opened after the call.
+func (c *ConfigLocal) SetQuotaReclamationMinUnrefAge(age time.Duration) {
+	c.qrUnrefAge = age
+}
+
 // QuotaReclamationMinHeadAge implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) QuotaReclamationMinHeadAge() time.Duration {
 	return c.qrMinHeadAge
@@ -1068,6 +1202,122 @@ func (c *ConfigLocal) BGFlushDirOpBatchSize() int {
 	return c.bgFlushDirOpBatchSize
 }
 
+// NetworkMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) NetworkMode() NetworkMode {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.networkMode
+}
+
+// SetNetworkMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetNetworkMode(
+	ctx context.Context, mode NetworkMode) error {
+	switch mode {
+	case NetworkModeNormal, NetworkModeUploadOnly, NetworkModeDownloadOnly:
+	default:
+		return errors.Errorf("Unknown network mode: %d", mode)
+	}
+
+	c.lock.Lock()
+	c.networkMode = mode
+	c.lock.Unlock()
+
+	if jServer, err := GetJournalServer(c); err == nil {
+		jServer.setUploadsPaused(ctx, !mode.uploadsEnabled())
+	}
+	return nil
+}
+
+// NetworkConstraints implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) NetworkConstraints() NetworkConstraints {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.netConstraints
+}
+
+// SetNetworkConstraints implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetNetworkConstraints(metered bool, maxRate int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.netConstraints = NetworkConstraints{
+		Metered:        metered,
+		MaxBytesPerSec: maxRate,
+	}
+}
+
+// Dialer implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) Dialer() Dialer {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dialer
+}
+
+// SetDialer implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetDialer(d Dialer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dialer = d
+}
+
+// LogPathsMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) LogPathsMode() LogPathsMode {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.logPathsMode
+}
+
+// SetLogPathsMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetLogPathsMode(mode LogPathsMode) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if mode == LogPathsHashed {
+		if _, err := c.logRedactKeyLocked(); err != nil {
+			return err
+		}
+	}
+	c.logPathsMode = mode
+	return nil
+}
+
+// logRedactKeyLocked returns the key used to hash names and paths
+// for the logs, making it first if needed.
+func (c *ConfigLocal) logRedactKeyLocked() ([]byte, error) {
+	if c.logRedactKey == nil {
+		// A fresh key per process means the tokens can't be
+		// matched against a precomputed table of common names.
+		key := make([]byte, sha256.Size)
+		if err := kbfscrypto.RandRead(key); err != nil {
+			return nil, err
+		}
+		c.logRedactKey = key
+	}
+	return c.logRedactKey, nil
+}
+
+func hashLogPath(key []byte, p string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(p))
+	return fmt.Sprintf("<redacted:%x>", mac.Sum(nil)[:8])
+}
+
+// RedactLogPath implements the logPathRedactor interface for
+// ConfigLocal.
+func (c *ConfigLocal) RedactLogPath(p string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if p == "" {
+		return p
+	}
+	switch c.logPathsMode {
+	case LogPathsHashed:
+		return hashLogPath(c.logRedactKey, p)
+	case LogPathsNone:
+		return "<redacted>"
+	default:
+		return p
+	}
+}
+
 // SetBGFlushPeriod implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) SetBGFlushPeriod(p time.Duration) {
 	c.lock.Lock()
@@ -1082,6 +1332,113 @@ func (c *ConfigLocal) BGFlushPeriod() time.Duration {
 	return c.bgFlushPeriod
 }
 
+// OpTimeouts implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) OpTimeouts() OpTimeoutPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.opTimeouts
+}
+
+// SetOpTimeouts implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetOpTimeouts(p OpTimeoutPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.opTimeouts = p
+}
+
+// RetryPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) RetryPolicy() RetryPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.retryPolicy
+}
+
+// SetRetryPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetRetryPolicy(p RetryPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.retryPolicy = p
+}
+
+// FrontendCapabilities implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) FrontendCapabilities(
+	frontend RequestFrontend) OpCapabilities {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if caps, ok := c.frontendCaps[frontend]; ok {
+		return caps
+	}
+	return OpCapsAll
+}
+
+// SetFrontendCapabilities implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetFrontendCapabilities(
+	frontend RequestFrontend, caps OpCapabilities) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.frontendCaps == nil {
+		c.frontendCaps = make(map[RequestFrontend]OpCapabilities)
+	}
+	c.frontendCaps[frontend] = caps
+}
+
+// MaxSymlinkLevels implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) MaxSymlinkLevels() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maxSymlinkLevels
+}
+
+// SetMaxSymlinkLevels implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetMaxSymlinkLevels(levels int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxSymlinkLevels = levels
+}
+
+// DirListingWorkers implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DirListingWorkers() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dirListingWorkers
+}
+
+// SetDirListingWorkers implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetDirListingWorkers(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dirListingWorkers = n
+}
+
+// ReadAhead implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) ReadAhead() ReadAheadParams {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.readAhead
+}
+
+// SetReadAhead implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetReadAhead(p ReadAheadParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readAhead = p
+}
+
+// RemoteChangePolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) RemoteChangePolicy() RemoteChangePolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.remoteChangePolicy
+}
+
+// SetRemoteChangePolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetRemoteChangePolicy(p RemoteChangePolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remoteChangePolicy = p
+}
+
 // Shutdown implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	c.RekeyQueue().Shutdown()
@@ -1093,7 +1450,7 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 			if !ok {
 				continue
 			}
-			for _, fbo := range kbfsOps.ops {
+			for _, fbo := range kbfsOps.ops.snapshot() {
 				if err := fbo.fbm.waitForArchives(ctx); err != nil {
 					return err
 				}
@@ -1144,6 +1501,9 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	if err != nil {
 		errorList = append(errorList, err)
 	}
+	if c.diskSpaceMonitor != nil {
+		c.diskSpaceMonitor.shutdown()
+	}
 	dbc := c.DiskBlockCache()
 	if dbc != nil {
 		dbc.Shutdown(ctx)
@@ -1237,6 +1597,11 @@ func (c *ConfigLocal) EnableDiskLimiter(configRoot string) error {
 		return err
 	}
 	c.diskLimiter = diskLimiter
+
+	if !c.IsTestMode() {
+		c.diskSpaceMonitor = newDiskSpaceMonitor(c, configRoot)
+		c.diskSpaceMonitor.start()
+	}
 	return nil
 }
 
@@ -1382,7 +1747,21 @@ func (c *ConfigLocal) IsSyncedTlf(tlfID tlf.ID) bool {
 }
 
 // SetTlfSyncState implements the Config interface for ConfigLocal.
-func (c *ConfigLocal) SetTlfSyncState(tlfID tlf.ID, isSynced bool) error {
+func (c *ConfigLocal) SetTlfSyncState(tlfID tlf.ID, isSynced bool) (
+	err error) {
+	var dbc DiskBlockCache
+	defer func() {
+		// Forget about any interrupted deep sync of an unsynced TLF.
+		// This happens outside of the lock, since the disk cache
+		// checks the synced TLFs while holding its own lock.
+		cache, ok := dbc.(*diskBlockCacheWrapped)
+		if err != nil || isSynced || !ok {
+			return
+		}
+		if progress := cache.getPrefetchProgress(); progress != nil {
+			err = progress.removeTlf(tlfID)
+		}
+	}()
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if isSynced {
@@ -1418,10 +1797,161 @@ func (c *ConfigLocal) SetTlfSyncState(tlfID tlf.ID, isSynced bool) error {
 		}
 	}
 	c.syncedTlfs[tlfID] = isSynced
+	dbc = c.diskBlockCache
 	<-c.bops.TogglePrefetcher(true)
 	return nil
 }
 
+func (c *ConfigLocal) loadExcludePatternsLocked() (err error) {
+	excludePatterns := make(map[tlf.ID]*ExcludePatterns)
+	c.excludePatterns = excludePatterns
+	if c.IsTestMode() || c.storageRoot == "" {
+		return nil
+	}
+	ldb, err := c.openConfigLevelDB(excludePatternsConfigFolderName)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	log := c.MakeLogger("")
+	// If there are any un-parseable entries, delete them.
+	deleteBatch := new(leveldb.Batch)
+	for iter.Next() {
+		key := string(iter.Key())
+		tlfID, err := tlf.ParseID(key)
+		if err != nil {
+			log.Debug("deleting TLF %s from exclude pattern list", key)
+			deleteBatch.Delete(iter.Key())
+			continue
+		}
+		patterns, err := ParseExcludePatterns(
+			strings.Split(string(iter.Value()), "\n"))
+		if err != nil {
+			log.Debug("deleting bad exclude patterns for TLF %s: %+v",
+				key, err)
+			deleteBatch.Delete(iter.Key())
+			continue
+		}
+		excludePatterns[tlfID] = patterns
+	}
+	return ldb.Write(deleteBatch, nil)
+}
+
+// TlfExcludePatterns implements the syncedTlfGetterSetter interface
+// for ConfigLocal.
+func (c *ConfigLocal) TlfExcludePatterns(tlfID tlf.ID) *ExcludePatterns {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.excludePatterns[tlfID]
+}
+
+// SetTlfExcludePatterns implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetTlfExcludePatterns(
+	tlfID tlf.ID, patterns []string) error {
+	excludePatterns, err := ParseExcludePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.IsTestMode() && c.storageRoot != "" {
+		ldb, err := c.openConfigLevelDB(excludePatternsConfigFolderName)
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		tlfBytes, err := tlfID.MarshalText()
+		if err != nil {
+			return err
+		}
+		if len(patterns) > 0 {
+			err = ldb.Put(
+				tlfBytes, []byte(strings.Join(patterns, "\n")), nil)
+		} else {
+			err = ldb.Delete(tlfBytes, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.excludePatterns == nil {
+		c.excludePatterns = make(map[tlf.ID]*ExcludePatterns)
+	}
+	if len(patterns) > 0 {
+		c.excludePatterns[tlfID] = excludePatterns
+	} else {
+		delete(c.excludePatterns, tlfID)
+	}
+	return nil
+}
+
+// WipeLocalData implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) WipeLocalData(
+	ctx context.Context, progress func(LocalDataWipeProgress)) error {
+	log := c.MakeLogger("")
+	session, err := c.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	log.CDebugf(ctx, "Wiping local data for user %s", session.UID)
+
+	var roots []string
+	if jServer, err := GetJournalServer(c); err == nil {
+		// Stop all journal activity before the files go away.
+		// Journals stay disabled until the next login.
+		jServer.shutdownExistingJournals(ctx)
+		dirs, err := jServer.journalDirsForUser(ctx, session.UID)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, dirs...)
+	}
+
+	// Detach the disk block cache and the per-TLF settings.  The
+	// cache gets recreated on the next login.
+	dbc := func() DiskBlockCache {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		dbc := c.diskBlockCache
+		if c.diskCacheMode == DiskCacheModeLocal {
+			c.diskBlockCache = nil
+			if c.storageRoot != "" {
+				roots = append(roots,
+					filepath.Join(c.storageRoot, workingSetCacheFolderName),
+					filepath.Join(c.storageRoot, syncCacheFolderName),
+					filepath.Join(c.storageRoot, syncedTlfConfigFolderName))
+			}
+		}
+		if c.storageRoot != "" {
+			roots = append(roots, filepath.Join(
+				c.storageRoot, excludePatternsConfigFolderName))
+		}
+		c.syncedTlfs = make(map[tlf.ID]bool)
+		c.excludePatterns = make(map[tlf.ID]*ExcludePatterns)
+		return dbc
+	}()
+	if dbc != nil && c.diskCacheMode == DiskCacheModeLocal {
+		// Shutdown outside of the lock, since it waits for any
+		// outstanding cache operations.
+		dbc.Shutdown(ctx)
+	}
+
+	c.ResetCaches()
+
+	w := &localDataWiper{roots: roots, progress: progress}
+	err = w.wipe(ctx)
+	if err != nil {
+		return err
+	}
+	log.CDebugf(ctx, "Wiped %d files (%d bytes) of local data",
+		w.status.FilesWiped, w.status.BytesWiped)
+	return nil
+}
+
 // PrefetchStatus implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) PrefetchStatus(ctx context.Context, tlfID tlf.ID,
 	ptr BlockPointer) PrefetchStatus {
@@ -2065,8 +2065,10 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 
 		fileActions := actionMap[p.tailPointer()]
 
-		// If this is a directory with setAttr(mtime)-related actions,
-		// just those action should be collapsed into the parent.
+		// If this is a directory with setAttr(mtime)-related actions
+		// (or actions related to any other attr that can be set on
+		// a directory), just those action should be collapsed into
+		// the parent.
 		if !chain.isFile() {
 			var parentActions crActionList
 			var otherDirActions crActionList
@@ -2074,13 +2076,14 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 				moved := false
 				switch realAction := action.(type) {
 				case *copyUnmergedAttrAction:
-					if realAction.attr[0] == mtimeAttr && !realAction.moved {
+					if realAction.attr[0].canApplyToDir() &&
+						!realAction.moved {
 						realAction.moved = true
 						parentActions = append(parentActions, realAction)
 						moved = true
 					}
 				case *renameUnmergedAction:
-					if realAction.causedByAttr == mtimeAttr &&
+					if realAction.causedByAttr.canApplyToDir() &&
 						!realAction.moved {
 						realAction.moved = true
 						parentActions = append(parentActions, realAction)
@@ -3138,6 +3141,7 @@ outer:
 	handle := head.GetTlfHandle()
 	cr.config.Reporter().ReportErr(
 		ctx, handle.GetCanonicalName(), handle.Type(), WriteMode, reportedError)
+	cr.fbo.recordSignificantErr(reportedError)
 	return nil
 }
 
@@ -3170,6 +3174,7 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 			cr.config.Reporter().ReportErr(
 				ctx, handle.GetCanonicalName(), handle.Type(),
 				WriteMode, CRWrapError{err})
+			cr.fbo.recordSignificantErr(CRWrapError{err})
 			if err == context.Canceled {
 				cr.inputLock.Lock()
 				defer cr.inputLock.Unlock()
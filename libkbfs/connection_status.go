@@ -5,13 +5,17 @@
 package libkbfs
 
 import (
+	"fmt"
 	"sync"
+
+	"golang.org/x/net/context"
 )
 
 // Service names used in ConnectionStatus.
 const (
 	KeybaseServiceName     = "keybase-service"
 	MDServiceName          = "md-server"
+	BlockServiceName       = "block-server"
 	GregorServiceName      = "gregor"
 	LoginStatusUpdateName  = "login"
 	LogoutStatusUpdateName = "logout"
@@ -21,10 +25,85 @@ type errDisconnected struct{}
 
 func (errDisconnected) Error() string { return "Disconnected" }
 
+// ConnectionState summarizes whether KBFS can reach the services it
+// depends on.
+type ConnectionState int
+
+const (
+	// ConnectionOnline means that all the services are reachable.
+	ConnectionOnline ConnectionState = iota
+	// ConnectionDegraded means that the MD and block servers are
+	// reachable, but the keybase service, which KBPKI relies on, or
+	// gregor isn't, so some lookups and notifications may fail.
+	ConnectionDegraded
+	// ConnectionOffline means that the MD server or the block server
+	// is unreachable, so only locally cached data can be relied on.
+	ConnectionOffline
+)
+
+var connectionStateNames = map[ConnectionState]string{
+	ConnectionOnline:   "online",
+	ConnectionDegraded: "degraded",
+	ConnectionOffline:  "offline",
+}
+
+func (s ConnectionState) String() string {
+	if name, ok := connectionStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// ConnectionState.
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+// for ConnectionState.
+func (s *ConnectionState) UnmarshalText(text []byte) error {
+	for state, name := range connectionStateNames {
+		if name == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown connection state %q", text)
+}
+
+// connectionStateFromFailures returns the aggregate connection state,
+// given the currently failing services.
+func connectionStateFromFailures(failing map[string]error) ConnectionState {
+	if failing[MDServiceName] != nil || failing[BlockServiceName] != nil {
+		return ConnectionOffline
+	}
+	if failing[KeybaseServiceName] != nil || failing[GregorServiceName] != nil {
+		return ConnectionDegraded
+	}
+	return ConnectionOnline
+}
+
+// ConnectionObserver is notified of changes to the aggregate
+// connection state.  The callback should not block, or register or
+// unregister connection observers.
+type ConnectionObserver interface {
+	// ConnectionStateChange announces that the connection state has
+	// changed to `state`, with the given services failing.
+	ConnectionStateChange(ctx context.Context, state ConnectionState,
+		failing map[string]error)
+}
+
 type kbfsCurrentStatus struct {
 	lock            sync.Mutex
 	failingServices map[string]error
 	invalidateChan  chan StatusUpdate
+	state           ConnectionState
+	observers       []ConnectionObserver
+
+	// notifyLock is held while notifying observers of a change, so
+	// that they see the changes in order.
+	notifyLock sync.Mutex
 }
 
 // Init inits the kbfsCurrentStatus.
@@ -33,6 +112,33 @@ func (kcs *kbfsCurrentStatus) Init() {
 	kcs.invalidateChan = make(chan StatusUpdate)
 }
 
+// ConnectionState returns the current aggregate connection state.
+func (kcs *kbfsCurrentStatus) ConnectionState() ConnectionState {
+	kcs.lock.Lock()
+	defer kcs.lock.Unlock()
+	return kcs.state
+}
+
+// AddObserver registers obs for connection state changes.
+func (kcs *kbfsCurrentStatus) AddObserver(obs ConnectionObserver) {
+	kcs.lock.Lock()
+	defer kcs.lock.Unlock()
+	kcs.observers = append(kcs.observers, obs)
+}
+
+// RemoveObserver unregisters obs from connection state changes.
+func (kcs *kbfsCurrentStatus) RemoveObserver(obs ConnectionObserver) {
+	kcs.lock.Lock()
+	defer kcs.lock.Unlock()
+	observers := make([]ConnectionObserver, 0, len(kcs.observers))
+	for _, o := range kcs.observers {
+		if o != obs {
+			observers = append(observers, o)
+		}
+	}
+	kcs.observers = observers
+}
+
 // CurrentStatus returns a copy of the current status.
 func (kcs *kbfsCurrentStatus) CurrentStatus() (map[string]error, chan StatusUpdate) {
 	kcs.lock.Lock()
@@ -45,10 +151,42 @@ func (kcs *kbfsCurrentStatus) CurrentStatus() (map[string]error, chan StatusUpda
 	return res, kcs.invalidateChan
 }
 
-// PushConnectionStatusChange pushes a change to the connection status of one of the services.
+// PushConnectionStatusChange pushes a change to the connection status
+// of one of the services, and notifies the observers if that changes
+// the aggregate connection state.
 func (kcs *kbfsCurrentStatus) PushConnectionStatusChange(service string, err error) {
+	kcs.notifyLock.Lock()
+	defer kcs.notifyLock.Unlock()
+
+	changed, state, failing, observers := kcs.pushConnectionStatusChange(
+		service, err)
+	if !changed {
+		return
+	}
+	ctx := context.Background()
+	for _, obs := range observers {
+		obs.ConnectionStateChange(ctx, state, failing)
+	}
+}
+
+func (kcs *kbfsCurrentStatus) pushConnectionStatusChange(
+	service string, err error) (changed bool, state ConnectionState,
+	failing map[string]error, observers []ConnectionObserver) {
 	kcs.lock.Lock()
 	defer kcs.lock.Unlock()
+	defer func() {
+		state = connectionStateFromFailures(kcs.failingServices)
+		if state == kcs.state {
+			return
+		}
+		kcs.state = state
+		changed = true
+		failing = make(map[string]error, len(kcs.failingServices))
+		for k, v := range kcs.failingServices {
+			failing[k] = v
+		}
+		observers = append([]ConnectionObserver(nil), kcs.observers...)
+	}()
 
 	if err != nil {
 		// Exit early if the service is already failed, to avoid an
@@ -69,6 +207,7 @@ func (kcs *kbfsCurrentStatus) PushConnectionStatusChange(service string, err err
 
 	close(kcs.invalidateChan)
 	kcs.invalidateChan = make(chan StatusUpdate)
+	return
 }
 
 // PushStatusChange forces a new status be fetched by status listeners.
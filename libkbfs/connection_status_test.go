@@ -0,0 +1,82 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testConnectionObserver struct {
+	states  []ConnectionState
+	failing []map[string]error
+}
+
+func (o *testConnectionObserver) ConnectionStateChange(
+	_ context.Context, state ConnectionState, failing map[string]error) {
+	o.states = append(o.states, state)
+	o.failing = append(o.failing, failing)
+}
+
+func TestConnectionStatusTransitions(t *testing.T) {
+	var kcs kbfsCurrentStatus
+	kcs.Init()
+	obs := &testConnectionObserver{}
+	kcs.AddObserver(obs)
+	require.Equal(t, ConnectionOnline, kcs.ConnectionState())
+
+	t.Log("Losing the keybase service degrades the connection")
+	kcs.PushConnectionStatusChange(KeybaseServiceName, errDisconnected{})
+	require.Equal(t, ConnectionDegraded, kcs.ConnectionState())
+
+	t.Log("Losing the block server takes us offline")
+	kcs.PushConnectionStatusChange(BlockServiceName, errDisconnected{})
+	require.Equal(t, ConnectionOffline, kcs.ConnectionState())
+
+	t.Log("Failures that don't change the state aren't announced")
+	kcs.PushConnectionStatusChange(MDServiceName, errDisconnected{})
+	kcs.PushConnectionStatusChange(BlockServiceName, nil)
+	require.Equal(t, ConnectionOffline, kcs.ConnectionState())
+
+	t.Log("Reconnecting everything brings us back online")
+	kcs.PushConnectionStatusChange(MDServiceName, nil)
+	require.Equal(t, ConnectionDegraded, kcs.ConnectionState())
+	kcs.PushConnectionStatusChange(KeybaseServiceName, nil)
+	require.Equal(t, ConnectionOnline, kcs.ConnectionState())
+
+	require.Equal(t, []ConnectionState{
+		ConnectionDegraded, ConnectionOffline, ConnectionDegraded,
+		ConnectionOnline,
+	}, obs.states)
+	require.Equal(t, map[string]error{
+		KeybaseServiceName: errDisconnected{},
+		BlockServiceName:   errDisconnected{},
+	}, obs.failing[1])
+	require.Len(t, obs.failing[3], 0)
+
+	t.Log("Unregistered observers aren't notified")
+	kcs.RemoveObserver(obs)
+	kcs.PushConnectionStatusChange(GregorServiceName, errDisconnected{})
+	require.Equal(t, ConnectionDegraded, kcs.ConnectionState())
+	require.Len(t, obs.states, 4)
+}
+
+func TestConnectionStateText(t *testing.T) {
+	for _, state := range []ConnectionState{
+		ConnectionOnline, ConnectionDegraded, ConnectionOffline,
+	} {
+		text, err := state.MarshalText()
+		require.NoError(t, err)
+		var decoded ConnectionState
+		err = decoded.UnmarshalText(text)
+		require.NoError(t, err)
+		require.Equal(t, state, decoded)
+	}
+	var decoded ConnectionState
+	err := decoded.UnmarshalText([]byte("sideways"))
+	require.Error(t, err)
+}
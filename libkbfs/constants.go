@@ -31,3 +31,11 @@ const registerForUpdatesFireNowThreshold = 10 * time.Minute
 // dialerTimeout is the TCP dial timeout used by mdserver and bserver RPC
 // connections.
 const dialerTimeout = 16 * time.Second
+
+// DefaultMaxSymlinkLevels is the default limit on how many symlinks
+// are followed while resolving a single path, the same as on Linux.
+const DefaultMaxSymlinkLevels = 40
+
+// DefaultDirListingWorkers is the default number of children of a
+// directory whose blocks are fetched in parallel while listing it.
+const DefaultDirListingWorkers = 10
@@ -100,7 +100,7 @@ func (cuea *copyUnmergedEntryAction) swapUnmergedBlock(
 			// should abort the swap.  Otherwise save the changed
 			// attributes so we can re-apply them during do().
 			if sao, ok := op.(*setAttrOp); ok {
-				cuea.attr = append(cuea.attr, sao.Attr)
+				cuea.attr = append(cuea.attr, sao.getAttr())
 			} else {
 				return false, zeroPtr, nil
 			}
@@ -190,6 +190,11 @@ func (cuea *copyUnmergedEntryAction) do(ctx context.Context,
 				unmergedEntry.Type = cuea.unmergedEntry.Type
 			case mtimeAttr:
 				unmergedEntry.Mtime = cuea.unmergedEntry.Mtime
+			case xattrAttr:
+				unmergedEntry.Xattrs = cuea.unmergedEntry.Xattrs
+			case modeAttr:
+				unmergedEntry.Type = cuea.unmergedEntry.Type
+				unmergedEntry.Mode = cuea.unmergedEntry.Mode
 			}
 		}
 	}
@@ -410,6 +415,11 @@ func (cuaa *copyUnmergedAttrAction) do(ctx context.Context,
 			mergedEntry.Type = unmergedEntry.Type
 		case mtimeAttr:
 			mergedEntry.Mtime = unmergedEntry.Mtime
+		case xattrAttr:
+			mergedEntry.Xattrs = unmergedEntry.Xattrs
+		case modeAttr:
+			mergedEntry.Type = unmergedEntry.Type
+			mergedEntry.Mode = unmergedEntry.Mode
 		case sizeAttr:
 			mergedEntry.Size = unmergedEntry.Size
 			mergedEntry.EncodedSize = unmergedEntry.EncodedSize
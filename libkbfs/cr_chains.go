@@ -238,9 +238,10 @@ func (cc *crChain) identifyType(ctx context.Context, fbo *folderBlockOps,
 	}
 
 	// If any op is setAttr (ex or size) or sync, this is a file
-	// chain.  If it only has a setAttr/mtime, we don't know what it
-	// is, so fall through and fetch the block unless we come across
-	// another op that can determine the type.
+	// chain.  If it only has setAttrs that can apply to directories
+	// (like mtime), we don't know what it is, so fall through and
+	// fetch the block unless we come across another op that can
+	// determine the type.
 	var parentDir BlockPointer
 	for _, op := range cc.ops {
 		switch realOp := op.(type) {
@@ -248,11 +249,12 @@ func (cc *crChain) identifyType(ctx context.Context, fbo *folderBlockOps,
 			cc.file = true
 			return nil
 		case *setAttrOp:
-			if realOp.Attr != mtimeAttr {
+			if !realOp.getAttr().canApplyToDir() {
 				cc.file = true
 				return nil
 			}
-			// We can't tell the file type from an mtimeAttr, so we
+			// We can't tell the file type from an mtimeAttr (or
+			// any other attr that can apply to dirs), so we
 			// may have to actually fetch the block to figure it out.
 			parentDir = realOp.Dir.Ref
 		default:
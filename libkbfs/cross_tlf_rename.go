@@ -0,0 +1,270 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	stdpath "path"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// crossTlfRenameChunkSize is how much file data is copied at a time
+// during a rename across TLFs.
+const crossTlfRenameChunkSize = 64 * 1024
+
+// CrossTlfRenameProgress describes how far along a rename across
+// TLFs is.
+type CrossTlfRenameProgress struct {
+	// Path is the file being copied, relative to the parent of the
+	// renamed entry, or the empty string once everything has been
+	// copied.
+	Path        string
+	FilesCopied int
+	FilesTotal  int
+	BytesCopied int64
+	BytesTotal  int64
+}
+
+// SetCrossTlfRenameFallback sets whether renames between two
+// different TLFs are carried out by copying the renamed entry into
+// the new TLF and then removing it from the old one, rather than
+// failing with RenameAcrossDirsError.  If progress is non-nil, it is
+// called as the copy goes along.  Such renames aren't atomic, and
+// the data of every copied file is re-encrypted with the keys of the
+// new TLF, so they can take a long time for large trees.
+func (fs *KBFSOpsStandard) SetCrossTlfRenameFallback(
+	enabled bool, progress func(CrossTlfRenameProgress)) {
+	fs.crossTlfRenameLock.Lock()
+	defer fs.crossTlfRenameLock.Unlock()
+	fs.crossTlfRenameEnabled = enabled
+	fs.crossTlfRenameProgress = progress
+}
+
+// crossTlfRenameFallback returns whether the copy fallback is
+// enabled, and the progress function to use for it.
+func (fs *KBFSOpsStandard) crossTlfRenameFallback() (
+	bool, func(CrossTlfRenameProgress)) {
+	fs.crossTlfRenameLock.Lock()
+	defer fs.crossTlfRenameLock.Unlock()
+	return fs.crossTlfRenameEnabled, fs.crossTlfRenameProgress
+}
+
+// crossTlfCopier copies an entry and everything under it from one
+// TLF to another, reporting its progress along the way.
+type crossTlfCopier struct {
+	fs       *KBFSOpsStandard
+	progress func(CrossTlfRenameProgress)
+	status   CrossTlfRenameProgress
+	// exclude, if set, lists the entries of the source TLF to leave
+	// out of the copy.  excludeBase is the path, relative to the
+	// root of the source TLF, that the relative paths passed to the
+	// copying methods are relative to.
+	exclude     *ExcludePatterns
+	excludeBase string
+}
+
+func (c *crossTlfCopier) report() {
+	if c.progress != nil {
+		c.progress(c.status)
+	}
+}
+
+// tally counts the files and bytes under the given entry.
+func (c *crossTlfCopier) tally(
+	ctx context.Context, node Node, ei EntryInfo) error {
+	switch ei.Type {
+	case File, Exec:
+		c.status.FilesTotal++
+		c.status.BytesTotal += int64(ei.Size)
+	case Dir:
+		children, err :=
+			c.fs.getOpsByNode(ctx, node).GetDirChildren(ctx, node)
+		if err != nil {
+			return err
+		}
+		for name, childEI := range children {
+			var childNode Node
+			if childEI.Type == Dir {
+				childNode, _, err = c.fs.getOpsByNode(ctx, node).Lookup(
+					ctx, node, name)
+				if err != nil {
+					return err
+				}
+			}
+			err = c.tally(ctx, childNode, childEI)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *crossTlfCopier) copyFile(ctx context.Context, srcNode Node,
+	dstNode Node, size uint64, relPath string) error {
+	srcOps := c.fs.getOpsByNode(ctx, srcNode)
+	dstOps := c.fs.getOpsByNode(ctx, dstNode)
+	c.status.Path = relPath
+	c.report()
+	buf := make([]byte, crossTlfRenameChunkSize)
+	for off := int64(0); off < int64(size); {
+		n, err := srcOps.Read(ctx, srcNode, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// The file got shorter while we were copying it.
+			break
+		}
+		err = dstOps.Write(ctx, dstNode, buf[:n], off)
+		if err != nil {
+			return err
+		}
+		off += n
+		c.status.BytesCopied += n
+		c.report()
+	}
+	c.status.FilesCopied++
+	return nil
+}
+
+// copyEntry copies srcName in srcParent to dstName in dstParent,
+// which must not exist yet.  It returns whether dstName was created,
+// even if copying its contents failed afterward.
+func (c *crossTlfCopier) copyEntry(ctx context.Context, srcParent Node,
+	srcName string, dstParent Node, dstName string, relPath string) (
+	created bool, err error) {
+	srcOps := c.fs.getOpsByNode(ctx, srcParent)
+	dstOps := c.fs.getOpsByNode(ctx, dstParent)
+	srcNode, ei, err := srcOps.Lookup(ctx, srcParent, srcName)
+	if err != nil {
+		return false, err
+	}
+
+	var dstNode Node
+	switch ei.Type {
+	case Sym:
+		_, err = dstOps.CreateLink(ctx, dstParent, dstName, ei.SymPath)
+		return err == nil, err
+	case Dir:
+		dstNode, _, err = dstOps.CreateDir(ctx, dstParent, dstName)
+		if err != nil {
+			return false, err
+		}
+		children, err := srcOps.GetDirChildren(ctx, srcNode)
+		if err != nil {
+			return true, err
+		}
+		children = c.exclude.filterChildren(
+			stdpath.Join(c.excludeBase, relPath), children)
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, err = c.copyEntry(ctx, srcNode, name, dstNode, name,
+				stdpath.Join(relPath, name))
+			if err != nil {
+				return true, err
+			}
+		}
+	case File, Exec:
+		dstNode, _, err = dstOps.CreateFile(
+			ctx, dstParent, dstName, ei.Type == Exec, WithExcl)
+		if err != nil {
+			return false, err
+		}
+		err = c.copyFile(ctx, srcNode, dstNode, ei.Size, relPath)
+		if err != nil {
+			return true, err
+		}
+	default:
+		return false, errors.Errorf("Unknown entry type %s", ei.Type)
+	}
+
+	mtime := time.Unix(0, ei.Mtime)
+	return true, dstOps.SetMtime(ctx, dstNode, &mtime)
+}
+
+// removeRenameTarget removes the entry that a rename of an entry of
+// type srcType to dstName in dstParent would replace, if there is
+// one, following the same rules as a rename within a TLF.
+func (fs *KBFSOpsStandard) removeRenameTarget(ctx context.Context,
+	srcType EntryType, dstParent Node, dstName string) error {
+	dstOps := fs.getOpsByNode(ctx, dstParent)
+	_, dstEI, err := dstOps.Lookup(ctx, dstParent, dstName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	dstPath := dstOps.nodeCache.PathFromNode(dstParent).ChildPathNoPtr(
+		dstName)
+	switch {
+	case dstEI.Type == Dir && srcType != Dir:
+		return NotDirError{dstPath}
+	case dstEI.Type != Dir && srcType == Dir:
+		return NotFileError{dstPath}
+	case dstEI.Type == Dir:
+		// This fails unless the directory is empty.
+		return dstOps.RemoveDir(ctx, dstParent, dstName)
+	default:
+		return dstOps.RemoveEntry(ctx, dstParent, dstName)
+	}
+}
+
+// renameAcrossTlfs renames an entry from one TLF to another by
+// copying it over, and then removing the original once the copy has
+// been synced.  If the copy fails, whatever part of it was made is
+// removed again.
+func (fs *KBFSOpsStandard) renameAcrossTlfs(ctx context.Context,
+	oldParent Node, oldName string, newParent Node, newName string,
+	progress func(CrossTlfRenameProgress)) (err error) {
+	fs.log.CDebugf(ctx, "Renaming %s across TLFs by copying it",
+		fs.config.RedactLogPath(oldName))
+	oldOps := fs.getOpsByNode(ctx, oldParent)
+	newOps := fs.getOpsByNode(ctx, newParent)
+
+	oldNode, oldEI, err := oldOps.Lookup(ctx, oldParent, oldName)
+	if err != nil {
+		return err
+	}
+	c := &crossTlfCopier{fs: fs, progress: progress}
+	err = c.tally(ctx, oldNode, oldEI)
+	if err != nil {
+		return err
+	}
+
+	err = fs.removeRenameTarget(ctx, oldEI.Type, newParent, newName)
+	if err != nil {
+		return err
+	}
+
+	created, err := c.copyEntry(
+		ctx, oldParent, oldName, newParent, newName, oldName)
+	if err == nil {
+		err = newOps.SyncAll(ctx, newParent.GetFolderBranch())
+	}
+	if err != nil {
+		if !created {
+			return err
+		}
+		rmErr := newOps.RemoveAll(ctx, newParent, newName)
+		if rmErr != nil {
+			fs.log.CDebugf(ctx, "Couldn't remove the partial copy of %s: "+
+				"%+v", fs.config.RedactLogPath(newName), rmErr)
+		}
+		return err
+	}
+	c.status.Path = ""
+	c.report()
+
+	return oldOps.RemoveAll(ctx, oldParent, oldName)
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"io"
+	"sync"
 
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
@@ -146,6 +147,15 @@ func powerOfTwoEqualOrGreater(n int) int {
 
 const padPrefixSize = 4
 
+// paddedBlockPool holds buffers for padded blocks that have already
+// been encrypted, so that writing many blocks doesn't allocate a
+// fresh block-sized buffer for each one.
+var paddedBlockPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
 // padBlock adds zero padding to an encoded block.
 func (c CryptoCommon) padBlock(block []byte) ([]byte, error) {
 	totalLen := powerOfTwoEqualOrGreater(len(block))
@@ -157,47 +167,168 @@ func (c CryptoCommon) padBlock(block []byte) ([]byte, error) {
 	return buf, nil
 }
 
-// depadBlock extracts the actual block data from a padded block.
-func (c CryptoCommon) depadBlock(paddedBlock []byte) ([]byte, error) {
+// padBlockPooled is like padBlock, but pads according to `padding`,
+// records that `block` was compressed with `compression`, and takes
+// its buffer from paddedBlockPool.  The caller must call the returned
+// function once it no longer needs the padded block.
+func (c CryptoCommon) padBlockPooled(block []byte, padding BlockPadding,
+	compression BlockCompression) (paddedBlock []byte, release func()) {
+	totalLen := padding.paddedLen(len(block))
+	bufLen := padPrefixSize + totalLen
+
+	bufp := paddedBlockPool.Get().(*[]byte)
+	buf := *bufp
+	if cap(buf) < bufLen {
+		buf = make([]byte, bufLen)
+	} else {
+		buf = buf[:bufLen]
+		// Reused buffers hold the data of an earlier block, so the
+		// padding has to be zeroed explicitly.
+		for i := padPrefixSize + len(block); i < bufLen; i++ {
+			buf[i] = 0
+		}
+	}
+	binary.LittleEndian.PutUint32(buf,
+		uint32(compression)<<blockCompressionShift|uint32(len(block)))
+	copy(buf[padPrefixSize:], block)
+
+	return buf, func() {
+		*bufp = buf
+		paddedBlockPool.Put(bufp)
+	}
+}
+
+// depadBlock extracts the actual block data from a padded block,
+// along with how that data is compressed.
+func (c CryptoCommon) depadBlock(paddedBlock []byte) (
+	[]byte, BlockCompression, error) {
 	totalLen := len(paddedBlock)
 	if totalLen < padPrefixSize {
-		return nil, errors.WithStack(io.ErrUnexpectedEOF)
+		return nil, 0, errors.WithStack(io.ErrUnexpectedEOF)
 	}
 
-	blockLen := binary.LittleEndian.Uint32(paddedBlock)
+	prefix := binary.LittleEndian.Uint32(paddedBlock)
+	compression := BlockCompression(prefix >> blockCompressionShift)
+	blockLen := prefix & blockCompressionLenMask
 	blockEndPos := int(blockLen + padPrefixSize)
 
 	if totalLen < blockEndPos {
-		return nil, errors.WithStack(
+		return nil, 0, errors.WithStack(
 			PaddedBlockReadError{
 				ActualLen:   totalLen,
 				ExpectedLen: blockEndPos,
 			})
 	}
-	return paddedBlock[padPrefixSize:blockEndPos], nil
+	return paddedBlock[padPrefixSize:blockEndPos], compression, nil
 }
 
 // EncryptBlock implements the Crypto interface for CryptoCommon.
 func (c CryptoCommon) EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 	plainSize int, encryptedBlock kbfscrypto.EncryptedBlock, err error) {
+	plainSize, _, encryptedBlock, err = c.encryptBlockWithPadding(
+		block, key, BlockPaddingPowerOfTwo, BlockCompressionNone)
+	return plainSize, encryptedBlock, err
+}
+
+// blockPaddingEncrypter is implemented by the Crypto implementations
+// that can pad, compress and key blocks in other ways than the
+// default.
+type blockPaddingEncrypter interface {
+	encryptBlockWithPadding(block Block, key kbfscrypto.BlockCryptKey,
+		padding BlockPadding, compression BlockCompression) (
+		plainSize int, compressed BlockCompression,
+		encryptedBlock kbfscrypto.EncryptedBlock, err error)
+	encryptBlockConvergent(block Block, tlfCryptKey kbfscrypto.TLFCryptKey,
+		padding BlockPadding, compression BlockCompression) (
+		plainSize int, compressed BlockCompression,
+		serverHalf kbfscrypto.BlockCryptKeyServerHalf,
+		encryptedBlock kbfscrypto.EncryptedBlock, err error)
+}
+
+var _ blockPaddingEncrypter = CryptoCommon{}
+
+// encodeAndPadBlock encodes `block`, compresses it with
+// `compression`, and pads it according to `padding`.  The returned
+// plainSize is the size of the block as stored, after compression,
+// and `compressed` is the compression that was actually used, which
+// is BlockCompressionNone if compressing didn't help.  The caller
+// must call the returned function once it no longer needs the padded
+// block.
+func (c CryptoCommon) encodeAndPadBlock(block Block, padding BlockPadding,
+	compression BlockCompression) (paddedBlock []byte, plainSize int,
+	compressed BlockCompression, release func(), err error) {
 	encodedBlock, err := c.codec.Encode(block)
 	if err != nil {
-		return -1, kbfscrypto.EncryptedBlock{}, err
+		return nil, -1, 0, nil, err
+	}
+	if len(encodedBlock) > blockCompressionLenMask {
+		return nil, -1, 0, nil, errors.Errorf(
+			"Encoded block of %d bytes is too big", len(encodedBlock))
+	}
+
+	encodedBlock, compressed, err = compressBlock(encodedBlock, compression)
+	if err != nil {
+		return nil, -1, 0, nil, err
 	}
 
-	paddedBlock, err := c.padBlock(encodedBlock)
+	paddedBlock, release = c.padBlockPooled(encodedBlock, padding, compressed)
+	return paddedBlock, len(encodedBlock), compressed, release, nil
+}
+
+// encryptBlockWithPadding is like EncryptBlock, but compresses the
+// encoded block with `compression`, and pads it according to
+// `padding`.  The returned plainSize is the size of the block as
+// stored, after compression, and `compressed` is the compression
+// that was actually used.
+func (c CryptoCommon) encryptBlockWithPadding(
+	block Block, key kbfscrypto.BlockCryptKey, padding BlockPadding,
+	compression BlockCompression) (
+	plainSize int, compressed BlockCompression,
+	encryptedBlock kbfscrypto.EncryptedBlock, err error) {
+	// The padded block is only needed until it's encrypted, since
+	// encryption copies it.
+	paddedBlock, plainSize, compressed, release, err :=
+		c.encodeAndPadBlock(block, padding, compression)
 	if err != nil {
-		return -1, kbfscrypto.EncryptedBlock{}, err
+		return -1, 0, kbfscrypto.EncryptedBlock{}, err
 	}
+	defer release()
 
 	encryptedBlock, err =
 		kbfscrypto.EncryptPaddedEncodedBlock(paddedBlock, key)
 	if err != nil {
-		return -1, kbfscrypto.EncryptedBlock{}, err
+		return -1, 0, kbfscrypto.EncryptedBlock{}, err
 	}
 
-	plainSize = len(encodedBlock)
-	return plainSize, encryptedBlock, nil
+	return plainSize, compressed, encryptedBlock, nil
+}
+
+// encryptBlockConvergent is like encryptBlockWithPadding, but derives
+// the block's key from `tlfCryptKey` and the block's contents,
+// instead of from a random server half, and encrypts it
+// deterministically.  So identical blocks in a TLF, at the same key
+// generation, are encrypted identically, and get the same ID.  Their
+// server half is returned along with the encrypted block.
+func (c CryptoCommon) encryptBlockConvergent(
+	block Block, tlfCryptKey kbfscrypto.TLFCryptKey, padding BlockPadding,
+	compression BlockCompression) (
+	plainSize int, compressed BlockCompression,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf,
+	encryptedBlock kbfscrypto.EncryptedBlock, err error) {
+	paddedBlock, plainSize, compressed, release, err :=
+		c.encodeAndPadBlock(block, padding, compression)
+	if err != nil {
+		return -1, 0, kbfscrypto.BlockCryptKeyServerHalf{},
+			kbfscrypto.EncryptedBlock{}, err
+	}
+	defer release()
+
+	serverHalf = kbfscrypto.MakeConvergentBlockCryptKeyServerHalf(
+		tlfCryptKey, paddedBlock)
+	key := kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
+	encryptedBlock =
+		kbfscrypto.EncryptPaddedEncodedBlockConvergent(paddedBlock, key)
+	return plainSize, compressed, serverHalf, encryptedBlock, nil
 }
 
 // DecryptBlock implements the Crypto interface for CryptoCommon.
@@ -209,11 +340,16 @@ func (c CryptoCommon) DecryptBlock(
 		return err
 	}
 
-	encodedBlock, err := c.depadBlock(paddedBlock)
+	encodedBlock, compression, err := c.depadBlock(paddedBlock)
 	if err != nil {
 		return err
 	}
 
+	encodedBlock, err = decompressBlock(encodedBlock, compression)
+	if err != nil {
+		return errors.WithStack(BlockDecodeError{err})
+	}
+
 	err = c.codec.Decode(encodedBlock, &block)
 	if err != nil {
 		return errors.WithStack(BlockDecodeError{err})
@@ -204,8 +204,9 @@ func TestEncryptBlock(t *testing.T) {
 	require.Equal(t, len(expectedEncodedBlock), plainSize)
 
 	paddedBlock := checkSecretboxOpenBlock(t, encryptedBlock, cryptKey)
-	encodedBlock, err := c.depadBlock(paddedBlock)
+	encodedBlock, compression, err := c.depadBlock(paddedBlock)
 	require.NoError(t, err)
+	require.Equal(t, BlockCompressionNone, compression)
 	require.Equal(t, expectedEncodedBlock, encodedBlock)
 }
 
@@ -263,7 +264,7 @@ func TestBlockDepadding(t *testing.T) {
 			t.Logf("padBlock err: %s", err)
 			return false
 		}
-		depadded, err := c.depadBlock(padded)
+		depadded, _, err := c.depadBlock(padded)
 		if err != nil {
 			t.Logf("depadBlock err: %s", err)
 			return false
@@ -278,6 +279,35 @@ func TestBlockDepadding(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// Tests that padding with a reused buffer gives the same result as
+// padding with a fresh one, even when the buffer was last used for a
+// larger block.
+func TestBlockPaddingPooled(t *testing.T) {
+	var c CryptoCommon
+	f := func(b []byte) bool {
+		big := make([]byte, 2*len(b)+minBlockSize)
+		for i := range big {
+			big[i] = 0xff
+		}
+		_, release := c.padBlockPooled(
+			big, BlockPaddingPowerOfTwo, BlockCompressionNone)
+		release()
+
+		expected, err := c.padBlock(b)
+		if err != nil {
+			t.Logf("padBlock err: %s", err)
+			return false
+		}
+		padded, release := c.padBlockPooled(
+			b, BlockPaddingPowerOfTwo, BlockCompressionNone)
+		defer release()
+		return bytes.Equal(expected, padded)
+	}
+
+	err := quick.Check(f, nil)
+	require.NoError(t, err)
+}
+
 // Test padding of blocks results in blocks at least 2^8.
 func TestBlockPadMinimum(t *testing.T) {
 	var c CryptoCommon
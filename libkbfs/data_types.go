@@ -6,7 +6,9 @@ package libkbfs
 
 import (
 	"fmt"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -142,6 +144,11 @@ const (
 // one indirect pointer with an indirect DirectType [although if it
 // holds for one, it should hold for all], and all of its indirect
 // pointers must have DataVer 3, by c).
+// e) The exception to all of the above is a block that's compressed
+// (see BlockCompression), which is always v4, whatever it points to
+// and whatever points to it.  Since the compression is hidden inside
+// the encrypted block, the version of the block itself has to say
+// so.
 type DataVer int
 
 const (
@@ -156,6 +163,9 @@ const (
 	// blocks that have multiple levels of indirection below them
 	// (i.e., indirect blocks that point to other indirect blocks).
 	AtLeastTwoLevelsOfChildrenDataVer DataVer = 3
+	// CompressedBlocksDataVer is the data version for blocks that
+	// were compressed before being padded and encrypted.
+	CompressedBlocksDataVer DataVer = 4
 )
 
 // BlockRef is a block ID/ref nonce pair, which defines a unique
@@ -283,6 +293,12 @@ type ReadyBlockData struct {
 	// These fields should not be used outside of putBlockToServer.
 	buf        []byte
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf
+	// convergent is true if the block was keyed by its contents,
+	// so that an identical block may already be on the server.
+	convergent bool
+	// compressed is true if the block was compressed, so that its
+	// pointer needs CompressedBlocksDataVer.
+	compressed bool
 }
 
 // GetEncodedSize returns the size of the encoded (and encrypted)
@@ -365,6 +381,77 @@ const (
 	MasterBranch BranchName = ""
 )
 
+const (
+	// branchRevPrefix starts the name of an archived branch that
+	// shows its folder as of a given revision.
+	branchRevPrefix = "rev="
+	// branchTimePrefix starts the name of an archived branch that
+	// shows its folder as of a given time.
+	branchTimePrefix = "time="
+	// branchDateFormat is the format of the time in an archived
+	// branch name that only specifies a date, meaning midnight UTC
+	// at the start of that day.  Otherwise the time must be in
+	// RFC3339 format.
+	branchDateFormat = "2006-01-02"
+)
+
+// MakeRevBranchName returns the name of a read-only branch that shows
+// its folder frozen as of the given revision.
+func MakeRevBranchName(rev kbfsmd.Revision) BranchName {
+	return BranchName(branchRevPrefix + strconv.FormatInt(int64(rev), 10))
+}
+
+// MakeTimeBranchName returns the name of a read-only branch that
+// shows its folder frozen as of the given time, i.e. at the latest
+// revision made at or before that time.
+func MakeTimeBranchName(t time.Time) BranchName {
+	return BranchName(branchTimePrefix + t.UTC().Format(time.RFC3339Nano))
+}
+
+// IsArchived returns true if this branch name refers to a read-only
+// view of its folder as of some revision or time in the past.  Such
+// branch names may still turn out to be invalid, see
+// RevisionIfSpecified and TimeIfSpecified.
+func (bn BranchName) IsArchived() bool {
+	s := string(bn)
+	return strings.HasPrefix(s, branchRevPrefix) ||
+		strings.HasPrefix(s, branchTimePrefix)
+}
+
+// RevisionIfSpecified returns the revision named by a branch name
+// made by MakeRevBranchName, and true, or false if bn doesn't name a
+// valid revision.
+func (bn BranchName) RevisionIfSpecified() (kbfsmd.Revision, bool) {
+	s := string(bn)
+	if !strings.HasPrefix(s, branchRevPrefix) {
+		return kbfsmd.RevisionUninitialized, false
+	}
+	i, err := strconv.ParseInt(strings.TrimPrefix(s, branchRevPrefix), 10, 64)
+	if err != nil || kbfsmd.Revision(i) < kbfsmd.RevisionInitial {
+		return kbfsmd.RevisionUninitialized, false
+	}
+	return kbfsmd.Revision(i), true
+}
+
+// TimeIfSpecified returns the time named by a branch name made by
+// MakeTimeBranchName, or of the form "time=2016-01-01", and true, or
+// false if bn doesn't name a valid time.
+func (bn BranchName) TimeIfSpecified() (time.Time, bool) {
+	s := string(bn)
+	if !strings.HasPrefix(s, branchTimePrefix) {
+		return time.Time{}, false
+	}
+	s = strings.TrimPrefix(s, branchTimePrefix)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t, err = time.Parse(branchDateFormat, s)
+		if err != nil {
+			return time.Time{}, false
+		}
+	}
+	return t, true
+}
+
 // FolderBranch represents a unique pair of top-level folder and a
 // branch of that folder.
 type FolderBranch struct {
@@ -546,6 +633,42 @@ type EntryInfo struct {
 	// If this is a team TLF, we want to track the last writer of an
 	// entry, since in the block, only the team ID will be tracked.
 	TeamWriter keybase1.UID `codec:"tw,omitempty"`
+	// Mode holds the permission bits last set by KBFSOps.SetMode,
+	// or 0 if they were never set.  Old clients don't know about
+	// it, so Type is still the authority on whether a file is
+	// executable; use Perm to get the effective bits.
+	Mode os.FileMode `codec:"mode,omitempty"`
+	// MimeType is the sniffed content type of a file, if sniffing is
+	// enabled for the frontend that asked for it.  It isn't stored.
+	MimeType string `codec:"-"`
+}
+
+// Perm returns the permission bits of the entry.  If none were ever
+// set, they're 0700 for directories, executables and symlinks, and
+// 0600 for regular files.  Otherwise, they're the stored bits,
+// except that the type may have been changed since by a client that
+// doesn't know about Mode: executables always get the user exec bit,
+// and regular files lose all their exec bits if the stored bits
+// still have the user one.  The caller is responsible for removing
+// any write bits if the user can't write to the TLF.
+func (ei EntryInfo) Perm() os.FileMode {
+	if ei.Mode == 0 {
+		if ei.Type == File {
+			return 0600
+		}
+		return 0700
+	}
+
+	perm := ei.Mode.Perm()
+	switch ei.Type {
+	case File:
+		if perm&0100 != 0 {
+			perm &^= 0111
+		}
+	case Exec:
+		perm |= 0100
+	}
+	return perm
 }
 
 // ReportedError represents an error reported by KBFS.
@@ -631,6 +754,99 @@ type NodeMetadata struct {
 	PrefetchStatus       string
 }
 
+// NodeRevision describes the entry for a node as of one revision of
+// its folder, as returned by KBFSOps.GetNodeHistory.
+type NodeRevision struct {
+	Revision kbfsmd.Revision
+	// Time is the modification time of the node as of Revision.
+	Time time.Time
+	// WriterUnverified is the last writer of the node as of
+	// Revision, according to the last writer of the TLF.  It is
+	// empty if the writer couldn't be determined.
+	WriterUnverified libkb.NormalizedUsername
+	Size             uint64
+}
+
+// UnmergedChanges summarizes the local changes on the unmerged
+// branch of a folder-branch, all of which would be discarded by
+// KBFSOps.Unstage.  A zero UnmergedChanges means the folder-branch
+// isn't staged.
+type UnmergedChanges struct {
+	BranchID kbfsmd.BranchID
+	// First and Last are the range of unmerged revisions on
+	// BranchID.
+	First kbfsmd.Revision
+	Last  kbfsmd.Revision
+	// Changes lists the operations on each node changed in the
+	// unmerged revisions, and the node's path where it can be
+	// found.
+	Changes []*crChainSummary
+}
+
+// NodeChangeType is a bitmask describing the kinds of change reported
+// by a NodeChange.
+type NodeChangeType int
+
+const (
+	// NodeChangeCreate means an entry was created in a directory.
+	NodeChangeCreate NodeChangeType = 1 << iota
+	// NodeChangeRemove means an entry was removed from a directory.
+	NodeChangeRemove
+	// NodeChangeRename means an entry was renamed into or out of a
+	// directory.
+	NodeChangeRename
+	// NodeChangeWrite means a file's contents were written.
+	NodeChangeWrite
+	// NodeChangeAttr means a node's attributes were changed.
+	NodeChangeAttr
+
+	// NodeChangeAll matches every kind of change.
+	NodeChangeAll = NodeChangeCreate | NodeChangeRemove |
+		NodeChangeRename | NodeChangeWrite | NodeChangeAttr
+)
+
+// ObserverFilter restricts the notifications an Observer receives
+// for a folder-branch.  Its zero value matches everything.
+type ObserverFilter struct {
+	// Types, if non-zero, limits notifications to these kinds of
+	// changes.  Changes whose kind isn't known (e.g., those caused
+	// by a fast-forward of the whole folder) are always delivered.
+	Types NodeChangeType
+	// PathPrefix, if non-empty, limits notifications to nodes whose
+	// path within the TLF (e.g., "a/b", without the TLF name) is
+	// PathPrefix or lies beneath it.
+	PathPrefix string
+	// Root, if non-nil, limits notifications to changes to Root,
+	// or, if Recursive is set, to Root and any node beneath it.
+	// Root must belong to the folder-branch being watched, and is
+	// followed across renames.
+	Root      Node
+	Recursive bool
+	// CoalesceWindow, if positive, holds back notifications for up
+	// to this long after the first one, and then delivers them all
+	// at once, with the changes to each node merged together.  The
+	// merged notifications are delivered with a new context, which
+	// only carries CtxNotificationSeqnosKey, and CtxBackgroundSyncKey
+	// if all the merged batches came from background syncs.
+	CoalesceWindow time.Duration
+}
+
+// restricts returns whether `f` limits which changes are delivered.
+func (f ObserverFilter) restricts() bool {
+	return f.Types != 0 || f.PathPrefix != "" || f.Root != nil
+}
+
+// NotificationSeqnos is the range of sequence numbers covered by a
+// BatchChanges notification.  Each batch of changes to a
+// folder-branch gets the next sequence number, and each
+// notification covers every batch since the previous notification
+// to the same observer, including batches that were filtered out
+// or merged together.  So an observer that sees First skip past
+// the previous notification's Last has missed a notification.
+type NotificationSeqnos struct {
+	First, Last uint64
+}
+
 // FavoritesOp defines an operation related to favorites.
 type FavoritesOp int
 
@@ -688,6 +904,61 @@ func (im InitModeType) String() string {
 	}
 }
 
+// NetworkMode restricts the direction in which KBFS may use the
+// network.
+type NetworkMode int
+
+const (
+	// NetworkModeNormal allows both uploads and downloads.
+	NetworkModeNormal NetworkMode = iota
+	// NetworkModeUploadOnly lets pending local changes (e.g., those
+	// in the journal) finish uploading, but doesn't fetch any new
+	// blocks or apply any new remote updates.
+	NetworkModeUploadOnly
+	// NetworkModeDownloadOnly lets KBFS fetch data from the servers,
+	// but holds all local changes in the journal (or fails them, if
+	// journaling is off) rather than uploading them.
+	NetworkModeDownloadOnly
+)
+
+func (m NetworkMode) String() string {
+	switch m {
+	case NetworkModeNormal:
+		return "Normal"
+	case NetworkModeUploadOnly:
+		return "UploadOnly"
+	case NetworkModeDownloadOnly:
+		return "DownloadOnly"
+	default:
+		return "Unknown"
+	}
+}
+
+// uploadsEnabled returns whether this mode allows sending data to
+// the servers.
+func (m NetworkMode) uploadsEnabled() bool {
+	return m != NetworkModeDownloadOnly
+}
+
+// downloadsEnabled returns whether this mode allows fetching data
+// from the servers.
+func (m NetworkMode) downloadsEnabled() bool {
+	return m != NetworkModeUploadOnly
+}
+
+// NetworkConstraints describes hints about how sparingly KBFS
+// should use the network, e.g. when on a mobile data plan or a
+// tethered hotspot.
+type NetworkConstraints struct {
+	// Metered indicates that the user pays for network usage, so
+	// non-essential traffic like prefetching and frequent update
+	// fetches should be avoided.
+	Metered bool
+	// MaxBytesPerSec, if positive, caps the rate at which journaled
+	// writes are uploaded in the background.
+	MaxBytesPerSec int64
+}
+
 // PrefetchStatus denotes the prefetch status of a block.
 type PrefetchStatus int
 
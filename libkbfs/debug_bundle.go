@@ -0,0 +1,336 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/metricsutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// debugBundleMaxLogBytes is the most that is included from the end
+// of each log file in a debug bundle.
+const debugBundleMaxLogBytes = 16 * 1024 * 1024
+
+// debugBundleFolderLister is implemented by KBFSOps implementations
+// that can enumerate their open folders for a debug bundle.
+type debugBundleFolderLister interface {
+	openFolderBranches() []FolderBranch
+	cachedPrivateNames() []string
+}
+
+// debugBundleCacheStats is the cache section of a debug bundle.
+type debugBundleCacheStats struct {
+	BlockCacheCleanBytesCapacity uint64
+	DiskCache                    map[string]DiskBlockCacheStatus `json:",omitempty"`
+}
+
+// debugBundleError is an error reported to the Reporter, in a form
+// that survives being encoded as JSON.
+type debugBundleError struct {
+	Time  time.Time
+	Error string
+}
+
+// debugBundleErrors is the error history section of a debug bundle.
+type debugBundleErrors struct {
+	Reported []debugBundleError
+	Folders  map[string][]FolderErrorStatus `json:",omitempty"`
+}
+
+// privatePathNames returns the components of p that name files or
+// directories inside a TLF.  p may either be a canonical path
+// (starting with /keybase/<type>/<tlf>) or a path starting with the
+// TLF name.
+func privatePathNames(p string) []string {
+	names := strings.Split(p, "/")
+	skip := 1
+	if strings.HasPrefix(p, "/") {
+		skip = 4
+	}
+	if len(names) <= skip {
+		return nil
+	}
+	return names[skip:]
+}
+
+// debugBundleWriter adds redacted entries to a debug bundle archive.
+type debugBundleWriter struct {
+	zw       *zip.Writer
+	redactor *strings.Replacer
+	modTime  time.Time
+}
+
+func (w *debugBundleWriter) addBytes(name string, data []byte) error {
+	f, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: w.modTime,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.WriteString(f, w.redactor.Replace(string(data)))
+	return errors.WithStack(err)
+}
+
+func (w *debugBundleWriter) addJSON(name string, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return w.addBytes(name, data)
+}
+
+// addError records in the bundle that a section couldn't be
+// collected, so that one failure doesn't cost the whole bundle.
+func (w *debugBundleWriter) addError(name string, err error) error {
+	return w.addBytes(name+".error.txt", []byte(fmt.Sprintf("%+v\n", err)))
+}
+
+// addLogFile adds the end of the log file at path, if it exists.
+func (w *debugBundleWriter) addLogFile(path string) error {
+	f, err := ioutil.OpenFile(path, os.O_RDONLY, 0)
+	if ioutil.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if fi.Size() > debugBundleMaxLogBytes {
+		_, err = f.Seek(fi.Size()-debugBundleMaxLogBytes, io.SeekStart)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return w.addBytes(filepath.Join("logs", filepath.Base(path)), data)
+}
+
+// debugBundleLogFiles returns the current log file at logPath, along
+// with any rotated-out versions of it.
+func debugBundleLogFiles(logPath string) ([]string, error) {
+	if logPath == "" {
+		return nil, nil
+	}
+	paths := []string{logPath}
+	dir, base := filepath.Split(logPath)
+	fileInfos, err := ioutil.ReadDir(filepath.Clean(dir))
+	if ioutil.IsNotExist(err) {
+		return paths, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, fi := range fileInfos {
+		if strings.HasPrefix(fi.Name(), base+"-") {
+			paths = append(paths, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// makeDebugBundleRedactor returns a Replacer that swaps each of the
+// given names for its hashed log token.  Names shorter than
+// minAuditNameLen are left alone, since they would garble too much
+// unrelated text.
+func (c *ConfigLocal) makeDebugBundleRedactor(names []string) (
+	*strings.Replacer, error) {
+	key, err := func() ([]byte, error) {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.logRedactKeyLocked()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make(map[string]bool, len(names))
+	for _, name := range names {
+		if len(name) >= minAuditNameLen {
+			unique[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(unique))
+	for name := range unique {
+		sorted = append(sorted, name)
+	}
+	// Replace longer names first, so a name that contains another
+	// one is hidden entirely.
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, name := range sorted {
+		oldnew = append(oldnew, name, hashLogPath(key, name))
+	}
+	return strings.NewReplacer(oldnew...), nil
+}
+
+// GenerateDebugBundle implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) GenerateDebugBundle(
+	ctx context.Context, w io.Writer) (err error) {
+	log := c.MakeLogger("")
+	log.CDebugf(ctx, "Generating debug bundle")
+	defer func() {
+		log.CDebugf(ctx, "Done generating debug bundle: %+v", err)
+	}()
+
+	kbfsOps := c.KBFSOps()
+	var fbs []FolderBranch
+	var names []string
+	if lister, ok := kbfsOps.(debugBundleFolderLister); ok {
+		fbs = lister.openFolderBranches()
+		names = lister.cachedPrivateNames()
+	}
+
+	// Collect the statuses before building the redactor, since
+	// they can mention paths that are no longer cached.
+	status, _, statusErr := kbfsOps.Status(ctx)
+	folderStatuses := make(map[FolderBranch]FolderBranchStatus, len(fbs))
+	folderErrs := make(map[FolderBranch]error)
+	for _, fb := range fbs {
+		fbStatus, _, err := kbfsOps.FolderStatus(ctx, fb)
+		if err != nil {
+			folderErrs[fb] = err
+			continue
+		}
+		folderStatuses[fb] = fbStatus
+		if fb.Tlf.Type() == tlf.Public {
+			continue
+		}
+		for _, p := range fbStatus.DirtyPaths {
+			names = append(names, privatePathNames(p)...)
+		}
+		if fbStatus.Journal != nil {
+			for _, p := range fbStatus.Journal.UnflushedPaths {
+				names = append(names, privatePathNames(p)...)
+			}
+		}
+	}
+
+	redactor, err := c.makeDebugBundleRedactor(names)
+	if err != nil {
+		return err
+	}
+	bw := &debugBundleWriter{
+		zw:       zip.NewWriter(w),
+		redactor: redactor,
+		modTime:  c.Clock().Now(),
+	}
+
+	err = bw.addBytes("version.txt", []byte(VersionString()+"\n"))
+	if err != nil {
+		return err
+	}
+
+	if statusErr != nil {
+		err = bw.addError("status", statusErr)
+	} else {
+		err = bw.addJSON("status.json", status)
+	}
+	if err != nil {
+		return err
+	}
+
+	errs := debugBundleErrors{
+		Folders: make(map[string][]FolderErrorStatus),
+	}
+	for _, fb := range fbs {
+		name := fmt.Sprintf("folders/%s-%s", fb.Tlf, fb.Branch)
+		if fbErr, ok := folderErrs[fb]; ok {
+			err = bw.addError(name, fbErr)
+		} else {
+			fbStatus := folderStatuses[fb]
+			if len(fbStatus.RecentErrors) > 0 {
+				errs.Folders[fb.Tlf.String()] = fbStatus.RecentErrors
+			}
+			err = bw.addJSON(name+".json", fbStatus)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range c.Reporter().AllKnownErrors() {
+		errs.Reported = append(errs.Reported, debugBundleError{
+			Time:  e.Time,
+			Error: e.Error.Error(),
+		})
+	}
+	err = bw.addJSON("errors.json", errs)
+	if err != nil {
+		return err
+	}
+
+	caches := debugBundleCacheStats{
+		BlockCacheCleanBytesCapacity: c.BlockCache().GetCleanBytesCapacity(),
+	}
+	if dbc := c.DiskBlockCache(); dbc != nil {
+		caches.DiskCache = dbc.Status(ctx)
+	}
+	err = bw.addJSON("caches.json", caches)
+	if err != nil {
+		return err
+	}
+
+	if registry := c.MetricsRegistry(); registry != nil {
+		var buf bytes.Buffer
+		metricsutil.WriteMetrics(registry, &buf)
+		err = bw.addBytes("metrics.txt", buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	var goroutines bytes.Buffer
+	err = pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = bw.addBytes("goroutines.txt", goroutines.Bytes())
+	if err != nil {
+		return err
+	}
+
+	logPaths, err := debugBundleLogFiles(c.logFilePath)
+	if err != nil {
+		err = bw.addError("logs", err)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range logPaths {
+		err = bw.addLogFile(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.WithStack(bw.zw.Close())
+}
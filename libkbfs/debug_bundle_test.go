@@ -0,0 +1,95 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivatePathNames(t *testing.T) {
+	require.Equal(t, []string{"a", "b"},
+		privatePathNames("/keybase/private/u1/a/b"))
+	require.Equal(t, []string{"a", "b"}, privatePathNames("u1/a/b"))
+	require.Nil(t, privatePathNames("/keybase/private/u1"))
+	require.Nil(t, privatePathNames("u1"))
+}
+
+func TestGenerateDebugBundle(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "debug_bundle")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	name := "quarterly-report.txt"
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, name, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	// Fake a current and a rotated-out log file that mention the
+	// new file.
+	config.logFilePath = filepath.Join(tempdir, "kbfs.log")
+	err = ioutil.WriteFile(
+		config.logFilePath, []byte("CreateFile "+name+"\n"), 0600)
+	require.NoError(t, err)
+	rotated := config.logFilePath + "-20180101T000000Z-20180102T000000Z"
+	err = ioutil.WriteFile(rotated, []byte("Lookup "+name+"\n"), 0600)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = config.GenerateDebugBundle(ctx, &buf)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+		contents[f.Name] = string(data)
+	}
+
+	fb := rootNode.GetFolderBranch()
+	for _, entry := range []string{
+		"version.txt", "status.json", "errors.json", "caches.json",
+		"goroutines.txt", "logs/kbfs.log", "logs/" + filepath.Base(rotated),
+		fmt.Sprintf("folders/%s-%s.json", fb.Tlf, fb.Branch),
+	} {
+		require.Contains(t, contents, entry)
+	}
+
+	token := config.RedactLogPath(name)
+	require.Equal(t, name, token)
+	err = config.SetLogPathsMode(LogPathsHashed)
+	require.NoError(t, err)
+	token = config.RedactLogPath(name)
+	require.Equal(t, "CreateFile "+token+"\n", contents["logs/kbfs.log"])
+	for entry, data := range contents {
+		require.False(t, strings.Contains(data, name),
+			"%s contains a private name", entry)
+	}
+}
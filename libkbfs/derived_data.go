@@ -0,0 +1,175 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io"
+	"mime"
+	gopath "path"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DerivedDataKind names a kind of data, like a thumbnail, that can be
+// derived from the contents of a file.
+type DerivedDataKind string
+
+const (
+	// DerivedDataThumbnail is a small image representing a file,
+	// suitable for icons in a file browser.
+	DerivedDataThumbnail DerivedDataKind = "thumbnail"
+	// DerivedDataPreview is a larger image representing a file,
+	// suitable for showing the file before opening it.
+	DerivedDataPreview DerivedDataKind = "preview"
+)
+
+// DerivedData is data derived from the contents of a file.
+type DerivedData struct {
+	// MimeType is the MIME type of Data.
+	MimeType string
+	Data     []byte
+}
+
+// DerivedDataGenerator derives data from the contents of files of
+// some MIME types.
+type DerivedDataGenerator interface {
+	// Supports returns true if the generator can derive data of the
+	// given kind from files of the given MIME type.
+	Supports(kind DerivedDataKind, mimeType string) bool
+	// Generate derives data of the given kind from `content`, which
+	// has the given MIME type.  It may stop reading `content` as
+	// soon as it has enough.
+	Generate(ctx context.Context, kind DerivedDataKind, mimeType string,
+		content io.Reader) (DerivedData, error)
+}
+
+// DefaultDerivedDataCacheCapacity is the default number of derived
+// data items kept by a DerivedDataCache.
+const DefaultDerivedDataCacheCapacity = 256
+
+// derivedDataKey identifies a file's contents.  A file's top block
+// ID is a hash of its synced contents; the size and mtime catch
+// changes that haven't been synced yet.
+type derivedDataKey struct {
+	kind  DerivedDataKind
+	id    kbfsblock.ID
+	size  uint64
+	mtime int64
+}
+
+// DerivedDataCache generates data like thumbnails and previews from
+// files on demand, using its registered generators, and caches the
+// results in memory, keyed by the contents of the files.
+type DerivedDataCache struct {
+	config Config
+	log    logger.Logger
+	cache  *lru.Cache
+
+	lock       sync.RWMutex
+	generators []DerivedDataGenerator
+}
+
+// NewDerivedDataCache constructs a new DerivedDataCache that keeps at
+// most `capacity` items.  It has no generators until some are
+// registered.
+func NewDerivedDataCache(config Config, capacity int) (
+	*DerivedDataCache, error) {
+	cache, err := lru.New(capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &DerivedDataCache{
+		config: config,
+		log:    config.MakeLogger("DDC"),
+		cache:  cache,
+	}, nil
+}
+
+// RegisterGenerator adds a generator to the cache.  Generators are
+// tried in the order they were registered.
+func (c *DerivedDataCache) RegisterGenerator(g DerivedDataGenerator) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generators = append(c.generators, g)
+}
+
+func (c *DerivedDataCache) getGenerator(
+	kind DerivedDataKind, mimeType string) DerivedDataGenerator {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, g := range c.generators {
+		if g.Supports(kind, mimeType) {
+			return g
+		}
+	}
+	return nil
+}
+
+// Get returns data of the given kind derived from `file`, generating
+// it if it isn't cached yet.  The file's MIME type is guessed from its
+// name.  If no generator supports it, Get returns a
+// NoDerivedDataGeneratorError.
+func (c *DerivedDataCache) Get(
+	ctx context.Context, file Node, kind DerivedDataKind) (
+	DerivedData, error) {
+	mimeType := mime.TypeByExtension(gopath.Ext(file.GetBasename()))
+	g := c.getGenerator(kind, mimeType)
+	if g == nil {
+		return DerivedData{}, NoDerivedDataGeneratorError{kind, mimeType}
+	}
+
+	kbfsOps := c.config.KBFSOps()
+	ei, err := kbfsOps.Stat(ctx, file)
+	if err != nil {
+		return DerivedData{}, err
+	}
+	if ei.Type != File && ei.Type != Exec {
+		return DerivedData{}, errors.Errorf(
+			"%s is not a file", file.GetBasename())
+	}
+	md, err := kbfsOps.GetNodeMetadata(ctx, file)
+	if err != nil {
+		return DerivedData{}, err
+	}
+	key := derivedDataKey{kind, md.BlockInfo.ID, ei.Size, ei.Mtime}
+	if data, ok := c.cache.Get(key); ok {
+		return data.(DerivedData), nil
+	}
+
+	c.log.CDebugf(ctx, "Generating a %s for %s (%s)",
+		kind, file.GetBasename(), mimeType)
+	data, err := g.Generate(ctx, kind, mimeType, &nodeReader{
+		ctx: ctx, kbfsOps: kbfsOps, file: file})
+	if err != nil {
+		return DerivedData{}, err
+	}
+	c.cache.Add(key, data)
+	return data, nil
+}
+
+// nodeReader reads a file sequentially through KBFSOps.
+type nodeReader struct {
+	ctx     context.Context
+	kbfsOps KBFSOps
+	file    Node
+	off     int64
+}
+
+func (r *nodeReader) Read(p []byte) (int, error) {
+	n, err := r.kbfsOps.Read(r.ctx, r.file, p, r.off)
+	if err != nil {
+		return int(n), err
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	r.off += n
+	return int(n), nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"image"
+	// Register the decoders for the supported image types.
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// ImageGenerator is a DerivedDataGenerator that makes PNG thumbnails
+// and previews of GIF, JPEG and PNG images, by scaling them down to
+// fit within a square.
+type ImageGenerator struct {
+	// ThumbnailSize is the largest dimension, in pixels, of a
+	// thumbnail.
+	ThumbnailSize int
+	// PreviewSize is the largest dimension, in pixels, of a
+	// preview.
+	PreviewSize int
+}
+
+// NewImageGenerator returns an ImageGenerator with the default
+// thumbnail and preview sizes.
+func NewImageGenerator() *ImageGenerator {
+	return &ImageGenerator{
+		ThumbnailSize: 256,
+		PreviewSize:   1024,
+	}
+}
+
+var _ DerivedDataGenerator = (*ImageGenerator)(nil)
+
+var imageGeneratorTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+func (g *ImageGenerator) maxSize(kind DerivedDataKind) int {
+	switch kind {
+	case DerivedDataThumbnail:
+		return g.ThumbnailSize
+	case DerivedDataPreview:
+		return g.PreviewSize
+	default:
+		return 0
+	}
+}
+
+// Supports implements the DerivedDataGenerator interface for
+// ImageGenerator.
+func (g *ImageGenerator) Supports(
+	kind DerivedDataKind, mimeType string) bool {
+	return imageGeneratorTypes[mimeType] && g.maxSize(kind) > 0
+}
+
+// Generate implements the DerivedDataGenerator interface for
+// ImageGenerator.
+func (g *ImageGenerator) Generate(ctx context.Context,
+	kind DerivedDataKind, mimeType string, content io.Reader) (
+	DerivedData, error) {
+	img, _, err := image.Decode(content)
+	if err != nil {
+		return DerivedData{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return DerivedData{}, err
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, scaleImage(img, g.maxSize(kind)))
+	if err != nil {
+		return DerivedData{}, err
+	}
+	return DerivedData{MimeType: "image/png", Data: buf.Bytes()}, nil
+}
+
+// scaleImage scales `img` down, keeping its aspect ratio, so that
+// neither of its dimensions is larger than `maxSize`, using
+// nearest-neighbor sampling.  Images that already fit are returned
+// as is.
+func scaleImage(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	sw, sh := maxSize, maxSize
+	if w > h {
+		sh = h * maxSize / w
+	} else {
+		sw = w * maxSize / h
+	}
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			scaled.Set(x, y, img.At(b.Min.X+x*w/sw, b.Min.Y+y*h/sh))
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type countingDerivedDataGenerator struct {
+	DerivedDataGenerator
+	generated int
+}
+
+func (g *countingDerivedDataGenerator) Generate(ctx context.Context,
+	kind DerivedDataKind, mimeType string, content io.Reader) (
+	DerivedData, error) {
+	g.generated++
+	return g.DerivedDataGenerator.Generate(ctx, kind, mimeType, content)
+}
+
+func makeTestPNG(t *testing.T, w, h int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestDerivedDataCache(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a.png", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, makeTestPNG(t, 600, 300, color.White), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	c, err := NewDerivedDataCache(config, DefaultDerivedDataCacheCapacity)
+	require.NoError(t, err)
+	g := &countingDerivedDataGenerator{DerivedDataGenerator: NewImageGenerator()}
+	c.RegisterGenerator(g)
+
+	checkSize := func(data DerivedData, w, h int) {
+		require.Equal(t, "image/png", data.MimeType)
+		img, err := png.Decode(bytes.NewReader(data.Data))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, w, h), img.Bounds())
+	}
+
+	t.Log("Thumbnails and previews are scaled to fit")
+	data, err := c.Get(ctx, fNode, DerivedDataThumbnail)
+	require.NoError(t, err)
+	checkSize(data, 256, 128)
+	data, err = c.Get(ctx, fNode, DerivedDataPreview)
+	require.NoError(t, err)
+	checkSize(data, 600, 300)
+	require.Equal(t, 2, g.generated)
+
+	t.Log("Derived data is cached until the contents change")
+	_, err = c.Get(ctx, fNode, DerivedDataThumbnail)
+	require.NoError(t, err)
+	require.Equal(t, 2, g.generated)
+	err = kbfsOps.Truncate(ctx, fNode, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, makeTestPNG(t, 100, 400, color.Black), 0)
+	require.NoError(t, err)
+	data, err = c.Get(ctx, fNode, DerivedDataThumbnail)
+	require.NoError(t, err)
+	checkSize(data, 64, 256)
+	require.Equal(t, 3, g.generated)
+
+	t.Log("Files without a generator are rejected")
+	tNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a.txt", false, NoExcl)
+	require.NoError(t, err)
+	_, err = c.Get(ctx, tNode, DerivedDataThumbnail)
+	require.IsType(t, NoDerivedDataGeneratorError{}, err)
+
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
@@ -0,0 +1,401 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"h12.me/socks"
+)
+
+const (
+	// proxyDirect is the proxy setting that disables proxying.
+	proxyDirect = "direct"
+	// serverConnKeepAlive is the TCP keep-alive period for server
+	// connections, matching the one used by rpc.ConnectionTransportTLS.
+	serverConnKeepAlive = 10 * time.Second
+)
+
+// proxyDialer is a Dialer that connects through an HTTP or SOCKS5
+// proxy, which can be chosen per server host.
+type proxyDialer struct {
+	timeout time.Duration
+	// defaultProxy is the proxy used for hosts not in hostProxies;
+	// nil means connect directly.
+	defaultProxy *url.URL
+	hostProxies  map[string]*url.URL
+	// anonymous is set in Tor mode, where every connection must go
+	// through defaultProxy.
+	anonymous bool
+}
+
+var _ Dialer = (*proxyDialer)(nil)
+
+func parseProxyURL(s string) (*url.URL, error) {
+	if s == "" || s == proxyDirect {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch u.Scheme {
+	case "http":
+	case "socks5":
+		if u.User != nil {
+			return nil, errors.Errorf(
+				"Authentication isn't supported for SOCKS5 proxy %s", u.Host)
+		}
+	default:
+		return nil, errors.Errorf("Unsupported proxy scheme %q in %q",
+			u.Scheme, s)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return u, nil
+}
+
+// ParseProxyOverrides parses per-host proxy settings of the form
+// "host1=proxy1,host2=proxy2", where each proxy is either a proxy
+// URL, as accepted by NewProxyDialer, or "direct".
+func ParseProxyOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	if s == "" {
+		return overrides, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("Invalid proxy override %q", entry)
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides, nil
+}
+
+// NewProxyDialer returns a Dialer that connects to servers through
+// `defaultProxy`, unless the server's host has an entry in
+// `hostProxies`.  Proxies are given as URLs of the form
+// "http://[user:password@]host:port" (for proxies supporting the
+// HTTP CONNECT method) or "socks5://host:port"; an empty proxy or
+// "direct" means connecting directly.  `timeout` limits how long it
+// takes to connect, including the proxy handshake.
+func NewProxyDialer(defaultProxy string, hostProxies map[string]string,
+	timeout time.Duration) (Dialer, error) {
+	d := &proxyDialer{
+		timeout:     timeout,
+		hostProxies: make(map[string]*url.URL, len(hostProxies)),
+	}
+	var err error
+	d.defaultProxy, err = parseProxyURL(defaultProxy)
+	if err != nil {
+		return nil, err
+	}
+	for host, proxy := range hostProxies {
+		d.hostProxies[host], err = parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// NewTorDialer returns a Dialer that sends every connection through
+// the Tor SOCKS5 proxy at `proxyAddr`, and never connects directly.
+// Server names are passed to the proxy unresolved, so that no DNS
+// lookups happen locally; for the same reason, `proxyAddr` must be
+// an IP address and port rather than a host name.
+func NewTorDialer(proxyAddr string, timeout time.Duration) (Dialer, error) {
+	host, _, err := net.SplitHostPort(proxyAddr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if net.ParseIP(host) == nil {
+		return nil, errors.Errorf(
+			"Tor proxy %s must be given as an IP address", proxyAddr)
+	}
+	return &proxyDialer{
+		timeout:      timeout,
+		defaultProxy: &url.URL{Scheme: "socks5", Host: proxyAddr},
+		anonymous:    true,
+	}, nil
+}
+
+// isAnonymousDialer returns whether `d` routes every connection
+// through Tor.
+func isAnonymousDialer(d Dialer) bool {
+	pd, ok := d.(*proxyDialer)
+	return ok && pd.anonymous
+}
+
+func (d *proxyDialer) proxyFor(addr string) (*url.URL, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if proxy, ok := d.hostProxies[host]; ok {
+		return proxy, nil
+	}
+	return d.defaultProxy, nil
+}
+
+// String implements the fmt.Stringer interface for proxyDialer.
+func (d *proxyDialer) String() string {
+	if d.anonymous {
+		return fmt.Sprintf("Tor proxy %s", d.defaultProxy.Host)
+	}
+	s := proxyDirect
+	if d.defaultProxy != nil {
+		s = d.defaultProxy.Scheme + "://" + d.defaultProxy.Host
+	}
+	return fmt.Sprintf("proxy %s (%d host overrides)", s, len(d.hostProxies))
+}
+
+// Dial implements the Dialer interface for proxyDialer.
+func (d *proxyDialer) Dial(
+	ctx context.Context, network, addr string) (net.Conn, error) {
+	proxy, err := d.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	if proxy == nil {
+		if d.anonymous {
+			return nil, errors.Errorf(
+				"Refusing to connect directly to %s in Tor mode", addr)
+		}
+		dialer := net.Dialer{KeepAlive: serverConnKeepAlive}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	switch proxy.Scheme {
+	case "socks5":
+		return d.dialSOCKS5(ctx, proxy, addr)
+	default:
+		return d.dialHTTPConnect(ctx, network, proxy, addr)
+	}
+}
+
+func (d *proxyDialer) dialSOCKS5(
+	ctx context.Context, proxy *url.URL, addr string) (net.Conn, error) {
+	// The socks package doesn't take a context, so abandon the
+	// dial (and close the connection if it ever completes) if ctx
+	// is done first.
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	c := make(chan result, 1)
+	go func() {
+		conn, err := socks.DialSocksProxy(socks.SOCKS5, proxy.Host)("tcp", addr)
+		if err != nil && conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		c <- result{conn, err}
+	}()
+	select {
+	case r := <-c:
+		if r.err != nil {
+			return nil, errors.Wrapf(r.err, "SOCKS5 proxy %s", proxy.Host)
+		}
+		return r.conn, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-c; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (d *proxyDialer) dialHTTPConnect(ctx context.Context, network string,
+	proxy *url.URL, addr string) (_ net.Conn, err error) {
+	dialer := net.Dialer{KeepAlive: serverConnKeepAlive}
+	conn, err := dialer.DialContext(ctx, network, proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString(
+			[]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// The server doesn't send anything until the TLS client hello,
+	// so nothing past the response can end up in this buffer.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("HTTP proxy %s refused to connect to %s: %s",
+			proxy.Host, addr, resp.Status)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn, nil
+}
+
+// dialerTransport is an rpc.ConnectionTransport that makes TLS
+// connections over a Dialer.  It otherwise behaves like
+// rpc.ConnectionTransportTLS.
+type dialerTransport struct {
+	dialer     Dialer
+	srvRemote  rpc.Remote
+	rootCerts  []byte
+	logFactory rpc.LogFactory
+	wef        rpc.WrapErrorFunc
+
+	// Protects everything below.
+	lock            sync.Mutex
+	conn            net.Conn
+	transport       rpc.Transporter
+	stagedTransport rpc.Transporter
+}
+
+var _ rpc.ConnectionTransport = (*dialerTransport)(nil)
+
+func newDialerTransport(dialer Dialer, srvRemote rpc.Remote,
+	rootCerts []byte, logFactory rpc.LogFactory,
+	wef rpc.WrapErrorFunc) *dialerTransport {
+	return &dialerTransport{
+		dialer:     dialer,
+		srvRemote:  srvRemote,
+		rootCerts:  rootCerts,
+		logFactory: logFactory,
+		wef:        wef,
+	}
+}
+
+// Dial implements the rpc.ConnectionTransport interface for
+// dialerTransport.
+func (dt *dialerTransport) Dial(ctx context.Context) (
+	rpc.Transporter, error) {
+	addr := dt.srvRemote.GetAddress()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	config := &tls.Config{ServerName: host}
+	if dt.rootCerts != nil {
+		certs := x509.NewCertPool()
+		if !certs.AppendCertsFromPEM(dt.rootCerts) {
+			return nil, errors.New("Unable to load root certificates")
+		}
+		config.RootCAs = certs
+	}
+
+	baseConn, err := dt.dialer.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(baseConn, config)
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "TLS handshake with %s", addr)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if err := rpc.DisableSigPipe(baseConn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+	if dt.conn != nil {
+		dt.conn.Close()
+	}
+	transport := rpc.NewTransport(conn, dt.logFactory, dt.wef)
+	dt.conn = conn
+	if dt.stagedTransport != nil {
+		dt.stagedTransport.Close()
+	}
+	dt.stagedTransport = transport
+	return transport, nil
+}
+
+// IsConnected implements the rpc.ConnectionTransport interface for
+// dialerTransport.
+func (dt *dialerTransport) IsConnected() bool {
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+	return dt.transport != nil && dt.transport.IsConnected()
+}
+
+// Finalize implements the rpc.ConnectionTransport interface for
+// dialerTransport.
+func (dt *dialerTransport) Finalize() {
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+	if dt.transport != nil {
+		dt.transport.Close()
+	}
+	dt.transport = dt.stagedTransport
+	dt.stagedTransport = nil
+	dt.srvRemote.Reset()
+}
+
+// Close implements the rpc.ConnectionTransport interface for
+// dialerTransport.
+func (dt *dialerTransport) Close() {
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+	if dt.conn != nil {
+		dt.conn.Close()
+	}
+	if dt.transport != nil {
+		dt.transport.Close()
+	}
+	if dt.stagedTransport != nil {
+		dt.stagedTransport.Close()
+	}
+}
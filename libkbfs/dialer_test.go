@@ -0,0 +1,234 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// startEchoServer starts a TCP server that echoes everything it
+// reads, and returns its address.
+func startEchoServer(t *testing.T) (addr string, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// startTestProxy starts a proxy that accepts connections and hands
+// each one to `handshake`, which returns the target address to
+// splice the connection to, or "" to close it.
+func startTestProxy(t *testing.T,
+	handshake func(conn net.Conn, br *bufio.Reader) string) (
+	addr string, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				target := handshake(conn, br)
+				if target == "" {
+					return
+				}
+				targetConn, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer targetConn.Close()
+				go func() { _, _ = io.Copy(targetConn, br) }()
+				_, _ = io.Copy(conn, targetConn)
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// socks5Handshake is a startTestProxy handshake for a SOCKS5 proxy
+// without authentication.
+func socks5Handshake(conn net.Conn, br *bufio.Reader) string {
+	// Method selection: no authentication.
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(br, greeting); err != nil {
+		return ""
+	}
+	_, _ = conn.Write([]byte{5, 0})
+	// Connect request with a domain name address.
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return ""
+	}
+	rest := make([]byte, int(header[4])+2)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return ""
+	}
+	host := string(rest[:header[4]])
+	port := int(rest[header[4]])<<8 | int(rest[header[4]+1])
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func checkEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	msg := []byte("hello through the proxy")
+	_, err := conn.Write(msg)
+	require.NoError(t, err)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, msg, buf)
+}
+
+func TestProxyDialerHTTPConnect(t *testing.T) {
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("u:p"))
+	proxyAddr, stopProxy := startTestProxy(t,
+		func(conn net.Conn, br *bufio.Reader) string {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return ""
+			}
+			if req.Method != "CONNECT" ||
+				req.Header.Get("Proxy-Authorization") != auth {
+				_, _ = io.WriteString(conn,
+					"HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+				return ""
+			}
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+			return req.Host
+		})
+	defer stopProxy()
+
+	ctx := context.Background()
+	d, err := NewProxyDialer("http://u:p@"+proxyAddr, nil, 5*time.Second)
+	require.NoError(t, err)
+	conn, err := d.Dial(ctx, "tcp", echoAddr)
+	require.NoError(t, err)
+	checkEcho(t, conn)
+
+	t.Log("Wrong credentials are refused")
+	d, err = NewProxyDialer("http://u:x@"+proxyAddr, nil, 5*time.Second)
+	require.NoError(t, err)
+	_, err = d.Dial(ctx, "tcp", echoAddr)
+	require.Error(t, err)
+}
+
+func TestProxyDialerSOCKS5(t *testing.T) {
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+
+	proxyAddr, stopProxy := startTestProxy(t, socks5Handshake)
+	defer stopProxy()
+
+	d, err := NewProxyDialer("socks5://"+proxyAddr, nil, 5*time.Second)
+	require.NoError(t, err)
+	conn, err := d.Dial(context.Background(), "tcp", echoAddr)
+	require.NoError(t, err)
+	checkEcho(t, conn)
+}
+
+func TestProxyDialerOverrides(t *testing.T) {
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+
+	// Nothing listens on the default proxy, so only direct
+	// connections can succeed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadProxy := l.Addr().String()
+	l.Close()
+
+	overrides, err := ParseProxyOverrides("127.0.0.1=direct,other=")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"127.0.0.1": "direct",
+		"other":     "",
+	}, overrides)
+
+	d, err := NewProxyDialer("http://"+deadProxy, overrides, 5*time.Second)
+	require.NoError(t, err)
+	conn, err := d.Dial(context.Background(), "tcp", echoAddr)
+	require.NoError(t, err)
+	checkEcho(t, conn)
+
+	d, err = NewProxyDialer("http://"+deadProxy, nil, 5*time.Second)
+	require.NoError(t, err)
+	_, err = d.Dial(context.Background(), "tcp", echoAddr)
+	require.Error(t, err)
+
+	t.Log("Bad settings are rejected")
+	_, err = ParseProxyOverrides("nohost")
+	require.Error(t, err)
+	_, err = NewProxyDialer("ftp://"+deadProxy, nil, 0)
+	require.Error(t, err)
+	_, err = NewProxyDialer("socks5://u:p@"+deadProxy, nil, 0)
+	require.Error(t, err)
+	_, err = NewProxyDialer("", map[string]string{"h": "http://nohostport"}, 0)
+	require.Error(t, err)
+}
+
+func TestTorDialer(t *testing.T) {
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+	proxyAddr, stopProxy := startTestProxy(t, socks5Handshake)
+	defer stopProxy()
+
+	d, err := NewTorDialer(proxyAddr, 5*time.Second)
+	require.NoError(t, err)
+	require.True(t, isAnonymousDialer(d))
+	conn, err := d.Dial(context.Background(), "tcp", echoAddr)
+	require.NoError(t, err)
+	checkEcho(t, conn)
+
+	t.Log("The status reports the anonymized connection")
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetDialer(d)
+	status, _, err := config.KBFSOps().Status(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Anonymized)
+	require.Equal(t, "Tor proxy "+proxyAddr, status.ConnectionProxy)
+
+	t.Log("Proxy host names would need a local DNS lookup")
+	_, err = NewTorDialer("localhost:9050", 0)
+	require.Error(t, err)
+	_, err = NewTorDialer("", 0)
+	require.Error(t, err)
+
+	t.Log("Other proxy dialers aren't anonymous")
+	d, err = NewProxyDialer("socks5://"+proxyAddr, nil, 0)
+	require.NoError(t, err)
+	require.False(t, isAnonymousDialer(d))
+}
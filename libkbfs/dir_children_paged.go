@@ -0,0 +1,137 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// DirChild is one entry of a page returned by
+// KBFSOps.GetDirChildrenPaged.
+type DirChild struct {
+	Name      string
+	EntryInfo EntryInfo
+}
+
+// GetDirtyDirChildrenPage returns, sorted by name, up to `maxEntries`
+// of the (possibly dirty) children entries of the given directory
+// that sort after `after`, along with the name to pass as `after` to
+// get the next page.  That name is empty once there are no more
+// entries.  If `maxEntries` is not positive, all the remaining
+// entries are returned.
+func (fbo *folderBlockOps) GetDirtyDirChildrenPage(
+	ctx context.Context, lState *lockState, kmd KeyMetadata, dir path,
+	after string, maxEntries int) (
+	children []DirChild, next string, err error) {
+	dblock, err := func() (*DirBlock, error) {
+		fbo.blockLock.RLock(lState)
+		defer fbo.blockLock.RUnlock(lState)
+		return fbo.getDirtyDirLocked(ctx, lState, kmd, dir, blockRead)
+	}()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only the names are sorted, so that a page doesn't need a copy
+	// of every entry in the directory.
+	names := make([]string, 0, len(dblock.Children))
+	for k := range dblock.Children {
+		if hiddenEntries[k] || k <= after {
+			continue
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	if maxEntries > 0 && len(names) > maxEntries {
+		names = names[:maxEntries]
+		next = names[maxEntries-1]
+	}
+	children = make([]DirChild, len(names))
+	for i, name := range names {
+		children[i] = DirChild{name, dblock.Children[name].EntryInfo}
+	}
+	return children, next, nil
+}
+
+func (fbo *folderBranchOps) getDirChildrenPaged(
+	ctx context.Context, dir Node, cursor string, maxEntries int) (
+	children []DirChild, nextCursor string, err error) {
+	lState := makeFBOLockState()
+
+	dirPath, err := fbo.pathFromNodeForRead(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fbo.nodeCache.IsUnlinked(dir) {
+		fbo.log.CDebugf(ctx, "Returning an empty children page for "+
+			"unlinked directory %v", dirPath.tailPointer())
+		return nil, "", nil
+	}
+
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return fbo.blocks.GetDirtyDirChildrenPage(
+		ctx, lState, md.ReadOnly(), dirPath, cursor, maxEntries)
+}
+
+// GetDirChildrenPaged implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetDirChildrenPaged(
+	ctx context.Context, dir Node, cursor string, maxEntries int) (
+	children []DirChild, nextCursor string, err error) {
+	fbo.log.CDebugf(ctx, "GetDirChildrenPaged %s max=%d",
+		getNodeIDStr(dir), maxEntries)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetDirChildrenPaged %s done, "+
+			"%d entries: %+v", getNodeIDStr(dir), len(children), err)
+		fbo.recordSignificantErr(err)
+	}()
+
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var retChildren []DirChild
+	var retNextCursor string
+	err = runUnlessCanceled(ctx, func() error {
+		retChildren, retNextCursor, err = fbo.getDirChildrenPaged(
+			ctx, dir, cursor, maxEntries)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Like GetDirChildren, let the node ask for a retry after
+	// syncing, but only when listing from the start.
+	if cursor == "" && dir.ShouldRetryOnDirRead(ctx) {
+		err2 := fbo.SyncFromServer(ctx, fbo.folderBranch, nil)
+		if err2 != nil {
+			fbo.log.CDebugf(ctx, "Error syncing before retry: %+v", err2)
+			return nil, "", nil
+		}
+
+		fbo.log.CDebugf(ctx,
+			"Retrying first page of GetDirChildrenPaged")
+		err = runUnlessCanceled(ctx, func() error {
+			retChildren, retNextCursor, err = fbo.getDirChildrenPaged(
+				ctx, dir, cursor, maxEntries)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return retChildren, retNextCursor, nil
+}
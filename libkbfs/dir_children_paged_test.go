@@ -0,0 +1,85 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDirChildrenPaged(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	const numEntries = 10
+	for i := numEntries - 1; i >= 0; i-- {
+		_, _, err := kbfsOps.CreateFile(
+			ctx, dNode, fmt.Sprintf("f%d", i), false, NoExcl)
+		require.NoError(t, err)
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	listAll := func(maxEntries int) (names []string, pages int) {
+		cursor := ""
+		for {
+			children, next, err := kbfsOps.GetDirChildrenPaged(
+				ctx, dNode, cursor, maxEntries)
+			require.NoError(t, err)
+			if maxEntries > 0 {
+				require.True(t, len(children) <= maxEntries)
+			}
+			for _, c := range children {
+				names = append(names, c.Name)
+			}
+			pages++
+			if next == "" {
+				return names, pages
+			}
+			cursor = next
+		}
+	}
+
+	var expected []string
+	for i := 0; i < numEntries; i++ {
+		expected = append(expected, fmt.Sprintf("f%d", i))
+	}
+
+	t.Log("Pages come back sorted, and cover every entry exactly once")
+	names, pages := listAll(3)
+	require.Equal(t, expected, names)
+	require.Equal(t, 4, pages)
+	names, pages = listAll(0)
+	require.Equal(t, expected, names)
+	require.Equal(t, 1, pages)
+
+	t.Log("Unsynced changes show up in later pages")
+	children, next, err := kbfsOps.GetDirChildrenPaged(ctx, dNode, "", 5)
+	require.NoError(t, err)
+	require.Len(t, children, 5)
+	require.Equal(t, "f4", next)
+	err = kbfsOps.RemoveEntry(ctx, dNode, "f6")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	children, next, err = kbfsOps.GetDirChildrenPaged(ctx, dNode, next, 0)
+	require.NoError(t, err)
+	require.Equal(t, "", next)
+	names = nil
+	for _, c := range children {
+		names = append(names, c.Name)
+		require.Equal(t, File, c.EntryInfo.Type)
+	}
+	require.Equal(t, []string{"f5", "f7", "f8", "f9", "g"}, names)
+}
@@ -11,6 +11,11 @@ type DirEntry struct {
 	BlockInfo
 	EntryInfo
 
+	// Xattrs holds the extended attributes of the entry, keyed by
+	// name.  It's never modified in place, since copies of the entry
+	// share it; it's replaced with a new map instead.
+	Xattrs map[string][]byte `codec:"xa,omitempty"`
+
 	codec.UnknownFieldSetHandler
 }
 
@@ -700,6 +700,39 @@ func (cache *DiskBlockCacheLocal) UpdateMetadata(ctx context.Context,
 	return cache.updateMetadataLocked(ctx, blockID.Bytes(), md)
 }
 
+// setPinned marks the given blocks as pinned or not, and returns the
+// ones that aren't in the cache.  Pinned blocks are never evicted,
+// though they can still be deleted.
+func (cache *DiskBlockCacheLocal) setPinned(ctx context.Context,
+	blockIDs []kbfsblock.ID, pinned bool) (
+	missing []kbfsblock.ID, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	err = cache.checkCacheLocked("setPinned")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blockID := range blockIDs {
+		md, err := cache.getMetadataLocked(blockID)
+		if err == leveldb.ErrNotFound {
+			missing = append(missing, blockID)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if md.Pinned == pinned {
+			continue
+		}
+		md.Pinned = pinned
+		err = cache.updateMetadataLocked(ctx, blockID.Bytes(), md)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
 // deleteLocked deletes a set of blocks from the disk block cache.
 func (cache *DiskBlockCacheLocal) deleteLocked(ctx context.Context,
 	blockEntries []kbfsblock.ID) (numRemoved int, sizeRemoved int64,
@@ -856,13 +889,16 @@ func (cache *DiskBlockCacheLocal) evictFromTLFLocked(ctx context.Context,
 			continue
 		}
 		blockID, err := kbfsblock.IDFromBytes(blockIDBytes)
-		lru, err := cache.getLRULocked(blockID)
+		metadata, err := cache.getMetadataLocked(blockID)
 		if err != nil {
 			cache.log.CWarningf(ctx, "Error decoding LRU time for block %s",
 				blockID)
 			continue
 		}
-		blockIDs = append(blockIDs, lruEntry{blockID, lru})
+		if metadata.Pinned {
+			continue
+		}
+		blockIDs = append(blockIDs, lruEntry{blockID, metadata.LRUTime})
 	}
 
 	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
@@ -911,12 +947,54 @@ func (cache *DiskBlockCacheLocal) evictLocked(ctx context.Context,
 				blockID)
 			continue
 		}
+		if metadata.Pinned {
+			continue
+		}
 		blockIDs = append(blockIDs, lruEntry{blockID, metadata.LRUTime})
 	}
 
 	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
 }
 
+// evictBytes evicts blocks from the cache, roughly in LRU order, until
+// at least `bytes` bytes have been freed or the cache is empty.  It
+// returns the number of bytes actually freed.
+func (cache *DiskBlockCacheLocal) evictBytes(ctx context.Context,
+	bytes int64) (sizeRemoved int64, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	err = cache.checkCacheLocked("evictBytes")
+	if err != nil {
+		return 0, err
+	}
+
+	for sizeRemoved < bytes && cache.numBlocks > 0 {
+		select {
+		case <-ctx.Done():
+			return sizeRemoved, errors.WithStack(ctx.Err())
+		default:
+		}
+		numRemoved, size, err := cache.evictLocked(
+			ctx, defaultNumBlocksToEvict)
+		if err != nil {
+			return sizeRemoved, err
+		}
+		if numRemoved == 0 {
+			break
+		}
+		sizeRemoved += size
+	}
+	return sizeRemoved, nil
+}
+
+// tlfSize returns how many bytes of the given TLF's blocks are in
+// the cache.
+func (cache *DiskBlockCacheLocal) tlfSize(tlfID tlf.ID) uint64 {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	return cache.tlfSizes[tlfID]
+}
+
 // Status implements the DiskBlockCache interface for DiskBlockCacheStandard.
 func (cache *DiskBlockCacheLocal) Status(
 	ctx context.Context) map[string]DiskBlockCacheStatus {
@@ -30,6 +30,9 @@ type DiskBlockCacheMetadata struct {
 	TriggeredPrefetch bool `codec:"HasPrefetched"`
 	// whether the block's triggered prefetches are complete
 	FinishedPrefetch bool
+	// whether the block belongs to a pinned file, and so must not be
+	// evicted
+	Pinned bool
 }
 
 // lruEntry is an entry for sorting LRU times
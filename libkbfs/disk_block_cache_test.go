@@ -89,11 +89,16 @@ func newDiskBlockCacheForTest(config *testDiskBlockCacheConfig,
 	if err != nil {
 		return nil, err
 	}
+	progress, err := newPrefetchProgressDbForTest(config)
+	if err != nil {
+		return nil, err
+	}
 	return &diskBlockCacheWrapped{
-		config:          config,
-		storageRoot:     "",
-		workingSetCache: workingSetCache,
-		syncCache:       syncCache,
+		config:           config,
+		storageRoot:      "",
+		workingSetCache:  workingSetCache,
+		syncCache:        syncCache,
+		prefetchProgress: progress,
 	}, nil
 }
 
@@ -397,6 +402,60 @@ func TestDiskBlockCacheEvictOverall(t *testing.T) {
 		"Average overall LRU delta from an eviction: %.2f", averageDifference)
 }
 
+func TestDiskBlockCacheEvictPinned(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that disk cache eviction skips pinned blocks.")
+	cache, config := initDiskBlockCacheTest(t)
+	standardCache := cache.workingSetCache
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(1, tlf.Private)
+	numBlocks := 20
+	var pinned []kbfsblock.ID
+	t.Log("Put 20 blocks into the cache, and pin the oldest 5.")
+	for i := 0; i < numBlocks; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := standardCache.Put(
+			ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		if i < 5 {
+			pinned = append(pinned, blockPtr.ID)
+		}
+		clock.Add(time.Second)
+	}
+	missingID := kbfsblock.FakeID(1)
+	missing, err := cache.setPinned(
+		ctx, append(pinned, missingID), true)
+	require.NoError(t, err)
+	require.Equal(t, []kbfsblock.ID{missingID}, missing)
+
+	t.Log("Evict until only the pinned blocks are left.")
+	for i := 0; i < 100 && standardCache.numBlocks > len(pinned); i++ {
+		_, _, err := standardCache.evictLocked(ctx, 10)
+		require.NoError(t, err)
+		_, _, err = standardCache.evictFromTLFLocked(ctx, tlf1, 10)
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(pinned), standardCache.numBlocks)
+	for _, id := range pinned {
+		md, err := standardCache.GetMetadata(ctx, id)
+		require.NoError(t, err)
+		require.True(t, md.Pinned)
+	}
+
+	t.Log("Unpinned blocks can be evicted again.")
+	_, err = cache.setPinned(ctx, pinned, false)
+	require.NoError(t, err)
+	for i := 0; i < 100 && standardCache.numBlocks > 0; i++ {
+		_, _, err := standardCache.evictLocked(ctx, 10)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 0, standardCache.numBlocks)
+}
+
 func TestDiskBlockCacheStaticLimit(t *testing.T) {
 	t.Parallel()
 	t.Log("Test that disk cache eviction works when we hit the static limit.")
@@ -40,6 +40,8 @@ type diskBlockCacheWrapped struct {
 	mtx             sync.RWMutex
 	workingSetCache *DiskBlockCacheLocal
 	syncCache       *DiskBlockCacheLocal
+	// prefetchProgress is non-nil whenever syncCache is.
+	prefetchProgress *prefetchProgressDb
 }
 
 var _ DiskBlockCache = (*diskBlockCacheWrapped)(nil)
@@ -62,15 +64,38 @@ func (cache *diskBlockCacheWrapped) enableCache(
 		// idempotent.
 		return nil
 	}
+	var progress *prefetchProgressDb
 	if cache.config.IsTestMode() {
+		if typ == syncCacheLimitTrackerType {
+			progress, err = newPrefetchProgressDbForTest(cache.config)
+			if err != nil {
+				return err
+			}
+		}
 		*cachePtr, err = newDiskBlockCacheStandardForTest(
 			cache.config, typ)
 	} else {
 		cacheStorageRoot := filepath.Join(cache.storageRoot, cacheFolder)
+		if typ == syncCacheLimitTrackerType {
+			progress, err = newPrefetchProgressDb(
+				cache.config, cacheStorageRoot)
+			if err != nil {
+				return err
+			}
+		}
 		*cachePtr, err = newDiskBlockCacheStandard(cache.config, typ,
 			cacheStorageRoot)
 	}
-	return err
+	if err != nil {
+		if progress != nil {
+			progress.Close()
+		}
+		return err
+	}
+	if progress != nil {
+		cache.prefetchProgress = progress
+	}
+	return nil
 }
 
 func newDiskBlockCacheWrapped(config diskBlockCacheConfig,
@@ -112,6 +137,25 @@ func (cache *diskBlockCacheWrapped) IsSyncCacheEnabled() bool {
 	return cache.syncCache != nil
 }
 
+// syncedBytes returns how many bytes of the given TLF's blocks are
+// stored in the sync cache, or 0 if the sync cache isn't enabled.
+func (cache *diskBlockCacheWrapped) syncedBytes(tlfID tlf.ID) uint64 {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	if cache.syncCache == nil {
+		return 0
+	}
+	return cache.syncCache.tlfSize(tlfID)
+}
+
+// getPrefetchProgress returns the database of in-progress deep-sync
+// prefetches, or nil if the sync cache isn't enabled.
+func (cache *diskBlockCacheWrapped) getPrefetchProgress() *prefetchProgressDb {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	return cache.prefetchProgress
+}
+
 // Get implements the DiskBlockCache interface for diskBlockCacheWrapped.
 func (cache *diskBlockCacheWrapped) Get(ctx context.Context, tlfID tlf.ID,
 	blockID kbfsblock.ID) (
@@ -210,6 +254,19 @@ func (cache *diskBlockCacheWrapped) UpdateMetadata(ctx context.Context,
 	return cache.workingSetCache.UpdateMetadata(ctx, blockID, prefetchStatus)
 }
 
+// setPinned marks the given blocks as pinned or not in whichever
+// cache holds them, and returns the ones that neither cache holds.
+func (cache *diskBlockCacheWrapped) setPinned(ctx context.Context,
+	blockIDs []kbfsblock.ID, pinned bool) ([]kbfsblock.ID, error) {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	missing, err := cache.workingSetCache.setPinned(ctx, blockIDs, pinned)
+	if err != nil || cache.syncCache == nil {
+		return missing, err
+	}
+	return cache.syncCache.setPinned(ctx, missing, pinned)
+}
+
 // Status implements the DiskBlockCache interface for diskBlockCacheWrapped.
 func (cache *diskBlockCacheWrapped) Status(
 	ctx context.Context) map[string]DiskBlockCacheStatus {
@@ -232,6 +289,20 @@ func (cache *diskBlockCacheWrapped) Status(
 	return statuses
 }
 
+// evictWorkingSetBytes evicts blocks from the working set cache until
+// at least `bytes` bytes have been freed or the cache is empty.  The
+// sync cache is left alone, since the user explicitly asked for
+// those blocks to be available offline.
+func (cache *diskBlockCacheWrapped) evictWorkingSetBytes(
+	ctx context.Context, bytes int64) (int64, error) {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	if cache.workingSetCache == nil {
+		return 0, nil
+	}
+	return cache.workingSetCache.evictBytes(ctx, bytes)
+}
+
 // Shutdown implements the DiskBlockCache interface for diskBlockCacheWrapped.
 func (cache *diskBlockCacheWrapped) Shutdown(ctx context.Context) {
 	cache.mtx.Lock()
@@ -240,4 +311,8 @@ func (cache *diskBlockCacheWrapped) Shutdown(ctx context.Context) {
 	if cache.syncCache != nil {
 		cache.syncCache.Shutdown(ctx)
 	}
+	if cache.prefetchProgress != nil {
+		cache.prefetchProgress.Close()
+		cache.prefetchProgress = nil
+	}
 }
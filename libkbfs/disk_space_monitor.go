@@ -0,0 +1,171 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// diskSpaceCheckInterval is how often the free space on the
+	// disk holding the caches and journals is checked.
+	diskSpaceCheckInterval = 1 * time.Minute
+	// diskSpaceLowBytes is the amount of free space below which the
+	// user is warned that the disk is filling up.
+	diskSpaceLowBytes int64 = 2 * 1024 * 1024 * 1024
+	// diskSpaceCriticalBytes is the amount of free space below
+	// which the working set disk cache is shrunk, to try to get
+	// back above diskSpaceLowBytes.
+	diskSpaceCriticalBytes int64 = 1 * 1024 * 1024 * 1024
+)
+
+// CtxDiskSpaceTagKey is the type used for unique context tags within
+// diskSpaceMonitor.
+type CtxDiskSpaceTagKey int
+
+const (
+	// CtxDiskSpaceIDKey is the type of the tag for unique operation
+	// IDs within diskSpaceMonitor.
+	CtxDiskSpaceIDKey CtxDiskSpaceTagKey = iota
+)
+
+// CtxDiskSpaceOpID is the display name for the unique operation
+// diskSpaceMonitor ID tag.
+const CtxDiskSpaceOpID = "DSMID"
+
+// diskSpaceMonitorConfig specifies the interfaces that a
+// diskSpaceMonitor needs to perform its functions.
+type diskSpaceMonitorConfig interface {
+	logMaker
+	diskBlockCacheGetter
+	Reporter() Reporter
+}
+
+// diskSpaceEvicter is implemented by disk caches that can give back
+// space on demand.
+type diskSpaceEvicter interface {
+	evictWorkingSetBytes(ctx context.Context, bytes int64) (int64, error)
+}
+
+// diskSpaceMonitor periodically checks the free space on the disk
+// where the KBFS caches and journals live.  When it drops below
+// diskSpaceLowBytes, the user is warned via the Reporter; when it
+// drops below diskSpaceCriticalBytes, the working set disk cache is
+// proactively shrunk, well before the OS itself runs out of space.
+type diskSpaceMonitor struct {
+	config      diskSpaceMonitorConfig
+	log         logger.Logger
+	path        string
+	freeBytesFn func(path string) (int64, int64, error)
+
+	shutdownCh   chan struct{}
+	doneCh       chan struct{}
+	shutdownOnce sync.Once
+
+	// Only accessed by the monitor goroutine, or by tests while
+	// the monitor isn't running.
+	warned bool
+}
+
+func newDiskSpaceMonitor(config diskSpaceMonitorConfig, path string) *diskSpaceMonitor {
+	return &diskSpaceMonitor{
+		config:      config,
+		log:         config.MakeLogger("DSM"),
+		path:        path,
+		freeBytesFn: defaultGetFreeBytesAndFiles,
+		shutdownCh:  make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+}
+
+// start launches the background monitoring goroutine.
+func (dsm *diskSpaceMonitor) start() {
+	go dsm.loop()
+}
+
+func (dsm *diskSpaceMonitor) loop() {
+	defer close(dsm.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(), CtxDiskSpaceIDKey,
+			CtxDiskSpaceOpID, dsm.log))
+	defer cancel()
+	go func() {
+		select {
+		case <-dsm.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(diskSpaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		dsm.check(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check looks at the current free disk space once, and warns and/or
+// evicts cached blocks as needed.
+func (dsm *diskSpaceMonitor) check(ctx context.Context) {
+	freeBytes, _, err := dsm.freeBytesFn(dsm.path)
+	if err != nil {
+		dsm.log.CDebugf(ctx, "Couldn't get free disk space for %s: %+v",
+			dsm.path, err)
+		return
+	}
+
+	if freeBytes >= diskSpaceLowBytes {
+		if dsm.warned {
+			dsm.log.CDebugf(ctx, "Free disk space is back to %d bytes",
+				freeBytes)
+		}
+		dsm.warned = false
+		return
+	}
+
+	if !dsm.warned {
+		dsm.log.CWarningf(ctx, "Free disk space is low: %d bytes", freeBytes)
+		dsm.config.Reporter().ReportErr(ctx, "", tlf.Private, WriteMode,
+			DiskSpaceLowWarning{
+				AvailableBytes: freeBytes,
+				ThresholdBytes: diskSpaceLowBytes,
+			})
+		dsm.warned = true
+	}
+
+	if freeBytes >= diskSpaceCriticalBytes {
+		return
+	}
+
+	evicter, ok := dsm.config.DiskBlockCache().(diskSpaceEvicter)
+	if !ok {
+		return
+	}
+	toEvict := diskSpaceLowBytes - freeBytes
+	evicted, err := evicter.evictWorkingSetBytes(ctx, toEvict)
+	if err != nil {
+		dsm.log.CDebugf(ctx, "Couldn't evict from the disk cache: %+v", err)
+	}
+	dsm.log.CDebugf(ctx, "Evicted %d of %d bytes from the disk cache to "+
+		"free up disk space", evicted, toEvict)
+}
+
+// shutdown stops the monitor and waits for it to finish.
+func (dsm *diskSpaceMonitor) shutdown() {
+	dsm.shutdownOnce.Do(func() {
+		close(dsm.shutdownCh)
+	})
+	<-dsm.doneCh
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testDiskSpaceMonitorConfig struct {
+	logMaker
+	*testDiskBlockCacheGetter
+	reporter *ReporterSimple
+}
+
+func (c testDiskSpaceMonitorConfig) Reporter() Reporter {
+	return c.reporter
+}
+
+func TestDiskSpaceMonitorWarnAndEvict(t *testing.T) {
+	t.Parallel()
+	cache, dbcConfig := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	ctx := context.Background()
+
+	t.Log("Seed the working set and sync caches with some blocks.")
+	tlfID := tlf.FakeID(1, tlf.Private)
+	syncedTlfID := tlf.FakeID(2, tlf.Private)
+	err := dbcConfig.SetTlfSyncState(syncedTlfID, true)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, dbcConfig)
+		err = cache.workingSetCache.Put(
+			ctx, tlfID, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		blockPtr, _, blockEncoded, serverHalf = setupBlockForDiskCache(
+			t, dbcConfig)
+		err = cache.syncCache.Put(
+			ctx, syncedTlfID, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 20, cache.workingSetCache.numBlocks)
+	require.Equal(t, 20, cache.syncCache.numBlocks)
+
+	config := testDiskSpaceMonitorConfig{
+		newTestLogMaker(t),
+		newTestDiskBlockCacheGetter(t, cache),
+		NewReporterSimple(dbcConfig.Clock(), 10),
+	}
+	dsm := newDiskSpaceMonitor(config, "")
+	var freeBytes int64
+	dsm.freeBytesFn = func(string) (int64, int64, error) {
+		return freeBytes, 0, nil
+	}
+
+	t.Log("Plenty of space: no warning, no eviction.")
+	freeBytes = diskSpaceLowBytes + 1
+	dsm.check(ctx)
+	require.Len(t, config.reporter.AllKnownErrors(), 0)
+	require.Equal(t, 20, cache.workingSetCache.numBlocks)
+
+	t.Log("Low space: a single warning, but no eviction.")
+	freeBytes = diskSpaceLowBytes - 1
+	dsm.check(ctx)
+	dsm.check(ctx)
+	errs := config.reporter.AllKnownErrors()
+	require.Len(t, errs, 1)
+	require.Equal(t, DiskSpaceLowWarning{
+		AvailableBytes: freeBytes,
+		ThresholdBytes: diskSpaceLowBytes,
+	}, errors.Cause(errs[0].Error))
+	require.Equal(t, 20, cache.workingSetCache.numBlocks)
+
+	t.Log("Critical space: the working set cache is emptied, but the " +
+		"sync cache is left alone.")
+	freeBytes = diskSpaceCriticalBytes - 1
+	dsm.check(ctx)
+	require.Equal(t, 0, cache.workingSetCache.numBlocks)
+	require.Equal(t, 20, cache.syncCache.numBlocks)
+	require.Len(t, config.reporter.AllKnownErrors(), 1)
+
+	t.Log("Once space recovers, the next drop warns again.")
+	freeBytes = diskSpaceLowBytes
+	dsm.check(ctx)
+	freeBytes = diskSpaceLowBytes - 1
+	dsm.check(ctx)
+	require.Len(t, config.reporter.AllKnownErrors(), 2)
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// downloadChunkSize is how much file data DownloadMany reads at a
+// time.
+const downloadChunkSize = 512 * 1024
+
+// defaultDownloadConcurrency is how many files DownloadMany fetches
+// at once if no concurrency is given.
+const defaultDownloadConcurrency = 8
+
+// DownloadProgress describes how far along a DownloadMany call is.
+type DownloadProgress struct {
+	// Path is the file whose data was just written, or the empty
+	// string before any data has been fetched.
+	Path       string
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// DownloadWriterFactory returns the writer that the contents of the
+// file at path `p` should be written to.  DownloadMany closes it once
+// the file has been written, or fetching it has failed.
+type DownloadWriterFactory func(p string, ei EntryInfo) (io.WriteCloser, error)
+
+type downloadState struct {
+	lock     sync.Mutex
+	status   DownloadProgress
+	progress func(DownloadProgress)
+}
+
+func (ds *downloadState) report(p string, bytes int64, fileDone bool) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.status.Path = p
+	ds.status.BytesDone += bytes
+	if fileDone {
+		ds.status.FilesDone++
+	}
+	if ds.progress != nil {
+		ds.progress(ds.status)
+	}
+}
+
+type downloadFile struct {
+	path string
+	node Node
+	ei   EntryInfo
+}
+
+func downloadOne(ctx context.Context, kbfsOps KBFSOps, f downloadFile,
+	destWriterFactory DownloadWriterFactory, ds *downloadState) (
+	err error) {
+	w, err := destWriterFactory(f.path, f.ei)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := w.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	buf := make([]byte, downloadChunkSize)
+	for off := int64(0); off < int64(f.ei.Size); {
+		n, err := kbfsOps.Read(ctx, f.node, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// The file got shorter while we were reading it.
+			break
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return errors.WithStack(err)
+		}
+		off += n
+		ds.report(f.path, n, false)
+	}
+	ds.report(f.path, 0, true)
+	return nil
+}
+
+// DownloadMany fetches the files at `paths`, relative to the root of
+// `folderBranch`, writing each one to the writer that
+// `destWriterFactory` returns for it.  Up to `concurrency` files are
+// fetched at once (or defaultDownloadConcurrency, if it's not
+// positive), which is much faster than reading them one after the
+// other, since the block fetches of the different files overlap.
+// If `progress` is non-nil, it's called, from one goroutine at a
+// time, every time more data has been written.  The first failure
+// cancels the rest of the downloads, and is returned.
+func DownloadMany(ctx context.Context, kbfsOps KBFSOps,
+	folderBranch FolderBranch, paths []string,
+	destWriterFactory DownloadWriterFactory, concurrency int,
+	progress func(DownloadProgress)) error {
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
+
+	// Look up all the files first, so that the totals are known
+	// before any progress is reported.
+	ds := &downloadState{progress: progress}
+	files := make([]downloadFile, 0, len(paths))
+	for _, p := range paths {
+		node, ei, err := kbfsOps.GetNodeByPath(ctx, folderBranch, p)
+		if err != nil {
+			return err
+		}
+		if ei.Type != File && ei.Type != Exec {
+			return errors.Errorf("%s is not a file", p)
+		}
+		files = append(files, downloadFile{p, node, ei})
+		ds.status.BytesTotal += int64(ei.Size)
+	}
+	ds.status.FilesTotal = len(files)
+
+	eg, groupCtx := errgroup.WithContext(ctx)
+	fileCh := make(chan downloadFile, len(files))
+	for _, f := range files {
+		fileCh <- f
+	}
+	close(fileCh)
+	for i := 0; i < concurrency && i < len(files); i++ {
+		eg.Go(func() error {
+			for f := range fileCh {
+				err := downloadOne(
+					groupCtx, kbfsOps, f, destWriterFactory, ds)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+type testDownloadBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *testDownloadBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestDownloadMany(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "photos")
+	require.NoError(t, err)
+
+	const numFiles = 5
+	var paths []string
+	var totalBytes int64
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("%d.jpg", i)
+		fileNode, _, err := kbfsOps.CreateFile(
+			ctx, dirNode, name, false, NoExcl)
+		require.NoError(t, err)
+		data := bytes.Repeat([]byte{byte(i)}, 100*(i+1))
+		err = kbfsOps.Write(ctx, fileNode, data, 0)
+		require.NoError(t, err)
+		paths = append(paths, "photos/"+name)
+		totalBytes += int64(len(data))
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	bufs := make(map[string]*testDownloadBuffer)
+	factory := func(p string, _ EntryInfo) (io.WriteCloser, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		bufs[p] = &testDownloadBuffer{}
+		return bufs[p], nil
+	}
+	var last DownloadProgress
+	err = DownloadMany(ctx, kbfsOps, rootNode.GetFolderBranch(), paths,
+		factory, 2, func(p DownloadProgress) { last = p })
+	require.NoError(t, err)
+
+	require.Len(t, bufs, numFiles)
+	for i, p := range paths {
+		require.True(t, bufs[p].closed)
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 100*(i+1)),
+			bufs[p].Bytes())
+	}
+	require.Equal(t, numFiles, last.FilesDone)
+	require.Equal(t, numFiles, last.FilesTotal)
+	require.Equal(t, totalBytes, last.BytesDone)
+	require.Equal(t, totalBytes, last.BytesTotal)
+
+	t.Log("A missing file fails the whole download")
+	err = DownloadMany(ctx, kbfsOps, rootNode.GetFolderBranch(),
+		[]string{"photos/nope.jpg"}, factory, 2, nil)
+	require.Error(t, err)
+}
@@ -159,6 +159,16 @@ func (e RenameAcrossDirsError) Error() string {
 	return fmt.Sprintf("Cannot rename across directories")
 }
 
+// CopyAcrossTlfsError indicates that the user tried to copy a file
+// into a different top-level folder than the one it's in.
+type CopyAcrossTlfsError struct {
+}
+
+// Error implements the error interface for CopyAcrossTlfsError
+func (e CopyAcrossTlfsError) Error() string {
+	return "Cannot copy a file to a different top-level folder"
+}
+
 // ErrorFileAccessError indicates that the user tried to perform an
 // operation on the ErrorFile that is not allowed.
 type ErrorFileAccessError struct {
@@ -222,6 +232,17 @@ func (e WriteToReadonlyNodeError) Error() string {
 	return fmt.Sprintf("%s is read-only and writes are not allowed", e.Filename)
 }
 
+// ReadOnlyError indicates an error when trying to change a folder
+// that was opened read-only.
+type ReadOnlyError struct {
+	Filename string
+}
+
+// Error implements the error interface for ReadOnlyError.
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s is in a folder opened read-only", e.Filename)
+}
+
 // UnsupportedOpInUnlinkedDirError indicates an error when trying to
 // create a file.
 type UnsupportedOpInUnlinkedDirError struct {
@@ -497,6 +518,53 @@ func (e NewDataVersionError) Error() string {
 		e.path, e.DataVer, e.path.Tlf)
 }
 
+// NewMDFormatVersionError indicates that the given folder has been
+// marked as needing a newer MD format than our client understands,
+// in order to be read at all.
+type NewMDFormatVersionError struct {
+	Tlf          tlf.ID
+	MinReaderVer MDFormatVer
+}
+
+// Error implements the error interface for NewMDFormatVersionError.
+func (e NewMDFormatVersionError) Error() string {
+	return fmt.Sprintf(
+		"Folder %s needs a newer version of Keybase to be read "+
+			"(format %d; this version understands up to %d)",
+		e.Tlf, e.MinReaderVer, CurrentMDFormatVer)
+}
+
+// MDFormatReadOnlyError indicates that the given folder can still be
+// read by our client, but has been marked as needing a newer MD
+// format than our client understands in order to be changed.
+type MDFormatReadOnlyError struct {
+	Tlf          tlf.ID
+	MinWriterVer MDFormatVer
+}
+
+// Error implements the error interface for MDFormatReadOnlyError.
+func (e MDFormatReadOnlyError) Error() string {
+	return fmt.Sprintf(
+		"Folder %s is read-only until you upgrade Keybase "+
+			"(format %d; this version understands up to %d)",
+		e.Tlf, e.MinWriterVer, CurrentMDFormatVer)
+}
+
+// MigrationInProgressError indicates that a format migration of
+// the given folder is being run by another device, or that this
+// device lost ownership of one it was running.
+type MigrationInProgressError struct {
+	Tlf   tlf.ID
+	Name  string
+	Owner kbfscrypto.VerifyingKey
+}
+
+// Error implements the error interface for MigrationInProgressError.
+func (e MigrationInProgressError) Error() string {
+	return fmt.Sprintf("Migration %q of folder %s is being run by "+
+		"device %s", e.Name, e.Tlf, e.Owner)
+}
+
 // OutdatedVersionError indicates that we have encountered some new
 // data version we don't understand, and the user should be prompted
 // to upgrade.
@@ -509,6 +577,18 @@ func (e OutdatedVersionError) Error() string {
 		"Please use `keybase update check` to upgrade your software."
 }
 
+// StatusSchemaVersionError indicates that an encoded status was
+// written with a newer schema version than this client understands.
+type StatusSchemaVersionError struct {
+	SchemaVersion int
+}
+
+// Error implements the error interface for StatusSchemaVersionError.
+func (e StatusSchemaVersionError) Error() string {
+	return fmt.Sprintf("Status schema version %d is newer than the "+
+		"supported version %d", e.SchemaVersion, StatusSchemaVersion)
+}
+
 // InvalidVersionError indicates that we have encountered some new data version
 // we don't understand, and we don't know how to handle it.
 type InvalidVersionError struct {
@@ -740,6 +820,23 @@ func (e NotPermittedWhileDirtyError) Error() string {
 	return "Not permitted while writes are dirty"
 }
 
+// UnmergedChangesChangedError indicates that KBFSOps.Unstage was
+// called with a summary of unmerged changes that no longer matches
+// the folder-branch, so unstaging it could discard changes the
+// caller hasn't seen.
+type UnmergedChangesChangedError struct {
+	Expected UnmergedChanges
+	Actual   UnmergedChanges
+}
+
+// Error implements the error interface for UnmergedChangesChangedError.
+func (e UnmergedChangesChangedError) Error() string {
+	return fmt.Sprintf("Unmerged changes have changed: expected branch %s "+
+		"revisions %d-%d, found branch %s revisions %d-%d",
+		e.Expected.BranchID, e.Expected.First, e.Expected.Last,
+		e.Actual.BranchID, e.Actual.First, e.Actual.Last)
+}
+
 // NoChainFoundError indicates that a conflict resolution chain
 // corresponding to the given pointer could not be found.
 type NoChainFoundError struct {
@@ -862,11 +959,19 @@ func (e RekeyIncompleteError) Error() string {
 }
 
 // TimeoutError is just a replacement for context.DeadlineExceeded
-// with a more friendly error string.
+// with a more friendly error string.  When a KBFSOps call returns it
+// because the call ran longer than the OpTimeoutPolicy allows, Class
+// is the class of the call, and Timeout is how long it was allowed.
 type TimeoutError struct {
+	Class   OpTimeoutClass
+	Timeout time.Duration
 }
 
 func (e TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("Operation timed out after %s (%s timeout)",
+			e.Timeout, e.Class)
+	}
 	return "Operation timed out"
 }
 
@@ -917,6 +1022,18 @@ func (e UnexpectedUnmergedPutError) Error() string {
 	return "Unmerged puts are not allowed"
 }
 
+// NetworkModeError indicates that a server operation was skipped
+// because the current NetworkMode doesn't allow it.
+type NetworkModeError struct {
+	Mode NetworkMode
+	Op   string
+}
+
+// Error implements the error interface for NetworkModeError.
+func (e NetworkModeError) Error() string {
+	return fmt.Sprintf("%s is not allowed in network mode %s", e.Op, e.Mode)
+}
+
 // NoSuchTlfHandleError indicates we were unable to resolve a folder
 // ID to a folder handle.
 type NoSuchTlfHandleError struct {
@@ -995,6 +1112,64 @@ func (w OverQuotaWarning) Error() string {
 		"to %d bytes.  Please delete some data.", w.UsageBytes, w.LimitBytes)
 }
 
+// QuotaUsageWarning indicates that the user or team quota usage has
+// crossed one of the configured warning thresholds, given as a
+// fraction of the limit.
+type QuotaUsageWarning struct {
+	UsageBytes int64
+	LimitBytes int64
+	Threshold  float64
+}
+
+// Error implements the error interface for QuotaUsageWarning.
+func (w QuotaUsageWarning) Error() string {
+	return fmt.Sprintf("You are using %d bytes, which is over %.0f%% of "+
+		"your %d-byte limit.", w.UsageBytes, w.Threshold*100, w.LimitBytes)
+}
+
+// DiskSpaceLowWarning indicates that the disk holding the KBFS
+// caches and journals is running out of free space.
+type DiskSpaceLowWarning struct {
+	AvailableBytes int64
+	ThresholdBytes int64
+}
+
+// Error implements the error interface for DiskSpaceLowWarning.
+func (w DiskSpaceLowWarning) Error() string {
+	return fmt.Sprintf("Only %d bytes are left on the disk holding the "+
+		"Keybase caches, which is below the %d-byte safety threshold.  "+
+		"Please free up some disk space.", w.AvailableBytes, w.ThresholdBytes)
+}
+
+// OpNotPermittedError indicates that a request came from a frontend
+// that isn't allowed to make that class of KBFSOps call.
+type OpNotPermittedError struct {
+	Frontend RequestFrontend
+	Class    OpTimeoutClass
+}
+
+// Error implements the error interface for OpNotPermittedError.
+func (e OpNotPermittedError) Error() string {
+	frontend := e.Frontend
+	if frontend == "" {
+		frontend = "unknown"
+	}
+	return fmt.Sprintf("Requests from the %s frontend may not make %s calls",
+		frontend, e.Class)
+}
+
+// LocalDataWipeError indicates that some local data was still found
+// on disk after Config.WipeLocalData tried to remove it.
+type LocalDataWipeError struct {
+	Path string
+}
+
+// Error implements the error interface for LocalDataWipeError.
+func (e LocalDataWipeError) Error() string {
+	return fmt.Sprintf("Local data at %s still exists after being wiped",
+		e.Path)
+}
+
 // OpsCantHandleFavorite means that folderBranchOps wasn't able to
 // deal with a favorites request.
 type OpsCantHandleFavorite struct {
@@ -1092,6 +1267,17 @@ func (e NoMergedMDError) Error() string {
 	return fmt.Sprintf("No MD yet for TLF %s", e.tlf)
 }
 
+// InvalidBranchNameError indicates that the name of an archived
+// branch doesn't specify a valid revision or time.
+type InvalidBranchNameError struct {
+	Branch BranchName
+}
+
+// Error implements the error interface for InvalidBranchNameError.
+func (e InvalidBranchNameError) Error() string {
+	return fmt.Sprintf("Invalid archived branch name %q", string(e.Branch))
+}
+
 // InvalidFavoritesOpError indicates an unknown FavoritesOp has been provided.
 type InvalidFavoritesOpError struct{}
 
@@ -1133,6 +1319,20 @@ func (e NoUpdatesWhileDirtyError) Error() string {
 	return "Ignoring MD updates while writes are dirty"
 }
 
+// RemoteChangeWhileDirtyError indicates that another device changed
+// a TLF while it had unsynced local changes, and that local writes
+// will wait until the two have been merged.
+type RemoteChangeWhileDirtyError struct {
+	Rev kbfsmd.Revision
+}
+
+// Error implements the error interface for RemoteChangeWhileDirtyError.
+func (e RemoteChangeWhileDirtyError) Error() string {
+	return fmt.Sprintf("Revision %d was written by another device while "+
+		"there were unsynced local changes; new writes will wait until "+
+		"the changes are merged", e.Rev)
+}
+
 // Disk Cache Errors
 const (
 	// StatusCodeDiskBlockCacheError is a generic disk cache error.
@@ -1191,3 +1391,149 @@ func (e MDWrittenAfterRevokeError) Error() string {
 		"last valid revision would have been %d",
 		e.revBad, e.tlfID, e.verifyingKey, e.revLimit)
 }
+
+// TooManySymlinksError indicates that resolving a path would have
+// meant following more than Config.MaxSymlinkLevels symlinks.
+type TooManySymlinksError struct {
+	Path   string
+	Levels int
+}
+
+// Error implements the Error interface for TooManySymlinksError.
+func (e TooManySymlinksError) Error() string {
+	return fmt.Sprintf("Too many levels of symlinks (more than %d) in %s",
+		e.Levels, e.Path)
+}
+
+// UnfollowableSymlinkError indicates that a path runs through a
+// symlink that is absolute, or that points outside of its TLF.
+type UnfollowableSymlinkError struct {
+	Path   string
+	Target string
+}
+
+// Error implements the Error interface for UnfollowableSymlinkError.
+func (e UnfollowableSymlinkError) Error() string {
+	return fmt.Sprintf("Can't follow symlink %s to %s outside of the folder",
+		e.Path, e.Target)
+}
+
+// FileProviderAnchorExpiredError indicates that a FileProvider can no
+// longer say what changed since the given anchor, either because it
+// is from before a restart, or because too many changes have
+// happened since.  The caller should enumerate its items again.
+type FileProviderAnchorExpiredError struct {
+	Anchor FileProviderAnchor
+}
+
+// Error implements the Error interface for
+// FileProviderAnchorExpiredError.
+func (e FileProviderAnchorExpiredError) Error() string {
+	return fmt.Sprintf("Sync anchor %d has expired", e.Anchor)
+}
+
+// NoDerivedDataGeneratorError indicates that no registered generator
+// can derive the given kind of data from files of the given MIME
+// type.
+type NoDerivedDataGeneratorError struct {
+	Kind     DerivedDataKind
+	MimeType string
+}
+
+// Error implements the Error interface for
+// NoDerivedDataGeneratorError.
+func (e NoDerivedDataGeneratorError) Error() string {
+	return fmt.Sprintf("Can't generate a %s for a file of type %q",
+		e.Kind, e.MimeType)
+}
+
+// UploadChunkHashMismatchError indicates that a chunk written to an
+// upload session didn't match the hash given for it.
+type UploadChunkHashMismatchError struct {
+	Name string
+	Off  int64
+}
+
+// Error implements the error interface for UploadChunkHashMismatchError.
+func (e UploadChunkHashMismatchError) Error() string {
+	return fmt.Sprintf("Hash mismatch for the chunk of %s at offset %d",
+		e.Name, e.Off)
+}
+
+// UploadHashMismatchError indicates that the file assembled by an
+// upload session didn't match the hash expected for it.
+type UploadHashMismatchError struct {
+	Name string
+}
+
+// Error implements the error interface for UploadHashMismatchError.
+func (e UploadHashMismatchError) Error() string {
+	return fmt.Sprintf("Hash mismatch for the uploaded file %s", e.Name)
+}
+
+// UploadSessionDoneError indicates that an upload session was used
+// after it had been committed or aborted.
+type UploadSessionDoneError struct {
+	Name string
+}
+
+// Error implements the error interface for UploadSessionDoneError.
+func (e UploadSessionDoneError) Error() string {
+	return fmt.Sprintf("The upload session for %s is already done", e.Name)
+}
+
+// NoSuchXattrError indicates that the requested extended attribute
+// isn't set on an entry.
+type NoSuchXattrError struct {
+	Name string
+}
+
+// Error implements the error interface for NoSuchXattrError.
+func (e NoSuchXattrError) Error() string {
+	return fmt.Sprintf("Extended attribute %s doesn't exist", e.Name)
+}
+
+// XattrTooBigError indicates that an extended attribute, or all the
+// extended attributes of an entry together, would exceed the allowed
+// size.
+type XattrTooBigError struct {
+	Name    string
+	Size    int
+	MaxSize int
+}
+
+// Error implements the error interface for XattrTooBigError.
+func (e XattrTooBigError) Error() string {
+	return fmt.Sprintf("Extended attribute %s makes the attributes of its "+
+		"entry %d bytes, bigger than the maximum of %d bytes",
+		e.Name, e.Size, e.MaxSize)
+}
+
+// AdvisoryLockConflictError indicates that an advisory lock couldn't
+// be taken without waiting, because a conflicting lock is held.
+type AdvisoryLockConflictError struct {
+	// Conflict is the conflicting lock, or nil if it's held by
+	// another device.
+	Conflict *AdvisoryLock
+}
+
+// Error implements the error interface for AdvisoryLockConflictError.
+func (e AdvisoryLockConflictError) Error() string {
+	if e.Conflict == nil {
+		return "The file is locked by another device"
+	}
+	return fmt.Sprintf("The file has a conflicting %s lock on [%d, %d) "+
+		"held by %d", e.Conflict.Type, e.Conflict.Off, e.Conflict.End,
+		e.Conflict.Owner)
+}
+
+// NotTempFileError indicates that a node passed to LinkTempFile
+// wasn't made by CreateTempFile, or has already been linked.
+type NotTempFileError struct {
+	Node NodeID
+}
+
+// Error implements the error interface for NotTempFileError.
+func (e NotTempFileError) Error() string {
+	return fmt.Sprintf("Node %v isn't an unlinked temp file", e.Node)
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	stdpath "path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// excludePattern is one parsed line of an ExcludePatterns list.
+type excludePattern struct {
+	// segments is the slash-separated glob, where a "**" segment
+	// matches any number of path components.
+	segments []string
+	// anchored is whether the pattern is matched against the whole
+	// path from the root of the TLF, rather than against the last
+	// component of the path.
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// ExcludePatterns is a list of patterns of paths within a TLF, with
+// the same syntax and meaning as a .gitignore file:
+//
+//   - Blank lines and lines starting with "#" are skipped.
+//   - A pattern without a slash, other than a trailing one, matches
+//     an entry of that name anywhere in the TLF.  Otherwise, it
+//     matches a path from the root of the TLF.
+//   - A trailing slash only matches directories.
+//   - A leading "!" re-includes what an earlier pattern excluded,
+//     unless a directory above it is excluded.
+//   - Globs are as accepted by path.Match, and a "**" component
+//     matches any number of directories.
+//
+// The last pattern that matches a path decides whether it's
+// excluded, and everything under an excluded directory is excluded.
+// A nil *ExcludePatterns excludes nothing.
+type ExcludePatterns struct {
+	lines    []string
+	patterns []excludePattern
+}
+
+// ParseExcludePatterns parses the given lines of patterns.
+func ParseExcludePatterns(lines []string) (*ExcludePatterns, error) {
+	e := &ExcludePatterns{lines: lines}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p excludePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// An escaped leading "#" or "!".
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		line = strings.TrimLeft(line, "/")
+		if line == "" {
+			return nil, errors.Errorf("Empty exclude pattern in %q", lines)
+		}
+		p.segments = strings.Split(line, "/")
+		for _, s := range p.segments {
+			if _, err := stdpath.Match(s, ""); err != nil {
+				return nil, errors.Wrapf(err, "Bad exclude pattern %q", line)
+			}
+		}
+		e.patterns = append(e.patterns, p)
+	}
+	return e, nil
+}
+
+// Lines returns the lines the patterns were parsed from.
+func (e *ExcludePatterns) Lines() []string {
+	if e == nil {
+		return nil
+	}
+	return e.lines
+}
+
+// matchSegments returns whether the path components in path match
+// the glob components in pattern.
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := stdpath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+func (p excludePattern) match(components []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		return matchSegments(p.segments, components[len(components)-1:])
+	}
+	return matchSegments(p.segments, components)
+}
+
+// match returns whether the patterns exclude the entry at the
+// slash-separated path p, relative to the root of the TLF, not
+// counting the directories above it.
+func (e *ExcludePatterns) match(p string, isDir bool) bool {
+	if e == nil || len(e.patterns) == 0 {
+		return false
+	}
+	components := strings.Split(strings.Trim(p, "/"), "/")
+	excluded := false
+	for _, pattern := range e.patterns {
+		if pattern.match(components, isDir) {
+			excluded = !pattern.negate
+		}
+	}
+	return excluded
+}
+
+// Excluded returns whether the entry at the slash-separated path p,
+// relative to the root of the TLF, is excluded, either by itself or
+// because a directory above it is.  isDir says whether the entry is
+// a directory.
+func (e *ExcludePatterns) Excluded(p string, isDir bool) bool {
+	if e == nil || len(e.patterns) == 0 {
+		return false
+	}
+	p = strings.Trim(p, "/")
+	for i, c := range p {
+		if c == '/' && e.match(p[:i], true) {
+			return true
+		}
+	}
+	return e.match(p, isDir)
+}
+
+// ExcludedEverywhere returns whether an entry named name is excluded
+// no matter which directory it's in, for callers that don't know the
+// full path of the entry.  Only unanchored patterns can exclude an
+// entry everywhere, and an anchored pattern that might re-include it
+// somewhere keeps it from being excluded.
+func (e *ExcludePatterns) ExcludedEverywhere(name string, isDir bool) bool {
+	if e == nil {
+		return false
+	}
+	components := []string{name}
+	excluded := false
+	for _, p := range e.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		last := p.segments[len(p.segments)-1:]
+		switch {
+		case !p.anchored:
+			if matchSegments(p.segments, components) {
+				excluded = !p.negate
+			}
+		case p.negate && (last[0] == "**" || matchSegments(last, components)):
+			excluded = false
+		}
+	}
+	return excluded
+}
+
+// filterChildren returns the children of the directory at the
+// slash-separated path dir, relative to the root of the TLF, that
+// aren't excluded.  The directories above dir are assumed not to be
+// excluded, as when the tree is walked from the top.
+func (e *ExcludePatterns) filterChildren(
+	dir string, children map[string]EntryInfo) map[string]EntryInfo {
+	if e == nil || len(e.patterns) == 0 {
+		return children
+	}
+	filtered := make(map[string]EntryInfo, len(children))
+	for name, ei := range children {
+		if !e.match(stdpath.Join(dir, name), ei.Type == Dir) {
+			filtered[name] = ei
+		}
+	}
+	return filtered
+}
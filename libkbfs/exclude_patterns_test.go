@@ -0,0 +1,59 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	e, err := ParseExcludePatterns([]string{
+		"# build output",
+		"node_modules/",
+		"",
+		"*.o",
+		"!keep.o",
+		"/dist",
+		"docs/**/*.tmp",
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		p        string
+		isDir    bool
+		excluded bool
+	}{
+		{"node_modules", true, true},
+		{"a/b/node_modules", true, true},
+		{"a/node_modules/pkg/index.js", false, true},
+		{"node_modules", false, false},
+		{"a/main.o", false, true},
+		{"a/keep.o", false, false},
+		{"dist", true, true},
+		{"dist/x", false, true},
+		{"a/dist", true, false},
+		{"docs/x.tmp", false, true},
+		{"docs/a/b/x.tmp", false, true},
+		{"src/x.tmp", false, false},
+		{"src/main.go", false, false},
+	} {
+		require.Equal(t, tc.excluded, e.Excluded(tc.p, tc.isDir), tc.p)
+	}
+
+	require.True(t, e.ExcludedEverywhere("node_modules", true))
+	require.True(t, e.ExcludedEverywhere("main.o", false))
+	require.False(t, e.ExcludedEverywhere("keep.o", false))
+	// Only excluded at the root.
+	require.False(t, e.ExcludedEverywhere("dist", true))
+
+	var nilPatterns *ExcludePatterns
+	require.False(t, nilPatterns.Excluded("a", false))
+	require.Nil(t, nilPatterns.Lines())
+
+	_, err = ParseExcludePatterns([]string{"a/[b"})
+	require.Error(t, err)
+}
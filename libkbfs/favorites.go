@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"sync"
+	"time"
 
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfssync"
@@ -13,12 +14,22 @@ import (
 	"golang.org/x/net/context"
 )
 
+// favoritesBackgroundRefreshPeriod is how often the cached favorites
+// list is refreshed in the background, so that a recent list is
+// available while the servers can't be reached.
+const favoritesBackgroundRefreshPeriod = 10 * time.Minute
+
 type favToAdd struct {
 	Favorite
 
 	// created, if set to true, indicates that this is the first time the TLF has
 	// ever existed. It is only used when adding the TLF to favorites
 	created bool
+
+	// explicit, if set to true, indicates that the user asked for the
+	// TLF to be added, rather than it being added because it was
+	// opened.  Only explicit adds bring back an ignored TLF.
+	explicit bool
 }
 
 func (f favToAdd) ToKBFolder() keybase1.Folder {
@@ -61,21 +72,33 @@ type Favorites struct {
 	// favorites list, if other devices have modified the list since
 	// the last refresh.
 	cache map[Favorite]bool
+	// cacheUID is the user whose favorites are in cache, if known.
+	cacheUID keybase1.UID
+	// ignored tracks the TLFs this user deleted from their
+	// favorites, which aren't added back when they're opened.
+	ignored map[Favorite]bool
 
 	inFlightLock sync.Mutex
 	inFlightAdds map[favToAdd]*favReq
 
 	muShutdown sync.RWMutex
 	shutdown   bool
+
+	// Closed on shutdown, to stop the background refreshes.
+	refreshShutdownCh chan struct{}
+	refreshDoneCh     chan struct{}
 }
 
 func newFavoritesWithChan(config Config, reqChan chan *favReq) *Favorites {
 	f := &Favorites{
-		config:       config,
-		reqChan:      reqChan,
-		inFlightAdds: make(map[favToAdd]*favReq),
+		config:            config,
+		reqChan:           reqChan,
+		inFlightAdds:      make(map[favToAdd]*favReq),
+		refreshShutdownCh: make(chan struct{}),
+		refreshDoneCh:     make(chan struct{}),
 	}
 	go f.loop()
+	go f.refreshLoop()
 	return f
 }
 
@@ -104,27 +127,28 @@ func (f *Favorites) handleReq(req *favReq) (err error) {
 	//  * The user wants the list of favorites.  TODO: use the cached list
 	//    once we have proper invalidation from the server.
 	if req.refresh || f.cache == nil || req.favs != nil {
-		folders, err := kbpki.FavoriteList(req.ctx)
-		if err != nil {
+		err := f.fetch(req.ctx)
+		switch {
+		case err == nil:
+		case req.favs != nil && !req.refresh && f.cacheIsForCurrentUser(req.ctx):
+			// Serve the last known list while the servers can't be
+			// reached.
+			f.config.MakeLogger("").CDebugf(req.ctx,
+				"Using cached favorites after error: %+v", err)
+		default:
 			return err
 		}
-
-		f.cache = make(map[Favorite]bool)
-		for _, folder := range folders {
-			f.cache[*NewFavoriteFromFolder(folder)] = true
-		}
-		session, err := f.config.KBPKI().GetCurrentSession(req.ctx)
-		if err == nil {
-			// Add favorites for the current user, that cannot be deleted.
-			f.cache[Favorite{string(session.Name), tlf.Private}] = true
-			f.cache[Favorite{string(session.Name), tlf.Public}] = true
-		}
 	}
 
 	for _, fav := range req.toAdd {
 		if !fav.created && f.cache[fav.Favorite] {
 			continue
 		}
+		if !fav.explicit && f.ignored[fav.Favorite] {
+			f.config.MakeLogger("").CDebugf(req.ctx,
+				"Not adding ignored favorite %v", fav)
+			continue
+		}
 		err := kbpki.FavoriteAdd(req.ctx, fav.ToKBFolder())
 		if err != nil {
 			f.config.MakeLogger("").CDebugf(req.ctx,
@@ -132,6 +156,7 @@ func (f *Favorites) handleReq(req *favReq) (err error) {
 			return err
 		}
 		f.cache[fav.Favorite] = true
+		delete(f.ignored, fav.Favorite)
 	}
 
 	for _, fav := range req.toDel {
@@ -143,6 +168,8 @@ func (f *Favorites) handleReq(req *favReq) (err error) {
 			return err
 		}
 		delete(f.cache, fav)
+		// The server ignores deleted favorites too.
+		f.ignored[fav] = true
 	}
 
 	if req.favs != nil {
@@ -156,6 +183,67 @@ func (f *Favorites) handleReq(req *favReq) (err error) {
 	return nil
 }
 
+// fetch replaces the cached list with the one from the server.  If
+// that fails, the cached list stays as it was.
+func (f *Favorites) fetch(ctx context.Context) error {
+	result, err := f.config.KBPKI().FavoriteList(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.cache = make(map[Favorite]bool)
+	f.cacheUID = ""
+	for _, folder := range result.FavoriteFolders {
+		f.cache[*NewFavoriteFromFolder(folder)] = true
+	}
+	f.ignored = make(map[Favorite]bool)
+	for _, folder := range result.IgnoredFolders {
+		f.ignored[*NewFavoriteFromFolder(folder)] = true
+	}
+	session, err := f.config.KBPKI().GetCurrentSession(ctx)
+	if err == nil {
+		// Add favorites for the current user, that cannot be deleted.
+		f.cache[Favorite{string(session.Name), tlf.Private}] = true
+		f.cache[Favorite{string(session.Name), tlf.Public}] = true
+		f.cacheUID = session.UID
+	}
+	return nil
+}
+
+// cacheIsForCurrentUser returns whether the cached list is known to
+// belong to the logged-in user, so that it can be shown to them.
+func (f *Favorites) cacheIsForCurrentUser(ctx context.Context) bool {
+	if f.cache == nil || f.cacheUID == "" {
+		return false
+	}
+	session, err := f.config.KBPKI().GetCurrentSession(ctx)
+	return err == nil && session.UID == f.cacheUID
+}
+
+// refreshLoop refreshes the cached list periodically until shutdown.
+func (f *Favorites) refreshLoop() {
+	defer close(f.refreshDoneCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-f.refreshShutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ticker := time.NewTicker(favoritesBackgroundRefreshPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.RefreshCache(ctx)
+		case <-f.refreshShutdownCh:
+			return
+		}
+	}
+}
+
 func (f *Favorites) loop() {
 	for req := range f.reqChan {
 		f.handleReq(req)
@@ -165,6 +253,9 @@ func (f *Favorites) loop() {
 
 // Shutdown shuts down this Favorites instance.
 func (f *Favorites) Shutdown() error {
+	close(f.refreshShutdownCh)
+	<-f.refreshDoneCh
+
 	f.muShutdown.Lock()
 	defer f.muShutdown.Unlock()
 	f.shutdown = true
@@ -311,8 +402,23 @@ func (f *Favorites) RefreshCache(ctx context.Context) {
 	}
 }
 
-// Get returns the logged-in users list of favorites. It
-// doesn't use the cache.
+// Refresh replaces the cached list of favorites with the one from
+// the server, and waits for it.  If that fails, the cached list stays
+// as it was.
+func (f *Favorites) Refresh(ctx context.Context) error {
+	if f.hasShutdown() {
+		return ShutdownHappenedError{}
+	}
+	return f.sendReq(ctx, &favReq{
+		ctx:     ctx,
+		refresh: true,
+		done:    make(chan struct{}),
+	})
+}
+
+// Get returns the logged-in users list of favorites.  It fetches the
+// list from the server, unless that fails and the last list fetched
+// for the same user is cached, in which case it returns that.
 func (f *Favorites) Get(ctx context.Context) ([]Favorite, error) {
 	if f.hasShutdown() {
 		return nil, ShutdownHappenedError{}
@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -43,8 +44,9 @@ func TestFavoritesAddTwice(t *testing.T) {
 	defer favTestShutdown(t, mockCtrl, config, f)
 
 	// Call Add twice in a row, but only get one Add KBPKI call
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, false}
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, false, false}
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 	config.mockKbpki.EXPECT().FavoriteAdd(gomock.Any(), fav1.ToKBFolder()).
 		Return(nil)
 	if err := f.Add(ctx, fav1); err != nil {
@@ -62,19 +64,20 @@ func TestFavoriteAddCreatedAlwaysGoThrough(t *testing.T) {
 	f := NewFavorites(config)
 	defer favTestShutdown(t, mockCtrl, config, f)
 
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, false}
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, false, false}
 	expected1 := keybase1.Folder{
 		Name:       "test",
 		FolderType: keybase1.FolderType_PUBLIC,
 		Created:    false,
 	}
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 	config.mockKbpki.EXPECT().FavoriteAdd(gomock.Any(), expected1).Return(nil)
 	if err := f.Add(ctx, fav1); err != nil {
 		t.Fatalf("Couldn't add favorite: %v", err)
 	}
 
-	fav2 := favToAdd{Favorite{"test", tlf.Public}, true}
+	fav2 := favToAdd{Favorite{"test", tlf.Public}, true, false}
 	expected2 := keybase1.Folder{
 		Name:       "test",
 		FolderType: keybase1.FolderType_PUBLIC,
@@ -92,8 +95,9 @@ func TestFavoritesAddCreated(t *testing.T) {
 	defer favTestShutdown(t, mockCtrl, config, f)
 
 	// Call Add with created = true
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, true}
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, true, false}
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 	expected := keybase1.Folder{
 		Name:       "test",
 		FolderType: keybase1.FolderType_PUBLIC,
@@ -111,8 +115,9 @@ func TestFavoritesAddRemoveAdd(t *testing.T) {
 	f := NewFavorites(config)
 	defer favTestShutdown(t, mockCtrl, config, f)
 
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, false}
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, false, false}
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 	folder1 := fav1.ToKBFolder()
 	config.mockKbpki.EXPECT().FavoriteAdd(gomock.Any(), folder1).
 		Times(2).Return(nil)
@@ -126,10 +131,15 @@ func TestFavoritesAddRemoveAdd(t *testing.T) {
 		t.Fatalf("Couldn't delete favorite: %v", err)
 	}
 
-	// A second add shouldn't result in a KBPKI call
+	// The deleted favorite is ignored, so only an explicit add
+	// results in a KBPKI call.
 	if err := f.Add(ctx, fav1); err != nil {
 		t.Fatalf("Couldn't re-add same favorite: %v", err)
 	}
+	fav1.explicit = true
+	if err := f.Add(ctx, fav1); err != nil {
+		t.Fatalf("Couldn't re-add same favorite explicitly: %v", err)
+	}
 }
 
 func TestFavoritesAddAsync(t *testing.T) {
@@ -139,8 +149,9 @@ func TestFavoritesAddAsync(t *testing.T) {
 	defer favTestShutdown(t, mockCtrl, config, f)
 
 	// Call Add twice in a row, but only get one Add KBPKI call
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, false}
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, false, false}
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 
 	c := make(chan struct{})
 	// Block until thereare multiple outstanding calls
@@ -166,11 +177,11 @@ func TestFavoritesListFailsDuringAddAsync(t *testing.T) {
 	defer favTestShutdown(t, mockCtrl, config, f)
 
 	// Call Add twice in a row, but only get one Add KBPKI call
-	fav1 := favToAdd{Favorite{"test", tlf.Public}, false}
+	fav1 := favToAdd{Favorite{"test", tlf.Public}, false, false}
 
 	// Cancel the first list request
 	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
-		Return(nil, context.Canceled)
+		Return(keybase1.FavoritesResult{}, context.Canceled)
 
 	f.AddAsync(ctx, fav1) // this will fail
 	// Wait so the next one doesn't get batched together with this one
@@ -181,7 +192,8 @@ func TestFavoritesListFailsDuringAddAsync(t *testing.T) {
 	// Now make sure the second time around, the favorites get listed
 	// and one gets added, even if its context gets added
 	c := make(chan struct{})
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, nil)
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, nil)
 	config.mockKbpki.EXPECT().FavoriteAdd(gomock.Any(), fav1.ToKBFolder()).
 		Do(func(_ context.Context, _ keybase1.Folder) {
 			c <- struct{}{}
@@ -190,3 +202,50 @@ func TestFavoritesListFailsDuringAddAsync(t *testing.T) {
 	f.AddAsync(ctx, fav1) // should work
 	<-c
 }
+
+func TestFavoritesGetOffline(t *testing.T) {
+	mockCtrl, config, ctx := favTestInit(t)
+	f := NewFavorites(config)
+	defer favTestShutdown(t, mockCtrl, config, f)
+
+	// With nothing cached, a failed list is an error.
+	errOffline := errors.New("offline")
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, errOffline)
+	if _, err := f.Get(ctx); err != errOffline {
+		t.Fatalf("Unexpected error getting favorites: %v", err)
+	}
+
+	fav1 := Favorite{"test", tlf.Public}
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{
+			FavoriteFolders: []keybase1.Folder{fav1.ToKBFolder(false)},
+		}, nil)
+	if err := f.Refresh(ctx); err != nil {
+		t.Fatalf("Couldn't refresh favorites: %v", err)
+	}
+
+	// Once the list is cached, it's used while the server is
+	// unreachable, but forced refreshes still fail.
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, errOffline).Times(2)
+	favs, err := f.Get(ctx)
+	if err != nil {
+		t.Fatalf("Couldn't get cached favorites: %v", err)
+	}
+	if len(favs) != 3 {
+		t.Fatalf("Unexpected favorites: %v", favs)
+	}
+	found := false
+	for _, fav := range favs {
+		if fav == fav1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Cached favorites are missing %v: %v", fav1, favs)
+	}
+	if err := f.Refresh(ctx); err != errOffline {
+		t.Fatalf("Unexpected error refreshing favorites: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// fboMapShardCount is the number of independently-locked shards in
+// an fboMap.
+const fboMapShardCount = 32
+
+// fboMapShard holds the ops for the folder-branches that hash to it.
+type fboMapShard struct {
+	lock sync.RWMutex
+	ops  map[FolderBranch]*folderBranchOps
+	// evicting holds a channel for each folder-branch whose idle ops
+	// are being shut down, which is closed once they are gone.
+	evicting map[FolderBranch]chan struct{}
+}
+
+// fboMap maps folder-branches to their ops.  It is split into
+// shards with their own locks, so that looking up the ops of one
+// folder never waits on a lookup or creation for an unrelated
+// folder.
+type fboMap struct {
+	shards [fboMapShardCount]fboMapShard
+}
+
+func newFBOMap() *fboMap {
+	m := &fboMap{}
+	for i := range m.shards {
+		m.shards[i].ops = make(map[FolderBranch]*folderBranchOps)
+		m.shards[i].evicting = make(map[FolderBranch]chan struct{})
+	}
+	return m
+}
+
+// shard returns the shard holding the ops for fb.
+func (m *fboMap) shard(fb FolderBranch) *fboMapShard {
+	h := fnv.New32a()
+	_, _ = h.Write(fb.Tlf.Bytes())
+	_, _ = h.Write([]byte(fb.Branch))
+	return &m.shards[h.Sum32()%fboMapShardCount]
+}
+
+// get returns the ops for fb, if there are any.
+func (m *fboMap) get(fb FolderBranch) (*folderBranchOps, bool) {
+	s := m.shard(fb)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ops, ok := s.ops[fb]
+	return ops, ok
+}
+
+// snapshot returns a copy of the whole map.  Ops may be added or
+// removed concurrently, so the copy may be out of date by the time
+// it's used.
+func (m *fboMap) snapshot() map[FolderBranch]*folderBranchOps {
+	all := make(map[FolderBranch]*folderBranchOps)
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.lock.RLock()
+		for fb, ops := range s.ops {
+			all[fb] = ops
+		}
+		s.lock.RUnlock()
+	}
+	return all
+}
+
+// len returns the number of ops in the map.
+func (m *fboMap) len() (n int) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.lock.RLock()
+		n += len(s.ops)
+		s.lock.RUnlock()
+	}
+	return n
+}
+
+// clear removes all the ops from the map and returns them.  Until
+// the returned function is called, anyone looking up one of the
+// removed folder-branches waits instead of starting new ops for it,
+// so the removed ops can be shut down without holding any locks.
+func (m *fboMap) clear() (
+	cleared map[FolderBranch]*folderBranchOps, done func()) {
+	for i := range m.shards {
+		m.shards[i].lock.Lock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].lock.Unlock()
+		}
+	}()
+
+	clearing := make(chan struct{})
+	cleared = make(map[FolderBranch]*folderBranchOps)
+	for i := range m.shards {
+		s := &m.shards[i]
+		for fb, ops := range s.ops {
+			cleared[fb] = ops
+			s.evicting[fb] = clearing
+		}
+		s.ops = make(map[FolderBranch]*folderBranchOps)
+	}
+	return cleared, func() {
+		for fb := range cleared {
+			s := m.shard(fb)
+			s.lock.Lock()
+			if s.evicting[fb] == clearing {
+				delete(s.evicting, fb)
+			}
+			s.lock.Unlock()
+		}
+		close(clearing)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFBOMap(t *testing.T) {
+	m := newFBOMap()
+	fbs := make([]FolderBranch, 100)
+	for i := range fbs {
+		fbs[i] = FolderBranch{
+			Tlf: tlf.FakeID(byte(i), tlf.Private), Branch: MasterBranch}
+		s := m.shard(fbs[i])
+		require.Equal(t, s, m.shard(fbs[i]))
+		s.ops[fbs[i]] = &folderBranchOps{}
+	}
+	require.Equal(t, len(fbs), m.len())
+
+	t.Log("Folder-branches are spread across shards")
+	used := 0
+	for i := range m.shards {
+		if len(m.shards[i].ops) > 0 {
+			used++
+		}
+	}
+	require.True(t, used > 1)
+
+	ops, ok := m.get(fbs[3])
+	require.True(t, ok)
+	require.Equal(t, m.shard(fbs[3]).ops[fbs[3]], ops)
+	_, ok = m.get(FolderBranch{
+		Tlf: tlf.FakeID(1, tlf.Public), Branch: MasterBranch})
+	require.False(t, ok)
+	require.Len(t, m.snapshot(), len(fbs))
+
+	cleared, done := m.clear()
+	require.Len(t, cleared, len(fbs))
+	require.Equal(t, 0, m.len())
+	for _, fb := range fbs {
+		require.Contains(t, m.shard(fb).evicting, fb)
+	}
+	done()
+	for _, fb := range fbs {
+		require.NotContains(t, m.shard(fb).evicting, fb)
+	}
+}
@@ -26,6 +26,12 @@ type fileBlockGetter func(context.Context, KeyMetadata, BlockPointer,
 // dirtyBlockCacher writes dirty blocks to a cache.
 type dirtyBlockCacher func(ptr BlockPointer, block Block) error
 
+// minFileHoleSize is the smallest gap, between the end of a block's
+// contents and a write into the hole after it, that's left as a hole
+// rather than zero-filled.  Smaller gaps are cheaper to fill than to
+// track as separate blocks.
+const minFileHoleSize = 4 * 1024
+
 // fileData is a helper struct for accessing and manipulating data
 // within a file.  It's meant for use within a single scope, not for
 // long-term storage.  The caller must ensure goroutine-safety.
@@ -465,6 +471,124 @@ func (fd *fileData) getIndirectBlocksForOffsetRange(ctx context.Context,
 	return pfr, nil
 }
 
+// getLeafPtrsFromOffset returns the pointers of up to `n` leaf blocks
+// of the file, in offset order, starting with the first leaf block
+// that begins at or after `off`.  It fetches the indirect blocks it
+// needs, but never the leaf blocks themselves.  Leaf blocks written
+// before pointers were labeled with their direct type are skipped.
+func (fd *fileData) getLeafPtrsFromOffset(
+	ctx context.Context, off int64, n int) ([]BlockPointer, error) {
+	topBlock, _, err := fd.getter(ctx, fd.kmd, fd.rootBlockPointer(),
+		fd.file, blockRead)
+	if err != nil {
+		return nil, err
+	}
+	if !topBlock.IsInd || n <= 0 {
+		return nil, nil
+	}
+	return fd.appendLeafPtrsFromOffset(ctx, topBlock, off, n, nil)
+}
+
+func (fd *fileData) appendLeafPtrsFromOffset(ctx context.Context,
+	pblock *FileBlock, off int64, n int, ptrs []BlockPointer) (
+	[]BlockPointer, error) {
+	for i, iptr := range pblock.IPtrs {
+		if len(ptrs) >= n {
+			break
+		}
+		// Skip the children that end before `off`.
+		if i < len(pblock.IPtrs)-1 && pblock.IPtrs[i+1].Off <= off {
+			continue
+		}
+		switch iptr.DirectType {
+		case DirectBlock:
+			if iptr.Off >= off {
+				ptrs = append(ptrs, iptr.BlockPointer)
+			}
+		case IndirectBlock:
+			block, _, err := fd.getter(
+				ctx, fd.kmd, iptr.BlockPointer, fd.file, blockRead)
+			if err != nil {
+				return nil, err
+			}
+			ptrs, err = fd.appendLeafPtrsFromOffset(ctx, block, off, n, ptrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ptrs, nil
+}
+
+// splitsAfter returns whether the block splitter, writing the file
+// from scratch, would end a block holding `contents` right at its
+// end, given that `next` holds the data that follows it, or is empty
+// if the block ends the file or is followed by a hole.
+func (fd *fileData) splitsAfter(contents, next []byte) bool {
+	data := contents
+	if len(next) > 0 {
+		data = make([]byte, len(contents)+1)
+		copy(data, contents)
+		data[len(contents)] = next[0]
+	}
+	n := fd.bsplit.CopyUntilSplit(NewFileBlock().(*FileBlock), true, data, 0)
+	return n == int64(len(contents))
+}
+
+// matchesSplitter returns whether every leaf block of the file ends
+// where the current block splitter would end it, if the file were
+// written again from scratch.  It fetches all the blocks of the file,
+// and stops at the first one that doesn't match.  The fan-out of the
+// indirect blocks isn't checked.
+func (fd *fileData) matchesSplitter(ctx context.Context) (bool, error) {
+	topBlock, _, err := fd.getter(ctx, fd.kmd, fd.rootBlockPointer(),
+		fd.file, blockRead)
+	if err != nil {
+		return false, err
+	}
+	if !topBlock.IsInd {
+		return fd.splitsAfter(topBlock.Contents, nil), nil
+	}
+
+	// Each leaf block can only be checked once the first byte of the
+	// next one is known.
+	var prev *FileBlock
+	var prevOff int64
+	var check func(pblock *FileBlock) (bool, error)
+	check = func(pblock *FileBlock) (bool, error) {
+		for _, iptr := range pblock.IPtrs {
+			block, _, err := fd.getter(
+				ctx, fd.kmd, iptr.BlockPointer, fd.file, blockRead)
+			if err != nil {
+				return false, err
+			}
+			if block.IsInd {
+				matches, err := check(block)
+				if err != nil || !matches {
+					return false, err
+				}
+				continue
+			}
+			if prev != nil {
+				var next []byte
+				if prevOff+int64(len(prev.Contents)) == iptr.Off {
+					next = block.Contents
+				}
+				if !fd.splitsAfter(prev.Contents, next) {
+					return false, nil
+				}
+			}
+			prev, prevOff = block, iptr.Off
+		}
+		return true, nil
+	}
+	matches, err := check(topBlock)
+	if err != nil || !matches || prev == nil {
+		return matches, err
+	}
+	return fd.splitsAfter(prev.Contents, nil), nil
+}
+
 // getByteSlicesInOffsetRange returns an ordered, continuous slice of
 // byte ranges for the data described by the half-inclusive offset
 // range `[startOff, endOff)`.  If `endOff` == -1, it returns data to
@@ -1102,9 +1226,18 @@ func (fd *fileData) write(ctx context.Context, data []byte, off int64,
 				max = room
 			}
 		}
+		// If the write lands in a hole, well past the end of this
+		// block's contents, leave the gap as a hole instead of
+		// zero-filling this block up to the write; a new block will
+		// be made at the write offset below.
+		inHole := nextBlockOff > 0 &&
+			off+nCopied-(startOff+int64(oldLen)) >= minFileHoleSize
 		oldNCopied := nCopied
-		nCopied += fd.bsplit.CopyUntilSplit(
-			block, nextBlockOff < 0, data[nCopied:max], off+nCopied-startOff)
+		if !inHole {
+			nCopied += fd.bsplit.CopyUntilSplit(
+				block, nextBlockOff < 0, data[nCopied:max],
+				off+nCopied-startOff)
+		}
 
 		// If we need another block but there are no more, then make one.
 		switchToIndirect := false
@@ -1174,6 +1307,12 @@ func (fd *fileData) write(ctx context.Context, data []byte, off int64,
 				}
 				unrefs = append(unrefs, newUnrefs...)
 				newlyDirtiedChildBytes += bytes
+				if inHole {
+					// The new block leaves a hole to its left.
+					for i := range topBlock.IPtrs {
+						topBlock.IPtrs[i].Holes = true
+					}
+				}
 				if oldSizeWithoutHoles == oldDe.Size {
 					// For the purposes of calculating the newly-dirtied
 					// bytes for the deferral calculation, disregard the
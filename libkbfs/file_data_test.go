@@ -604,6 +604,61 @@ func TestFileDataWriteHole(t *testing.T) {
 	}
 }
 
+func TestFileDataWriteScatteredInHole(t *testing.T) {
+	const maxBlockSize = 64 * 1024
+	fd, cleanBcache, _, df := setupFileDataTest(t, maxBlockSize, 4)
+	ctx := context.Background()
+	data := []byte("0123456789")
+	topBlock, _ := testFileDataLevelExistingBlocks(
+		t, fd, maxBlockSize, 4, data, nil, cleanBcache)
+	de := DirEntry{EntryInfo: EntryInfo{Size: uint64(len(data))}}
+
+	const size = 1024 * 1024
+	_, parentBlocks, _, _, _, _, err :=
+		fd.getFileBlockAtOffset(ctx, topBlock, size, blockWrite)
+	require.NoError(t, err)
+	de, _, err = fd.truncateExtend(ctx, size, topBlock, parentBlocks, de, df)
+	require.NoError(t, err)
+
+	t.Log("Write a little data at scattered offsets within the hole")
+	expected := make([]byte, size)
+	copy(expected, data)
+	for _, off := range []int64{300 * 1024, 100*1024 + 7, 20} {
+		topBlock, _, err = fd.getter(
+			ctx, fd.kmd, fd.rootBlockPointer(), fd.file, blockWrite)
+		require.NoError(t, err)
+		newData := bytes.Repeat([]byte{byte(off)}, 100)
+		de, _, _, _, _, err = fd.write(ctx, newData, off, topBlock, de, df)
+		require.NoError(t, err)
+		copy(expected[off:], newData)
+	}
+	require.Equal(t, uint64(size), de.Size)
+
+	t.Log("Only the written ranges, and the small gap before the " +
+		"last write, take up space in blocks")
+	topBlock, _, err = fd.getter(
+		ctx, fd.kmd, fd.rootBlockPointer(), fd.file, blockRead)
+	require.NoError(t, err)
+	pfr, blocks, _, err := fd.getLeafBlocksForOffsetRange(
+		ctx, fd.rootBlockPointer(), topBlock, 0, -1, false)
+	require.NoError(t, err)
+	require.Len(t, pfr, 4)
+	stored := 0
+	for _, block := range blocks {
+		stored += len(block.Contents)
+	}
+	require.Equal(t, 120+2*100, stored)
+	for _, iptr := range topBlock.IPtrs {
+		require.True(t, iptr.Holes)
+	}
+
+	gotData := make([]byte, size)
+	nRead, err := fd.read(ctx, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(size), nRead)
+	require.True(t, bytes.Equal(expected, gotData))
+}
+
 func testFileDataCheckTruncateExtend(t *testing.T, fd *fileData,
 	dirtyBcache DirtyBlockCache, df *dirtyFile, size uint64,
 	topBlock *FileBlock, oldDe DirEntry, expectedTopLevel testFileDataLevel) {
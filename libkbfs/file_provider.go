@@ -0,0 +1,435 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	gopath "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// fileProviderMaxChanges is how many changed items a FileProvider
+// remembers before it starts expiring the oldest anchors.
+const fileProviderMaxChanges = 10000
+
+// FileProviderItemID identifies an item to a FileProvider.  It's the
+// item's path below the KBFS root, like "private/alice/docs/a.txt".
+// The root container is FileProviderRootItemID, and its children
+// "private", "public" and "team" list the favorite folders of each
+// type.  Since the ID is a path, a renamed item shows up in changes
+// as the deletion of its old ID and the addition of its new one.
+type FileProviderItemID string
+
+// FileProviderRootItemID identifies the root container, which holds
+// the folder lists.
+const FileProviderRootItemID FileProviderItemID = ""
+
+// Parent returns the ID of the container holding the item.
+func (id FileProviderItemID) Parent() FileProviderItemID {
+	i := strings.LastIndex(string(id), "/")
+	if i < 0 {
+		return FileProviderRootItemID
+	}
+	return id[:i]
+}
+
+// Child returns the ID of the item with the given name in this
+// container.
+func (id FileProviderItemID) Child(name string) FileProviderItemID {
+	if id == FileProviderRootItemID {
+		return FileProviderItemID(name)
+	}
+	return id + "/" + FileProviderItemID(name)
+}
+
+// FileProviderItem describes a file, directory or symlink to a
+// FileProvider extension.
+type FileProviderItem struct {
+	ID       FileProviderItemID
+	ParentID FileProviderItemID
+	Filename string
+	EntryInfo
+}
+
+// FileProviderAnchor marks a point in the sequence of changes that a
+// FileProvider has seen, and is meant to be handed to the extension
+// as its sync anchor.  Anchors start from the time the FileProvider
+// was made, so that anchors from before a restart are recognized as
+// expired.
+type FileProviderAnchor uint64
+
+type fileProviderChange struct {
+	anchor FileProviderAnchor
+	id     FileProviderItemID
+}
+
+var fileProviderPathTypes = map[string]tlf.Type{
+	string(PrivatePathType):    tlf.Private,
+	string(PublicPathType):     tlf.Public,
+	string(SingleTeamPathType): tlf.SingleTeam,
+}
+
+// FileProvider adapts KBFSOps to the model of a macOS FileProvider
+// extension: items are fetched and enumerated by identifier, changes
+// are enumerated since a sync anchor, and file data can be
+// materialized into, or dematerialized from, the disk block cache on
+// demand.  Changes are only tracked for folders whose items have been
+// fetched or enumerated through the FileProvider, and changes to the
+// favorites lists aren't tracked at all.
+type FileProvider struct {
+	config Config
+	log    logger.Logger
+
+	// watchLock protects watched, and is held while registering for
+	// changes, so it must never be taken by the Observer methods.
+	watchLock sync.Mutex
+	watched   map[FolderBranch]bool
+
+	// changesLock protects the fields below it.
+	changesLock sync.Mutex
+	// oldest is the earliest anchor that changes can still be
+	// enumerated from, and latest is the current one.
+	oldest  FileProviderAnchor
+	latest  FileProviderAnchor
+	changes []fileProviderChange
+}
+
+var _ Observer = (*FileProvider)(nil)
+
+// NewFileProvider returns a FileProvider over the KBFSOps of the
+// given config.  Shutdown must be called when it's no longer needed.
+func NewFileProvider(config Config) *FileProvider {
+	start := FileProviderAnchor(time.Now().UnixNano())
+	return &FileProvider{
+		config:  config,
+		log:     config.MakeLogger(""),
+		watched: make(map[FolderBranch]bool),
+		oldest:  start,
+		latest:  start,
+	}
+}
+
+// Shutdown stops tracking changes.
+func (fp *FileProvider) Shutdown() error {
+	fp.watchLock.Lock()
+	defer fp.watchLock.Unlock()
+	fbs := make([]FolderBranch, 0, len(fp.watched))
+	for fb := range fp.watched {
+		fbs = append(fbs, fb)
+	}
+	fp.watched = make(map[FolderBranch]bool)
+	return fp.config.Notifier().UnregisterFromChanges(fbs, fp)
+}
+
+func (fp *FileProvider) watch(fb FolderBranch) error {
+	fp.watchLock.Lock()
+	defer fp.watchLock.Unlock()
+	if fp.watched[fb] {
+		return nil
+	}
+	err := fp.config.Notifier().RegisterForChanges([]FolderBranch{fb}, fp)
+	if err != nil {
+		return err
+	}
+	fp.watched[fb] = true
+	return nil
+}
+
+// resolve looks up the given item.  It returns a nil node for the
+// root container, the folder lists, and symlinks.
+func (fp *FileProvider) resolve(ctx context.Context, id FileProviderItemID) (
+	node Node, ei EntryInfo, err error) {
+	if id == FileProviderRootItemID {
+		return nil, EntryInfo{Type: Dir}, nil
+	}
+	parts := strings.Split(string(id), "/")
+	t, ok := fileProviderPathTypes[parts[0]]
+	if !ok {
+		return nil, EntryInfo{}, NoSuchNameError{Name: string(id)}
+	}
+	if len(parts) == 1 {
+		return nil, EntryInfo{Type: Dir}, nil
+	}
+
+	h, err := GetHandleFromFolderNameAndType(
+		ctx, fp.config.KBPKI(), fp.config.MDOps(), parts[1], t)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	kbfsOps := fp.config.KBFSOps()
+	node, ei, err = kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	err = fp.watch(node.GetFolderBranch())
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	for i, name := range parts[2:] {
+		if node == nil {
+			// Only the last element may be a symlink.
+			return nil, EntryInfo{}, NoSuchNameError{
+				Name: strings.Join(parts[:i+3], "/")}
+		}
+		node, ei, err = kbfsOps.Lookup(ctx, node, name)
+		if err != nil {
+			return nil, EntryInfo{}, err
+		}
+	}
+	return node, ei, nil
+}
+
+func (fp *FileProvider) resolveFile(
+	ctx context.Context, id FileProviderItemID) (
+	*KBFSOpsStandard, Node, error) {
+	kbfsOps, ok := fp.config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return nil, nil, errors.Errorf(
+			"Unsupported KBFSOps type: %T", fp.config.KBFSOps())
+	}
+	node, ei, err := fp.resolve(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil || (ei.Type != File && ei.Type != Exec) {
+		return nil, nil, errors.Errorf("%s is not a file", id)
+	}
+	return kbfsOps, node, nil
+}
+
+// Item returns the item with the given ID.
+func (fp *FileProvider) Item(ctx context.Context, id FileProviderItemID) (
+	FileProviderItem, error) {
+	_, ei, err := fp.resolve(ctx, id)
+	if err != nil {
+		return FileProviderItem{}, err
+	}
+	return FileProviderItem{
+		ID:        id,
+		ParentID:  id.Parent(),
+		Filename:  gopath.Base("/" + string(id)),
+		EntryInfo: ei,
+	}, nil
+}
+
+// Children returns the items in the given container, sorted by name.
+func (fp *FileProvider) Children(ctx context.Context,
+	id FileProviderItemID) ([]FileProviderItem, error) {
+	var entries map[string]EntryInfo
+	switch t, isList := fileProviderPathTypes[string(id)]; {
+	case id == FileProviderRootItemID:
+		entries = make(map[string]EntryInfo, len(fileProviderPathTypes))
+		for name := range fileProviderPathTypes {
+			entries[name] = EntryInfo{Type: Dir}
+		}
+	case isList:
+		favs, err := fp.config.KBFSOps().GetFavorites(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries = make(map[string]EntryInfo)
+		for _, fav := range favs {
+			if fav.Type == t {
+				entries[fav.Name] = EntryInfo{Type: Dir}
+			}
+		}
+	default:
+		node, ei, err := fp.resolve(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ei.Type != Dir {
+			return nil, errors.Errorf("%s is not a directory", id)
+		}
+		entries, err = fp.config.KBFSOps().GetDirChildren(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]FileProviderItem, 0, len(entries))
+	for name, ei := range entries {
+		items = append(items, FileProviderItem{
+			ID:        id.Child(name),
+			ParentID:  id,
+			Filename:  name,
+			EntryInfo: ei,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Filename < items[j].Filename
+	})
+	return items, nil
+}
+
+// CurrentAnchor returns the anchor to enumerate later changes from.
+func (fp *FileProvider) CurrentAnchor() FileProviderAnchor {
+	fp.changesLock.Lock()
+	defer fp.changesLock.Unlock()
+	return fp.latest
+}
+
+// ChangesSince returns the items that were added or changed since the
+// given anchor, the IDs of the ones that were deleted, and the anchor
+// to use next time.  It returns a FileProviderAnchorExpiredError if
+// the changes since the anchor are no longer known.
+func (fp *FileProvider) ChangesSince(
+	ctx context.Context, anchor FileProviderAnchor) (
+	updated []FileProviderItem, deleted []FileProviderItemID,
+	latest FileProviderAnchor, err error) {
+	var ids []FileProviderItemID
+	func() {
+		fp.changesLock.Lock()
+		defer fp.changesLock.Unlock()
+		latest = fp.latest
+		if anchor < fp.oldest || anchor > fp.latest {
+			err = FileProviderAnchorExpiredError{anchor}
+			return
+		}
+		seen := make(map[FileProviderItemID]bool)
+		for _, c := range fp.changes {
+			if c.anchor > anchor && !seen[c.id] {
+				seen[c.id] = true
+				ids = append(ids, c.id)
+			}
+		}
+	}()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		item, err := fp.Item(ctx, id)
+		switch errors.Cause(err).(type) {
+		case nil:
+			updated = append(updated, item)
+		case NoSuchNameError:
+			deleted = append(deleted, id)
+		default:
+			return nil, nil, 0, err
+		}
+	}
+	return updated, deleted, latest, nil
+}
+
+// GetHydration returns how much of the data of the given file is
+// stored locally.
+func (fp *FileProvider) GetHydration(ctx context.Context,
+	id FileProviderItemID) (FileHydration, error) {
+	kbfsOps, node, err := fp.resolveFile(ctx, id)
+	if err != nil {
+		return FileDehydrated, err
+	}
+	return kbfsOps.GetFileHydration(ctx, node)
+}
+
+// Materialize fetches all of the data of the given file into the disk
+// block cache, calling progress, if it's non-nil, as it goes along.
+func (fp *FileProvider) Materialize(ctx context.Context,
+	id FileProviderItemID, progress func(HydrationProgress)) error {
+	kbfsOps, node, err := fp.resolveFile(ctx, id)
+	if err != nil {
+		return err
+	}
+	return kbfsOps.HydrateFile(ctx, node, progress)
+}
+
+// Dematerialize removes the data of the given file from the disk
+// block cache, leaving it as a placeholder.
+func (fp *FileProvider) Dematerialize(
+	ctx context.Context, id FileProviderItemID) error {
+	kbfsOps, node, err := fp.resolveFile(ctx, id)
+	if err != nil {
+		return err
+	}
+	return kbfsOps.DehydrateFile(ctx, node)
+}
+
+// nodeItemID returns the ID of the item at the given node, if its
+// path is known.
+func (fp *FileProvider) nodeItemID(
+	ctx context.Context, node Node) (FileProviderItemID, bool) {
+	kbfsOps, ok := fp.config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return "", false
+	}
+	// Observers are called with the folder's locks held, so don't
+	// let the lookup try to add the folder as a favorite.
+	ops := kbfsOps.getOpsNoAdd(ctx, node.GetFolderBranch())
+	if ops.nodeCache == nil {
+		return "", false
+	}
+	p := ops.nodeCache.PathFromNode(node)
+	if !p.isValid() {
+		return "", false
+	}
+	prefix := BuildCanonicalPath(KeybasePathType) + "/"
+	return FileProviderItemID(
+		strings.TrimPrefix(p.CanonicalPathString(), prefix)), true
+}
+
+func (fp *FileProvider) recordChanges(ids []FileProviderItemID) {
+	if len(ids) == 0 {
+		return
+	}
+	fp.changesLock.Lock()
+	defer fp.changesLock.Unlock()
+	fp.latest++
+	for _, id := range ids {
+		fp.changes = append(
+			fp.changes, fileProviderChange{anchor: fp.latest, id: id})
+	}
+	if extra := len(fp.changes) - fileProviderMaxChanges; extra > 0 {
+		// Drop whole anchors, so that no anchor is left with only
+		// some of its changes.
+		dropped := fp.changes[extra-1].anchor
+		for extra < len(fp.changes) && fp.changes[extra].anchor == dropped {
+			extra++
+		}
+		fp.changes = append([]fileProviderChange(nil), fp.changes[extra:]...)
+		fp.oldest = dropped
+	}
+}
+
+// LocalChange implements the Observer interface for FileProvider.
+func (fp *FileProvider) LocalChange(
+	ctx context.Context, node Node, _ WriteRange) {
+	if id, ok := fp.nodeItemID(ctx, node); ok {
+		fp.recordChanges([]FileProviderItemID{id})
+	}
+}
+
+// BatchChanges implements the Observer interface for FileProvider.
+func (fp *FileProvider) BatchChanges(
+	ctx context.Context, changes []NodeChange, _ []NodeID) {
+	var ids []FileProviderItemID
+	for _, change := range changes {
+		id, ok := fp.nodeItemID(ctx, change.Node)
+		if !ok {
+			continue
+		}
+		ids = append(ids, id)
+		for _, name := range change.DirUpdated {
+			ids = append(ids, id.Child(name))
+		}
+	}
+	fp.recordChanges(ids)
+}
+
+// TlfHandleChange implements the Observer interface for FileProvider.
+func (fp *FileProvider) TlfHandleChange(ctx context.Context,
+	newHandle *TlfHandle) {
+	fp.log.CDebugf(ctx, "Folder %s was renamed; its items will need to be "+
+		"enumerated again", newHandle.GetCanonicalPath())
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProvider(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// The config shuts the cache down.
+	dbc, _ := initDiskBlockCacheTest(t)
+	config.diskBlockCache = dbc
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	bsplitter, err := NewBlockSplitterSimple(20, 8*1024, config.Codec())
+	require.NoError(t, err)
+	config.SetBlockSplitter(bsplitter)
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 100)
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	fp := NewFileProvider(config)
+	defer func() {
+		err := fp.Shutdown()
+		require.NoError(t, err)
+	}()
+
+	t.Log("Fetch and enumerate items")
+	items, err := fp.Children(ctx, FileProviderRootItemID)
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	require.Equal(t, FileProviderItemID("private"), items[0].ID)
+	items, err = fp.Children(ctx, "private/u1/d")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, FileProviderItemID("private/u1/d/f"), items[0].ID)
+	require.Equal(t, FileProviderItemID("private/u1/d"), items[0].ParentID)
+	item, err := fp.Item(ctx, "private/u1/d/f")
+	require.NoError(t, err)
+	require.Equal(t, "f", item.Filename)
+	require.Equal(t, File, item.Type)
+	require.Equal(t, uint64(len(data)), item.Size)
+	_, err = fp.Item(ctx, "private/u1/missing")
+	require.IsType(t, NoSuchNameError{}, err)
+
+	// Observers without a coalescing window are notified before a
+	// local change returns, so the changes are already recorded.
+	getChanges := func(anchor FileProviderAnchor) (
+		[]FileProviderItem, []FileProviderItemID, FileProviderAnchor) {
+		updated, deleted, latest, err := fp.ChangesSince(ctx, anchor)
+		require.NoError(t, err)
+		require.NotEqual(t, anchor, latest, "No changes were seen")
+		return updated, deleted, latest
+	}
+
+	t.Log("Changes since an anchor are enumerated")
+	anchor := fp.CurrentAnchor()
+	_, _, err = kbfsOps.CreateFile(ctx, dirNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	updated, deleted, anchor := getChanges(anchor)
+	require.Len(t, deleted, 0)
+	var ids []FileProviderItemID
+	for _, item := range updated {
+		ids = append(ids, item.ID)
+	}
+	require.Contains(t, ids, FileProviderItemID("private/u1/d/g"))
+
+	err = kbfsOps.RemoveEntry(ctx, dirNode, "g")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	_, deleted, _ = getChanges(anchor)
+	require.Equal(t, []FileProviderItemID{"private/u1/d/g"}, deleted)
+
+	t.Log("Anchors from before the provider started have expired")
+	_, _, _, err = fp.ChangesSince(ctx, 1)
+	require.IsType(t, FileProviderAnchorExpiredError{}, err)
+
+	t.Log("Files can be dematerialized and materialized")
+	err = fp.Dematerialize(ctx, "private/u1/d/f")
+	require.NoError(t, err)
+	h, err := fp.GetHydration(ctx, "private/u1/d/f")
+	require.NoError(t, err)
+	require.Equal(t, FileDehydrated, h)
+	err = fp.Materialize(ctx, "private/u1/d/f", nil)
+	require.NoError(t, err)
+	h, err = fp.GetHydration(ctx, "private/u1/d/f")
+	require.NoError(t, err)
+	require.Equal(t, FileHydrated, h)
+	err = fp.Materialize(ctx, "private/u1/d", nil)
+	require.Error(t, err)
+}
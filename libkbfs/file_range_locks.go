@@ -0,0 +1,96 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// lockedFileRange is a byte range of a file held by one writer.
+type lockedFileRange struct {
+	// off and end delimit the half-inclusive range [off, end).  An
+	// `end` of -1 means the range extends to the end of the file,
+	// however big the file gets.
+	off, end int64
+	// doneCh is closed when the range is unlocked.
+	doneCh chan struct{}
+}
+
+func (r *lockedFileRange) overlaps(off, end int64) bool {
+	return (r.end == -1 || off < r.end) && (end == -1 || r.off < end)
+}
+
+// fileRangeLocks lets the writers of a file lock the byte ranges
+// they write.  Writers to disjoint ranges of the same file never
+// wait on each other, while a writer to a range that overlaps a
+// locked one waits until that range is unlocked, so overlapping
+// writes from different handles land in a well-defined order.
+//
+// Range locks are taken before `blockLock`, and must never be taken
+// while holding it.
+type fileRangeLocks struct {
+	lock sync.Mutex
+	held map[NodeID][]*lockedFileRange
+}
+
+func newFileRangeLocks() *fileRangeLocks {
+	return &fileRangeLocks{
+		held: make(map[NodeID][]*lockedFileRange),
+	}
+}
+
+// lockRange locks the range [off, end) of the file with the given
+// node ID, where an `end` of -1 means the rest of the file.  It
+// waits until no other writer holds an overlapping range, or until
+// `ctx` is canceled.  On success, the caller must call the returned
+// function to unlock the range.
+func (frl *fileRangeLocks) lockRange(
+	ctx context.Context, id NodeID, off, end int64) (
+	unlock func(), err error) {
+	for {
+		frl.lock.Lock()
+		var waitCh chan struct{}
+		for _, r := range frl.held[id] {
+			if r.overlaps(off, end) {
+				waitCh = r.doneCh
+				break
+			}
+		}
+		if waitCh == nil {
+			r := &lockedFileRange{off, end, make(chan struct{})}
+			frl.held[id] = append(frl.held[id], r)
+			frl.lock.Unlock()
+			return func() { frl.unlockRange(id, r) }, nil
+		}
+		frl.lock.Unlock()
+
+		select {
+		case <-waitCh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (frl *fileRangeLocks) unlockRange(id NodeID, r *lockedFileRange) {
+	frl.lock.Lock()
+	defer frl.lock.Unlock()
+	ranges := frl.held[id]
+	for i, held := range ranges {
+		if held != r {
+			continue
+		}
+		ranges = append(ranges[:i], ranges[i+1:]...)
+		break
+	}
+	if len(ranges) == 0 {
+		delete(frl.held, id)
+	} else {
+		frl.held[id] = ranges
+	}
+	close(r.doneCh)
+}
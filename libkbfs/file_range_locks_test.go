@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testRangeLockNodeID struct {
+	name string
+}
+
+func (testRangeLockNodeID) ParentID() NodeID { return nil }
+
+func TestFileRangeLocksDisjoint(t *testing.T) {
+	frl := newFileRangeLocks()
+	id := &testRangeLockNodeID{"a"}
+	ctx := context.Background()
+
+	unlock1, err := frl.lockRange(ctx, id, 0, 10)
+	require.NoError(t, err)
+	unlock2, err := frl.lockRange(ctx, id, 10, 20)
+	require.NoError(t, err)
+
+	t.Log("A different file doesn't conflict either")
+	unlock3, err := frl.lockRange(ctx, &testRangeLockNodeID{"b"}, 0, 10)
+	require.NoError(t, err)
+
+	unlock1()
+	unlock2()
+	unlock3()
+	require.Len(t, frl.held, 0)
+}
+
+func TestFileRangeLocksOverlap(t *testing.T) {
+	frl := newFileRangeLocks()
+	id := &testRangeLockNodeID{"a"}
+	ctx := context.Background()
+
+	unlock, err := frl.lockRange(ctx, id, 5, 15)
+	require.NoError(t, err)
+
+	t.Log("An overlapping range waits until the first is unlocked")
+	lockedCh := make(chan func())
+	go func() {
+		unlock, err := frl.lockRange(ctx, id, 10, 20)
+		if err != nil {
+			close(lockedCh)
+			return
+		}
+		lockedCh <- unlock
+	}()
+	select {
+	case <-lockedCh:
+		t.Fatal("Overlapping range was locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock2, ok := <-lockedCh:
+		require.True(t, ok)
+		unlock2()
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the overlapping range")
+	}
+
+	t.Log("A range to the end of the file overlaps everything after it")
+	unlock, err = frl.lockRange(ctx, id, 100, -1)
+	require.NoError(t, err)
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = frl.lockRange(ctx2, id, 1000, 1001)
+	require.Equal(t, context.DeadlineExceeded, err)
+	unlock3, err := frl.lockRange(ctx, id, 0, 100)
+	require.NoError(t, err)
+	unlock3()
+	unlock()
+}
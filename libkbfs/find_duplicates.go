@@ -0,0 +1,131 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	stdpath "path"
+	"sort"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"golang.org/x/net/context"
+)
+
+// DuplicateGroup is a set of files within a TLF that all have the
+// same contents.
+type DuplicateGroup struct {
+	// Size is the size of each of the files.
+	Size uint64
+	// Paths lists the files, relative to the root of the TLF, in
+	// sorted order.
+	Paths []string
+}
+
+// Wasted returns how many bytes could be reclaimed by keeping only
+// one of the files in the group.
+func (dg DuplicateGroup) Wasted() uint64 {
+	return dg.Size * uint64(len(dg.Paths)-1)
+}
+
+type duplicateCandidate struct {
+	path string
+	node Node
+}
+
+// findDuplicateCandidates walks the subtree under `dir`, adding each
+// non-empty file to `bySize`.
+func findDuplicateCandidates(ctx context.Context, kbfsOps KBFSOps,
+	p string, dir Node, bySize map[uint64][]duplicateCandidate) error {
+	children, err := kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for name, ei := range children {
+		switch ei.Type {
+		case Sym:
+			continue
+		case File, Exec:
+			if ei.Size == 0 {
+				continue
+			}
+		}
+
+		child, _, err := kbfsOps.Lookup(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+		childPath := stdpath.Join(p, name)
+		if ei.Type == Dir {
+			err = findDuplicateCandidates(
+				ctx, kbfsOps, childPath, child, bySize)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		bySize[ei.Size] = append(
+			bySize[ei.Size], duplicateCandidate{childPath, child})
+	}
+	return nil
+}
+
+// FindDuplicates returns the groups of files in `folderBranch` that
+// have identical contents, with the groups that waste the most space
+// first.  Only files that have the same size as some other file are
+// read, and they're compared by the SHA-256 hashes of their
+// contents.  Files that point to the same block (for example, ones
+// made by KBFSOps.CopyFile) are hashed only once.  Empty files and
+// symlinks are never reported.
+func FindDuplicates(ctx context.Context, kbfsOps KBFSOps,
+	folderBranch FolderBranch) ([]DuplicateGroup, error) {
+	rootNode, _, err := kbfsOps.GetNodeByPath(ctx, folderBranch, "")
+	if err != nil {
+		return nil, err
+	}
+	bySize := make(map[uint64][]duplicateCandidate)
+	err = findDuplicateCandidates(ctx, kbfsOps, "", rootNode, bySize)
+	if err != nil {
+		return nil, err
+	}
+
+	hashesByID := make(map[kbfsblock.ID]string)
+	var groups []DuplicateGroup
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		byHash := make(map[string][]string)
+		for _, c := range candidates {
+			md, err := kbfsOps.GetNodeMetadata(ctx, c.node)
+			if err != nil {
+				return nil, err
+			}
+			hash, ok := hashesByID[md.BlockInfo.ID]
+			if !ok {
+				h, err := hashFileContents(ctx, kbfsOps, c.node, size)
+				if err != nil {
+					return nil, err
+				}
+				hash = string(h)
+				hashesByID[md.BlockInfo.ID] = hash
+			}
+			byHash[hash] = append(byHash[hash], c.path)
+		}
+		for _, paths := range byHash {
+			if len(paths) < 2 {
+				continue
+			}
+			sort.Strings(paths)
+			groups = append(groups, DuplicateGroup{size, paths})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Wasted() != groups[j].Wasted() {
+			return groups[i].Wasted() > groups[j].Wasted()
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups, nil
+}
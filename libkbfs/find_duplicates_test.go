@@ -0,0 +1,53 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	makeFile := func(dir Node, name, data string) Node {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte(data), 0)
+		require.NoError(t, err)
+		return n
+	}
+
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	makeFile(rootNode, "a", "short")
+	makeFile(dNode, "a", "short")
+	bNode := makeFile(rootNode, "b", "longer dup")
+	makeFile(dNode, "b", "longer dup")
+	makeFile(dNode, "c", "longer one")
+	makeFile(rootNode, "empty1", "")
+	makeFile(rootNode, "empty2", "")
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CopyFile(ctx, bNode, dNode, "bcopy")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	groups, err := FindDuplicates(ctx, kbfsOps, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, []DuplicateGroup{
+		{10, []string{"b", "d/b", "d/bcopy"}},
+		{5, []string{"a", "d/a"}},
+	}, groups)
+	require.Equal(t, uint64(20), groups[0].Wasted())
+}
@@ -118,7 +118,7 @@ type folderBlockManager struct {
 }
 
 func newFolderBlockManager(config Config, fb FolderBranch,
-	helper fbmHelper) *folderBlockManager {
+	bType branchType, helper fbmHelper) *folderBlockManager {
 	tlfStringFull := fb.Tlf.String()
 	log := config.MakeLogger(fmt.Sprintf("FBM %s", tlfStringFull[:8]))
 	fbm := &folderBlockManager{
@@ -144,7 +144,10 @@ func newFolderBlockManager(config Config, fb FolderBranch,
 
 	go fbm.archiveBlocksInBackground()
 	go fbm.deleteBlocksInBackground()
-	if fb.Branch == MasterBranch && config.Mode().QuotaReclamationEnabled() {
+	// Quota reclamation writes to the folder, which branches opened
+	// read-only can't do.
+	if fb.Branch == MasterBranch && bType != standardReadOnly &&
+		config.Mode().QuotaReclamationEnabled() {
 		go fbm.reclaimQuotaInBackground()
 	}
 	return fbm
@@ -13,6 +13,7 @@ import (
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
 
@@ -156,6 +157,70 @@ func TestQuotaReclamationUnembedded(t *testing.T) {
 	}
 }
 
+// Test that quota reclamation keeps unreferenced blocks for as long
+// as the configured unref age says, rather than the default.
+func TestQuotaReclamationConfiguredUnrefAge(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+	unrefAge := 100 * qrUnrefAgeDefault
+	config.SetQuotaReclamationMinUnrefAge(unrefAge)
+
+	rootNode := GetRootNodeOrBust(
+		ctx, t, config, userName.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	_, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+
+	bserverLocal, ok := config.BlockServer().(blockServerLocal)
+	require.True(t, ok)
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	reclaimAt := func(d time.Duration, name string) map[kbfsblock.ID]blockRefMap {
+		clock.Set(now.Add(d))
+		_, _, err := kbfsOps.CreateDir(ctx, rootNode, name)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, fb)
+		require.NoError(t, err)
+		ops.fbm.forceQuotaReclamation()
+		err = ops.fbm.waitForQuotaReclamations(ctx)
+		require.NoError(t, err)
+		blocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+		require.NoError(t, err)
+		return blocks
+	}
+	numDeleted := func(blocks map[kbfsblock.ID]blockRefMap,
+		preQRBlocks map[kbfsblock.ID]blockRefMap) (n int) {
+		for id := range preQRBlocks {
+			if _, ok := blocks[id]; !ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	preQRBlocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+
+	t.Log("Past the default unref age, nothing is reclaimed yet")
+	blocks := reclaimAt(2*qrUnrefAgeDefault, "b")
+	require.Equal(t, 0, numDeleted(blocks, preQRBlocks))
+
+	t.Log("Past the configured unref age, the old blocks go away")
+	blocks = reclaimAt(2*unrefAge, "c")
+	require.NotEqual(t, 0, numDeleted(blocks, preQRBlocks))
+}
+
 // Test that a single quota reclamation run doesn't try to reclaim too
 // much quota at once.
 func TestQuotaReclamationIncrementalReclamation(t *testing.T) {
@@ -262,13 +262,34 @@ type folderBlockOps struct {
 	// Track deferred operations on a per-file basis.
 	deferred map[BlockRef]deferredState
 
+	// tempFiles maps the refs of files made by CreateTempFile, which
+	// haven't been linked into a directory yet, to the functions
+	// that relink their nodes.  Their dirty state is kept, but
+	// they're left out of syncs until they're linked.
+	tempFiles map[BlockRef]func()
+
 	// set to true if this write or truncate should be deferred
 	doDeferWrite bool
 
+	// truncateGen counts the local truncates of files in this
+	// folder.  Reads drop blockLock while fetching blocks, so they
+	// check it to find out whether a file was truncated under them.
+	truncateGen uint64
+
 	// nodeCache itself is goroutine-safe, but write/truncate must
 	// call PathFromNode() only under blockLock (see nodeCache
 	// comments in folder_branch_ops.go).
 	nodeCache NodeCache
+
+	// readAhead fetches the blocks that follow sequential reads.
+	// It has its own locking.
+	readAhead *readAhead
+
+	// rangeLocks serializes overlapping writes to the same file,
+	// while letting writes to disjoint ranges fetch the blocks they
+	// need in parallel.  It has its own locking, and is always
+	// locked before blockLock.
+	rangeLocks *fileRangeLocks
 }
 
 // Only exported methods of folderBlockOps should be used outside of this
@@ -292,7 +313,14 @@ func (fbo *folderBlockOps) GetState(lState *lockState) overallBlockState {
 	if len(fbo.deCache) == 0 {
 		return cleanState
 	}
-	return dirtyState
+	// Unlinked temp files can stay dirty for a long time, and they
+	// aren't affected by updates to the rest of the folder.
+	for ref := range fbo.deCache {
+		if _, ok := fbo.tempFiles[ref]; !ok {
+			return dirtyState
+		}
+	}
+	return cleanState
 }
 
 // getCleanEncodedBlockHelperLocked retrieves the encoded size of the
@@ -748,6 +776,18 @@ func (fbo *folderBlockOps) GetIndirectFileBlockInfosWithTopBlock(
 	return fd.getIndirectFileBlockInfosWithTopBlock(ctx, topBlock)
 }
 
+// MatchesBlockSplitter returns whether the leaf blocks of the given
+// file all end where the configured block splitter would end them if
+// the file were written from scratch today.
+func (fbo *folderBlockOps) MatchesBlockSplitter(ctx context.Context,
+	lState *lockState, kmd KeyMetadata, file path) (bool, error) {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+	var id keybase1.UserOrTeamID // Data reads don't depend on the id.
+	fd := fbo.newFileData(lState, file, id, kmd)
+	return fd.matchesSplitter(ctx)
+}
+
 // DeepCopyFile makes a complete copy of the given file, deduping leaf
 // blocks and making new random BlockPointers for all indirect blocks.
 // It returns the new top pointer of the copy, and all the new child
@@ -957,6 +997,42 @@ func (fbo *folderBlockOps) AddDirEntryInCache(lState *lockState, dir path,
 	return fbo.wrapWithBlockLock(undoFn)
 }
 
+// LinkTempFileInCache adds an entry named `newName` to the given
+// directory in the cache, for the unlinked temp file with dir entry
+// `newDe`.  Unlike with AddDirEntryInCache, the file may already have
+// a cache entry of its own, holding the changes made to it so far,
+// which is kept.  It returns NameExistsError if the directory already
+// has a cached entry by that name.
+func (fbo *folderBlockOps) LinkTempFileInCache(lState *lockState, dir path,
+	newName string, newDe DirEntry) (dirCacheUndoFn, error) {
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+	dirEntry := fbo.deCache[dir.tailRef()]
+	if _, ok := dirEntry.adds[newName]; ok {
+		return nil, NameExistsError{newName}
+	}
+	if _, ok := dirEntry.addedSyms[newName]; ok {
+		return nil, NameExistsError{newName}
+	}
+
+	undoFn := fbo.addDirEntryInCacheLocked(lState, dir, newName, newDe)
+	cacheEntry, ok := fbo.deCache[newDe.Ref()]
+	if ok && cacheEntry.dirEntry.IsInitialized() {
+		return fbo.wrapWithBlockLock(undoFn), nil
+	}
+	cacheEntryCopy := cacheEntry.deepCopy()
+	cacheEntry.dirEntry = newDe
+	fbo.deCache[newDe.Ref()] = cacheEntry
+	return fbo.wrapWithBlockLock(func() {
+		if ok {
+			fbo.deCache[newDe.Ref()] = cacheEntryCopy
+		} else {
+			delete(fbo.deCache, newDe.Ref())
+		}
+		undoFn()
+	}), nil
+}
+
 func (fbo *folderBlockOps) removeDirEntryInCacheLocked(lState *lockState,
 	dir path, oldName string, oldDe DirEntry) func() {
 	fbo.blockLock.AssertLocked(lState)
@@ -1116,6 +1192,11 @@ func (fbo *folderBlockOps) setCachedAttrLocked(
 		fileEntry.dirEntry.Type = realEntry.Type
 	case mtimeAttr:
 		fileEntry.dirEntry.Mtime = realEntry.Mtime
+	case xattrAttr:
+		fileEntry.dirEntry.Xattrs = realEntry.Xattrs
+	case modeAttr:
+		fileEntry.dirEntry.Type = realEntry.Type
+		fileEntry.dirEntry.Mode = realEntry.Mode
 	}
 	fileEntry.dirEntry.Ctime = realEntry.Ctime
 	fbo.deCache[ref] = fileEntry
@@ -1406,26 +1487,79 @@ func (fbo *folderBlockOps) getDirtyParentAndEntryLocked(ctx context.Context,
 	de, ok := dblock.Children[name]
 	if !ok || (file.tailPointer().IsValid() &&
 		de.BlockPointer != file.tailPointer()) {
-		if includeDeleted {
-			// Has the file been removed?
-			node := fbo.nodeCache.Get(file.tailRef())
-			if node == nil {
-				return nil, DirEntry{}, NoSuchNameError{name}
-			}
-			if !fbo.nodeCache.IsUnlinked(node) {
-				return nil, DirEntry{}, NoSuchNameError{name}
-			}
-			de = fbo.nodeCache.UnlinkedDirEntry(node)
-			// It's possible the unlinked file has been updated.
-			_, de = fbo.updateDirtyEntryFromCacheLocked(ctx, lState, de)
-		} else {
+		if !includeDeleted {
 			return nil, DirEntry{}, NoSuchNameError{name}
 		}
+		de, err = fbo.getUnlinkedEntryLocked(ctx, lState, file)
+		if err != nil {
+			return nil, DirEntry{}, err
+		}
 	}
 
 	return dblock, de, err
 }
 
+// getUnlinkedEntryLocked returns the possibly-dirty DirEntry of the
+// given file, if it has been removed from its parent directory but
+// still has a node.
+func (fbo *folderBlockOps) getUnlinkedEntryLocked(ctx context.Context,
+	lState *lockState, file path) (DirEntry, error) {
+	fbo.blockLock.AssertAnyLocked(lState)
+
+	// Has the file been removed?
+	node := fbo.nodeCache.Get(file.tailRef())
+	if node == nil || !fbo.nodeCache.IsUnlinked(node) {
+		return DirEntry{}, NoSuchNameError{file.tailName()}
+	}
+	de := fbo.nodeCache.UnlinkedDirEntry(node)
+	// It's possible the unlinked file has been updated.
+	_, de = fbo.updateDirtyEntryFromCacheLocked(ctx, lState, de)
+	return de, nil
+}
+
+// getDirtyChildEntryLocked returns the possibly-dirty DirEntry for
+// the given name in dir, and whether there is one.  It applies the
+// same cached changes as updateWithDirtyEntriesLocked, but only to
+// the one entry, so that lookups (and especially misses) in large
+// directories don't have to copy and update every other entry.
+func (fbo *folderBlockOps) getDirtyChildEntryLocked(ctx context.Context,
+	lState *lockState, kmd KeyMetadata, dir path, name string) (
+	DirEntry, bool, error) {
+	fbo.blockLock.AssertAnyLocked(lState)
+
+	dblock, err := fbo.getDirLocked(ctx, lState, kmd, dir, blockLookup)
+	if err != nil {
+		return DirEntry{}, false, err
+	}
+
+	de, inBlock := dblock.Children[name]
+	if len(fbo.deCache) == 0 {
+		return de, inBlock, nil
+	}
+
+	dirCacheEntry := fbo.deCache[dir.tailRef()]
+	if inBlock && dirCacheEntry.dels[name] {
+		return DirEntry{}, false, nil
+	}
+	if symDe, ok := dirCacheEntry.addedSyms[name]; ok {
+		return symDe, true, nil
+	}
+	if ptr, ok := dirCacheEntry.adds[name]; ok {
+		addedDe, ok := fbo.deCache[ptr.Ref()]
+		if !ok {
+			return DirEntry{}, false, fmt.Errorf("No cached dir entry found "+
+				"for new entry %s in dir %s (%v)", name, dir,
+				dir.tailPointer())
+		}
+		return addedDe.dirEntry, true, nil
+	}
+	if !inBlock {
+		return DirEntry{}, false, nil
+	}
+	_, de = fbo.updateDirtyEntryFromCacheLocked(ctx, lState, de)
+	return de, true, nil
+}
+
 // GetDirtyParentAndEntry returns the parent DirBlock (which shouldn't
 // be modified) of the given file, which may contain entries pointing
 // to other dirty files, and its possibly-dirty DirEntry in that
@@ -1445,11 +1579,23 @@ func (fbo *folderBlockOps) GetDirtyParentAndEntry(
 func (fbo *folderBlockOps) getDirtyEntryLocked(ctx context.Context,
 	lState *lockState, kmd KeyMetadata, file path, includeDeleted bool) (
 	DirEntry, error) {
-	// TODO: Since we only need a single DirEntry, avoid having to
-	// look up every entry in the DirBlock.
-	_, de, err := fbo.getDirtyParentAndEntryLocked(
-		ctx, lState, kmd, file, blockLookup, includeDeleted)
-	return de, err
+	if !file.hasValidParent() {
+		return DirEntry{}, InvalidParentPathError{file}
+	}
+
+	de, ok, err := fbo.getDirtyChildEntryLocked(
+		ctx, lState, kmd, *file.parentPath(), file.tailName())
+	if err != nil {
+		return DirEntry{}, err
+	}
+	if ok && (!file.tailPointer().IsValid() ||
+		de.BlockPointer == file.tailPointer()) {
+		return de, nil
+	}
+	if !includeDeleted {
+		return DirEntry{}, NoSuchNameError{file.tailName()}
+	}
+	return fbo.getUnlinkedEntryLocked(ctx, lState, file)
 }
 
 // GetDirtyEntry returns the possibly-dirty DirEntry of the given file
@@ -1579,6 +1725,10 @@ func (fbo *folderBlockOps) GetDirtyFileBlockRefs(lState *lockState) []BlockRef {
 	defer fbo.blockLock.RUnlock(lState)
 	var dirtyRefs []BlockRef
 	for ref := range fbo.deCache {
+		// Temp files can't be synced until they're linked.
+		if _, ok := fbo.tempFiles[ref]; ok {
+			continue
+		}
 		// A file is only dirty if it's been written to (and hence
 		// caused some block unrefs) but not been synced yet.
 		if _, ok := fbo.unrefCache[ref]; ok {
@@ -1588,6 +1738,29 @@ func (fbo *folderBlockOps) GetDirtyFileBlockRefs(lState *lockState) []BlockRef {
 	return dirtyRefs
 }
 
+// AddTempFile marks the file with the given ref as an unlinked temp
+// file, which isn't synced until RemoveTempFile is called for it.
+// `relinkFn` must relink the file's node in the node cache.
+func (fbo *folderBlockOps) AddTempFile(
+	lState *lockState, ref BlockRef, relinkFn func()) {
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+	fbo.tempFiles[ref] = relinkFn
+}
+
+// RemoveTempFile unmarks the temp file with the given ref, so that it
+// gets synced once it's linked into a directory, and returns the
+// function that relinks its node.  It returns false if the file isn't
+// an unlinked temp file.
+func (fbo *folderBlockOps) RemoveTempFile(
+	lState *lockState, ref BlockRef) (relinkFn func(), ok bool) {
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+	relinkFn, ok = fbo.tempFiles[ref]
+	delete(fbo.tempFiles, ref)
+	return relinkFn, ok
+}
+
 // GetDirtyDirBlockRefs returns a list of references of all known dirty
 // directories.
 func (fbo *folderBlockOps) GetDirtyDirBlockRefs(lState *lockState) []BlockRef {
@@ -1805,12 +1978,130 @@ func (fbo *folderBlockOps) Read(
 	fbo.blockLock.RLock(lState)
 	defer fbo.blockLock.RUnlock(lState)
 
-	filePath := fbo.nodeCache.PathFromNode(file)
+	for {
+		filePath := fbo.nodeCache.PathFromNode(file)
+		truncateGen := fbo.truncateGen
+
+		fbo.log.CDebugf(ctx, "Reading from %v", filePath.tailPointer())
+
+		var id keybase1.UserOrTeamID // Data reads don't depend on the id.
+		fd := fbo.newFileData(lState, filePath, id, kmd)
+		n, err := fd.read(ctx, dest, off)
+		if err == nil && fbo.fileChangedDuringReadLocked(
+			lState, file, filePath, truncateGen) {
+			// blockLock was dropped while fetching blocks, and the
+			// file was truncated or replaced in the meantime, so
+			// `dest` might mix the old blocks with the new ones,
+			// or hold data past the new end of the file.  Read
+			// again from the file as it is now.
+			fbo.log.CDebugf(ctx, "File %v changed during the read; "+
+				"reading again", filePath.tailPointer())
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// Dirty files have blocks that aren't on the server yet, so
+		// there's nothing to read ahead for them.
+		if _, isDirty := fbo.dirtyFiles[filePath.tailPointer()]; !isDirty {
+			fbo.readAhead.afterRead(ctx, fd, off, n, err)
+		}
+		return n, err
+	}
+}
+
+// fileChangedDuringReadLocked returns whether `file`, which was at
+// `filePath` when a read of it started, was truncated locally or
+// changed by a remote update or a sync since then.
+func (fbo *folderBlockOps) fileChangedDuringReadLocked(
+	lState *lockState, file Node, filePath path, truncateGen uint64) bool {
+	fbo.blockLock.AssertRLocked(lState)
+	if fbo.truncateGen != truncateGen {
+		return true
+	}
+	currPath := fbo.nodeCache.PathFromNode(file)
+	return currPath.isValid() &&
+		currPath.tailPointer() != filePath.tailPointer()
+}
+
+// getCleanBlock retrieves the synced version of the block pointed to
+// by ptr, either from the block cache or from the server, ignoring
+// any dirty version of it.  Since it never looks at dirty state, it
+// doesn't need blockLock.
+//
+// p is used only when reporting errors, and can be empty.
+func (fbo *folderBlockOps) getCleanBlock(ctx context.Context,
+	kmd KeyMetadata, ptr BlockPointer, newBlock makeNewBlock, p path) (
+	Block, error) {
+	if !ptr.IsValid() {
+		return nil, InvalidBlockRefError{ptr.Ref()}
+	}
+
+	if block, err := fbo.config.BlockCache().Get(ptr); err == nil {
+		return block, nil
+	}
+
+	if err := checkDataVersion(fbo.config, p, ptr); err != nil {
+		return nil, err
+	}
+
+	block := newBlock()
+	err := fbo.config.BlockOps().Get(ctx, kmd, ptr, block, TransientEntry)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// GetCleanDirBlock retrieves the synced version of the directory
+// block pointed to by ptr, as of the given MD, ignoring any local
+// changes to it that haven't been synced yet.
+//
+// p is used only when reporting errors, and can be empty.
+func (fbo *folderBlockOps) GetCleanDirBlock(ctx context.Context,
+	kmd KeyMetadata, ptr BlockPointer, p path) (*DirBlock, error) {
+	block, err := fbo.getCleanBlock(ctx, kmd, ptr, NewDirBlock, p)
+	if err != nil {
+		return nil, err
+	}
+
+	dblock, ok := block.(*DirBlock)
+	if !ok {
+		return nil, NotDirBlockError{ptr, p.Branch, p}
+	}
+	return dblock, nil
+}
 
-	fbo.log.CDebugf(ctx, "Reading from %v", filePath.tailPointer())
+// ReadClean reads from the synced version of the given file, as of
+// the given MD, into the given buffer at the given offset.  Unlike
+// Read, it ignores any writes to the file that haven't been synced
+// yet, so it can be used to read the contents of the file at an
+// older revision.  It returns the number of bytes read and nil, or 0
+// and the error if there was one.
+func (fbo *folderBlockOps) ReadClean(ctx context.Context,
+	kmd KeyMetadata, file path, dest []byte, off int64) (int64, error) {
+	fbo.log.CDebugf(ctx, "Reading clean data from %v", file.tailPointer())
 
 	var id keybase1.UserOrTeamID // Data reads don't depend on the id.
-	fd := fbo.newFileData(lState, filePath, id, kmd)
+	fd := newFileData(file, id, fbo.config.Crypto(),
+		fbo.config.BlockSplitter(), kmd,
+		func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
+			file path, rtype blockReqType) (*FileBlock, bool, error) {
+			block, err := fbo.getCleanBlock(ctx, kmd, ptr, NewFileBlock, file)
+			if err != nil {
+				return nil, false, err
+			}
+			fblock, ok := block.(*FileBlock)
+			if !ok {
+				return nil, false, NotFileBlockError{ptr, file.Branch, file}
+			}
+			return fblock, false, nil
+		},
+		func(ptr BlockPointer, block Block) error {
+			// Reads never dirty any blocks.
+			return nil
+		}, fbo.log)
 	return fd.read(ctx, dest, off)
 }
 
@@ -2015,25 +2306,46 @@ func (fbo *folderBlockOps) writeDataLocked(
 	return latestWrite, dirtyPtrs, newlyDirtiedChildBytes, nil
 }
 
-// Write writes the given data to the given file. May block if there
-// is too much unflushed data; in that case, it will be unblocked by a
+// Write writes the given data to the given file, and returns the
+// offset it was written at, which is the end of the file if `flags`
+// includes WriteAppend, and `off` otherwise.  May block if there is
+// too much unflushed data; in that case, it will be unblocked by a
 // future sync.
 func (fbo *folderBlockOps) Write(
 	ctx context.Context, lState *lockState, kmd KeyMetadata,
-	file Node, data []byte, off int64) error {
+	file Node, data []byte, off int64, flags WriteFlags) (int64, error) {
 	// If there is too much unflushed data, we should wait until some
 	// of it gets flush so our memory usage doesn't grow without
 	// bound.
 	c, err := fbo.config.DirtyBlockCache().RequestPermissionToDirty(ctx,
 		fbo.id(), int64(len(data)))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer fbo.config.DirtyBlockCache().UpdateUnsyncedBytes(fbo.id(),
 		-int64(len(data)), false)
+	isAppend := flags&WriteAppend != 0
+	lockOff, lockEnd := off, off+int64(len(data))
+	if isAppend {
+		// The end of the file isn't known until `blockLock` is
+		// taken, so keep every other writer out of the file until
+		// the append is done.
+		lockOff, lockEnd = 0, -1
+	}
+	unlock, err := fbo.rangeLocks.lockRange(
+		ctx, file.GetID(), lockOff, lockEnd)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
 	err = fbo.maybeWaitOnDeferredWrites(ctx, lState, file, c)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if !isAppend {
+		fbo.fetchBlocksForWrite(
+			ctx, lState, kmd, file, off, int64(len(data)))
 	}
 
 	fbo.blockLock.Lock(lState)
@@ -2041,7 +2353,21 @@ func (fbo *folderBlockOps) Write(
 
 	filePath, err := fbo.pathFromNodeForBlockWriteLocked(lState, file)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if isAppend {
+		// Look up the size under the same lock as the write itself,
+		// so no other local write can move the end of the file in
+		// between.
+		de, err := fbo.getDirtyEntryLocked(ctx, lState, kmd, filePath, true)
+		if err != nil {
+			return 0, err
+		}
+		if de.Size >= uint64(1<<63) {
+			return 0, errors.New("offset too large")
+		}
+		off = int64(de.Size)
 	}
 
 	defer func() {
@@ -2051,7 +2377,7 @@ func (fbo *folderBlockOps) Write(
 	latestWrite, dirtyPtrs, newlyDirtiedChildBytes, err := fbo.writeDataLocked(
 		ctx, lState, kmd, filePath, data, off)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	fbo.observers.localChange(ctx, file, latestWrite)
@@ -2088,7 +2414,42 @@ func (fbo *folderBlockOps) Write(
 		fbo.deferred[filePath.tailRef()] = ds
 	}
 
-	return nil
+	return off, nil
+}
+
+// fetchBlocksForWrite fetches the existing leaf blocks of `file` that
+// a write of `size` bytes at `off` is about to modify, into the block
+// cache.  It only needs `blockLock` for reading, so writers of
+// disjoint ranges of the same file fetch their blocks in parallel,
+// rather than one at a time under the write lock.  Errors are
+// ignored, since the write itself will fetch any block that didn't
+// make it into the cache, and report the error then.
+func (fbo *folderBlockOps) fetchBlocksForWrite(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	file Node, off, size int64) {
+	if size == 0 {
+		return
+	}
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+
+	filePath := fbo.nodeCache.PathFromNode(file)
+	if !filePath.isValid() {
+		return
+	}
+	var id keybase1.UserOrTeamID // Data reads don't depend on the id.
+	fd := fbo.newFileData(lState, filePath, id, kmd)
+	topBlock, _, err := fd.getter(
+		ctx, kmd, fd.rootBlockPointer(), filePath, blockRead)
+	if err != nil || !topBlock.IsInd {
+		return
+	}
+	_, _, _, err = fd.getLeafBlocksForOffsetRange(
+		ctx, fd.rootBlockPointer(), topBlock, off, off+size, false)
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't fetch blocks for write to %v: %+v",
+			filePath.tailPointer(), err)
+	}
 }
 
 // truncateExtendLocked is called by truncateLocked to extend a file and
@@ -2157,6 +2518,11 @@ func (fbo *folderBlockOps) truncateLocked(
 		defer jServer.dirtyOpEnd(fbo.id())
 	}
 
+	// Let any reads that dropped blockLock to fetch a block know
+	// that they may have fetched blocks that are no longer part of
+	// the file.
+	fbo.truncateGen++
+
 	fblock, err := fbo.writeGetFileLocked(ctx, lState, kmd, file)
 	if err != nil {
 		return &WriteRange{}, nil, 0, err
@@ -2235,6 +2601,24 @@ func (fbo *folderBlockOps) truncateLocked(
 	return &latestWrite, dirtyPtrs, newlyDirtiedChildBytes, nil
 }
 
+// cacheTopFileBlock makes sure the top block of `file` is cached,
+// fetching it if needed.  It only r-locks blockLock, so the lock is
+// dropped while waiting on the network; once blockLock is locked for
+// writing, a fetch would hold up every other user of this folder.
+func (fbo *folderBlockOps) cacheTopFileBlock(ctx context.Context,
+	lState *lockState, kmd KeyMetadata, file Node) error {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+	filePath := fbo.nodeCache.PathFromNode(file)
+	if !filePath.isValid() {
+		// Let the caller report the error.
+		return nil
+	}
+	_, _, err := fbo.getFileBlockLocked(
+		ctx, lState, kmd, filePath.tailPointer(), filePath, blockRead)
+	return err
+}
+
 // Truncate truncates or extends the given file to the given size.
 // May block if there is too much unflushed data; in that case, it
 // will be unblocked by a future sync.
@@ -2255,10 +2639,21 @@ func (fbo *folderBlockOps) Truncate(
 	}
 	defer fbo.config.DirtyBlockCache().UpdateUnsyncedBytes(fbo.id(),
 		-int64(size), false)
+	// A truncate can move the end of the file in either direction,
+	// so it conflicts with every write.
+	unlock, err := fbo.rangeLocks.lockRange(ctx, file.GetID(), 0, -1)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	err = fbo.maybeWaitOnDeferredWrites(ctx, lState, file, c)
 	if err != nil {
 		return err
 	}
+	err = fbo.cacheTopFileBlock(ctx, lState, kmd, file)
+	if err != nil {
+		return err
+	}
 
 	fbo.blockLock.Lock(lState)
 	defer fbo.blockLock.Unlock(lState)
@@ -2454,6 +2849,20 @@ func ReadyBlock(ctx context.Context, bcache BlockCache, bops BlockOps,
 			DirectType: directType,
 			Context:    kbfsblock.MakeFirstContext(chargedTo, bType),
 		}
+		if readyBlockData.compressed {
+			// Older clients can't decompress the block, so they
+			// need to know to refuse it.
+			ptr.DataVer = CompressedBlocksDataVer
+		}
+		if readyBlockData.convergent {
+			// An identical block may already be on the server, so
+			// this must be a reference of its own; putBlockToServer
+			// uploads the block if it turns out not to be there.
+			ptr.RefNonce, err = crypto.MakeBlockRefNonce()
+			if err != nil {
+				return
+			}
+		}
 	}
 
 	info = BlockInfo{
@@ -3320,7 +3729,7 @@ func (fbo *folderBlockOps) UpdateCachedEntryAttributes(
 	}
 
 	if cleanEntry != nil {
-		fbo.setCachedAttr(lState, op.File.Ref(), op.Attr, cleanEntry, false)
+		fbo.setCachedAttr(lState, op.File.Ref(), op.getAttr(), cleanEntry, false)
 	}
 
 	return childNode, nil
@@ -3333,7 +3742,7 @@ func (fbo *folderBlockOps) UpdateCachedEntryAttributes(
 // file handle, which will clear out the entry.
 func (fbo *folderBlockOps) UpdateCachedEntryAttributesOnRemovedFile(
 	ctx context.Context, lState *lockState, op *setAttrOp, de DirEntry) {
-	fbo.setCachedAttr(lState, de.Ref(), op.Attr, &de, true)
+	fbo.setCachedAttr(lState, de.Ref(), op.getAttr(), &de, true)
 }
 
 func (fbo *folderBlockOps) getDeferredWriteCountForTest(lState *lockState) int {
@@ -5,13 +5,17 @@
 package libkbfs
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
+	stdpath "path"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/backoff"
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/chat1"
@@ -52,10 +56,11 @@ const (
 type branchType int
 
 const (
-	standard       branchType = iota // an online, read-write branch
-	archive                          // an online, read-only branch
-	offline                          // an offline, read-write branch
-	archiveOffline                   // an offline, read-only branch
+	standard         branchType = iota // an online, read-write branch
+	archive                            // an online, read-only branch
+	offline                            // an offline, read-write branch
+	archiveOffline                     // an offline, read-only branch
+	standardReadOnly                   // an online, read-only branch that gets updates
 )
 
 // Constants used in this file.  TODO: Make these configurable?
@@ -94,6 +99,11 @@ const (
 	// If there are more than this many new revisions, fast forward
 	// rather than downloading them all.
 	fastForwardRevThresh = 50
+	// How often to check whether downloads have been re-enabled,
+	// while holding back remote updates in upload-only mode.
+	networkModeRecheckInterval = 10 * time.Second
+	// On a metered network, fetch remote updates at most this often.
+	meteredUpdateInterval = 5 * time.Minute
 )
 
 type fboMutexLevel mutexLevel
@@ -252,7 +262,7 @@ type folderBranchOps struct {
 	dirOps       []cachedDirOp
 
 	// protects access to head, headStatus, latestMergedRevision,
-	// and hasBeenCleared.
+	// hasBeenCleared, remoteChangeRev and remoteChangeDone.
 	headLock   leveledRWMutex
 	head       ImmutableRootMetadata
 	headStatus headTrustStatus
@@ -260,6 +270,13 @@ type folderBranchOps struct {
 	latestMergedRevision kbfsmd.Revision
 	// Has this folder ever been cleared?
 	hasBeenCleared bool
+	// remoteChangeRev is the latest remote revision that couldn't be
+	// applied because of unsynced local changes.
+	remoteChangeRev kbfsmd.Revision
+	// remoteChangeDone, if non-nil, is closed once remoteChangeRev
+	// has been merged; local writes wait on it under the
+	// RemoteChangeNotifyAndBlock policy.
+	remoteChangeDone chan struct{}
 
 	blocks  folderBlockOps
 	prepper folderUpdatePrepper
@@ -346,8 +363,26 @@ type folderBranchOps struct {
 	// disconnection.
 	lastGetHead time.Time
 
+	muLastUsed sync.Mutex
+	// lastUsed is when KBFSOpsStandard last handed out this FBO, and
+	// uses is how many times it has done so, so that idle FBOs can
+	// be found and evicted.
+	lastUsed time.Time
+	uses     uint64
+
 	convLock sync.Mutex
 	convID   chat1.ConversationID
+
+	// usageCache maps the root pointer of each recently-measured
+	// subtree to its SubtreeUsage.
+	usageCache *lru.Cache
+	// usagesToCache are the usages of the top-level entries most
+	// recently measured by GetUsageBreakdown, to be cached in the
+	// next MD written, if any.
+	usageLock     sync.Mutex
+	usagesToCache []CachedSubtreeUsage
+
+	advisoryLocks *advisoryLockManager
 }
 
 var _ KBFSOps = (*folderBranchOps)(nil)
@@ -378,7 +413,7 @@ func newFolderBranchOps(ctx context.Context, config Config, fb FolderBranch,
 	// But print it out once in full, just in case.
 	log.CInfof(ctx, "Created new folder-branch for %s", tlfStringFull)
 
-	observers := newObserverList()
+	observers := newObserverList(nodeCache)
 
 	mdWriterLock := makeLeveledMutex(mutexLevel(fboMDWriter), &sync.Mutex{})
 	headLock := makeLeveledRWMutex(mutexLevel(fboHead), &sync.RWMutex{})
@@ -408,7 +443,10 @@ func newFolderBranchOps(ctx context.Context, config Config, fb FolderBranch,
 			deferred:   make(map[BlockRef]deferredState),
 			unrefCache: make(map[BlockRef]*syncInfo),
 			deCache:    make(map[BlockRef]deCacheEntry),
+			tempFiles:  make(map[BlockRef]func()),
 			nodeCache:  nodeCache,
+			readAhead:  newReadAhead(config, log),
+			rangeLocks: newFileRangeLocks(),
 		},
 		nodeCache:       nodeCache,
 		log:             traceLogger{log},
@@ -425,10 +463,12 @@ func newFolderBranchOps(ctx context.Context, config Config, fb FolderBranch,
 		log:          log,
 	}
 	fbo.cr = NewConflictResolver(config, fbo)
-	fbo.fbm = newFolderBlockManager(config, fb, fbo)
+	fbo.fbm = newFolderBlockManager(config, fb, bType, fbo)
 	fbo.editHistory = NewTlfEditHistory(config, fbo, log)
 	fbo.rekeyFSM = NewRekeyFSM(fbo)
-	if config.DoBackgroundFlushes() {
+	fbo.usageCache, _ = lru.New(subtreeUsageCacheSize)
+	fbo.advisoryLocks = newAdvisoryLockManager(config, fb, log)
+	if config.DoBackgroundFlushes() && !fbo.isOpenedReadOnly() {
 		go fbo.backgroundFlusher()
 	}
 
@@ -452,7 +492,10 @@ func (fbo *folderBranchOps) Shutdown(ctx context.Context) error {
 	if fbo.config.CheckStateOnShutdown() {
 		lState := makeFBOLockState()
 
-		if fbo.blocks.GetState(lState) == dirtyState {
+		if fbo.isArchived() {
+			fbo.log.CDebugf(ctx, "Skipping state-checking for an archived "+
+				"branch")
+		} else if fbo.blocks.GetState(lState) == dirtyState {
 			fbo.log.CDebugf(ctx, "Skipping state-checking due to dirty state")
 		} else if !fbo.isMasterBranch(lState) {
 			fbo.log.CDebugf(ctx, "Skipping state-checking due to being staged")
@@ -471,18 +514,26 @@ func (fbo *folderBranchOps) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	fbo.shutdown(ctx)
+	return nil
+}
+
+// shutdown stops all of this fbo's background work, without checking
+// its state first.
+func (fbo *folderBranchOps) shutdown(ctx context.Context) {
 	close(fbo.shutdownChan)
+	fbo.blocks.readAhead.shutdown()
 	fbo.merkleFetches.Wait(ctx)
 	fbo.cr.Shutdown()
 	fbo.fbm.shutdown()
 	fbo.editHistory.Shutdown()
 	fbo.rekeyFSM.Shutdown()
+	fbo.advisoryLocks.shutdown(ctx)
 	// Wait for the update goroutine to finish, so that we don't have
 	// any races with logging during test reporting.
 	if fbo.updateDoneChan != nil {
 		<-fbo.updateDoneChan
 	}
-	return nil
 }
 
 func (fbo *folderBranchOps) id() tlf.ID {
@@ -493,6 +544,18 @@ func (fbo *folderBranchOps) branch() BranchName {
 	return fbo.folderBranch.Branch
 }
 
+// isArchived returns true if this folderBranchOps shows its folder
+// frozen at some past revision, and so never changes.
+func (fbo *folderBranchOps) isArchived() bool {
+	return fbo.bType == archive
+}
+
+// isOpenedReadOnly returns true if this folderBranchOps follows the
+// changes made to its folder by others, but can't make any itself.
+func (fbo *folderBranchOps) isOpenedReadOnly() bool {
+	return fbo.bType == standardReadOnly
+}
+
 func (fbo *folderBranchOps) GetFavorites(ctx context.Context) (
 	[]Favorite, error) {
 	return nil, errors.New("GetFavorites is not supported by folderBranchOps")
@@ -580,6 +643,33 @@ func (fbo *folderBranchOps) updateLastGetHeadTimestamp() {
 	fbo.lastGetHead = fbo.config.Clock().Now()
 }
 
+func (fbo *folderBranchOps) markUsed() {
+	fbo.muLastUsed.Lock()
+	defer fbo.muLastUsed.Unlock()
+	fbo.lastUsed = fbo.config.Clock().Now()
+	fbo.uses++
+}
+
+func (fbo *folderBranchOps) getLastUsed() (lastUsed time.Time, uses uint64) {
+	fbo.muLastUsed.Lock()
+	defer fbo.muLastUsed.Unlock()
+	return fbo.lastUsed, fbo.uses
+}
+
+// isIdle returns whether nothing depends on this FBO staying up: no
+// nodes are in use, there are no dirty or unmerged changes, and no
+// observers are registered other than KBFSOps' own favorite
+// observer.
+func (fbo *folderBranchOps) isIdle(lState *lockState) bool {
+	return fbo.nodeCache.NumNodes() == 0 &&
+		fbo.blocks.GetState(lState) == cleanState &&
+		fbo.isMasterBranch(lState) &&
+		fbo.observers.empty(func(obs Observer) bool {
+			_, ok := obs.(*kbfsOpsFavoriteObserver)
+			return ok
+		})
+}
+
 // getTrustedHead should not be called outside of folder_branch_ops.go.
 // Returns ImmutableRootMetadata{} when the head is not trusted.
 // See the comment on headTrustedStatus for more information.
@@ -802,6 +892,13 @@ func (fbo *folderBranchOps) setHeadLocked(
 		fbo.headStatus = headTrusted
 	}
 	fbo.status.setRootMetadata(md)
+	fbo.maybeUnblockRemoteChangeLocked(ctx, lState, md)
+	if !isFirstHead && md.MergedStatus() == kbfsmd.Merged &&
+		fbo.config.IsSyncedTlf(fbo.id()) {
+		// Keep a synced folder's latest revision fully on disk as the
+		// head advances.  Only the blocks that changed need fetching.
+		fbo.kickOffRootBlockFetch(ctx, md)
+	}
 	if isFirstHead {
 		// Start registering for updates right away, using this MD
 		// as a starting point. For now only the master branch can
@@ -814,8 +911,10 @@ func (fbo *folderBranchOps) setHeadLocked(
 		// If journaling is enabled, we should make sure to enable it
 		// for this TLF.  That's because we may have received the TLF
 		// ID from the service, rather than via a GetIDForHandle call,
-		// and so we might have skipped the journal.
-		if jServer, err := GetJournalServer(fbo.config); err == nil {
+		// and so we might have skipped the journal.  A folder opened
+		// read-only has nothing to journal.
+		if jServer, err := GetJournalServer(fbo.config); err == nil &&
+			!fbo.isOpenedReadOnly() {
 			_, _ = jServer.getTLFJournal(fbo.id(), md.GetTlfHandle())
 		}
 	}
@@ -1086,6 +1185,24 @@ func (fbo *folderBranchOps) getMDForWriteOrRekeyLocked(
 	// TODO: Make tests not take this code path.
 	fbo.mdWriterLock.AssertLocked(lState)
 
+	if fbo.isArchived() {
+		md, err = fbo.getArchivedMD(ctx)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+
+		fbo.headLock.Lock(lState)
+		defer fbo.headLock.Unlock(lState)
+		if fbo.head != (ImmutableRootMetadata{}) {
+			return fbo.head, nil
+		}
+		err = fbo.setHeadLocked(ctx, lState, md, headTrusted)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+		return md, nil
+	}
+
 	// Not in cache, fetch from server and add to cache.  First, see
 	// if this device has any unmerged commits -- take the latest one.
 	mdops := fbo.config.MDOps()
@@ -1143,6 +1260,65 @@ func (fbo *folderBranchOps) getMDForWriteOrRekeyLocked(
 	return md, nil
 }
 
+// getArchivedMD fetches the MD that an archived branch is frozen at,
+// as specified by its name.
+func (fbo *folderBranchOps) getArchivedMD(ctx context.Context) (
+	ImmutableRootMetadata, error) {
+	if rev, ok := fbo.branch().RevisionIfSpecified(); ok {
+		return getSingleMD(ctx, fbo.config, fbo.id(), kbfsmd.NullBranchID,
+			rev, kbfsmd.Merged, nil)
+	}
+	if t, ok := fbo.branch().TimeIfSpecified(); ok {
+		return fbo.getMDAtTime(ctx, t)
+	}
+	return ImmutableRootMetadata{}, InvalidBranchNameError{fbo.branch()}
+}
+
+// getMDAtTime returns the latest merged MD that was made at or
+// before the given time, according to the server's timestamps.  If
+// the folder didn't have any revisions yet at that time, it returns
+// NoMergedMDError.
+func (fbo *folderBranchOps) getMDAtTime(
+	ctx context.Context, t time.Time) (ImmutableRootMetadata, error) {
+	head, err := fbo.config.MDOps().GetForTLF(ctx, fbo.id(), nil)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return ImmutableRootMetadata{},
+			errors.WithStack(NoMergedMDError{fbo.id()})
+	}
+	if !head.localTimestamp.After(t) {
+		return head, nil
+	}
+
+	// Timestamps only increase along with the revisions, so binary
+	// search for the first revision after t.  The one before it is
+	// the one we want.
+	start, end := kbfsmd.RevisionInitial, head.Revision()
+	for start < end {
+		mid := start + (end-start)/2
+		rmd, err := getSingleMD(ctx, fbo.config, fbo.id(),
+			kbfsmd.NullBranchID, mid, kbfsmd.Merged, nil)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+		if rmd.localTimestamp.After(t) {
+			end = mid
+		} else {
+			start = mid + 1
+		}
+	}
+	if start == kbfsmd.RevisionInitial {
+		return ImmutableRootMetadata{},
+			errors.WithStack(NoMergedMDError{fbo.id()})
+	}
+	fbo.log.CDebugf(ctx, "Revision %d is the latest one as of %s",
+		start-1, t)
+	return getSingleMD(ctx, fbo.config, fbo.id(), kbfsmd.NullBranchID,
+		start-1, kbfsmd.Merged, nil)
+}
+
 func (fbo *folderBranchOps) getMDForReadHelper(
 	ctx context.Context, lState *lockState, rtype mdReadType) (ImmutableRootMetadata, error) {
 	md, err := fbo.getMDForRead(ctx, lState, rtype)
@@ -1254,6 +1430,15 @@ func (fbo *folderBranchOps) getMDForWriteLockedForFilename(
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
+	if fbo.isOpenedReadOnly() {
+		if filename == "" {
+			filename = md.GetTlfHandle().GetCanonicalPath()
+		}
+		return ImmutableRootMetadata{}, ReadOnlyError{filename}
+	}
+	if err := md.data.checkWritable(md.TlfID()); err != nil {
+		return ImmutableRootMetadata{}, err
+	}
 
 	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
 	if err != nil {
@@ -1310,6 +1495,9 @@ func (fbo *folderBranchOps) getMDForRekeyWriteLocked(
 	if err != nil {
 		return nil, kbfscrypto.VerifyingKey{}, false, err
 	}
+	if err := md.data.checkWritable(md.TlfID()); err != nil {
+		return nil, kbfscrypto.VerifyingKey{}, false, err
+	}
 
 	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
 	if err != nil {
@@ -1608,7 +1796,7 @@ func (fbo *folderBranchOps) checkNodeForWrite(
 	if err != nil {
 		return err
 	}
-	if !node.Readonly(ctx) {
+	if !node.Readonly(ctx) && !fbo.isArchived() && !fbo.isOpenedReadOnly() {
 		return nil
 	}
 
@@ -1617,9 +1805,35 @@ func (fbo *folderBranchOps) checkNodeForWrite(
 	if err != nil {
 		return err
 	}
+	if fbo.isOpenedReadOnly() {
+		return ReadOnlyError{p.String()}
+	}
 	return WriteToReadonlyNodeError{p.String()}
 }
 
+// kickOffRootBlockFetch requests the root block of `md`, to start
+// prefetching the blocks below it.  For a synced folder, that fetches
+// and pins every block of the revision that isn't already in the sync
+// cache.  It returns false if `md` is unreadable, or if prefetching
+// is disabled.
+func (fbo *folderBranchOps) kickOffRootBlockFetch(
+	ctx context.Context, md ImmutableRootMetadata) bool {
+	if !md.IsReadable() || fbo.config.Mode().PrefetchWorkers() == 0 {
+		return false
+	}
+	// We `Get` the root block to ensure downstream prefetches occur.
+	// Use a fresh context, in case `ctx` is canceled by the caller
+	// before we complete.
+	prefetchCtx := fbo.ctxWithFBOID(context.Background())
+	fbo.log.CDebugf(ctx,
+		"Prefetching root block with a new context: FBOID=%s",
+		prefetchCtx.Value(CtxFBOIDKey))
+	_ = fbo.config.BlockOps().BlockRetriever().Request(prefetchCtx,
+		defaultOnDemandRequestPriority, md, md.data.Dir.BlockPointer,
+		&DirBlock{}, TransientEntry)
+	return true
+}
+
 // SetInitialHeadFromServer sets the head to the given
 // ImmutableRootMetadata, which must be retrieved from the MD server.
 func (fbo *folderBranchOps) SetInitialHeadFromServer(
@@ -1632,18 +1846,7 @@ func (fbo *folderBranchOps) SetInitialHeadFromServer(
 			md.Revision(), md.MergedStatus(), err)
 	}()
 
-	if md.IsReadable() && fbo.config.Mode().PrefetchWorkers() > 0 {
-		// We `Get` the root block to ensure downstream prefetches
-		// occur.  Use a fresh context, in case `ctx` is canceled by
-		// the caller before we complete.
-		prefetchCtx := fbo.ctxWithFBOID(context.Background())
-		fbo.log.CDebugf(ctx,
-			"Prefetching root block with a new context: FBOID=%s",
-			prefetchCtx.Value(CtxFBOIDKey))
-		_ = fbo.config.BlockOps().BlockRetriever().Request(prefetchCtx,
-			defaultOnDemandRequestPriority, md, md.data.Dir.BlockPointer,
-			&DirBlock{}, TransientEntry)
-	} else {
+	if !fbo.kickOffRootBlockFetch(ctx, md) {
 		fbo.log.CDebugf(ctx,
 			"Setting an unreadable head with revision=%d", md.Revision())
 	}
@@ -1729,6 +1932,10 @@ func (fbo *folderBranchOps) SetInitialHeadToNew(
 			id, err)
 	}()
 
+	if fbo.isOpenedReadOnly() {
+		return ReadOnlyError{handle.GetCanonicalPath()}
+	}
+
 	rmd, err := makeInitialRootMetadata(
 		fbo.config.MetadataVersion(), id, handle)
 	if err != nil {
@@ -1861,6 +2068,7 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "GetDirChildren %s done, %d entries: %+v",
 			getNodeIDStr(dir), len(children), err)
+		fbo.recordSignificantErr(err)
 	}()
 
 	err = fbo.checkNode(dir)
@@ -1957,10 +2165,12 @@ func (fbo *folderBranchOps) lookup(ctx context.Context, dir Node, name string) (
 
 func (fbo *folderBranchOps) Lookup(ctx context.Context, dir Node, name string) (
 	node Node, ei EntryInfo, err error) {
-	fbo.log.CDebugf(ctx, "Lookup %s %s", getNodeIDStr(dir), name)
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "Lookup %s %s", getNodeIDStr(dir), logName)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "Lookup %s %s done: %v %+v",
-			getNodeIDStr(dir), name, getNodeIDStr(node), err)
+			getNodeIDStr(dir), logName, getNodeIDStr(node), err)
+		fbo.recordSignificantErr(err)
 	}()
 
 	err = fbo.checkNode(dir)
@@ -2063,6 +2273,7 @@ func (fbo *folderBranchOps) Stat(ctx context.Context, node Node) (
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "Stat %s done: %+v",
 			getNodeIDStr(node), err)
+		fbo.recordSignificantErr(err)
 	}()
 
 	var de DirEntry
@@ -2076,6 +2287,104 @@ func (fbo *folderBranchOps) Stat(ctx context.Context, node Node) (
 	return de.EntryInfo, nil
 }
 
+// splitPathInTlf returns the names making up p, a slash-separated
+// path relative to a TLF root.
+func splitPathInTlf(p string) []string {
+	p = stdpath.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+// followSymlinkInTlf returns the names making up the path that the
+// symlink with the given target, in the directory named by dirNames,
+// points to.  The symlink may not lead outside of the TLF.
+func followSymlinkInTlf(dirNames []string, target string) (
+	[]string, error) {
+	linkPath := stdpath.Join(dirNames...)
+	if stdpath.IsAbs(target) {
+		return nil, UnfollowableSymlinkError{linkPath, target}
+	}
+	newPath := stdpath.Clean(stdpath.Join(linkPath, target))
+	if newPath == ".." || strings.HasPrefix(newPath, "../") {
+		return nil, UnfollowableSymlinkError{linkPath, target}
+	}
+	return splitPathInTlf(newPath), nil
+}
+
+// GetNodeByPath implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) GetNodeByPath(
+	ctx context.Context, folderBranch FolderBranch, p string) (
+	node Node, ei EntryInfo, err error) {
+	logPath := fbo.config.RedactLogPath(p)
+	fbo.log.CDebugf(ctx, "GetNodeByPath %s", logPath)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetNodeByPath %s done: %v %+v",
+			logPath, getNodeIDStr(node), err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, EntryInfo{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	rootNode, rootEI, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	maxLevels := fbo.config.MaxSymlinkLevels()
+	names := splitPathInTlf(p)
+	levels := 0
+outer:
+	for {
+		node, ei = rootNode, rootEI
+		for i, name := range names {
+			if ei.Type != Dir {
+				nodePath, err := fbo.pathFromNodeForRead(node)
+				if err != nil {
+					return nil, EntryInfo{}, err
+				}
+				return nil, EntryInfo{}, NotDirError{nodePath}
+			}
+
+			child, childEI, err := fbo.Lookup(ctx, node, name)
+			if err != nil {
+				return nil, EntryInfo{}, err
+			}
+			if childEI.Type != Sym {
+				node, ei = child, childEI
+				continue
+			}
+
+			// Start over from the root with the path the symlink
+			// points to, plus whatever is left of the original.
+			if levels == maxLevels {
+				return nil, EntryInfo{}, TooManySymlinksError{p, maxLevels}
+			}
+			levels++
+			fbo.log.CDebugf(ctx, "Following symlink %s to %s",
+				fbo.config.RedactLogPath(name),
+				fbo.config.RedactLogPath(childEI.SymPath))
+			newNames, err := followSymlinkInTlf(names[:i], childEI.SymPath)
+			if err != nil {
+				return nil, EntryInfo{}, err
+			}
+			names = append(newNames, names[i+1:]...)
+			continue outer
+		}
+		return node, ei, nil
+	}
+}
+
+// StatByPath implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) StatByPath(
+	ctx context.Context, folderBranch FolderBranch, p string) (
+	EntryInfo, error) {
+	_, ei, err := fbo.GetNodeByPath(ctx, folderBranch, p)
+	return ei, err
+}
+
 func (fbo *folderBranchOps) GetNodeMetadata(ctx context.Context, node Node) (
 	res NodeMetadata, err error) {
 	fbo.log.CDebugf(ctx, "GetNodeMetadata %s", getNodeIDStr(node))
@@ -2094,16 +2403,7 @@ func (fbo *folderBranchOps) GetNodeMetadata(ctx context.Context, node Node) (
 	}
 	res.BlockInfo = de.BlockInfo
 
-	id := de.TeamWriter.AsUserOrTeam()
-	if id.IsNil() {
-		id = de.Writer
-	}
-	if id.IsNil() {
-		id = de.Creator
-	}
-	// Only set the last resolved writer if it's really a user ID.
-	// This works around an old teams bug where the TeamWriter isn't
-	// set.  See KBFS-2939.
+	id := lastWriterOfEntry(de)
 	if id.IsUser() {
 		res.LastWriterUnverified, err =
 			fbo.config.KBPKI().GetNormalizedUsername(ctx, id)
@@ -2117,6 +2417,21 @@ func (fbo *folderBranchOps) GetNodeMetadata(ctx context.Context, node Node) (
 	return res, nil
 }
 
+// lastWriterOfEntry returns the ID of the last writer of the given
+// entry, if it is a user.  For an entry that was last written before
+// TeamWriter was tracked, it may return a team ID instead.  See
+// KBFS-2939.
+func lastWriterOfEntry(de DirEntry) keybase1.UserOrTeamID {
+	id := de.TeamWriter.AsUserOrTeam()
+	if id.IsNil() {
+		id = de.Writer
+	}
+	if id.IsNil() {
+		id = de.Creator
+	}
+	return id
+}
+
 // blockPutState is an internal structure to track data when putting blocks
 type blockPutState struct {
 	blockStates []blockState
@@ -2394,6 +2709,8 @@ func (fbo *folderBranchOps) finalizeMDWriteLocked(ctx context.Context,
 	// have already succeeded. Returning EINTR makes application thinks the file
 	// is not created successfully.
 
+	fbo.cacheSubtreeUsagesLocked(lState, md)
+
 	err = fbo.finalizeBlocks(ctx, bps)
 	if err != nil {
 		return err
@@ -2668,6 +2985,18 @@ func (fbo *folderBranchOps) finalizeGCOp(ctx context.Context, gco *GCOp) (
 	// `gco.LatestRev+1`.
 	md.SetLastGCRevision(gco.LatestRev)
 
+	return fbo.finalizeMDOnlyWriteLocked(ctx, lState, md)
+}
+
+// finalizeMDOnlyWriteLocked puts `md`, a merged successor of the
+// head whose changes don't touch any data blocks, straight to the
+// server.  Unlike finalizeMDWriteLocked, a conflict is returned as
+// an error rather than turned into an unmerged put, so the caller
+// can just try again later.
+func (fbo *folderBranchOps) finalizeMDOnlyWriteLocked(
+	ctx context.Context, lState *lockState, md *RootMetadata) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
 	bps, err := fbo.maybeUnembedAndPutBlocks(ctx, md)
 	if err != nil {
 		return err
@@ -2688,8 +3017,9 @@ func (fbo *folderBranchOps) finalizeGCOp(ctx context.Context, gco *GCOp) (
 	irmd, err := fbo.config.MDOps().Put(
 		ctx, md, session.VerifyingKey, nil, keybase1.MDPriorityNormal)
 	if err != nil {
-		// Don't allow garbage collection to put us into a conflicting
-		// state; just wait for the next period.
+		// Don't allow garbage collection, or any other MD-only
+		// write, to put us into a conflicting state; just try
+		// again later.
 		return err
 	}
 
@@ -2830,6 +3160,71 @@ func (fbo *folderBranchOps) checkForUnlinkedDir(dir Node) error {
 	return nil
 }
 
+// makeNewEntry returns a directory entry for a new, empty entry of
+// type `entryType`, which points to a new temporary block ID.
+func (fbo *folderBranchOps) makeNewEntry(ctx context.Context,
+	md ImmutableRootMetadata, entryType EntryType) (DirEntry, error) {
+	newID, err := fbo.config.cryptoPure().MakeTemporaryBlockID()
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	chargedTo, err := chargedToForTLF(
+		ctx, fbo.config.KBPKI(), fbo.config.KBPKI(), md.GetTlfHandle())
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	newPtr := BlockPointer{
+		ID:         newID,
+		KeyGen:     md.LatestKeyGeneration(),
+		DataVer:    fbo.config.DataVersion(),
+		DirectType: DirectBlock,
+		Context: kbfsblock.MakeFirstContext(
+			chargedTo, fbo.config.DefaultBlockType()),
+	}
+
+	now := fbo.nowUnixNano()
+	de := DirEntry{
+		BlockInfo: BlockInfo{
+			BlockPointer: newPtr,
+			EncodedSize:  0,
+		},
+		EntryInfo: EntryInfo{
+			Type:  entryType,
+			Size:  0,
+			Mtime: now,
+			Ctime: now,
+		},
+	}
+
+	// Set the TeamWriter for team TLFs, so we can return the
+	// LastWriterUnverified before the writes are flushed from memory.
+	if fbo.id().Type() == tlf.SingleTeam {
+		session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		de.TeamWriter = session.UID
+	}
+	return de, nil
+}
+
+// putNewEntryBlock puts an empty block for the new entry `de` into
+// the dirty block cache.
+func (fbo *folderBranchOps) putNewEntryBlock(de DirEntry) error {
+	var newBlock Block
+	if de.Type == Dir {
+		newBlock = &DirBlock{
+			Children: make(map[string]DirEntry),
+		}
+	} else {
+		newBlock = &FileBlock{}
+	}
+	return fbo.config.DirtyBlockCache().Put(
+		fbo.id(), de.BlockPointer, fbo.branch(), newBlock)
+}
+
 // entryType must not by Sym.
 func (fbo *folderBranchOps) createEntryLocked(
 	ctx context.Context, lState *lockState, dir Node, name string,
@@ -2889,37 +3284,14 @@ func (fbo *folderBranchOps) createEntryLocked(
 		return nil, DirEntry{}, err
 	}
 	co.setFinalPath(dirPath)
-	// create new data block
-	var newBlock Block
-	if entryType == Dir {
-		newBlock = &DirBlock{
-			Children: make(map[string]DirEntry),
-		}
-	} else {
-		newBlock = &FileBlock{}
-	}
 
 	// Cache update and operations until batch happens.  Make a new
 	// temporary ID and directory entry.
-	newID, err := fbo.config.cryptoPure().MakeTemporaryBlockID()
-	if err != nil {
-		return nil, DirEntry{}, err
-	}
-
-	chargedTo, err := chargedToForTLF(
-		ctx, fbo.config.KBPKI(), fbo.config.KBPKI(), md.GetTlfHandle())
+	de, err = fbo.makeNewEntry(ctx, md, entryType)
 	if err != nil {
 		return nil, DirEntry{}, err
 	}
-
-	newPtr := BlockPointer{
-		ID:         newID,
-		KeyGen:     md.LatestKeyGeneration(),
-		DataVer:    fbo.config.DataVersion(),
-		DirectType: DirectBlock,
-		Context: kbfsblock.MakeFirstContext(
-			chargedTo, fbo.config.DefaultBlockType()),
-	}
+	newPtr := de.BlockPointer
 	co.AddRefBlock(newPtr)
 	co.AddSelfUpdate(parentPtr)
 
@@ -2928,36 +3300,11 @@ func (fbo *folderBranchOps) createEntryLocked(
 		return nil, DirEntry{}, err
 	}
 
-	err = fbo.config.DirtyBlockCache().Put(
-		fbo.id(), newPtr, fbo.branch(), newBlock)
+	err = fbo.putNewEntryBlock(de)
 	if err != nil {
 		return nil, DirEntry{}, err
 	}
 
-	now := fbo.nowUnixNano()
-	de = DirEntry{
-		BlockInfo: BlockInfo{
-			BlockPointer: newPtr,
-			EncodedSize:  0,
-		},
-		EntryInfo: EntryInfo{
-			Type:  entryType,
-			Size:  0,
-			Mtime: now,
-			Ctime: now,
-		},
-	}
-
-	// Set the TeamWriter for team TLFs, so we can return the
-	// LastWriterUnverified before the writes are flushed from memory.
-	if fbo.id().Type() == tlf.SingleTeam {
-		session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
-		if err != nil {
-			return nil, DirEntry{}, err
-		}
-		de.TeamWriter = session.UID
-	}
-
 	dirCacheUndoFn := fbo.blocks.AddDirEntryInCache(lState, dirPath, name, de)
 	fbo.dirOps = append(fbo.dirOps, cachedDirOp{co, []Node{dir, node}})
 	added := fbo.status.addDirtyNode(dir)
@@ -2983,8 +3330,8 @@ func (fbo *folderBranchOps) createEntryLocked(
 		// Dirty the file with a zero-byte write, to ensure the new
 		// block is synced in SyncAll.  TODO: remove this if we ever
 		// embed 0-byte files in the directory entry itself.
-		err = fbo.blocks.Write(
-			ctx, lState, md.ReadOnly(), node, []byte{}, 0)
+		_, err = fbo.blocks.Write(
+			ctx, lState, md.ReadOnly(), node, []byte{}, 0, 0)
 		if err != nil {
 			return nil, DirEntry{}, err
 		}
@@ -3145,19 +3492,22 @@ func (fbo *folderBranchOps) doMDWriteWithRetry(ctx context.Context,
 
 func (fbo *folderBranchOps) doMDWriteWithRetryUnlessCanceled(
 	ctx context.Context, fn func(lState *lockState) error) error {
-	return runUnlessCanceled(ctx, func() error {
+	err := runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 		return fbo.doMDWriteWithRetry(ctx, lState, fn)
 	})
+	fbo.recordSignificantErr(err)
+	return err
 }
 
 func (fbo *folderBranchOps) CreateDir(
 	ctx context.Context, dir Node, path string) (
 	n Node, ei EntryInfo, err error) {
-	fbo.log.CDebugf(ctx, "CreateDir %s %s", getNodeIDStr(dir), path)
+	logName := fbo.config.RedactLogPath(path)
+	fbo.log.CDebugf(ctx, "CreateDir %s %s", getNodeIDStr(dir), logName)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "CreateDir %s %s done: %v %+v",
-			getNodeIDStr(dir), path, getNodeIDStr(n), err)
+			getNodeIDStr(dir), logName, getNodeIDStr(n), err)
 	}()
 
 	err = fbo.checkNodeForWrite(ctx, dir)
@@ -3186,12 +3536,13 @@ func (fbo *folderBranchOps) CreateDir(
 func (fbo *folderBranchOps) CreateFile(
 	ctx context.Context, dir Node, path string, isExec bool, excl Excl) (
 	n Node, ei EntryInfo, err error) {
+	logName := fbo.config.RedactLogPath(path)
 	fbo.log.CDebugf(ctx, "CreateFile %s %s isExec=%v Excl=%s",
-		getNodeIDStr(dir), path, isExec, excl)
+		getNodeIDStr(dir), logName, isExec, excl)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx,
 			"CreateFile %s %s isExec=%v Excl=%s done: %v %+v",
-			getNodeIDStr(dir), path, isExec, excl,
+			getNodeIDStr(dir), logName, isExec, excl,
 			getNodeIDStr(n), err)
 	}()
 
@@ -3243,9 +3594,15 @@ func (fbo *folderBranchOps) CreateFile(
 // is on, it signals the write; otherwise it syncs the change.  It
 // should only be called as the final instruction that can fail in a
 // method.
-func (fbo *folderBranchOps) notifyAndSyncOrSignal(
+// notifyAndQueueLocked adds the given op, which has already been
+// applied to the dir entry cache, to the list of ops waiting to be
+// synced, and sends out the notifications for it.  It returns a
+// function that undoes all of that, and which must be called before
+// any later ops are undone.
+func (fbo *folderBranchOps) notifyAndQueueLocked(
 	ctx context.Context, lState *lockState, undoFn dirCacheUndoFn,
-	nodesToDirty []Node, op op, md ReadOnlyRootMetadata) (err error) {
+	nodesToDirty []Node, op op, md ReadOnlyRootMetadata) (
+	queueUndoFn dirCacheUndoFn, err error) {
 	fbo.dirOps = append(fbo.dirOps, cachedDirOp{op, nodesToDirty})
 	var addedNodes []Node
 	for _, n := range nodesToDirty {
@@ -3255,34 +3612,49 @@ func (fbo *folderBranchOps) notifyAndSyncOrSignal(
 		}
 	}
 
-	defer func() {
-		if err != nil {
-			for _, n := range addedNodes {
-				fbo.status.rmDirtyNode(n)
-			}
-			fbo.dirOps = fbo.dirOps[:len(fbo.dirOps)-1]
-			if undoFn != nil {
-				undoFn(lState)
-			}
+	queueUndoFn = func(lState *lockState) {
+		for _, n := range addedNodes {
+			fbo.status.rmDirtyNode(n)
 		}
-	}()
+		fbo.dirOps = fbo.dirOps[:len(fbo.dirOps)-1]
+		if undoFn != nil {
+			undoFn(lState)
+		}
+	}
 
 	// It's safe to notify before we've synced, since it is only
 	// sending invalidation notifications.  At worst the upper layer
 	// will just have to refresh its cache needlessly.
 	err = fbo.notifyOneOp(ctx, lState, op, md, false)
 	if err != nil {
-		return err
+		queueUndoFn(lState)
+		return nil, err
 	}
-
-	return fbo.syncDirUpdateOrSignal(ctx, lState)
+	return queueUndoFn, nil
 }
 
-func (fbo *folderBranchOps) createLinkLocked(
-	ctx context.Context, lState *lockState, dir Node, fromName string,
-	toPath string) (DirEntry, error) {
-	fbo.mdWriterLock.AssertLocked(lState)
-
+func (fbo *folderBranchOps) notifyAndSyncOrSignal(
+	ctx context.Context, lState *lockState, undoFn dirCacheUndoFn,
+	nodesToDirty []Node, op op, md ReadOnlyRootMetadata) error {
+	queueUndoFn, err := fbo.notifyAndQueueLocked(
+		ctx, lState, undoFn, nodesToDirty, op, md)
+	if err != nil {
+		return err
+	}
+
+	err = fbo.syncDirUpdateOrSignal(ctx, lState)
+	if err != nil {
+		queueUndoFn(lState)
+		return err
+	}
+	return nil
+}
+
+func (fbo *folderBranchOps) createLinkLocked(
+	ctx context.Context, lState *lockState, dir Node, fromName string,
+	toPath string) (DirEntry, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
 	if err := checkDisallowedPrefixes(ctx, fromName); err != nil {
 		return DirEntry{}, err
 	}
@@ -3365,11 +3737,13 @@ func (fbo *folderBranchOps) createLinkLocked(
 func (fbo *folderBranchOps) CreateLink(
 	ctx context.Context, dir Node, fromName string, toPath string) (
 	ei EntryInfo, err error) {
+	logFromName := fbo.config.RedactLogPath(fromName)
+	logToPath := fbo.config.RedactLogPath(toPath)
 	fbo.log.CDebugf(ctx, "CreateLink %s %s -> %s",
-		getNodeIDStr(dir), fromName, toPath)
+		getNodeIDStr(dir), logFromName, logToPath)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "CreateLink %s %s -> %s done: %+v",
-			getNodeIDStr(dir), fromName, toPath, err)
+			getNodeIDStr(dir), logFromName, logToPath, err)
 	}()
 
 	err = fbo.checkNodeForWrite(ctx, dir)
@@ -3392,6 +3766,290 @@ func (fbo *folderBranchOps) CreateLink(
 	return retEntryInfo, nil
 }
 
+// readyFileCopyLocked makes a copy of the file at `file`, to be
+// named `name` in `dir`, sharing its leaf blocks with the original by
+// adding new references to them, and readies all of the copy's
+// blocks into `bps`.  It returns the
+// BlockInfo of the copy's top block, along with the infos of all its
+// children, which need to be referenced by the op creating the copy.
+func (fbo *folderBranchOps) readyFileCopyLocked(
+	ctx context.Context, lState *lockState, md *RootMetadata,
+	chargedTo keybase1.UserOrTeamID, file path, dir path, name string,
+	bps *blockPutState) (topInfo BlockInfo, infos []BlockInfo, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// Keep the copied blocks out of the main dirty block cache; they
+	// are readied right away, and never written to.
+	dirtyBcache := simpleDirtyBlockCacheStandard()
+	newPtr, _, err := fbo.blocks.DeepCopyFile(
+		ctx, lState, md, file, dirtyBcache, fbo.config.DataVersion())
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	block, err := dirtyBcache.Get(fbo.id(), newPtr, fbo.branch())
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	fblock, isFileBlock := block.(*FileBlock)
+	if !isFileBlock {
+		return BlockInfo{}, nil, NotFileBlockError{newPtr, fbo.branch(), file}
+	}
+	dstPath := dir.ChildPath(name, newPtr)
+
+	if fblock.IsInd {
+		// If journaling is enabled, new references aren't
+		// supported, so each leaf block has to be fetched and
+		// readied as a new block.  TODO: remove this when KBFS-1149
+		// is fixed.
+		if TLFJournalEnabled(fbo.config, fbo.id()) {
+			infos, err = fbo.blocks.UndupChildrenInCopy(
+				ctx, lState, md.ReadOnly(), dstPath, bps, dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+		} else {
+			_, err = fbo.blocks.ReadyNonLeafBlocksInCopy(
+				ctx, lState, md.ReadOnly(), dstPath, bps, dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+
+			infos, err = fbo.blocks.GetIndirectFileBlockInfosWithTopBlock(
+				ctx, lState, md.ReadOnly(), dstPath, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+
+			for _, info := range infos {
+				// The indirect blocks were already added to `bps`,
+				// so only add the dedup'd leaf blocks.
+				if info.RefNonce != kbfsblock.ZeroRefNonce {
+					bps.addNewBlock(info.BlockPointer,
+						nil, ReadyBlockData{}, nil)
+				}
+			}
+		}
+	}
+
+	// The top block always gets a new ID, since it's small, and for
+	// an indirect file its children have all changed.
+	topInfo, _, readyBlockData, err := ReadyBlock(
+		ctx, fbo.config.BlockCache(), fbo.config.BlockOps(),
+		fbo.config.cryptoPure(), md, fblock, chargedTo,
+		fbo.config.DefaultBlockType())
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	bps.addNewBlock(topInfo.BlockPointer, fblock, readyBlockData, nil)
+	err = fbo.config.BlockCache().Put(
+		topInfo.BlockPointer, fbo.id(), fblock, TransientEntry)
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	return topInfo, infos, nil
+}
+
+func (fbo *folderBranchOps) copyFileLocked(
+	ctx context.Context, lState *lockState, srcNode Node, dstDir Node,
+	dstName string) (childNode Node, de DirEntry, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if err := checkDisallowedPrefixes(ctx, dstName); err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	if uint32(len(dstName)) > fbo.config.MaxNameBytes() {
+		return nil, DirEntry{},
+			NameTooLongError{dstName, fbo.config.MaxNameBytes()}
+	}
+
+	if err := fbo.checkForUnlinkedDir(dstDir); err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	// Flush everything first, so the source file's blocks are all on
+	// the server and can be referenced, and the destination
+	// directory isn't dirty.
+	err = fbo.syncAllLocked(ctx, lState, NoExcl)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	filename, err := fbo.canonicalPath(ctx, dstDir, dstName)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLockedForFilename(
+		ctx, lState, filename)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	srcPath, err := fbo.pathFromNodeForMDWriteLocked(lState, srcNode)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dstDir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	srcDe, err := fbo.blocks.GetDirtyEntry(ctx, lState, md, srcPath)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	if srcDe.Type != File && srcDe.Type != Exec {
+		return nil, DirEntry{}, NotFileError{srcPath}
+	}
+
+	dblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockWrite)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	if _, ok := dblock.Children[dstName]; ok {
+		return nil, DirEntry{}, NameExistsError{dstName}
+	}
+
+	if err := fbo.checkNewDirSize(
+		ctx, lState, md.ReadOnly(), dirPath, dstName); err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	chargedTo, err := chargedToForTLF(
+		ctx, fbo.config.KBPKI(), fbo.config.KBPKI(), md.GetTlfHandle())
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	co, err := newCreateOp(dstName, dirPath.tailPointer(), srcDe.Type)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	co.setFinalPath(dirPath)
+	md.AddOp(co)
+
+	bps := newBlockPutState(1)
+	defer func() {
+		if err != nil {
+			fbo.fbm.cleanUpBlockState(
+				md.ReadOnly(), bps, blockDeleteOnMDFail)
+		}
+	}()
+	topInfo, childInfos, err := fbo.readyFileCopyLocked(
+		ctx, lState, md, chargedTo, srcPath, dirPath, dstName, bps)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	md.AddRefBlock(topInfo)
+	for _, info := range childInfos {
+		md.AddRefBlock(info)
+	}
+
+	now := fbo.nowUnixNano()
+	de = DirEntry{
+		BlockInfo: topInfo,
+		EntryInfo: EntryInfo{
+			Type:  srcDe.Type,
+			Size:  srcDe.Size,
+			Mtime: now,
+			Ctime: now,
+		},
+	}
+	if fbo.id().Type() == tlf.SingleTeam {
+		session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+		if err != nil {
+			return nil, DirEntry{}, err
+		}
+		de.TeamWriter = session.UID
+	}
+	dblock.Children[dstName] = de
+
+	// Ready the new directory block, and everything above it.
+	_, _, dirBps, err := fbo.prepper.prepUpdateForPath(
+		ctx, lState, chargedTo, md, dblock, *dirPath.parentPath(),
+		dirPath.tailName(), Dir, true, true, zeroPtr, make(localBcache))
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	bps.mergeOtherBps(dirBps)
+
+	_, err = doBlockPuts(ctx, fbo.config.BlockServer(),
+		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log,
+		fbo.deferLog, md.TlfID(), md.GetTlfHandle().GetCanonicalName(), *bps)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	changesBps, err := fbo.maybeUnembedAndPutBlocks(ctx, md)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	if changesBps != nil {
+		bps.mergeOtherBps(changesBps)
+	}
+
+	err = fbo.finalizeMDWriteLocked(ctx, lState, md, bps, NoExcl,
+		func(md ImmutableRootMetadata) error {
+			return fbo.notifyBatchLocked(ctx, lState, md)
+		})
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	node, err := fbo.nodeCache.GetOrCreate(
+		topInfo.BlockPointer, dstName, dstDir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	return node, de, nil
+}
+
+// CopyFile implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) CopyFile(
+	ctx context.Context, srcNode Node, dstDir Node, dstName string) (
+	n Node, ei EntryInfo, err error) {
+	logName := fbo.config.RedactLogPath(dstName)
+	fbo.log.CDebugf(ctx, "CopyFile %s -> %s %s",
+		getNodeIDStr(srcNode), getNodeIDStr(dstDir), logName)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "CopyFile %s -> %s %s done: %v %+v",
+			getNodeIDStr(srcNode), getNodeIDStr(dstDir), logName,
+			getNodeIDStr(n), err)
+	}()
+
+	err = fbo.checkNode(srcNode)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	err = fbo.checkNodeForWrite(ctx, dstDir)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	var retNode Node
+	var retEntryInfo EntryInfo
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			if srcNode.GetFolderBranch() != dstDir.GetFolderBranch() {
+				return CopyAcrossTlfsError{}
+			}
+
+			// Don't set n and ei directly, as that can cause a race
+			// when the copy is canceled.
+			node, de, err := fbo.copyFileLocked(
+				ctx, lState, srcNode, dstDir, dstName)
+			retNode = node
+			retEntryInfo = de.EntryInfo
+			return err
+		})
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	return retNode, retEntryInfo, nil
+}
+
 // unrefEntry modifies md to unreference all relevant blocks for the
 // given entry.
 func (fbo *folderBranchOps) unrefEntryLocked(ctx context.Context,
@@ -3447,38 +4105,41 @@ func (fbo *folderBranchOps) unrefEntryLocked(ctx context.Context,
 	return nil
 }
 
-func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
+// removeEntryNoSyncLocked removes the given entry from the dir entry
+// cache and queues up the op for it, without syncing it.  It returns
+// a function that undoes the removal.
+func (fbo *folderBranchOps) removeEntryNoSyncLocked(ctx context.Context,
 	lState *lockState, md ReadOnlyRootMetadata, dir Node, dirPath path,
-	name string) error {
+	name string) (dirCacheUndoFn, error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
 	if err := fbo.checkForUnlinkedDir(dir); err != nil {
-		return err
+		return nil, err
 	}
 
 	// We're not going to modify this copy of the dirblock, so just
 	// fetch it for reading.
 	pblock, err := fbo.blocks.GetDirtyDir(ctx, lState, md, dirPath, blockRead)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// make sure the entry exists
 	de, ok := pblock.Children[name]
 	if !ok {
-		return NoSuchNameError{name}
+		return nil, NoSuchNameError{name}
 	}
 
 	parentPtr := dirPath.tailPointer()
 	ro, err := newRmOp(name, parentPtr, de.Type)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	ro.setFinalPath(dirPath)
 	ro.AddSelfUpdate(parentPtr)
 	err = fbo.unrefEntryLocked(ctx, lState, md, ro, dirPath, de, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	dirCacheUndoFn := fbo.blocks.RemoveDirEntryInCache(
@@ -3501,10 +4162,99 @@ func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
 			}
 		}
 	}
-	return fbo.notifyAndSyncOrSignal(
+	return fbo.notifyAndQueueLocked(
 		ctx, lState, dirCacheUndoFn, []Node{dir}, ro, md.ReadOnly())
 }
 
+func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
+	lState *lockState, md ReadOnlyRootMetadata, dir Node, dirPath path,
+	name string) error {
+	undoFn, err := fbo.removeEntryNoSyncLocked(
+		ctx, lState, md, dir, dirPath, name)
+	if err != nil {
+		return err
+	}
+
+	err = fbo.syncDirUpdateOrSignal(ctx, lState)
+	if err != nil {
+		undoFn(lState)
+		return err
+	}
+	return nil
+}
+
+// removeAllNoSyncLocked removes the given entry and, if it's a
+// directory, everything under it, bottom-up, without syncing any of
+// the removals.  It returns the functions that undo the removals, in
+// the order in which they must be called.
+func (fbo *folderBranchOps) removeAllNoSyncLocked(ctx context.Context,
+	lState *lockState, md ReadOnlyRootMetadata, dir Node, dirPath path,
+	name string) (undoFns []dirCacheUndoFn, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	defer func() {
+		if err != nil {
+			for _, f := range undoFns {
+				f(lState)
+			}
+			undoFns = nil
+		}
+	}()
+
+	pblock, err := fbo.blocks.GetDirtyDir(ctx, lState, md, dirPath, blockRead)
+	if err != nil {
+		return nil, err
+	}
+	de, ok := pblock.Children[name]
+	if !ok {
+		return nil, NoSuchNameError{name}
+	}
+
+	if de.Type == Dir {
+		childPath := dirPath.ChildPath(name, de.BlockPointer)
+		childBlock, err := fbo.blocks.GetDirtyDir(
+			ctx, lState, md, childPath, blockRead)
+		switch {
+		case isRecoverableBlockErrorForRemoval(err):
+			// The children can't be unreferenced, but the
+			// directory itself can still be removed.
+			msg := fmt.Sprintf("Recoverable block error encountered for "+
+				"removeAllNoSyncLocked(%v); continuing", childPath)
+			fbo.log.CWarningf(ctx, "%s", msg)
+			fbo.log.CDebugf(ctx, "%s (err=%v)", msg, err)
+		case err != nil:
+			return nil, err
+		case len(childBlock.Children) > 0:
+			childNode, err := fbo.nodeCache.GetOrCreate(
+				de.BlockPointer, name, dir)
+			if err != nil {
+				return nil, err
+			}
+			childNames := make([]string, 0, len(childBlock.Children))
+			for childName := range childBlock.Children {
+				childNames = append(childNames, childName)
+			}
+			sort.Strings(childNames)
+			for _, childName := range childNames {
+				childUndoFns, err := fbo.removeAllNoSyncLocked(
+					ctx, lState, md, childNode, childPath, childName)
+				// Undo the newest removals first.
+				undoFns = append(childUndoFns, undoFns...)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	undoFn, err := fbo.removeEntryNoSyncLocked(
+		ctx, lState, md, dir, dirPath, name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]dirCacheUndoFn{undoFn}, undoFns...), nil
+}
+
 func (fbo *folderBranchOps) removeDirLocked(ctx context.Context,
 	lState *lockState, dir Node, dirName string) (err error) {
 	fbo.mdWriterLock.AssertLocked(lState)
@@ -3548,10 +4298,11 @@ func (fbo *folderBranchOps) removeDirLocked(ctx context.Context,
 
 func (fbo *folderBranchOps) RemoveDir(
 	ctx context.Context, dir Node, dirName string) (err error) {
-	fbo.log.CDebugf(ctx, "RemoveDir %s %s", getNodeIDStr(dir), dirName)
+	logName := fbo.config.RedactLogPath(dirName)
+	fbo.log.CDebugf(ctx, "RemoveDir %s %s", getNodeIDStr(dir), logName)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "RemoveDir %s %s done: %+v",
-			getNodeIDStr(dir), dirName, err)
+			getNodeIDStr(dir), logName, err)
 	}()
 
 	removeDone, err := dir.RemoveDir(ctx, dirName)
@@ -3575,10 +4326,11 @@ func (fbo *folderBranchOps) RemoveDir(
 
 func (fbo *folderBranchOps) RemoveEntry(ctx context.Context, dir Node,
 	name string) (err error) {
-	fbo.log.CDebugf(ctx, "RemoveEntry %s %s", getNodeIDStr(dir), name)
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "RemoveEntry %s %s", getNodeIDStr(dir), logName)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "RemoveEntry %s %s done: %+v",
-			getNodeIDStr(dir), name, err)
+			getNodeIDStr(dir), logName, err)
 	}()
 
 	err = fbo.checkNodeForWrite(ctx, dir)
@@ -3605,54 +4357,108 @@ func (fbo *folderBranchOps) RemoveEntry(ctx context.Context, dir Node,
 		})
 }
 
-func (fbo *folderBranchOps) renameLocked(
+// RemoveAll implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RemoveAll(ctx context.Context, dir Node,
+	name string) (err error) {
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "RemoveAll %s %s", getNodeIDStr(dir), logName)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RemoveAll %s %s done: %+v",
+			getNodeIDStr(dir), logName, err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			// Verify we have permission to write (but no need to make
+			// a successor yet).
+			md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+			if err != nil {
+				return err
+			}
+
+			dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+			if err != nil {
+				return err
+			}
+
+			// Queue up all the removals before syncing any of them,
+			// so that they can all go into the same revision.
+			undoFns, err := fbo.removeAllNoSyncLocked(
+				ctx, lState, md.ReadOnly(), dir, dirPath, name)
+			if err != nil {
+				return err
+			}
+
+			err = fbo.syncDirUpdateOrSignal(ctx, lState)
+			if err != nil {
+				for _, f := range undoFns {
+					f(lState)
+				}
+				return err
+			}
+			return nil
+		})
+}
+
+// renameNoSyncLocked queues up a rename, without syncing it.  It
+// returns a function that undoes the rename, and which must be called
+// before any earlier queued ops are undone.
+func (fbo *folderBranchOps) renameNoSyncLocked(
 	ctx context.Context, lState *lockState, oldParent Node, oldName string,
-	newParent Node, newName string) (err error) {
+	newParent Node, newName string, flags RenameFlags) (
+	undoFn dirCacheUndoFn, err error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
 	if err := fbo.checkForUnlinkedDir(oldParent); err != nil {
-		return err
+		return nil, err
 	}
 	if err := fbo.checkForUnlinkedDir(newParent); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := checkDisallowedPrefixes(ctx, newName); err != nil {
-		return err
+		return nil, err
 	}
 
 	oldParentPath, err := fbo.pathFromNodeForMDWriteLocked(lState, oldParent)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	newParentPath, err := fbo.pathFromNodeForMDWriteLocked(lState, newParent)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Verify we have permission to write (but no need to make a
 	// successor yet).
 	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, newPBlock, newDe, ro, err := fbo.blocks.PrepRename(
 		ctx, lState, md.ReadOnly(), oldParentPath, oldName, newParentPath,
 		newName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// does name exist?
 	replacedDe, ok := newPBlock.Children[newName]
-	if ok {
+	if ok && flags&RenameNoReplace != 0 {
+		return nil, NameExistsError{newName}
+	} else if ok {
 		// Usually higher-level programs check these, but just in case.
 		if replacedDe.Type == Dir && newDe.Type != Dir {
-			return NotDirError{newParentPath.ChildPathNoPtr(newName)}
+			return nil, NotDirError{newParentPath.ChildPathNoPtr(newName)}
 		} else if replacedDe.Type != Dir && newDe.Type == Dir {
-			return NotFileError{newParentPath.ChildPathNoPtr(newName)}
+			return nil, NotFileError{newParentPath.ChildPathNoPtr(newName)}
 		}
 
 		if replacedDe.Type == Dir {
@@ -3661,12 +4467,12 @@ func (fbo *folderBranchOps) renameLocked(
 				md.ReadOnly(), replacedDe.BlockPointer, newParentPath.Branch,
 				newParentPath.ChildPathNoPtr(newName))
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if len(oldTargetDir.Children) != 0 {
 				fbo.log.CWarningf(ctx, "Renaming over a non-empty directory "+
 					" (%s/%s) not allowed.", newParentPath, newName)
-				return DirNotEmptyError{newName}
+				return nil, DirNotEmptyError{newName}
 			}
 		}
 
@@ -3674,7 +4480,7 @@ func (fbo *folderBranchOps) renameLocked(
 		err := fbo.unrefEntryLocked(
 			ctx, lState, md.ReadOnly(), ro, newParentPath, replacedDe, newName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		// If the entry doesn't exist yet, see if the new name will
@@ -3693,7 +4499,7 @@ func (fbo *folderBranchOps) renameLocked(
 			if err := fbo.checkNewDirSize(
 				ctx, lState, md.ReadOnly(), newParentPath,
 				checkName); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
@@ -3705,28 +4511,114 @@ func (fbo *folderBranchOps) renameLocked(
 		lState, oldParentPath, oldName, newParentPath, newName, newDe,
 		replacedDe)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	nodesToDirty := []Node{oldParent}
 	if oldParent.GetID() != newParent.GetID() {
 		nodesToDirty = append(nodesToDirty, newParent)
 	}
-	return fbo.notifyAndSyncOrSignal(
+	return fbo.notifyAndQueueLocked(
 		ctx, lState, dirCacheUndoFn, nodesToDirty, ro, md.ReadOnly())
 }
 
+func (fbo *folderBranchOps) renameLocked(
+	ctx context.Context, lState *lockState, oldParent Node, oldName string,
+	newParent Node, newName string, flags RenameFlags) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	undoFn, err := fbo.renameNoSyncLocked(
+		ctx, lState, oldParent, oldName, newParent, newName, flags)
+	if err != nil {
+		return err
+	}
+
+	err = fbo.syncDirUpdateOrSignal(ctx, lState)
+	if err != nil {
+		undoFn(lState)
+		return err
+	}
+	return nil
+}
+
+// renameExchangeLocked swaps oldName in oldParent with newName in
+// newParent.  It's done as three renames that don't replace
+// anything, through a temporary name, which all go into the same
+// revision, so nobody else ever sees the temporary name.
+func (fbo *folderBranchOps) renameExchangeLocked(
+	ctx context.Context, lState *lockState, oldParent Node, oldName string,
+	newParent Node, newName string) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	var suffix [8]byte
+	if err := kbfscrypto.RandRead(suffix[:]); err != nil {
+		return err
+	}
+	tmpName := ".exchange-" + hex.EncodeToString(suffix[:])
+
+	var undoFns []dirCacheUndoFn
+	defer func() {
+		if err != nil {
+			for i := len(undoFns) - 1; i >= 0; i-- {
+				undoFns[i](lState)
+			}
+		}
+	}()
+	for _, r := range []struct {
+		oldParent Node
+		oldName   string
+		newParent Node
+		newName   string
+	}{
+		{oldParent, oldName, oldParent, tmpName},
+		{newParent, newName, oldParent, oldName},
+		{oldParent, tmpName, newParent, newName},
+	} {
+		undoFn, err := fbo.renameNoSyncLocked(ctx, lState, r.oldParent,
+			r.oldName, r.newParent, r.newName, RenameNoReplace)
+		if err != nil {
+			return err
+		}
+		undoFns = append(undoFns, undoFn)
+	}
+
+	return fbo.syncDirUpdateOrSignal(ctx, lState)
+}
+
 func (fbo *folderBranchOps) Rename(
 	ctx context.Context, oldParent Node, oldName string, newParent Node,
 	newName string) (err error) {
-	fbo.log.CDebugf(ctx, "Rename %s/%s -> %s/%s", getNodeIDStr(oldParent),
-		oldName, getNodeIDStr(newParent), newName)
+	return fbo.RenameWithFlags(ctx, oldParent, oldName, newParent, newName, 0)
+}
+
+// RenameExchange implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RenameExchange(
+	ctx context.Context, oldParent Node, oldName string, newParent Node,
+	newName string) (err error) {
+	return fbo.RenameWithFlags(
+		ctx, oldParent, oldName, newParent, newName, RenameExchange)
+}
+
+// RenameWithFlags implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RenameWithFlags(
+	ctx context.Context, oldParent Node, oldName string, newParent Node,
+	newName string, flags RenameFlags) (err error) {
+	logOldName := fbo.config.RedactLogPath(oldName)
+	logNewName := fbo.config.RedactLogPath(newName)
+	fbo.log.CDebugf(ctx, "Rename %s/%s -> %s/%s flags=%d",
+		getNodeIDStr(oldParent), logOldName, getNodeIDStr(newParent),
+		logNewName, flags)
 	defer func() {
-		fbo.deferLog.CDebugf(ctx, "Rename %s/%s -> %s/%s done: %+v",
-			getNodeIDStr(oldParent), oldName,
-			getNodeIDStr(newParent), newName, err)
+		fbo.deferLog.CDebugf(ctx, "Rename %s/%s -> %s/%s flags=%d done: %+v",
+			getNodeIDStr(oldParent), logOldName,
+			getNodeIDStr(newParent), logNewName, flags, err)
 	}()
 
+	if flags&RenameNoReplace != 0 && flags&RenameExchange != 0 {
+		return errors.New(
+			"RenameNoReplace and RenameExchange can't be used together")
+	}
+
 	err = fbo.checkNodeForWrite(ctx, oldParent)
 	if err != nil {
 		return err
@@ -3743,8 +4635,12 @@ func (fbo *folderBranchOps) Rename(
 				return RenameAcrossDirsError{}
 			}
 
+			if flags&RenameExchange != 0 {
+				return fbo.renameExchangeLocked(ctx, lState, oldParent,
+					oldName, newParent, newName)
+			}
 			return fbo.renameLocked(ctx, lState, oldParent, oldName,
-				newParent, newName)
+				newParent, newName, flags)
 		})
 }
 
@@ -3756,6 +4652,7 @@ func (fbo *folderBranchOps) Read(
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "Read %s %d %d (n=%d) done: %+v",
 			getNodeIDStr(file), len(dest), off, n, err)
+		fbo.recordSignificantErr(err)
 	}()
 
 	err = fbo.checkNode(file)
@@ -3815,23 +4712,86 @@ func (fbo *folderBranchOps) Read(
 	return bytesRead, nil
 }
 
+// fileBlockPointers returns the pointers of all the blocks of the
+// given file: its top block, and any indirect blocks under it.
+func (fbo *folderBranchOps) fileBlockPointers(
+	ctx context.Context, file Node) (ptrs []BlockPointer, err error) {
+	err = fbo.checkNode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+		filePath, err := fbo.pathFromNodeForRead(file)
+		if err != nil {
+			return err
+		}
+		if !filePath.hasValidParent() {
+			return NotFileError{filePath}
+		}
+		de, err := fbo.blocks.GetDirtyEntry(ctx, lState, md.ReadOnly(), filePath)
+		if err != nil {
+			return err
+		}
+		if de.Type != File && de.Type != Exec {
+			return NotFileError{filePath}
+		}
+		infos, err := fbo.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md.ReadOnly(), filePath)
+		if err != nil {
+			return err
+		}
+		ptrs = make([]BlockPointer, 0, len(infos)+1)
+		ptrs = append(ptrs, de.BlockPointer)
+		for _, info := range infos {
+			ptrs = append(ptrs, info.BlockPointer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ptrs, nil
+}
+
 func (fbo *folderBranchOps) Write(
-	ctx context.Context, file Node, data []byte, off int64) (err error) {
-	fbo.log.CDebugf(ctx, "Write %s %d %d", getNodeIDStr(file),
-		len(data), off)
+	ctx context.Context, file Node, data []byte, off int64) error {
+	_, err := fbo.WriteWithFlags(ctx, file, data, off, 0)
+	return err
+}
+
+func (fbo *folderBranchOps) WriteWithFlags(
+	ctx context.Context, file Node, data []byte, off int64,
+	flags WriteFlags) (writtenOff int64, err error) {
+	fbo.log.CDebugf(ctx, "Write %s %d %d flags=%d", getNodeIDStr(file),
+		len(data), off, flags)
 	defer func() {
-		fbo.deferLog.CDebugf(ctx, "Write %s %d %d done: %+v",
-			getNodeIDStr(file), len(data), off, err)
+		fbo.deferLog.CDebugf(ctx, "Write %s %d %d done: off=%d %+v",
+			getNodeIDStr(file), len(data), off, writtenOff, err)
 	}()
 
 	err = fbo.checkNodeForWrite(ctx, file)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return runUnlessCanceled(ctx, func() error {
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call.
+	var actualOff int64
+	err = runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
+		err := fbo.waitForRemoteChangeMerged(ctx, lState)
+		if err != nil {
+			return err
+		}
+
 		// Get the MD for reading.  We won't modify it; we'll track the
 		// unref changes on the side, and put them into the MD during the
 		// sync.
@@ -3840,8 +4800,8 @@ func (fbo *folderBranchOps) Write(
 			return err
 		}
 
-		err = fbo.blocks.Write(
-			ctx, lState, md.ReadOnly(), file, data, off)
+		actualOff, err = fbo.blocks.Write(
+			ctx, lState, md.ReadOnly(), file, data, off, flags)
 		if err != nil {
 			return err
 		}
@@ -3850,6 +4810,10 @@ func (fbo *folderBranchOps) Write(
 		fbo.signalWrite()
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return actualOff, nil
 }
 
 func (fbo *folderBranchOps) Truncate(
@@ -3868,6 +4832,11 @@ func (fbo *folderBranchOps) Truncate(
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
+		err := fbo.waitForRemoteChangeMerged(ctx, lState)
+		if err != nil {
+			return err
+		}
+
 		// Get the MD for reading.  We won't modify it; we'll track the
 		// unref changes on the side, and put them into the MD during the
 		// sync.
@@ -3950,7 +4919,7 @@ func (fbo *folderBranchOps) setExLocked(
 	sao.setFinalPath(filePath)
 
 	dirCacheUndoFn := fbo.blocks.SetAttrInDirEntryInCache(
-		lState, filePath, de, sao.Attr)
+		lState, filePath, de, sao.getAttr())
 	return fbo.notifyAndSyncOrSignal(
 		ctx, lState, dirCacheUndoFn, []Node{file}, sao, md.ReadOnly())
 }
@@ -3974,6 +4943,107 @@ func (fbo *folderBranchOps) SetEx(
 		})
 }
 
+func (fbo *folderBranchOps) setModeLocked(
+	ctx context.Context, lState *lockState, node Node,
+	mode os.FileMode) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	nodePath, err := fbo.pathFromNodeForMDWriteLocked(lState, node)
+	if err != nil {
+		return err
+	}
+	if !nodePath.hasValidParent() {
+		// The TLF root has no parent directory entry to hold the
+		// mode.
+		return InvalidParentPathError{nodePath}
+	}
+
+	// Verify we have permission to write (no need to make a successor yet).
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+	if err != nil {
+		return err
+	}
+
+	de, err := fbo.blocks.GetDirtyEntryEvenIfDeleted(
+		ctx, lState, md.ReadOnly(), nodePath)
+	if err != nil {
+		return err
+	}
+
+	// If the file is a symlink, do nothing (to match ext4
+	// behavior).
+	if de.Type == Sym {
+		fbo.log.CDebugf(ctx, "Ignoring setmode on type %s", de.Type)
+		return nil
+	}
+
+	// Keep the type in sync with the user exec bit, for the sake of
+	// clients that only know about the type.
+	oldType := de.Type
+	if de.Type == File && mode&0100 != 0 {
+		de.Type = Exec
+	} else if de.Type == Exec && mode&0100 == 0 {
+		de.Type = File
+	}
+	if de.Mode == mode && de.Type == oldType {
+		// Like setex, treat this as a no-op to keep
+		// permissions-preserving rsyncs fast.
+		fbo.log.CDebugf(ctx, "Ignoring no-op setmode")
+		return nil
+	}
+	de.Mode = mode
+	de.Ctime = fbo.nowUnixNano()
+
+	parentPtr := nodePath.parentPath().tailPointer()
+	sao, err := newSetAttrOp(nodePath.tailName(), parentPtr,
+		modeAttr, nodePath.tailPointer())
+	if err != nil {
+		return err
+	}
+	sao.AddSelfUpdate(parentPtr)
+
+	// If the node has been unlinked, we can safely ignore this
+	// setmode.
+	if fbo.nodeCache.IsUnlinked(node) {
+		fbo.log.CDebugf(ctx, "Skipping setmode for a removed file %v",
+			nodePath.tailPointer())
+		fbo.blocks.UpdateCachedEntryAttributesOnRemovedFile(
+			ctx, lState, sao, de)
+		return nil
+	}
+
+	sao.setFinalPath(nodePath)
+
+	dirCacheUndoFn := fbo.blocks.SetAttrInDirEntryInCache(
+		lState, nodePath, de, sao.getAttr())
+	return fbo.notifyAndSyncOrSignal(
+		ctx, lState, dirCacheUndoFn, []Node{node}, sao, md.ReadOnly())
+}
+
+// SetMode implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetMode(
+	ctx context.Context, node Node, mode os.FileMode) (err error) {
+	fbo.log.CDebugf(ctx, "SetMode %s %s", getNodeIDStr(node), mode)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetMode %s %s done: %+v",
+			getNodeIDStr(node), mode, err)
+	}()
+
+	if mode&^os.ModePerm != 0 {
+		return errors.Errorf("Mode %s has non-permission bits set", mode)
+	}
+
+	err = fbo.checkNodeForWrite(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setModeLocked(ctx, lState, node, mode)
+		})
+}
+
 func (fbo *folderBranchOps) setMtimeLocked(
 	ctx context.Context, lState *lockState, file Node,
 	mtime *time.Time) error {
@@ -4020,7 +5090,7 @@ func (fbo *folderBranchOps) setMtimeLocked(
 	sao.setFinalPath(filePath)
 
 	dirCacheUndoFn := fbo.blocks.SetAttrInDirEntryInCache(
-		lState, filePath, de, sao.Attr)
+		lState, filePath, de, sao.getAttr())
 	return fbo.notifyAndSyncOrSignal(
 		ctx, lState, dirCacheUndoFn, []Node{file}, sao, md.ReadOnly())
 }
@@ -4040,13 +5110,159 @@ func (fbo *folderBranchOps) SetMtime(
 
 	err = fbo.checkNodeForWrite(ctx, file)
 	if err != nil {
-		return
+		return
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setMtimeLocked(ctx, lState, file, mtime)
+		})
+}
+
+// setXattrLocked sets the extended attribute `name` on `node` to
+// `value`, or removes it if `value` is nil.
+func (fbo *folderBranchOps) setXattrLocked(
+	ctx context.Context, lState *lockState, node Node, name string,
+	value []byte) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	nodePath, err := fbo.pathFromNodeForMDWriteLocked(lState, node)
+	if err != nil {
+		return err
+	}
+	if !nodePath.hasValidParent() {
+		// The TLF root has no parent directory entry to hold the
+		// attributes.
+		return InvalidParentPathError{nodePath}
+	}
+
+	// Verify we have permission to write (no need to make a successor yet).
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+	if err != nil {
+		return err
+	}
+
+	de, err := fbo.blocks.GetDirtyEntryEvenIfDeleted(
+		ctx, lState, md.ReadOnly(), nodePath)
+	if err != nil {
+		return err
+	}
+	de.Xattrs, err = withXattr(de.Xattrs, name, value)
+	if err != nil {
+		return err
+	}
+	de.Ctime = fbo.nowUnixNano()
+
+	parentPtr := nodePath.parentPath().tailPointer()
+	sao, err := newSetAttrOp(nodePath.tailName(), parentPtr,
+		xattrAttr, nodePath.tailPointer())
+	if err != nil {
+		return err
+	}
+	sao.AddSelfUpdate(parentPtr)
+
+	// If the node has been unlinked, we can safely ignore this
+	// setxattr.
+	if fbo.nodeCache.IsUnlinked(node) {
+		fbo.log.CDebugf(ctx, "Skipping setxattr for a removed file %v",
+			nodePath.tailPointer())
+		fbo.blocks.UpdateCachedEntryAttributesOnRemovedFile(
+			ctx, lState, sao, de)
+		return nil
+	}
+
+	sao.setFinalPath(nodePath)
+
+	dirCacheUndoFn := fbo.blocks.SetAttrInDirEntryInCache(
+		lState, nodePath, de, sao.getAttr())
+	return fbo.notifyAndSyncOrSignal(
+		ctx, lState, dirCacheUndoFn, []Node{node}, sao, md.ReadOnly())
+}
+
+func (fbo *folderBranchOps) setOrRemoveXattr(
+	ctx context.Context, node Node, name string, value []byte) (err error) {
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "SetXattr %s %s remove=%t",
+		getNodeIDStr(node), logName, value == nil)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetXattr %s %s remove=%t done: %+v",
+			getNodeIDStr(node), logName, value == nil, err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setXattrLocked(ctx, lState, node, name, value)
+		})
+}
+
+// SetXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetXattr(
+	ctx context.Context, node Node, name string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return fbo.setOrRemoveXattr(ctx, node, name, value)
+}
+
+// RemoveXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RemoveXattr(
+	ctx context.Context, node Node, name string) error {
+	return fbo.setOrRemoveXattr(ctx, node, name, nil)
+}
+
+// GetXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) GetXattr(
+	ctx context.Context, node Node, name string) (value []byte, err error) {
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "GetXattr %s %s", getNodeIDStr(node), logName)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetXattr %s %s done: %+v",
+			getNodeIDStr(node), logName, err)
+	}()
+
+	var de DirEntry
+	err = runUnlessCanceled(ctx, func() error {
+		de, err = fbo.statEntry(ctx, node)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := de.Xattrs[name]
+	if !ok {
+		return nil, NoSuchXattrError{name}
+	}
+	return append([]byte{}, value...), nil
+}
+
+// ListXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) ListXattr(
+	ctx context.Context, node Node) (names []string, err error) {
+	fbo.log.CDebugf(ctx, "ListXattr %s", getNodeIDStr(node))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ListXattr %s done: %+v",
+			getNodeIDStr(node), err)
+	}()
+
+	var de DirEntry
+	err = runUnlessCanceled(ctx, func() error {
+		de, err = fbo.statEntry(ctx, node)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
-		func(lState *lockState) error {
-			return fbo.setMtimeLocked(ctx, lState, file, mtime)
-		})
+	names = make([]string, 0, len(de.Xattrs))
+	for name := range de.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 type cleanupFn func(context.Context, *lockState, []BlockPointer, error)
@@ -4349,7 +5565,8 @@ func (fbo *folderBranchOps) syncAllLocked(
 			continue
 		}
 		file := fbo.nodeCache.PathFromNode(node)
-		fbo.log.CDebugf(ctx, "Syncing file %v (%s)", ref, file)
+		fbo.log.CDebugf(ctx, "Syncing file %v (%s)",
+			ref, fbo.config.RedactLogPath(file.String()))
 
 		// Start the sync for this dirty file.
 		doSync, stillDirty, fblock, newLbc, newBps, syncState, cleanup, err :=
@@ -4567,6 +5784,32 @@ func (fbo *folderBranchOps) FolderStatus(
 	return fbo.status.getStatus(ctx, &fbo.blocks)
 }
 
+// ClearFolderErrors implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) ClearFolderErrors(
+	ctx context.Context, folderBranch FolderBranch) error {
+	fbo.log.CDebugf(ctx, "ClearFolderErrors")
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	fbo.status.clearRecentErrs()
+	return nil
+}
+
+// recordSignificantErr remembers the given error in the folder
+// status, if it's one that the user should keep seeing until they
+// dismiss it.
+func (fbo *folderBranchOps) recordSignificantErr(err error) {
+	if err != nil && isSignificantFolderError(err) {
+		fbo.status.addRecentErr(err)
+	}
+}
+
+func (fbo *folderBranchOps) GetQuotaInfo(ctx context.Context) (
+	*kbfsblock.QuotaInfo, error) {
+	return nil, InvalidOpError{}
+}
+
 func (fbo *folderBranchOps) Status(
 	ctx context.Context) (
 	fbs KBFSStatus, updateChan <-chan StatusUpdate, err error) {
@@ -4575,16 +5818,38 @@ func (fbo *folderBranchOps) Status(
 
 // RegisterForChanges registers a single Observer to receive
 // notifications about this folder/branch.
-func (fbo *folderBranchOps) RegisterForChanges(obs Observer) error {
+func (fbo *folderBranchOps) RegisterForChanges(
+	folderBranch FolderBranch, obs Observer) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
 	// It's the caller's responsibility to make sure
 	// RegisterForChanges isn't called twice for the same Observer
 	fbo.observers.add(obs)
 	return nil
 }
 
+// RegisterForChangesWithFilter registers a single Observer to
+// receive notifications about this folder/branch that match
+// `filter`.
+func (fbo *folderBranchOps) RegisterForChangesWithFilter(
+	folderBranch FolderBranch, obs Observer, filter ObserverFilter) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	// It's the caller's responsibility to make sure
+	// RegisterForChanges isn't called twice for the same Observer
+	fbo.observers.addWithFilter(obs, filter)
+	return nil
+}
+
 // UnregisterFromChanges stops an Observer from getting notifications
 // about the folder/branch.
-func (fbo *folderBranchOps) UnregisterFromChanges(obs Observer) error {
+func (fbo *folderBranchOps) UnregisterFromChanges(
+	folderBranch FolderBranch, obs Observer) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
 	fbo.observers.remove(obs)
 	return nil
 }
@@ -4771,6 +6036,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 		changes = append(changes, NodeChange{
 			Node:       node,
 			DirUpdated: []string{realOp.NewName},
+			Type:       NodeChangeCreate,
 		})
 	case *rmOp:
 		node := fbo.nodeCache.Get(realOp.Dir.Ref.Ref())
@@ -4782,6 +6048,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 		changes = append(changes, NodeChange{
 			Node:       node,
 			DirUpdated: []string{realOp.OldName},
+			Type:       NodeChangeRemove,
 		})
 
 		// If this node exists, then the child node might exist too,
@@ -4795,6 +6062,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 			changes = append(changes, NodeChange{
 				Node:       oldNode,
 				DirUpdated: []string{realOp.OldName},
+				Type:       NodeChangeRename,
 			})
 		}
 		var newNode Node
@@ -4804,6 +6072,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 				changes = append(changes, NodeChange{
 					Node:       newNode,
 					DirUpdated: []string{realOp.NewName},
+					Type:       NodeChangeRename,
 				})
 			}
 		} else {
@@ -4817,8 +6086,10 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 
 		if oldNode != nil {
 			fbo.log.CDebugf(ctx, "notifyOneOp: rename %v from %s/%s to %s/%s",
-				realOp.Renamed, realOp.OldName, getNodeIDStr(oldNode),
-				realOp.NewName, getNodeIDStr(newNode))
+				realOp.Renamed, fbo.config.RedactLogPath(realOp.OldName),
+				getNodeIDStr(oldNode),
+				fbo.config.RedactLogPath(realOp.NewName),
+				getNodeIDStr(newNode))
 
 			if newNode == nil {
 				if childNode :=
@@ -4862,6 +6133,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 		changes = append(changes, NodeChange{
 			Node:        node,
 			FileUpdated: realOp.Writes,
+			Type:        NodeChangeWrite,
 		})
 	case *setAttrOp:
 		node := fbo.nodeCache.Get(realOp.Dir.Ref.Ref())
@@ -4869,7 +6141,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 			break
 		}
 		fbo.log.CDebugf(ctx, "notifyOneOp: setAttr %s for file %s in node %s",
-			realOp.Attr, realOp.Name, getNodeIDStr(node))
+			realOp.getAttr(), realOp.Name, getNodeIDStr(node))
 
 		p, err := fbo.pathFromNodeForRead(node)
 		if err != nil {
@@ -4887,6 +6159,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 
 		changes = append(changes, NodeChange{
 			Node: childNode,
+			Type: NodeChangeAttr,
 		})
 	case *GCOp:
 		// Unreferenced blocks in a GCOp mean that we shouldn't cache
@@ -4934,6 +6207,7 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 				changes = append(changes, NodeChange{
 					Node:       parentNode,
 					DirUpdated: []string{p.tailName()},
+					Type:       NodeChangeRemove,
 				})
 			}
 
@@ -5059,6 +6333,10 @@ func (fbo *folderBranchOps) applyMDUpdatesLocked(ctx context.Context,
 	// sync will put us into an unmerged state anyway and we'll
 	// require conflict resolution.
 	if fbo.blocks.GetState(lState) != cleanState {
+		if len(rmds) > 0 {
+			fbo.handleRemoteChangeWhileDirtyLocked(
+				ctx, lState, rmds[len(rmds)-1].Revision())
+		}
 		return errors.WithStack(NoUpdatesWhileDirtyError{})
 	}
 
@@ -5204,6 +6482,11 @@ func (fbo *folderBranchOps) setLatestMergedRevisionLocked(ctx context.Context, l
 func (fbo *folderBranchOps) getAndApplyMDUpdates(ctx context.Context,
 	lState *lockState, lockBeforeGet *keybase1.LockID,
 	applyFunc applyMDUpdatesFunc) error {
+	if fbo.isArchived() {
+		// Archived branches stay at their revision forever.
+		return nil
+	}
+
 	// first look up all MD revisions newer than my current head
 	start := fbo.getLatestMergedRevision(lState) + 1
 	rmds, err := getMergedMDUpdates(ctx,
@@ -5399,22 +6682,97 @@ func (fbo *folderBranchOps) unstageLocked(ctx context.Context,
 		})
 }
 
-// TODO: remove once we have automatic conflict resolution
-func (fbo *folderBranchOps) UnstageForTesting(
-	ctx context.Context, folderBranch FolderBranch) (err error) {
-	fbo.log.CDebugf(ctx, "UnstageForTesting")
+// unmergedChangesLocked summarizes the unmerged revisions of this
+// folder-branch, if it's staged.
+func (fbo *folderBranchOps) unmergedChangesLocked(
+	ctx context.Context, lState *lockState) (UnmergedChanges, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if fbo.isMasterBranchLocked(lState) {
+		return UnmergedChanges{}, nil
+	}
+
+	_, unmergedRmds, err := fbo.getUnmergedMDUpdatesLocked(ctx, lState)
+	if err != nil {
+		return UnmergedChanges{}, err
+	}
+	if len(unmergedRmds) == 0 {
+		return UnmergedChanges{}, nil
+	}
+
+	changes := UnmergedChanges{
+		BranchID: fbo.bid,
+		First:    unmergedRmds[0].Revision(),
+		Last:     unmergedRmds[len(unmergedRmds)-1].Revision(),
+	}
+
+	chains, err := newCRChainsForIRMDs(
+		ctx, fbo.config.Codec(), unmergedRmds, &fbo.blocks, false)
+	if err != nil {
+		return UnmergedChanges{}, err
+	}
+	var changed []*crChain
+	for _, chain := range chains.byOriginal {
+		if len(chain.ops) > 0 {
+			changed = append(changed, chain)
+		}
+	}
+	// Look up the current paths of the changed nodes; this sets
+	// the final path of each op in the chains.  Chains whose paths
+	// can't be found are still reported below, with an unknown path.
+	_, err = chains.getPaths(
+		ctx, &fbo.blocks, fbo.log, fbo.nodeCache, true)
+	if err != nil {
+		return UnmergedChanges{}, err
+	}
+	for _, chain := range changed {
+		summary := &crChainSummary{}
+		for _, op := range chain.ops {
+			summary.Ops = append(summary.Ops, op.String())
+		}
+		if p := chain.ops[0].getFinalPath(); p.isValid() {
+			summary.Path = p.String()
+		} else {
+			summary.Path = fmt.Sprintf("Unknown path: %v", chain.mostRecent)
+		}
+		changes.Changes = append(changes.Changes, summary)
+	}
+	sort.Slice(changes.Changes, func(i, j int) bool {
+		return changes.Changes[i].Path < changes.Changes[j].Path
+	})
+	return changes, nil
+}
+
+// GetUnmergedChanges implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetUnmergedChanges(
+	ctx context.Context, folderBranch FolderBranch) (
+	changes UnmergedChanges, err error) {
+	fbo.log.CDebugf(ctx, "GetUnmergedChanges")
 	defer func() {
-		fbo.deferLog.CDebugf(ctx, "UnstageForTesting done: %+v", err)
+		fbo.deferLog.CDebugf(ctx, "GetUnmergedChanges done: %+v", err)
 	}()
 
 	if folderBranch != fbo.folderBranch {
-		return WrongOpsError{fbo.folderBranch, folderBranch}
+		return UnmergedChanges{}, WrongOpsError{fbo.folderBranch, folderBranch}
 	}
 
+	lState := makeFBOLockState()
+	fbo.mdWriterLock.Lock(lState)
+	defer fbo.mdWriterLock.Unlock(lState)
+	return fbo.unmergedChangesLocked(ctx, lState)
+}
+
+// unstage discards the unmerged branch of this folder-branch, if
+// any.  If `check` is non-nil, it is called with mdWriterLock held
+// before anything is discarded, and unstaging is aborted if it
+// returns an error.
+func (fbo *folderBranchOps) unstage(ctx context.Context,
+	check func(ctx context.Context, lState *lockState) error) error {
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
-		if fbo.isMasterBranch(lState) {
+		if fbo.isMasterBranch(lState) && check == nil {
 			// no-op
 			return nil
 		}
@@ -5430,11 +6788,19 @@ func (fbo *folderBranchOps) UnstageForTesting(
 		c := make(chan error, 1)
 		freshCtx, cancel := fbo.newCtxWithFBOID()
 		defer cancel()
-		fbo.log.CDebugf(freshCtx, "Launching new context for UnstageForTesting")
+		fbo.log.CDebugf(freshCtx, "Launching new context for unstaging")
 		go func() {
 			lState := makeFBOLockState()
 			c <- fbo.doMDWriteWithRetry(ctx, lState,
 				func(lState *lockState) error {
+					if check != nil {
+						if err := check(freshCtx, lState); err != nil {
+							return err
+						}
+					}
+					if fbo.isMasterBranchLocked(lState) {
+						return nil
+					}
 					return fbo.unstageLocked(freshCtx, lState)
 				})
 		}()
@@ -5448,6 +6814,59 @@ func (fbo *folderBranchOps) UnstageForTesting(
 	})
 }
 
+// UnstageForTesting implements the KBFSOps interface for
+// folderBranchOps.
+// TODO: remove once we have automatic conflict resolution
+func (fbo *folderBranchOps) UnstageForTesting(
+	ctx context.Context, folderBranch FolderBranch) (err error) {
+	fbo.log.CDebugf(ctx, "UnstageForTesting")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "UnstageForTesting done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.unstage(ctx, nil)
+}
+
+// Unstage implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) Unstage(ctx context.Context,
+	folderBranch FolderBranch, expected UnmergedChanges) (err error) {
+	fbo.log.CDebugf(ctx, "Unstage %s (revisions %d-%d)",
+		expected.BranchID, expected.First, expected.Last)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "Unstage done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.unstage(ctx, func(ctx context.Context, lState *lockState) error {
+		// Only compare the branch and revision range, since the
+		// paths in the summary depend on the node cache.
+		actual := UnmergedChanges{BranchID: fbo.bid}
+		if !fbo.isMasterBranchLocked(lState) {
+			_, unmergedRmds, err := fbo.getUnmergedMDUpdatesLocked(
+				ctx, lState)
+			if err != nil {
+				return err
+			}
+			if len(unmergedRmds) > 0 {
+				actual.First = unmergedRmds[0].Revision()
+				actual.Last = unmergedRmds[len(unmergedRmds)-1].Revision()
+			}
+		}
+		if actual.BranchID != expected.BranchID ||
+			actual.First != expected.First || actual.Last != expected.Last {
+			return UnmergedChangesChangedError{expected, actual}
+		}
+		return nil
+	})
+}
+
 // mdWriterLock must be taken by the caller.
 func (fbo *folderBranchOps) rekeyLocked(ctx context.Context,
 	lState *lockState, promptPaper bool) (res RekeyResult, err error) {
@@ -5458,6 +6877,15 @@ func (fbo *folderBranchOps) rekeyLocked(ctx context.Context,
 
 	fbo.mdWriterLock.AssertLocked(lState)
 
+	if fbo.isArchived() {
+		fbo.log.CDebugf(ctx, "Archived branches can't be rekeyed")
+		return RekeyResult{}, nil
+	}
+	if fbo.isOpenedReadOnly() {
+		fbo.log.CDebugf(ctx, "Folders opened read-only can't be rekeyed")
+		return RekeyResult{}, nil
+	}
+
 	if !fbo.isMasterBranchLocked(lState) {
 		return RekeyResult{}, errors.New("can't rekey while staged")
 	}
@@ -5629,6 +7057,11 @@ func (fbo *folderBranchOps) SyncFromServer(ctx context.Context,
 		return WrongOpsError{fbo.folderBranch, folderBranch}
 	}
 
+	if fbo.isArchived() {
+		// Nothing can change on an archived branch.
+		return nil
+	}
+
 	lState := makeFBOLockState()
 
 	// Make sure everything outstanding syncs to disk at least.
@@ -5972,7 +7405,8 @@ func (fbo *folderBranchOps) registerAndWaitForUpdates() {
 					// skip the back-off timer and continue directly to next
 					// registerForUpdates
 					return nil
-				case kbfsmd.NewMetadataVersionError:
+				case kbfsmd.NewMetadataVersionError,
+					NewMDFormatVersionError:
 					fbo.log.CDebugf(ctx, "Abandoning updates since we can't "+
 						"read the newest metadata: %+v", err)
 					fbo.status.setPermErr(err)
@@ -6055,6 +7489,54 @@ func (fbo *folderBranchOps) registerForUpdates(ctx context.Context) (
 	return fbo.config.MDServer().RegisterForUpdate(ctx, fbo.id(), currRev)
 }
 
+// waitForDownloadsEnabled blocks while the network mode doesn't allow
+// downloads, so that remote updates aren't fetched in upload-only
+// mode.
+func (fbo *folderBranchOps) waitForDownloadsEnabled(
+	ctx context.Context) error {
+	logged := false
+	for !fbo.config.NetworkMode().downloadsEnabled() {
+		if !logged {
+			fbo.log.CDebugf(ctx, "Holding back updates while downloads "+
+				"are disabled")
+			logged = true
+		}
+		select {
+		case <-time.After(networkModeRecheckInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if logged {
+		fbo.log.CDebugf(ctx, "Downloads re-enabled; processing updates")
+	}
+	return nil
+}
+
+// waitForMeteredUpdateInterval delays fetching remote updates on a
+// metered network, so that at most one batch of updates is fetched
+// every meteredUpdateInterval.
+func (fbo *folderBranchOps) waitForMeteredUpdateInterval(
+	ctx context.Context, lastUpdate time.Time) error {
+	if lastUpdate.IsZero() || !fbo.config.NetworkConstraints().Metered {
+		return nil
+	}
+	wait := lastUpdate.Add(meteredUpdateInterval).Sub(
+		fbo.config.Clock().Now())
+	if wait <= 0 {
+		return nil
+	}
+	fbo.log.CDebugf(ctx, "Delaying updates by %s on a metered network", wait)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (fbo *folderBranchOps) waitForAndProcessUpdates(
 	ctx context.Context, lastUpdate time.Time,
 	updateChan <-chan error) (currUpdate time.Time, err error) {
@@ -6073,6 +7555,14 @@ func (fbo *folderBranchOps) waitForAndProcessUpdates(
 			if err != nil {
 				return time.Time{}, err
 			}
+			err = fbo.waitForDownloadsEnabled(ctx)
+			if err != nil {
+				return time.Time{}, err
+			}
+			err = fbo.waitForMeteredUpdateInterval(ctx, lastUpdate)
+			if err != nil {
+				return time.Time{}, err
+			}
 			// Getting and applying the updates requires holding
 			// locks, so make sure it doesn't take too long.
 			ctx, cancel := context.WithTimeout(ctx, backgroundTaskTimeout)
@@ -6720,6 +8210,170 @@ func (fbo *folderBranchOps) GetUpdateHistory(ctx context.Context,
 	return history, nil
 }
 
+// getEntryAtRevision looks up the entry at the given path, as of
+// the given MD, by following the names of the path from the root of
+// the folder.  It returns the path of the entry as of that MD, and
+// false if there was no such entry.
+func (fbo *folderBranchOps) getEntryAtRevision(ctx context.Context,
+	rmd ImmutableRootMetadata, p path) (
+	de DirEntry, entryPath path, ok bool, err error) {
+	de = rmd.data.Dir
+	entryPath = path{
+		FolderBranch: p.FolderBranch,
+		path: []pathNode{{
+			BlockPointer: de.BlockPointer,
+			Name:         p.path[0].Name,
+		}},
+	}
+	for _, pn := range p.path[1:] {
+		if de.Type != Dir {
+			return DirEntry{}, path{}, false, nil
+		}
+		dblock, err := fbo.blocks.GetCleanDirBlock(
+			ctx, rmd, entryPath.tailPointer(), entryPath)
+		if err != nil {
+			return DirEntry{}, path{}, false, err
+		}
+		de, ok = dblock.Children[pn.Name]
+		if !ok {
+			return DirEntry{}, path{}, false, nil
+		}
+		entryPath = entryPath.ChildPath(pn.Name, de.BlockPointer)
+	}
+	return de, entryPath, true, nil
+}
+
+// GetNodeHistory implements the KBFSOps interface for folderBranchOps
+func (fbo *folderBranchOps) GetNodeHistory(ctx context.Context, node Node) (
+	history []NodeRevision, err error) {
+	fbo.log.CDebugf(ctx, "GetNodeHistory %s", getNodeIDStr(node))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetNodeHistory %s done: %+v",
+			getNodeIDStr(node), err)
+	}()
+
+	err = fbo.checkNode(node)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := fbo.pathFromNodeForRead(node)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify we have permission to read.
+	lState := makeFBOLockState()
+	_, err = fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+
+	rmds, err := getMergedMDUpdates(ctx, fbo.config, fbo.id(),
+		kbfsmd.RevisionInitial, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	writerNames := make(map[keybase1.UserOrTeamID]libkb.NormalizedUsername)
+	var prev DirEntry
+	for _, rmd := range rmds {
+		de, _, ok, err := fbo.getEntryAtRevision(ctx, rmd, p)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Start over if the node shows up again later.
+			prev = DirEntry{}
+			continue
+		}
+		if de.BlockPointer == prev.BlockPointer &&
+			de.EntryInfo == prev.EntryInfo {
+			// This revision didn't change the node.
+			continue
+		}
+		prev = de
+
+		id := lastWriterOfEntry(de)
+		writer, ok := writerNames[id]
+		if !ok && id.IsUser() {
+			writer, err = fbo.config.KBPKI().GetNormalizedUsername(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			writerNames[id] = writer
+		}
+		history = append(history, NodeRevision{
+			Revision:         rmd.Revision(),
+			Time:             time.Unix(0, de.Mtime),
+			WriterUnverified: writer,
+			Size:             de.Size,
+		})
+	}
+	return history, nil
+}
+
+// ReadAtRevision implements the KBFSOps interface for folderBranchOps
+func (fbo *folderBranchOps) ReadAtRevision(ctx context.Context, file Node,
+	rev kbfsmd.Revision, dest []byte, off int64) (n int64, err error) {
+	fbo.log.CDebugf(ctx, "ReadAtRevision %s %d %d %d", getNodeIDStr(file),
+		rev, len(dest), off)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ReadAtRevision %s %d %d %d (n=%d) "+
+			"done: %+v", getNodeIDStr(file), rev, len(dest), off, n, err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return 0, err
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call, and end up in a read/write race
+	// with the caller.
+	var bytesRead int64
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+
+		// Verify we have permission to read.
+		_, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+
+		rmd, err := getSingleMD(ctx, fbo.config, fbo.id(),
+			kbfsmd.NullBranchID, rev, kbfsmd.Merged, nil)
+		if err != nil {
+			return err
+		}
+
+		de, entryPath, ok, err := fbo.getEntryAtRevision(ctx, rmd, filePath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return NoSuchNameError{filePath.tailName()}
+		}
+		if de.Type != File && de.Type != Exec {
+			return NotFileError{filePath}
+		}
+
+		bytesRead, err = fbo.blocks.ReadClean(
+			ctx, rmd.ReadOnly(), entryPath, dest, off)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return bytesRead, nil
+}
+
 // GetEditHistory implements the KBFSOps interface for folderBranchOps
 func (fbo *folderBranchOps) GetEditHistory(ctx context.Context,
 	folderBranch FolderBranch) (edits TlfWriterEdits, err error) {
@@ -6746,6 +8400,25 @@ func (fbo *folderBranchOps) PushStatusChange() {
 	fbo.config.KBFSOps().PushStatusChange()
 }
 
+// cachedPrivateNames returns the names of all the entries (below the
+// root) of this folder that are currently cached in memory, or nil
+// for public folders.
+func (fbo *folderBranchOps) cachedPrivateNames() []string {
+	if fbo.folderBranch.Tlf.Type() == tlf.Public {
+		return nil
+	}
+
+	var names []string
+	for _, n := range fbo.nodeCache.AllNodes() {
+		p := fbo.nodeCache.PathFromNode(n)
+		if len(p.path) < 2 {
+			continue
+		}
+		names = append(names, p.tailName())
+	}
+	return names
+}
+
 // ClearPrivateFolderMD implements the KBFSOps interface for
 // folderBranchOps.
 func (fbo *folderBranchOps) ClearPrivateFolderMD(ctx context.Context) {
@@ -6788,9 +8461,31 @@ func (fbo *folderBranchOps) ClearPrivateFolderMD(ctx context.Context) {
 	fbo.hasBeenCleared = true
 }
 
+// ResetForUser implements the KBFSOps interface for folderBranchOps.
+// A single folder can't reset itself, so this is a no-op;
+// KBFSOpsStandard does the actual reset.
+func (fbo *folderBranchOps) ResetForUser(
+	ctx context.Context, uid keybase1.UID) bool {
+	return false
+}
+
+// AuditLocalPlaintext implements the KBFSOps interface for
+// folderBranchOps, looking only for the names cached by this folder.
+func (fbo *folderBranchOps) AuditLocalPlaintext(ctx context.Context) (
+	[]LocalPlaintextLeak, error) {
+	return auditLocalPlaintext(
+		ctx, localDataRoots(fbo.config), fbo.cachedPrivateNames())
+}
+
 // ForceFastForward implements the KBFSOps interface for
 // folderBranchOps.
 func (fbo *folderBranchOps) ForceFastForward(ctx context.Context) {
+	if fbo.isArchived() {
+		// Archived branches are never fast-forwarded; their head
+		// is set again on the next access.
+		return
+	}
+
 	lState := makeFBOLockState()
 	fbo.headLock.RLock(lState)
 	defer fbo.headLock.RUnlock(lState)
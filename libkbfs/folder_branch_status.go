@@ -5,64 +5,237 @@
 package libkbfs
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
+// StatusSchemaVersion is the version of the JSON encoding of
+// FolderBranchStatus and KBFSStatus.  New fields may be added to
+// either struct without changing the version, so decoders must
+// ignore fields they don't know about.  Renaming or removing a
+// field, or changing its type or meaning, requires bumping the
+// version.  Statuses encoded before versioning was introduced have a
+// SchemaVersion of 0, and are otherwise identical to version 1.
+const StatusSchemaVersion = 1
+
 // FolderBranchStatus is a simple data structure describing the
 // current status of a particular folder-branch.  It is suitable for
-// encoding directly as JSON.
+// encoding directly as JSON.  The JSON field names are part of the
+// versioned schema described by StatusSchemaVersion, and must not
+// change even if the Go field names do.
 type FolderBranchStatus struct {
-	Staged              bool
-	BranchID            string
-	HeadWriter          libkb.NormalizedUsername
-	DiskUsage           uint64
-	RekeyPending        bool
-	LatestKeyGeneration kbfsmd.KeyGen
-	FolderID            string
-	Revision            kbfsmd.Revision
-	MDVersion           kbfsmd.MetadataVer
-	RootBlockID         string
-	SyncEnabled         bool
-	PrefetchStatus      string
-	UsageBytes          int64
-	LimitBytes          int64
-	GitUsageBytes       int64
-	GitLimitBytes       int64
+	SchemaVersion       int                      `json:"SchemaVersion"`
+	Staged              bool                     `json:"Staged"`
+	BranchID            string                   `json:"BranchID"`
+	HeadWriter          libkb.NormalizedUsername `json:"HeadWriter"`
+	DiskUsage           uint64                   `json:"DiskUsage"`
+	RekeyPending        bool                     `json:"RekeyPending"`
+	LatestKeyGeneration kbfsmd.KeyGen            `json:"LatestKeyGeneration"`
+	FolderID            string                   `json:"FolderID"`
+	Revision            kbfsmd.Revision          `json:"Revision"`
+	MDVersion           kbfsmd.MetadataVer       `json:"MDVersion"`
+	RootBlockID         string                   `json:"RootBlockID"`
+	SyncEnabled         bool                     `json:"SyncEnabled"`
+	PrefetchStatus      string                   `json:"PrefetchStatus"`
+	UsageBytes          int64                    `json:"UsageBytes"`
+	LimitBytes          int64                    `json:"LimitBytes"`
+	GitUsageBytes       int64                    `json:"GitUsageBytes"`
+	GitLimitBytes       int64                    `json:"GitLimitBytes"`
+
+	// FolderUsageBytes and FolderGitUsageBytes are the parts of
+	// UsageBytes and GitUsageBytes used by this folder.
+	FolderUsageBytes    int64 `json:"FolderUsageBytes"`
+	FolderGitUsageBytes int64 `json:"FolderGitUsageBytes"`
+
+	// ExcludePatterns are the patterns of paths in this folder that
+	// aren't synced, prefetched or mirrored.
+	ExcludePatterns []string `json:"ExcludePatterns,omitempty"`
+
+	// Sync describes how much of the folder is stored locally, if
+	// SyncEnabled.
+	Sync *FolderSyncStatus `json:"Sync,omitempty"`
 
 	// DirtyPaths are files that have been written, but not flushed.
 	// They do not represent unstaged changes in your local instance.
-	DirtyPaths []string
+	DirtyPaths []string `json:"DirtyPaths"`
 
 	// If we're in the staged state, these summaries show the
 	// diverging operations per-file
-	Unmerged []*crChainSummary
-	Merged   []*crChainSummary
+	Unmerged []*crChainSummary `json:"Unmerged"`
+	Merged   []*crChainSummary `json:"Merged"`
+
+	Journal *TLFJournalStatus `json:"Journal,omitempty"`
 
-	Journal *TLFJournalStatus `json:",omitempty"`
+	PermanentErr string `json:"PermanentErr,omitempty"`
+
+	// RecentErrors are the most recent significant errors in this
+	// folder-branch, oldest first, until they are cleared via
+	// KBFSOps.ClearFolderErrors.
+	RecentErrors []FolderErrorStatus `json:"RecentErrors,omitempty"`
+}
 
-	PermanentErr string `json:",omitempty"`
+// FolderErrorStatus describes a significant error (e.g., over quota,
+// needing a rekey, or a failed conflict resolution) that happened in
+// a folder-branch, so that a GUI can keep displaying it until the
+// user dismisses it.
+type FolderErrorStatus struct {
+	// Time is when the error last happened.
+	Time time.Time `json:"Time"`
+	// Error is the error string.
+	Error string `json:"Error"`
+	// Count is the number of consecutive times this error happened.
+	Count int `json:"Count"`
 }
 
 // KBFSStatus represents the content of the top-level status file. It is
-// suitable for encoding directly as JSON.
+// suitable for encoding directly as JSON, following the same
+// versioned schema rules as FolderBranchStatus.  In the JSON
+// encoding, FailingServices maps each service to its error string.
 // TODO: implement magical status update like FolderBranchStatus
 type KBFSStatus struct {
-	CurrentUser     string
-	IsConnected     bool
-	UsageBytes      int64
-	LimitBytes      int64
-	GitUsageBytes   int64
-	GitLimitBytes   int64
-	FailingServices map[string]error
-	JournalServer   *JournalServerStatus            `json:",omitempty"`
-	DiskCacheStatus map[string]DiskBlockCacheStatus `json:",omitempty"`
+	SchemaVersion   int                             `json:"SchemaVersion"`
+	CurrentUser     string                          `json:"CurrentUser"`
+	IsConnected     bool                            `json:"IsConnected"`
+	ConnectionState ConnectionState                 `json:"ConnectionState"`
+	UsageBytes      int64                           `json:"UsageBytes"`
+	LimitBytes      int64                           `json:"LimitBytes"`
+	GitUsageBytes   int64                           `json:"GitUsageBytes"`
+	GitLimitBytes   int64                           `json:"GitLimitBytes"`
+	FailingServices map[string]error                `json:"-"`
+	JournalServer   *JournalServerStatus            `json:"JournalServer,omitempty"`
+	DiskCacheStatus map[string]DiskBlockCacheStatus `json:"DiskCacheStatus,omitempty"`
+	// ConnectionProxy describes how server connections are made,
+	// if not directly.
+	ConnectionProxy string `json:"ConnectionProxy,omitempty"`
+	// Anonymized is true when all server connections go through
+	// Tor.
+	Anonymized bool `json:"Anonymized,omitempty"`
+}
+
+// FolderSyncStatus describes the progress of syncing a folder to
+// disk, for offline access.
+type FolderSyncStatus struct {
+	// SyncedBytes is how many bytes of the folder's blocks are in
+	// the sync cache.  It can exceed TotalBytes when the cache
+	// still holds blocks from older revisions.
+	SyncedBytes uint64 `json:"SyncedBytes"`
+	// TotalBytes is the disk usage of the folder's latest revision,
+	// which is roughly how many bytes a full sync needs.
+	TotalBytes uint64 `json:"TotalBytes"`
+	// Done is true once every block of the latest revision has been
+	// fetched.
+	Done bool `json:"Done"`
+}
+
+// kbfsStatusJSON is the JSON representation of KBFSStatus.  Errors
+// don't survive a round trip through encoding/json, so the failing
+// services are encoded as strings instead, with a nil error encoded
+// as null.
+type kbfsStatusJSON struct {
+	kbfsStatusNoMethods
+	FailingServices map[string]*string `json:"FailingServices"`
+}
+
+// kbfsStatusNoMethods has the same fields as KBFSStatus, without the
+// JSON methods, to avoid infinite recursion.
+type kbfsStatusNoMethods KBFSStatus
+
+// MarshalJSON implements the json.Marshaler interface for KBFSStatus.
+func (s KBFSStatus) MarshalJSON() ([]byte, error) {
+	var failing map[string]*string
+	if s.FailingServices != nil {
+		failing = make(map[string]*string, len(s.FailingServices))
+		for service, err := range s.FailingServices {
+			if err == nil {
+				failing[service] = nil
+				continue
+			}
+			errStr := err.Error()
+			failing[service] = &errStr
+		}
+	}
+	return json.Marshal(kbfsStatusJSON{kbfsStatusNoMethods(s), failing})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for
+// KBFSStatus.  Failing services are decoded as plain errors carrying
+// the original error strings.
+func (s *KBFSStatus) UnmarshalJSON(data []byte) error {
+	var sj kbfsStatusJSON
+	if err := json.Unmarshal(data, &sj); err != nil {
+		return err
+	}
+	*s = KBFSStatus(sj.kbfsStatusNoMethods)
+	if sj.FailingServices != nil {
+		s.FailingServices = make(map[string]error, len(sj.FailingServices))
+		for service, errStr := range sj.FailingServices {
+			if errStr == nil {
+				s.FailingServices[service] = nil
+				continue
+			}
+			s.FailingServices[service] = errors.New(*errStr)
+		}
+	}
+	return nil
+}
+
+// DecodeFolderBranchStatus decodes a JSON-encoded FolderBranchStatus,
+// such as the contents of a folder's status file.  It returns a
+// StatusSchemaVersionError if the status was encoded with a newer,
+// incompatible schema.
+func DecodeFolderBranchStatus(data []byte) (FolderBranchStatus, error) {
+	var fbs FolderBranchStatus
+	if err := json.Unmarshal(data, &fbs); err != nil {
+		return FolderBranchStatus{}, err
+	}
+	if fbs.SchemaVersion > StatusSchemaVersion {
+		return FolderBranchStatus{}, StatusSchemaVersionError{
+			fbs.SchemaVersion}
+	}
+	return fbs, nil
+}
+
+// DecodeKBFSStatus decodes a JSON-encoded KBFSStatus, such as the
+// contents of the top-level status file.  It returns a
+// StatusSchemaVersionError if the status was encoded with a newer,
+// incompatible schema.
+func DecodeKBFSStatus(data []byte) (KBFSStatus, error) {
+	var status KBFSStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return KBFSStatus{}, err
+	}
+	if status.SchemaVersion > StatusSchemaVersion {
+		return KBFSStatus{}, StatusSchemaVersionError{status.SchemaVersion}
+	}
+	return status, nil
+}
+
+// maxRecentFolderErrors is the maximum number of significant errors
+// remembered per folder-branch.
+const maxRecentFolderErrors = 10
+
+// isSignificantFolderError returns true if the given error is one
+// that a user should keep seeing until they dismiss it, rather than
+// a transient failure of a single operation.
+func isSignificantFolderError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case kbfsblock.ServerErrorOverQuota, NeedSelfRekeyError,
+		NeedOtherRekeyError, CRWrapError, CRAbandonStagedBranchError,
+		*ErrDiskLimitTimeout:
+		return true
+	default:
+		return false
+	}
 }
 
 // StatusUpdate is a dummy type used to indicate status has been updated.
@@ -78,6 +251,7 @@ type folderBranchStatusKeeper struct {
 	dataMutex  sync.Mutex
 	md         ImmutableRootMetadata
 	permErr    error
+	recentErrs []FolderErrorStatus
 	dirtyNodes map[NodeID]Node
 	unmerged   []*crChainSummary
 	merged     []*crChainSummary
@@ -137,6 +311,43 @@ func (fbsk *folderBranchStatusKeeper) setPermErr(err error) {
 	fbsk.signalChangeLocked()
 }
 
+// addRecentErr records a significant error, evicting the oldest one
+// if there are already maxRecentFolderErrors of them.  Consecutive
+// repeats of the same error are collapsed into a single entry.
+func (fbsk *folderBranchStatusKeeper) addRecentErr(err error) {
+	fbsk.dataMutex.Lock()
+	defer fbsk.dataMutex.Unlock()
+	now := fbsk.config.Clock().Now()
+	errStr := err.Error()
+	if n := len(fbsk.recentErrs); n > 0 &&
+		fbsk.recentErrs[n-1].Error == errStr {
+		fbsk.recentErrs[n-1].Time = now
+		fbsk.recentErrs[n-1].Count++
+	} else {
+		if n == maxRecentFolderErrors {
+			fbsk.recentErrs = append(fbsk.recentErrs[:0],
+				fbsk.recentErrs[1:]...)
+		}
+		fbsk.recentErrs = append(fbsk.recentErrs, FolderErrorStatus{
+			Time:  now,
+			Error: errStr,
+			Count: 1,
+		})
+	}
+	fbsk.signalChangeLocked()
+}
+
+// clearRecentErrs forgets all recorded significant errors.
+func (fbsk *folderBranchStatusKeeper) clearRecentErrs() {
+	fbsk.dataMutex.Lock()
+	defer fbsk.dataMutex.Unlock()
+	if len(fbsk.recentErrs) == 0 {
+		return
+	}
+	fbsk.recentErrs = nil
+	fbsk.signalChangeLocked()
+}
+
 func (fbsk *folderBranchStatusKeeper) addNode(m map[NodeID]Node, n Node) bool {
 	fbsk.dataMutex.Lock()
 	defer fbsk.dataMutex.Unlock()
@@ -189,7 +400,7 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 	fbsk.updateMutex.Lock()
 	defer fbsk.updateMutex.Unlock()
 
-	var fbs FolderBranchStatus
+	fbs := FolderBranchStatus{SchemaVersion: StatusSchemaVersion}
 
 	tlfID := tlf.NullID
 	if fbsk.md != (ImmutableRootMetadata{}) {
@@ -209,9 +420,21 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 		fbs.Revision = fbsk.md.Revision()
 		fbs.MDVersion = fbsk.md.Version()
 		fbs.SyncEnabled = fbsk.config.IsSyncedTlf(fbsk.md.TlfID())
+		fbs.ExcludePatterns =
+			fbsk.config.TlfExcludePatterns(fbsk.md.TlfID()).Lines()
 		prefetchStatus := fbsk.config.PrefetchStatus(ctx, fbsk.md.TlfID(),
 			fbsk.md.Data().Dir.BlockPointer)
 		fbs.PrefetchStatus = prefetchStatus.String()
+		if fbs.SyncEnabled {
+			fbs.Sync = &FolderSyncStatus{
+				TotalBytes: fbs.DiskUsage,
+				Done:       prefetchStatus == FinishedPrefetch,
+			}
+			dbc, ok := fbsk.config.DiskBlockCache().(*diskBlockCacheWrapped)
+			if ok {
+				fbs.Sync.SyncedBytes = dbc.syncedBytes(fbsk.md.TlfID())
+			}
+		}
 		fbs.RootBlockID = fbsk.md.Data().Dir.BlockPointer.ID.String()
 
 		if fbsk.quotaUsage == nil {
@@ -244,6 +467,8 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 		fbs.LimitBytes = limitBytes
 		fbs.GitUsageBytes = gitUsageBytes
 		fbs.GitLimitBytes = gitLimitBytes
+		fbs.FolderUsageBytes, fbs.FolderGitUsageBytes =
+			fbsk.quotaUsage.GetFolderUsage(fbsk.md.TlfID())
 	}
 
 	fbs.DirtyPaths = fbsk.convertNodesToPathsLocked(fbsk.dirtyNodes)
@@ -255,6 +480,12 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 		fbs.PermanentErr = fbsk.permErr.Error()
 	}
 
+	if len(fbsk.recentErrs) > 0 {
+		fbs.RecentErrors = make(
+			[]FolderErrorStatus, len(fbsk.recentErrs))
+		copy(fbs.RecentErrors, fbsk.recentErrs)
+	}
+
 	return fbs, fbsk.updateChan, tlfID, nil
 }
 
@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -176,3 +177,117 @@ func TestFBStatusAllFields(t *testing.T) {
 	require.Equal(t, int64(20), status.GitUsageBytes)
 	require.Equal(t, int64(2000), status.GitLimitBytes)
 }
+
+func TestFBStatusJSONRoundTrip(t *testing.T) {
+	fbs := FolderBranchStatus{
+		SchemaVersion: StatusSchemaVersion,
+		Staged:        true,
+		HeadWriter:    "alice",
+		Revision:      kbfsmd.Revision(10),
+		DirtyPaths:    []string{"/keybase/private/alice/a"},
+		Unmerged:      []*crChainSummary{{Path: "a", Ops: []string{"write"}}},
+		PermanentErr:  "bad",
+	}
+	data, err := json.Marshal(fbs)
+	require.NoError(t, err)
+	decoded, err := DecodeFolderBranchStatus(data)
+	require.NoError(t, err)
+	require.Equal(t, fbs, decoded)
+
+	// Unknown fields from newer, compatible encoders are ignored.
+	decoded, err = DecodeFolderBranchStatus(
+		[]byte(`{"SchemaVersion": 1, "Revision": 5, "NewField": true}`))
+	require.NoError(t, err)
+	require.Equal(t, kbfsmd.Revision(5), decoded.Revision)
+
+	_, err = DecodeFolderBranchStatus(
+		[]byte(`{"SchemaVersion": 1000}`))
+	require.IsType(t, StatusSchemaVersionError{}, err)
+}
+
+func TestKBFSStatusJSONRoundTrip(t *testing.T) {
+	status := KBFSStatus{
+		SchemaVersion: StatusSchemaVersion,
+		CurrentUser:   "alice",
+		IsConnected:   true,
+		FailingServices: map[string]error{
+			"mdserver": errors.New("connection refused"),
+		},
+		ConnectionState: ConnectionOffline,
+	}
+	data, err := json.Marshal(status)
+	require.NoError(t, err)
+	require.Contains(t, string(data),
+		`"FailingServices":{"mdserver":"connection refused"}`)
+	require.Contains(t, string(data), `"ConnectionState":"offline"`)
+
+	decoded, err := DecodeKBFSStatus(data)
+	require.NoError(t, err)
+	require.Equal(t, "alice", decoded.CurrentUser)
+	require.True(t, decoded.IsConnected)
+	require.Equal(t, ConnectionOffline, decoded.ConnectionState)
+	require.Len(t, decoded.FailingServices, 1)
+	require.EqualError(t, decoded.FailingServices["mdserver"],
+		"connection refused")
+
+	_, err = DecodeKBFSStatus([]byte(`{"SchemaVersion": 1000}`))
+	require.IsType(t, StatusSchemaVersionError{}, err)
+
+	t.Log("A nil error doesn't break encoding, and stays nil")
+	status.FailingServices["kbpki"] = nil
+	data, err = json.Marshal(status)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"kbpki":null`)
+	decoded, err = DecodeKBFSStatus(data)
+	require.NoError(t, err)
+	require.Len(t, decoded.FailingServices, 2)
+	require.Nil(t, decoded.FailingServices["kbpki"])
+	require.EqualError(t, decoded.FailingServices["mdserver"],
+		"connection refused")
+}
+
+func TestFBStatusRecentErrors(t *testing.T) {
+	mockCtrl, config, fbsk, _ := fbStatusTestInit(t)
+	defer fbStatusTestShutdown(mockCtrl, config)
+	ctx := context.Background()
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	require.True(t, isSignificantFolderError(
+		kbfsblock.ServerErrorOverQuota{Throttled: true}))
+	require.False(t, isSignificantFolderError(errors.New("transient")))
+
+	_, c, err := fbsk.getStatus(ctx, nil)
+	require.NoError(t, err)
+
+	crErr := CRWrapError{errors.New("cr failed")}
+	fbsk.addRecentErr(crErr)
+	<-c
+	clock.Add(1 * time.Minute)
+	fbsk.addRecentErr(crErr)
+	status, c, err := fbsk.getStatus(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []FolderErrorStatus{{
+		Time:  now.Add(1 * time.Minute),
+		Error: crErr.Error(),
+		Count: 2,
+	}}, status.RecentErrors)
+
+	// Only the most recent errors are kept.
+	for i := 0; i < maxRecentFolderErrors; i++ {
+		fbsk.addRecentErr(fmt.Errorf("error %d", i))
+	}
+	<-c
+	status, c, err = fbsk.getStatus(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, status.RecentErrors, maxRecentFolderErrors)
+	require.Equal(t, "error 0", status.RecentErrors[0].Error)
+	require.Equal(t, fmt.Sprintf("error %d", maxRecentFolderErrors-1),
+		status.RecentErrors[maxRecentFolderErrors-1].Error)
+
+	fbsk.clearRecentErrs()
+	<-c
+	status, _, err = fbsk.getStatus(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, status.RecentErrors, 0)
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// CtxFolderEvictionTagKey is the type used for unique context tags
+// within idle folder eviction.
+type CtxFolderEvictionTagKey int
+
+const (
+	// CtxFolderEvictionIDKey is the type of the tag for unique
+	// operation IDs within idle folder eviction.
+	CtxFolderEvictionIDKey CtxFolderEvictionTagKey = iota
+)
+
+// CtxFolderEvictionOpID is the display name for the unique operation
+// idle folder eviction ID tag.
+const CtxFolderEvictionOpID = "FEID"
+
+const (
+	// folderEvictionCheckInterval is the longest time between two
+	// checks for idle folders.
+	folderEvictionCheckInterval = time.Minute
+	// folderEvictionMinIdle is how long a folder must have gone
+	// unused before it can be evicted to stay under the maximum
+	// number of folders.
+	folderEvictionMinIdle = 10 * time.Second
+	// folderEvictionTimeout bounds how long eviction waits for the
+	// background work of a folder to finish.
+	folderEvictionTimeout = time.Minute
+)
+
+// SetFolderEviction sets when idle folders are shut down and
+// forgotten, to free their goroutines and caches.  A folder is idle
+// when none of its nodes are in use, it has no dirty or unmerged
+// changes, and no observers are registered for it.  Idle folders
+// are evicted once they have gone unused for ttl, and, while there
+// are more than maxFolders folders, the least recently used idle
+// folders are evicted early.  A non-positive ttl or maxFolders
+// disables that part of the policy; with both disabled, nothing is
+// evicted.  An evicted folder starts up again the next time it's
+// used.
+func (fs *KBFSOpsStandard) SetFolderEviction(
+	ttl time.Duration, maxFolders int) {
+	fs.evictionLock.Lock()
+	defer fs.evictionLock.Unlock()
+	if fs.evictionShutdownCh != nil {
+		close(fs.evictionShutdownCh)
+		fs.evictionShutdownCh = nil
+	}
+	fs.evictionTTL = ttl
+	fs.evictionMaxFolders = maxFolders
+	if ttl <= 0 && maxFolders <= 0 {
+		return
+	}
+
+	interval := folderEvictionCheckInterval
+	if ttl > 0 && ttl < interval {
+		interval = ttl
+	}
+	fs.evictionShutdownCh = make(chan struct{})
+	go fs.evictIdleFoldersLoop(interval, fs.evictionShutdownCh)
+}
+
+func (fs *KBFSOpsStandard) folderEviction() (
+	ttl time.Duration, maxFolders int) {
+	fs.evictionLock.Lock()
+	defer fs.evictionLock.Unlock()
+	return fs.evictionTTL, fs.evictionMaxFolders
+}
+
+func (fs *KBFSOpsStandard) evictIdleFoldersLoop(
+	interval time.Duration, shutdownCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxFolderEvictionIDKey, CtxFolderEvictionOpID, fs.log))
+	defer cancel()
+	go func() {
+		select {
+		case <-shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fs.evictIdleFolders(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evictIdleFolders evicts the idle folders that the current policy
+// allows to be evicted.
+func (fs *KBFSOpsStandard) evictIdleFolders(ctx context.Context) {
+	ttl, maxFolders := fs.folderEviction()
+	if ttl <= 0 && maxFolders <= 0 {
+		return
+	}
+
+	type candidate struct {
+		fb       FolderBranch
+		ops      *folderBranchOps
+		lastUsed time.Time
+		uses     uint64
+	}
+	var candidates []candidate
+	for fb, ops := range fs.ops.snapshot() {
+		lastUsed, uses := ops.getLastUsed()
+		candidates = append(candidates, candidate{fb, ops, lastUsed, uses})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	excess := 0
+	if maxFolders > 0 && len(candidates) > maxFolders {
+		excess = len(candidates) - maxFolders
+	}
+	now := fs.config.Clock().Now()
+	for _, c := range candidates {
+		idle := now.Sub(c.lastUsed)
+		if !(ttl > 0 && idle >= ttl) &&
+			!(excess > 0 && idle >= folderEvictionMinIdle) {
+			continue
+		}
+		evicted, err := fs.evictFolder(ctx, c.fb, c.ops, c.uses)
+		if err != nil {
+			fs.log.CDebugf(ctx, "Couldn't evict %s: %+v", c.fb, err)
+		}
+		if evicted {
+			excess--
+		}
+	}
+}
+
+// evictFolder shuts down and forgets the given ops for fb, if they
+// are idle and haven't been used since they had been used `uses`
+// times.  It returns whether the ops were evicted.
+func (fs *KBFSOpsStandard) evictFolder(ctx context.Context,
+	fb FolderBranch, ops *folderBranchOps, uses uint64) (bool, error) {
+	lState := makeFBOLockState()
+	if !ops.isIdle(lState) {
+		return false, nil
+	}
+
+	// Let any archiving and deleting of old blocks finish first.
+	waitCtx, cancel := context.WithTimeout(ctx, folderEvictionTimeout)
+	defer cancel()
+	if err := ops.fbm.waitForArchives(waitCtx); err != nil {
+		return false, err
+	}
+	if err := ops.fbm.waitForDeletingBlocks(waitCtx); err != nil {
+		return false, err
+	}
+
+	// Anything that might have made the ops busy again had to get
+	// them from the map first, which marks them as used.
+	fs.opsLock.Lock()
+	s := fs.ops.shard(fb)
+	s.lock.Lock()
+	if _, newUses := ops.getLastUsed(); s.ops[fb] != ops || newUses != uses {
+		s.lock.Unlock()
+		fs.opsLock.Unlock()
+		return false, nil
+	}
+	delete(s.ops, fb)
+	evicting := make(chan struct{})
+	s.evicting[fb] = evicting
+	s.lock.Unlock()
+	for fav, favOps := range fs.opsByFav {
+		if favOps == ops {
+			delete(fs.opsByFav, fav)
+		}
+	}
+	fs.opsLock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.evicting, fb)
+		close(evicting)
+	}()
+
+	// Skip the state checks of a full Shutdown, since they would
+	// need the ops for this folder-branch again.
+	fs.log.CDebugf(ctx, "Evicting idle folder %s", fb)
+	ops.shutdown(ctx)
+	if fb.Branch == MasterBranch {
+		// The ops may still be registered for updates, which would
+		// keep the next ops for this folder from registering.
+		fs.config.MDServer().CancelRegistration(ctx, fb.Tlf)
+	}
+	return true, nil
+}
@@ -0,0 +1,375 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"golang.org/x/net/context"
+)
+
+// subtreeUsageCacheSize is how many subtree usages each folder keeps
+// cached.
+const subtreeUsageCacheSize = 1000
+
+// maxMDSubtreeUsages is how many subtree usages can be kept in the
+// private metadata of a TLF, so that the cache doesn't make every
+// MD update of a huge TLF bigger.
+const maxMDSubtreeUsages = 256
+
+// SubtreeUsage describes how much storage a subtree of a TLF uses.
+type SubtreeUsage struct {
+	// LogicalBytes is the total size of the files in the subtree,
+	// as readers see them.
+	LogicalBytes uint64 `codec:"l"`
+	// PhysicalBytes is the total encoded (that is, compressed,
+	// encrypted and padded) size of the blocks that make up the
+	// subtree, including directory and indirect file blocks.  A
+	// block shared by several files in the subtree, for example
+	// by KBFSOps.CopyFile, is only counted once.
+	PhysicalBytes uint64 `codec:"p"`
+	// Files is the number of files in the subtree.
+	Files uint64 `codec:"f"`
+	// Dirs is the number of directories in the subtree, including
+	// its root if it's a directory.
+	Dirs uint64 `codec:"d"`
+}
+
+// CachedSubtreeUsage is the usage of the subtree whose root is at
+// Ptr, as cached in the private metadata of a TLF.  Since blocks are
+// immutable, it stays correct for as long as Ptr is in use.
+type CachedSubtreeUsage struct {
+	Ptr   BlockPointer `codec:"p"`
+	Usage SubtreeUsage `codec:"u"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// getSubtreeUsage returns the usage cached in this MD for the
+// subtree whose root is at `ptr`, if there is one.
+func (pmd *PrivateMetadata) getSubtreeUsage(ptr BlockPointer) (
+	SubtreeUsage, bool) {
+	for _, cached := range pmd.SubtreeUsages {
+		if cached.Ptr == ptr {
+			return cached.Usage, true
+		}
+	}
+	return SubtreeUsage{}, false
+}
+
+// updateSubtreeUsages replaces the usages cached in this MD with
+// `usages`, if it's not nil, and then drops any whose root is
+// unreferenced by the ops of this MD, since those subtrees are gone
+// as of this revision.
+func (pmd *PrivateMetadata) updateSubtreeUsages(usages []CachedSubtreeUsage) {
+	if usages != nil {
+		pmd.SubtreeUsages = usages
+	}
+	if len(pmd.SubtreeUsages) == 0 {
+		return
+	}
+
+	ops := pmd.Changes.Ops
+	if len(ops) == 0 {
+		// The changes may have been unembedded already.
+		ops = pmd.cachedChanges.Ops
+	}
+	unrefs := make(map[BlockPointer]bool)
+	for _, op := range ops {
+		for _, ptr := range op.Unrefs() {
+			unrefs[ptr] = true
+		}
+		for _, update := range op.allUpdates() {
+			unrefs[update.Unref] = true
+		}
+	}
+
+	var kept []CachedSubtreeUsage
+	for _, cached := range pmd.SubtreeUsages {
+		if !unrefs[cached.Ptr] {
+			kept = append(kept, cached)
+		}
+	}
+	pmd.SubtreeUsages = kept
+}
+
+func (su *SubtreeUsage) add(other SubtreeUsage) {
+	su.LogicalBytes += other.LogicalBytes
+	su.PhysicalBytes += other.PhysicalBytes
+	su.Files += other.Files
+	su.Dirs += other.Dirs
+}
+
+// UsageBreakdown describes how much storage each top-level entry of
+// a TLF uses.
+type UsageBreakdown struct {
+	// Revision is the revision of the TLF that was measured.
+	Revision kbfsmd.Revision
+	// Total is the usage of the whole TLF.  It's the sum of the
+	// usages of the entries, plus the root directory, so blocks
+	// shared between different top-level entries are counted more
+	// than once.
+	Total SubtreeUsage
+	// Entries maps the name of each top-level file or directory
+	// to its usage.  Symlinks are left out, since they don't use
+	// any blocks.
+	Entries map[string]SubtreeUsage
+}
+
+// addSubtreeUsage adds the usage of the subtree at `p`, whose entry
+// is `de`, as of `md`, to `usage`.  Blocks already in `seen` aren't
+// counted again.  Only block pointers, and not file contents, are
+// read.
+func (fbo *folderBranchOps) addSubtreeUsage(ctx context.Context,
+	lState *lockState, md ImmutableRootMetadata, p path, de DirEntry,
+	seen map[kbfsblock.ID]bool, usage *SubtreeUsage) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	countBlock := func(info BlockInfo) {
+		if !seen[info.ID] {
+			seen[info.ID] = true
+			usage.PhysicalBytes += uint64(info.EncodedSize)
+		}
+	}
+
+	switch de.Type {
+	case Sym:
+		return nil
+	case File, Exec:
+		usage.Files++
+		usage.LogicalBytes += de.Size
+		countBlock(de.BlockInfo)
+		infos, err := fbo.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md, p)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			countBlock(info)
+		}
+		return nil
+	}
+
+	usage.Dirs++
+	countBlock(de.BlockInfo)
+	dblock, err := fbo.blocks.GetCleanDirBlock(ctx, md, de.BlockPointer, p)
+	if err != nil {
+		return err
+	}
+	for name, childDe := range dblock.Children {
+		err := fbo.addSubtreeUsage(ctx, lState, md,
+			p.ChildPath(name, childDe.BlockPointer), childDe, seen, usage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSubtreeUsage returns the usage of the subtree at `p`, whose
+// entry is `de`, as of `md`.  Since blocks are immutable, a subtree
+// that has the same root pointer as one measured earlier must have
+// the same usage, so the results are cached by root pointer, both in
+// memory and (for the top-level entries of the TLF) in `md` itself,
+// and only subtrees that changed since the last call get walked
+// again.
+func (fbo *folderBranchOps) getSubtreeUsage(ctx context.Context,
+	lState *lockState, md ImmutableRootMetadata, p path, de DirEntry) (
+	SubtreeUsage, error) {
+	if de.Type == Sym {
+		return SubtreeUsage{}, nil
+	}
+	if cached, ok := fbo.usageCache.Get(de.BlockPointer); ok {
+		return cached.(SubtreeUsage), nil
+	}
+	if usage, ok := md.data.getSubtreeUsage(de.BlockPointer); ok {
+		fbo.usageCache.Add(de.BlockPointer, usage)
+		return usage, nil
+	}
+
+	var usage SubtreeUsage
+	err := fbo.addSubtreeUsage(
+		ctx, lState, md, p, de, make(map[kbfsblock.ID]bool), &usage)
+	if err != nil {
+		return SubtreeUsage{}, err
+	}
+	fbo.usageCache.Add(de.BlockPointer, usage)
+	return usage, nil
+}
+
+// setSubtreeUsagesToCache remembers the usages of the top-level
+// entries of the TLF as of `md`, so that they get cached in the next
+// MD written by this device, unless they're the same as the ones
+// already cached in `md`.
+func (fbo *folderBranchOps) setSubtreeUsagesToCache(
+	md ImmutableRootMetadata, usages []CachedSubtreeUsage) {
+	if len(usages) == len(md.data.SubtreeUsages) {
+		changed := false
+		for _, cached := range usages {
+			if _, ok := md.data.getSubtreeUsage(cached.Ptr); !ok {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+
+	fbo.usageLock.Lock()
+	defer fbo.usageLock.Unlock()
+	fbo.usagesToCache = usages
+}
+
+// cacheSubtreeUsagesLocked updates the subtree usages cached in
+// `md`, which is about to be written, with the latest ones measured
+// by GetUsageBreakdown.
+func (fbo *folderBranchOps) cacheSubtreeUsagesLocked(
+	lState *lockState, md *RootMetadata) {
+	fbo.mdWriterLock.AssertLocked(lState)
+	fbo.usageLock.Lock()
+	usages := fbo.usagesToCache
+	fbo.usagesToCache = nil
+	fbo.usageLock.Unlock()
+	md.data.updateSubtreeUsages(usages)
+}
+
+// GetUsageBreakdown implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetUsageBreakdown(ctx context.Context,
+	folderBranch FolderBranch) (breakdown UsageBreakdown, err error) {
+	fbo.log.CDebugf(ctx, "GetUsageBreakdown")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetUsageBreakdown done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return UsageBreakdown{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call.
+	var result UsageBreakdown
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+
+		rootDe := md.data.Dir
+		rootPath := path{
+			FolderBranch: fbo.folderBranch,
+			path: []pathNode{{
+				BlockPointer: rootDe.BlockPointer,
+				Name:         string(md.GetTlfHandle().GetCanonicalName()),
+			}},
+		}
+		dblock, err := fbo.blocks.GetCleanDirBlock(
+			ctx, md, rootDe.BlockPointer, rootPath)
+		if err != nil {
+			return err
+		}
+
+		result = UsageBreakdown{
+			Revision: md.Revision(),
+			Total: SubtreeUsage{
+				PhysicalBytes: uint64(rootDe.EncodedSize),
+				Dirs:          1,
+			},
+			Entries: make(map[string]SubtreeUsage, len(dblock.Children)),
+		}
+		toCache := make([]CachedSubtreeUsage, 0, len(dblock.Children))
+		for name, de := range dblock.Children {
+			if de.Type == Sym {
+				continue
+			}
+			usage, err := fbo.getSubtreeUsage(ctx, lState, md,
+				rootPath.ChildPath(name, de.BlockPointer), de)
+			if err != nil {
+				return err
+			}
+			result.Entries[name] = usage
+			result.Total.add(usage)
+			if len(toCache) < maxMDSubtreeUsages {
+				toCache = append(toCache,
+					CachedSubtreeUsage{Ptr: de.BlockPointer, Usage: usage})
+			}
+		}
+		fbo.setSubtreeUsagesToCache(md, toCache)
+		return nil
+	})
+	if err != nil {
+		return UsageBreakdown{}, err
+	}
+	return result, nil
+}
+
+// GetSubtreeUsage implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetSubtreeUsage(
+	ctx context.Context, node Node) (usage SubtreeUsage, err error) {
+	fbo.log.CDebugf(ctx, "GetSubtreeUsage %s", getNodeIDStr(node))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetSubtreeUsage %s done: %+v",
+			getNodeIDStr(node), err)
+	}()
+
+	err = fbo.checkNode(node)
+	if err != nil {
+		return SubtreeUsage{}, err
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call.
+	var result SubtreeUsage
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+		p, err := fbo.pathFromNodeForRead(node)
+		if err != nil {
+			return err
+		}
+		if fbo.nodeCache.IsUnlinked(node) {
+			// Nothing in the current revision points to it.
+			return nil
+		}
+
+		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+
+		de := md.data.Dir
+		if p.hasValidParent() {
+			parentPath := *p.parentPath()
+			dblock, err := fbo.blocks.GetCleanDirBlock(
+				ctx, md, parentPath.tailPointer(), parentPath)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			de, ok = dblock.Children[p.tailName()]
+			if !ok {
+				// The entry hasn't been synced yet, so it doesn't
+				// use any storage so far.
+				return nil
+			}
+		}
+
+		result, err = fbo.getSubtreeUsage(ctx, lState, md, p, de)
+		return err
+	})
+	if err != nil {
+		return SubtreeUsage{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestGetUsageBreakdown(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// Make the blocks small, so that copies of a file share several
+	// leaf blocks, but with only one level of indirection, since
+	// copies get their own indirect blocks.
+	bsplit := &BlockSplitterSimple{5, 10, 100 * 1024}
+	config.SetBlockSplitter(bsplit)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	makeFile := func(dir Node, name string, size int) Node {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, make([]byte, size), 0)
+		require.NoError(t, err)
+		return n
+	}
+	encodedSize := func(n Node) uint64 {
+		md, err := kbfsOps.GetNodeMetadata(ctx, n)
+		require.NoError(t, err)
+		return uint64(md.BlockInfo.EncodedSize)
+	}
+
+	t.Log("Make a, copies/{b,c} as copies of a, and docs/d")
+	aNode := makeFile(rootNode, "a", 20)
+	copiesNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "copies")
+	require.NoError(t, err)
+	docsNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "docs")
+	require.NoError(t, err)
+	makeFile(docsNode, "d", 3)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "link", "docs/d")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CopyFile(ctx, aNode, copiesNode, "b")
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CopyFile(ctx, aNode, copiesNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	breakdown, err := kbfsOps.GetUsageBreakdown(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, breakdown.Entries, 3)
+	a := breakdown.Entries["a"]
+	require.Equal(t, uint64(20), a.LogicalBytes)
+	require.Equal(t, uint64(1), a.Files)
+	copies := breakdown.Entries["copies"]
+	require.Equal(t, uint64(40), copies.LogicalBytes)
+	require.Equal(t, uint64(2), copies.Files)
+	require.Equal(t, uint64(1), copies.Dirs)
+	docs := breakdown.Entries["docs"]
+	require.Equal(t, uint64(3), docs.LogicalBytes)
+	require.Equal(t, uint64(63), breakdown.Total.LogicalBytes)
+	require.Equal(t, uint64(3), breakdown.Total.Dirs)
+
+	t.Log("The leaf blocks shared by the copies are only counted once")
+	leavesSize := a.PhysicalBytes - encodedSize(aNode)
+	require.NotZero(t, leavesSize)
+	require.Equal(t,
+		encodedSize(copiesNode)+encodedSize(bNode)+encodedSize(cNode)+
+			leavesSize, copies.PhysicalBytes)
+
+	t.Log("Only the changed subtree is measured again")
+	makeFile(docsNode, "e", 4)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	copiesMD, err := kbfsOps.GetNodeMetadata(ctx, copiesNode)
+	require.NoError(t, err)
+	ops := getOps(config, fb.Tlf)
+	ops.usageCache.Add(copiesMD.BlockInfo.BlockPointer, SubtreeUsage{})
+	breakdown, err = kbfsOps.GetUsageBreakdown(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, SubtreeUsage{}, breakdown.Entries["copies"])
+	require.Equal(t, uint64(7), breakdown.Entries["docs"].LogicalBytes)
+
+	t.Log("The next MD caches the usages, except for changed subtrees")
+	docsMD, err := kbfsOps.GetNodeMetadata(ctx, docsNode)
+	require.NoError(t, err)
+	makeFile(docsNode, "f", 5)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	md, err := config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	usage, ok := md.data.getSubtreeUsage(copiesMD.BlockInfo.BlockPointer)
+	require.True(t, ok)
+	require.Equal(t, SubtreeUsage{}, usage)
+	_, ok = md.data.getSubtreeUsage(docsMD.BlockInfo.BlockPointer)
+	require.False(t, ok)
+	require.Len(t, md.data.SubtreeUsages, 2)
+
+	t.Log("Usages cached in the MD are used once the memory cache is gone")
+	ops.usageCache.Purge()
+	breakdown, err = kbfsOps.GetUsageBreakdown(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, SubtreeUsage{}, breakdown.Entries["copies"])
+	require.Equal(t, uint64(12), breakdown.Entries["docs"].LogicalBytes)
+}
+
+func TestGetSubtreeUsage(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	makeFile := func(dir Node, name string, size int) Node {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, make([]byte, size), 0)
+		require.NoError(t, err)
+		return n
+	}
+
+	t.Log("Make a and dir/{b,c}, where dir/c is a copy of dir/b")
+	makeFile(rootNode, "a", 10)
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "dir")
+	require.NoError(t, err)
+	bNode := makeFile(dirNode, "b", 5)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CopyFile(ctx, bNode, dirNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	bUsage, err := kbfsOps.GetSubtreeUsage(ctx, bNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), bUsage.LogicalBytes)
+	require.Equal(t, uint64(1), bUsage.Files)
+	require.NotZero(t, bUsage.PhysicalBytes)
+	dirUsage, err := kbfsOps.GetSubtreeUsage(ctx, dirNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), dirUsage.LogicalBytes)
+	require.Equal(t, uint64(2), dirUsage.Files)
+	require.Equal(t, uint64(1), dirUsage.Dirs)
+
+	t.Log("The whole TLF matches the breakdown, plus the root")
+	rootUsage, err := kbfsOps.GetSubtreeUsage(ctx, rootNode)
+	require.NoError(t, err)
+	breakdown, err := kbfsOps.GetUsageBreakdown(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, breakdown.Total, rootUsage)
+	require.Equal(t, uint64(20), rootUsage.LogicalBytes)
+	require.Equal(t, uint64(2), rootUsage.Dirs)
+
+	t.Log("Unsynced writes aren't counted")
+	dNode := makeFile(dirNode, "d", 7)
+	dUsage, err := kbfsOps.GetSubtreeUsage(ctx, dNode)
+	require.NoError(t, err)
+	require.Equal(t, SubtreeUsage{}, dUsage)
+	err = kbfsOps.Write(ctx, bNode, make([]byte, 3), 5)
+	require.NoError(t, err)
+	bUsage2, err := kbfsOps.GetSubtreeUsage(ctx, bNode)
+	require.NoError(t, err)
+	require.Equal(t, bUsage, bUsage2)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	dirUsage, err = kbfsOps.GetSubtreeUsage(ctx, dirNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), dirUsage.LogicalBytes)
+	require.Equal(t, uint64(3), dirUsage.Files)
+
+	t.Log("A canceled walk returns an error")
+	ops := getOps(config, fb.Tlf)
+	ops.usageCache.Purge()
+	canceledCtx, cancel2 := context.WithCancel(ctx)
+	cancel2()
+	_, err = kbfsOps.GetSubtreeUsage(canceledCtx, rootNode)
+	require.Error(t, err)
+}
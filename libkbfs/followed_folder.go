@@ -0,0 +1,198 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxFollowedFolderTagKey is the type used for unique context tags
+// within a FollowedFolder.
+type CtxFollowedFolderTagKey int
+
+const (
+	// CtxFollowedFolderIDKey is the type of the tag for unique
+	// operation IDs within a FollowedFolder.
+	CtxFollowedFolderIDKey CtxFollowedFolderTagKey = iota
+)
+
+// CtxFollowedFolderOpID is the display name for the unique operation
+// FollowedFolder ID tag.
+const CtxFollowedFolderOpID = "FFID"
+
+// FolderUpdate announces that a followed folder has new contents.
+type FolderUpdate struct {
+	// Revision is the folder's latest revision.  Updates that
+	// arrive while the previous one is still unread are coalesced,
+	// so revisions can be skipped.
+	Revision kbfsmd.Revision
+}
+
+// FollowedFolder is a read-only local copy of a public folder,
+// followed with KBFSOpsStandard.FollowFolder.  The folder is synced
+// to disk, so its latest revision stays readable offline, and it's
+// kept up to date as the folder's writers change it.
+type FollowedFolder struct {
+	fs *KBFSOpsStandard
+	// rootNode keeps the folder's node cache, and so its change
+	// notifications, alive for as long as it's followed.
+	rootNode  Node
+	wasSynced bool
+
+	changedCh  chan struct{}
+	updatesCh  chan FolderUpdate
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+	stopOnce   sync.Once
+}
+
+var _ Observer = (*FollowedFolder)(nil)
+
+// FollowFolder subscribes to the public folder named by `handle`,
+// typically one published by another user.  The folder is synced to
+// disk, and updated in the background whenever its writers change
+// it; each update is announced on the returned FollowedFolder's
+// Updates channel, until it's stopped.  The folder must already
+// exist.
+func (fs *KBFSOpsStandard) FollowFolder(
+	ctx context.Context, handle *TlfHandle) (_ *FollowedFolder, err error) {
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
+
+	if handle.Type() != tlf.Public {
+		return nil, errors.Errorf(
+			"Only public folders can be followed, not %s",
+			handle.GetCanonicalPath())
+	}
+	rootNode, _, err := fs.GetRootNode(ctx, handle, MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if rootNode == nil {
+		return nil, errors.Errorf("Folder %s doesn't exist yet",
+			handle.GetCanonicalPath())
+	}
+	fb := rootNode.GetFolderBranch()
+
+	f := &FollowedFolder{
+		fs:         fs,
+		rootNode:   rootNode,
+		wasSynced:  fs.config.IsSyncedTlf(fb.Tlf),
+		changedCh:  make(chan struct{}, 1),
+		updatesCh:  make(chan FolderUpdate, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	if !f.wasSynced {
+		err = fs.SetFolderSyncState(ctx, fb.Tlf, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = fs.RegisterForChanges([]FolderBranch{fb}, f)
+	if err != nil {
+		if !f.wasSynced {
+			_ = fs.SetFolderSyncState(ctx, fb.Tlf, false)
+		}
+		return nil, err
+	}
+	go f.loop()
+	return f, nil
+}
+
+// Updates returns the channel on which the folder's updates are
+// announced.  It's closed once the FollowedFolder is stopped.
+func (f *FollowedFolder) Updates() <-chan FolderUpdate {
+	return f.updatesCh
+}
+
+// RootNode returns the root node of the followed folder.
+func (f *FollowedFolder) RootNode() Node {
+	return f.rootNode
+}
+
+func (f *FollowedFolder) loop() {
+	defer close(f.doneCh)
+	defer close(f.updatesCh)
+	ctx := CtxWithRandomIDReplayable(context.Background(),
+		CtxFollowedFolderIDKey, CtxFollowedFolderOpID, f.fs.log)
+	fb := f.rootNode.GetFolderBranch()
+	lState := makeFBOLockState()
+
+	// Only send an update when there's one pending; a nil channel
+	// blocks forever.
+	var lastRev kbfsmd.Revision
+	var pending FolderUpdate
+	var sendCh chan<- FolderUpdate
+	for {
+		select {
+		case <-f.changedCh:
+			rev := f.fs.getOpsNoAdd(ctx, fb).getCurrMDRevision(lState)
+			if rev <= lastRev {
+				continue
+			}
+			f.fs.log.CDebugf(ctx, "Followed folder %s is now at revision %d",
+				fb.Tlf, rev)
+			lastRev = rev
+			pending = FolderUpdate{Revision: rev}
+			sendCh = f.updatesCh
+		case sendCh <- pending:
+			sendCh = nil
+		case <-f.shutdownCh:
+			return
+		}
+	}
+}
+
+// Stop stops following the folder.  Unless it was already synced
+// before it was followed, the folder is no longer kept on disk.
+func (f *FollowedFolder) Stop(ctx context.Context) error {
+	fb := f.rootNode.GetFolderBranch()
+	var err error
+	f.stopOnce.Do(func() {
+		err = f.fs.UnregisterFromChanges([]FolderBranch{fb}, f)
+		close(f.shutdownCh)
+		if !f.wasSynced {
+			if syncErr := f.fs.SetFolderSyncState(
+				ctx, fb.Tlf, false); err == nil {
+				err = syncErr
+			}
+		}
+	})
+	select {
+	case <-f.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// LocalChange implements the Observer interface for FollowedFolder.
+// A followed folder can't be written locally.
+func (f *FollowedFolder) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+// BatchChanges implements the Observer interface for FollowedFolder.
+func (f *FollowedFolder) BatchChanges(
+	_ context.Context, _ []NodeChange, _ []NodeID) {
+	select {
+	case f.changedCh <- struct{}{}:
+	default:
+		// An update is already pending, and will pick this up.
+	}
+}
+
+// TlfHandleChange implements the Observer interface for
+// FollowedFolder.
+func (f *FollowedFolder) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
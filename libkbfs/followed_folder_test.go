@@ -0,0 +1,100 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowFolder(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	t.Log("Give user 2 a disk cache to keep the followed folder in")
+	tempdir, err := ioutil.TempDir(os.TempDir(), "followed_folder")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	config2.storageRoot = tempdir
+	config2.diskCacheMode = DiskCacheModeLocal
+	err = config2.loadSyncedTlfsLocked()
+	require.NoError(t, err)
+	err = config2.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	err = config2.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	t.Log("User 1 publishes a file")
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, "u1", tlf.Public)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Private folders can't be followed")
+	kbfsOps2 := config2.KBFSOps().(*KBFSOpsStandard)
+	privRootNode := GetRootNodeOrBust(ctx, t, config2, "u2", tlf.Private)
+	privHandle, err := kbfsOps2.GetTLFHandle(ctx, privRootNode)
+	require.NoError(t, err)
+	_, err = kbfsOps2.FollowFolder(ctx, privHandle)
+	require.Error(t, err)
+
+	t.Log("User 2 follows user 1's public folder")
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "u1", tlf.Public)
+	handle, err := kbfsOps2.GetTLFHandle(ctx, rootNode2)
+	require.NoError(t, err)
+	f, err := kbfsOps2.FollowFolder(ctx, handle)
+	require.NoError(t, err)
+	fb := f.RootNode().GetFolderBranch()
+	require.True(t, config2.IsSyncedTlf(fb.Tlf))
+
+	t.Log("User 1 changes the file, and user 2 hears about it")
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{2}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	rev := getOps(config1, fb.Tlf).getCurrMDRevision(makeFBOLockState())
+	for {
+		select {
+		case update := <-f.Updates():
+			if update.Revision < rev {
+				continue
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for the update")
+		}
+		break
+	}
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, f.RootNode(), "a")
+	require.NoError(t, err)
+	data := make([]byte, 1)
+	_, err = kbfsOps2.Read(ctx, fileNode2, data, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, data)
+
+	t.Log("Stopping closes the updates, and unsyncs the folder")
+	err = f.Stop(ctx)
+	require.NoError(t, err)
+	_, ok := <-f.Updates()
+	require.False(t, ok)
+	require.False(t, config2.IsSyncedTlf(fb.Tlf))
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
 )
 
 const (
@@ -58,6 +59,67 @@ type InitParams struct {
 	// "dir:/path/to/dir" for an on-disk test server.
 	MDServerAddr string
 
+	// The number of connections to use for reading blocks from a
+	// remote block server.
+	BServerGetConnections int
+
+	// If non-zero, block reads from a remote block server that take
+	// longer than this are sent again, and the first answer wins.
+	BServerHedgeDelay time.Duration
+	// The fraction of block reads that may be sent again, when
+	// BServerHedgeDelay is non-zero.
+	BServerHedgeBudget float64
+
+	// If non-empty, the proxy to use for connections to remote MD
+	// and block servers, as a URL of the form "http://host:port"
+	// (optionally with a user and password) or "socks5://host:port".
+	Proxy string
+	// If non-empty, per-host exceptions to Proxy, of the form
+	// "host1=proxy1,host2=proxy2", where each proxy is a proxy URL
+	// or "direct".
+	ProxyOverrides string
+	// If non-empty, the "ip:port" address of a Tor SOCKS proxy.  All
+	// connections to remote MD and block servers then go through
+	// it, and server names are never resolved locally.  It can't be
+	// combined with Proxy or ProxyOverrides.  Identity lookups are
+	// made by the Keybase service, which must be put in its own Tor
+	// mode separately.
+	TorProxy string
+
+	// If non-empty, a comma-separated list of "host:port" addresses
+	// of other devices on the local network to try getting blocks
+	// from, before asking the block server.
+	LANPeers string
+	// If non-empty, the "host:port" address on which to serve the
+	// blocks in the disk block cache to LAN peers.
+	LANPeerListen string
+
+	// If non-empty, the address of a cheaper, slower block server,
+	// in the same form as BServerAddr, to move the blocks of old
+	// revisions of the TLFs in ColdStorageTLFs to.
+	BServerColdAddr string
+	// A comma-separated list of the IDs of the TLFs that use cold
+	// storage.
+	ColdStorageTLFs string
+
+	// If positive, how long a folder with no open nodes or dirty
+	// state may go unused before it's shut down to free resources.
+	FolderIdleTTL time.Duration
+	// If positive, the number of running folders above which idle
+	// folders are shut down early.
+	MaxFolders int
+
+	// If non-empty, the path of a JSON file listing mirror jobs
+	// (see MirrorJobConfig) to run in the background.
+	MirrorJobsFile string
+	// A comma-separated list of <remote>=<local> pairs of a
+	// directory in a TLF (see LocalSyncConfig) and an absolute local
+	// path, to keep in sync in both directions.
+	LocalSyncDirs string
+	// A comma-separated list of patterns of entries to leave out of
+	// the local syncs (see LocalSyncConfig.Exclude).
+	LocalSyncExclude string
+
 	// If non-zero, specifies the capacity (in bytes) of the block cache. If
 	// zero, the capacity is set using getDefaultBlockCacheCapacity().
 	CleanBlockCacheCapacity uint64
@@ -112,8 +174,40 @@ type InitParams struct {
 	// flush.
 	BGFlushDirOpBatchSize int
 
+	// RemoteChangePolicy controls what folders do when another
+	// device changes them while they have unsynced local changes.
+	RemoteChangePolicy RemoteChangePolicy
+
+	// BlockSplitPolicy controls how newly-written file blocks are
+	// split and padded.  If it's the zero value, the default policy
+	// is used.
+	BlockSplitPolicy BlockSplitPolicy
+
+	// QuotaReclamationPeriod indicates how often each TLF checks for
+	// blocks whose quota can be reclaimed.  If zero, quota
+	// reclamation is disabled.
+	QuotaReclamationPeriod time.Duration
+
+	// QuotaReclamationMinUnrefAge indicates how long a block must
+	// have been unreferenced by the latest revision of its TLF before
+	// it is deleted from the block server.
+	QuotaReclamationMinUnrefAge time.Duration
+
 	// Mode describes how KBFS should initialize itself.
 	Mode string
+
+	// LogPaths says how much of file names and paths to reveal in
+	// the KBFS logs.
+	LogPaths LogPathsMode
+
+	// SelfTest, if true, runs a self-test in the background once
+	// KBFS has been initialized, and logs the results.
+	SelfTest bool
+
+	// SelfTestFolder is the folder, as "<type>/<name>", that the
+	// self-test uses.  If empty, the current user's own private
+	// folder is used.
+	SelfTestFolder string
 }
 
 // defaultBServer returns the default value for the -bserver flag.
@@ -190,9 +284,15 @@ func DefaultInitParams(ctx Context) InitParams {
 		StorageRoot:                    ctx.GetDataDir(),
 		BGFlushPeriod:                  bgFlushPeriodDefault,
 		BGFlushDirOpBatchSize:          bgFlushDirOpBatchSizeDefault,
+		BlockSplitPolicy:               DefaultBlockSplitPolicy(),
+		QuotaReclamationPeriod:         qrPeriodDefault,
+		QuotaReclamationMinUnrefAge:    qrUnrefAgeDefault,
 		EnableJournal:                  BoolForString(journalEnv),
 		DiskCacheMode:                  DiskCacheModeLocal,
 		Mode:                           InitDefaultString,
+		LogPaths:                       LogPathsFull,
+		BServerGetConnections:          BServerDefaultGetConnections,
+		BServerHedgeBudget:             BServerDefaultHedgeBudget,
 	}
 }
 
@@ -211,6 +311,57 @@ func AddFlagsWithDefaults(
 	flags.StringVar(&params.MDServerAddr, "mdserver",
 		defaultParams.MDServerAddr,
 		"host:port of the metadata server, 'memory', or 'dir:/path/to/dir'")
+	flags.IntVar(&params.BServerGetConnections, "bserver-get-conns",
+		defaultParams.BServerGetConnections,
+		"Number of connections to use for reading blocks from a remote "+
+			"block server")
+	flags.DurationVar(&params.BServerHedgeDelay, "bserver-hedge-delay",
+		defaultParams.BServerHedgeDelay,
+		"If non-zero, resend block reads that take longer than this, and "+
+			"use the first answer")
+	flags.Float64Var(&params.BServerHedgeBudget, "bserver-hedge-budget",
+		defaultParams.BServerHedgeBudget,
+		"The largest fraction of block reads that may be resent because "+
+			"of -bserver-hedge-delay")
+	flags.StringVar(&params.Proxy, "proxy", defaultParams.Proxy,
+		"Proxy for connections to the metadata and block servers, "+
+			"'http://[user:password@]host:port' or 'socks5://host:port'")
+	flags.StringVar(&params.ProxyOverrides, "proxy-overrides",
+		defaultParams.ProxyOverrides,
+		"Per-host proxies overriding -proxy, "+
+			"'host1=proxy1,host2=proxy2'; use 'direct' for no proxy")
+	flags.StringVar(&params.TorProxy, "tor-proxy", defaultParams.TorProxy,
+		"Send all metadata and block server traffic through the Tor "+
+			"SOCKS proxy at this 'ip:port', and never connect directly")
+	flags.StringVar(&params.LANPeers, "lan-peers", defaultParams.LANPeers,
+		"Comma-separated 'host:port' addresses of devices on the local "+
+			"network to try getting blocks from first")
+	flags.StringVar(&params.LANPeerListen, "lan-peer-listen",
+		defaultParams.LANPeerListen,
+		"The 'host:port' address on which to serve cached blocks to LAN peers")
+	flags.StringVar(&params.BServerColdAddr, "bserver-cold",
+		defaultParams.BServerColdAddr,
+		"host:port of a cold storage block server for old revisions")
+	flags.StringVar(&params.ColdStorageTLFs, "cold-storage-tlfs",
+		defaultParams.ColdStorageTLFs,
+		"Comma-separated IDs of the TLFs that move old revisions' blocks "+
+			"to -bserver-cold")
+	flags.DurationVar(&params.FolderIdleTTL, "folder-idle-ttl",
+		defaultParams.FolderIdleTTL,
+		"Shut down folders that have been idle for this long (0 = never)")
+	flags.IntVar(&params.MaxFolders, "max-folders", defaultParams.MaxFolders,
+		"Shut down idle folders early while more than this many are "+
+			"running (0 = no limit)")
+	flags.StringVar(&params.MirrorJobsFile, "mirror-jobs",
+		defaultParams.MirrorJobsFile,
+		"Path of a JSON file listing one-way mirror jobs to run")
+	flags.StringVar(&params.LocalSyncDirs, "local-sync",
+		defaultParams.LocalSyncDirs,
+		"Comma-separated <type>/<name>[/<path>]=<local path> pairs of "+
+			"directories to keep in sync with local directories")
+	flags.StringVar(&params.LocalSyncExclude, "local-sync-exclude",
+		defaultParams.LocalSyncExclude,
+		"Comma-separated patterns of entries to leave out of local syncs")
 	flags.StringVar(&params.LocalUser, "localuser", defaultParams.LocalUser,
 		"fake local user")
 	flags.StringVar(&params.LocalFavoriteStorage, "local-fav-storage",
@@ -264,6 +415,45 @@ func AddFlagsWithDefaults(
 		"The number of unflushed directory operations in a TLF that will "+
 			"trigger an immediate data sync.")
 
+	params.RemoteChangePolicy = defaultParams.RemoteChangePolicy
+	flags.Var(&params.RemoteChangePolicy, "remote-change-policy",
+		"What to do when another device changes a folder with unsynced "+
+			"local changes: local-wins, conflict-now or notify-and-block.")
+
+	flags.Int64Var(&params.BlockSplitPolicy.MaxBlockSize, "block-size",
+		defaultParams.BlockSplitPolicy.MaxBlockSize,
+		"The desired size, in bytes, of newly-written file blocks.")
+	flags.IntVar(&params.BlockSplitPolicy.MaxPtrsPerBlock, "block-ptrs",
+		defaultParams.BlockSplitPolicy.MaxPtrsPerBlock,
+		"The max number of pointers in an indirect file block. 0 derives "+
+			"it from the block size.")
+	params.BlockSplitPolicy.Padding = defaultParams.BlockSplitPolicy.Padding
+	flags.Var(&params.BlockSplitPolicy.Padding, "block-padding",
+		"How newly-written blocks are padded: pow2 or minimal.")
+	flags.BoolVar(&params.BlockSplitPolicy.ContentDefined,
+		"block-content-defined",
+		defaultParams.BlockSplitPolicy.ContentDefined,
+		"End newly-written blocks at content-defined boundaries, so "+
+			"that small insertions only change a few blocks.")
+	params.BlockSplitPolicy.Compression =
+		defaultParams.BlockSplitPolicy.Compression
+	flags.Var(&params.BlockSplitPolicy.Compression, "block-compression",
+		"How newly-written blocks are compressed before being "+
+			"encrypted: none, snappy or flate.")
+	flags.BoolVar(&params.BlockSplitPolicy.Dedup, "block-dedup",
+		defaultParams.BlockSplitPolicy.Dedup,
+		"Key newly-written blocks by their contents, so that identical "+
+			"blocks in a folder are only stored once.")
+
+	flags.DurationVar(&params.QuotaReclamationPeriod, "qr-period",
+		defaultParams.QuotaReclamationPeriod,
+		"How often each TLF checks for unreferenced blocks to delete from "+
+			"the block server. 0 disables quota reclamation.")
+	flags.DurationVar(&params.QuotaReclamationMinUnrefAge, "qr-unref-age",
+		defaultParams.QuotaReclamationMinUnrefAge,
+		"How long a block must have been unreferenced by the latest "+
+			"revision of its TLF before quota reclamation deletes it.")
+
 	flags.IntVar((*int)(&params.MetadataVersion), "md-version",
 		int(defaultParams.MetadataVersion),
 		"Metadata version to use when creating new metadata")
@@ -272,6 +462,20 @@ func AddFlagsWithDefaults(
 			"heavy-weight it can be (%s, %s, %s or %s)", InitDefaultString,
 			InitMinimalString, InitSingleOpString, InitConstrainedString))
 
+	params.LogPaths = defaultParams.LogPaths
+	flags.Var(&params.LogPaths, "log-paths",
+		"How much of file names and paths to reveal in log messages. If "+
+			"'full', they are logged as-is. If 'hashed', each is replaced by "+
+			"an opaque token that is stable until restart. If 'none', they "+
+			"are left out entirely.")
+
+	flags.BoolVar(&params.SelfTest, "self-test", false,
+		"Run a self-test after startup that writes, syncs, reads back and "+
+			"removes a scratch file, and log the timing of each step.")
+	flags.StringVar(&params.SelfTestFolder, "self-test-folder", "",
+		"Folder to use for -self-test, e.g. 'team/acme'. Defaults to the "+
+			"current user's own private folder.")
+
 	return &params
 }
 
@@ -387,7 +591,7 @@ func makeKeyServer(config Config, keyserverAddr string,
 	return keyServer, nil
 }
 
-func makeBlockServer(config Config, bserverAddr string,
+func makeBlockServer(config Config, bserverAddr string, numGetConns int,
 	rpcLogFactory rpc.LogFactory,
 	log logger.Logger) (BlockServer, error) {
 	if bserverAddr == memoryAddr {
@@ -415,7 +619,8 @@ func makeBlockServer(config Config, bserverAddr string,
 		return nil, err
 	}
 	log.Debug("Using remote bserver %s", remote)
-	return NewBlockServerRemote(config, remote, rpcLogFactory), nil
+	return NewBlockServerRemote(
+		config, remote, rpcLogFactory, numGetConns), nil
 }
 
 // InitLogWithPrefix sets up logging switching to a log file if
@@ -601,12 +806,17 @@ func doInit(
 	prefetchWorkers := config.Mode().PrefetchWorkers()
 	config.SetBlockOps(NewBlockOpsStandard(config, workers, prefetchWorkers))
 
-	bsplitter, err := NewBlockSplitterSimple(MaxBlockSizeBytesDefault, 8*1024,
-		config.Codec())
+	blockSplitPolicy := params.BlockSplitPolicy
+	if blockSplitPolicy == (BlockSplitPolicy{}) {
+		blockSplitPolicy = DefaultBlockSplitPolicy()
+	}
+	err := config.SetBlockSplitPolicy(blockSplitPolicy)
 	if err != nil {
 		return nil, err
 	}
-	config.SetBlockSplitter(bsplitter)
+	if blockSplitPolicy != DefaultBlockSplitPolicy() {
+		log.CDebugf(ctx, "Using block split policy %+v", blockSplitPolicy)
+	}
 
 	if registry := config.MetricsRegistry(); registry != nil {
 		keyCache := config.KeyCache()
@@ -621,9 +831,25 @@ func doInit(
 	config.SetMetadataVersion(kbfsmd.MetadataVer(params.MetadataVersion))
 	config.SetTLFValidDuration(params.TLFValidDuration)
 	config.SetBGFlushPeriod(params.BGFlushPeriod)
+	if params.QuotaReclamationPeriod < 0 ||
+		params.QuotaReclamationMinUnrefAge < 0 {
+		return nil, fmt.Errorf("Illegal quota reclamation period %s or "+
+			"unref age %s", params.QuotaReclamationPeriod,
+			params.QuotaReclamationMinUnrefAge)
+	}
+	config.SetQuotaReclamationPeriod(params.QuotaReclamationPeriod)
+	config.SetQuotaReclamationMinUnrefAge(params.QuotaReclamationMinUnrefAge)
+	config.logFilePath = params.LogFileConfig.Path
+	if config.logFilePath == "" && params.LogToFile {
+		config.logFilePath = defaultLogPath(kbCtx)
+	}
+	if err := config.SetLogPathsMode(params.LogPaths); err != nil {
+		return nil, err
+	}
 
 	kbfsOps := NewKBFSOpsStandard(config)
 	config.SetKBFSOps(kbfsOps)
+	kbfsOps.SetFolderEviction(params.FolderIdleTTL, params.MaxFolders)
 	config.SetNotifier(kbfsOps)
 	config.SetKeyManager(NewKeyManagerStandard(config))
 	config.SetMDOps(NewMDOpsStandard(config))
@@ -664,6 +890,30 @@ func doInit(
 	}
 	config.SetChat(chat)
 
+	if params.TorProxy != "" {
+		if params.Proxy != "" || params.ProxyOverrides != "" {
+			return nil, errors.New(
+				"-tor-proxy can't be combined with -proxy or -proxy-overrides")
+		}
+		dialer, err := NewTorDialer(params.TorProxy, dialerTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("problem configuring Tor proxy: %+v", err)
+		}
+		log.Debug("Using %s for server connections", dialer)
+		config.SetDialer(dialer)
+	} else if params.Proxy != "" || params.ProxyOverrides != "" {
+		overrides, err := ParseProxyOverrides(params.ProxyOverrides)
+		if err != nil {
+			return nil, fmt.Errorf("problem parsing proxy overrides: %+v", err)
+		}
+		dialer, err := NewProxyDialer(params.Proxy, overrides, dialerTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("problem configuring proxy: %+v", err)
+		}
+		log.Debug("Using %s for server connections", dialer)
+		config.SetDialer(dialer)
+	}
+
 	// Initialize MDServer connection.
 	mdServer, err := makeMDServer(
 		config, params.MDServerAddr, kbCtx.NewRPCLogFactory(), log)
@@ -684,14 +934,63 @@ func doInit(
 	config.SetKeyServer(keyServer)
 
 	// Initialize BlockServer connection.
-	bserv, err := makeBlockServer(
-		config, params.BServerAddr, kbCtx.NewRPCLogFactory(), log)
+	bserv, err := makeBlockServer(config, params.BServerAddr,
+		params.BServerGetConnections, kbCtx.NewRPCLogFactory(), log)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open block database: %+v", err)
 	}
+	if remote, ok := bserv.(*BlockServerRemote); ok &&
+		params.BServerHedgeDelay > 0 {
+		remote.EnableHedgedGets(
+			params.BServerHedgeDelay, params.BServerHedgeBudget)
+	}
 	if registry := config.MetricsRegistry(); registry != nil {
 		bserv = NewBlockServerMeasured(bserv, registry)
 	}
+	bserv = NewBlockServerNetworkMode(bserv, config)
+	if params.LANPeers != "" || params.LANPeerListen != "" {
+		if params.TorProxy != "" {
+			return nil, errors.New(
+				"LAN peers can't be used together with -tor-proxy")
+		}
+		var peers []string
+		if params.LANPeers != "" {
+			peers = strings.Split(params.LANPeers, ",")
+		}
+		lanPeers := NewBlockServerLANPeers(bserv, config, peers)
+		if params.LANPeerListen != "" {
+			addr, err := lanPeers.Serve(params.LANPeerListen)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"problem serving LAN peers: %+v", err)
+			}
+			log.Debug("Serving blocks to LAN peers on %s", addr)
+		}
+		bserv = lanPeers
+	}
+	if params.BServerColdAddr != "" {
+		coldBserv, err := makeBlockServer(config, params.BServerColdAddr,
+			params.BServerGetConnections, kbCtx.NewRPCLogFactory(), log)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open cold block database: %+v", err)
+		}
+		coldBserv = NewBlockServerNetworkMode(coldBserv, config)
+		tiered := NewBlockServerTiered(
+			bserv, coldBserv, config.MakeLogger("BST"))
+		if params.ColdStorageTLFs != "" {
+			for _, s := range strings.Split(params.ColdStorageTLFs, ",") {
+				tlfID, err := tlf.ParseID(s)
+				if err != nil {
+					return nil, fmt.Errorf(
+						"problem parsing cold storage TLFs: %+v", err)
+				}
+				tiered.SetColdStorage(tlfID, true)
+			}
+		}
+		bserv = tiered
+	} else if params.ColdStorageTLFs != "" {
+		return nil, errors.New("-cold-storage-tlfs requires -bserver-cold")
+	}
 	config.SetBlockServer(bserv)
 
 	err = config.MakeDiskBlockCacheIfNotExists()
@@ -749,9 +1048,71 @@ func doInit(
 		params.BGFlushDirOpBatchSize)
 	config.SetBGFlushDirOpBatchSize(params.BGFlushDirOpBatchSize)
 
+	config.SetRemoteChangePolicy(params.RemoteChangePolicy)
+
+	if params.MirrorJobsFile != "" {
+		jobConfigs, err := ReadMirrorJobConfigs(params.MirrorJobsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, jobConfig := range jobConfigs {
+			err := kbfsOps.StartMirrorJob(ctx, jobConfig)
+			if err != nil {
+				return nil, err
+			}
+			log.CDebugf(ctx, "Started mirror job %s", jobConfig.Name)
+		}
+	}
+
+	if params.LocalSyncDirs != "" {
+		var exclude []string
+		if params.LocalSyncExclude != "" {
+			exclude = strings.Split(params.LocalSyncExclude, ",")
+		}
+		for _, pair := range strings.Split(params.LocalSyncDirs, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf(
+					"Local sync %q is not of the form <remote>=<local>", pair)
+			}
+			_, err := kbfsOps.StartLocalSync(LocalSyncConfig{
+				Remote:  parts[0],
+				Local:   parts[1],
+				Exclude: exclude,
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.CDebugf(ctx, "Started syncing %s with %s", parts[1], parts[0])
+		}
+	}
+
+	if params.SelfTest {
+		tlfName, t, err := ParseSelfTestFolder(params.SelfTestFolder)
+		if err != nil {
+			return nil, err
+		}
+		go runStartupSelfTest(config, tlfName, t, log)
+	}
+
 	return config, nil
 }
 
+// runStartupSelfTest runs a self-test for the -self-test flag, and
+// logs the results.
+func runStartupSelfTest(
+	config Config, tlfName string, t tlf.Type, log logger.Logger) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), startupSelfTestTimeout)
+	defer cancel()
+	result := RunSelfTest(ctx, config, tlfName, t)
+	if result.Passed {
+		log.CInfof(ctx, "%s", result)
+	} else {
+		log.CWarningf(ctx, "%s", result)
+	}
+}
+
 // Shutdown does any necessary shutdown tasks for libkbfs. Shutdown
 // should be called at the end of main.
 func Shutdown() {}
@@ -55,14 +55,16 @@ func (cg *testClockGetter) TestClock() *TestClock {
 }
 
 type testSyncedTlfGetterSetter struct {
-	syncedTlfs map[tlf.ID]bool
+	syncedTlfs      map[tlf.ID]bool
+	excludePatterns map[tlf.ID]*ExcludePatterns
 }
 
 var _ syncedTlfGetterSetter = (*testSyncedTlfGetterSetter)(nil)
 
 func newTestSyncedTlfGetterSetter() *testSyncedTlfGetterSetter {
 	return &testSyncedTlfGetterSetter{
-		syncedTlfs: make(map[tlf.ID]bool),
+		syncedTlfs:      make(map[tlf.ID]bool),
+		excludePatterns: make(map[tlf.ID]*ExcludePatterns),
 	}
 }
 
@@ -76,6 +78,21 @@ func (t *testSyncedTlfGetterSetter) SetTlfSyncState(tlfID tlf.ID,
 	return nil
 }
 
+func (t *testSyncedTlfGetterSetter) TlfExcludePatterns(
+	tlfID tlf.ID) *ExcludePatterns {
+	return t.excludePatterns[tlfID]
+}
+
+func (t *testSyncedTlfGetterSetter) SetTlfExcludePatterns(tlfID tlf.ID,
+	patterns []string) error {
+	excludePatterns, err := ParseExcludePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	t.excludePatterns[tlfID] = excludePatterns
+	return nil
+}
+
 type testInitModeGetter struct {
 	mode InitModeType
 }
@@ -89,3 +106,13 @@ func (t testInitModeGetter) Mode() InitMode {
 func (t testInitModeGetter) IsTestMode() bool {
 	return true
 }
+
+type testNetworkConstraintsGetter struct {
+	constraints NetworkConstraints
+}
+
+var _ networkConstraintsGetter = (*testNetworkConstraintsGetter)(nil)
+
+func (t *testNetworkConstraintsGetter) NetworkConstraints() NetworkConstraints {
+	return t.constraints
+}
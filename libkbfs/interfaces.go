@@ -5,6 +5,9 @@
 package libkbfs
 
 import (
+	"io"
+	"net"
+	"os"
 	"time"
 
 	"github.com/keybase/client/go/libkb"
@@ -45,6 +48,10 @@ type blockServerGetter interface {
 	BlockServer() BlockServer
 }
 
+type kbfsOpsGetter interface {
+	KBFSOps() KBFSOps
+}
+
 type cryptoPureGetter interface {
 	cryptoPure() cryptoPure
 }
@@ -81,9 +88,78 @@ type diskLimiterGetter interface {
 	DiskLimiter() DiskLimiter
 }
 
+type networkModeGetter interface {
+	// NetworkMode returns the current network mode.
+	NetworkMode() NetworkMode
+}
+
+// Dialer makes the network connections to remote KBFS servers.
+type Dialer interface {
+	// Dial connects to `addr` on the named network, as in
+	// net.Dialer.DialContext.
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type dialerGetter interface {
+	// Dialer returns the Dialer to use for server connections, or
+	// nil if connections should be made directly.
+	Dialer() Dialer
+}
+
+type networkConstraintsGetter interface {
+	// NetworkConstraints returns the current network usage hints.
+	NetworkConstraints() NetworkConstraints
+}
+
+type blockSplitPolicyGetter interface {
+	// BlockSplitPolicy returns the policy for splitting and padding
+	// newly-written file blocks.
+	BlockSplitPolicy() BlockSplitPolicy
+}
+
+type retryPolicyGetter interface {
+	// RetryPolicy returns the policy for retrying failed calls to
+	// the MD and block servers, or nil if they're never retried.
+	RetryPolicy() RetryPolicy
+}
+
+type metricsRegistryGetter interface {
+	// MetricsRegistry may be nil, which should be interpreted as
+	// not using metrics at all.
+	MetricsRegistry() metrics.Registry
+}
+
+// RetryPolicy decides whether, and after how long, a call that MDOps
+// or BlockOps makes to a server should be retried after it fails.
+type RetryPolicy interface {
+	// NextRetry is called after the named call, like
+	// "MDOps.GetForTLF", has failed with err for the given number of
+	// times in a row, the first attempt having started the given
+	// time ago.  It returns how long to wait before trying the call
+	// again, or false if it shouldn't be retried.
+	NextRetry(op string, failures int, elapsed time.Duration, err error) (
+		wait time.Duration, retry bool)
+}
+
+type logPathRedactor interface {
+	// RedactLogPath returns the form of p that may be written to
+	// the logs under the current LogPathsMode: p itself, an opaque
+	// token that stays the same for p for the life of the process,
+	// or a placeholder.
+	RedactLogPath(p string) string
+}
+
 type syncedTlfGetterSetter interface {
 	IsSyncedTlf(tlfID tlf.ID) bool
 	SetTlfSyncState(tlfID tlf.ID, isSynced bool) error
+	// TlfExcludePatterns returns the patterns of paths in the given
+	// TLF that shouldn't be synced, prefetched or mirrored, or nil
+	// if there are none.
+	TlfExcludePatterns(tlfID tlf.ID) *ExcludePatterns
+	// SetTlfExcludePatterns sets the exclude patterns for the given
+	// TLF, in the syntax of a .gitignore file, one per line.  An
+	// empty list removes them.
+	SetTlfExcludePatterns(tlfID tlf.ID, patterns []string) error
 }
 
 type blockRetrieverGetter interface {
@@ -208,19 +284,23 @@ type Node interface {
 // action.
 type KBFSOps interface {
 	// GetFavorites returns the logged-in user's list of favorite
-	// top-level folders.  This is a remote-access operation.
+	// top-level folders.  This is a remote-access operation, but if
+	// the server can't be reached, the last list fetched for the same
+	// user is returned instead.
 	GetFavorites(ctx context.Context) ([]Favorite, error)
 	// RefreshCachedFavorites tells the instances to forget any cached
 	// favorites list and fetch a new list from the server.  The
 	// effects are asychronous; if there's an error refreshing the
-	// favorites, the cached favorites will become empty.
+	// favorites, the cached favorites stay as they were.
 	RefreshCachedFavorites(ctx context.Context)
 	// AddFavorite adds the favorite to both the server and
-	// the local cache.
+	// the local cache, even if it was ignored.
 	AddFavorite(ctx context.Context, fav Favorite) error
 	// DeleteFavorite deletes the favorite from both the server and
 	// the local cache.  Idempotent, so it succeeds even if the folder
-	// isn't favorited.
+	// isn't favorited.  The folder is then ignored: opening it
+	// doesn't add it back to the favorites, until AddFavorite is
+	// called for it.
 	DeleteFavorite(ctx context.Context, fav Favorite) error
 
 	// GetTLFCryptKeys gets crypt key of all generations as well as
@@ -264,6 +344,19 @@ type KBFSOps interface {
 	// given Node, if the logged-in user has read permissions to the
 	// top-level folder.  This is a remote-access operation.
 	Stat(ctx context.Context, node Node) (EntryInfo, error)
+	// GetNodeByPath returns the Node and entry info for the given
+	// slash-separated path, relative to the root of the given
+	// folder-branch, by looking up each of its components in turn.
+	// Relative symlinks along the way, including one at the end of
+	// the path, are followed, up to Config.MaxSymlinkLevels of them;
+	// absolute symlinks, and those that point outside of the TLF,
+	// cause an error.  This is a remote-access operation.
+	GetNodeByPath(ctx context.Context, folderBranch FolderBranch, p string) (
+		Node, EntryInfo, error)
+	// StatByPath is like GetNodeByPath, but only returns the entry
+	// info.  This is a remote-access operation.
+	StatByPath(ctx context.Context, folderBranch FolderBranch, p string) (
+		EntryInfo, error)
 	// CreateDir creates a new subdirectory under the given node, if
 	// the logged-in user has write permission to the top-level
 	// folder.  Returns the new Node for the created subdirectory, and
@@ -295,6 +388,13 @@ type KBFSOps interface {
 	// given node, if the logged-in user has write permission to the
 	// top-level folder.  This is a remote-sync operation.
 	RemoveEntry(ctx context.Context, dir Node, name string) error
+	// RemoveAll removes the given entry of dir, along with
+	// everything under it if it's a directory, if the logged-in user
+	// has write permission to the top-level folder.  All the
+	// removals are batched into as few revisions as possible, so
+	// this is much cheaper than removing the entries one by one.
+	// This is a remote-sync operation.
+	RemoveAll(ctx context.Context, dir Node, name string) error
 	// Rename performs an atomic rename operation with a given
 	// top-level folder if the logged-in user has write permission to
 	// that folder, and will return an error if nodes from different
@@ -304,6 +404,31 @@ type KBFSOps interface {
 	// remote-sync operation.
 	Rename(ctx context.Context, oldParent Node, oldName string, newParent Node,
 		newName string) error
+	// RenameWithFlags is like Rename, but changes its behavior
+	// according to `flags`.  With RenameNoReplace, it fails with
+	// NameExistsError instead of replacing an existing entry.  With
+	// RenameExchange, it swaps the two entries, which must both
+	// exist.  Either way, the whole change shows up in a single
+	// revision.  Unlike Rename, it never falls back to copying
+	// between TLFs.
+	RenameWithFlags(ctx context.Context, oldParent Node, oldName string,
+		newParent Node, newName string, flags RenameFlags) error
+	// RenameExchange atomically swaps the two given entries, which
+	// must both exist within the same top-level folder; it's the
+	// same as RenameWithFlags with RenameExchange.  This is a
+	// remote-sync operation.
+	RenameExchange(ctx context.Context, oldParent Node, oldName string,
+		newParent Node, newName string) error
+	// CopyFile creates a new file named dstName in dstDir, with the
+	// same contents as the file represented by srcNode, if the
+	// logged-in user has write permission to the top-level folder.
+	// The copy shares the source's blocks, rather than re-uploading
+	// them, so its cost doesn't depend on the size of the file; the
+	// two files are independent from then on.  Returns an error if
+	// the nodes are from different top-level folders.  This is a
+	// remote-sync operation.
+	CopyFile(ctx context.Context, srcNode Node, dstDir Node,
+		dstName string) (Node, EntryInfo, error)
 	// Read fills in the given buffer with data from the file at the
 	// given node starting at the given offset, if the logged-in user
 	// has read permission to the top-level folder.  The read data
@@ -327,6 +452,14 @@ type KBFSOps interface {
 	// the necessary blocks have been locally cached.  This is a
 	// remote-access operation.
 	Write(ctx context.Context, file Node, data []byte, off int64) error
+	// WriteWithFlags is like Write, but changes its behavior
+	// according to `flags`, and returns the offset at which the
+	// data was actually written.  With WriteAppend, `off` is
+	// ignored, and the data is written at the end of the file as of
+	// the moment of the write, atomically with respect to all
+	// other local writes and truncates of the file.
+	WriteWithFlags(ctx context.Context, file Node, data []byte, off int64,
+		flags WriteFlags) (int64, error)
 	// Truncate modifies the file at the given node, by either
 	// shrinking or extending its size to match the given size, if the
 	// logged-in user has write permission to the top-level folder.
@@ -340,11 +473,42 @@ type KBFSOps interface {
 	// permissions to the top-level folder.  This is a remote-sync
 	// operation.
 	SetEx(ctx context.Context, file Node, ex bool) error
+	// SetMode sets the permission bits on the file or directory
+	// represented by a given node, which can't be the TLF root, if
+	// the logged-in user has write permissions to the top-level
+	// folder.  They're only advisory, since access is really decided
+	// by the TLF's readers and writers; see EntryInfo.Perm.  The
+	// user exec bit decides whether a file is executable, like
+	// SetEx, and setting the mode of a symlink is a no-op.  A mode of 0 goes back
+	// to the bits implied by the entry's type.  This is a
+	// remote-sync operation.
+	SetMode(ctx context.Context, node Node, mode os.FileMode) error
 	// SetMtime sets the modification time on the file represented by
 	// a given node, if the logged-in user has write permissions to
 	// the top-level folder.  If mtime is nil, it is a noop.  This is
 	// a remote-sync operation.
 	SetMtime(ctx context.Context, file Node, mtime *time.Time) error
+	// GetXattr returns the value of the extended attribute `name`
+	// of the given node, or NoSuchXattrError if it isn't set.  This
+	// is a remote-access operation.
+	GetXattr(ctx context.Context, node Node, name string) ([]byte, error)
+	// ListXattr returns the sorted names of all the extended
+	// attributes of the given node.  This is a remote-access
+	// operation.
+	ListXattr(ctx context.Context, node Node) ([]string, error)
+	// SetXattr sets the extended attribute `name` of the given node,
+	// which can't be the TLF root, to `value`, if the logged-in user
+	// has write permissions to the top-level folder.  Extended
+	// attributes are stored, encrypted, in the node's entry in its
+	// parent directory, so their names are limited to
+	// MaxXattrNameLength bytes, and all the names and values of a
+	// node together to MaxXattrsSize bytes.  This is a remote-sync
+	// operation.
+	SetXattr(ctx context.Context, node Node, name string, value []byte) error
+	// RemoveXattr removes the extended attribute `name` from the
+	// given node, or returns NoSuchXattrError if it isn't set.  This
+	// is a remote-sync operation.
+	RemoveXattr(ctx context.Context, node Node, name string) error
 	// SyncAll flushes all outstanding writes and truncates for any
 	// dirty files to the KBFS servers within the given folder, if the
 	// logged-in user has write permissions to the top-level folder.
@@ -365,10 +529,32 @@ type KBFSOps interface {
 	// KBFSStatus can be non-empty even if there is an error.
 	Status(ctx context.Context) (
 		KBFSStatus, <-chan StatusUpdate, error)
+	// ClearFolderErrors forgets the recent significant errors
+	// reported in the status of a particular folder/branch, e.g.
+	// after the user has dismissed them.
+	ClearFolderErrors(ctx context.Context, folderBranch FolderBranch) error
+	// GetQuotaInfo fetches the latest quota usage and limits of the
+	// logged-in user from the block server, including the usage of
+	// each of the user's TLFs keyed by TLF ID string.  The usage of
+	// team TLFs is charged to the team instead, and shows up in the
+	// FolderBranchStatus of each team TLF.
+	GetQuotaInfo(ctx context.Context) (*kbfsblock.QuotaInfo, error)
 	// UnstageForTesting clears out this device's staged state, if
 	// any, and fast-forwards to the current head of this
 	// folder-branch.
 	UnstageForTesting(ctx context.Context, folderBranch FolderBranch) error
+	// GetUnmergedChanges returns a summary of this device's staged
+	// changes to the given folder-branch, if any.
+	GetUnmergedChanges(ctx context.Context, folderBranch FolderBranch) (
+		UnmergedChanges, error)
+	// Unstage discards this device's staged changes to the given
+	// folder-branch, and fast-forwards to the current head of the
+	// folder-branch.  `expected` must be the result of a recent
+	// GetUnmergedChanges call; if the staged changes differ from it,
+	// nothing is discarded and UnmergedChangesChangedError is
+	// returned.
+	Unstage(ctx context.Context, folderBranch FolderBranch,
+		expected UnmergedChanges) error
 	// RequestRekey requests to rekey this folder. Note that this asynchronously
 	// requests a rekey, so canceling ctx doesn't cancel the rekey.
 	RequestRekey(ctx context.Context, id tlf.ID)
@@ -398,9 +584,86 @@ type KBFSOps interface {
 
 	// GetNodeMetadata gets metadata associated with a Node.
 	GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error)
+	// GetNodeHistory returns the revisions of the folder in which
+	// the entry at the current path of the given node was created or
+	// changed, oldest first.  The entry is looked up by name in each
+	// revision, so if the node was renamed, revisions from before
+	// the rename aren't included.  Like GetUpdateHistory, this
+	// fetches every merged revision of the folder, and should be
+	// used sparingly.  Any unsynced changes are not included.
+	GetNodeHistory(ctx context.Context, node Node) ([]NodeRevision, error)
+	// GetUsageBreakdown returns how much storage each top-level
+	// file and directory of the given folder uses, both logically
+	// and on the server, as of the current revision, ignoring any
+	// unsynced writes.  Only block pointers are read, not file
+	// contents, and results are cached per subtree, so only the
+	// subtrees that changed since the last call are walked again.
+	GetUsageBreakdown(ctx context.Context, folderBranch FolderBranch) (
+		UsageBreakdown, error)
+	// GetSubtreeUsage returns how much storage the given file or
+	// directory and everything under it uses, both logically and on
+	// the server, as of the current revision, ignoring any unsynced
+	// writes.  A block shared by several files in the subtree is
+	// only counted once.  Like GetUsageBreakdown, it only reads
+	// block pointers, caches results per subtree, and stops early
+	// if `ctx` is canceled.
+	GetSubtreeUsage(ctx context.Context, node Node) (SubtreeUsage, error)
+	// SetAdvisoryLock takes or releases (if `lock.Type` is
+	// AdvisoryUnlock) an advisory lock on a byte range of the given
+	// file, like fcntl(F_SETLK) does.  If `wait` is true and a
+	// conflicting lock is held, it blocks until that lock is
+	// released, like F_SETLKW; otherwise it fails with an
+	// AdvisoryLockConflictError.  While this device holds any lock
+	// on a file, it also holds the file's lock on the MD server, so
+	// other devices can't lock the file at all.
+	SetAdvisoryLock(ctx context.Context, file Node, lock AdvisoryLock,
+		wait bool) error
+	// GetAdvisoryLock returns a lock held on this device that
+	// conflicts with `lock`, like fcntl(F_GETLK) does, or a lock of
+	// type AdvisoryUnlock if there is none.  Locks held by other
+	// devices aren't reported.
+	GetAdvisoryLock(ctx context.Context, file Node, lock AdvisoryLock) (
+		AdvisoryLock, error)
+	// CreateTempFile creates a new, empty file in the folder of the
+	// given directory, like CreateFile does, except that the file
+	// isn't linked into any directory, like a file opened with
+	// O_TMPFILE.  Writes and attribute changes to the returned node
+	// are only kept in memory, and are lost if the file is never
+	// linked with LinkTempFile, so it's best suited to files of
+	// modest size.
+	CreateTempFile(ctx context.Context, dir Node, isExec bool) (
+		Node, EntryInfo, error)
+	// LinkTempFile links a file made by CreateTempFile into the given
+	// directory under the given name, which must not exist yet.  The
+	// new entry and the file's contents are published together, in
+	// a single MD update, so no other client ever sees a partly
+	// written file.
+	//
+	// This is a remote-sync operation.
+	LinkTempFile(ctx context.Context, dir Node, name string, file Node) (
+		EntryInfo, error)
+	// GetDirChildrenPaged returns up to `maxEntries` children of the
+	// directory, sorted by name, starting after the given cursor,
+	// which should be empty for the first page.  It also returns
+	// the cursor for the next page, which is empty once all entries
+	// have been returned.  If `maxEntries` isn't positive, all the
+	// remaining entries are returned.  Entries added or removed
+	// between calls may or may not show up in later pages, but no
+	// entry that exists throughout the listing is skipped or
+	// repeated.  This is a remote-access operation.
+	GetDirChildrenPaged(ctx context.Context, dir Node, cursor string,
+		maxEntries int) (children []DirChild, nextCursor string, err error)
+	// ReadAtRevision is like Read, but reads the contents that the
+	// file at the current path of the given node had as of the
+	// given merged revision of its folder, e.g. one returned by
+	// GetNodeHistory.  It ignores any unsynced writes to the file.
+	ReadAtRevision(ctx context.Context, file Node, rev kbfsmd.Revision,
+		dest []byte, off int64) (int64, error)
 
 	// Shutdown is called to clean up any resources associated with
-	// this KBFSOps instance.
+	// this KBFSOps instance.  All folders are shut down concurrently,
+	// and the errors from all of them are returned together.  If ctx
+	// is done first, Shutdown returns without waiting for the rest.
 	Shutdown(ctx context.Context) error
 	// PushConnectionStatusChange updates the status of a service for
 	// human readable connection status tracking.
@@ -411,6 +674,18 @@ type KBFSOps interface {
 	// ClearPrivateFolderMD clears any cached private folder metadata,
 	// e.g. on a logout.
 	ClearPrivateFolderMD(ctx context.Context)
+	// ResetForUser shuts down and discards the state of all
+	// folders if they were opened on behalf of a user other than
+	// `uid`, so that no per-user state leaks across sessions.  It
+	// should be called whenever a user logs in, and returns true if
+	// the folders were reset.
+	ResetForUser(ctx context.Context, uid keybase1.UID) bool
+	// AuditLocalPlaintext scans the local caches and journals for
+	// unencrypted copies of the names of any private files and
+	// directories currently cached in memory, and returns
+	// everywhere one was found.  Names shorter than a few bytes are
+	// skipped, since they would match ciphertext by chance.
+	AuditLocalPlaintext(ctx context.Context) ([]LocalPlaintextLeak, error)
 	// ForceFastForward forwards the nodes of all folders that have
 	// been previously cleared with `ClearPrivateFolderMD` to their
 	// newest version.  It works asynchronously, so no error is
@@ -537,8 +812,10 @@ type KeybaseService interface {
 	// favorites.
 	FavoriteDelete(ctx context.Context, folder keybase1.Folder) error
 
-	// FavoriteList returns the current list of favorites.
-	FavoriteList(ctx context.Context, sessionID int) ([]keybase1.Folder, error)
+	// FavoriteList returns the current list of favorites, along with
+	// the folders that were removed from it and are ignored.
+	FavoriteList(ctx context.Context, sessionID int) (
+		keybase1.FavoritesResult, error)
 
 	// Notify sends a filesystem notification.
 	Notify(ctx context.Context, notification *keybase1.FSNotification) error
@@ -711,12 +988,13 @@ type KBPKI interface {
 	FavoriteAdd(ctx context.Context, folder keybase1.Folder) error
 
 	// FavoriteDelete deletes folder from the list of the logged in user's
-	// favorite folders.  It is idempotent.
+	// favorite folders, and marks it as ignored.  It is idempotent.
 	FavoriteDelete(ctx context.Context, folder keybase1.Folder) error
 
-	// FavoriteList returns the list of all favorite folders for
-	// the logged in user.
-	FavoriteList(ctx context.Context) ([]keybase1.Folder, error)
+	// FavoriteList returns the list of all favorite folders for the
+	// logged in user, along with the folders they deleted from it,
+	// which are ignored until they're explicitly added again.
+	FavoriteList(ctx context.Context) (keybase1.FavoritesResult, error)
 
 	// CreateTeamTLF associates the given TLF ID with the team ID in
 	// the team's sigchain.  If the team already has a TLF ID
@@ -1742,6 +2020,8 @@ type NodeChange struct {
 	// Basenames of entries added/removed.
 	DirUpdated  []string
 	FileUpdated []WriteRange
+	// Type describes the kinds of change; it is zero if unknown.
+	Type NodeChangeType
 }
 
 // Observer can be notified that there is an available update for a
@@ -1759,7 +2039,9 @@ type Observer interface {
 	// list of all the nodes that had their underlying data changed,
 	// even if it wasn't an user-visible change (e.g., if a
 	// subdirectory was updated, the directory block for the TLF root
-	// is updated but that wouldn't be visible to a user).
+	// is updated but that wouldn't be visible to a user).  `ctx`
+	// carries the NotificationSeqnos of the notification under
+	// CtxNotificationSeqnosKey.
 	BatchChanges(ctx context.Context, changes []NodeChange,
 		allAffectedNodeIDs []NodeID)
 	// TlfHandleChange announces that the handle of the corresponding
@@ -1776,12 +2058,28 @@ type Observer interface {
 // Notifier notifies registrants of directory changes
 type Notifier interface {
 	// RegisterForChanges declares that the given Observer wants to
-	// subscribe to updates for the given top-level folders.
+	// subscribe to updates for the given top-level folders.  The
+	// registration is all-or-nothing: if it fails for any of the
+	// folders, the Observer is left unregistered from all of them.
 	RegisterForChanges(folderBranches []FolderBranch, obs Observer) error
+	// RegisterForChangesWithFilter is like RegisterForChanges, but
+	// the Observer only receives notifications that match `filter`.
+	// BatchChanges is only called if at least one change matches,
+	// and the `allAffectedNodeIDs` passed to it are not filtered.
+	RegisterForChangesWithFilter(folderBranches []FolderBranch,
+		obs Observer, filter ObserverFilter) error
 	// UnregisterFromChanges declares that the given Observer no
 	// longer wants to subscribe to updates for the given top-level
-	// folders.
+	// folders.  It tries every folder, even if some fail, and
+	// returns the first error.
 	UnregisterFromChanges(folderBranches []FolderBranch, obs Observer) error
+	// RegisterForConnectionChanges declares that the given
+	// ConnectionObserver wants to be told whenever the aggregate
+	// connection state changes.
+	RegisterForConnectionChanges(obs ConnectionObserver)
+	// UnregisterFromConnectionChanges declares that the given
+	// ConnectionObserver no longer wants connection state updates.
+	UnregisterFromConnectionChanges(obs ConnectionObserver)
 }
 
 // Clock is an interface for getting the current time
@@ -1892,8 +2190,14 @@ type Config interface {
 	diskLimiterGetter
 	syncedTlfGetterSetter
 	initModeGetter
+	networkModeGetter
+	networkConstraintsGetter
+	blockSplitPolicyGetter
+	retryPolicyGetter
+	dialerGetter
+	logPathRedactor
 	Tracer
-	KBFSOps() KBFSOps
+	kbfsOpsGetter
 	SetKBFSOps(KBFSOps)
 	KBPKI() KBPKI
 	SetKBPKI(KBPKI)
@@ -1928,6 +2232,10 @@ type Config interface {
 	SetKeybaseService(KeybaseService)
 	BlockSplitter() BlockSplitter
 	SetBlockSplitter(BlockSplitter)
+	// SetBlockSplitPolicy sets the policy for splitting and padding
+	// newly-written file blocks, and replaces the block splitter to
+	// match it.
+	SetBlockSplitPolicy(BlockSplitPolicy) error
 	Notifier() Notifier
 	SetNotifier(Notifier)
 	SetClock(Clock)
@@ -2023,6 +2331,91 @@ type Config interface {
 	// before syncing a set of changes to the servers.
 	SetBGFlushPeriod(p time.Duration)
 
+	// OpTimeouts returns how long each class of KBFSOps call may run
+	// before it is canceled.
+	OpTimeouts() OpTimeoutPolicy
+	// SetOpTimeouts sets how long each class of KBFSOps call may run
+	// before it is canceled, from now on.
+	SetOpTimeouts(p OpTimeoutPolicy)
+	// SetRetryPolicy sets the policy for retrying failed calls to
+	// the MD and block servers; nil turns retries off.
+	SetRetryPolicy(p RetryPolicy)
+	// FrontendCapabilities returns the classes of KBFSOps calls
+	// that requests from the given frontend may make.  By default,
+	// every frontend may make every call.
+	FrontendCapabilities(frontend RequestFrontend) OpCapabilities
+	// SetFrontendCapabilities restricts requests from the given
+	// frontend to the given classes of KBFSOps calls from now on.
+	// The restriction is enforced by KBFSOps itself, so it holds
+	// no matter how the frontend behaves.
+	SetFrontendCapabilities(frontend RequestFrontend, caps OpCapabilities)
+	// MaxSymlinkLevels returns how many symlinks KBFSOps will
+	// follow while resolving a single path.
+	MaxSymlinkLevels() int
+	// SetMaxSymlinkLevels sets how many symlinks KBFSOps will follow
+	// while resolving a single path, from now on.
+	SetMaxSymlinkLevels(levels int)
+	// DirListingWorkers returns how many children of a directory
+	// have their blocks fetched in parallel while it is listed.
+	DirListingWorkers() int
+	// SetDirListingWorkers sets how many children of a directory
+	// have their blocks fetched in parallel while it is listed, from
+	// now on.
+	SetDirListingWorkers(n int)
+	// ReadAhead returns how far, and how aggressively, sequential
+	// file reads are read ahead.
+	ReadAhead() ReadAheadParams
+	// SetReadAhead sets how far, and how aggressively, sequential
+	// file reads are read ahead, from now on.
+	SetReadAhead(p ReadAheadParams)
+	// RemoteChangePolicy returns what folders do when another device
+	// changes them while they have unsynced local changes.
+	RemoteChangePolicy() RemoteChangePolicy
+	// SetRemoteChangePolicy sets what folders do when another device
+	// changes them while they have unsynced local changes, from now
+	// on.
+	SetRemoteChangePolicy(p RemoteChangePolicy)
+
+	// SetNetworkMode restricts the directions in which KBFS uses
+	// the network from now on.  Switching out of
+	// NetworkModeDownloadOnly resumes any journal flushes that were
+	// held back.
+	SetNetworkMode(ctx context.Context, mode NetworkMode) error
+	// SetNetworkConstraints sets hints about how sparingly KBFS
+	// should use the network.  When metered is true, prefetching
+	// and update fetches are cut back; when maxRate is positive,
+	// background journal uploads are limited to maxRate bytes per
+	// second.
+	SetNetworkConstraints(metered bool, maxRate int64)
+	// SetDialer sets the Dialer used for new connections to remote
+	// servers, e.g. to go through a proxy.  It must be called before
+	// the MD and block servers are created.
+	SetDialer(Dialer)
+	// LogPathsMode returns how much of file names and paths KBFS
+	// reveals in its log messages.
+	LogPathsMode() LogPathsMode
+	// SetLogPathsMode changes how much of file names and paths
+	// KBFS reveals in its log messages from now on.
+	SetLogPathsMode(mode LogPathsMode) error
+	// WipeLocalData permanently erases everything KBFS has stored
+	// on local disk for the current user: the disk block caches,
+	// the synced TLF list, and the user's write journals
+	// (including any MD and block writes not yet flushed to the
+	// servers).  Files are overwritten before they are removed,
+	// and the removal is verified afterwards.  If progress is
+	// non-nil, it is called after each file is wiped.  It is meant
+	// to be called on logout or when the device is being
+	// decommissioned.
+	WipeLocalData(ctx context.Context,
+		progress func(LocalDataWipeProgress)) error
+	// GenerateDebugBundle writes a zip archive to w for sharing
+	// with support, containing the global and per-folder status,
+	// cache stats, metrics, a goroutine dump, recent errors and
+	// the end of the KBFS logs.  Any private file or directory
+	// names KBFS knows about are replaced throughout with the same
+	// tokens used by LogPathsHashed.
+	GenerateDebugBundle(ctx context.Context, w io.Writer) error
+
 	// Shutdown is called to free config resources.
 	Shutdown(context.Context) error
 	// CheckStateOnShutdown tells the caller whether or not it is safe
@@ -2090,6 +2483,8 @@ type NodeCache interface {
 	PathFromNode(node Node) path
 	// AllNodes returns the complete set of nodes currently in the cache.
 	AllNodes() []Node
+	// NumNodes returns the number of nodes currently in the cache.
+	NumNodes() int
 	// AddRootWrapper adds a new wrapper function that will be applied
 	// whenever a root Node is created.
 	AddRootWrapper(func(Node) Node)
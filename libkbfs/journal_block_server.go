@@ -117,6 +117,38 @@ func (j journalBlockServer) Put(
 	return j.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
 }
 
+func (j journalBlockServer) PutAgain(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context,
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
+	// Don't trace this function, for the same reasons as Put.
+
+	// Blocks keyed by their contents are put again under a new
+	// reference when AddBlockReference fails, so they must go
+	// through the journal too.  Once flushed, they're only
+	// uploaded if the server doesn't already have them.
+	if tlfJournal, ok := j.jServer.getTLFJournal(tlfID, nil); ok {
+		defer func() {
+			err = translateToBlockServerError(err)
+		}()
+		err := tlfJournal.putBlockDataAgain(
+			ctx, id, context, buf, serverHalf)
+		switch e := errors.Cause(err).(type) {
+		case nil:
+			usedQuotaBytes, quotaBytes := tlfJournal.getQuotaInfo()
+			return j.jServer.maybeReturnOverQuotaError(
+				usedQuotaBytes, quotaBytes)
+		case errTLFJournalDisabled:
+			break
+		case *ErrDiskLimitTimeout:
+			return j.jServer.maybeMakeDiskLimitErrorReportable(e)
+		default:
+			return err
+		}
+	}
+
+	return j.BlockServer.PutAgain(ctx, tlfID, id, context, buf, serverHalf)
+}
+
 func (j journalBlockServer) AddBlockReference(
 	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
 	context kbfsblock.Context) (err error) {
@@ -130,3 +130,43 @@ func TestJournalBlockServerPutGetAddReference(t *testing.T) {
 	require.Equal(t, data, buf)
 	require.Equal(t, serverHalf, key)
 }
+
+func TestJournalBlockServerPutAgain(t *testing.T) {
+	tempdir, ctx, cancel, config, jServer := setupJournalBlockServerTest(t)
+	defer teardownJournalBlockServerTest(t, tempdir, ctx, cancel, config)
+
+	// Use a shutdown-only BlockServer so that it errors if the
+	// journal tries to access it.
+	jServer.delegateBlockServer = shutdownOnlyBlockServer{}
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	err := jServer.Enable(ctx, tlfID, nil, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	blockServer := config.BlockServer()
+
+	// Put a block under a non-zero refnonce, like a block keyed by
+	// its contents that wasn't already stored.
+	uid1 := keybase1.MakeTestUID(1)
+	nonce, err := kbfsblock.MakeRefNonce()
+	require.NoError(t, err)
+	bCtx := kbfsblock.MakeContext(
+		uid1.AsUserOrTeam(), uid1.AsUserOrTeam(), nonce,
+		keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = blockServer.PutAgain(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	// It should come back from the journal.
+	buf, key, err := blockServer.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, serverHalf, key)
+	isUnflushed, err := blockServer.IsUnflushed(ctx, tlfID, bID)
+	require.NoError(t, err)
+	require.True(t, isUnflushed)
+}
@@ -537,6 +537,9 @@ func (j *JournalServer) enableLocked(
 	if err != nil {
 		return nil, err
 	}
+	if !j.config.NetworkMode().uploadsEnabled() {
+		tj.pause(journalPauseNetworkMode)
+	}
 
 	return tj, nil
 }
@@ -657,6 +660,25 @@ func (j *JournalServer) ResumeBackgroundWork(ctx context.Context, tlfID tlf.ID)
 		tlfID)
 }
 
+// setUploadsPaused pauses or resumes the background flushing of
+// every journal on behalf of the network mode, independently of any
+// pause requested via PauseBackgroundWork.
+func (j *JournalServer) setUploadsPaused(ctx context.Context, paused bool) {
+	j.log.CDebugf(ctx, "Setting uploads paused=%t for all journals", paused)
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	for _, tlfJournal := range j.tlfJournals {
+		if paused {
+			tlfJournal.pause(journalPauseNetworkMode)
+		} else {
+			tlfJournal.resume(journalPauseNetworkMode)
+			// Kick off a flush right away, in case an upload
+			// attempt failed and is waiting on a backoff timer.
+			tlfJournal.signalWork()
+		}
+	}
+}
+
 // Flush flushes the write journal for the given TLF.
 func (j *JournalServer) Flush(ctx context.Context, tlfID tlf.ID) (err error) {
 	j.log.CDebugf(ctx, "Flushing journal for %s", tlfID)
@@ -920,6 +942,36 @@ func (j *JournalServer) shutdownExistingJournals(ctx context.Context) {
 	j.shutdownExistingJournalsLocked(ctx)
 }
 
+// journalDirsForUser returns the directories of all the TLF
+// journals on disk that belong to the given user, whether or not
+// they are currently enabled.
+func (j *JournalServer) journalDirsForUser(
+	ctx context.Context, uid keybase1.UID) ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(j.rootPath())
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, fi := range fileInfos {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := filepath.Join(j.rootPath(), fi.Name())
+		dirUID, _, _, _, err := readTLFJournalInfoFile(dir)
+		if err != nil {
+			j.log.CDebugf(ctx, "Skipping non-TLF dir %q: %+v", dir, err)
+			continue
+		}
+		if dirUID == uid {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
 func (j *JournalServer) shutdown(ctx context.Context) {
 	j.log.CDebugf(ctx, "Shutting down journal")
 	j.lock.Lock()
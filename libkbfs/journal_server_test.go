@@ -998,3 +998,77 @@ func TestJournalQuotaStatusForGitBlocks(t *testing.T) {
 	require.Equal(
 		t, int64(2000), bs.JournalTrackerStatus.QuotaStatus.QuotaBytes)
 }
+
+func TestJournalServerNetworkMode(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	jServer.delegateBlockServer = NewBlockServerNetworkMode(
+		jServer.delegateBlockServer, config)
+	delegateBlockServer := jServer.delegateBlockServer
+
+	// Set the mode first, so the journal starts out paused.
+	err := config.SetNetworkMode(ctx, NetworkModeDownloadOnly)
+	require.NoError(t, err)
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	err = jServer.Enable(ctx, tlfID, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+
+	blockServer := config.BlockServer()
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "test_user1", tlf.Private)
+	require.NoError(t, err)
+	id := h.ResolvedWriters()[0]
+
+	t.Log("In download-only mode, writes go to the journal but aren't " +
+		"flushed.")
+	bCtx := kbfsblock.MakeFirstContext(id, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = blockServer.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	err = jServer.Wait(ctx, tlfID)
+	require.NoError(t, err)
+	status, err := jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), status.BlockOpCount)
+
+	t.Log("Direct uploads are refused.")
+	err = delegateBlockServer.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.IsType(t, NetworkModeError{}, err)
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	_, err = jServer.delegateMDOps.Put(ctx, rmd, session.VerifyingKey,
+		nil, keybase1.MDPriorityNormal)
+	require.IsType(t, NetworkModeError{}, err)
+
+	t.Log("Going back to normal mode flushes the journal.")
+	err = config.SetNetworkMode(ctx, NetworkModeNormal)
+	require.NoError(t, err)
+	err = jServer.Wait(ctx, tlfID)
+	require.NoError(t, err)
+	status, err = jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), status.BlockOpCount)
+
+	t.Log("In upload-only mode, downloads are refused.")
+	err = config.SetNetworkMode(ctx, NetworkModeUploadOnly)
+	require.NoError(t, err)
+	_, _, err = delegateBlockServer.Get(ctx, tlfID, bID, bCtx)
+	require.IsType(t, NetworkModeError{}, err)
+
+	err = config.SetNetworkMode(ctx, NetworkModeNormal)
+	require.NoError(t, err)
+	buf, key, err := delegateBlockServer.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, serverHalf, key)
+}
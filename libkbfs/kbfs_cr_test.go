@@ -14,6 +14,7 @@ import (
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
@@ -406,6 +407,82 @@ func TestUnmergedAfterRestart(t *testing.T) {
 		rootNode2.GetFolderBranch(), "Node 2 (after unstage)")
 }
 
+// Tests that Unstage reports the unmerged changes it will discard,
+// and refuses to discard changes the caller hasn't seen.
+func TestUnstageWithSummary(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	fb := rootNode1.GetFolderBranch()
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	changes, err := kbfsOps1.GetUnmergedChanges(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, UnmergedChanges{}, changes)
+
+	_, err = DisableUpdatesForTesting(config1, fb)
+	require.NoError(t, err)
+	DisableCRForTesting(config1, fb)
+
+	// user2 writes to the file, and then user1 makes a conflicting
+	// write, which leaves user1 staged.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	data2 := []byte{2}
+	err = kbfsOps2.Write(ctx, fileNode2, data2, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	checkStatus(t, ctx, kbfsOps1, true, userName1, nil, fb, "Node 1")
+
+	changes, err = kbfsOps1.GetUnmergedChanges(ctx, fb)
+	require.NoError(t, err)
+	require.NotEqual(t, kbfsmd.NullBranchID, changes.BranchID)
+	require.True(t, changes.First <= changes.Last)
+	var paths []string
+	for _, c := range changes.Changes {
+		paths = append(paths, c.Path)
+	}
+	require.Contains(t, paths, name+"/a")
+
+	// A stale summary doesn't discard anything.
+	stale := changes
+	stale.Last--
+	err = kbfsOps1.Unstage(ctx, fb, stale)
+	require.IsType(t, UnmergedChangesChangedError{}, errors.Cause(err))
+	checkStatus(t, ctx, kbfsOps1, true, userName1, nil, fb, "Node 1 (stale)")
+
+	err = kbfsOps1.Unstage(ctx, fb, changes)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	checkStatus(t, ctx, kbfsOps1, false, userName1, nil, fb,
+		"Node 1 (after unstage)")
+	readAndCompareData(t, config1, ctx, name, data2, userName2)
+
+	changes, err = kbfsOps1.GetUnmergedChanges(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, UnmergedChanges{}, changes)
+}
+
 // Tests that multiple users can write to the same file sequentially
 // without any problems.
 func TestMultiUserWrite(t *testing.T) {
@@ -6,12 +6,15 @@ package libkbfs
 
 import (
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/chat1"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
@@ -26,9 +29,15 @@ type KBFSOpsStandard struct {
 	config   Config
 	log      logger.Logger
 	deferLog logger.Logger
-	ops      map[FolderBranch]*folderBranchOps
+	// ops has its own, sharded locking, so that lookups for
+	// unrelated folders don't contend.  When both are needed,
+	// opsLock must be taken before any lock within ops.
+	ops      *fboMap
 	opsByFav map[Favorite]*folderBranchOps
 	opsLock  sync.RWMutex
+	// sessionUID is the user who was last logged in when the
+	// current folder state was created.  Protected by opsLock.
+	sessionUID keybase1.UID
 	// reIdentifyControlChan controls reidentification.
 	// Sending a value to this channel forces all fbos
 	// to be marked for revalidation.
@@ -40,7 +49,55 @@ type KBFSOpsStandard struct {
 
 	currentStatus            kbfsCurrentStatus
 	quotaUsage               *EventuallyConsistentQuotaUsage
+	quotaNotifier            *quotaUsageNotifier
 	longOperationDebugDumper *ImpatientDebugDumper
+
+	// protects the cross-TLF rename settings
+	crossTlfRenameLock     sync.Mutex
+	crossTlfRenameEnabled  bool
+	crossTlfRenameProgress func(CrossTlfRenameProgress)
+
+	// protects the idle folder eviction settings
+	evictionLock       sync.Mutex
+	evictionTTL        time.Duration
+	evictionMaxFolders int
+	evictionShutdownCh chan struct{}
+
+	// protects mirrorJobs
+	mirrorJobsLock sync.Mutex
+	mirrorJobs     map[string]*mirrorJob
+
+	// protects rechunkJobs
+	rechunkJobsLock sync.Mutex
+	rechunkJobs     map[FolderBranch]*rechunkJob
+
+	// protects localSyncs
+	localSyncsLock sync.Mutex
+	localSyncs     map[*LocalSync]bool
+
+	// protects pinnedFiles, and the blocks pinned for each one
+	pinnedFilesLock sync.Mutex
+	pinnedFiles     map[NodeID]*pinnedFile
+
+	// protects readOnlyTlfs
+	readOnlyLock sync.Mutex
+	readOnlyTlfs map[tlf.ID]bool
+
+	// protects the hydration on read settings, and the files being
+	// or already hydrated on read
+	hydrationLock         sync.Mutex
+	hydrateOnRead         bool
+	hydrateOnReadProgress func(HydrationProgress)
+	hydrating             map[NodeID]bool
+	hydrated              map[NodeID]BlockPointer
+	hydrationShutdown     bool
+	hydrationShutdownCh   chan struct{}
+	hydrations            sync.WaitGroup
+
+	// protects the frontends that get sniffed MIME types
+	mimeTypeLock      sync.Mutex
+	mimeTypeFrontends map[RequestFrontend]bool
+	mimeTypes         *lru.Cache
 }
 
 var _ KBFSOps = (*KBFSOpsStandard)(nil)
@@ -54,11 +111,20 @@ func NewKBFSOpsStandard(config Config) *KBFSOpsStandard {
 		config:                config,
 		log:                   log,
 		deferLog:              log.CloneWithAddedDepth(1),
-		ops:                   make(map[FolderBranch]*folderBranchOps),
+		ops:                   newFBOMap(),
 		opsByFav:              make(map[Favorite]*folderBranchOps),
+		mirrorJobs:            make(map[string]*mirrorJob),
+		rechunkJobs:           make(map[FolderBranch]*rechunkJob),
+		localSyncs:            make(map[*LocalSync]bool),
+		pinnedFiles:           make(map[NodeID]*pinnedFile),
+		readOnlyTlfs:          make(map[tlf.ID]bool),
+		hydrating:             make(map[NodeID]bool),
+		hydrated:              make(map[NodeID]BlockPointer),
+		hydrationShutdownCh:   make(chan struct{}),
 		reIdentifyControlChan: make(chan chan<- struct{}),
-		favs:       NewFavorites(config),
-		quotaUsage: NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
+		favs:          NewFavorites(config),
+		quotaUsage:    NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
+		quotaNotifier: newQuotaUsageNotifier(config),
 		longOperationDebugDumper: NewImpatientDebugDumper(
 			config, longOperationDebugDumpDuration),
 	}
@@ -101,32 +167,73 @@ func (fs *KBFSOpsStandard) markForReIdentifyIfNeededLoop() {
 
 func (fs *KBFSOpsStandard) markForReIdentifyIfNeeded(
 	now time.Time, maxValid time.Duration) {
-	fs.opsLock.Lock()
-	defer fs.opsLock.Unlock()
-
-	for _, fbo := range fs.ops {
+	for _, fbo := range fs.ops.snapshot() {
 		fbo.markForReIdentifyIfNeeded(now, maxValid)
 	}
 }
 
 // Shutdown safely shuts down any background goroutines that may have
-// been launched by KBFSOpsStandard.
+// been launched by KBFSOpsStandard, shutting down all its folders
+// concurrently.
 func (fs *KBFSOpsStandard) Shutdown(ctx context.Context) error {
 	defer fs.longOperationDebugDumper.Shutdown() // shut it down last
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
 
 	close(fs.reIdentifyControlChan)
+	fs.SetFolderEviction(0, 0)
 	var errors []error
+	if err := fs.stopMirrorJobs(ctx); err != nil {
+		errors = append(errors, err)
+	}
+	if err := fs.stopRechunkJobs(ctx); err != nil {
+		errors = append(errors, err)
+	}
+	if err := fs.stopLocalSyncs(ctx); err != nil {
+		errors = append(errors, err)
+	}
+	if err := fs.stopPinnedFiles(ctx); err != nil {
+		errors = append(errors, err)
+	}
+	fs.stopHydrations()
 	if err := fs.favs.Shutdown(); err != nil {
 		errors = append(errors, err)
 	}
-	for _, ops := range fs.ops {
-		if err := ops.Shutdown(ctx); err != nil {
-			errors = append(errors, err)
-			// Continue on and try to shut down the other FBOs.
-		}
+
+	// Shut down all the FBOs at once, since each one may have to
+	// talk to the servers to check its state first.  A failure in
+	// one doesn't stop the others.
+	var errLock sync.Mutex
+	var fboErrors []error
+	var wg sync.WaitGroup
+	for _, ops := range fs.ops.snapshot() {
+		wg.Add(1)
+		go func(ops *folderBranchOps) {
+			defer wg.Done()
+			if err := ops.Shutdown(ctx); err != nil {
+				errLock.Lock()
+				defer errLock.Unlock()
+				fboErrors = append(fboErrors, err)
+			}
+		}(ops)
 	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		errors = append(errors, fboErrors...)
+	case <-ctx.Done():
+		// Stop waiting for any FBOs that haven't finished yet; they
+		// keep shutting down in the background.
+		errLock.Lock()
+		errors = append(errors, fboErrors...)
+		errLock.Unlock()
+		errors = append(errors, ctx.Err())
+	}
+
 	if len(errors) == 1 {
 		return errors[0]
 	} else if len(errors) > 1 {
@@ -153,39 +260,167 @@ func (fs *KBFSOpsStandard) ClearPrivateFolderMD(ctx context.Context) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
 
-	fs.opsLock.Lock()
-	defer fs.opsLock.Unlock()
-
 	// Block until all private folders have been reset.  TODO:
 	// parallelize these, as they can block for a while waiting for
 	// the lock.
-	for _, fbo := range fs.ops {
+	for _, fbo := range fs.ops.snapshot() {
 		// This call is a no-op for public folders.
 		fbo.ClearPrivateFolderMD(ctx)
 	}
 }
 
+// ResetForUser implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ResetForUser(
+	ctx context.Context, uid keybase1.UID) bool {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	fs.opsLock.Lock()
+	prevUID := fs.sessionUID
+	fs.sessionUID = uid
+	fs.opsLock.Unlock()
+	if prevUID == keybase1.UID("") || prevUID == uid {
+		return false
+	}
+
+	// Stop everything that was started on behalf of the previous
+	// user, since it may no longer be readable by the new one.
+	if err := fs.stopMirrorJobs(ctx); err != nil {
+		fs.log.CDebugf(ctx, "Couldn't stop mirror jobs: %+v", err)
+	}
+	if err := fs.stopRechunkJobs(ctx); err != nil {
+		fs.log.CDebugf(ctx, "Couldn't stop rechunk jobs: %+v", err)
+	}
+	if err := fs.stopLocalSyncs(ctx); err != nil {
+		fs.log.CDebugf(ctx, "Couldn't stop local syncs: %+v", err)
+	}
+	if err := fs.resetPinnedFiles(ctx); err != nil {
+		fs.log.CDebugf(ctx, "Couldn't reset pinned files: %+v", err)
+	}
+	fs.resetHydrations()
+	func() {
+		fs.readOnlyLock.Lock()
+		defer fs.readOnlyLock.Unlock()
+		fs.readOnlyTlfs = make(map[tlf.ID]bool)
+	}()
+	func() {
+		fs.mimeTypeLock.Lock()
+		defer fs.mimeTypeLock.Unlock()
+		if fs.mimeTypes != nil {
+			fs.mimeTypes.Purge()
+		}
+	}()
+	fs.quotaNotifier.reset()
+
+	fs.opsLock.Lock()
+	ops, done := fs.ops.clear()
+	fs.opsByFav = make(map[Favorite]*folderBranchOps)
+	fs.opsLock.Unlock()
+	defer done()
+
+	// Shut the folders down without holding any locks, since they
+	// may need to look up other ops while doing so.  The state of
+	// these folders can't be checked on shutdown, since it may no
+	// longer be readable by the new user.
+	fs.log.CDebugf(ctx, "User changed from %s to %s; shutting down %d "+
+		"folders", prevUID, uid, len(ops))
+	for _, fbo := range ops {
+		fbo.shutdown(ctx)
+	}
+	return true
+}
+
+// cachedPrivateNames returns the names of all the private entries
+// currently cached by any open folder.
+func (fs *KBFSOpsStandard) cachedPrivateNames() (names []string) {
+	for _, fbo := range fs.ops.snapshot() {
+		names = append(names, fbo.cachedPrivateNames()...)
+	}
+	return names
+}
+
+// openFolderBranches returns all the folder-branches that currently
+// have state in memory.
+func (fs *KBFSOpsStandard) openFolderBranches() []FolderBranch {
+	ops := fs.ops.snapshot()
+	fbs := make([]FolderBranch, 0, len(ops))
+	for fb := range ops {
+		fbs = append(fbs, fb)
+	}
+	return fbs
+}
+
+// AuditLocalPlaintext implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) AuditLocalPlaintext(ctx context.Context) (
+	[]LocalPlaintextLeak, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	names := fs.cachedPrivateNames()
+	roots := localDataRoots(fs.config)
+	fs.log.CDebugf(ctx, "Auditing %d local data roots for %d cached names",
+		len(roots), len(names))
+	leaks, err := auditLocalPlaintext(ctx, roots, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaks) > 0 {
+		fs.log.CWarningf(ctx, "Found %d plaintext names in local data",
+			len(leaks))
+	}
+	return leaks, nil
+}
+
 // ForceFastForward implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) ForceFastForward(ctx context.Context) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
 
-	fs.opsLock.Lock()
-	defer fs.opsLock.Unlock()
-
-	fs.log.CDebugf(ctx, "Forcing fast-forwards for %d folders", len(fs.ops))
-	for _, fbo := range fs.ops {
+	ops := fs.ops.snapshot()
+	fs.log.CDebugf(ctx, "Forcing fast-forwards for %d folders", len(ops))
+	for _, fbo := range ops {
 		fbo.ForceFastForward(ctx)
 	}
 }
 
+// startOp prepares ctx for a KBFSOps call of the given class: it
+// checks that the frontend the call came from may make it, counts
+// the call against that frontend, and applies the timeout policy for
+// the class.  Unless an error is returned, the returned done function
+// must always be called with the call's error once it's finished,
+// and returns the error the call should return instead, which is a
+// TimeoutError if the call ran out of time.
+func (fs *KBFSOpsStandard) startOp(ctx context.Context,
+	class OpTimeoutClass) (
+	context.Context, func(error) error, error) {
+	frontend := RequestSourceFromContext(ctx).Frontend
+	if !fs.config.FrontendCapabilities(frontend).Allows(class) {
+		return nil, nil, OpNotPermittedError{frontend, class}
+	}
+	markRequestSource(ctx, fs.config, class)
+	parent := ctx
+	ctx, cancel := withOpTimeout(parent, fs.config, class)
+	done := func(err error) error {
+		err = opTimeoutError(parent, ctx, fs.config, class, err)
+		cancel()
+		return err
+	}
+	return ctx, done, nil
+}
+
 // GetFavorites implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) GetFavorites(ctx context.Context) (
-	[]Favorite, error) {
+	favorites []Favorite, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
 
 	return fs.favs.Get(ctx)
 }
@@ -199,18 +434,42 @@ func (fs *KBFSOpsStandard) RefreshCachedFavorites(ctx context.Context) {
 	fs.favs.RefreshCache(ctx)
 }
 
+// RefreshFavorites fetches a new list of favorites from the server
+// and waits until the cached list has been replaced with it.  If that
+// fails, the cached list stays as it was.
+func (fs *KBFSOpsStandard) RefreshFavorites(ctx context.Context) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	return fs.favs.Refresh(ctx)
+}
+
 // AddFavorite implements the KBFSOps interface for KBFSOpsStandard.
 func (fs *KBFSOpsStandard) AddFavorite(ctx context.Context,
-	fav Favorite) error {
+	fav Favorite) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	kbpki := fs.config.KBPKI()
-	_, err := kbpki.GetCurrentSession(ctx)
+	_, err = kbpki.GetCurrentSession(ctx)
 	isLoggedIn := err == nil
 
 	if isLoggedIn {
-		err := fs.favs.Add(ctx, favToAdd{Favorite: fav, created: false})
+		err := fs.favs.Add(ctx, favToAdd{
+			Favorite: fav,
+			created:  false,
+			explicit: true,
+		})
 		if err != nil {
 			return err
 		}
@@ -228,12 +487,17 @@ func (fs *KBFSOpsStandard) getOpsByFav(fav Favorite) *folderBranchOps {
 // DeleteFavorite implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) DeleteFavorite(ctx context.Context,
-	fav Favorite) error {
+	fav Favorite) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	kbpki := fs.config.KBPKI()
-	_, err := kbpki.GetCurrentSession(ctx)
+	_, err = kbpki.GetCurrentSession(ctx)
 	isLoggedIn := err == nil
 
 	// Let this ops remove itself, if we have one available.
@@ -266,24 +530,48 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(
 		panic("zero FolderBranch in getOps")
 	}
 
-	fs.opsLock.RLock()
-	if ops, ok := fs.ops[fb]; ok {
-		fs.opsLock.RUnlock()
+	s := fs.ops.shard(fb)
+	s.lock.RLock()
+	if ops, ok := s.ops[fb]; ok {
+		ops.markUsed()
+		s.lock.RUnlock()
 		return ops
 	}
+	s.lock.RUnlock()
 
-	fs.opsLock.RUnlock()
-	fs.opsLock.Lock()
-	defer fs.opsLock.Unlock()
-	// look it up again in case someone else got the lock
-	ops, ok := fs.ops[fb]
-	if !ok {
-		// TODO: add some interface for specifying the type of the
-		// branch; for now assume online and read-write.
-		ops = newFolderBranchOps(ctx, fs.config, fb, standard)
-		fs.ops[fb] = ops
+	for {
+		s.lock.Lock()
+		// look it up again in case someone else got the lock
+		ops, ok := s.ops[fb]
+		if ok {
+			ops.markUsed()
+			s.lock.Unlock()
+			return ops
+		}
+		if evicting, ok := s.evicting[fb]; ok {
+			// Wait for the evicted ops to shut down before starting
+			// new ones, so the two don't both register for updates.
+			s.lock.Unlock()
+			<-evicting
+			continue
+		}
+
+		// TODO: add some interface for specifying whether the branch
+		// is offline; for now assume online, and read-write unless
+		// it's an archived view of the folder, or the folder was set
+		// to open read-only.
+		bType := standard
+		if fb.Branch.IsArchived() {
+			bType = archive
+		} else if fs.isFolderReadOnly(fb.Tlf) {
+			bType = standardReadOnly
+		}
+		ops = newFolderBranchOps(ctx, fs.config, fb, bType)
+		ops.markUsed()
+		s.ops[fb] = ops
+		s.lock.Unlock()
+		return ops
 	}
-	return ops
 }
 
 func (fs *KBFSOpsStandard) getOps(ctx context.Context,
@@ -323,10 +611,13 @@ func (fs *KBFSOpsStandard) getOpsByHandle(ctx context.Context,
 	// Track under its name, so we can later tell it to remove itself
 	// from the favorites list.
 	fs.opsByFav[fav] = ops
-	ops.RegisterForChanges(&kbfsOpsFavoriteObserver{
+	err := ops.RegisterForChanges(fb, &kbfsOpsFavoriteObserver{
 		kbfsOps: fs,
 		currFav: fav,
 	})
+	if err != nil {
+		fs.log.CWarningf(ctx, "Couldn't register favorite observer: %+v", err)
+	}
 	return ops
 }
 
@@ -483,6 +774,11 @@ func (fs *KBFSOpsStandard) GetTLFCryptKeys(
 	keys []kbfscrypto.TLFCryptKey, id tlf.ID, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return nil, tlf.ID{}, err
+	}
+	defer func() { err = done(err) }()
 
 	fs.log.CDebugf(ctx, "GetTLFCryptKeys(%s)", tlfHandle.GetCanonicalPath())
 	defer func() { fs.deferLog.CDebugf(ctx, "Done: %+v", err) }()
@@ -500,6 +796,11 @@ func (fs *KBFSOpsStandard) GetTLFID(ctx context.Context,
 	tlfHandle *TlfHandle) (id tlf.ID, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return tlf.ID{}, err
+	}
+	defer func() { err = done(err) }()
 
 	fs.log.CDebugf(ctx, "GetTLFID(%s)", tlfHandle.GetCanonicalPath())
 	defer func() { fs.deferLog.CDebugf(ctx, "Done: %+v", err) }()
@@ -513,14 +814,50 @@ func (fs *KBFSOpsStandard) GetTLFID(ctx context.Context,
 
 // GetTLFHandle implements the KBFSOps interface for KBFSOpsStandard.
 func (fs *KBFSOpsStandard) GetTLFHandle(ctx context.Context, node Node) (
-	*TlfHandle, error) {
+	handle *TlfHandle, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, node)
 	return ops.GetTLFHandle(ctx, node)
 }
 
+// getArchivedRootNode returns the root node of an archived branch of
+// the given TLF, or a nil Node if the TLF didn't exist yet at the
+// time the branch is frozen at.  Archived branches aren't tracked as
+// favorites by their handle, since they're just another view of the
+// master branch.
+func (fs *KBFSOpsStandard) getArchivedRootNode(
+	ctx context.Context, h *TlfHandle, branch BranchName) (
+	node Node, ei EntryInfo, err error) {
+	if h.tlfID == tlf.NullID {
+		// A team TLF that hasn't been created yet has no history.
+		return nil, EntryInfo{}, nil
+	}
+
+	fb := FolderBranch{Tlf: h.tlfID, Branch: branch}
+	ops := fs.getOpsNoAdd(ctx, fb)
+	lState := makeFBOLockState()
+	md, err := ops.getMDForReadNeedIdentifyOnMaybeFirstAccess(ctx, lState)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	if md == (ImmutableRootMetadata{}) {
+		return nil, EntryInfo{}, nil
+	}
+
+	node, ei, _, err = ops.getRootNode(ctx)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	return node, ei, nil
+}
+
 // getMaybeCreateRootNode is called for GetOrCreateRootNode and GetRootNode.
 func (fs *KBFSOpsStandard) getMaybeCreateRootNode(
 	ctx context.Context, h *TlfHandle, branch BranchName, create bool) (
@@ -529,6 +866,12 @@ func (fs *KBFSOpsStandard) getMaybeCreateRootNode(
 		h.GetCanonicalPath(), branch, create)
 	defer func() { fs.deferLog.CDebugf(ctx, "Done: %#v", err) }()
 
+	if branch.IsArchived() {
+		// Archived branches are read-only, so there's nothing to
+		// create.
+		return fs.getArchivedRootNode(ctx, h, branch)
+	}
+
 	// Check if we already have the MD cached, before contacting any
 	// servers.
 	fops := fs.getOpsByFav(h.ToFavorite())
@@ -603,11 +946,9 @@ func (fs *KBFSOpsStandard) getMaybeCreateRootNode(
 	// we might not be able to read the metadata if we aren't in the
 	// key group yet.
 	if err := isReadableOrError(ctx, fs.config.KBPKI(), md.ReadOnly()); err != nil {
-		fs.opsLock.Lock()
-		defer fs.opsLock.Unlock()
 		// If we already have an FBO for this ID, trigger a rekey
 		// prompt in the background, if possible.
-		if ops, ok := fs.ops[fb]; ok {
+		if ops, ok := fs.ops.get(fb); ok {
 			fs.log.CDebugf(ctx, "Triggering a paper prompt rekey on folder "+
 				"access due to unreadable MD for %s", h.GetCanonicalPath())
 			ops.rekeyFSM.Event(NewRekeyRequestWithPaperPromptEvent())
@@ -642,6 +983,11 @@ func (fs *KBFSOpsStandard) GetOrCreateRootNode(
 	node Node, ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	return fs.getMaybeCreateRootNode(ctx, h, branch, true)
 }
@@ -654,25 +1000,45 @@ func (fs *KBFSOpsStandard) GetRootNode(
 	node Node, ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	return fs.getMaybeCreateRootNode(ctx, h, branch, false)
 }
 
 // GetDirChildren implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetDirChildren(ctx context.Context, dir Node) (
-	map[string]EntryInfo, error) {
+	children map[string]EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
-	return ops.GetDirChildren(ctx, dir)
+	children, err = ops.GetDirChildren(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	fs.addMimeTypesIfNeeded(ctx, ops, dir, children)
+	return children, nil
 }
 
 // Lookup implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Lookup(ctx context.Context, dir Node, name string) (
-	Node, EntryInfo, error) {
+	node Node, ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.Lookup(ctx, dir, name)
@@ -680,19 +1046,66 @@ func (fs *KBFSOpsStandard) Lookup(ctx context.Context, dir Node, name string) (
 
 // Stat implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Stat(ctx context.Context, node Node) (
-	EntryInfo, error) {
+	ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, node)
-	return ops.Stat(ctx, node)
+	ei, err = ops.Stat(ctx, node)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	fs.addMimeTypeIfNeeded(ctx, ops, node, &ei)
+	return ei, nil
+}
+
+// GetNodeByPath implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetNodeByPath(
+	ctx context.Context, folderBranch FolderBranch, p string) (
+	node Node, ei EntryInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetNodeByPath(ctx, folderBranch, p)
+}
+
+// StatByPath implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) StatByPath(
+	ctx context.Context, folderBranch FolderBranch, p string) (
+	ei EntryInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.StatByPath(ctx, folderBranch, p)
 }
 
 // CreateDir implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) CreateDir(
-	ctx context.Context, dir Node, name string) (Node, EntryInfo, error) {
+	ctx context.Context, dir Node, name string) (node Node, ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateDir(ctx, dir, name)
@@ -701,9 +1114,14 @@ func (fs *KBFSOpsStandard) CreateDir(
 // CreateFile implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) CreateFile(
 	ctx context.Context, dir Node, name string, isExec bool, excl Excl) (
-	Node, EntryInfo, error) {
+	node Node, ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateFile(ctx, dir, name, isExec, excl)
@@ -712,9 +1130,14 @@ func (fs *KBFSOpsStandard) CreateFile(
 // CreateLink implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) CreateLink(
 	ctx context.Context, dir Node, fromName string, toPath string) (
-	EntryInfo, error) {
+	ei EntryInfo, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateLink(ctx, dir, fromName, toPath)
@@ -722,9 +1145,14 @@ func (fs *KBFSOpsStandard) CreateLink(
 
 // RemoveDir implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RemoveDir(
-	ctx context.Context, dir Node, name string) error {
+	ctx context.Context, dir Node, name string) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.RemoveDir(ctx, dir, name)
@@ -732,24 +1160,87 @@ func (fs *KBFSOpsStandard) RemoveDir(
 
 // RemoveEntry implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RemoveEntry(
-	ctx context.Context, dir Node, name string) error {
+	ctx context.Context, dir Node, name string) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.RemoveEntry(ctx, dir, name)
 }
 
+// RemoveAll implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveAll(
+	ctx context.Context, dir Node, name string) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.RemoveAll(ctx, dir, name)
+}
+
+// CopyFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) CopyFile(
+	ctx context.Context, srcNode Node, dstDir Node, dstName string) (
+	node Node, ei EntryInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
+
+	if srcNode.GetFolderBranch() != dstDir.GetFolderBranch() {
+		return nil, EntryInfo{}, CopyAcrossTlfsError{}
+	}
+
+	ops := fs.getOpsByNode(ctx, dstDir)
+	return ops.CopyFile(ctx, srcNode, dstDir, dstName)
+}
+
 // Rename implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Rename(
 	ctx context.Context, oldParent Node, oldName string, newParent Node,
-	newName string) error {
+	newName string) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
 
 	oldFB := oldParent.GetFolderBranch()
 	newFB := newParent.GetFolderBranch()
 
+	class := OpTimeoutWrite
+	acrossTlfs := false
+	var progress func(CrossTlfRenameProgress)
+	if oldFB.Tlf != newFB.Tlf {
+		acrossTlfs, progress = fs.crossTlfRenameFallback()
+		if acrossTlfs {
+			// Copying the data can take much longer than a regular
+			// rename, and it syncs the new TLF.
+			class = OpTimeoutSync
+		}
+	}
+
+	ctx, done, err := fs.startOp(ctx, class)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	if acrossTlfs {
+		return fs.renameAcrossTlfs(
+			ctx, oldParent, oldName, newParent, newName, progress)
+	}
+
 	// only works for nodes within the same topdir
 	if oldFB != newFB {
 		return RenameAcrossDirsError{}
@@ -759,32 +1250,98 @@ func (fs *KBFSOpsStandard) Rename(
 	return ops.Rename(ctx, oldParent, oldName, newParent, newName)
 }
 
+// RenameWithFlags implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RenameWithFlags(
+	ctx context.Context, oldParent Node, oldName string, newParent Node,
+	newName string, flags RenameFlags) (err error) {
+	if flags == 0 {
+		return fs.Rename(ctx, oldParent, oldName, newParent, newName)
+	}
+
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	// only works for nodes within the same topdir
+	if oldParent.GetFolderBranch() != newParent.GetFolderBranch() {
+		return RenameAcrossDirsError{}
+	}
+
+	ops := fs.getOpsByNode(ctx, oldParent)
+	return ops.RenameWithFlags(
+		ctx, oldParent, oldName, newParent, newName, flags)
+}
+
+// RenameExchange implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RenameExchange(
+	ctx context.Context, oldParent Node, oldName string, newParent Node,
+	newName string) error {
+	return fs.RenameWithFlags(
+		ctx, oldParent, oldName, newParent, newName, RenameExchange)
+}
+
 // Read implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Read(
 	ctx context.Context, file Node, dest []byte, off int64) (
 	numRead int64, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, file)
+	fs.hydrateOnReadIfNeeded(ops, file)
 	return ops.Read(ctx, file, dest, off)
 }
 
 // Write implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Write(
-	ctx context.Context, file Node, data []byte, off int64) error {
+	ctx context.Context, file Node, data []byte, off int64) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.Write(ctx, file, data, off)
 }
 
+// WriteWithFlags implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) WriteWithFlags(
+	ctx context.Context, file Node, data []byte, off int64,
+	flags WriteFlags) (writtenOff int64, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.WriteWithFlags(ctx, file, data, off, flags)
+}
+
 // Truncate implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Truncate(
-	ctx context.Context, file Node, size uint64) error {
+	ctx context.Context, file Node, size uint64) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.Truncate(ctx, file, size)
@@ -792,29 +1349,119 @@ func (fs *KBFSOpsStandard) Truncate(
 
 // SetEx implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SetEx(
-	ctx context.Context, file Node, ex bool) error {
+	ctx context.Context, file Node, ex bool) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.SetEx(ctx, file, ex)
 }
 
+// SetMode implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetMode(
+	ctx context.Context, node Node, mode os.FileMode) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.SetMode(ctx, node, mode)
+}
+
 // SetMtime implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SetMtime(
-	ctx context.Context, file Node, mtime *time.Time) error {
+	ctx context.Context, file Node, mtime *time.Time) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.SetMtime(ctx, file, mtime)
 }
 
+// GetXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetXattr(
+	ctx context.Context, node Node, name string) (value []byte, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.GetXattr(ctx, node, name)
+}
+
+// ListXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) ListXattr(
+	ctx context.Context, node Node) (names []string, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.ListXattr(ctx, node)
+}
+
+// SetXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetXattr(
+	ctx context.Context, node Node, name string, value []byte) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.SetXattr(ctx, node, name, value)
+}
+
+// RemoveXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveXattr(
+	ctx context.Context, node Node, name string) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.RemoveXattr(ctx, node, name)
+}
+
 // SyncAll implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SyncAll(
-	ctx context.Context, folderBranch FolderBranch) error {
+	ctx context.Context, folderBranch FolderBranch) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.SyncAll(ctx, folderBranch)
@@ -823,19 +1470,84 @@ func (fs *KBFSOpsStandard) SyncAll(
 // FolderStatus implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) FolderStatus(
 	ctx context.Context, folderBranch FolderBranch) (
-	FolderBranchStatus, <-chan StatusUpdate, error) {
+	status FolderBranchStatus, updateChan <-chan StatusUpdate, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return FolderBranchStatus{}, nil, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
 	return ops.FolderStatus(ctx, folderBranch)
 }
 
+// ClearFolderErrors implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) ClearFolderErrors(
+	ctx context.Context, folderBranch FolderBranch) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ClearFolderErrors(ctx, folderBranch)
+}
+
+// GetQuotaInfo implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetQuotaInfo(ctx context.Context) (
+	info *kbfsblock.QuotaInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
+
+	info, err = fs.config.BlockServer().GetUserQuotaInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var usageBytes int64
+	if info.Total != nil {
+		usageBytes = info.Total.Bytes[kbfsblock.UsageWrite]
+	}
+	fs.quotaNotifier.update(ctx, keybase1.TeamID(""), usageBytes, info.Limit)
+	return info, nil
+}
+
+// SetQuotaUsageThresholds sets the fractions of the quota limit at
+// which a QuotaUsageWarning is reported, whenever the usage of the
+// logged-in user or of a team is found to have crossed one of them.
+// The default thresholds are 0.8 and 0.95.
+func (fs *KBFSOpsStandard) SetQuotaUsageThresholds(
+	ctx context.Context, thresholds []float64) (err error) {
+	_, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	fs.quotaNotifier.setThresholds(thresholds)
+	return nil
+}
+
 // Status implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Status(ctx context.Context) (
-	KBFSStatus, <-chan StatusUpdate, error) {
+	status KBFSStatus, updateChan <-chan StatusUpdate, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return KBFSStatus{}, nil, err
+	}
+	defer func() { err = done(err) }()
 
 	session, err := fs.config.KBPKI().GetCurrentSession(ctx)
 	var usageBytes, limitBytes int64 = -1, -1
@@ -879,9 +1591,17 @@ func (fs *KBFSOpsStandard) Status(ctx context.Context) (
 		dbcStatus = dbc.Status(ctx)
 	}
 
+	var connProxy string
+	dialer := fs.config.Dialer()
+	if dialer != nil {
+		connProxy = fmt.Sprintf("%s", dialer)
+	}
+
 	return KBFSStatus{
+		SchemaVersion:   StatusSchemaVersion,
 		CurrentUser:     session.Name.String(),
 		IsConnected:     fs.config.MDServer().IsConnected(),
+		ConnectionState: fs.currentStatus.ConnectionState(),
 		UsageBytes:      usageBytes,
 		LimitBytes:      limitBytes,
 		GitUsageBytes:   gitUsageBytes,
@@ -889,24 +1609,69 @@ func (fs *KBFSOpsStandard) Status(ctx context.Context) (
 		FailingServices: failures,
 		JournalServer:   jServerStatus,
 		DiskCacheStatus: dbcStatus,
+		ConnectionProxy: connProxy,
+		Anonymized:      isAnonymousDialer(dialer),
 	}, ch, err
 }
 
 // UnstageForTesting implements the KBFSOps interface for KBFSOpsStandard
 // TODO: remove once we have automatic conflict resolution
 func (fs *KBFSOpsStandard) UnstageForTesting(
-	ctx context.Context, folderBranch FolderBranch) error {
+	ctx context.Context, folderBranch FolderBranch) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.UnstageForTesting(ctx, folderBranch)
 }
 
+// GetUnmergedChanges implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetUnmergedChanges(
+	ctx context.Context, folderBranch FolderBranch) (
+	changes UnmergedChanges, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return UnmergedChanges{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetUnmergedChanges(ctx, folderBranch)
+}
+
+// Unstage implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) Unstage(ctx context.Context,
+	folderBranch FolderBranch, expected UnmergedChanges) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
+	return ops.Unstage(ctx, folderBranch, expected)
+}
+
 // RequestRekey implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RequestRekey(ctx context.Context, id tlf.ID) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Not rekeying %s: %+v", id, err)
+		return
+	}
+	defer done(nil)
 
 	// We currently only support rekeys of master branches.
 	ops := fs.getOps(ctx,
@@ -916,9 +1681,14 @@ func (fs *KBFSOpsStandard) RequestRekey(ctx context.Context, id tlf.ID) {
 
 // SyncFromServer implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SyncFromServer(ctx context.Context,
-	folderBranch FolderBranch, lockBeforeGet *keybase1.LockID) error {
+	folderBranch FolderBranch, lockBeforeGet *keybase1.LockID) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.SyncFromServer(ctx, folderBranch, lockBeforeGet)
@@ -929,6 +1699,11 @@ func (fs *KBFSOpsStandard) GetUpdateHistory(ctx context.Context,
 	folderBranch FolderBranch) (history TLFUpdateHistory, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return TLFUpdateHistory{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.GetUpdateHistory(ctx, folderBranch)
@@ -939,31 +1714,190 @@ func (fs *KBFSOpsStandard) GetEditHistory(ctx context.Context,
 	folderBranch FolderBranch) (edits TlfWriterEdits, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return TlfWriterEdits{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.GetEditHistory(ctx, folderBranch)
 }
 
+// GetUsageBreakdown implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetUsageBreakdown(ctx context.Context,
+	folderBranch FolderBranch) (breakdown UsageBreakdown, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return UsageBreakdown{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetUsageBreakdown(ctx, folderBranch)
+}
+
+// GetSubtreeUsage implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetSubtreeUsage(ctx context.Context, node Node) (
+	usage SubtreeUsage, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return SubtreeUsage{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.GetSubtreeUsage(ctx, node)
+}
+
+// SetAdvisoryLock implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetAdvisoryLock(ctx context.Context, file Node,
+	lock AdvisoryLock, wait bool) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.SetAdvisoryLock(ctx, file, lock, wait)
+}
+
+// GetAdvisoryLock implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetAdvisoryLock(ctx context.Context, file Node,
+	lock AdvisoryLock) (conflict AdvisoryLock, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return AdvisoryLock{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.GetAdvisoryLock(ctx, file, lock)
+}
+
+// CreateTempFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) CreateTempFile(
+	ctx context.Context, dir Node, isExec bool) (
+	node Node, ei EntryInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.CreateTempFile(ctx, dir, isExec)
+}
+
+// LinkTempFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) LinkTempFile(
+	ctx context.Context, dir Node, name string, file Node) (
+	ei EntryInfo, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.LinkTempFile(ctx, dir, name, file)
+}
+
+// GetDirChildrenPaged implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetDirChildrenPaged(
+	ctx context.Context, dir Node, cursor string, maxEntries int) (
+	children []DirChild, nextCursor string, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	children, nextCursor, err = ops.GetDirChildrenPaged(
+		ctx, dir, cursor, maxEntries)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only this page needs mime types.
+	eis := make(map[string]EntryInfo, len(children))
+	for _, c := range children {
+		eis[c.Name] = c.EntryInfo
+	}
+	fs.addMimeTypesIfNeeded(ctx, ops, dir, eis)
+	for i := range children {
+		children[i].EntryInfo = eis[children[i].Name]
+	}
+	return children, nextCursor, nil
+}
+
 // GetNodeMetadata implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetNodeMetadata(ctx context.Context, node Node) (
-	NodeMetadata, error) {
+	md NodeMetadata, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutStat)
+	if err != nil {
+		return NodeMetadata{}, err
+	}
+	defer func() { err = done(err) }()
 
 	ops := fs.getOpsByNode(ctx, node)
 	return ops.GetNodeMetadata(ctx, node)
 }
 
+// GetNodeHistory implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetNodeHistory(ctx context.Context, node Node) (
+	history []NodeRevision, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.GetNodeHistory(ctx, node)
+}
+
+// ReadAtRevision implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) ReadAtRevision(ctx context.Context, file Node,
+	rev kbfsmd.Revision, dest []byte, off int64) (numRead int64, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutRead)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = done(err) }()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.ReadAtRevision(ctx, file, rev, dest, off)
+}
+
 func (fs *KBFSOpsStandard) findTeamByID(
 	ctx context.Context, tid keybase1.TeamID) *folderBranchOps {
-	fs.opsLock.Lock()
-	// Copy the ops list so we don't have to hold opsLock when calling
-	// `getRootNode()` (which can lead to deadlocks).
-	ops := make(map[FolderBranch]*folderBranchOps)
-	for fb, fbo := range fs.ops {
-		ops[fb] = fbo
-	}
-	fs.opsLock.Unlock()
+	// Copy the ops list so we don't have to hold any ops locks when
+	// calling `getRootNode()` (which can lead to deadlocks).
+	ops := fs.ops.snapshot()
 
 	// We have to search for the tid since we don't know the old name
 	// of the team here.  Should we add an index for this?
@@ -994,6 +1928,15 @@ func (fs *KBFSOpsStandard) TeamNameChanged(
 	ctx context.Context, tid keybase1.TeamID) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	// The change is handled in the background, so it mustn't be
+	// canceled along with the op's context.
+	_, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Not handling TeamNameChanged for %s: %+v",
+			tid, err)
+		return
+	}
+	defer done(nil)
 
 	fs.log.CDebugf(ctx, "Got TeamNameChanged for %s", tid)
 	fbo := fs.findTeamByID(ctx, tid)
@@ -1017,9 +1960,14 @@ func (fs *KBFSOpsStandard) TeamAbandoned(
 
 // MigrateToImplicitTeam implements the KBFSOps interface for KBFSOpsStandard.
 func (fs *KBFSOpsStandard) MigrateToImplicitTeam(
-	ctx context.Context, id tlf.ID) error {
+	ctx context.Context, id tlf.ID) (err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
 
 	// We currently only migrate on the master branch of a TLF.
 	ops := fs.getOps(ctx,
@@ -1030,7 +1978,7 @@ func (fs *KBFSOpsStandard) MigrateToImplicitTeam(
 // KickoffAllOutstandingRekeys implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) KickoffAllOutstandingRekeys() error {
-	for _, op := range fs.ops {
+	for _, op := range fs.ops.snapshot() {
 		op.rekeyFSM.Event(newRekeyKickoffEvent())
 	}
 	return nil
@@ -1063,26 +2011,88 @@ func (fs *KBFSOpsStandard) changeHandle(ctx context.Context,
 // Notifier:
 var _ Notifier = (*KBFSOpsStandard)(nil)
 
-// RegisterForChanges implements the Notifer interface for KBFSOpsStandard
-func (fs *KBFSOpsStandard) RegisterForChanges(
-	folderBranches []FolderBranch, obs Observer) error {
+// registerForChanges registers `obs` with the ops for each of
+// `folderBranches`, using `register`.  If any registration fails,
+// the ones that already succeeded are undone, so that `obs` ends up
+// registered either for all of the folder-branches or for none.
+func (fs *KBFSOpsStandard) registerForChanges(
+	folderBranches []FolderBranch, obs Observer,
+	register func(ops *folderBranchOps, fb FolderBranch) error) error {
+	registered := make(map[FolderBranch]*folderBranchOps, len(folderBranches))
 	for _, fb := range folderBranches {
-		// TODO: add branch parameter to notifier interface
+		if _, ok := registered[fb]; ok {
+			continue
+		}
 		ops := fs.getOps(context.Background(), fb, FavoritesOpNoChange)
-		return ops.RegisterForChanges(obs)
+		err := register(ops, fb)
+		if err != nil {
+			for doneFB, doneOps := range registered {
+				unregErr := doneOps.UnregisterFromChanges(doneFB, obs)
+				if unregErr != nil {
+					fs.log.CDebugf(context.Background(),
+						"Couldn't roll back registration for %s: %+v",
+						doneFB, unregErr)
+				}
+			}
+			return err
+		}
+		registered[fb] = ops
 	}
 	return nil
 }
 
-// UnregisterFromChanges implements the Notifer interface for KBFSOpsStandard
+// RegisterForChanges implements the Notifer interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RegisterForChanges(
+	folderBranches []FolderBranch, obs Observer) error {
+	return fs.registerForChanges(folderBranches, obs,
+		func(ops *folderBranchOps, fb FolderBranch) error {
+			return ops.RegisterForChanges(fb, obs)
+		})
+}
+
+// RegisterForChangesWithFilter implements the Notifer interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) RegisterForChangesWithFilter(
+	folderBranches []FolderBranch, obs Observer, filter ObserverFilter) error {
+	return fs.registerForChanges(folderBranches, obs,
+		func(ops *folderBranchOps, fb FolderBranch) error {
+			return ops.RegisterForChangesWithFilter(fb, obs, filter)
+		})
+}
+
+// UnregisterFromChanges implements the Notifer interface for
+// KBFSOpsStandard.  It unregisters `obs` from as many of
+// `folderBranches` as it can, and returns the first error, if any.
 func (fs *KBFSOpsStandard) UnregisterFromChanges(
 	folderBranches []FolderBranch, obs Observer) error {
+	var firstErr error
+	done := make(map[FolderBranch]bool, len(folderBranches))
 	for _, fb := range folderBranches {
-		// TODO: add branch parameter to notifier interface
+		if done[fb] {
+			continue
+		}
+		done[fb] = true
 		ops := fs.getOps(context.Background(), fb, FavoritesOpNoChange)
-		return ops.UnregisterFromChanges(obs)
+		err := ops.UnregisterFromChanges(fb, obs)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
+}
+
+// RegisterForConnectionChanges implements the Notifer interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) RegisterForConnectionChanges(
+	obs ConnectionObserver) {
+	fs.currentStatus.AddObserver(obs)
+}
+
+// UnregisterFromConnectionChanges implements the Notifer interface
+// for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) UnregisterFromConnectionChanges(
+	obs ConnectionObserver) {
+	fs.currentStatus.RemoveObserver(obs)
 }
 
 func (fs *KBFSOpsStandard) onTLFBranchChange(tlfID tlf.ID, newBID kbfsmd.BranchID) {
@@ -0,0 +1,274 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These benchmarks can be run with:
+// go test -test.run=XXX -test.bench=KBFSOps -benchmem
+//
+// Running the tests with -check-bench-allocs also makes
+// TestKBFSOpsBenchAllocs run the same operations, and fail if any of
+// them allocates noticeably more than kbfsOpsBenchAllocsBaseline says
+// it should.
+
+package libkbfs
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+const (
+	// kbfsOpsBenchFileSize is the size of the files used by the
+	// small read and write benchmarks.
+	kbfsOpsBenchFileSize = 4 * 1024
+	// kbfsOpsBenchSyncFiles is how many files are written before
+	// each sync in the sync benchmark.
+	kbfsOpsBenchSyncFiles = 10
+	// kbfsOpsBenchLargeDirSize is the number of entries in the
+	// directory listed by the large directory benchmark.
+	kbfsOpsBenchLargeDirSize = 1000
+	// kbfsOpsBenchAllocsRuns is how many times each operation is run
+	// to measure its allocations.
+	kbfsOpsBenchAllocsRuns = 50
+	// kbfsOpsBenchAllocsTolerance is how much more an operation may
+	// allocate than its baseline before TestKBFSOpsBenchAllocs
+	// fails, to leave room for background goroutines and runtime
+	// differences.
+	kbfsOpsBenchAllocsTolerance = 0.25
+)
+
+var checkBenchAllocs = flag.Bool("check-bench-allocs", false,
+	"Check KBFSOps allocations against kbfsOpsBenchAllocsBaseline")
+
+// kbfsOpsBenchAllocsBaseline is the tracked number of allocations
+// per run of each kbfsOpsBenchCases operation.  When a change makes
+// an operation allocate less, lower its baseline here; when one is
+// expected to make it allocate more, raise it in the same change and
+// say why in the commit message.
+var kbfsOpsBenchAllocsBaseline = map[string]float64{
+	"Lookup":              175,
+	"Stat":                169,
+	"ReadSmall":           208,
+	"WriteSmall":          224,
+	"SyncSmallFiles":      12150,
+	"GetDirChildrenLarge": 188,
+}
+
+// kbfsOpsBenchCase is a KBFSOps operation to benchmark.  setup
+// prepares the TLF rooted at rootNode, and returns the operation to
+// run repeatedly.
+type kbfsOpsBenchCase struct {
+	name  string
+	setup func(ctx context.Context, tb testing.TB, kbfsOps KBFSOps,
+		rootNode Node) func() error
+}
+
+func kbfsOpsBenchMakeFile(ctx context.Context, tb testing.TB,
+	kbfsOps KBFSOps, parent Node, name string, size int) Node {
+	fileNode, _, err := kbfsOps.CreateFile(ctx, parent, name, false, NoExcl)
+	require.NoError(tb, err)
+	if size > 0 {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		err = kbfsOps.Write(ctx, fileNode, data, 0)
+		require.NoError(tb, err)
+	}
+	return fileNode
+}
+
+func kbfsOpsBenchSync(ctx context.Context, tb testing.TB,
+	kbfsOps KBFSOps, node Node) {
+	err := kbfsOps.SyncAll(ctx, node.GetFolderBranch())
+	require.NoError(tb, err)
+}
+
+var kbfsOpsBenchCases = []kbfsOpsBenchCase{
+	{"Lookup", func(ctx context.Context, tb testing.TB, kbfsOps KBFSOps,
+		rootNode Node) func() error {
+		kbfsOpsBenchMakeFile(ctx, tb, kbfsOps, rootNode, "a", 0)
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		return func() error {
+			_, _, err := kbfsOps.Lookup(ctx, rootNode, "a")
+			return err
+		}
+	}},
+	{"Stat", func(ctx context.Context, tb testing.TB, kbfsOps KBFSOps,
+		rootNode Node) func() error {
+		fileNode := kbfsOpsBenchMakeFile(ctx, tb, kbfsOps, rootNode, "a", 0)
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		return func() error {
+			_, err := kbfsOps.Stat(ctx, fileNode)
+			return err
+		}
+	}},
+	{"ReadSmall", func(ctx context.Context, tb testing.TB, kbfsOps KBFSOps,
+		rootNode Node) func() error {
+		fileNode := kbfsOpsBenchMakeFile(
+			ctx, tb, kbfsOps, rootNode, "a", kbfsOpsBenchFileSize)
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		buf := make([]byte, kbfsOpsBenchFileSize)
+		return func() error {
+			_, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+			return err
+		}
+	}},
+	{"WriteSmall", func(ctx context.Context, tb testing.TB, kbfsOps KBFSOps,
+		rootNode Node) func() error {
+		fileNode := kbfsOpsBenchMakeFile(
+			ctx, tb, kbfsOps, rootNode, "a", kbfsOpsBenchFileSize)
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		data := make([]byte, kbfsOpsBenchFileSize)
+		return func() error {
+			// Overwrite the same range each time, so the amount of
+			// dirty data stays the same.
+			return kbfsOps.Write(ctx, fileNode, data, 0)
+		}
+	}},
+	{"SyncSmallFiles", func(ctx context.Context, tb testing.TB,
+		kbfsOps KBFSOps, rootNode Node) func() error {
+		fileNodes := make([]Node, kbfsOpsBenchSyncFiles)
+		for i := range fileNodes {
+			fileNodes[i] = kbfsOpsBenchMakeFile(
+				ctx, tb, kbfsOps, rootNode, fmt.Sprintf("f%d", i), 0)
+		}
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		data := make([]byte, 1)
+		return func() error {
+			// Change every file each time, so there is something to
+			// sync.
+			data[0]++
+			for _, fileNode := range fileNodes {
+				err := kbfsOps.Write(ctx, fileNode, data, 0)
+				if err != nil {
+					return err
+				}
+			}
+			return kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		}
+	}},
+	{"GetDirChildrenLarge", func(ctx context.Context, tb testing.TB,
+		kbfsOps KBFSOps, rootNode Node) func() error {
+		dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+		require.NoError(tb, err)
+		for i := 0; i < kbfsOpsBenchLargeDirSize; i++ {
+			kbfsOpsBenchMakeFile(
+				ctx, tb, kbfsOps, dirNode, fmt.Sprintf("f%d", i), 0)
+		}
+		kbfsOpsBenchSync(ctx, tb, kbfsOps, rootNode)
+		return func() error {
+			_, err := kbfsOps.GetDirChildren(ctx, dirNode)
+			return err
+		}
+	}},
+}
+
+// kbfsOpsBenchInit sets up an in-memory config for tb, and runs the
+// setup for the given case in the private TLF of user u1.  It
+// returns the operation to run, and a function that syncs whatever
+// the operation left dirty and shuts everything down again.
+func kbfsOpsBenchInit(tb testing.TB, bc kbfsOpsBenchCase) (
+	op func() error, shutdown func()) {
+	config := MakeTestConfigOrBust(tb, "u1")
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, err := NewContextWithCancellationDelayer(NewContextReplayable(
+		ctx, func(c context.Context) context.Context {
+			return c
+		}))
+	require.NoError(tb, err)
+
+	rootNode := GetRootNodeOrBust(ctx, tb, config, "u1", tlf.Private)
+	op = bc.setup(ctx, tb, config.KBFSOps(), rootNode)
+	return op, func() {
+		kbfsOpsBenchSync(ctx, tb, config.KBFSOps(), rootNode)
+		CheckConfigAndShutdown(ctx, tb, config)
+		cancel()
+		CleanupCancellationDelayer(ctx)
+	}
+}
+
+func runKBFSOpsBenchmark(b *testing.B, name string) {
+	for _, bc := range kbfsOpsBenchCases {
+		if bc.name != name {
+			continue
+		}
+		op, shutdown := kbfsOpsBenchInit(noLogTB{b}, bc)
+		defer shutdown()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			err := op()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		return
+	}
+	b.Fatalf("Unknown benchmark case %s", name)
+}
+
+func BenchmarkKBFSOpsLookup(b *testing.B) {
+	runKBFSOpsBenchmark(b, "Lookup")
+}
+
+func BenchmarkKBFSOpsStat(b *testing.B) {
+	runKBFSOpsBenchmark(b, "Stat")
+}
+
+func BenchmarkKBFSOpsReadSmall(b *testing.B) {
+	runKBFSOpsBenchmark(b, "ReadSmall")
+}
+
+func BenchmarkKBFSOpsWriteSmall(b *testing.B) {
+	runKBFSOpsBenchmark(b, "WriteSmall")
+}
+
+func BenchmarkKBFSOpsSyncSmallFiles(b *testing.B) {
+	runKBFSOpsBenchmark(b, "SyncSmallFiles")
+}
+
+func BenchmarkKBFSOpsGetDirChildrenLarge(b *testing.B) {
+	runKBFSOpsBenchmark(b, "GetDirChildrenLarge")
+}
+
+// TestKBFSOpsBenchAllocs checks the allocations of each benchmarked
+// operation against kbfsOpsBenchAllocsBaseline.  Allocation counts
+// still shift with the Go version and with background goroutines,
+// so it only runs when asked for with -check-bench-allocs.
+func TestKBFSOpsBenchAllocs(t *testing.T) {
+	if !*checkBenchAllocs {
+		t.Skip("Run with -check-bench-allocs to check allocations")
+	}
+	for _, bc := range kbfsOpsBenchCases {
+		bc := bc // capture range variable.
+		t.Run(bc.name, func(t *testing.T) {
+			baseline, ok := kbfsOpsBenchAllocsBaseline[bc.name]
+			require.True(t, ok, "No baseline for %s", bc.name)
+
+			op, shutdown := kbfsOpsBenchInit(t, bc)
+			defer shutdown()
+			var err error
+			allocs := testing.AllocsPerRun(kbfsOpsBenchAllocsRuns, func() {
+				if err == nil {
+					err = op()
+				}
+			})
+			require.NoError(t, err)
+
+			t.Logf("%s: %.0f allocs/op (baseline %.0f)",
+				bc.name, allocs, baseline)
+			require.True(t,
+				allocs <= baseline*(1+kbfsOpsBenchAllocsTolerance),
+				"%s allocates %.0f times per run, up from a baseline of "+
+					"%.0f; if that's expected, update "+
+					"kbfsOpsBenchAllocsBaseline", bc.name, allocs, baseline)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+const (
+	chaosTestRounds        = 10
+	chaosTestOpsPerRound   = 20
+	chaosTestKillProb      = 0.05
+	chaosTestNumFiles      = 5
+	chaosTestMaxFileLength = 3 * 64 * 1024
+)
+
+// chaosOutcome describes a possible state of a single file; a nil
+// contents means the file doesn't exist.
+type chaosOutcome struct {
+	name     string
+	contents []byte
+}
+
+// chaosRunRound runs random file operations as the given user in a
+// fresh config until either the config is killed or the round's ops
+// are exhausted.  It updates `expected` with the result of every
+// operation that completed, and returns the outcome of the operation
+// that was in flight when the config died, if any.
+func chaosRunRound(ctx context.Context, t *testing.T, rng *rand.Rand,
+	c *ConfigLocal, ck *ChaosKiller,
+	expected map[string][]byte) (pending *chaosOutcome) {
+	kbfsOps := c.KBFSOps()
+	rootNode, err := GetRootNodeForTest(ctx, c, "test_user", tlf.Private)
+	if err != nil {
+		require.True(t, ck.IsKilled(), "Unexpected error: %+v", err)
+		return nil
+	}
+
+	for i := 0; i < chaosTestOpsPerRound; i++ {
+		name := fmt.Sprintf("file%d", rng.Intn(chaosTestNumFiles))
+		_, exists := expected[name]
+		var outcome chaosOutcome
+		if exists && rng.Intn(4) == 0 {
+			outcome = chaosOutcome{name, nil}
+			err = kbfsOps.RemoveEntry(ctx, rootNode, name)
+		} else {
+			data := make([]byte, 1+rng.Intn(chaosTestMaxFileLength))
+			rng.Read(data)
+			outcome = chaosOutcome{name, data}
+			err = chaosWriteFile(ctx, kbfsOps, rootNode, name, data, exists)
+		}
+		if err == nil {
+			err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		}
+		if err != nil {
+			require.True(t, ck.IsKilled(), "Unexpected error: %+v", err)
+			return &outcome
+		}
+		if outcome.contents == nil {
+			delete(expected, name)
+		} else {
+			expected[name] = outcome.contents
+		}
+	}
+	return nil
+}
+
+func chaosWriteFile(ctx context.Context, kbfsOps KBFSOps, rootNode Node,
+	name string, data []byte, exists bool) error {
+	var n Node
+	var err error
+	if exists {
+		n, _, err = kbfsOps.Lookup(ctx, rootNode, name)
+		if err != nil {
+			return err
+		}
+		err = kbfsOps.Truncate(ctx, n, 0)
+	} else {
+		n, _, err = kbfsOps.CreateFile(ctx, rootNode, name, false, NoExcl)
+	}
+	if err != nil {
+		return err
+	}
+	return kbfsOps.Write(ctx, n, data, 0)
+}
+
+// chaosCheckState reads the whole folder using the given (live)
+// config, and checks that each file matches either its expected
+// contents or the outcome of the operation that was in flight when
+// the last config died.  It resets `expected` to the observed state.
+func chaosCheckState(ctx context.Context, t *testing.T, config *ConfigLocal,
+	expected map[string][]byte, pending *chaosOutcome) {
+	kbfsOps := config.KBFSOps()
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	err := kbfsOps.SyncFromServer(ctx, rootNode.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	actual := make(map[string][]byte, len(children))
+	for name, ei := range children {
+		n, _, err := kbfsOps.Lookup(ctx, rootNode, name)
+		require.NoError(t, err)
+		buf := make([]byte, ei.Size)
+		nr, err := kbfsOps.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(buf)), nr)
+		actual[name] = buf
+	}
+
+	for name := range expected {
+		if _, ok := actual[name]; !ok {
+			actual[name] = nil
+		}
+	}
+	if pending != nil {
+		if _, ok := actual[pending.name]; !ok {
+			actual[pending.name] = nil
+		}
+	}
+	for name, got := range actual {
+		if bytes.Equal(got, expected[name]) &&
+			(got == nil) == (expected[name] == nil) {
+			continue
+		}
+		require.True(t, pending != nil && pending.name == name &&
+			bytes.Equal(got, pending.contents) &&
+			(got == nil) == (pending.contents == nil),
+			"Unexpected contents for %s after a crash", name)
+	}
+
+	for name := range expected {
+		delete(expected, name)
+	}
+	for name, got := range actual {
+		if got != nil {
+			expected[name] = got
+		}
+	}
+}
+
+// Test that killing a client at random points during its server
+// operations never loses completed writes or corrupts the folder.
+func TestKBFSOpsChaosShutdown(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer func() {
+		// Crashed clients leave unreferenced blocks behind on the
+		// server, so the strict shutdown check can't be used.
+		config.MDServer().Shutdown()
+		kbfsTestShutdownNoMocksNoCheck(t, config, ctx, cancel)
+	}()
+
+	seed := time.Now().UnixNano()
+	t.Logf("Chaos seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	expected := make(map[string][]byte)
+	for i := 0; i < chaosTestRounds; i++ {
+		c := ConfigAsUser(config, "test_user")
+		ck := InstallChaosKillerForTesting(c, rng.Int63(), chaosTestKillProb)
+		pending := chaosRunRound(ctx, t, rng, c, ck, expected)
+		ck.Kill()
+		// The dead client may leave all kinds of errors behind.
+		_ = c.Shutdown(ctx)
+
+		chaosCheckState(ctx, t, config, expected, pending)
+	}
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	sc := NewStateChecker(config)
+	sc.allowLeakedBlocks = true
+	err := sc.CheckMergedState(ctx, rootNode.GetFolderBranch().Tlf)
+	require.NoError(t, err)
+}
@@ -530,6 +530,92 @@ func TestKBFSOpsConcurBlockReadWrite(t *testing.T) {
 	}
 }
 
+// Test that a read that's waiting on a block while the file is
+// truncated returns only data from the truncated file.
+func TestKBFSOpsConcurReadDuringTruncate(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsConcurInit(t, "test_user")
+	// The stalling block server can't be state-checked.
+	defer kbfsConcurTestShutdownNoCheck(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	if err != nil {
+		t.Fatalf("Couldn't create file: %v", err)
+	}
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	if err != nil {
+		t.Fatalf("Couldn't write file: %v", err)
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		t.Fatalf("Couldn't sync file: %v", err)
+	}
+
+	// Take the block out of the cache, so the read has to fetch it.
+	md, err := kbfsOps.GetNodeMetadata(ctx, fileNode)
+	if err != nil {
+		t.Fatalf("Couldn't get node metadata: %v", err)
+	}
+	ptr := md.BlockInfo.BlockPointer
+	tlfID := rootNode.GetFolderBranch().Tlf
+	block, err := config.BlockCache().Get(ptr)
+	if err != nil {
+		t.Fatalf("Couldn't get block from the cache: %v", err)
+	}
+	err = config.BlockCache().DeleteTransient(ptr, tlfID)
+	if err != nil {
+		t.Fatalf("Couldn't delete block from the cache: %v", err)
+	}
+
+	onReadStalledCh, readUnstallCh, ctxStallRead :=
+		StallBlockOp(ctx, config, StallableBlockGet, 1)
+
+	// Start the read and wait for it to stall fetching the block.
+	readCh := make(chan error, 1)
+	gotData := make([]byte, len(data))
+	var nr int64
+	go func() {
+		var err error
+		nr, err = kbfsOps.Read(ctxStallRead, fileNode, gotData, 0)
+		readCh <- err
+	}()
+	<-onReadStalledCh
+
+	// Truncate the file while the read is waiting.  Any fetch of the
+	// block would wait behind the stalled one, so hand the block to
+	// the truncate through the cache, as if something else fetched it
+	// in the meantime.
+	err = config.BlockCache().Put(ptr, tlfID, block, TransientEntry)
+	if err != nil {
+		t.Fatalf("Couldn't put block in the cache: %v", err)
+	}
+	err = kbfsOps.Truncate(ctx, fileNode, 4)
+	if err != nil {
+		t.Fatalf("Couldn't truncate file: %v", err)
+	}
+
+	close(readUnstallCh)
+	err = <-readCh
+	if err != nil {
+		t.Fatalf("Couldn't read file: %v", err)
+	}
+	if nr != 4 {
+		t.Errorf("Read %d bytes past the truncate", nr)
+	}
+	if !bytes.Equal(data[:4], gotData[:nr]) {
+		t.Errorf("Read wrong data.  Expected %v, got %v",
+			data[:4], gotData[:nr])
+	}
+
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		t.Fatalf("Couldn't sync file: %v", err)
+	}
+}
+
 // mdRecordingKeyManager records the last KeyMetadata argument seen
 // in its KeyManager methods.
 type mdRecordingKeyManager struct {
@@ -7,7 +7,13 @@ package libkbfs
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -112,7 +118,8 @@ func kbfsOpsInit(t *testing.T) (mockCtrl *gomock.Controller,
 	// Ignore BlockRetriever calls
 	brc := &testBlockRetrievalConfig{nil, newTestLogMaker(t),
 		config.BlockCache(), nil, newTestDiskBlockCacheGetter(t, nil),
-		newTestSyncedTlfGetterSetter(), testInitModeGetter{InitDefault}}
+		newTestSyncedTlfGetterSetter(), testInitModeGetter{InitDefault},
+		&testNetworkConstraintsGetter{}}
 	brq := newBlockRetrievalQueue(0, 0, brc)
 	config.mockBops.EXPECT().BlockRetriever().AnyTimes().Return(brq)
 	// Ignore Prefetcher calls
@@ -121,7 +128,7 @@ func kbfsOpsInit(t *testing.T) (mockCtrl *gomock.Controller,
 
 	// Ignore favorites
 	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).AnyTimes().
-		Return(nil, nil)
+		Return(keybase1.FavoritesResult{}, nil)
 	config.mockKbpki.EXPECT().FavoriteAdd(gomock.Any(), gomock.Any()).
 		AnyTimes().Return(nil)
 
@@ -280,6 +287,48 @@ func TestKBFSOpsGetFavoritesSuccess(t *testing.T) {
 	}
 }
 
+func TestKBFSOpsDeleteFavoriteIgnores(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	fav := Favorite{"alice,bob", tlf.Private}
+	isFavorite := func() bool {
+		err := kbfsOps.favs.wg.Wait(ctx)
+		require.NoError(t, err)
+		favs, err := kbfsOps.GetFavorites(ctx)
+		require.NoError(t, err)
+		for _, f := range favs {
+			if f == fav {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Log("Opening a folder favorites it")
+	GetRootNodeOrBust(ctx, t, config, fav.Name, fav.Type)
+	require.True(t, isFavorite())
+
+	t.Log("Opening it again after deleting the favorite doesn't")
+	err := kbfsOps.DeleteFavorite(ctx, fav)
+	require.NoError(t, err)
+	require.False(t, isFavorite())
+	GetRootNodeOrBust(ctx, t, config, fav.Name, fav.Type)
+	require.False(t, isFavorite())
+
+	t.Log("The folder stays ignored after a refresh")
+	err = kbfsOps.RefreshFavorites(ctx)
+	require.NoError(t, err)
+	GetRootNodeOrBust(ctx, t, config, fav.Name, fav.Type)
+	require.False(t, isFavorite())
+
+	t.Log("Adding it explicitly brings it back")
+	err = kbfsOps.AddFavorite(ctx, fav)
+	require.NoError(t, err)
+	require.True(t, isFavorite())
+}
+
 func TestKBFSOpsGetFavoritesFail(t *testing.T) {
 	mockCtrl, config, ctx, cancel := kbfsOpsInit(t)
 	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
@@ -291,7 +340,8 @@ func TestKBFSOpsGetFavoritesFail(t *testing.T) {
 	config.SetKBPKI(config.mockKbpki)
 
 	// expect one call to favorites, and fail it
-	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).Return(nil, err)
+	config.mockKbpki.EXPECT().FavoriteList(gomock.Any()).
+		Return(keybase1.FavoritesResult{}, err)
 
 	if _, err2 := config.KBFSOps().GetFavorites(ctx); err2 != err {
 		t.Errorf("Got bad error on favorites: %+v", err2)
@@ -3435,13 +3485,11 @@ func TestForceFastForwardOnEmptyTLF(t *testing.T) {
 
 	// There's only one folder at this point.
 	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
-	kbfsOps.opsLock.RLock()
 	var ops *folderBranchOps
-	for _, fbo := range kbfsOps.ops {
+	for _, fbo := range kbfsOps.ops.snapshot() {
 		ops = fbo
 		break
 	}
-	kbfsOps.opsLock.RUnlock()
 
 	// FastForward shouldn't do anything, since the TLF hasn't been
 	// cleared yet.
@@ -3793,3 +3841,1297 @@ func TestKBFSOpsMigratePublicV2ToImplicitTeam(t *testing.T) {
 	testKBFSOpsMigrateToImplicitTeam(
 		t, tlf.Public, kbfsmd.InitialExtraMetadataVer)
 }
+
+// Test that logging in as a different user shuts down and discards
+// all the folder state of the previous user.
+func TestKBFSOpsResetForNewUser(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// The config shuts the cache down.
+	dbc, _ := initDiskBlockCacheTest(t)
+	config.diskBlockCache = dbc
+	kbfsOps := config.KBFSOps()
+
+	session1, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	serviceLoggedIn(ctx, config, session1, TLFJournalBackgroundWorkEnabled)
+
+	t.Log("Write a file as u1")
+	rootNode1 := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	aNode, _, err := kbfsOps.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, aNode, []byte("%PDF-1.4 fake"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	tlfID1 := rootNode1.GetFolderBranch().Tlf
+	ops1 := getOps(config, tlfID1)
+	require.NotNil(t, ops1)
+
+	t.Log("Start everything that keeps per-user state as u1")
+	kops := kbfsOps.(*KBFSOpsStandard)
+	localDir, err := ioutil.TempDir("", "kbfs_ops_reset_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+	err = kops.StartRechunkJob(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	ls, err := kops.StartLocalSync(LocalSyncConfig{
+		Remote:       "private/u1/s",
+		Local:        filepath.Join(localDir, "s"),
+		DisableWatch: true,
+	})
+	require.NoError(t, err)
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	err = kops.StartMirrorJob(ctx, MirrorJobConfig{
+		Name: "m", Source: "private/u1", Dest: filepath.Join(localDir, "m")})
+	require.NoError(t, err)
+	err = kops.waitForMirrorJobSyncsForTest(ctx, "m", 1)
+	require.NoError(t, err)
+	err = kops.PinFile(ctx, aNode)
+	require.NoError(t, err)
+	err = kops.SetFolderReadOnly(ctx, tlf.FakeID(1, tlf.Private), true)
+	require.NoError(t, err)
+	kops.SetMimeTypeSniffing(RequestFrontendHTTP, true)
+	ei, err := kbfsOps.Stat(CtxWithRequestSource(ctx, RequestSource{
+		Frontend: RequestFrontendHTTP,
+	}), aNode)
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", ei.MimeType)
+	func() {
+		kops.hydrationLock.Lock()
+		defer kops.hydrationLock.Unlock()
+		kops.hydrated[aNode.GetID()] = BlockPointer{}
+	}()
+
+	t.Log("Logging in again as u1 keeps the folder state")
+	serviceLoggedIn(ctx, config, session1, TLFJournalBackgroundWorkEnabled)
+	require.Equal(t, ops1, getOps(config, tlfID1))
+	mdcache := config.MDCache()
+
+	t.Log("Switch to u2 without a logout")
+	_, id2, err := config.KBPKI().Resolve(ctx, u2.String())
+	require.NoError(t, err)
+	config.KeybaseService().(*KeybaseDaemonLocal).setCurrentUID(
+		id2.AsUserOrBust())
+	SwitchDeviceForLocalUserOrBust(t, config, 0)
+	session2, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	serviceLoggedIn(ctx, config, session2, TLFJournalBackgroundWorkEnabled)
+
+	t.Log("None of u1's folder state or caches should be left")
+	func() {
+		require.Equal(t, 0, kops.ops.len())
+		kops.opsLock.RLock()
+		defer kops.opsLock.RUnlock()
+		require.Len(t, kops.opsByFav, 0)
+	}()
+	require.Len(t, kops.MirrorJobStatuses(), 0)
+	func() {
+		kops.rechunkJobsLock.Lock()
+		defer kops.rechunkJobsLock.Unlock()
+		require.Len(t, kops.rechunkJobs, 0)
+	}()
+	func() {
+		kops.localSyncsLock.Lock()
+		defer kops.localSyncsLock.Unlock()
+		require.Len(t, kops.localSyncs, 0)
+	}()
+	func() {
+		kops.pinnedFilesLock.Lock()
+		defer kops.pinnedFilesLock.Unlock()
+		require.Len(t, kops.pinnedFiles, 0)
+	}()
+	func() {
+		kops.readOnlyLock.Lock()
+		defer kops.readOnlyLock.Unlock()
+		require.Len(t, kops.readOnlyTlfs, 0)
+	}()
+	func() {
+		kops.mimeTypeLock.Lock()
+		defer kops.mimeTypeLock.Unlock()
+		require.Equal(t, 0, kops.mimeTypes.Len())
+	}()
+	func() {
+		kops.hydrationLock.Lock()
+		defer kops.hydrationLock.Unlock()
+		require.Len(t, kops.hydrating, 0)
+		require.Len(t, kops.hydrated, 0)
+		require.False(t, kops.hydrationShutdown)
+	}()
+	select {
+	case <-ops1.shutdownChan:
+	default:
+		t.Fatal("u1's folder wasn't shut down")
+	}
+	require.NotEqual(t, mdcache, config.MDCache())
+
+	t.Log("u2 can use its own folder")
+	rootNode2 := GetRootNodeOrBust(ctx, t, config, u2.String(), tlf.Private)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode2, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsGetNodeByPath(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Make a/b/c, plus some symlinks")
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateDir(ctx, aNode, "b")
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateFile(ctx, bNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, cNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "toB", "a/b")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, bNode, "up", "../../toB/c")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "abs", "/etc")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "out", "../u2")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "loop1", "loop2")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "loop2", "loop1")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	for _, p := range []string{"", "/", "."} {
+		n, ei, err := kbfsOps.GetNodeByPath(ctx, fb, p)
+		require.NoError(t, err)
+		require.Equal(t, rootNode.GetID(), n.GetID())
+		require.Equal(t, Dir, ei.Type)
+	}
+
+	for _, p := range []string{
+		"a/b/c", "/a/b/c", "a/./b/../b/c", "toB/c", "a/b/up", "toB/up"} {
+		n, ei, err := kbfsOps.GetNodeByPath(ctx, fb, p)
+		require.NoError(t, err, p)
+		require.Equal(t, cNode.GetID(), n.GetID(), p)
+		require.Equal(t, File, ei.Type, p)
+		require.Equal(t, uint64(5), ei.Size, p)
+
+		ei, err = kbfsOps.StatByPath(ctx, fb, p)
+		require.NoError(t, err, p)
+		require.Equal(t, File, ei.Type, p)
+	}
+
+	n, _, err := kbfsOps.GetNodeByPath(ctx, fb, "toB")
+	require.NoError(t, err)
+	require.Equal(t, bNode.GetID(), n.GetID())
+
+	_, err = kbfsOps.StatByPath(ctx, fb, "a/d")
+	require.IsType(t, NoSuchNameError{}, errors.Cause(err))
+	_, err = kbfsOps.StatByPath(ctx, fb, "a/b/c/d")
+	require.IsType(t, NotDirError{}, errors.Cause(err))
+	_, err = kbfsOps.StatByPath(ctx, fb, "abs")
+	require.IsType(t, UnfollowableSymlinkError{}, errors.Cause(err))
+	_, err = kbfsOps.StatByPath(ctx, fb, "out")
+	require.IsType(t, UnfollowableSymlinkError{}, errors.Cause(err))
+	_, err = kbfsOps.StatByPath(ctx, fb, "loop1")
+	require.IsType(t, TooManySymlinksError{}, errors.Cause(err))
+
+	t.Log("The symlink limit is configurable")
+	config.SetMaxSymlinkLevels(1)
+	_, err = kbfsOps.StatByPath(ctx, fb, "toB/c")
+	require.NoError(t, err)
+	_, err = kbfsOps.StatByPath(ctx, fb, "toB/up")
+	require.IsType(t, TooManySymlinksError{}, errors.Cause(err))
+}
+
+func TestKBFSOpsRemoveAll(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// Sync every dir op right away, so that any revisions RemoveAll
+	// makes show up immediately.
+	config.SetBGFlushDirOpBatchSize(1)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Make a/b/{c,d}, a/e and f")
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateDir(ctx, aNode, "b")
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateFile(ctx, bNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, cNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, bNode, "d", false, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, aNode, "e", "b/c")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	md, err := config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	rev := md.Revision()
+
+	t.Log("Remove a in a single revision")
+	err = kbfsOps.RemoveAll(ctx, rootNode, "a")
+	require.NoError(t, err)
+	md, err = config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	require.Equal(t, rev+1, md.Revision())
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "f")
+
+	t.Log("Another device sees the same tree")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	children, err = config2.KBFSOps().GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "f")
+
+	t.Log("Remove a file, and a name that doesn't exist")
+	err = kbfsOps.RemoveAll(ctx, rootNode, "f")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveAll(ctx, rootNode, "a")
+	require.Equal(t, NoSuchNameError{"a"}, errors.Cause(err))
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+}
+
+func TestKBFSOpsRenameAcrossTlfsFallback(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	privRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	kbfsOps := config.KBFSOps()
+
+	t.Log("Make a/{f,x,s} in the private TLF, and g in the public one")
+	aNode, _, err := kbfsOps.CreateDir(ctx, privRoot, "a")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, aNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 2*crossTlfRenameChunkSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "x", true, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, aNode, "s", "f")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, pubRoot, "g", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, pubRoot.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Renames across TLFs fail by default")
+	err = kbfsOps.Rename(ctx, privRoot, "a", pubRoot, "b")
+	require.Equal(t, RenameAcrossDirsError{}, err)
+
+	var progress []CrossTlfRenameProgress
+	config.KBFSOps().(*KBFSOpsStandard).SetCrossTlfRenameFallback(true,
+		func(p CrossTlfRenameProgress) {
+			progress = append(progress, p)
+		})
+
+	t.Log("A directory can't replace a file")
+	err = kbfsOps.Rename(ctx, privRoot, "a", pubRoot, "g")
+	require.IsType(t, NotFileError{}, errors.Cause(err))
+
+	t.Log("Copy a over to the public TLF")
+	progress = nil
+	err = kbfsOps.Rename(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, privRoot, "a")
+	require.IsType(t, NoSuchNameError{}, errors.Cause(err))
+
+	bNode, _, err := kbfsOps.Lookup(ctx, pubRoot, "b")
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, bNode)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	require.Equal(t, File, children["f"].Type)
+	require.Equal(t, Exec, children["x"].Type)
+	require.Equal(t, Sym, children["s"].Type)
+	require.Equal(t, "f", children["s"].SymPath)
+	newFNode, _, err := kbfsOps.Lookup(ctx, bNode, "f")
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, newFNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.True(t, bytes.Equal(data, buf))
+
+	require.True(t, len(progress) > 2)
+	require.Equal(t, CrossTlfRenameProgress{
+		FilesCopied: 2,
+		FilesTotal:  2,
+		BytesCopied: int64(len(data)),
+		BytesTotal:  int64(len(data)),
+	}, progress[len(progress)-1])
+	require.Equal(t, "a/f", progress[0].Path)
+}
+
+func TestKBFSOpsCloneTlfContents(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	srcRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+
+	t.Log("Make a template with a/{f,s} and x")
+	aNode, _, err := kbfsOps.CreateDir(ctx, srcRoot, "a")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, aNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte("template data")
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, aNode, "s", "f")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, srcRoot, "x", true, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, srcRoot.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Clone it into a new TLF")
+	h := parseTlfHandleOrBust(t, config, "u1,u2", tlf.Private, tlf.NullID)
+	err = kbfsOps.(*KBFSOpsStandard).CloneTlfContents(
+		ctx, srcRoot.GetFolderBranch(), h)
+	require.NoError(t, err)
+
+	dstRoot, _, err := kbfsOps.GetRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	require.NotNil(t, dstRoot)
+	children, err := kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Equal(t, Dir, children["a"].Type)
+	require.Equal(t, Exec, children["x"].Type)
+	newANode, _, err := kbfsOps.Lookup(ctx, dstRoot, "a")
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, newANode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Equal(t, "f", children["s"].SymPath)
+	newFNode, _, err := kbfsOps.Lookup(ctx, newANode, "f")
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, newFNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+
+	t.Log("The destination must be a new TLF")
+	err = kbfsOps.(*KBFSOpsStandard).CloneTlfContents(
+		ctx, srcRoot.GetFolderBranch(), h)
+	require.Error(t, err)
+}
+
+func TestKBFSOpsEvictIdleFolders(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	kbfsOps.SetFolderEviction(time.Hour, 0)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	isEvicted := func() bool {
+		_, ok := kbfsOps.ops.get(fb)
+		return !ok
+	}
+
+	t.Log("Folders with dirty state or open nodes aren't evicted")
+	clock.Add(2 * time.Hour)
+	kbfsOps.evictIdleFolders(ctx)
+	require.False(t, isEvicted())
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	clock.Add(2 * time.Hour)
+	kbfsOps.evictIdleFolders(ctx)
+	require.False(t, isEvicted())
+
+	t.Log("Recently used folders aren't evicted")
+	ops := kbfsOps.getOpsNoAdd(ctx, fb)
+	rootNode, fileNode = nil, nil
+	for i := 0; ops.nodeCache.NumNodes() > 0; i++ {
+		require.True(t, i < 100, "Nodes weren't collected")
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	kbfsOps.evictIdleFolders(ctx)
+	require.False(t, isEvicted())
+
+	t.Log("Idle folders are evicted after the TTL")
+	clock.Add(2 * time.Hour)
+	kbfsOps.evictIdleFolders(ctx)
+	require.True(t, isEvicted())
+
+	t.Log("Evicted folders start up again when used")
+	rootNode = GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fileNode, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	require.Equal(t, []byte("hello"), buf)
+	require.False(t, isEvicted())
+}
+
+func TestKBFSOpsReadOnlyFolder(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Another device opens the folder read-only")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	kbfsOps2 := config2.KBFSOps().(*KBFSOpsStandard)
+	err = kbfsOps2.SetFolderReadOnly(ctx, fb.Tlf, true)
+	require.NoError(t, err)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = kbfsOps2.Read(ctx, fileNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), buf)
+
+	t.Log("Changes fail with a ReadOnlyError")
+	_, _, err = kbfsOps2.CreateFile(ctx, rootNode2, "b", false, NoExcl)
+	require.IsType(t, ReadOnlyError{}, errors.Cause(err))
+	err = kbfsOps2.Write(ctx, fileNode2, []byte("x"), 0)
+	require.IsType(t, ReadOnlyError{}, errors.Cause(err))
+	err = kbfsOps2.RemoveEntry(ctx, rootNode2, "a")
+	require.IsType(t, ReadOnlyError{}, errors.Cause(err))
+	err = kbfsOps2.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Changes made elsewhere still show up")
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	children, err := kbfsOps2.GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Contains(t, children, "b")
+
+	t.Log("The mode can't change while the folder is in use")
+	err = kbfsOps2.SetFolderReadOnly(ctx, fb.Tlf, false)
+	require.Error(t, err)
+	require.True(t, kbfsOps2.isFolderReadOnly(fb.Tlf))
+
+	t.Log("Once idle, the folder reopens read-write")
+	ops := kbfsOps2.getOpsNoAdd(ctx, fb)
+	rootNode2, fileNode2 = nil, nil
+	for i := 0; ops.nodeCache.NumNodes() > 0; i++ {
+		require.True(t, i < 100, "Nodes weren't collected")
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	err = kbfsOps2.SetFolderReadOnly(ctx, fb.Tlf, false)
+	require.NoError(t, err)
+	rootNode2 = GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	_, _, err = kbfsOps2.CreateFile(ctx, rootNode2, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fb)
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsLookupWithDirtyEntries(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+
+	t.Log("Make a and c, and sync them")
+	aNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Without syncing, write to a, make b and s, and remove c")
+	err = kbfsOps.Write(ctx, aNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "s", "a")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "c")
+	require.NoError(t, err)
+
+	t.Log("Lookups see the unsynced changes")
+	_, ei, err := kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), ei.Size)
+	_, ei, err = kbfsOps.Lookup(ctx, rootNode, "b")
+	require.NoError(t, err)
+	require.Equal(t, File, ei.Type)
+	_, ei, err = kbfsOps.Lookup(ctx, rootNode, "s")
+	require.NoError(t, err)
+	require.Equal(t, Sym, ei.Type)
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "c")
+	require.Equal(t, NoSuchNameError{"c"}, errors.Cause(err))
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "d")
+	require.Equal(t, NoSuchNameError{"d"}, errors.Cause(err))
+
+	t.Log("They match the full listing")
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	for name, childEI := range children {
+		_, ei, err := kbfsOps.Lookup(ctx, rootNode, name)
+		require.NoError(t, err)
+		require.Equal(t, childEI, ei)
+	}
+
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsGetQuotaInfo(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Write a file into the TLF")
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, make([]byte, 1000), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("The user's usage includes the TLF's usage")
+	info, err := kbfsOps.GetQuotaInfo(ctx)
+	require.NoError(t, err)
+	usage := info.Total.Bytes[kbfsblock.UsageWrite]
+	require.True(t, usage >= 1000)
+	require.Contains(t, info.Folders, fb.Tlf.String())
+	require.Equal(t,
+		usage, info.Folders[fb.Tlf.String()].Bytes[kbfsblock.UsageWrite])
+
+	t.Log("The folder status shows the same usage")
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, usage, status.UsageBytes)
+	require.Equal(t, usage, status.FolderUsageBytes)
+	require.Equal(t, int64(0), status.FolderGitUsageBytes)
+}
+
+func TestKBFSOpsNodeHistory(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Create a file in a subdirectory, and write it twice")
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	v1 := []byte("first version")
+	err = kbfsOps.Write(ctx, fileNode, v1, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	v2 := []byte("second, longer version")
+	err = kbfsOps.Write(ctx, fileNode, v2, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Change something else, which shouldn't show up")
+	_, _, err = kbfsOps.CreateFile(ctx, dirNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	history, err := kbfsOps.GetNodeHistory(ctx, fileNode)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, uint64(0), history[0].Size)
+	require.Equal(t, uint64(len(v1)), history[1].Size)
+	require.Equal(t, uint64(len(v2)), history[2].Size)
+	for i, rev := range history {
+		require.Equal(t, u1, rev.WriterUnverified)
+		if i > 0 {
+			require.True(t, rev.Revision > history[i-1].Revision)
+		}
+	}
+
+	t.Log("Unsynced writes don't affect older revisions")
+	err = kbfsOps.Write(ctx, fileNode, []byte("unsynced"), 0)
+	require.NoError(t, err)
+
+	buf := make([]byte, 100)
+	n, err := kbfsOps.ReadAtRevision(
+		ctx, fileNode, history[0].Revision, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+	n, err = kbfsOps.ReadAtRevision(
+		ctx, fileNode, history[1].Revision, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, v1, buf[:n])
+	n, err = kbfsOps.ReadAtRevision(
+		ctx, fileNode, history[2].Revision, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, v2, buf[:n])
+	n, err = kbfsOps.ReadAtRevision(
+		ctx, fileNode, history[2].Revision, buf, 7)
+	require.NoError(t, err)
+	require.Equal(t, v2[7:], buf[:n])
+
+	t.Log("The file doesn't exist before it was created")
+	_, err = kbfsOps.ReadAtRevision(
+		ctx, fileNode, history[0].Revision-1, buf, 0)
+	require.Equal(t, NoSuchNameError{"a"}, errors.Cause(err))
+
+	t.Log("Directories can't be read")
+	_, err = kbfsOps.ReadAtRevision(
+		ctx, dirNode, history[0].Revision, buf, 0)
+	require.IsType(t, NotFileError{}, errors.Cause(err))
+
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsArchivedBranch(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, t0 := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Write two versions of a file, an hour apart")
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	v1 := []byte("first version")
+	err = kbfsOps.Write(ctx, fileNode, v1, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	rev1 := status.Revision
+
+	clock.Add(1 * time.Hour)
+	v2 := []byte("second, longer version")
+	err = kbfsOps.Write(ctx, fileNode, v2, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), u1.String(), tlf.Private)
+	require.NoError(t, err)
+	checkArchived := func(branch BranchName, expected []byte) {
+		archivedRoot, _, err := kbfsOps.GetRootNode(ctx, h, branch)
+		require.NoError(t, err)
+		require.NotNil(t, archivedRoot)
+		require.Equal(t, branch, archivedRoot.GetFolderBranch().Branch)
+
+		archivedFile, _, err := kbfsOps.Lookup(ctx, archivedRoot, "a")
+		require.NoError(t, err)
+		buf := make([]byte, 100)
+		n, err := kbfsOps.Read(ctx, archivedFile, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf[:n])
+
+		t.Logf("Writes to %s are refused", branch)
+		err = kbfsOps.Write(ctx, archivedFile, []byte("x"), 0)
+		require.IsType(t, WriteToReadonlyNodeError{}, errors.Cause(err))
+		_, _, err = kbfsOps.CreateFile(
+			ctx, archivedRoot, "b", false, NoExcl)
+		require.IsType(t, WriteToReadonlyNodeError{}, errors.Cause(err))
+		err = kbfsOps.RemoveEntry(ctx, archivedRoot, "a")
+		require.IsType(t, WriteToReadonlyNodeError{}, errors.Cause(err))
+	}
+
+	t.Log("Open the folder as of the first version, by revision and time")
+	revBranch := MakeRevBranchName(rev1)
+	checkArchived(revBranch, v1)
+	checkArchived(MakeTimeBranchName(t0.Add(30*time.Minute)), v1)
+	checkArchived(MakeTimeBranchName(clock.Now()), v2)
+
+	t.Log("Changes to the master branch don't affect archived branches")
+	err = kbfsOps.Write(ctx, fileNode, []byte("third"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServer(ctx,
+		FolderBranch{Tlf: fb.Tlf, Branch: revBranch}, nil)
+	require.NoError(t, err)
+	checkArchived(revBranch, v1)
+
+	t.Log("The folder didn't exist before it was created")
+	node, _, err := kbfsOps.GetRootNode(
+		ctx, h, MakeTimeBranchName(t0.Add(-1*time.Hour)))
+	require.NoError(t, err)
+	require.Nil(t, node)
+
+	t.Log("Invalid archived branch names are rejected")
+	_, _, err = kbfsOps.GetRootNode(ctx, h, "rev=first")
+	require.IsType(t, InvalidBranchNameError{}, errors.Cause(err))
+}
+
+func TestArchivedBranchNames(t *testing.T) {
+	require.False(t, MasterBranch.IsArchived())
+
+	rev, ok := MakeRevBranchName(10).RevisionIfSpecified()
+	require.True(t, ok)
+	require.Equal(t, kbfsmd.Revision(10), rev)
+	_, ok = BranchName("rev=0").RevisionIfSpecified()
+	require.False(t, ok)
+	require.True(t, BranchName("rev=x").IsArchived())
+	_, ok = BranchName("rev=x").RevisionIfSpecified()
+	require.False(t, ok)
+
+	date, ok := BranchName("time=2016-01-01").TimeIfSpecified()
+	require.True(t, ok)
+	require.True(t, date.Equal(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)))
+	now := time.Now()
+	parsed, ok := MakeTimeBranchName(now).TimeIfSpecified()
+	require.True(t, ok)
+	require.True(t, parsed.Equal(now))
+	_, ok = BranchName("time=yesterday").TimeIfSpecified()
+	require.False(t, ok)
+	_, ok = MakeRevBranchName(10).TimeIfSpecified()
+	require.False(t, ok)
+}
+
+// testFilterObserver also records the nodes passed to LocalChange.
+type testFilterObserver struct {
+	testCRObserver
+	local []Node
+}
+
+func (t *testFilterObserver) LocalChange(
+	_ context.Context, node Node, _ WriteRange) {
+	t.local = append(t.local, node)
+}
+
+func TestKBFSOpsObserverFilter(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateDir(ctx, aNode, "b")
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	newObs := func() *testFilterObserver {
+		return &testFilterObserver{
+			testCRObserver: testCRObserver{make(chan struct{}, 100), nil},
+		}
+	}
+	register := func(filter ObserverFilter) *testFilterObserver {
+		obs := newObs()
+		err := config.Notifier().RegisterForChangesWithFilter(
+			[]FolderBranch{fb}, obs, filter)
+		require.NoError(t, err)
+		return obs
+	}
+	aOnly := register(ObserverFilter{Root: aNode})
+	aRecursive := register(ObserverFilter{Root: aNode, Recursive: true})
+	creates := register(ObserverFilter{Types: NodeChangeCreate})
+	cPrefix := register(ObserverFilter{PathPrefix: "c"})
+	all := newObs()
+	err = config.Notifier().RegisterForChanges([]FolderBranch{fb}, all)
+	require.NoError(t, err)
+
+	t.Log("Create a file in a/b, then write to it")
+	fileNode, _, err := kbfsOps.CreateFile(ctx, bNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Create a file in c")
+	gNode, _, err := kbfsOps.CreateFile(ctx, cNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	nodesAndTypes := func(obs *testFilterObserver) map[NodeID]NodeChangeType {
+		res := make(map[NodeID]NodeChangeType)
+		for _, change := range obs.changes {
+			res[change.Node.GetID()] |= change.Type
+		}
+		for _, node := range obs.local {
+			res[node.GetID()] |= NodeChangeWrite
+		}
+		return res
+	}
+
+	require.Len(t, aOnly.changes, 0)
+	require.Equal(t, map[NodeID]NodeChangeType{
+		bNode.GetID():    NodeChangeCreate,
+		fileNode.GetID(): NodeChangeWrite,
+	}, nodesAndTypes(aRecursive))
+	require.Equal(t, map[NodeID]NodeChangeType{
+		bNode.GetID(): NodeChangeCreate,
+		cNode.GetID(): NodeChangeCreate,
+	}, nodesAndTypes(creates))
+	require.Equal(t, map[NodeID]NodeChangeType{
+		cNode.GetID(): NodeChangeCreate,
+		gNode.GetID(): NodeChangeWrite,
+	}, nodesAndTypes(cPrefix))
+	require.Len(t, nodesAndTypes(all), 4)
+
+	t.Log("Unregistered observers get nothing more")
+	err = config.Notifier().UnregisterFromChanges(
+		[]FolderBranch{fb}, aRecursive)
+	require.NoError(t, err)
+	numChanges := len(aRecursive.changes)
+	numCreates := len(creates.changes)
+	_, _, err = kbfsOps.CreateFile(ctx, bNode, "h", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, aRecursive.changes, numChanges)
+	require.Len(t, creates.changes, numCreates+1)
+}
+
+func TestKBFSOpsRegisterForChangesMultipleFolders(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	privNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	pubNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	privFB := privNode.GetFolderBranch()
+	pubFB := pubNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+
+	createDir := func(root Node, name string) Node {
+		n, _, err := kbfsOps.CreateDir(ctx, root, name)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+		require.NoError(t, err)
+		return n
+	}
+	changedNodes := func(obs *testCRObserver) (ids []NodeID) {
+		for _, change := range obs.changes {
+			ids = append(ids, change.Node.GetID())
+		}
+		return ids
+	}
+
+	t.Log("One observer registered for both folders hears from both")
+	obs := &testCRObserver{make(chan struct{}, 100), nil}
+	err := config.Notifier().RegisterForChanges(
+		[]FolderBranch{privFB, pubFB, privFB}, obs)
+	require.NoError(t, err)
+	createDir(privNode, "a")
+	createDir(pubNode, "b")
+	require.Equal(t,
+		[]NodeID{privNode.GetID(), pubNode.GetID()}, changedNodes(obs))
+
+	t.Log("A failed registration is rolled back")
+	failObs := &testCRObserver{make(chan struct{}, 100), nil}
+	errFail := errors.New("fail")
+	err = kbfsOps.(*KBFSOpsStandard).registerForChanges(
+		[]FolderBranch{privFB, pubFB}, failObs,
+		func(ops *folderBranchOps, fb FolderBranch) error {
+			if fb == pubFB {
+				return errFail
+			}
+			return ops.RegisterForChanges(fb, failObs)
+		})
+	require.Equal(t, errFail, err)
+	createDir(privNode, "c")
+	require.Len(t, failObs.changes, 0)
+
+	t.Log("Unregistering covers every folder")
+	err = config.Notifier().UnregisterFromChanges(
+		[]FolderBranch{privFB, pubFB}, obs)
+	require.NoError(t, err)
+	numChanges := len(obs.changes)
+	createDir(privNode, "d")
+	createDir(pubNode, "e")
+	require.Len(t, obs.changes, numChanges)
+}
+
+func TestKBFSOpsWriteAppend(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte("hello"), 0)
+	require.NoError(t, err)
+
+	t.Log("An append ignores the given offset")
+	off, err := kbfsOps.WriteWithFlags(
+		ctx, fileNode, []byte(" world"), 0, WriteAppend)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), off)
+
+	t.Log("Concurrent appends never overwrite each other")
+	const numAppends = 20
+	var wg sync.WaitGroup
+	errCh := make(chan error, numAppends)
+	for i := 0; i < numAppends; i++ {
+		wg.Add(1)
+		go func(b byte) {
+			defer wg.Done()
+			_, err := kbfsOps.WriteWithFlags(
+				ctx, fileNode, []byte{b}, 0, WriteAppend)
+			errCh <- err
+		}(byte('a' + i))
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	ei, err := kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len("hello world")+numAppends), ei.Size)
+	buf := make([]byte, ei.Size)
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(buf)), n)
+	require.Equal(t, "hello world", string(buf[:11]))
+	appended := buf[11:]
+	sort.Slice(appended, func(i, j int) bool {
+		return appended[i] < appended[j]
+	})
+	require.Equal(t, "abcdefghijklmnopqrst", string(appended))
+
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsCopyFile(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// Make the blocks small, so the file has several leaf blocks to
+	// share.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024}
+	config.SetBlockSplitter(bsplit)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Copy a file with unsynced writes into a subdirectory")
+	aNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", true, NoExcl)
+	require.NoError(t, err)
+	data := []byte("abcdefghijklmnopqrst")
+	err = kbfsOps.Write(ctx, aNode, data, 0)
+	require.NoError(t, err)
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	bNode, ei, err := kbfsOps.CopyFile(ctx, aNode, dNode, "b")
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	require.Equal(t, uint64(len(data)), ei.Size)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, bNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+
+	t.Log("The copy shares the original's leaf blocks")
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsNoAdd(ctx, fb)
+	lState := makeFBOLockState()
+	md, _ := ops.getHead(lState)
+	leafIDs := func(node Node) map[kbfsblock.ID]bool {
+		infos, err := ops.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md, ops.nodeCache.PathFromNode(node))
+		require.NoError(t, err)
+		ids := make(map[kbfsblock.ID]bool)
+		for _, info := range infos {
+			if info.DirectType == DirectBlock {
+				ids[info.ID] = true
+			}
+		}
+		return ids
+	}
+	aIDs := leafIDs(aNode)
+	require.Len(t, aIDs, 4)
+	require.Equal(t, aIDs, leafIDs(bNode))
+
+	t.Log("Writing to the copy leaves the original alone")
+	err = kbfsOps.Write(ctx, bNode, []byte("XY"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	n, err = kbfsOps.Read(ctx, aNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+
+	t.Log("Another device sees the copy")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	dNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "d")
+	require.NoError(t, err)
+	bNode2, _, err := kbfsOps2.Lookup(ctx, dNode2, "b")
+	require.NoError(t, err)
+	n, err = kbfsOps2.Read(ctx, bNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, "XYcdefghijklmnopqrst", string(buf))
+
+	t.Log("Copying onto an existing name fails")
+	_, _, err = kbfsOps.CopyFile(ctx, aNode, dNode, "b")
+	require.Equal(t, NameExistsError{"b"}, errors.Cause(err))
+}
+
+func TestKBFSOpsRenameWithFlags(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// Sync every dir op right away, so that the revisions each
+	// rename makes show up immediately.
+	config.SetBGFlushDirOpBatchSize(1)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	makeFile := func(dir Node, name string) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte(name), 0)
+		require.NoError(t, err)
+	}
+	readFile := func(dir Node, name string) string {
+		n, ei, err := kbfsOps.Lookup(ctx, dir, name)
+		require.NoError(t, err)
+		buf := make([]byte, ei.Size)
+		_, err = kbfsOps.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	t.Log("Make a, b and d/c")
+	makeFile(rootNode, "a")
+	makeFile(rootNode, "b")
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	makeFile(dNode, "c")
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("A no-replace rename over an existing entry fails")
+	err = kbfsOps.RenameWithFlags(
+		ctx, rootNode, "a", rootNode, "b", RenameNoReplace)
+	require.Equal(t, NameExistsError{"b"}, errors.Cause(err))
+	require.Equal(t, "a", readFile(rootNode, "a"))
+	require.Equal(t, "b", readFile(rootNode, "b"))
+	err = kbfsOps.RenameWithFlags(
+		ctx, rootNode, "a", rootNode, "e", RenameNoReplace)
+	require.NoError(t, err)
+	require.Equal(t, "a", readFile(rootNode, "e"))
+
+	md, err := config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	rev := md.Revision()
+
+	t.Log("Exchange e and d/c in a single revision")
+	err = kbfsOps.RenameExchange(ctx, rootNode, "e", dNode, "c")
+	require.NoError(t, err)
+	md, err = config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	require.Equal(t, rev+1, md.Revision())
+	require.Equal(t, "c", readFile(rootNode, "e"))
+	require.Equal(t, "a", readFile(dNode, "c"))
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+
+	t.Log("Exchanging with a missing entry changes nothing")
+	err = kbfsOps.RenameExchange(ctx, rootNode, "e", dNode, "nope")
+	require.Equal(t, NoSuchNameError{"nope"}, errors.Cause(err))
+	require.Equal(t, "c", readFile(rootNode, "e"))
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+}
+
+func TestKBFSOpsXattrs(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+
+	t.Log("Set a couple of attributes")
+	err = kbfsOps.SetXattr(ctx, fNode, "user.tag", []byte("red"))
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, fNode, "com.apple.quarantine", nil)
+	require.NoError(t, err)
+	names, err := kbfsOps.ListXattr(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, []string{"com.apple.quarantine", "user.tag"}, names)
+	value, err := kbfsOps.GetXattr(ctx, fNode, "user.tag")
+	require.NoError(t, err)
+	require.Equal(t, []byte("red"), value)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Another device sees them")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	fNode2, _, err := config2.KBFSOps().Lookup(ctx, rootNode2, "f")
+	require.NoError(t, err)
+	value, err = config2.KBFSOps().GetXattr(ctx, fNode2, "user.tag")
+	require.NoError(t, err)
+	require.Equal(t, []byte("red"), value)
+
+	t.Log("Remove one, and check the limits")
+	err = kbfsOps.RemoveXattr(ctx, fNode, "user.tag")
+	require.NoError(t, err)
+	_, err = kbfsOps.GetXattr(ctx, fNode, "user.tag")
+	require.Equal(t, NoSuchXattrError{"user.tag"}, errors.Cause(err))
+	err = kbfsOps.RemoveXattr(ctx, fNode, "user.tag")
+	require.Equal(t, NoSuchXattrError{"user.tag"}, errors.Cause(err))
+	err = kbfsOps.SetXattr(
+		ctx, fNode, "user.big", make([]byte, MaxXattrsSize))
+	require.IsType(t, XattrTooBigError{}, errors.Cause(err))
+	err = kbfsOps.SetXattr(ctx, rootNode, "user.tag", []byte("red"))
+	require.IsType(t, InvalidParentPathError{}, errors.Cause(err))
+	names, err = kbfsOps.ListXattr(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, []string{"com.apple.quarantine"}, names)
+}
+
+func TestKBFSOpsSetMode(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fNode, ei, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), ei.Perm())
+	dNode, ei, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), ei.Perm())
+
+	t.Log("The user exec bit makes a file executable")
+	err = kbfsOps.SetMode(ctx, fNode, 0755)
+	require.NoError(t, err)
+	ei, err = kbfsOps.Stat(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	require.Equal(t, os.FileMode(0755), ei.Perm())
+	err = kbfsOps.SetMode(ctx, fNode, 0640)
+	require.NoError(t, err)
+	ei, err = kbfsOps.Stat(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, File, ei.Type)
+	require.Equal(t, os.FileMode(0640), ei.Perm())
+
+	t.Log("SetEx, like an old client would use, still works")
+	err = kbfsOps.SetMode(ctx, fNode, 0755)
+	require.NoError(t, err)
+	err = kbfsOps.SetEx(ctx, fNode, false)
+	require.NoError(t, err)
+	ei, err = kbfsOps.Stat(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), ei.Perm())
+
+	t.Log("Directories have modes too, but the root doesn't")
+	err = kbfsOps.SetMode(ctx, dNode, 0750)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	_, ei, err = config2.KBFSOps().Lookup(ctx, rootNode2, "d")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0750), ei.Perm())
+	err = kbfsOps.SetMode(ctx, rootNode, 0750)
+	require.IsType(t, InvalidParentPathError{}, errors.Cause(err))
+	err = kbfsOps.SetMode(ctx, fNode, os.ModeSetuid|0755)
+	require.Error(t, err)
+}
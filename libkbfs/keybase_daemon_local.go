@@ -55,10 +55,12 @@ func (m localImplicitTeamMap) getLocalImplicitTeam(
 	return team, nil
 }
 
+// favoriteStore stores the favorites of each user.  Like the
+// service, deleting a favorite ignores it, until it's added again.
 type favoriteStore interface {
 	FavoriteAdd(uid keybase1.UID, folder keybase1.Folder) error
 	FavoriteDelete(uid keybase1.UID, folder keybase1.Folder) error
-	FavoriteList(uid keybase1.UID) ([]keybase1.Folder, error)
+	FavoriteList(uid keybase1.UID) (keybase1.FavoritesResult, error)
 
 	Shutdown()
 }
@@ -75,6 +77,15 @@ func (c diskFavoriteClient) favkey(
 	return []byte(fmt.Sprintf("%s:%s", uid, folder.ToString()))
 }
 
+// diskFavoriteIgnoredPrefix starts the keys of ignored folders, so that they're
+// kept apart from the favorites.
+const diskFavoriteIgnoredPrefix = "ignored:"
+
+func (c diskFavoriteClient) ignoredKey(
+	uid keybase1.UID, folder keybase1.Folder) []byte {
+	return append([]byte(diskFavoriteIgnoredPrefix), c.favkey(uid, folder)...)
+}
+
 func (c diskFavoriteClient) FavoriteAdd(
 	uid keybase1.UID, folder keybase1.Folder) error {
 	enc, err := c.codec.Encode(folder)
@@ -82,17 +93,27 @@ func (c diskFavoriteClient) FavoriteAdd(
 		return err
 	}
 
-	return c.favoriteDb.Put(c.favkey(uid, folder), enc, nil)
+	batch := new(leveldb.Batch)
+	batch.Put(c.favkey(uid, folder), enc)
+	batch.Delete(c.ignoredKey(uid, folder))
+	return c.favoriteDb.Write(batch, nil)
 }
 
 func (c diskFavoriteClient) FavoriteDelete(
 	uid keybase1.UID, folder keybase1.Folder) error {
-	return c.favoriteDb.Delete(c.favkey(uid, folder), nil)
+	enc, err := c.codec.Encode(folder)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(c.favkey(uid, folder))
+	batch.Put(c.ignoredKey(uid, folder), enc)
+	return c.favoriteDb.Write(batch, nil)
 }
 
-func (c diskFavoriteClient) FavoriteList(uid keybase1.UID) (
-	[]keybase1.Folder, error) {
-	iter := c.favoriteDb.NewIterator(util.BytesPrefix([]byte(uid+":")), nil)
+func (c diskFavoriteClient) list(prefix string) ([]keybase1.Folder, error) {
+	iter := c.favoriteDb.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
 	defer iter.Release()
 	var folders []keybase1.Folder
 	for iter.Next() {
@@ -109,12 +130,29 @@ func (c diskFavoriteClient) FavoriteList(uid keybase1.UID) (
 	return folders, nil
 }
 
+func (c diskFavoriteClient) FavoriteList(uid keybase1.UID) (
+	keybase1.FavoritesResult, error) {
+	favorites, err := c.list(string(uid) + ":")
+	if err != nil {
+		return keybase1.FavoritesResult{}, err
+	}
+	ignored, err := c.list(diskFavoriteIgnoredPrefix + string(uid) + ":")
+	if err != nil {
+		return keybase1.FavoritesResult{}, err
+	}
+	return keybase1.FavoritesResult{
+		FavoriteFolders: favorites,
+		IgnoredFolders:  ignored,
+	}, nil
+}
+
 func (c diskFavoriteClient) Shutdown() {
 	c.favoriteDb.Close()
 }
 
 type memoryFavoriteClient struct {
 	favorites map[keybase1.UID]map[string]keybase1.Folder
+	ignored   map[keybase1.UID]map[string]keybase1.Folder
 }
 
 var _ favoriteStore = memoryFavoriteClient{}
@@ -125,6 +163,9 @@ func (c memoryFavoriteClient) FavoriteAdd(
 		c.favorites[uid] = make(map[string]keybase1.Folder)
 	}
 	c.favorites[uid][folder.ToString()] = folder
+	if c.ignored[uid] != nil {
+		delete(c.ignored[uid], folder.ToString())
+	}
 	return nil
 }
 
@@ -133,18 +174,26 @@ func (c memoryFavoriteClient) FavoriteDelete(
 	if c.favorites[uid] != nil {
 		delete(c.favorites[uid], folder.ToString())
 	}
+	if c.ignored[uid] == nil {
+		c.ignored[uid] = make(map[string]keybase1.Folder)
+	}
+	c.ignored[uid][folder.ToString()] = folder
 	return nil
 }
 
 func (c memoryFavoriteClient) FavoriteList(
-	uid keybase1.UID) ([]keybase1.Folder, error) {
-	folders := make([]keybase1.Folder, len(c.favorites[uid]))
-	i := 0
-	for _, v := range c.favorites[uid] {
-		folders[i] = v
-		i++
+	uid keybase1.UID) (keybase1.FavoritesResult, error) {
+	list := func(m map[string]keybase1.Folder) []keybase1.Folder {
+		folders := make([]keybase1.Folder, 0, len(m))
+		for _, v := range m {
+			folders = append(folders, v)
+		}
+		return folders
 	}
-	return folders, nil
+	return keybase1.FavoritesResult{
+		FavoriteFolders: list(c.favorites[uid]),
+		IgnoredFolders:  list(c.ignored[uid]),
+	}, nil
 }
 
 func (c memoryFavoriteClient) Shutdown() {}
@@ -830,9 +879,9 @@ func (k *KeybaseDaemonLocal) FavoriteDelete(
 
 // FavoriteList implements KeybaseDaemon for KeybaseDaemonLocal.
 func (k *KeybaseDaemonLocal) FavoriteList(
-	ctx context.Context, sessionID int) ([]keybase1.Folder, error) {
+	ctx context.Context, sessionID int) (keybase1.FavoritesResult, error) {
 	if err := checkContext(ctx); err != nil {
-		return nil, err
+		return keybase1.FavoritesResult{}, err
 	}
 
 	k.lock.Lock()
@@ -899,6 +948,7 @@ func NewKeybaseDaemonMemory(currentUID keybase1.UID,
 	codec kbfscodec.Codec) *KeybaseDaemonLocal {
 	favoriteStore := memoryFavoriteClient{
 		favorites: make(map[keybase1.UID]map[string]keybase1.Folder),
+		ignored:   make(map[keybase1.UID]map[string]keybase1.Folder),
 	}
 	return newKeybaseDaemonLocal(codec, currentUID, users, teams, favoriteStore)
 }
@@ -345,7 +345,9 @@ func (k *KeybaseServiceBase) LoggedIn(ctx context.Context, name string) error {
 // LoggedOut implements keybase1.NotifySessionInterface.
 func (k *KeybaseServiceBase) LoggedOut(ctx context.Context) error {
 	k.log.CDebugf(ctx, "Current session logged out")
-	k.setCachedCurrentSession(SessionInfo{})
+	// Don't let the logged-out user's view of other users and teams
+	// leak into the next session.
+	k.clearCaches()
 	if k.config != nil {
 		serviceLoggedOut(ctx, k.config)
 	}
@@ -1006,12 +1008,8 @@ func (k *KeybaseServiceBase) FavoriteDelete(ctx context.Context, folder keybase1
 }
 
 // FavoriteList implements the KeybaseService interface for KeybaseServiceBase.
-func (k *KeybaseServiceBase) FavoriteList(ctx context.Context, sessionID int) ([]keybase1.Folder, error) {
-	results, err := k.favoriteClient.GetFavorites(ctx, sessionID)
-	if err != nil {
-		return nil, err
-	}
-	return results.FavoriteFolders, nil
+func (k *KeybaseServiceBase) FavoriteList(ctx context.Context, sessionID int) (keybase1.FavoritesResult, error) {
+	return k.favoriteClient.GetFavorites(ctx, sessionID)
 }
 
 // Notify implements the KeybaseService interface for KeybaseServiceBase.
@@ -26,9 +26,20 @@ func EnableAdminFeature(ctx context.Context, runMode libkb.RunMode, config Confi
 
 // serviceLoggedIn should be called when a new user logs in. It
 // shouldn't be called again until after serviceLoggedOut is called.
+// If a different user was logged in before, all of their folder
+// state, journals and caches are discarded first, even if we never
+// heard about the logout.
 func serviceLoggedIn(ctx context.Context, config Config, session SessionInfo,
 	bws TLFJournalBackgroundWorkStatus) {
 	log := config.MakeLogger("")
+	if config.KBFSOps().ResetForUser(ctx, session.UID) {
+		log.CDebugf(ctx, "Logged in as a new user %s; resetting "+
+			"the previous user's state", session.UID)
+		if jServer, err := GetJournalServer(config); err == nil {
+			jServer.shutdownExistingJournals(ctx)
+		}
+		config.ResetCaches()
+	}
 	if jServer, err := GetJournalServer(config); err == nil {
 		err := jServer.EnableExistingJournals(
 			ctx, session.UID, session.VerifyingKey, bws)
@@ -60,7 +71,16 @@ func serviceLoggedOut(ctx context.Context, config Config) {
 	if jServer, err := GetJournalServer(config); err == nil {
 		jServer.shutdownExistingJournals(ctx)
 	}
+
+	// Clear any cached MD for all private TLFs, as they shouldn't be
+	// readable by a logged out user.  This also waits for any
+	// in-progress writes and stops background updates, so nothing
+	// repopulates the caches with plaintext after they are reset
+	// below.  We assume that a logged-out call always comes before a
+	// logged-in call.
+	config.KBFSOps().ClearPrivateFolderMD(ctx)
 	config.ResetCaches()
+
 	mdServer := config.MDServer()
 	if mdServer != nil {
 		mdServer.RefreshAuthToken(ctx)
@@ -71,9 +91,4 @@ func serviceLoggedOut(ctx context.Context, config Config) {
 	}
 	config.KBFSOps().RefreshCachedFavorites(ctx)
 	config.KBFSOps().PushStatusChange()
-
-	// Clear any cached MD for all private TLFs, as they shouldn't be
-	// readable by a logged out user.  We assume that a logged-out
-	// call always comes before a logged-in call.
-	config.KBFSOps().ClearPrivateFolderMD(ctx)
 }
@@ -0,0 +1,1095 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxLocalSyncTagKey is the type used for unique context tags within
+// a LocalSync.
+type CtxLocalSyncTagKey int
+
+const (
+	// CtxLocalSyncIDKey is the type of the tag for unique operation
+	// IDs within a LocalSync.
+	CtxLocalSyncIDKey CtxLocalSyncTagKey = iota
+)
+
+// CtxLocalSyncOpID is the display name for the unique operation
+// LocalSync ID tag.
+const CtxLocalSyncOpID = "LSID"
+
+const (
+	// localSyncDefaultPollInterval is how often a LocalSync checks
+	// the local directory for changes, unless configured otherwise.
+	localSyncDefaultPollInterval = 10 * time.Second
+	// localSyncWatchedRescanInterval is how often a LocalSync
+	// rescans the whole local directory while it's watched for
+	// changes, in case the OS missed any.
+	localSyncWatchedRescanInterval = 10 * time.Minute
+	// localSyncWatchCoalesceWindow is how long a LocalSync waits
+	// after a watched local change for more changes, before syncing
+	// them together.
+	localSyncWatchCoalesceWindow = 500 * time.Millisecond
+	// localSyncStateFileName is the name of the file in the root of
+	// the local directory that records the last synced state.  It
+	// isn't synced itself.
+	localSyncStateFileName = ".kbfs-sync-state"
+	// localSyncTempPrefix starts the names of the temporary files
+	// that local files are written to before being renamed into
+	// place.  They aren't synced.
+	localSyncTempPrefix = ".kbfs-mirror-"
+)
+
+// localSyncIgnored returns whether name, in the root directory if
+// inRoot is true, is left out of a LocalSync.
+func localSyncIgnored(name string, inRoot bool) bool {
+	return (inRoot && name == localSyncStateFileName) ||
+		strings.HasPrefix(name, localSyncTempPrefix)
+}
+
+// localSyncEntry is the state of a directory entry on one side of a
+// LocalSync.  Only the type matters for directories.
+type localSyncEntry struct {
+	Type    EntryType `json:"type"`
+	Size    uint64    `json:"size,omitempty"`
+	Mtime   int64     `json:"mtime,omitempty"`
+	SymPath string    `json:"sym,omitempty"`
+}
+
+func (e *localSyncEntry) equal(other *localSyncEntry) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return *e == *other
+}
+
+func localSyncEntryFromEntryInfo(ei EntryInfo) *localSyncEntry {
+	switch ei.Type {
+	case Dir:
+		return &localSyncEntry{Type: Dir}
+	case Sym:
+		return &localSyncEntry{Type: Sym, SymPath: ei.SymPath}
+	default:
+		return &localSyncEntry{Type: ei.Type, Size: ei.Size, Mtime: ei.Mtime}
+	}
+}
+
+func localSyncEntryFromFileInfo(
+	fi os.FileInfo, localPath string) (*localSyncEntry, error) {
+	switch {
+	case fi.IsDir():
+		return &localSyncEntry{Type: Dir}, nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			return nil, err
+		}
+		return &localSyncEntry{Type: Sym, SymPath: target}, nil
+	case fi.Mode().IsRegular():
+		t := File
+		if fi.Mode()&0100 != 0 {
+			t = Exec
+		}
+		return &localSyncEntry{
+			Type:  t,
+			Size:  uint64(fi.Size()),
+			Mtime: fi.ModTime().UnixNano(),
+		}, nil
+	default:
+		// Devices, sockets and the like can't be synced.
+		return nil, nil
+	}
+}
+
+// localSyncBase is the state of an entry on both sides, as of the
+// last time it was synced.
+type localSyncBase struct {
+	Local  localSyncEntry `json:"local"`
+	Remote localSyncEntry `json:"remote"`
+}
+
+// LocalSyncConfig describes a two-way sync between a directory in a
+// TLF and a local directory.
+type LocalSyncConfig struct {
+	// Remote is a directory within a TLF, in the form accepted by
+	// ParseMirrorEndpoint.  It's created if it doesn't exist yet.
+	Remote string
+	// Local is the absolute path of the local directory.  It's
+	// created if it doesn't exist yet.
+	Local string
+	// PollInterval is how often the local directory is checked for
+	// changes, if it can't be watched.  If zero, a default is used.
+	PollInterval time.Duration
+	// DisableWatch turns off watching the local directory with the
+	// OS's file-watching facilities, e.g. for network filesystems
+	// where they don't work, so that it's always polled instead.
+	DisableWatch bool
+	// Exclude lists the patterns, as accepted by path.Match, of
+	// entries left out of the sync on both sides.  A pattern with a
+	// slash is matched against the path of an entry relative to the
+	// synced directories, and any other pattern against its name.
+	Exclude []string
+}
+
+// LocalSyncStatus describes how a LocalSync is doing.
+type LocalSyncStatus struct {
+	Remote string
+	Local  string
+	// Watching is whether local changes are noticed by watching the
+	// local directory, rather than by polling it.
+	Watching bool
+	// Syncs counts the completed sync passes.
+	Syncs    int
+	LastSync time.Time
+	// LastError is the error of the last pass, if it failed.
+	LastError string
+	// Pushed and Pulled count the entries copied to and from the
+	// TLF, and Conflicts the entries that had changed on both
+	// sides.
+	Pushed    int
+	Pulled    int
+	Conflicts int
+}
+
+// LocalSync keeps a local directory and a directory in a TLF in
+// sync in both directions, for platforms where KBFS can't be
+// mounted.
+//
+// Like conflict resolution between a local branch and the merged
+// master branch of a TLF, each pass compares both sides to their
+// state at the end of the last pass, recorded in a state file in the
+// local directory: a change on only one side is applied to the other
+// side, including removals.  An entry changed on both sides is a
+// conflict: the remote version keeps the name, and the local version
+// is moved aside under a name from the config's ConflictRenamer and
+// synced under that name as well.  A directory removed on one side
+// keeps only the entries that changed under it on the other side.
+//
+// Remote changes are made like any other writes, so with journaling
+// enabled, local changes are synced even while offline, and flushed
+// to the servers once the journal can be.  Files are compared by
+// size and mtime.
+//
+// Where the OS supports it, the local directory is watched for
+// changes, which are synced within seconds by passes over just the
+// directories that changed; the whole directory is still rescanned
+// now and then.  Elsewhere, it's polled.
+type LocalSync struct {
+	fs           *KBFSOpsStandard
+	remote       MirrorEndpoint
+	local        string
+	pollInterval time.Duration
+	exclude      []string
+	// watcher watches the local directory, if that's possible.
+	watcher localWatcher
+
+	changedCh      chan struct{}
+	localChangedCh chan struct{}
+	shutdownCh     chan struct{}
+	doneCh         chan struct{}
+	stopOnce       sync.Once
+
+	// dirtyLock protects dirty, which holds the local directories
+	// whose entries were seen changing since the last pass began,
+	// relative to local.
+	dirtyLock sync.Mutex
+	dirty     map[string]bool
+
+	statusLock sync.Mutex
+	status     LocalSyncStatus
+	// passCh is closed, and replaced, at the end of every sync pass,
+	// and is protected by statusLock.
+	passCh chan struct{}
+
+	// passLock serializes sync passes, and protects the fields
+	// below.
+	passLock sync.Mutex
+	// dir is the remote directory, once it has been looked up.
+	dir  Node
+	base map[string]localSyncBase
+	// tlfExclude holds the exclude patterns of the remote TLF, as of
+	// the start of the current pass.  Entries they match are left
+	// alone on both sides.
+	tlfExclude *ExcludePatterns
+}
+
+var _ Observer = (*LocalSync)(nil)
+
+// StartLocalSync starts syncing as described by syncConfig.  The
+// first pass, and every later one, runs in the background; use
+// LocalSync.SyncNow to run one and wait for it.  Failed passes are
+// recorded in the status of the LocalSync and tried again later.
+func (fs *KBFSOpsStandard) StartLocalSync(
+	syncConfig LocalSyncConfig) (*LocalSync, error) {
+	remote, err := ParseMirrorEndpoint(syncConfig.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if remote.IsLocal() {
+		return nil, errors.Errorf("%s is not in a TLF", syncConfig.Remote)
+	}
+	if !filepath.IsAbs(syncConfig.Local) {
+		return nil, errors.Errorf("%s is not an absolute path",
+			syncConfig.Local)
+	}
+	pollInterval := syncConfig.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = localSyncDefaultPollInterval
+	}
+	for _, pattern := range syncConfig.Exclude {
+		if _, err := stdpath.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "Bad exclude pattern %q", pattern)
+		}
+	}
+
+	ls := &LocalSync{
+		fs:             fs,
+		remote:         remote,
+		local:          filepath.Clean(syncConfig.Local),
+		pollInterval:   pollInterval,
+		exclude:        syncConfig.Exclude,
+		changedCh:      make(chan struct{}, 1),
+		localChangedCh: make(chan struct{}, 1),
+		shutdownCh:     make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		passCh:         make(chan struct{}),
+		dirty:          make(map[string]bool),
+	}
+	ls.status.Remote = remote.String()
+	ls.status.Local = ls.local
+
+	err = os.MkdirAll(ls.local, 0700)
+	if err != nil {
+		return nil, err
+	}
+	ls.base, err = ls.readState()
+	if err != nil {
+		return nil, err
+	}
+	if !syncConfig.DisableWatch {
+		// Start watching before the first pass, so that no change is
+		// missed.
+		ls.watcher, err = newLocalWatcher(
+			ls.local, ls.ignored, ls.localChanged)
+		if err != nil {
+			fs.log.CDebugf(nil, "Polling %s instead of watching it: %+v",
+				ls.local, err)
+		}
+		ls.status.Watching = ls.watcher != nil
+	}
+
+	fs.localSyncsLock.Lock()
+	defer fs.localSyncsLock.Unlock()
+	fs.localSyncs[ls] = true
+	go ls.loop()
+	return ls, nil
+}
+
+// ignored returns whether name, in the directory at rel, is left out
+// of the sync.
+func (ls *LocalSync) ignored(rel string, name string) bool {
+	if localSyncIgnored(name, rel == "") {
+		return true
+	}
+	p := stdpath.Join(rel, name)
+	for _, pattern := range ls.exclude {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = p
+		}
+		if ok, _ := stdpath.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// localChanged records that the entries of the local directory at
+// rel changed, for the next pass to sync.
+func (ls *LocalSync) localChanged(rel string) {
+	ls.dirtyLock.Lock()
+	defer ls.dirtyLock.Unlock()
+	ls.dirty[rel] = true
+	select {
+	case ls.localChangedCh <- struct{}{}:
+	default:
+	}
+}
+
+// takeDirty returns the local directories that changed since the
+// last call, and forgets them.
+func (ls *LocalSync) takeDirty() map[string]bool {
+	ls.dirtyLock.Lock()
+	defer ls.dirtyLock.Unlock()
+	dirty := ls.dirty
+	ls.dirty = make(map[string]bool)
+	return dirty
+}
+
+func (ls *LocalSync) statePath() string {
+	return filepath.Join(ls.local, localSyncStateFileName)
+}
+
+func (ls *LocalSync) readState() (map[string]localSyncBase, error) {
+	base := make(map[string]localSyncBase)
+	buf, err := ioutil.ReadFile(ls.statePath())
+	if os.IsNotExist(err) {
+		return base, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &base)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse %s", ls.statePath())
+	}
+	return base, nil
+}
+
+// writeState records the synced state, replacing the state file
+// atomically.
+func (ls *LocalSync) writeState() error {
+	buf, err := json.Marshal(ls.base)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(ls.local, localSyncTempPrefix)
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+	return os.Rename(name, ls.statePath())
+}
+
+// Status returns the current status of ls.
+func (ls *LocalSync) Status() LocalSyncStatus {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	return ls.status
+}
+
+func (ls *LocalSync) countPushed() {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	ls.status.Pushed++
+}
+
+func (ls *LocalSync) countPulled() {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	ls.status.Pulled++
+}
+
+func (ls *LocalSync) countConflict() {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	ls.status.Conflicts++
+}
+
+// nextPassForTest returns a channel that is closed at the end of the
+// next sync pass to finish.
+func (ls *LocalSync) nextPassForTest() <-chan struct{} {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	return ls.passCh
+}
+
+func (ls *LocalSync) setResult(err error) {
+	ls.statusLock.Lock()
+	defer ls.statusLock.Unlock()
+	close(ls.passCh)
+	ls.passCh = make(chan struct{})
+	if err != nil {
+		ls.status.LastError = err.Error()
+		return
+	}
+	ls.status.Syncs++
+	ls.status.LastSync = ls.fs.config.Clock().Now()
+	ls.status.LastError = ""
+}
+
+func (ls *LocalSync) loop() {
+	defer close(ls.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxLocalSyncIDKey, CtxLocalSyncOpID, ls.fs.log))
+	defer cancel()
+	// Writes need a context that can delay their cancellation.
+	ctx, err := NewContextWithCancellationDelayer(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer CleanupCancellationDelayer(ctx)
+	go func() {
+		select {
+		case <-ls.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	rescanInterval := ls.pollInterval
+	if ls.watcher != nil {
+		defer ls.watcher.Close()
+		rescanInterval = localSyncWatchedRescanInterval
+	}
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+	full := true
+	for {
+		var err error
+		if full {
+			err = ls.SyncNow(ctx)
+		} else {
+			err = ls.syncLocalChanges(ctx)
+		}
+		if err != nil {
+			ls.fs.log.CWarningf(ctx, "Couldn't sync %s with %s: %+v",
+				ls.local, ls.status.Remote, err)
+		}
+		select {
+		case <-ls.changedCh:
+			full = true
+		case <-ls.localChangedCh:
+			// Wait for the rest of a burst of changes.
+			select {
+			case <-time.After(localSyncWatchCoalesceWindow):
+			case <-ctx.Done():
+				return
+			}
+			full = err != nil
+		case <-ticker.C:
+			full = true
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops syncing, and waits for any pass in progress to end.
+func (ls *LocalSync) Stop(ctx context.Context) error {
+	ls.stopOnce.Do(func() {
+		ls.fs.localSyncsLock.Lock()
+		defer ls.fs.localSyncsLock.Unlock()
+		delete(ls.fs.localSyncs, ls)
+		close(ls.shutdownCh)
+	})
+	select {
+	case <-ls.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ls.passLock.Lock()
+	defer ls.passLock.Unlock()
+	if ls.dir == nil {
+		return nil
+	}
+	return ls.fs.UnregisterFromChanges(
+		[]FolderBranch{ls.dir.GetFolderBranch()}, ls)
+}
+
+// stopLocalSyncs stops all the local syncs.
+func (fs *KBFSOpsStandard) stopLocalSyncs(ctx context.Context) error {
+	fs.localSyncsLock.Lock()
+	syncs := make([]*LocalSync, 0, len(fs.localSyncs))
+	for ls := range fs.localSyncs {
+		syncs = append(syncs, ls)
+	}
+	fs.localSyncsLock.Unlock()
+	for _, ls := range syncs {
+		err := ls.Stop(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncNow runs a sync pass, and waits for it to finish.
+func (ls *LocalSync) SyncNow(ctx context.Context) error {
+	return ls.sync(ctx, nil)
+}
+
+// syncLocalChanges runs a sync pass over only the local directories
+// that were seen changing since the last pass.
+func (ls *LocalSync) syncLocalChanges(ctx context.Context) error {
+	return ls.sync(ctx, ls.takeDirty())
+}
+
+// sync runs a sync pass over the local directories in dirty, and
+// everything under them, or over everything if dirty is nil.
+func (ls *LocalSync) sync(ctx context.Context, dirty map[string]bool) (
+	err error) {
+	ls.passLock.Lock()
+	defer ls.passLock.Unlock()
+	defer func() { ls.setResult(err) }()
+	if dirty == nil {
+		// Everything is about to be synced.
+		ls.takeDirty()
+	} else if len(dirty) == 0 {
+		return nil
+	}
+	ctx, done, err := ls.fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	if ls.dir == nil {
+		dir, err := ls.fs.lookupEndpointDir(ctx, ls.remote, true)
+		if err != nil {
+			return err
+		}
+		// Watch the remote directory before syncing it, so that no
+		// change is missed.
+		err = ls.fs.RegisterForChangesWithFilter(
+			[]FolderBranch{dir.GetFolderBranch()}, ls, ObserverFilter{
+				Root:           dir,
+				Recursive:      true,
+				CoalesceWindow: subtreeMirrorCoalesceWindow,
+			})
+		if err != nil {
+			return err
+		}
+		ls.dir = dir
+	}
+	ls.tlfExclude = ls.fs.config.TlfExcludePatterns(
+		ls.dir.GetFolderBranch().Tlf)
+
+	ls.fs.log.CDebugf(ctx, "Syncing %s with %s", ls.local, ls.status.Remote)
+	err = ls.syncDirs(ctx, dirty)
+	// Record whatever got synced, even if the pass failed partway.
+	if stateErr := ls.writeState(); err == nil {
+		err = stateErr
+	}
+	if err != nil {
+		return err
+	}
+	return ls.fs.getOpsByNode(ctx, ls.dir).SyncAll(
+		ctx, ls.dir.GetFolderBranch())
+}
+
+// syncDirs syncs the directories in dirty, and everything under
+// them, or everything if dirty is nil.  If any of them isn't a
+// directory that was synced before on both sides, everything is
+// synced instead.
+func (ls *LocalSync) syncDirs(ctx context.Context,
+	dirty map[string]bool) error {
+	baseChildren := ls.baseChildren()
+	if dirty == nil || dirty[""] {
+		return ls.syncDir(ctx, ls.dir, ls.local, "", baseChildren)
+	}
+
+	// Skip the directories under other ones in the set.
+	rels := make([]string, 0, len(dirty))
+	for rel := range dirty {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	type syncedDir struct {
+		rel  string
+		node Node
+	}
+	var dirs []syncedDir
+	for _, rel := range rels {
+		if len(dirs) > 0 &&
+			strings.HasPrefix(rel, dirs[len(dirs)-1].rel+"/") {
+			continue
+		}
+		if ls.tlfExclude.Excluded(stdpath.Join(ls.remote.Path, rel), true) {
+			continue
+		}
+		node, ok := ls.lookupSyncedDir(ctx, rel)
+		if !ok {
+			ls.fs.log.CDebugf(ctx, "Syncing everything, since %s changed",
+				ls.fs.config.RedactLogPath(rel))
+			return ls.syncDir(ctx, ls.dir, ls.local, "", baseChildren)
+		}
+		dirs = append(dirs, syncedDir{rel, node})
+	}
+
+	for _, d := range dirs {
+		err := ls.syncDir(ctx, d.node,
+			filepath.Join(ls.local, filepath.FromSlash(d.rel)), d.rel,
+			baseChildren)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupSyncedDir returns the remote node for rel, if rel is a
+// directory on both sides that was synced before.
+func (ls *LocalSync) lookupSyncedDir(ctx context.Context, rel string) (
+	Node, bool) {
+	b, ok := ls.base[rel]
+	if !ok || b.Local.Type != Dir || b.Remote.Type != Dir {
+		return nil, false
+	}
+	fi, err := os.Lstat(filepath.Join(ls.local, filepath.FromSlash(rel)))
+	if err != nil || !fi.IsDir() {
+		return nil, false
+	}
+	node := ls.dir
+	for _, name := range strings.Split(rel, "/") {
+		child, ei, err := ls.fs.getOpsByNode(ctx, node).Lookup(
+			ctx, node, name)
+		if err != nil || ei.Type != Dir {
+			return nil, false
+		}
+		node = child
+	}
+	return node, true
+}
+
+// baseChildren returns the names in the synced state, by the path of
+// their parent directory.
+func (ls *LocalSync) baseChildren() map[string][]string {
+	children := make(map[string][]string)
+	for rel := range ls.base {
+		parent := stdpath.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		children[parent] = append(children[parent], stdpath.Base(rel))
+	}
+	return children
+}
+
+// forget removes rel and everything under it from the synced state.
+func (ls *LocalSync) forget(rel string) {
+	delete(ls.base, rel)
+	prefix := rel + "/"
+	for p := range ls.base {
+		if strings.HasPrefix(p, prefix) {
+			delete(ls.base, p)
+		}
+	}
+}
+
+// syncDir syncs the contents of the remote directory dir with those
+// of the local directory localDir, both at rel.
+func (ls *LocalSync) syncDir(ctx context.Context, dir Node, localDir string,
+	rel string, baseChildren map[string][]string) error {
+	ops := ls.fs.getOpsByNode(ctx, dir)
+	remoteChildren, err := ops.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+
+	remote := make(map[string]*localSyncEntry, len(remoteChildren))
+	for name, ei := range remoteChildren {
+		remote[name] = localSyncEntryFromEntryInfo(ei)
+	}
+	local := make(map[string]*localSyncEntry, len(fis))
+	for _, fi := range fis {
+		e, err := localSyncEntryFromFileInfo(
+			fi, filepath.Join(localDir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			local[fi.Name()] = e
+		}
+	}
+
+	nameSet := make(map[string]bool)
+	for name := range remote {
+		nameSet[name] = true
+	}
+	for name := range local {
+		nameSet[name] = true
+	}
+	for _, name := range baseChildren[rel] {
+		nameSet[name] = true
+	}
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		if ls.ignored(rel, name) {
+			continue
+		}
+		isDir := (remote[name] != nil && remote[name].Type == Dir) ||
+			(local[name] != nil && local[name].Type == Dir)
+		if ls.tlfExclude.match(
+			stdpath.Join(ls.remote.Path, rel, name), isDir) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := ls.syncEntry(ctx, dir, localDir, rel, name,
+			remote[name], local[name], baseChildren)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncEntry syncs name in the remote directory dir with name in the
+// local directory localDir, given their current states r and l.
+func (ls *LocalSync) syncEntry(ctx context.Context, dir Node,
+	localDir string, parentRel string, name string, r, l *localSyncEntry,
+	baseChildren map[string][]string) error {
+	rel := stdpath.Join(parentRel, name)
+	var br, bl *localSyncEntry
+	if b, ok := ls.base[rel]; ok {
+		br, bl = &b.Remote, &b.Local
+	}
+	rChanged := !r.equal(br)
+	lChanged := !l.equal(bl)
+	isDir := func(e *localSyncEntry) bool { return e != nil && e.Type == Dir }
+
+	switch {
+	case r == nil && l == nil:
+		ls.forget(rel)
+		return nil
+	case isDir(br) && isDir(bl) && ((r == nil && isDir(l)) ||
+		(l == nil && isDir(r))):
+		return ls.syncRemovedDir(ctx, dir, localDir, rel, name, r == nil,
+			baseChildren)
+	case isDir(r) && isDir(l):
+		return ls.syncBothDirs(ctx, dir, localDir, rel, name, baseChildren)
+	case !rChanged && !lChanged:
+		return nil
+	case !rChanged, r == nil:
+		return ls.push(ctx, dir, localDir, rel, name, r, l)
+	case !lChanged, l == nil:
+		return ls.pull(ctx, dir, localDir, rel, name, r, l)
+	case r.equal(l):
+		// Both sides made the same change.
+		return ls.recordSynced(ctx, dir, localDir, rel, name)
+	default:
+		return ls.resolveConflict(ctx, dir, localDir, rel, name, r, l)
+	}
+}
+
+// syncBothDirs syncs a directory that exists on both sides.
+func (ls *LocalSync) syncBothDirs(ctx context.Context, dir Node,
+	localDir string, rel string, name string,
+	baseChildren map[string][]string) error {
+	node, _, err := ls.fs.getOpsByNode(ctx, dir).Lookup(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	ls.base[rel] = localSyncBase{
+		Local: localSyncEntry{Type: Dir}, Remote: localSyncEntry{Type: Dir}}
+	return ls.syncDir(
+		ctx, node, filepath.Join(localDir, name), rel, baseChildren)
+}
+
+// syncRemovedDir syncs a directory that was removed on one side
+// since the last pass.  Everything under it that didn't change on
+// the other side is removed, and if nothing is left, the directory
+// itself is removed too.
+func (ls *LocalSync) syncRemovedDir(ctx context.Context, dir Node,
+	localDir string, rel string, name string, remoteRemoved bool,
+	baseChildren map[string][]string) error {
+	ops := ls.fs.getOpsByNode(ctx, dir)
+	localPath := filepath.Join(localDir, name)
+	if remoteRemoved {
+		_, _, err := ops.CreateDir(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := os.Mkdir(localPath, 0700)
+		if err != nil {
+			return err
+		}
+	}
+	err := ls.syncBothDirs(ctx, dir, localDir, rel, name, baseChildren)
+	if err != nil {
+		return err
+	}
+
+	node, _, err := ops.Lookup(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	children, err := ops.GetDirChildren(ctx, node)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return nil
+	}
+	ls.fs.log.CDebugf(ctx, "Removing %s from both sides",
+		ls.fs.config.RedactLogPath(rel))
+	err = ops.RemoveDir(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(localPath)
+	if err != nil {
+		return err
+	}
+	ls.forget(rel)
+	return nil
+}
+
+// push makes the remote entry a copy of the local one.
+func (ls *LocalSync) push(ctx context.Context, dir Node, localDir string,
+	rel string, name string, r, l *localSyncEntry) error {
+	ls.fs.log.CDebugf(ctx, "Pushing %s", ls.fs.config.RedactLogPath(rel))
+	ls.countPushed()
+	ops := ls.fs.getOpsByNode(ctx, dir)
+	localPath := filepath.Join(localDir, name)
+	if l == nil {
+		ls.forget(rel)
+		if r == nil {
+			return nil
+		}
+		return ops.RemoveAll(ctx, dir, name)
+	}
+
+	// Replace the remote entry, unless it's a file that can be
+	// overwritten in place.
+	var node Node
+	if r != nil {
+		if (r.Type == File || r.Type == Exec) &&
+			(l.Type == File || l.Type == Exec) {
+			var err error
+			node, _, err = ops.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := ops.RemoveAll(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	ls.forget(rel)
+
+	switch l.Type {
+	case Dir:
+		_, _, err := ops.CreateDir(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+		return ls.syncBothDirs(
+			ctx, dir, localDir, rel, name, map[string][]string{})
+	case Sym:
+		_, err := ops.CreateLink(ctx, dir, name, l.SymPath)
+		if err != nil {
+			return err
+		}
+	default:
+		var err error
+		if node == nil {
+			node, _, err = ops.CreateFile(
+				ctx, dir, name, l.Type == Exec, WithExcl)
+		} else {
+			err = ops.SetEx(ctx, node, l.Type == Exec)
+		}
+		if err != nil {
+			return err
+		}
+		err = ls.copyFromLocalFile(ctx, localPath, node)
+		if err != nil {
+			return err
+		}
+		mtime := time.Unix(0, l.Mtime)
+		err = ops.SetMtime(ctx, node, &mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return ls.recordSynced(ctx, dir, localDir, rel, name)
+}
+
+// copyFromLocalFile overwrites the contents of node with those of
+// the local file at localPath.
+func (ls *LocalSync) copyFromLocalFile(
+	ctx context.Context, localPath string, node Node) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ops := ls.fs.getOpsByNode(ctx, node)
+	buf := make([]byte, crossTlfRenameChunkSize)
+	var off int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			writeErr := ops.Write(ctx, node, buf[:n], off)
+			if writeErr != nil {
+				return writeErr
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return ops.Truncate(ctx, node, uint64(off))
+}
+
+// pull makes the local entry a copy of the remote one.
+func (ls *LocalSync) pull(ctx context.Context, dir Node, localDir string,
+	rel string, name string, r, l *localSyncEntry) error {
+	ls.fs.log.CDebugf(ctx, "Pulling %s", ls.fs.config.RedactLogPath(rel))
+	ls.countPulled()
+	localPath := filepath.Join(localDir, name)
+	ls.forget(rel)
+	if r == nil {
+		return os.RemoveAll(localPath)
+	}
+
+	// A file replaces the local entry with a rename, so it only
+	// needs to be removed first if it's a directory.
+	if l != nil && (l.Type == Dir || r.Type == Dir || r.Type == Sym) {
+		err := os.RemoveAll(localPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	ops := ls.fs.getOpsByNode(ctx, dir)
+	switch r.Type {
+	case Dir:
+		err := os.Mkdir(localPath, 0700)
+		if err != nil {
+			return err
+		}
+		return ls.syncBothDirs(
+			ctx, dir, localDir, rel, name, map[string][]string{})
+	case Sym:
+		err := os.Symlink(r.SymPath, localPath)
+		if err != nil {
+			return err
+		}
+	default:
+		node, ei, err := ops.Lookup(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+		err = ls.fs.copyToLocalFile(ctx, node, ei, localPath)
+		if err != nil {
+			return err
+		}
+	}
+	return ls.recordSynced(ctx, dir, localDir, rel, name)
+}
+
+// resolveConflict handles an entry that changed on both sides: the
+// local version is moved aside to a conflict name and pushed under
+// that name, and then the remote version is pulled.
+func (ls *LocalSync) resolveConflict(ctx context.Context, dir Node,
+	localDir string, rel string, name string, r, l *localSyncEntry) error {
+	session, err := ls.fs.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	// The renamer only needs to know who wrote the conflicting
+	// version.
+	op := &createOp{NewName: name, Type: l.Type}
+	op.setWriterInfo(writerInfo{
+		uid: session.UID,
+		key: session.VerifyingKey,
+	})
+	conflictName, err := ls.fs.config.ConflictRenamer().ConflictRename(
+		ctx, op, name)
+	if err != nil {
+		return err
+	}
+
+	ls.fs.log.CDebugf(ctx, "Both versions of %s changed; moving the local "+
+		"one to %s", ls.fs.config.RedactLogPath(rel),
+		ls.fs.config.RedactLogPath(conflictName))
+	ls.countConflict()
+	err = os.Rename(
+		filepath.Join(localDir, name), filepath.Join(localDir, conflictName))
+	if err != nil {
+		return err
+	}
+	err = ls.push(ctx, dir, localDir, stdpath.Join(stdpath.Dir(rel),
+		conflictName), conflictName, nil, l)
+	if err != nil {
+		return err
+	}
+	return ls.pull(ctx, dir, localDir, rel, name, r, nil)
+}
+
+// recordSynced records the current state of both sides of name as
+// synced.
+func (ls *LocalSync) recordSynced(ctx context.Context, dir Node,
+	localDir string, rel string, name string) error {
+	_, ei, err := ls.fs.getOpsByNode(ctx, dir).Lookup(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	localPath := filepath.Join(localDir, name)
+	fi, err := os.Lstat(localPath)
+	if err != nil {
+		return err
+	}
+	l, err := localSyncEntryFromFileInfo(fi, localPath)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return errors.Errorf("%s can't be synced", localPath)
+	}
+	ls.base[rel] = localSyncBase{
+		Local:  *l,
+		Remote: *localSyncEntryFromEntryInfo(ei),
+	}
+	return nil
+}
+
+// LocalChange implements the Observer interface for LocalSync.  Only
+// synced changes are synced to the local directory.
+func (ls *LocalSync) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+// BatchChanges implements the Observer interface for LocalSync.
+func (ls *LocalSync) BatchChanges(
+	_ context.Context, _ []NodeChange, _ []NodeID) {
+	select {
+	case ls.changedCh <- struct{}{}:
+	default:
+		// A pass is already pending, and will pick this up.
+	}
+}
+
+// TlfHandleChange implements the Observer interface for LocalSync.
+func (ls *LocalSync) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
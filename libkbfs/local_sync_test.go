@@ -0,0 +1,253 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsLocalSync(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	writeRemote := func(parent Node, name string, data string) {
+		node, _, err := kbfsOps.Lookup(ctx, parent, name)
+		if _, ok := err.(NoSuchNameError); ok {
+			node, _, err = kbfsOps.CreateFile(ctx, parent, name, false, NoExcl)
+		}
+		require.NoError(t, err)
+		err = kbfsOps.Truncate(ctx, node, 0)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, node, []byte(data), 0)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+	}
+	checkRemote := func(parent Node, name string, expected string) {
+		node, _, err := kbfsOps.Lookup(ctx, parent, name)
+		require.NoError(t, err)
+		buf := make([]byte, len(expected)+1)
+		n, err := kbfsOps.Read(ctx, node, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(buf[:n]))
+	}
+	remoteNames := func(dir Node) []string {
+		children, err := kbfsOps.GetDirChildren(ctx, dir)
+		require.NoError(t, err)
+		var names []string
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	localDir, err := ioutil.TempDir("", "local_sync_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+	writeLocal := func(path string, data string) {
+		err := ioutil.WriteFile(
+			filepath.Join(localDir, path), []byte(data), 0600)
+		require.NoError(t, err)
+	}
+	checkLocal := func(path string, expected string) {
+		buf, err := ioutil.ReadFile(filepath.Join(localDir, path))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(buf))
+	}
+	localNames := func(path string) []string {
+		fis, err := ioutil.ReadDir(filepath.Join(localDir, path))
+		require.NoError(t, err)
+		var names []string
+		for _, fi := range fis {
+			if !localSyncIgnored(fi.Name(), path == "") {
+				names = append(names, fi.Name())
+			}
+		}
+		return names
+	}
+
+	t.Log("Start with a and d/b in the TLF, and c locally")
+	sNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "s")
+	require.NoError(t, err)
+	writeRemote(sNode, "a", "remote a")
+	dNode, _, err := kbfsOps.CreateDir(ctx, sNode, "d")
+	require.NoError(t, err)
+	writeRemote(dNode, "b", "remote b")
+	writeLocal("c", "local c")
+
+	ls, err := kbfsOps.StartLocalSync(LocalSyncConfig{
+		Remote:       "private/u1/s",
+		Local:        localDir,
+		PollInterval: time.Hour,
+		// Only sync when the test asks for it.
+		DisableWatch: true,
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := ls.Stop(ctx)
+		require.NoError(t, err)
+	}()
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c", "d"}, localNames(""))
+	require.Equal(t, []string{"a", "c", "d"}, remoteNames(sNode))
+	checkLocal("a", "remote a")
+	checkLocal("d/b", "remote b")
+	checkRemote(sNode, "c", "local c")
+
+	t.Log("Nothing is copied when nothing changed")
+	before := ls.Status()
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	after := ls.Status()
+	require.Equal(t, before.Pushed, after.Pushed)
+	require.Equal(t, before.Pulled, after.Pulled)
+
+	t.Log("Changes on either side are synced to the other")
+	writeLocal("c", "local c, again")
+	err = os.Remove(filepath.Join(localDir, "d", "b"))
+	require.NoError(t, err)
+	writeRemote(sNode, "a", "remote a, again")
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	checkRemote(sNode, "c", "local c, again")
+	require.Len(t, remoteNames(dNode), 0)
+	checkLocal("a", "remote a, again")
+
+	t.Log("Changes on both sides keep both versions")
+	writeLocal("a", "local a")
+	writeRemote(sNode, "a", "remote a, third")
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, ls.Status().Conflicts)
+	checkLocal("a", "remote a, third")
+	checkRemote(sNode, "a", "remote a, third")
+	names := localNames("")
+	require.Len(t, names, 4)
+	require.Equal(t, names, remoteNames(sNode))
+	var conflictName string
+	for _, name := range names {
+		if strings.HasPrefix(name, "a.conflicted") {
+			conflictName = name
+		}
+	}
+	require.NotEqual(t, "", conflictName)
+	checkLocal(conflictName, "local a")
+	checkRemote(sNode, conflictName, "local a")
+
+	t.Log("Removing a directory keeps what changed on the other side")
+	writeLocal("d/e", "local e")
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(localDir, "d"))
+	require.NoError(t, err)
+	writeRemote(dNode, "f", "remote f")
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f"}, remoteNames(dNode))
+	require.Equal(t, []string{"f"}, localNames("d"))
+	checkLocal("d/f", "remote f")
+
+	t.Log("Removing an unchanged directory removes it from both sides")
+	err = os.RemoveAll(filepath.Join(localDir, "d"))
+	require.NoError(t, err)
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, sNode, "d")
+	require.IsType(t, NoSuchNameError{}, err)
+	require.Equal(t, "", ls.Status().LastError)
+}
+
+func TestKBFSOpsLocalSyncWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Local directories are only watched on Linux")
+	}
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	localDir, err := ioutil.TempDir("", "local_sync_watch_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+
+	ls, err := kbfsOps.StartLocalSync(LocalSyncConfig{
+		Remote:       "private/u1",
+		Local:        localDir,
+		PollInterval: time.Hour,
+		Exclude:      []string{"*.tmp", "d/skip"},
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := ls.Stop(ctx)
+		require.NoError(t, err)
+	}()
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	require.True(t, ls.Status().Watching)
+
+	waitForRemote := func(parent Node, name string) Node {
+		for {
+			passCh := ls.nextPassForTest()
+			node, _, err := kbfsOps.Lookup(ctx, parent, name)
+			if err == nil {
+				return node
+			}
+			require.IsType(t, NoSuchNameError{}, err)
+			select {
+			case <-passCh:
+			case <-ctx.Done():
+				t.Fatalf("%s wasn't synced: %v", name, ls.Status())
+			}
+		}
+	}
+
+	t.Log("Local changes are synced without polling")
+	err = ioutil.WriteFile(filepath.Join(localDir, "a"), []byte("a"), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(localDir, "b.tmp"), []byte("b"), 0600)
+	require.NoError(t, err)
+	waitForRemote(rootNode, "a")
+
+	t.Log("New local directories are watched too")
+	err = os.MkdirAll(filepath.Join(localDir, "d", "skip"), 0700)
+	require.NoError(t, err)
+	dNode := waitForRemote(rootNode, "d")
+	err = ioutil.WriteFile(
+		filepath.Join(localDir, "d", "e"), []byte("e"), 0600)
+	require.NoError(t, err)
+	waitForRemote(dNode, "e")
+
+	t.Log("Excluded entries stay on their own side")
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "c.tmp", false, NoExcl)
+	require.NoError(t, err)
+	err = ls.SyncNow(ctx)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "b.tmp")
+	require.IsType(t, NoSuchNameError{}, err)
+	_, _, err = kbfsOps.Lookup(ctx, dNode, "skip")
+	require.IsType(t, NoSuchNameError{}, err)
+	_, err = os.Stat(filepath.Join(localDir, "c.tmp"))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, "", ls.Status().LastError)
+}
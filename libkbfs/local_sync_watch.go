@@ -0,0 +1,31 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "errors"
+
+// errLocalWatchUnsupported is returned by newLocalWatcher on
+// platforms where local directories can't be watched for changes.
+var errLocalWatchUnsupported = errors.New(
+	"Watching local directories is not supported on this platform")
+
+// localWatcher watches a local directory tree for changes, using the
+// file-watching facilities of the OS.
+type localWatcher interface {
+	// Close stops watching.  No calls to the changed callback are
+	// made once it returns.
+	Close() error
+}
+
+// localWatchIgnoreFunc returns whether the change to name, in the
+// directory at the slash-separated path rel relative to the watched
+// root ("" for the root itself), should not be reported.
+type localWatchIgnoreFunc func(rel string, name string) bool
+
+// localWatchChangedFunc is called with the path of each directory,
+// relative to the watched root, whose entries changed.  It's called
+// with "" if any change may have been missed, in which case the whole
+// tree should be rescanned.
+type localWatchChangedFunc func(rel string)
@@ -0,0 +1,212 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWatchMask is the set of events watched for in each directory.
+const inotifyWatchMask = unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_MOVED_FROM |
+	unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR
+
+// inotifyWatcher watches a directory tree with inotify, which needs
+// a watch for each directory in it.
+type inotifyWatcher struct {
+	root    string
+	ignore  localWatchIgnoreFunc
+	changed localWatchChangedFunc
+	fd      int
+	f       *os.File
+	doneCh  chan struct{}
+
+	lock sync.Mutex
+	// watches maps each watch descriptor to the path of its
+	// directory, relative to root.
+	watches map[int]string
+	closed  bool
+}
+
+// newLocalWatcher starts watching the directory tree at root.
+func newLocalWatcher(root string, ignore localWatchIgnoreFunc,
+	changed localWatchChangedFunc) (localWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		root:    root,
+		ignore:  ignore,
+		changed: changed,
+		fd:      fd,
+		// With a non-blocking descriptor, reads go through the
+		// runtime poller, so closing the file ends a pending read.
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		doneCh:  make(chan struct{}),
+		watches: make(map[int]string),
+	}
+	err = w.addTree("")
+	if err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	go w.loop()
+	return w, nil
+}
+
+// addTree watches the directory at rel and all the directories
+// under it.
+func (w *inotifyWatcher) addTree(rel string) error {
+	return filepath.Walk(filepath.Join(w.root, filepath.FromSlash(rel)),
+		func(path string, fi os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				// Removed since it was listed; the removal is
+				// reported by its parent.
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			relPath, err := filepath.Rel(w.root, path)
+			if err != nil {
+				return err
+			}
+			relPath = filepath.ToSlash(relPath)
+			if relPath == "." {
+				relPath = ""
+			} else {
+				parent := stdpath.Dir(relPath)
+				if parent == "." {
+					parent = ""
+				}
+				if w.ignore(parent, stdpath.Base(relPath)) {
+					return filepath.SkipDir
+				}
+			}
+			w.lock.Lock()
+			defer w.lock.Unlock()
+			if w.closed {
+				return filepath.SkipDir
+			}
+			wd, err := unix.InotifyAddWatch(w.fd, path, inotifyWatchMask)
+			if err == unix.ENOENT || err == unix.ENOTDIR {
+				return nil
+			} else if err != nil {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			w.watches[wd] = relPath
+			return nil
+		})
+}
+
+// removeTree forgets the watches for rel and the directories under
+// it, after it was moved away.
+func (w *inotifyWatcher) removeTree(rel string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	prefix := rel + "/"
+	for wd, p := range w.watches {
+		if p == rel || strings.HasPrefix(p, prefix) {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.doneCh)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			// Closed, or the descriptor is unusable.
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			w.handleEvent(event, name)
+			offset = nameEnd
+		}
+	}
+}
+
+func (w *inotifyWatcher) handleEvent(event *unix.InotifyEvent, name string) {
+	if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+		w.changed("")
+		return
+	}
+
+	w.lock.Lock()
+	rel, ok := w.watches[int(event.Wd)]
+	if event.Mask&unix.IN_IGNORED != 0 {
+		delete(w.watches, int(event.Wd))
+	}
+	w.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	if name == "" {
+		// The directory itself was removed or moved, which its parent
+		// reports, unless it's the root.
+		const selfMask = unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+		if rel == "" && event.Mask&selfMask != 0 {
+			w.changed("")
+		}
+		return
+	}
+	if w.ignore(rel, name) {
+		return
+	}
+
+	if event.Mask&unix.IN_ISDIR != 0 {
+		childRel := stdpath.Join(rel, name)
+		if event.Mask&unix.IN_MOVED_FROM != 0 {
+			w.removeTree(childRel)
+		}
+		if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			err := w.addTree(childRel)
+			if err != nil {
+				// Changes under it would be missed.
+				w.changed("")
+				return
+			}
+		}
+	}
+	w.changed(rel)
+}
+
+// Close implements the localWatcher interface for inotifyWatcher.
+func (w *inotifyWatcher) Close() error {
+	w.lock.Lock()
+	w.closed = true
+	err := w.f.Close()
+	w.lock.Unlock()
+	<-w.doneCh
+	return err
+}
@@ -0,0 +1,14 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package libkbfs
+
+// newLocalWatcher always fails on this platform, so local
+// directories are polled for changes instead.
+func newLocalWatcher(_ string, _ localWatchIgnoreFunc,
+	_ localWatchChangedFunc) (localWatcher, error) {
+	return nil, errLocalWatchUnsupported
+}
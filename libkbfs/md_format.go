@@ -0,0 +1,64 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "github.com/keybase/kbfs/tlf"
+
+// MDFormatVer is the version of the format of the private metadata
+// of a TLF, and of the data it points to.  Unlike kbfsmd.MetadataVer,
+// which only covers the layout of the MD object itself, a new
+// MDFormatVer is needed for any change that older clients might
+// misread or clobber, even if they can still decode the MD.
+type MDFormatVer int
+
+const (
+	// FirstMDFormatVer is the format of every TLF written before
+	// format markers existed, which all clients can read and write.
+	FirstMDFormatVer MDFormatVer = 0
+	// CurrentMDFormatVer is the newest format this client
+	// understands.
+	CurrentMDFormatVer MDFormatVer = 1
+)
+
+// checkReadable returns an error if this client is too old to read
+// the TLF this PrivateMetadata belongs to.
+func (pmd *PrivateMetadata) checkReadable(tlfID tlf.ID) error {
+	if pmd.MinReaderFormat > CurrentMDFormatVer {
+		return NewMDFormatVersionError{tlfID, pmd.MinReaderFormat}
+	}
+	return nil
+}
+
+// checkWritable returns an error if this client is too old to
+// write to the TLF this PrivateMetadata belongs to, though it may
+// still be able to read it.
+func (pmd *PrivateMetadata) checkWritable(tlfID tlf.ID) error {
+	if pmd.MinWriterFormat > CurrentMDFormatVer {
+		return MDFormatReadOnlyError{tlfID, pmd.MinWriterFormat}
+	}
+	return nil
+}
+
+// requireMDFormat raises the minimum format versions that clients
+// need in order to read and write the TLF, starting with this
+// revision.  The markers are carried over to every successor, and
+// are never lowered.  Any client writing data that older clients
+// can't handle must call this in the same MD update.
+func (md *RootMetadata) requireMDFormat(
+	minReader, minWriter MDFormatVer) {
+	if minReader > CurrentMDFormatVer || minWriter > CurrentMDFormatVer {
+		panic("Requiring an MD format this client doesn't understand")
+	}
+	// A client that can't read a TLF can't write to it either.
+	if minWriter < minReader {
+		minWriter = minReader
+	}
+	if minReader > md.data.MinReaderFormat {
+		md.data.MinReaderFormat = minReader
+	}
+	if minWriter > md.data.MinWriterFormat {
+		md.data.MinWriterFormat = minWriter
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateMetadataFormatMarkers(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	tlfID := tlf.FakeID(1, tlf.Private)
+
+	t.Log("MDs without markers encode just like before")
+	var pmd PrivateMetadata
+	buf, err := codec.Encode(pmd)
+	require.NoError(t, err)
+	type oldPrivateMetadata struct {
+		Dir            DirEntry
+		TLFPrivateKey  kbfscrypto.TLFPrivateKey
+		Changes        BlockChanges
+		LastGCRevision kbfsmd.Revision `codec:"lgc"`
+	}
+	oldBuf, err := codec.Encode(oldPrivateMetadata{})
+	require.NoError(t, err)
+	require.Equal(t, oldBuf, buf)
+	require.NoError(t, pmd.checkReadable(tlfID))
+	require.NoError(t, pmd.checkWritable(tlfID))
+
+	t.Log("Newer writer formats only block writes")
+	pmd.MinWriterFormat = CurrentMDFormatVer + 1
+	buf, err = codec.Encode(pmd)
+	require.NoError(t, err)
+	var decoded PrivateMetadata
+	err = codec.Decode(buf, &decoded)
+	require.NoError(t, err)
+	require.NoError(t, decoded.checkReadable(tlfID))
+	require.Equal(t,
+		MDFormatReadOnlyError{tlfID, CurrentMDFormatVer + 1},
+		decoded.checkWritable(tlfID))
+
+	t.Log("Newer reader formats block everything")
+	decoded.MinReaderFormat = CurrentMDFormatVer + 1
+	require.Equal(t,
+		NewMDFormatVersionError{tlfID, CurrentMDFormatVer + 1},
+		decoded.checkReadable(tlfID))
+}
+
+func TestRequireMDFormat(t *testing.T) {
+	var md RootMetadata
+	md.requireMDFormat(FirstMDFormatVer, CurrentMDFormatVer)
+	require.Equal(t, FirstMDFormatVer, md.data.MinReaderFormat)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinWriterFormat)
+
+	t.Log("Markers are never lowered, and writers need what readers need")
+	md.data.MinWriterFormat = FirstMDFormatVer
+	md.requireMDFormat(CurrentMDFormatVer, FirstMDFormatVer)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinReaderFormat)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinWriterFormat)
+	md.requireMDFormat(FirstMDFormatVer, FirstMDFormatVer)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinReaderFormat)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinWriterFormat)
+
+	require.Panics(t, func() {
+		md.requireMDFormat(FirstMDFormatVer, CurrentMDFormatVer+1)
+	})
+}
+
+func TestMDFormatReadOnlyFolder(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Pretend a newer client marked the head as needing a newer " +
+		"writer")
+	ops := getOps(config, fb.Tlf)
+	lState := makeFBOLockState()
+	func() {
+		ops.headLock.Lock(lState)
+		defer ops.headLock.Unlock(lState)
+		ops.head.data.MinWriterFormat = CurrentMDFormatVer + 1
+	}()
+
+	t.Log("Reads still work, but writes fail")
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.Equal(t,
+		MDFormatReadOnlyError{fb.Tlf, CurrentMDFormatVer + 1},
+		errors.Cause(err))
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+}
@@ -239,9 +239,15 @@ func (md *MDOpsStandard) verifyKey(
 		irmd.Revision(), irmd.TlfID(), info.Time, info.MerkleRoot.Seqno)
 	ctx = context.WithValue(ctx, ctxMDOpsSkipKeyVerification, struct{}{})
 
-	kbfsRoot, merkleNodes, rootSeqno, err :=
-		md.config.MDServer().FindNextMD(ctx, rmds.MD.TlfID(),
-			info.MerkleRoot.Seqno)
+	var kbfsRoot *kbfsmd.MerkleRoot
+	var merkleNodes [][]byte
+	var rootSeqno keybase1.Seqno
+	err = md.retry(ctx, "FindNextMD", func() (err error) {
+		kbfsRoot, merkleNodes, rootSeqno, err =
+			md.config.MDServer().FindNextMD(ctx, rmds.MD.TlfID(),
+				info.MerkleRoot.Seqno)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
@@ -726,8 +732,12 @@ func (c constIDGetter) ValidateLatestHandleNotFinal(
 func (md *MDOpsStandard) getForTLF(ctx context.Context, id tlf.ID,
 	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus, lockBeforeGet *keybase1.LockID) (
 	ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetForTLF(
-		ctx, id, bid, mStatus, lockBeforeGet)
+	var rmds *RootMetadataSigned
+	err := md.retry(ctx, "GetForTLF", func() (err error) {
+		rmds, err = md.config.MDServer().GetForTLF(
+			ctx, id, bid, mStatus, lockBeforeGet)
+		return err
+	})
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
@@ -879,8 +889,12 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 func (md *MDOpsStandard) getRange(ctx context.Context, id tlf.ID,
 	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus, start, stop kbfsmd.Revision,
 	lockBeforeGet *keybase1.LockID) ([]ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetRange(
-		ctx, id, bid, mStatus, start, stop, lockBeforeGet)
+	var rmds []*RootMetadataSigned
+	err := md.retry(ctx, "GetRange", func() (err error) {
+		rmds, err = md.config.MDServer().GetRange(
+			ctx, id, bid, mStatus, start, stop, lockBeforeGet)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -909,6 +923,10 @@ func (md *MDOpsStandard) GetUnmergedRange(ctx context.Context, id tlf.ID,
 func (md *MDOpsStandard) put(ctx context.Context, rmd *RootMetadata,
 	verifyingKey kbfscrypto.VerifyingKey, lockContext *keybase1.LockContext,
 	priority keybase1.MDPriority) (ImmutableRootMetadata, error) {
+	if mode := md.config.NetworkMode(); !mode.uploadsEnabled() {
+		return ImmutableRootMetadata{}, NetworkModeError{mode, "MD put"}
+	}
+
 	session, err := md.config.KBPKI().GetCurrentSession(ctx)
 	if err != nil {
 		return ImmutableRootMetadata{}, err
@@ -936,7 +954,10 @@ func (md *MDOpsStandard) put(ctx context.Context, rmd *RootMetadata,
 		return ImmutableRootMetadata{}, err
 	}
 
-	err = md.config.MDServer().Put(ctx, rmds, rmd.extra, lockContext, priority)
+	err = md.retry(ctx, "Put", func() error {
+		return md.config.MDServer().Put(
+			ctx, rmds, rmd.extra, lockContext, priority)
+	})
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
@@ -988,7 +1009,9 @@ func (md *MDOpsStandard) PutUnmerged(
 // PruneBranch implements the MDOps interface for MDOpsStandard.
 func (md *MDOpsStandard) PruneBranch(
 	ctx context.Context, id tlf.ID, bid kbfsmd.BranchID) error {
-	return md.config.MDServer().PruneBranch(ctx, id, bid)
+	return md.retry(ctx, "PruneBranch", func() error {
+		return md.config.MDServer().PruneBranch(ctx, id, bid)
+	})
 }
 
 // ResolveBranch implements the MDOps interface for MDOpsStandard.
@@ -1016,7 +1039,20 @@ func (md *MDOpsStandard) ResolveBranch(
 func (md *MDOpsStandard) GetLatestHandleForTLF(ctx context.Context, id tlf.ID) (
 	tlf.Handle, error) {
 	// TODO: Verify this mapping using a Merkle tree.
-	return md.config.MDServer().GetLatestHandleForTLF(ctx, id)
+	var h tlf.Handle
+	err := md.retry(ctx, "GetLatestHandleForTLF", func() (err error) {
+		h, err = md.config.MDServer().GetLatestHandleForTLF(ctx, id)
+		return err
+	})
+	return h, err
+}
+
+// retry runs fn, a call to the MD server made on behalf of the named
+// MDOps method, under the config's retry policy.
+func (md *MDOpsStandard) retry(
+	ctx context.Context, method string, fn func() error) error {
+	return runWithRetry(ctx, md.config.RetryPolicy(),
+		md.config.MetricsRegistry(), md.log, "MDOps."+method, fn)
 }
 
 // ValidateLatestHandleNotFinal implements the MDOps interface for
@@ -583,6 +583,11 @@ func decryptMDPrivateData(ctx context.Context, codec kbfscodec.Codec,
 		}
 	}
 
+	// Don't go any further with data we might misread.
+	if err := pmd.checkReadable(rmdToDecrypt.TlfID()); err != nil {
+		return PrivateMetadata{}, err
+	}
+
 	// Re-embed the block changes if it's needed.
 	err := reembedBlockChanges(
 		ctx, codec, bcache, bops, mode, rmdWithKeys.TlfID(),
@@ -148,11 +148,20 @@ func (md *MDServerRemote) initNewConnection() {
 		md.conn.Shutdown()
 	}
 
-	md.conn = rpc.NewTLSConnection(md.mdSrvRemote, kbfscrypto.GetRootCerts(
-		md.mdSrvRemote.Peek(), libkb.GetBundledCAsFromHost),
-		kbfsmd.ServerErrorUnwrapper{}, md, md.rpcLogFactory,
-		logger.LogOutputWithDepthAdder{Logger: md.config.MakeLogger("")},
-		md.connOpts)
+	rootCerts := kbfscrypto.GetRootCerts(
+		md.mdSrvRemote.Peek(), libkb.GetBundledCAsFromHost)
+	logOutput := logger.LogOutputWithDepthAdder{
+		Logger: md.config.MakeLogger("")}
+	if dialer := md.config.Dialer(); dialer != nil {
+		md.conn = rpc.NewConnectionWithTransport(
+			md, newDialerTransport(dialer, md.mdSrvRemote, rootCerts,
+				md.rpcLogFactory, md.connOpts.WrapErrorFunc),
+			kbfsmd.ServerErrorUnwrapper{}, logOutput, md.connOpts)
+	} else {
+		md.conn = rpc.NewTLSConnection(md.mdSrvRemote, rootCerts,
+			kbfsmd.ServerErrorUnwrapper{}, md, md.rpcLogFactory,
+			logOutput, md.connOpts)
+	}
 	md.client = keybase1.MetadataClient{Cli: md.conn.GetClient()}
 }
 
@@ -207,6 +216,13 @@ func (md *MDServerRemote) OnConnect(ctx context.Context,
 		}
 	}
 
+	// TODO: negotiate capabilities (supported batch ops, push
+	// notifications, compression, max sizes) with the mdserver and
+	// bserver here, and store them with the connection.  This needs
+	// a new RPC in the keybase1 metadata and block protocols first;
+	// until then the client assumes the servers support everything
+	// in the protocol version it was built against.
+
 	// reset auth -- using md.client here would cause problematic recursion.
 	c := keybase1.MetadataClient{Cli: client}
 	pingIntervalSeconds, err := md.resetAuth(ctx, c)
@@ -444,9 +460,17 @@ func (md *MDServerRemote) ShouldRetryOnConnect(err error) bool {
 
 // CheckReachability implements the MDServer interface.
 func (md *MDServerRemote) CheckReachability(ctx context.Context) {
-	conn, err := net.DialTimeout("tcp",
-		// The peeked address is the top choice in most cases.
-		md.mdSrvRemote.Peek(), MdServerPingTimeout)
+	// The peeked address is the top choice in most cases.
+	addr := md.mdSrvRemote.Peek()
+	var conn net.Conn
+	var err error
+	if dialer := md.config.Dialer(); dialer != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, MdServerPingTimeout)
+		defer cancel()
+		conn, err = dialer.Dial(dialCtx, "tcp", addr)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, MdServerPingTimeout)
+	}
 	if err != nil {
 		if md.getIsAuthenticated() {
 			md.log.CInfof(ctx, "MDServerRemote: CheckReachability(): "+
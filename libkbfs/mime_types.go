@@ -0,0 +1,159 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"net/http"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/net/context"
+)
+
+// mimeTypeSniffLen is the most bytes http.DetectContentType looks
+// at, so only the first block of a file ever needs to be read.
+const mimeTypeSniffLen = 512
+
+// mimeTypeCacheSize is the number of sniffed MIME types to remember.
+const mimeTypeCacheSize = 10000
+
+// mimeTypeKey identifies the contents of a file.  The size and mtime
+// catch writes that haven't been synced yet.
+type mimeTypeKey struct {
+	ptr   BlockPointer
+	size  uint64
+	mtime int64
+}
+
+// SetMimeTypeSniffing sets whether Stat and GetDirChildren calls
+// from the given frontend fill in the MimeType of the files they
+// return, by sniffing the start of each file.  Sniffed types are
+// cached until the files change.
+func (fs *KBFSOpsStandard) SetMimeTypeSniffing(
+	frontend RequestFrontend, enabled bool) {
+	fs.mimeTypeLock.Lock()
+	defer fs.mimeTypeLock.Unlock()
+	if !enabled {
+		delete(fs.mimeTypeFrontends, frontend)
+		return
+	}
+	if fs.mimeTypeFrontends == nil {
+		fs.mimeTypeFrontends = make(map[RequestFrontend]bool)
+	}
+	fs.mimeTypeFrontends[frontend] = true
+	if fs.mimeTypes == nil {
+		// This can only fail for a non-positive size.
+		fs.mimeTypes, _ = lru.New(mimeTypeCacheSize)
+	}
+}
+
+func (fs *KBFSOpsStandard) mimeTypeCache(ctx context.Context) *lru.Cache {
+	fs.mimeTypeLock.Lock()
+	defer fs.mimeTypeLock.Unlock()
+	if !fs.mimeTypeFrontends[RequestSourceFromContext(ctx).Frontend] {
+		return nil
+	}
+	return fs.mimeTypes
+}
+
+// sniffMimeType returns the MIME type of `file`, whose entry is `ei`,
+// reading the start of the file if it isn't in `cache` yet.
+func (fs *KBFSOpsStandard) sniffMimeType(ctx context.Context,
+	ops *folderBranchOps, cache *lru.Cache, file Node, ei EntryInfo) (
+	string, error) {
+	key := mimeTypeKey{
+		ops.nodeCache.PathFromNode(file).tailPointer(), ei.Size, ei.Mtime}
+	if mimeType, ok := cache.Get(key); ok {
+		return mimeType.(string), nil
+	}
+	buf := make([]byte, mimeTypeSniffLen)
+	n, err := ops.Read(ctx, file, buf, 0)
+	if err != nil {
+		return "", err
+	}
+	mimeType := http.DetectContentType(buf[:n])
+	cache.Add(key, mimeType)
+	return mimeType, nil
+}
+
+// addMimeTypeIfNeeded fills in the MimeType of `ei`, the entry for
+// `node`, if it is a non-empty file and the frontend of the request
+// gets sniffed MIME types.  Failures are only logged, since the type
+// is just a hint.
+func (fs *KBFSOpsStandard) addMimeTypeIfNeeded(ctx context.Context,
+	ops *folderBranchOps, node Node, ei *EntryInfo) {
+	if (ei.Type != File && ei.Type != Exec) || ei.Size == 0 {
+		return
+	}
+	cache := fs.mimeTypeCache(ctx)
+	if cache == nil {
+		return
+	}
+	mimeType, err := fs.sniffMimeType(ctx, ops, cache, node, *ei)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Couldn't sniff the MIME type of %s: %+v",
+			node.GetBasename(), err)
+		return
+	}
+	ei.MimeType = mimeType
+}
+
+// addMimeTypesIfNeeded fills in the MimeTypes of the file entries in
+// `children`, the children of `dir`, if the frontend of the request
+// gets sniffed MIME types.  Up to Config.DirListingWorkers files are
+// sniffed in parallel, so that listing a big directory doesn't wait
+// on the first block of each file in turn.
+func (fs *KBFSOpsStandard) addMimeTypesIfNeeded(ctx context.Context,
+	ops *folderBranchOps, dir Node, children map[string]EntryInfo) {
+	if fs.mimeTypeCache(ctx) == nil {
+		return
+	}
+	var names []string
+	for name, ei := range children {
+		if (ei.Type != File && ei.Type != Exec) || ei.Size == 0 {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	workers := fs.config.DirListingWorkers()
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	results := make([]EntryInfo, len(names))
+	for i, name := range names {
+		results[i] = children[name]
+	}
+	var wg sync.WaitGroup
+loop:
+	for i, name := range names {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// Leave the rest of the types unset; the caller will
+			// notice the canceled context.
+			break loop
+		}
+		wg.Add(1)
+		go func(name string, ei *EntryInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			node, _, err := ops.Lookup(ctx, dir, name)
+			if err != nil {
+				fs.log.CDebugf(ctx, "Couldn't look up %s: %+v", name, err)
+				return
+			}
+			fs.addMimeTypeIfNeeded(ctx, ops, node, ei)
+		}(name, &results[i])
+	}
+	wg.Wait()
+	for i, name := range names {
+		children[name] = results[i]
+	}
+}
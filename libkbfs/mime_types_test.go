@@ -0,0 +1,93 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsMimeTypeSniffing(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, []byte("%PDF-1.4 fake"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "empty", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Types aren't sniffed unless enabled for the frontend")
+	ei, err := kbfsOps.Stat(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, "", ei.MimeType)
+	kbfsOps.(*KBFSOpsStandard).SetMimeTypeSniffing(RequestFrontendHTTP, true)
+	ei, err = kbfsOps.Stat(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, "", ei.MimeType)
+
+	httpCtx := CtxWithRequestSource(ctx, RequestSource{
+		Frontend: RequestFrontendHTTP,
+	})
+	ei, err = kbfsOps.Stat(httpCtx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", ei.MimeType)
+	children, err := kbfsOps.GetDirChildren(httpCtx, rootNode)
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", children["a"].MimeType)
+	require.Equal(t, "", children["empty"].MimeType)
+
+	t.Log("Listing a directory sniffs its files in parallel")
+	for i := 0; i < 20; i++ {
+		n, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("gif%d", i), false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte("GIF89a fake"), 0)
+		require.NoError(t, err)
+	}
+	for _, workers := range []int{1, 4} {
+		config.SetDirListingWorkers(workers)
+		children, err = kbfsOps.GetDirChildren(httpCtx, rootNode)
+		require.NoError(t, err)
+		require.Equal(t, "application/pdf", children["a"].MimeType)
+		for i := 0; i < 20; i++ {
+			require.Equal(t, "image/gif",
+				children[fmt.Sprintf("gif%d", i)].MimeType)
+		}
+	}
+
+	t.Log("Changing the contents changes the type")
+	err = kbfsOps.Truncate(ctx, fNode, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, []byte("GIF89a fake"), 0)
+	require.NoError(t, err)
+	ei, err = kbfsOps.Stat(httpCtx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, "image/gif", ei.MimeType)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Sniffed types aren't encoded")
+	codec := kbfscodec.NewMsgpack()
+	buf, err := codec.Encode(ei)
+	require.NoError(t, err)
+	var decoded EntryInfo
+	err = codec.Decode(buf, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, "", decoded.MimeType)
+	ei.MimeType = ""
+	require.Equal(t, ei, decoded)
+}
@@ -0,0 +1,644 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxMirrorJobTagKey is the type used for unique context tags within
+// a mirror job.
+type CtxMirrorJobTagKey int
+
+const (
+	// CtxMirrorJobIDKey is the type of the tag for unique operation
+	// IDs within a mirror job.
+	CtxMirrorJobIDKey CtxMirrorJobTagKey = iota
+)
+
+// CtxMirrorJobOpID is the display name for the unique operation
+// mirror job ID tag.
+const CtxMirrorJobOpID = "MJID"
+
+// mirrorJobRetryInterval is how long a mirror job waits to try again
+// after a failed sync, if the source doesn't change before then.
+const mirrorJobRetryInterval = time.Minute
+
+// MirrorEndpoint names the directory that a mirror job copies from
+// or to: either a directory within a TLF, or a local directory.
+type MirrorEndpoint struct {
+	// TlfName and TlfType name the TLF, unless LocalPath is set.
+	TlfName string
+	TlfType tlf.Type
+	// Path is the slash-separated path of the directory within the
+	// TLF, or the empty string for the root of the TLF.
+	Path string
+	// LocalPath is the absolute path of a local directory.
+	LocalPath string
+}
+
+// IsLocal returns whether e is a local directory.
+func (e MirrorEndpoint) IsLocal() bool {
+	return e.LocalPath != ""
+}
+
+func (e MirrorEndpoint) String() string {
+	if e.IsLocal() {
+		return e.LocalPath
+	}
+	var t PathType
+	switch e.TlfType {
+	case tlf.Private:
+		t = PrivatePathType
+	case tlf.Public:
+		t = PublicPathType
+	case tlf.SingleTeam:
+		t = SingleTeamPathType
+	}
+	return stdpath.Join(string(t), e.TlfName, e.Path)
+}
+
+// ParseMirrorEndpoint parses a mirror job endpoint.  An absolute
+// path names a local directory; anything else must be of the form
+// <type>/<name>[/<path>], like "private/alice/docs", and names a
+// directory within a TLF.
+func ParseMirrorEndpoint(s string) (MirrorEndpoint, error) {
+	if filepath.IsAbs(s) {
+		return MirrorEndpoint{LocalPath: filepath.Clean(s)}, nil
+	}
+	parts := strings.SplitN(strings.Trim(s, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return MirrorEndpoint{}, errors.Errorf(
+			"Mirror endpoint %q is neither an absolute local path nor "+
+				"of the form <type>/<name>[/<path>]", s)
+	}
+	e := MirrorEndpoint{TlfName: parts[1]}
+	switch PathType(parts[0]) {
+	case PrivatePathType:
+		e.TlfType = tlf.Private
+	case PublicPathType:
+		e.TlfType = tlf.Public
+	case SingleTeamPathType:
+		e.TlfType = tlf.SingleTeam
+	default:
+		return MirrorEndpoint{}, errors.Errorf(
+			"Unknown folder type %q in mirror endpoint %q", parts[0], s)
+	}
+	if len(parts) == 3 {
+		e.Path = stdpath.Clean(parts[2])
+		if e.Path == "." {
+			e.Path = ""
+		}
+	}
+	return e, nil
+}
+
+// MirrorJobConfig describes a one-way mirror of a directory within a
+// TLF into another directory, within a TLF or on the local disk.
+type MirrorJobConfig struct {
+	// Name identifies the job.
+	Name string `json:"name"`
+	// Source and Dest are endpoints as accepted by
+	// ParseMirrorEndpoint.  Source must be within a TLF.
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
+
+// ReadMirrorJobConfigs reads a JSON list of mirror job configs from
+// the given file.
+func ReadMirrorJobConfigs(path string) ([]MirrorJobConfig, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []MirrorJobConfig
+	err = json.Unmarshal(buf, &configs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse mirror jobs in %s", path)
+	}
+	return configs, nil
+}
+
+// MirrorJobStatus describes how a mirror job is doing.
+type MirrorJobStatus struct {
+	Name   string
+	Source string
+	Dest   string
+	// Syncs counts the successful syncs of the job.
+	Syncs    int
+	LastSync time.Time
+	// LastError is the error of the last sync, if it failed.
+	LastError string
+}
+
+// mirrorJob copies the contents of a source directory over the
+// contents of a destination directory whenever the source changes.
+// Anything in the destination that differs from the source is
+// overwritten, so changes made to the destination by anyone else
+// don't survive the next sync.
+type mirrorJob struct {
+	fs  *KBFSOpsStandard
+	src MirrorEndpoint
+	dst MirrorEndpoint
+
+	changedCh  chan struct{}
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+
+	statusLock sync.Mutex
+	status     MirrorJobStatus
+	// statusCh is closed, and replaced, whenever status changes.
+	statusCh chan struct{}
+
+	// Only accessed by the job's goroutine.
+	srcDir Node
+}
+
+var _ Observer = (*mirrorJob)(nil)
+
+// StartMirrorJob starts mirroring as described by jobConfig, in the
+// background.  The whole source directory is copied first, and then
+// again shortly after each synced change to it.  Errors are recorded
+// in the job's status and retried later.
+func (fs *KBFSOpsStandard) StartMirrorJob(
+	ctx context.Context, jobConfig MirrorJobConfig) (err error) {
+	_, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	src, err := ParseMirrorEndpoint(jobConfig.Source)
+	if err != nil {
+		return err
+	}
+	if src.IsLocal() {
+		return errors.Errorf("Mirror job %s: the source must be in a TLF",
+			jobConfig.Name)
+	}
+	dst, err := ParseMirrorEndpoint(jobConfig.Dest)
+	if err != nil {
+		return err
+	}
+
+	j := &mirrorJob{
+		fs:         fs,
+		src:        src,
+		dst:        dst,
+		changedCh:  make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		statusCh:   make(chan struct{}),
+		status: MirrorJobStatus{
+			Name:   jobConfig.Name,
+			Source: src.String(),
+			Dest:   dst.String(),
+		},
+	}
+	fs.mirrorJobsLock.Lock()
+	defer fs.mirrorJobsLock.Unlock()
+	if _, ok := fs.mirrorJobs[jobConfig.Name]; ok {
+		return errors.Errorf("Mirror job %s already exists", jobConfig.Name)
+	}
+	fs.mirrorJobs[jobConfig.Name] = j
+	go j.loop()
+	return nil
+}
+
+// StopMirrorJob stops the named mirror job, and waits for any sync
+// in progress to end.
+func (fs *KBFSOpsStandard) StopMirrorJob(
+	ctx context.Context, name string) error {
+	fs.mirrorJobsLock.Lock()
+	j, ok := fs.mirrorJobs[name]
+	delete(fs.mirrorJobs, name)
+	fs.mirrorJobsLock.Unlock()
+	if !ok {
+		return errors.Errorf("No mirror job named %s", name)
+	}
+	close(j.shutdownCh)
+	select {
+	case <-j.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopMirrorJobs stops all the mirror jobs.
+func (fs *KBFSOpsStandard) stopMirrorJobs(ctx context.Context) error {
+	fs.mirrorJobsLock.Lock()
+	names := make([]string, 0, len(fs.mirrorJobs))
+	for name := range fs.mirrorJobs {
+		names = append(names, name)
+	}
+	fs.mirrorJobsLock.Unlock()
+	for _, name := range names {
+		err := fs.StopMirrorJob(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MirrorJobStatuses returns the status of every mirror job, sorted
+// by name.
+func (fs *KBFSOpsStandard) MirrorJobStatuses() []MirrorJobStatus {
+	fs.mirrorJobsLock.Lock()
+	defer fs.mirrorJobsLock.Unlock()
+	statuses := make([]MirrorJobStatus, 0, len(fs.mirrorJobs))
+	for _, j := range fs.mirrorJobs {
+		statuses = append(statuses, j.getStatus())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func (j *mirrorJob) getStatus() MirrorJobStatus {
+	j.statusLock.Lock()
+	defer j.statusLock.Unlock()
+	return j.status
+}
+
+func (j *mirrorJob) setResult(err error) {
+	j.statusLock.Lock()
+	defer j.statusLock.Unlock()
+	close(j.statusCh)
+	j.statusCh = make(chan struct{})
+	if err != nil {
+		j.status.LastError = err.Error()
+		return
+	}
+	j.status.Syncs++
+	j.status.LastSync = j.fs.config.Clock().Now()
+	j.status.LastError = ""
+}
+
+// waitForMirrorJobSyncsForTest waits until the named mirror job has
+// synced successfully at least `syncs` times.
+func (fs *KBFSOpsStandard) waitForMirrorJobSyncsForTest(
+	ctx context.Context, name string, syncs int) error {
+	fs.mirrorJobsLock.Lock()
+	j, ok := fs.mirrorJobs[name]
+	fs.mirrorJobsLock.Unlock()
+	if !ok {
+		return errors.Errorf("No mirror job named %s", name)
+	}
+	for {
+		j.statusLock.Lock()
+		status, statusCh := j.status, j.statusCh
+		j.statusLock.Unlock()
+		if status.Syncs >= syncs {
+			return nil
+		}
+		select {
+		case <-statusCh:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(),
+				"Mirror job %s wasn't synced: %v", name, status)
+		}
+	}
+}
+
+func (j *mirrorJob) loop() {
+	defer close(j.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxMirrorJobIDKey, CtxMirrorJobOpID, j.fs.log))
+	defer cancel()
+	// Writes need a context that can delay their cancellation.
+	ctx, err := NewContextWithCancellationDelayer(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer CleanupCancellationDelayer(ctx)
+	go func() {
+		select {
+		case <-j.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer j.unregister(ctx)
+
+	for {
+		err := j.sync(ctx)
+		j.setResult(err)
+		var retryCh <-chan time.Time
+		if err != nil {
+			j.fs.log.CWarningf(ctx, "Mirror job %s failed: %+v",
+				j.status.Name, err)
+			// Look the source up again next time, in case it was
+			// replaced.
+			j.unregister(ctx)
+			retryCh = time.After(mirrorJobRetryInterval)
+		}
+		select {
+		case <-j.changedCh:
+		case <-retryCh:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unregister stops watching the source directory, if it's being
+// watched.
+func (j *mirrorJob) unregister(ctx context.Context) {
+	if j.srcDir == nil {
+		return
+	}
+	err := j.fs.UnregisterFromChanges(
+		[]FolderBranch{j.srcDir.GetFolderBranch()}, j)
+	if err != nil {
+		j.fs.log.CDebugf(ctx, "Couldn't unregister mirror job %s: %+v",
+			j.status.Name, err)
+	}
+	j.srcDir = nil
+}
+
+// lookupEndpointDir returns the node of the directory named by e,
+// which must be within a TLF.  If create is true, the TLF and any
+// missing directories along the path are created.
+func (fs *KBFSOpsStandard) lookupEndpointDir(
+	ctx context.Context, e MirrorEndpoint, create bool) (Node, error) {
+	h, err := GetHandleFromFolderNameAndType(
+		ctx, fs.config.KBPKI(), fs.config.MDOps(), e.TlfName, e.TlfType)
+	if err != nil {
+		return nil, err
+	}
+	dir, _, err := fs.getMaybeCreateRootNode(ctx, h, MasterBranch, create)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		return nil, errors.Errorf("%s doesn't exist", h.GetCanonicalPath())
+	}
+	if e.Path == "" {
+		return dir, nil
+	}
+	for _, name := range strings.Split(e.Path, "/") {
+		ops := fs.getOpsByNode(ctx, dir)
+		child, ei, err := ops.Lookup(ctx, dir, name)
+		if _, ok := errors.Cause(err).(NoSuchNameError); ok && create {
+			child, ei, err = ops.CreateDir(ctx, dir, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ei.Type != Dir {
+			return nil, NotDirError{
+				ops.nodeCache.PathFromNode(dir).ChildPathNoPtr(name)}
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+// sync makes the destination a copy of the source.
+func (j *mirrorJob) sync(ctx context.Context) (err error) {
+	ctx, done, err := j.fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	if j.srcDir == nil {
+		srcDir, err := j.fs.lookupEndpointDir(ctx, j.src, false)
+		if err != nil {
+			return err
+		}
+		// Watch the source before copying it, so that no change is
+		// missed.
+		err = j.fs.RegisterForChangesWithFilter(
+			[]FolderBranch{srcDir.GetFolderBranch()}, j, ObserverFilter{
+				Root:           srcDir,
+				Recursive:      true,
+				CoalesceWindow: subtreeMirrorCoalesceWindow,
+			})
+		if err != nil {
+			return err
+		}
+		j.srcDir = srcDir
+	}
+
+	j.fs.log.CDebugf(ctx, "Mirroring %s to %s", j.status.Source,
+		j.status.Dest)
+	exclude := j.fs.config.TlfExcludePatterns(j.srcDir.GetFolderBranch().Tlf)
+	if j.dst.IsLocal() {
+		return j.syncLocalDir(ctx, j.srcDir, j.dst.LocalPath, exclude, j.src.Path)
+	}
+
+	dstDir, err := j.fs.lookupEndpointDir(ctx, j.dst, true)
+	if err != nil {
+		return err
+	}
+	dstFB := dstDir.GetFolderBranch()
+	if j.srcDir.GetFolderBranch() == dstFB {
+		srcPath := "/" + j.src.Path
+		dstPath := "/" + j.dst.Path
+		if srcPath == dstPath || strings.HasPrefix(dstPath, srcPath+"/") ||
+			strings.HasPrefix(srcPath, dstPath+"/") {
+			return errors.Errorf("Can't mirror %s into %s, since they overlap",
+				j.status.Source, j.status.Dest)
+		}
+	}
+	c := &crossTlfCopier{fs: j.fs, exclude: exclude, excludeBase: j.src.Path}
+	err = c.syncDir(ctx, j.srcDir, dstDir, "")
+	if err != nil {
+		return err
+	}
+	return j.fs.getOpsByNode(ctx, dstDir).SyncAll(ctx, dstFB)
+}
+
+// syncLocalDir makes the local directory dstPath a copy of srcDir,
+// only copying files whose size, mtime or executable bit differ.
+func (j *mirrorJob) syncLocalDir(ctx context.Context, srcDir Node,
+	dstPath string, exclude *ExcludePatterns, relPath string) error {
+	ops := j.fs.getOpsByNode(ctx, srcDir)
+	children, err := ops.GetDirChildren(ctx, srcDir)
+	if err != nil {
+		return err
+	}
+	children = exclude.filterChildren(relPath, children)
+	err = os.MkdirAll(dstPath, 0700)
+	if err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(dstPath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if _, ok := children[fi.Name()]; ok {
+			continue
+		}
+		err := os.RemoveAll(filepath.Join(dstPath, fi.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ei := children[name]
+		localPath := filepath.Join(dstPath, name)
+		fi, err := os.Lstat(localPath)
+		if os.IsNotExist(err) {
+			fi = nil
+		} else if err != nil {
+			return err
+		}
+
+		switch ei.Type {
+		case Dir:
+			if fi != nil && !fi.IsDir() {
+				if err := os.Remove(localPath); err != nil {
+					return err
+				}
+			}
+			node, _, err := ops.Lookup(ctx, srcDir, name)
+			if err != nil {
+				return err
+			}
+			err = j.syncLocalDir(ctx, node, localPath, exclude,
+				stdpath.Join(relPath, name))
+			if err != nil {
+				return err
+			}
+		case Sym:
+			if fi != nil && fi.Mode()&os.ModeSymlink != 0 {
+				if target, err := os.Readlink(localPath); err == nil &&
+					target == ei.SymPath {
+					continue
+				}
+			}
+			if fi != nil {
+				if err := os.RemoveAll(localPath); err != nil {
+					return err
+				}
+			}
+			err := os.Symlink(ei.SymPath, localPath)
+			if err != nil {
+				return err
+			}
+		case File, Exec:
+			if fi != nil && fi.Mode().IsRegular() &&
+				uint64(fi.Size()) == ei.Size &&
+				fi.ModTime().UnixNano() == ei.Mtime &&
+				(fi.Mode()&0100 != 0) == (ei.Type == Exec) {
+				continue
+			}
+			if fi != nil && fi.IsDir() {
+				if err := os.RemoveAll(localPath); err != nil {
+					return err
+				}
+			}
+			node, _, err := ops.Lookup(ctx, srcDir, name)
+			if err != nil {
+				return err
+			}
+			err = j.fs.copyToLocalFile(ctx, node, ei, localPath)
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("Unknown entry type %s", ei.Type)
+		}
+	}
+	return nil
+}
+
+// copyToLocalFile writes the contents of srcNode to a temporary file
+// next to localPath, and then renames it over localPath, so that
+// readers of localPath never see a partial copy.
+func (fs *KBFSOpsStandard) copyToLocalFile(ctx context.Context, srcNode Node,
+	ei EntryInfo, localPath string) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(localPath), ".kbfs-mirror-")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(name)
+		}
+	}()
+
+	ops := fs.getOpsByNode(ctx, srcNode)
+	buf := make([]byte, crossTlfRenameChunkSize)
+	for off := int64(0); off < int64(ei.Size); {
+		n, err := ops.Read(ctx, srcNode, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// The file got shorter while we were copying it.
+			break
+		}
+		_, err = f.WriteAt(buf[:n], off)
+		if err != nil {
+			return err
+		}
+		off += n
+	}
+	mode := os.FileMode(0600)
+	if ei.Type == Exec {
+		mode = 0700
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	mtime := time.Unix(0, ei.Mtime)
+	err = os.Chtimes(name, mtime, mtime)
+	if err != nil {
+		return err
+	}
+	return os.Rename(name, localPath)
+}
+
+// LocalChange implements the Observer interface for mirrorJob.  Only
+// synced changes are mirrored.
+func (j *mirrorJob) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+// BatchChanges implements the Observer interface for mirrorJob.
+func (j *mirrorJob) BatchChanges(
+	_ context.Context, _ []NodeChange, _ []NodeID) {
+	select {
+	case j.changedCh <- struct{}{}:
+	default:
+		// A sync is already pending, and will pick this up.
+	}
+}
+
+// TlfHandleChange implements the Observer interface for mirrorJob.
+func (j *mirrorJob) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
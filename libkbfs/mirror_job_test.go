@@ -0,0 +1,150 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMirrorEndpoint(t *testing.T) {
+	e, err := ParseMirrorEndpoint("private/u1,u2/a/b/")
+	require.NoError(t, err)
+	require.Equal(t, MirrorEndpoint{
+		TlfName: "u1,u2", TlfType: tlf.Private, Path: "a/b"}, e)
+	require.Equal(t, "private/u1,u2/a/b", e.String())
+
+	e, err = ParseMirrorEndpoint("team/t1")
+	require.NoError(t, err)
+	require.Equal(t, MirrorEndpoint{TlfName: "t1", TlfType: tlf.SingleTeam}, e)
+
+	e, err = ParseMirrorEndpoint("/backups/kbfs/")
+	require.NoError(t, err)
+	require.True(t, e.IsLocal())
+	require.Equal(t, "/backups/kbfs", e.String())
+
+	_, err = ParseMirrorEndpoint("private")
+	require.Error(t, err)
+	_, err = ParseMirrorEndpoint("secret/u1")
+	require.Error(t, err)
+}
+
+func TestKBFSOpsMirrorJobs(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	privRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	t.Log("Make a/{f,d/g} in the private TLF")
+	aNode, _, err := kbfsOps.CreateDir(ctx, privRoot, "a")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, aNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	dNode, _, err := kbfsOps.CreateDir(ctx, aNode, "d")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "g", true, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+
+	localDir, err := ioutil.TempDir("", "mirror_job_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+	localDst := filepath.Join(localDir, "dst")
+
+	t.Log("Mirror a into the public TLF and into a local directory")
+	err = kbfsOps.StartMirrorJob(ctx, MirrorJobConfig{
+		Name: "pub", Source: "private/u1/a", Dest: "public/u1/x/y"})
+	require.NoError(t, err)
+	err = kbfsOps.StartMirrorJob(ctx, MirrorJobConfig{
+		Name: "local", Source: "private/u1/a", Dest: localDst})
+	require.NoError(t, err)
+	err = kbfsOps.StartMirrorJob(ctx, MirrorJobConfig{
+		Name: "pub", Source: "private/u1/a", Dest: "public/u1/z"})
+	require.Error(t, err)
+	err = kbfsOps.StartMirrorJob(ctx, MirrorJobConfig{
+		Name: "bad", Source: localDir, Dest: "public/u1/z"})
+	require.Error(t, err)
+
+	waitForSyncs := func(syncs int) {
+		require.Len(t, kbfsOps.MirrorJobStatuses(), 2)
+		for _, name := range []string{"pub", "local"} {
+			err := kbfsOps.waitForMirrorJobSyncsForTest(ctx, name, syncs)
+			require.NoError(t, err)
+		}
+	}
+	checkPub := func(expectedF []byte, expectedD []string) {
+		xNode, _, err := kbfsOps.Lookup(ctx, pubRoot, "x")
+		require.NoError(t, err)
+		yNode, _, err := kbfsOps.Lookup(ctx, xNode, "y")
+		require.NoError(t, err)
+		node, _, err := kbfsOps.Lookup(ctx, yNode, "f")
+		require.NoError(t, err)
+		buf := make([]byte, len(expectedF)+1)
+		n, err := kbfsOps.Read(ctx, node, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expectedF, buf[:n])
+		node, _, err = kbfsOps.Lookup(ctx, yNode, "d")
+		require.NoError(t, err)
+		children, err := kbfsOps.GetDirChildren(ctx, node)
+		require.NoError(t, err)
+		require.Len(t, children, len(expectedD))
+		for _, name := range expectedD {
+			require.Contains(t, children, name)
+		}
+	}
+	checkLocal := func(expectedF []byte, expectedD []string) {
+		buf, err := ioutil.ReadFile(filepath.Join(localDst, "f"))
+		require.NoError(t, err)
+		require.Equal(t, expectedF, buf)
+		fis, err := ioutil.ReadDir(filepath.Join(localDst, "d"))
+		require.NoError(t, err)
+		require.Len(t, fis, len(expectedD))
+		for i, name := range expectedD {
+			require.Equal(t, name, fis[i].Name())
+		}
+	}
+
+	waitForSyncs(1)
+	checkPub([]byte("hello"), []string{"g"})
+	checkLocal([]byte("hello"), []string{"g"})
+	fi, err := os.Stat(filepath.Join(localDst, "d", "g"))
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&0100)
+
+	t.Log("Later changes overwrite the mirrors")
+	err = kbfsOps.Write(ctx, fNode, []byte("howdy, world"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, dNode, "g")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "h", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	waitForSyncs(2)
+	checkPub([]byte("howdy, world"), []string{"h"})
+	checkLocal([]byte("howdy, world"), []string{"h"})
+
+	t.Log("Stopped jobs are gone")
+	err = kbfsOps.StopMirrorJob(ctx, "pub")
+	require.NoError(t, err)
+	err = kbfsOps.StopMirrorJob(ctx, "pub")
+	require.Error(t, err)
+	statuses := kbfsOps.MirrorJobStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "local", statuses[0].Name)
+	require.Equal(t, "", statuses[0].LastError)
+}
@@ -17,6 +17,9 @@ import (
 	tlf "github.com/keybase/kbfs/tlf"
 	go_metrics "github.com/rcrowley/go-metrics"
 	context "golang.org/x/net/context"
+	io "io"
+	net "net"
+	os "os"
 	reflect "reflect"
 	time "time"
 )
@@ -196,6 +199,41 @@ func (mr *MockcodecGetterMockRecorder) Codec() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Codec", reflect.TypeOf((*MockcodecGetter)(nil).Codec))
 }
 
+// MockkbfsOpsGetter is a mock of kbfsOpsGetter interface
+type MockkbfsOpsGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockkbfsOpsGetterMockRecorder
+}
+
+// MockkbfsOpsGetterMockRecorder is the mock recorder for MockkbfsOpsGetter
+type MockkbfsOpsGetterMockRecorder struct {
+	mock *MockkbfsOpsGetter
+}
+
+// NewMockkbfsOpsGetter creates a new mock instance
+func NewMockkbfsOpsGetter(ctrl *gomock.Controller) *MockkbfsOpsGetter {
+	mock := &MockkbfsOpsGetter{ctrl: ctrl}
+	mock.recorder = &MockkbfsOpsGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockkbfsOpsGetter) EXPECT() *MockkbfsOpsGetterMockRecorder {
+	return m.recorder
+}
+
+// KBFSOps mocks base method
+func (m *MockkbfsOpsGetter) KBFSOps() KBFSOps {
+	ret := m.ctrl.Call(m, "KBFSOps")
+	ret0, _ := ret[0].(KBFSOps)
+	return ret0
+}
+
+// KBFSOps indicates an expected call of KBFSOps
+func (mr *MockkbfsOpsGetterMockRecorder) KBFSOps() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "KBFSOps", reflect.TypeOf((*MockkbfsOpsGetter)(nil).KBFSOps))
+}
+
 // MockblockServerGetter is a mock of blockServerGetter interface
 type MockblockServerGetter struct {
 	ctrl     *gomock.Controller
@@ -546,6 +584,288 @@ func (mr *MockdiskLimiterGetterMockRecorder) DiskLimiter() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiskLimiter", reflect.TypeOf((*MockdiskLimiterGetter)(nil).DiskLimiter))
 }
 
+// MocknetworkModeGetter is a mock of networkModeGetter interface
+type MocknetworkModeGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MocknetworkModeGetterMockRecorder
+}
+
+// MocknetworkModeGetterMockRecorder is the mock recorder for MocknetworkModeGetter
+type MocknetworkModeGetterMockRecorder struct {
+	mock *MocknetworkModeGetter
+}
+
+// NewMocknetworkModeGetter creates a new mock instance
+func NewMocknetworkModeGetter(ctrl *gomock.Controller) *MocknetworkModeGetter {
+	mock := &MocknetworkModeGetter{ctrl: ctrl}
+	mock.recorder = &MocknetworkModeGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MocknetworkModeGetter) EXPECT() *MocknetworkModeGetterMockRecorder {
+	return m.recorder
+}
+
+// NetworkMode mocks base method
+func (m *MocknetworkModeGetter) NetworkMode() NetworkMode {
+	ret := m.ctrl.Call(m, "NetworkMode")
+	ret0, _ := ret[0].(NetworkMode)
+	return ret0
+}
+
+// NetworkMode indicates an expected call of NetworkMode
+func (mr *MocknetworkModeGetterMockRecorder) NetworkMode() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkMode", reflect.TypeOf((*MocknetworkModeGetter)(nil).NetworkMode))
+}
+
+// MocknetworkConstraintsGetter is a mock of networkConstraintsGetter interface
+type MocknetworkConstraintsGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MocknetworkConstraintsGetterMockRecorder
+}
+
+// MocknetworkConstraintsGetterMockRecorder is the mock recorder for MocknetworkConstraintsGetter
+type MocknetworkConstraintsGetterMockRecorder struct {
+	mock *MocknetworkConstraintsGetter
+}
+
+// NewMocknetworkConstraintsGetter creates a new mock instance
+func NewMocknetworkConstraintsGetter(ctrl *gomock.Controller) *MocknetworkConstraintsGetter {
+	mock := &MocknetworkConstraintsGetter{ctrl: ctrl}
+	mock.recorder = &MocknetworkConstraintsGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MocknetworkConstraintsGetter) EXPECT() *MocknetworkConstraintsGetterMockRecorder {
+	return m.recorder
+}
+
+// NetworkConstraints mocks base method
+func (m *MocknetworkConstraintsGetter) NetworkConstraints() NetworkConstraints {
+	ret := m.ctrl.Call(m, "NetworkConstraints")
+	ret0, _ := ret[0].(NetworkConstraints)
+	return ret0
+}
+
+// NetworkConstraints indicates an expected call of NetworkConstraints
+func (mr *MocknetworkConstraintsGetterMockRecorder) NetworkConstraints() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkConstraints", reflect.TypeOf((*MocknetworkConstraintsGetter)(nil).NetworkConstraints))
+}
+
+// MockretryPolicyGetter is a mock of retryPolicyGetter interface
+type MockretryPolicyGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockretryPolicyGetterMockRecorder
+}
+
+// MockretryPolicyGetterMockRecorder is the mock recorder for MockretryPolicyGetter
+type MockretryPolicyGetterMockRecorder struct {
+	mock *MockretryPolicyGetter
+}
+
+// NewMockretryPolicyGetter creates a new mock instance
+func NewMockretryPolicyGetter(ctrl *gomock.Controller) *MockretryPolicyGetter {
+	mock := &MockretryPolicyGetter{ctrl: ctrl}
+	mock.recorder = &MockretryPolicyGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockretryPolicyGetter) EXPECT() *MockretryPolicyGetterMockRecorder {
+	return m.recorder
+}
+
+// RetryPolicy mocks base method
+func (m *MockretryPolicyGetter) RetryPolicy() RetryPolicy {
+	ret := m.ctrl.Call(m, "RetryPolicy")
+	ret0, _ := ret[0].(RetryPolicy)
+	return ret0
+}
+
+// RetryPolicy indicates an expected call of RetryPolicy
+func (mr *MockretryPolicyGetterMockRecorder) RetryPolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryPolicy", reflect.TypeOf((*MockretryPolicyGetter)(nil).RetryPolicy))
+}
+
+// MockmetricsRegistryGetter is a mock of metricsRegistryGetter interface
+type MockmetricsRegistryGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockmetricsRegistryGetterMockRecorder
+}
+
+// MockmetricsRegistryGetterMockRecorder is the mock recorder for MockmetricsRegistryGetter
+type MockmetricsRegistryGetterMockRecorder struct {
+	mock *MockmetricsRegistryGetter
+}
+
+// NewMockmetricsRegistryGetter creates a new mock instance
+func NewMockmetricsRegistryGetter(ctrl *gomock.Controller) *MockmetricsRegistryGetter {
+	mock := &MockmetricsRegistryGetter{ctrl: ctrl}
+	mock.recorder = &MockmetricsRegistryGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockmetricsRegistryGetter) EXPECT() *MockmetricsRegistryGetterMockRecorder {
+	return m.recorder
+}
+
+// MetricsRegistry mocks base method
+func (m *MockmetricsRegistryGetter) MetricsRegistry() go_metrics.Registry {
+	ret := m.ctrl.Call(m, "MetricsRegistry")
+	ret0, _ := ret[0].(go_metrics.Registry)
+	return ret0
+}
+
+// MetricsRegistry indicates an expected call of MetricsRegistry
+func (mr *MockmetricsRegistryGetterMockRecorder) MetricsRegistry() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MetricsRegistry", reflect.TypeOf((*MockmetricsRegistryGetter)(nil).MetricsRegistry))
+}
+
+// MockRetryPolicy is a mock of RetryPolicy interface
+type MockRetryPolicy struct {
+	ctrl     *gomock.Controller
+	recorder *MockRetryPolicyMockRecorder
+}
+
+// MockRetryPolicyMockRecorder is the mock recorder for MockRetryPolicy
+type MockRetryPolicyMockRecorder struct {
+	mock *MockRetryPolicy
+}
+
+// NewMockRetryPolicy creates a new mock instance
+func NewMockRetryPolicy(ctrl *gomock.Controller) *MockRetryPolicy {
+	mock := &MockRetryPolicy{ctrl: ctrl}
+	mock.recorder = &MockRetryPolicyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockRetryPolicy) EXPECT() *MockRetryPolicyMockRecorder {
+	return m.recorder
+}
+
+// NextRetry mocks base method
+func (m *MockRetryPolicy) NextRetry(op string, failures int, elapsed time.Duration, err error) (time.Duration, bool) {
+	ret := m.ctrl.Call(m, "NextRetry", op, failures, elapsed, err)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// NextRetry indicates an expected call of NextRetry
+func (mr *MockRetryPolicyMockRecorder) NextRetry(op, failures, elapsed, err interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextRetry", reflect.TypeOf((*MockRetryPolicy)(nil).NextRetry), op, failures, elapsed, err)
+}
+
+// MockDialer is a mock of Dialer interface
+type MockDialer struct {
+	ctrl     *gomock.Controller
+	recorder *MockDialerMockRecorder
+}
+
+// MockDialerMockRecorder is the mock recorder for MockDialer
+type MockDialerMockRecorder struct {
+	mock *MockDialer
+}
+
+// NewMockDialer creates a new mock instance
+func NewMockDialer(ctrl *gomock.Controller) *MockDialer {
+	mock := &MockDialer{ctrl: ctrl}
+	mock.recorder = &MockDialerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDialer) EXPECT() *MockDialerMockRecorder {
+	return m.recorder
+}
+
+// Dial mocks base method
+func (m *MockDialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	ret := m.ctrl.Call(m, "Dial", ctx, network, addr)
+	ret0, _ := ret[0].(net.Conn)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dial indicates an expected call of Dial
+func (mr *MockDialerMockRecorder) Dial(ctx, network, addr interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dial", reflect.TypeOf((*MockDialer)(nil).Dial), ctx, network, addr)
+}
+
+// MockdialerGetter is a mock of dialerGetter interface
+type MockdialerGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockdialerGetterMockRecorder
+}
+
+// MockdialerGetterMockRecorder is the mock recorder for MockdialerGetter
+type MockdialerGetterMockRecorder struct {
+	mock *MockdialerGetter
+}
+
+// NewMockdialerGetter creates a new mock instance
+func NewMockdialerGetter(ctrl *gomock.Controller) *MockdialerGetter {
+	mock := &MockdialerGetter{ctrl: ctrl}
+	mock.recorder = &MockdialerGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockdialerGetter) EXPECT() *MockdialerGetterMockRecorder {
+	return m.recorder
+}
+
+// Dialer mocks base method
+func (m *MockdialerGetter) Dialer() Dialer {
+	ret := m.ctrl.Call(m, "Dialer")
+	ret0, _ := ret[0].(Dialer)
+	return ret0
+}
+
+// Dialer indicates an expected call of Dialer
+func (mr *MockdialerGetterMockRecorder) Dialer() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dialer", reflect.TypeOf((*MockdialerGetter)(nil).Dialer))
+}
+
+// MocklogPathRedactor is a mock of logPathRedactor interface
+type MocklogPathRedactor struct {
+	ctrl     *gomock.Controller
+	recorder *MocklogPathRedactorMockRecorder
+}
+
+// MocklogPathRedactorMockRecorder is the mock recorder for MocklogPathRedactor
+type MocklogPathRedactorMockRecorder struct {
+	mock *MocklogPathRedactor
+}
+
+// NewMocklogPathRedactor creates a new mock instance
+func NewMocklogPathRedactor(ctrl *gomock.Controller) *MocklogPathRedactor {
+	mock := &MocklogPathRedactor{ctrl: ctrl}
+	mock.recorder = &MocklogPathRedactorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MocklogPathRedactor) EXPECT() *MocklogPathRedactorMockRecorder {
+	return m.recorder
+}
+
+// RedactLogPath mocks base method
+func (m *MocklogPathRedactor) RedactLogPath(p string) string {
+	ret := m.ctrl.Call(m, "RedactLogPath", p)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// RedactLogPath indicates an expected call of RedactLogPath
+func (mr *MocklogPathRedactorMockRecorder) RedactLogPath(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedactLogPath", reflect.TypeOf((*MocklogPathRedactor)(nil).RedactLogPath), p)
+}
+
 // MocksyncedTlfGetterSetter is a mock of syncedTlfGetterSetter interface
 type MocksyncedTlfGetterSetter struct {
 	ctrl     *gomock.Controller
@@ -593,6 +913,30 @@ func (mr *MocksyncedTlfGetterSetterMockRecorder) SetTlfSyncState(tlfID, isSynced
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfSyncState", reflect.TypeOf((*MocksyncedTlfGetterSetter)(nil).SetTlfSyncState), tlfID, isSynced)
 }
 
+// TlfExcludePatterns mocks base method
+func (m *MocksyncedTlfGetterSetter) TlfExcludePatterns(tlfID tlf.ID) *ExcludePatterns {
+	ret := m.ctrl.Call(m, "TlfExcludePatterns", tlfID)
+	ret0, _ := ret[0].(*ExcludePatterns)
+	return ret0
+}
+
+// TlfExcludePatterns indicates an expected call of TlfExcludePatterns
+func (mr *MocksyncedTlfGetterSetterMockRecorder) TlfExcludePatterns(tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TlfExcludePatterns", reflect.TypeOf((*MocksyncedTlfGetterSetter)(nil).TlfExcludePatterns), tlfID)
+}
+
+// SetTlfExcludePatterns mocks base method
+func (m *MocksyncedTlfGetterSetter) SetTlfExcludePatterns(tlfID tlf.ID, patterns []string) error {
+	ret := m.ctrl.Call(m, "SetTlfExcludePatterns", tlfID, patterns)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTlfExcludePatterns indicates an expected call of SetTlfExcludePatterns
+func (mr *MocksyncedTlfGetterSetterMockRecorder) SetTlfExcludePatterns(tlfID, patterns interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfExcludePatterns", reflect.TypeOf((*MocksyncedTlfGetterSetter)(nil).SetTlfExcludePatterns), tlfID, patterns)
+}
+
 // MockblockRetrieverGetter is a mock of blockRetrieverGetter interface
 type MockblockRetrieverGetter struct {
 	ctrl     *gomock.Controller
@@ -1067,6 +1411,33 @@ func (mr *MockKBFSOpsMockRecorder) Stat(ctx, node interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockKBFSOps)(nil).Stat), ctx, node)
 }
 
+// GetNodeByPath mocks base method
+func (m *MockKBFSOps) GetNodeByPath(ctx context.Context, folderBranch FolderBranch, p string) (Node, EntryInfo, error) {
+	ret := m.ctrl.Call(m, "GetNodeByPath", ctx, folderBranch, p)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetNodeByPath indicates an expected call of GetNodeByPath
+func (mr *MockKBFSOpsMockRecorder) GetNodeByPath(ctx, folderBranch, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeByPath", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeByPath), ctx, folderBranch, p)
+}
+
+// StatByPath mocks base method
+func (m *MockKBFSOps) StatByPath(ctx context.Context, folderBranch FolderBranch, p string) (EntryInfo, error) {
+	ret := m.ctrl.Call(m, "StatByPath", ctx, folderBranch, p)
+	ret0, _ := ret[0].(EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatByPath indicates an expected call of StatByPath
+func (mr *MockKBFSOpsMockRecorder) StatByPath(ctx, folderBranch, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatByPath", reflect.TypeOf((*MockKBFSOps)(nil).StatByPath), ctx, folderBranch, p)
+}
+
 // CreateDir mocks base method
 func (m *MockKBFSOps) CreateDir(ctx context.Context, dir Node, name string) (Node, EntryInfo, error) {
 	ret := m.ctrl.Call(m, "CreateDir", ctx, dir, name)
@@ -1132,6 +1503,32 @@ func (mr *MockKBFSOpsMockRecorder) RemoveEntry(ctx, dir, name interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveEntry", reflect.TypeOf((*MockKBFSOps)(nil).RemoveEntry), ctx, dir, name)
 }
 
+// RemoveAll mocks base method
+func (m *MockKBFSOps) RemoveAll(ctx context.Context, dir Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveAll", ctx, dir, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAll indicates an expected call of RemoveAll
+func (mr *MockKBFSOpsMockRecorder) RemoveAll(ctx, dir, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAll", reflect.TypeOf((*MockKBFSOps)(nil).RemoveAll), ctx, dir, name)
+}
+
+// CopyFile mocks base method
+func (m *MockKBFSOps) CopyFile(ctx context.Context, srcNode Node, dstDir Node, dstName string) (Node, EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CopyFile", ctx, srcNode, dstDir, dstName)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CopyFile indicates an expected call of CopyFile
+func (mr *MockKBFSOpsMockRecorder) CopyFile(ctx, srcNode, dstDir, dstName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFile", reflect.TypeOf((*MockKBFSOps)(nil).CopyFile), ctx, srcNode, dstDir, dstName)
+}
+
 // Rename mocks base method
 func (m *MockKBFSOps) Rename(ctx context.Context, oldParent Node, oldName string, newParent Node, newName string) error {
 	ret := m.ctrl.Call(m, "Rename", ctx, oldParent, oldName, newParent, newName)
@@ -1144,6 +1541,30 @@ func (mr *MockKBFSOpsMockRecorder) Rename(ctx, oldParent, oldName, newParent, ne
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockKBFSOps)(nil).Rename), ctx, oldParent, oldName, newParent, newName)
 }
 
+// RenameWithFlags mocks base method
+func (m *MockKBFSOps) RenameWithFlags(ctx context.Context, oldParent Node, oldName string, newParent Node, newName string, flags RenameFlags) error {
+	ret := m.ctrl.Call(m, "RenameWithFlags", ctx, oldParent, oldName, newParent, newName, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameWithFlags indicates an expected call of RenameWithFlags
+func (mr *MockKBFSOpsMockRecorder) RenameWithFlags(ctx, oldParent, oldName, newParent, newName, flags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameWithFlags", reflect.TypeOf((*MockKBFSOps)(nil).RenameWithFlags), ctx, oldParent, oldName, newParent, newName, flags)
+}
+
+// RenameExchange mocks base method
+func (m *MockKBFSOps) RenameExchange(ctx context.Context, oldParent Node, oldName string, newParent Node, newName string) error {
+	ret := m.ctrl.Call(m, "RenameExchange", ctx, oldParent, oldName, newParent, newName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameExchange indicates an expected call of RenameExchange
+func (mr *MockKBFSOpsMockRecorder) RenameExchange(ctx, oldParent, oldName, newParent, newName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameExchange", reflect.TypeOf((*MockKBFSOps)(nil).RenameExchange), ctx, oldParent, oldName, newParent, newName)
+}
+
 // Read mocks base method
 func (m *MockKBFSOps) Read(ctx context.Context, file Node, dest []byte, off int64) (int64, error) {
 	ret := m.ctrl.Call(m, "Read", ctx, file, dest, off)
@@ -1169,6 +1590,19 @@ func (mr *MockKBFSOpsMockRecorder) Write(ctx, file, data, off interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockKBFSOps)(nil).Write), ctx, file, data, off)
 }
 
+// WriteWithFlags mocks base method
+func (m *MockKBFSOps) WriteWithFlags(ctx context.Context, file Node, data []byte, off int64, flags WriteFlags) (int64, error) {
+	ret := m.ctrl.Call(m, "WriteWithFlags", ctx, file, data, off, flags)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WriteWithFlags indicates an expected call of WriteWithFlags
+func (mr *MockKBFSOpsMockRecorder) WriteWithFlags(ctx, file, data, off, flags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteWithFlags", reflect.TypeOf((*MockKBFSOps)(nil).WriteWithFlags), ctx, file, data, off, flags)
+}
+
 // Truncate mocks base method
 func (m *MockKBFSOps) Truncate(ctx context.Context, file Node, size uint64) error {
 	ret := m.ctrl.Call(m, "Truncate", ctx, file, size)
@@ -1193,6 +1627,18 @@ func (mr *MockKBFSOpsMockRecorder) SetEx(ctx, file, ex interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEx", reflect.TypeOf((*MockKBFSOps)(nil).SetEx), ctx, file, ex)
 }
 
+// SetMode mocks base method
+func (m *MockKBFSOps) SetMode(ctx context.Context, node Node, mode os.FileMode) error {
+	ret := m.ctrl.Call(m, "SetMode", ctx, node, mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMode indicates an expected call of SetMode
+func (mr *MockKBFSOpsMockRecorder) SetMode(ctx, node, mode interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMode", reflect.TypeOf((*MockKBFSOps)(nil).SetMode), ctx, node, mode)
+}
+
 // SetMtime mocks base method
 func (m *MockKBFSOps) SetMtime(ctx context.Context, file Node, mtime *time.Time) error {
 	ret := m.ctrl.Call(m, "SetMtime", ctx, file, mtime)
@@ -1205,6 +1651,56 @@ func (mr *MockKBFSOpsMockRecorder) SetMtime(ctx, file, mtime interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMtime", reflect.TypeOf((*MockKBFSOps)(nil).SetMtime), ctx, file, mtime)
 }
 
+// GetXattr mocks base method
+func (m *MockKBFSOps) GetXattr(ctx context.Context, node Node, name string) ([]byte, error) {
+	ret := m.ctrl.Call(m, "GetXattr", ctx, node, name)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetXattr indicates an expected call of GetXattr
+func (mr *MockKBFSOpsMockRecorder) GetXattr(ctx, node, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetXattr", reflect.TypeOf((*MockKBFSOps)(nil).GetXattr), ctx, node, name)
+}
+
+// ListXattr mocks base method
+func (m *MockKBFSOps) ListXattr(ctx context.Context, node Node) ([]string, error) {
+	ret := m.ctrl.Call(m, "ListXattr", ctx, node)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListXattr indicates an expected call of ListXattr
+func (mr *MockKBFSOpsMockRecorder) ListXattr(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListXattr", reflect.TypeOf((*MockKBFSOps)(nil).ListXattr), ctx, node)
+}
+
+// SetXattr mocks base method
+func (m *MockKBFSOps) SetXattr(ctx context.Context, node Node, name string, value []byte) error {
+	ret := m.ctrl.Call(m, "SetXattr", ctx, node, name, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetXattr indicates an expected call of SetXattr
+func (mr *MockKBFSOpsMockRecorder) SetXattr(ctx, node, name, value interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetXattr", reflect.TypeOf((*MockKBFSOps)(nil).SetXattr), ctx, node, name, value)
+}
+
+// RemoveXattr mocks base method
+func (m *MockKBFSOps) RemoveXattr(ctx context.Context, node Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveXattr", ctx, node, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveXattr indicates an expected call of RemoveXattr
+func (mr *MockKBFSOpsMockRecorder) RemoveXattr(ctx, node, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveXattr", reflect.TypeOf((*MockKBFSOps)(nil).RemoveXattr), ctx, node, name)
+}
+
 // SyncAll mocks base method
 func (m *MockKBFSOps) SyncAll(ctx context.Context, folderBranch FolderBranch) error {
 	ret := m.ctrl.Call(m, "SyncAll", ctx, folderBranch)
@@ -1226,9 +1722,34 @@ func (m *MockKBFSOps) FolderStatus(ctx context.Context, folderBranch FolderBranc
 	return ret0, ret1, ret2
 }
 
-// FolderStatus indicates an expected call of FolderStatus
-func (mr *MockKBFSOpsMockRecorder) FolderStatus(ctx, folderBranch interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FolderStatus", reflect.TypeOf((*MockKBFSOps)(nil).FolderStatus), ctx, folderBranch)
+// FolderStatus indicates an expected call of FolderStatus
+func (mr *MockKBFSOpsMockRecorder) FolderStatus(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FolderStatus", reflect.TypeOf((*MockKBFSOps)(nil).FolderStatus), ctx, folderBranch)
+}
+
+// ClearFolderErrors mocks base method
+func (m *MockKBFSOps) ClearFolderErrors(ctx context.Context, folderBranch FolderBranch) error {
+	ret := m.ctrl.Call(m, "ClearFolderErrors", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearFolderErrors indicates an expected call of ClearFolderErrors
+func (mr *MockKBFSOpsMockRecorder) ClearFolderErrors(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearFolderErrors", reflect.TypeOf((*MockKBFSOps)(nil).ClearFolderErrors), ctx, folderBranch)
+}
+
+// GetQuotaInfo mocks base method
+func (m *MockKBFSOps) GetQuotaInfo(ctx context.Context) (*kbfsblock.QuotaInfo, error) {
+	ret := m.ctrl.Call(m, "GetQuotaInfo", ctx)
+	ret0, _ := ret[0].(*kbfsblock.QuotaInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetQuotaInfo indicates an expected call of GetQuotaInfo
+func (mr *MockKBFSOpsMockRecorder) GetQuotaInfo(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQuotaInfo", reflect.TypeOf((*MockKBFSOps)(nil).GetQuotaInfo), ctx)
 }
 
 // Status mocks base method
@@ -1257,6 +1778,31 @@ func (mr *MockKBFSOpsMockRecorder) UnstageForTesting(ctx, folderBranch interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnstageForTesting", reflect.TypeOf((*MockKBFSOps)(nil).UnstageForTesting), ctx, folderBranch)
 }
 
+// GetUnmergedChanges mocks base method
+func (m *MockKBFSOps) GetUnmergedChanges(ctx context.Context, folderBranch FolderBranch) (UnmergedChanges, error) {
+	ret := m.ctrl.Call(m, "GetUnmergedChanges", ctx, folderBranch)
+	ret0, _ := ret[0].(UnmergedChanges)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnmergedChanges indicates an expected call of GetUnmergedChanges
+func (mr *MockKBFSOpsMockRecorder) GetUnmergedChanges(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnmergedChanges", reflect.TypeOf((*MockKBFSOps)(nil).GetUnmergedChanges), ctx, folderBranch)
+}
+
+// Unstage mocks base method
+func (m *MockKBFSOps) Unstage(ctx context.Context, folderBranch FolderBranch, expected UnmergedChanges) error {
+	ret := m.ctrl.Call(m, "Unstage", ctx, folderBranch, expected)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unstage indicates an expected call of Unstage
+func (mr *MockKBFSOpsMockRecorder) Unstage(ctx, folderBranch, expected interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unstage", reflect.TypeOf((*MockKBFSOps)(nil).Unstage), ctx, folderBranch, expected)
+}
+
 // RequestRekey mocks base method
 func (m *MockKBFSOps) RequestRekey(ctx context.Context, id tlf.ID) {
 	m.ctrl.Call(m, "RequestRekey", ctx, id)
@@ -1305,6 +1851,98 @@ func (mr *MockKBFSOpsMockRecorder) GetEditHistory(ctx, folderBranch interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEditHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetEditHistory), ctx, folderBranch)
 }
 
+// GetUsageBreakdown mocks base method
+func (m *MockKBFSOps) GetUsageBreakdown(ctx context.Context, folderBranch FolderBranch) (UsageBreakdown, error) {
+	ret := m.ctrl.Call(m, "GetUsageBreakdown", ctx, folderBranch)
+	ret0, _ := ret[0].(UsageBreakdown)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsageBreakdown indicates an expected call of GetUsageBreakdown
+func (mr *MockKBFSOpsMockRecorder) GetUsageBreakdown(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsageBreakdown", reflect.TypeOf((*MockKBFSOps)(nil).GetUsageBreakdown), ctx, folderBranch)
+}
+
+// SetAdvisoryLock mocks base method
+func (m *MockKBFSOps) SetAdvisoryLock(ctx context.Context, file Node, lock AdvisoryLock, wait bool) error {
+	ret := m.ctrl.Call(m, "SetAdvisoryLock", ctx, file, lock, wait)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAdvisoryLock indicates an expected call of SetAdvisoryLock
+func (mr *MockKBFSOpsMockRecorder) SetAdvisoryLock(ctx, file, lock, wait interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAdvisoryLock", reflect.TypeOf((*MockKBFSOps)(nil).SetAdvisoryLock), ctx, file, lock, wait)
+}
+
+// GetAdvisoryLock mocks base method
+func (m *MockKBFSOps) GetAdvisoryLock(ctx context.Context, file Node, lock AdvisoryLock) (AdvisoryLock, error) {
+	ret := m.ctrl.Call(m, "GetAdvisoryLock", ctx, file, lock)
+	ret0, _ := ret[0].(AdvisoryLock)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAdvisoryLock indicates an expected call of GetAdvisoryLock
+func (mr *MockKBFSOpsMockRecorder) GetAdvisoryLock(ctx, file, lock interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAdvisoryLock", reflect.TypeOf((*MockKBFSOps)(nil).GetAdvisoryLock), ctx, file, lock)
+}
+
+// CreateTempFile mocks base method
+func (m *MockKBFSOps) CreateTempFile(ctx context.Context, dir Node, isExec bool) (Node, EntryInfo, error) {
+	ret := m.ctrl.Call(m, "CreateTempFile", ctx, dir, isExec)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateTempFile indicates an expected call of CreateTempFile
+func (mr *MockKBFSOpsMockRecorder) CreateTempFile(ctx, dir, isExec interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTempFile", reflect.TypeOf((*MockKBFSOps)(nil).CreateTempFile), ctx, dir, isExec)
+}
+
+// LinkTempFile mocks base method
+func (m *MockKBFSOps) LinkTempFile(ctx context.Context, dir Node, name string, file Node) (EntryInfo, error) {
+	ret := m.ctrl.Call(m, "LinkTempFile", ctx, dir, name, file)
+	ret0, _ := ret[0].(EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LinkTempFile indicates an expected call of LinkTempFile
+func (mr *MockKBFSOpsMockRecorder) LinkTempFile(ctx, dir, name, file interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkTempFile", reflect.TypeOf((*MockKBFSOps)(nil).LinkTempFile), ctx, dir, name, file)
+}
+
+// GetDirChildrenPaged mocks base method
+func (m *MockKBFSOps) GetDirChildrenPaged(ctx context.Context, dir Node, cursor string, maxEntries int) ([]DirChild, string, error) {
+	ret := m.ctrl.Call(m, "GetDirChildrenPaged", ctx, dir, cursor, maxEntries)
+	ret0, _ := ret[0].([]DirChild)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetDirChildrenPaged indicates an expected call of GetDirChildrenPaged
+func (mr *MockKBFSOpsMockRecorder) GetDirChildrenPaged(ctx, dir, cursor, maxEntries interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirChildrenPaged", reflect.TypeOf((*MockKBFSOps)(nil).GetDirChildrenPaged), ctx, dir, cursor, maxEntries)
+}
+
+// GetSubtreeUsage mocks base method
+func (m *MockKBFSOps) GetSubtreeUsage(ctx context.Context, node Node) (SubtreeUsage, error) {
+	ret := m.ctrl.Call(m, "GetSubtreeUsage", ctx, node)
+	ret0, _ := ret[0].(SubtreeUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubtreeUsage indicates an expected call of GetSubtreeUsage
+func (mr *MockKBFSOpsMockRecorder) GetSubtreeUsage(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubtreeUsage", reflect.TypeOf((*MockKBFSOps)(nil).GetSubtreeUsage), ctx, node)
+}
+
 // GetNodeMetadata mocks base method
 func (m *MockKBFSOps) GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error) {
 	ret := m.ctrl.Call(m, "GetNodeMetadata", ctx, node)
@@ -1318,6 +1956,32 @@ func (mr *MockKBFSOpsMockRecorder) GetNodeMetadata(ctx, node interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeMetadata", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeMetadata), ctx, node)
 }
 
+// GetNodeHistory mocks base method
+func (m *MockKBFSOps) GetNodeHistory(ctx context.Context, node Node) ([]NodeRevision, error) {
+	ret := m.ctrl.Call(m, "GetNodeHistory", ctx, node)
+	ret0, _ := ret[0].([]NodeRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNodeHistory indicates an expected call of GetNodeHistory
+func (mr *MockKBFSOpsMockRecorder) GetNodeHistory(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeHistory), ctx, node)
+}
+
+// ReadAtRevision mocks base method
+func (m *MockKBFSOps) ReadAtRevision(ctx context.Context, file Node, rev kbfsmd.Revision, dest []byte, off int64) (int64, error) {
+	ret := m.ctrl.Call(m, "ReadAtRevision", ctx, file, rev, dest, off)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAtRevision indicates an expected call of ReadAtRevision
+func (mr *MockKBFSOpsMockRecorder) ReadAtRevision(ctx, file, rev, dest, off interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAtRevision", reflect.TypeOf((*MockKBFSOps)(nil).ReadAtRevision), ctx, file, rev, dest, off)
+}
+
 // Shutdown mocks base method
 func (m *MockKBFSOps) Shutdown(ctx context.Context) error {
 	ret := m.ctrl.Call(m, "Shutdown", ctx)
@@ -1360,6 +2024,31 @@ func (mr *MockKBFSOpsMockRecorder) ClearPrivateFolderMD(ctx interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearPrivateFolderMD", reflect.TypeOf((*MockKBFSOps)(nil).ClearPrivateFolderMD), ctx)
 }
 
+// ResetForUser mocks base method
+func (m *MockKBFSOps) ResetForUser(ctx context.Context, uid keybase1.UID) bool {
+	ret := m.ctrl.Call(m, "ResetForUser", ctx, uid)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// ResetForUser indicates an expected call of ResetForUser
+func (mr *MockKBFSOpsMockRecorder) ResetForUser(ctx, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetForUser", reflect.TypeOf((*MockKBFSOps)(nil).ResetForUser), ctx, uid)
+}
+
+// AuditLocalPlaintext mocks base method
+func (m *MockKBFSOps) AuditLocalPlaintext(ctx context.Context) ([]LocalPlaintextLeak, error) {
+	ret := m.ctrl.Call(m, "AuditLocalPlaintext", ctx)
+	ret0, _ := ret[0].([]LocalPlaintextLeak)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuditLocalPlaintext indicates an expected call of AuditLocalPlaintext
+func (mr *MockKBFSOpsMockRecorder) AuditLocalPlaintext(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditLocalPlaintext", reflect.TypeOf((*MockKBFSOps)(nil).AuditLocalPlaintext), ctx)
+}
+
 // ForceFastForward mocks base method
 func (m *MockKBFSOps) ForceFastForward(ctx context.Context) {
 	m.ctrl.Call(m, "ForceFastForward", ctx)
@@ -1710,9 +2399,9 @@ func (mr *MockKeybaseServiceMockRecorder) FavoriteDelete(ctx, folder interface{}
 }
 
 // FavoriteList mocks base method
-func (m *MockKeybaseService) FavoriteList(ctx context.Context, sessionID int) ([]keybase1.Folder, error) {
+func (m *MockKeybaseService) FavoriteList(ctx context.Context, sessionID int) (keybase1.FavoritesResult, error) {
 	ret := m.ctrl.Call(m, "FavoriteList", ctx, sessionID)
-	ret0, _ := ret[0].([]keybase1.Folder)
+	ret0, _ := ret[0].(keybase1.FavoritesResult)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -2458,9 +3147,9 @@ func (mr *MockKBPKIMockRecorder) FavoriteDelete(ctx, folder interface{}) *gomock
 }
 
 // FavoriteList mocks base method
-func (m *MockKBPKI) FavoriteList(ctx context.Context) ([]keybase1.Folder, error) {
+func (m *MockKBPKI) FavoriteList(ctx context.Context) (keybase1.FavoritesResult, error) {
 	ret := m.ctrl.Call(m, "FavoriteList", ctx)
-	ret0, _ := ret[0].([]keybase1.Folder)
+	ret0, _ := ret[0].(keybase1.FavoritesResult)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -5709,6 +6398,18 @@ func (mr *MockNotifierMockRecorder) RegisterForChanges(folderBranches, obs inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterForChanges", reflect.TypeOf((*MockNotifier)(nil).RegisterForChanges), folderBranches, obs)
 }
 
+// RegisterForChangesWithFilter mocks base method
+func (m *MockNotifier) RegisterForChangesWithFilter(folderBranches []FolderBranch, obs Observer, filter ObserverFilter) error {
+	ret := m.ctrl.Call(m, "RegisterForChangesWithFilter", folderBranches, obs, filter)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RegisterForChangesWithFilter indicates an expected call of RegisterForChangesWithFilter
+func (mr *MockNotifierMockRecorder) RegisterForChangesWithFilter(folderBranches, obs, filter interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterForChangesWithFilter", reflect.TypeOf((*MockNotifier)(nil).RegisterForChangesWithFilter), folderBranches, obs, filter)
+}
+
 // UnregisterFromChanges mocks base method
 func (m *MockNotifier) UnregisterFromChanges(folderBranches []FolderBranch, obs Observer) error {
 	ret := m.ctrl.Call(m, "UnregisterFromChanges", folderBranches, obs)
@@ -5721,6 +6422,26 @@ func (mr *MockNotifierMockRecorder) UnregisterFromChanges(folderBranches, obs in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnregisterFromChanges", reflect.TypeOf((*MockNotifier)(nil).UnregisterFromChanges), folderBranches, obs)
 }
 
+// RegisterForConnectionChanges mocks base method
+func (m *MockNotifier) RegisterForConnectionChanges(obs ConnectionObserver) {
+	m.ctrl.Call(m, "RegisterForConnectionChanges", obs)
+}
+
+// RegisterForConnectionChanges indicates an expected call of RegisterForConnectionChanges
+func (mr *MockNotifierMockRecorder) RegisterForConnectionChanges(obs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterForConnectionChanges", reflect.TypeOf((*MockNotifier)(nil).RegisterForConnectionChanges), obs)
+}
+
+// UnregisterFromConnectionChanges mocks base method
+func (m *MockNotifier) UnregisterFromConnectionChanges(obs ConnectionObserver) {
+	m.ctrl.Call(m, "UnregisterFromConnectionChanges", obs)
+}
+
+// UnregisterFromConnectionChanges indicates an expected call of UnregisterFromConnectionChanges
+func (mr *MockNotifierMockRecorder) UnregisterFromConnectionChanges(obs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnregisterFromConnectionChanges", reflect.TypeOf((*MockNotifier)(nil).UnregisterFromConnectionChanges), obs)
+}
+
 // MockClock is a mock of Clock interface
 type MockClock struct {
 	ctrl     *gomock.Controller
@@ -6362,6 +7083,30 @@ func (mr *MockConfigMockRecorder) SetTlfSyncState(tlfID, isSynced interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfSyncState", reflect.TypeOf((*MockConfig)(nil).SetTlfSyncState), tlfID, isSynced)
 }
 
+// TlfExcludePatterns mocks base method
+func (m *MockConfig) TlfExcludePatterns(tlfID tlf.ID) *ExcludePatterns {
+	ret := m.ctrl.Call(m, "TlfExcludePatterns", tlfID)
+	ret0, _ := ret[0].(*ExcludePatterns)
+	return ret0
+}
+
+// TlfExcludePatterns indicates an expected call of TlfExcludePatterns
+func (mr *MockConfigMockRecorder) TlfExcludePatterns(tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TlfExcludePatterns", reflect.TypeOf((*MockConfig)(nil).TlfExcludePatterns), tlfID)
+}
+
+// SetTlfExcludePatterns mocks base method
+func (m *MockConfig) SetTlfExcludePatterns(tlfID tlf.ID, patterns []string) error {
+	ret := m.ctrl.Call(m, "SetTlfExcludePatterns", tlfID, patterns)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTlfExcludePatterns indicates an expected call of SetTlfExcludePatterns
+func (mr *MockConfigMockRecorder) SetTlfExcludePatterns(tlfID, patterns interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfExcludePatterns", reflect.TypeOf((*MockConfig)(nil).SetTlfExcludePatterns), tlfID, patterns)
+}
+
 // Mode mocks base method
 func (m *MockConfig) Mode() InitMode {
 	ret := m.ctrl.Call(m, "Mode")
@@ -6386,6 +7131,54 @@ func (mr *MockConfigMockRecorder) IsTestMode() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTestMode", reflect.TypeOf((*MockConfig)(nil).IsTestMode))
 }
 
+// NetworkMode mocks base method
+func (m *MockConfig) NetworkMode() NetworkMode {
+	ret := m.ctrl.Call(m, "NetworkMode")
+	ret0, _ := ret[0].(NetworkMode)
+	return ret0
+}
+
+// NetworkMode indicates an expected call of NetworkMode
+func (mr *MockConfigMockRecorder) NetworkMode() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkMode", reflect.TypeOf((*MockConfig)(nil).NetworkMode))
+}
+
+// NetworkConstraints mocks base method
+func (m *MockConfig) NetworkConstraints() NetworkConstraints {
+	ret := m.ctrl.Call(m, "NetworkConstraints")
+	ret0, _ := ret[0].(NetworkConstraints)
+	return ret0
+}
+
+// NetworkConstraints indicates an expected call of NetworkConstraints
+func (mr *MockConfigMockRecorder) NetworkConstraints() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkConstraints", reflect.TypeOf((*MockConfig)(nil).NetworkConstraints))
+}
+
+// RetryPolicy mocks base method
+func (m *MockConfig) RetryPolicy() RetryPolicy {
+	ret := m.ctrl.Call(m, "RetryPolicy")
+	ret0, _ := ret[0].(RetryPolicy)
+	return ret0
+}
+
+// RetryPolicy indicates an expected call of RetryPolicy
+func (mr *MockConfigMockRecorder) RetryPolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryPolicy", reflect.TypeOf((*MockConfig)(nil).RetryPolicy))
+}
+
+// RedactLogPath mocks base method
+func (m *MockConfig) RedactLogPath(p string) string {
+	ret := m.ctrl.Call(m, "RedactLogPath", p)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// RedactLogPath indicates an expected call of RedactLogPath
+func (mr *MockConfigMockRecorder) RedactLogPath(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedactLogPath", reflect.TypeOf((*MockConfig)(nil).RedactLogPath), p)
+}
+
 // MaybeStartTrace mocks base method
 func (m *MockConfig) MaybeStartTrace(ctx context.Context, family, title string) context.Context {
 	ret := m.ctrl.Call(m, "MaybeStartTrace", ctx, family, title)
@@ -6788,6 +7581,30 @@ func (mr *MockConfigMockRecorder) SetBlockSplitter(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBlockSplitter", reflect.TypeOf((*MockConfig)(nil).SetBlockSplitter), arg0)
 }
 
+// BlockSplitPolicy mocks base method
+func (m *MockConfig) BlockSplitPolicy() BlockSplitPolicy {
+	ret := m.ctrl.Call(m, "BlockSplitPolicy")
+	ret0, _ := ret[0].(BlockSplitPolicy)
+	return ret0
+}
+
+// BlockSplitPolicy indicates an expected call of BlockSplitPolicy
+func (mr *MockConfigMockRecorder) BlockSplitPolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockSplitPolicy", reflect.TypeOf((*MockConfig)(nil).BlockSplitPolicy))
+}
+
+// SetBlockSplitPolicy mocks base method
+func (m *MockConfig) SetBlockSplitPolicy(arg0 BlockSplitPolicy) error {
+	ret := m.ctrl.Call(m, "SetBlockSplitPolicy", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBlockSplitPolicy indicates an expected call of SetBlockSplitPolicy
+func (mr *MockConfigMockRecorder) SetBlockSplitPolicy(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBlockSplitPolicy", reflect.TypeOf((*MockConfig)(nil).SetBlockSplitPolicy), arg0)
+}
+
 // Notifier mocks base method
 func (m *MockConfig) Notifier() Notifier {
 	ret := m.ctrl.Call(m, "Notifier")
@@ -7178,6 +7995,240 @@ func (mr *MockConfigMockRecorder) SetBGFlushPeriod(p interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBGFlushPeriod", reflect.TypeOf((*MockConfig)(nil).SetBGFlushPeriod), p)
 }
 
+// OpTimeouts mocks base method
+func (m *MockConfig) OpTimeouts() OpTimeoutPolicy {
+	ret := m.ctrl.Call(m, "OpTimeouts")
+	ret0, _ := ret[0].(OpTimeoutPolicy)
+	return ret0
+}
+
+// OpTimeouts indicates an expected call of OpTimeouts
+func (mr *MockConfigMockRecorder) OpTimeouts() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpTimeouts", reflect.TypeOf((*MockConfig)(nil).OpTimeouts))
+}
+
+// SetOpTimeouts mocks base method
+func (m *MockConfig) SetOpTimeouts(p OpTimeoutPolicy) {
+	m.ctrl.Call(m, "SetOpTimeouts", p)
+}
+
+// SetOpTimeouts indicates an expected call of SetOpTimeouts
+func (mr *MockConfigMockRecorder) SetOpTimeouts(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetOpTimeouts", reflect.TypeOf((*MockConfig)(nil).SetOpTimeouts), p)
+}
+
+// SetRetryPolicy mocks base method
+func (m *MockConfig) SetRetryPolicy(p RetryPolicy) {
+	m.ctrl.Call(m, "SetRetryPolicy", p)
+}
+
+// SetRetryPolicy indicates an expected call of SetRetryPolicy
+func (mr *MockConfigMockRecorder) SetRetryPolicy(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRetryPolicy", reflect.TypeOf((*MockConfig)(nil).SetRetryPolicy), p)
+}
+
+// FrontendCapabilities mocks base method
+func (m *MockConfig) FrontendCapabilities(frontend RequestFrontend) OpCapabilities {
+	ret := m.ctrl.Call(m, "FrontendCapabilities", frontend)
+	ret0, _ := ret[0].(OpCapabilities)
+	return ret0
+}
+
+// FrontendCapabilities indicates an expected call of FrontendCapabilities
+func (mr *MockConfigMockRecorder) FrontendCapabilities(frontend interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FrontendCapabilities", reflect.TypeOf((*MockConfig)(nil).FrontendCapabilities), frontend)
+}
+
+// SetFrontendCapabilities mocks base method
+func (m *MockConfig) SetFrontendCapabilities(frontend RequestFrontend, caps OpCapabilities) {
+	m.ctrl.Call(m, "SetFrontendCapabilities", frontend, caps)
+}
+
+// SetFrontendCapabilities indicates an expected call of SetFrontendCapabilities
+func (mr *MockConfigMockRecorder) SetFrontendCapabilities(frontend, caps interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFrontendCapabilities", reflect.TypeOf((*MockConfig)(nil).SetFrontendCapabilities), frontend, caps)
+}
+
+// MaxSymlinkLevels mocks base method
+func (m *MockConfig) MaxSymlinkLevels() int {
+	ret := m.ctrl.Call(m, "MaxSymlinkLevels")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// MaxSymlinkLevels indicates an expected call of MaxSymlinkLevels
+func (mr *MockConfigMockRecorder) MaxSymlinkLevels() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MaxSymlinkLevels", reflect.TypeOf((*MockConfig)(nil).MaxSymlinkLevels))
+}
+
+// SetMaxSymlinkLevels mocks base method
+func (m *MockConfig) SetMaxSymlinkLevels(levels int) {
+	m.ctrl.Call(m, "SetMaxSymlinkLevels", levels)
+}
+
+// SetMaxSymlinkLevels indicates an expected call of SetMaxSymlinkLevels
+func (mr *MockConfigMockRecorder) SetMaxSymlinkLevels(levels interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMaxSymlinkLevels", reflect.TypeOf((*MockConfig)(nil).SetMaxSymlinkLevels), levels)
+}
+
+// DirListingWorkers mocks base method
+func (m *MockConfig) DirListingWorkers() int {
+	ret := m.ctrl.Call(m, "DirListingWorkers")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// DirListingWorkers indicates an expected call of DirListingWorkers
+func (mr *MockConfigMockRecorder) DirListingWorkers() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DirListingWorkers", reflect.TypeOf((*MockConfig)(nil).DirListingWorkers))
+}
+
+// SetDirListingWorkers mocks base method
+func (m *MockConfig) SetDirListingWorkers(n int) {
+	m.ctrl.Call(m, "SetDirListingWorkers", n)
+}
+
+// SetDirListingWorkers indicates an expected call of SetDirListingWorkers
+func (mr *MockConfigMockRecorder) SetDirListingWorkers(n interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDirListingWorkers", reflect.TypeOf((*MockConfig)(nil).SetDirListingWorkers), n)
+}
+
+// ReadAhead mocks base method
+func (m *MockConfig) ReadAhead() ReadAheadParams {
+	ret := m.ctrl.Call(m, "ReadAhead")
+	ret0, _ := ret[0].(ReadAheadParams)
+	return ret0
+}
+
+// ReadAhead indicates an expected call of ReadAhead
+func (mr *MockConfigMockRecorder) ReadAhead() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAhead", reflect.TypeOf((*MockConfig)(nil).ReadAhead))
+}
+
+// SetReadAhead mocks base method
+func (m *MockConfig) SetReadAhead(p ReadAheadParams) {
+	m.ctrl.Call(m, "SetReadAhead", p)
+}
+
+// SetReadAhead indicates an expected call of SetReadAhead
+func (mr *MockConfigMockRecorder) SetReadAhead(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetReadAhead", reflect.TypeOf((*MockConfig)(nil).SetReadAhead), p)
+}
+
+// RemoteChangePolicy mocks base method
+func (m *MockConfig) RemoteChangePolicy() RemoteChangePolicy {
+	ret := m.ctrl.Call(m, "RemoteChangePolicy")
+	ret0, _ := ret[0].(RemoteChangePolicy)
+	return ret0
+}
+
+// RemoteChangePolicy indicates an expected call of RemoteChangePolicy
+func (mr *MockConfigMockRecorder) RemoteChangePolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoteChangePolicy", reflect.TypeOf((*MockConfig)(nil).RemoteChangePolicy))
+}
+
+// SetRemoteChangePolicy mocks base method
+func (m *MockConfig) SetRemoteChangePolicy(p RemoteChangePolicy) {
+	m.ctrl.Call(m, "SetRemoteChangePolicy", p)
+}
+
+// SetRemoteChangePolicy indicates an expected call of SetRemoteChangePolicy
+func (mr *MockConfigMockRecorder) SetRemoteChangePolicy(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRemoteChangePolicy", reflect.TypeOf((*MockConfig)(nil).SetRemoteChangePolicy), p)
+}
+
+// SetNetworkMode mocks base method
+func (m *MockConfig) SetNetworkMode(ctx context.Context, mode NetworkMode) error {
+	ret := m.ctrl.Call(m, "SetNetworkMode", ctx, mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetNetworkMode indicates an expected call of SetNetworkMode
+func (mr *MockConfigMockRecorder) SetNetworkMode(ctx, mode interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNetworkMode", reflect.TypeOf((*MockConfig)(nil).SetNetworkMode), ctx, mode)
+}
+
+// SetNetworkConstraints mocks base method
+func (m *MockConfig) SetNetworkConstraints(metered bool, maxRate int64) {
+	m.ctrl.Call(m, "SetNetworkConstraints", metered, maxRate)
+}
+
+// SetNetworkConstraints indicates an expected call of SetNetworkConstraints
+func (mr *MockConfigMockRecorder) SetNetworkConstraints(metered, maxRate interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNetworkConstraints", reflect.TypeOf((*MockConfig)(nil).SetNetworkConstraints), metered, maxRate)
+}
+
+// Dialer mocks base method
+func (m *MockConfig) Dialer() Dialer {
+	ret := m.ctrl.Call(m, "Dialer")
+	ret0, _ := ret[0].(Dialer)
+	return ret0
+}
+
+// Dialer indicates an expected call of Dialer
+func (mr *MockConfigMockRecorder) Dialer() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dialer", reflect.TypeOf((*MockConfig)(nil).Dialer))
+}
+
+// SetDialer mocks base method
+func (m *MockConfig) SetDialer(arg0 Dialer) {
+	m.ctrl.Call(m, "SetDialer", arg0)
+}
+
+// SetDialer indicates an expected call of SetDialer
+func (mr *MockConfigMockRecorder) SetDialer(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDialer", reflect.TypeOf((*MockConfig)(nil).SetDialer), arg0)
+}
+
+// WipeLocalData mocks base method
+func (m *MockConfig) WipeLocalData(ctx context.Context, progress func(LocalDataWipeProgress)) error {
+	ret := m.ctrl.Call(m, "WipeLocalData", ctx, progress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WipeLocalData indicates an expected call of WipeLocalData
+func (mr *MockConfigMockRecorder) WipeLocalData(ctx, progress interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WipeLocalData", reflect.TypeOf((*MockConfig)(nil).WipeLocalData), ctx, progress)
+}
+
+// LogPathsMode mocks base method
+func (m *MockConfig) LogPathsMode() LogPathsMode {
+	ret := m.ctrl.Call(m, "LogPathsMode")
+	ret0, _ := ret[0].(LogPathsMode)
+	return ret0
+}
+
+// LogPathsMode indicates an expected call of LogPathsMode
+func (mr *MockConfigMockRecorder) LogPathsMode() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogPathsMode", reflect.TypeOf((*MockConfig)(nil).LogPathsMode))
+}
+
+// SetLogPathsMode mocks base method
+func (m *MockConfig) SetLogPathsMode(mode LogPathsMode) error {
+	ret := m.ctrl.Call(m, "SetLogPathsMode", mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogPathsMode indicates an expected call of SetLogPathsMode
+func (mr *MockConfigMockRecorder) SetLogPathsMode(mode interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogPathsMode", reflect.TypeOf((*MockConfig)(nil).SetLogPathsMode), mode)
+}
+
+// GenerateDebugBundle mocks base method
+func (m *MockConfig) GenerateDebugBundle(ctx context.Context, w io.Writer) error {
+	ret := m.ctrl.Call(m, "GenerateDebugBundle", ctx, w)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GenerateDebugBundle indicates an expected call of GenerateDebugBundle
+func (mr *MockConfigMockRecorder) GenerateDebugBundle(ctx, w interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateDebugBundle", reflect.TypeOf((*MockConfig)(nil).GenerateDebugBundle), ctx, w)
+}
+
 // Shutdown mocks base method
 func (m *MockConfig) Shutdown(arg0 context.Context) error {
 	ret := m.ctrl.Call(m, "Shutdown", arg0)
@@ -7369,6 +8420,18 @@ func (mr *MockNodeCacheMockRecorder) AllNodes() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllNodes", reflect.TypeOf((*MockNodeCache)(nil).AllNodes))
 }
 
+// NumNodes mocks base method
+func (m *MockNodeCache) NumNodes() int {
+	ret := m.ctrl.Call(m, "NumNodes")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// NumNodes indicates an expected call of NumNodes
+func (mr *MockNodeCacheMockRecorder) NumNodes() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NumNodes", reflect.TypeOf((*MockNodeCache)(nil).NumNodes))
+}
+
 // AddRootWrapper mocks base method
 func (m *MockNodeCache) AddRootWrapper(arg0 func(Node) Node) {
 	m.ctrl.Call(m, "AddRootWrapper", arg0)
@@ -356,6 +356,13 @@ func (ncs *nodeCacheStandard) AllNodes() []Node {
 	return nodes
 }
 
+// NumNodes implements the NodeCache interface for nodeCacheStandard.
+func (ncs *nodeCacheStandard) NumNodes() int {
+	ncs.lock.RLock()
+	defer ncs.lock.RUnlock()
+	return len(ncs.nodes)
+}
+
 func (ncs *nodeCacheStandard) AddRootWrapper(f func(Node) Node) {
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
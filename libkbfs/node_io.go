@@ -0,0 +1,276 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// NodeIOBufferSize is how much data a NodeIO collects from
+// sequential writes before passing it on to KBFSOps.
+const NodeIOBufferSize = 512 * 1024
+
+// NodeIO adapts a file Node to the standard io interfaces, so that
+// other Go code can, for example, io.Copy into and out of KBFS files.
+// It is safe for concurrent use, although concurrent Read, Write and
+// Seek calls share a single offset, as with an os.File.
+//
+// Data written with Write is buffered, and only handed to KBFSOps
+// once NodeIOBufferSize bytes have accumulated, or when the NodeIO
+// is flushed, synced or closed, or is used in a way that needs the
+// data to be in place.  Written data is only guaranteed to reach the
+// servers once Sync or Close returns successfully.
+type NodeIO struct {
+	ctx     context.Context
+	kbfsOps KBFSOps
+	node    Node
+	bufSize int
+
+	lock   sync.Mutex
+	offset int64
+	// buf holds the data from Write calls that hasn't been passed to
+	// KBFSOps yet, which starts at bufOffset in the file.
+	buf       []byte
+	bufOffset int64
+	dirty     bool
+	closed    bool
+}
+
+var _ io.ReaderAt = (*NodeIO)(nil)
+var _ io.WriterAt = (*NodeIO)(nil)
+var _ io.ReadWriteSeeker = (*NodeIO)(nil)
+var _ io.Closer = (*NodeIO)(nil)
+
+// NewNodeIO returns a NodeIO for the given file node, with its offset
+// at the start of the file.  All calls on it are made with ctx.
+func NewNodeIO(ctx context.Context, kbfsOps KBFSOps, node Node) *NodeIO {
+	return &NodeIO{
+		ctx:     ctx,
+		kbfsOps: kbfsOps,
+		node:    node,
+		bufSize: NodeIOBufferSize,
+	}
+}
+
+func (nio *NodeIO) checkClosedLocked() error {
+	if nio.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (nio *NodeIO) flushLocked() error {
+	if len(nio.buf) == 0 {
+		return nil
+	}
+	err := nio.kbfsOps.Write(nio.ctx, nio.node, nio.buf, nio.bufOffset)
+	if err != nil {
+		return err
+	}
+	nio.buf = nio.buf[:0]
+	return nil
+}
+
+func (nio *NodeIO) readAtLocked(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		readBytes, err := nio.kbfsOps.Read(nio.ctx, nio.node, p[n:], off)
+		if err != nil {
+			return n, err
+		}
+		if readBytes == 0 {
+			return n, io.EOF
+		}
+		n += int(readBytes)
+		off += readBytes
+	}
+	return n, nil
+}
+
+// ReadAt implements the io.ReaderAt interface for NodeIO.
+func (nio *NodeIO) ReadAt(p []byte, off int64) (n int, err error) {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.Errorf("Cannot read at offset %d", off)
+	}
+	err = nio.flushLocked()
+	if err != nil {
+		return 0, err
+	}
+	return nio.readAtLocked(p, off)
+}
+
+// WriteAt implements the io.WriterAt interface for NodeIO.  Unlike
+// Write, it isn't buffered.
+func (nio *NodeIO) WriteAt(p []byte, off int64) (n int, err error) {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.Errorf("Cannot write at offset %d", off)
+	}
+	err = nio.flushLocked()
+	if err != nil {
+		return 0, err
+	}
+	err = nio.kbfsOps.Write(nio.ctx, nio.node, p, off)
+	if err != nil {
+		return 0, err
+	}
+	nio.dirty = true
+	return len(p), nil
+}
+
+// Read implements the io.Reader interface for NodeIO.
+func (nio *NodeIO) Read(p []byte) (n int, err error) {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err = nio.flushLocked()
+	if err != nil {
+		return 0, err
+	}
+	readBytes, err := nio.kbfsOps.Read(nio.ctx, nio.node, p, nio.offset)
+	if err != nil {
+		return 0, err
+	}
+	if readBytes == 0 {
+		return 0, io.EOF
+	}
+	nio.offset += readBytes
+	return int(readBytes), nil
+}
+
+// Write implements the io.Writer interface for NodeIO.
+func (nio *NodeIO) Write(p []byte) (n int, err error) {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return 0, err
+	}
+	if len(nio.buf) > 0 && nio.bufOffset+int64(len(nio.buf)) != nio.offset {
+		// This write doesn't continue the buffered data.
+		err = nio.flushLocked()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(nio.buf) == 0 {
+		nio.bufOffset = nio.offset
+	}
+	nio.buf = append(nio.buf, p...)
+	nio.offset += int64(len(p))
+	nio.dirty = true
+	if len(nio.buf) >= nio.bufSize {
+		err = nio.flushLocked()
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Seek implements the io.Seeker interface for NodeIO.
+func (nio *NodeIO) Seek(offset int64, whence int) (int64, error) {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return 0, err
+	}
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset = nio.offset + offset
+	case io.SeekEnd:
+		// The size has to include any buffered data.
+		err := nio.flushLocked()
+		if err != nil {
+			return 0, err
+		}
+		ei, err := nio.kbfsOps.Stat(nio.ctx, nio.node)
+		if err != nil {
+			return 0, err
+		}
+		newOffset = int64(ei.Size) + offset
+	default:
+		return 0, errors.Errorf("Unknown whence %d", whence)
+	}
+	if newOffset < 0 {
+		return 0, errors.Errorf("Cannot seek to offset %d", newOffset)
+	}
+	nio.offset = newOffset
+	return newOffset, nil
+}
+
+// Flush passes any buffered data on to KBFSOps, without waiting for
+// it to reach the servers.
+func (nio *NodeIO) Flush() error {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return err
+	}
+	return nio.flushLocked()
+}
+
+func (nio *NodeIO) syncLocked() error {
+	err := nio.flushLocked()
+	if err != nil {
+		return err
+	}
+	if !nio.dirty {
+		return nil
+	}
+	err = nio.kbfsOps.SyncAll(nio.ctx, nio.node.GetFolderBranch())
+	if err != nil {
+		return err
+	}
+	nio.dirty = false
+	return nil
+}
+
+// Sync flushes any buffered data, and then waits for everything
+// written through nio to reach the servers.
+func (nio *NodeIO) Sync() error {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return err
+	}
+	return nio.syncLocked()
+}
+
+// Close implements the io.Closer interface for NodeIO.  It syncs
+// anything written through nio; if that fails, nio stays open so
+// that the caller can try again.
+func (nio *NodeIO) Close() error {
+	nio.lock.Lock()
+	defer nio.lock.Unlock()
+	if err := nio.checkClosedLocked(); err != nil {
+		return err
+	}
+	err := nio.syncLocked()
+	if err != nil {
+		return err
+	}
+	nio.closed = true
+	return nil
+}
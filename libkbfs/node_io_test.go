@@ -0,0 +1,86 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeIO(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	fb := rootNode.GetFolderBranch()
+
+	t.Log("Copy in more data than fits in the buffer")
+	nio := NewNodeIO(ctx, kbfsOps, fileNode)
+	nio.bufSize = 1024
+	data := make([]byte, 2*nio.bufSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	n, err := io.Copy(nio, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	t.Log("Reads see the buffered data")
+	buf := make([]byte, 10)
+	_, err = nio.ReadAt(buf, int64(len(data))-10)
+	require.NoError(t, err)
+	require.Equal(t, data[len(data)-10:], buf)
+	_, err = nio.ReadAt(buf, int64(len(data))-5)
+	require.Equal(t, io.EOF, err)
+
+	t.Log("WriteAt doesn't move the offset")
+	_, err = nio.WriteAt([]byte("hello"), 5)
+	require.NoError(t, err)
+	copy(data[5:], "hello")
+	_, err = nio.Write([]byte("bye"))
+	require.NoError(t, err)
+	data = append(data, "bye"...)
+
+	off, err := nio.Seek(-3, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data))-3, off)
+	_, err = nio.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	readData, err := ioutil.ReadAll(nio)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, readData))
+
+	t.Log("Closing syncs the file")
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.NotEmpty(t, status.DirtyPaths)
+	err = nio.Close()
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, status.DirtyPaths, 0)
+	_, err = nio.Read(buf)
+	require.Equal(t, os.ErrClosed, err)
+
+	t.Log("A new NodeIO reads the synced data")
+	nio = NewNodeIO(ctx, kbfsOps, fileNode)
+	var out bytes.Buffer
+	_, err = io.Copy(&out, nio)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, out.Bytes()))
+	err = nio.Close()
+	require.NoError(t, err)
+}
@@ -5,46 +5,339 @@
 package libkbfs
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 )
 
+// pendingLocalChange holds the coalesced local writes to one node.
+type pendingLocalChange struct {
+	node   Node
+	writes []WriteRange
+}
+
+// observerEntry is a registered observer, along with its filter and
+// any notifications being coalesced for it.
+type observerEntry struct {
+	obs    Observer
+	filter ObserverFilter
+
+	// deliverLock serializes deliveries of coalesced notifications,
+	// so they reach the observer in order.
+	deliverLock sync.Mutex
+
+	lock sync.Mutex // protects everything below
+	// lastSeqno is the last batch sequence number covered by a
+	// delivery to obs.
+	lastSeqno uint64
+	removed   bool
+	timer     *time.Timer
+	// The notifications waiting for the coalescing window to end.
+	pendingLocal    []pendingLocalChange
+	pendingChanges  []NodeChange
+	pendingAffected []NodeID
+	pendingBatch    bool
+	// changeIndex maps each node to its entry in pendingChanges, and
+	// affectedSeen tracks the node IDs in pendingAffected.
+	changeIndex  map[NodeID]int
+	affectedSeen map[NodeID]bool
+	// pendingSeqno is the last batch sequence number merged into
+	// the pending notifications.
+	pendingSeqno uint64
+	// pendingBGSync is true while all the pending batches came from
+	// background syncs.
+	pendingBGSync bool
+}
+
 // observerList is a thread-safe list of observers.
 type observerList struct {
+	// nodeCache is used to find the paths of changed nodes when
+	// matching them against observer filters.  It may be nil, in
+	// which case path-based filters never match.
+	nodeCache NodeCache
+	// seqno is the sequence number of the last batch of changes,
+	// accessed atomically.
+	seqno uint64
+
 	lock      sync.RWMutex
-	observers []Observer
+	observers []*observerEntry
 }
 
-func newObserverList() *observerList {
-	return &observerList{}
+func newObserverList(nodeCache NodeCache) *observerList {
+	return &observerList{
+		nodeCache: nodeCache,
+	}
 }
 
 // It's the caller's responsibility to make sure add isn't called
 // twice for the same Observer.
 func (ol *observerList) add(o Observer) {
+	ol.addWithFilter(o, ObserverFilter{})
+}
+
+// addWithFilter is like add, but `o` will only be notified about
+// changes matching `filter`, and they may be coalesced as
+// `filter.CoalesceWindow` specifies.
+func (ol *observerList) addWithFilter(o Observer, filter ObserverFilter) {
 	ol.lock.Lock()
 	defer ol.lock.Unlock()
-	ol.observers = append(ol.observers, o)
+	ol.observers = append(ol.observers, &observerEntry{
+		obs:       o,
+		filter:    filter,
+		lastSeqno: atomic.LoadUint64(&ol.seqno),
+	})
 }
 
 func (ol *observerList) remove(o Observer) {
 	ol.lock.Lock()
 	defer ol.lock.Unlock()
-	for i, oldObs := range ol.observers {
-		if oldObs == o {
+	for i, e := range ol.observers {
+		if e.obs == o {
 			ol.observers = append(ol.observers[:i], ol.observers[i+1:]...)
+			e.lock.Lock()
+			defer e.lock.Unlock()
+			// Drop anything still being coalesced.
+			e.removed = true
+			if e.timer != nil {
+				e.timer.Stop()
+				e.timer = nil
+			}
 			return
 		}
 	}
 }
 
+// empty returns whether no observers are registered, other than
+// those for which `ignore` returns true.
+func (ol *observerList) empty(ignore func(Observer) bool) bool {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+	for _, e := range ol.observers {
+		if !ignore(e.obs) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathNames returns the names of the nodes on the path to `node`,
+// excluding the TLF root.
+func (ol *observerList) pathNames(node Node) []string {
+	p := ol.nodeCache.PathFromNode(node)
+	if len(p.path) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(p.path)-1)
+	for _, pn := range p.path[1:] {
+		names = append(names, pn.Name)
+	}
+	return names
+}
+
+// matches returns whether a change of type `t` to `node` should be
+// delivered to an observer with `filter`.
+func (ol *observerList) matches(
+	filter ObserverFilter, node Node, t NodeChangeType) bool {
+	if t != 0 && filter.Types != 0 && t&filter.Types == 0 {
+		return false
+	}
+	if filter.PathPrefix == "" && filter.Root == nil {
+		return true
+	}
+	if node == nil || ol.nodeCache == nil {
+		return false
+	}
+
+	if filter.PathPrefix != "" {
+		prefix := strings.Trim(filter.PathPrefix, "/")
+		p := strings.Join(ol.pathNames(node), "/")
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+
+	if filter.Root != nil {
+		if node.GetID() == filter.Root.GetID() {
+			return true
+		}
+		if !filter.Recursive {
+			return false
+		}
+		rootPath := ol.nodeCache.PathFromNode(filter.Root)
+		p := ol.nodeCache.PathFromNode(node)
+		if !rootPath.isValid() || len(p.path) <= len(rootPath.path) {
+			return false
+		}
+		i := len(rootPath.path) - 1
+		return p.path[i].BlockPointer == rootPath.tailPointer()
+	}
+	return true
+}
+
+// rootAffected returns whether the pointers of the root of `filter`
+// were updated, which is all that's reported for local syncs,
+// including ones of changes beneath the root.
+func (ol *observerList) rootAffected(
+	filter ObserverFilter, affectedNodeIDs []NodeID) bool {
+	if filter.Root == nil || filter.PathPrefix != "" {
+		return false
+	}
+	rootID := filter.Root.GetID()
+	for _, id := range affectedNodeIDs {
+		if id == rootID {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeWrite adds `write` to `writes`, extending an existing write
+// instead if the two overlap or touch.
+func mergeWrite(writes []WriteRange, write WriteRange) []WriteRange {
+	for i, w := range writes {
+		switch {
+		case w.isTruncate() || write.isTruncate():
+			if w.isTruncate() && write.isTruncate() && w.Off == write.Off {
+				return writes
+			}
+		case write.Off <= w.End() && w.Off <= write.End():
+			off := w.Off
+			if write.Off < off {
+				off = write.Off
+			}
+			end := w.End()
+			if write.End() > end {
+				end = write.End()
+			}
+			writes[i] = WriteRange{Off: off, Len: end - off}
+			return writes
+		}
+	}
+	return append(writes, write)
+}
+
+// mergeChangeLocked folds `change` into the pending changes,
+// combining it with any pending change to the same node.
+func (e *observerEntry) mergeChangeLocked(change NodeChange) {
+	if e.changeIndex == nil {
+		e.changeIndex = make(map[NodeID]int)
+	}
+	i, ok := -1, false
+	if change.Node != nil {
+		i, ok = e.changeIndex[change.Node.GetID()]
+	}
+	if !ok {
+		// Copy the slices, since later merges modify them.
+		change.DirUpdated = append([]string(nil), change.DirUpdated...)
+		change.FileUpdated = append([]WriteRange(nil), change.FileUpdated...)
+		if change.Node != nil {
+			e.changeIndex[change.Node.GetID()] = len(e.pendingChanges)
+		}
+		e.pendingChanges = append(e.pendingChanges, change)
+		return
+	}
+
+	c := &e.pendingChanges[i]
+	for _, name := range change.DirUpdated {
+		found := false
+		for _, n := range c.DirUpdated {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.DirUpdated = append(c.DirUpdated, name)
+		}
+	}
+	for _, write := range change.FileUpdated {
+		c.FileUpdated = mergeWrite(c.FileUpdated, write)
+	}
+	c.Type |= change.Type
+}
+
+// startTimerLocked makes sure the pending notifications for `e` are
+// delivered at the end of its coalescing window.
+func (e *observerEntry) startTimerLocked() {
+	if e.timer == nil {
+		e.timer = time.AfterFunc(e.filter.CoalesceWindow, e.flush)
+	}
+}
+
+// flush delivers all the notifications being coalesced for `e`.
+// Since they may come from many different operations, they are
+// delivered with a fresh context.
+func (e *observerEntry) flush() {
+	e.deliverLock.Lock()
+	defer e.deliverLock.Unlock()
+
+	e.lock.Lock()
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	removed := e.removed
+	local, changes, affected := e.pendingLocal, e.pendingChanges,
+		e.pendingAffected
+	batch, bgSync := e.pendingBatch, e.pendingBGSync
+	seqnos := NotificationSeqnos{First: e.lastSeqno + 1, Last: e.pendingSeqno}
+	if batch {
+		e.lastSeqno = e.pendingSeqno
+	}
+	e.pendingLocal, e.pendingChanges, e.pendingAffected = nil, nil, nil
+	e.changeIndex, e.affectedSeen = nil, nil
+	e.pendingBatch = false
+	e.lock.Unlock()
+
+	if removed {
+		return
+	}
+	ctx := context.Background()
+	for _, lc := range local {
+		for _, write := range lc.writes {
+			e.obs.LocalChange(ctx, lc.node, write)
+		}
+	}
+	if batch {
+		ctx = context.WithValue(ctx, CtxNotificationSeqnosKey, seqnos)
+		if bgSync {
+			ctx = context.WithValue(ctx, CtxBackgroundSyncKey, "1")
+		}
+		e.obs.BatchChanges(ctx, changes, affected)
+	}
+}
+
 func (ol *observerList) localChange(
 	ctx context.Context, node Node, write WriteRange) {
 	ol.lock.RLock()
 	defer ol.lock.RUnlock()
-	for _, o := range ol.observers {
-		o.LocalChange(ctx, node, write)
+	for _, e := range ol.observers {
+		if !ol.matches(e.filter, node, NodeChangeWrite) {
+			continue
+		}
+		if e.filter.CoalesceWindow <= 0 {
+			e.obs.LocalChange(ctx, node, write)
+			continue
+		}
+
+		e.lock.Lock()
+		found := false
+		for i, lc := range e.pendingLocal {
+			if lc.node.GetID() == node.GetID() {
+				e.pendingLocal[i].writes = mergeWrite(lc.writes, write)
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.pendingLocal = append(e.pendingLocal,
+				pendingLocalChange{node, []WriteRange{write}})
+		}
+		e.startTimerLocked()
+		e.lock.Unlock()
 	}
 }
 
@@ -52,8 +345,58 @@ func (ol *observerList) batchChanges(
 	ctx context.Context, changes []NodeChange, affectedNodeIDs []NodeID) {
 	ol.lock.RLock()
 	defer ol.lock.RUnlock()
-	for _, o := range ol.observers {
-		o.BatchChanges(ctx, changes, affectedNodeIDs)
+	seqno := atomic.AddUint64(&ol.seqno, 1)
+	_, bgSync := ctx.Value(CtxBackgroundSyncKey).(string)
+	for _, e := range ol.observers {
+		filtered := changes
+		if e.filter.restricts() {
+			filtered = nil
+			for _, change := range changes {
+				if ol.matches(e.filter, change.Node, change.Type) {
+					filtered = append(filtered, change)
+				}
+			}
+			if len(filtered) == 0 &&
+				!ol.rootAffected(e.filter, affectedNodeIDs) {
+				continue
+			}
+		}
+
+		e.lock.Lock()
+		if e.filter.CoalesceWindow <= 0 {
+			seqnos := NotificationSeqnos{First: e.lastSeqno + 1, Last: seqno}
+			if seqno > e.lastSeqno {
+				e.lastSeqno = seqno
+			}
+			e.lock.Unlock()
+			e.obs.BatchChanges(
+				context.WithValue(ctx, CtxNotificationSeqnosKey, seqnos),
+				filtered, affectedNodeIDs)
+			continue
+		}
+
+		if !e.pendingBatch {
+			e.pendingBGSync = true
+		}
+		e.pendingBatch = true
+		e.pendingBGSync = e.pendingBGSync && bgSync
+		if seqno > e.pendingSeqno {
+			e.pendingSeqno = seqno
+		}
+		for _, change := range filtered {
+			e.mergeChangeLocked(change)
+		}
+		if e.affectedSeen == nil {
+			e.affectedSeen = make(map[NodeID]bool)
+		}
+		for _, id := range affectedNodeIDs {
+			if !e.affectedSeen[id] {
+				e.affectedSeen[id] = true
+				e.pendingAffected = append(e.pendingAffected, id)
+			}
+		}
+		e.startTimerLocked()
+		e.lock.Unlock()
 	}
 }
 
@@ -61,7 +404,12 @@ func (ol *observerList) tlfHandleChange(
 	ctx context.Context, newHandle *TlfHandle) {
 	ol.lock.RLock()
 	defer ol.lock.RUnlock()
-	for _, o := range ol.observers {
-		o.TlfHandleChange(ctx, newHandle)
+	for _, e := range ol.observers {
+		if e.filter.CoalesceWindow > 0 {
+			// Deliver the older notifications first, under the old
+			// handle.
+			e.flush()
+		}
+		e.obs.TlfHandleChange(ctx, newHandle)
 	}
 }
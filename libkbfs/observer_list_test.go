@@ -0,0 +1,140 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testSeqnoObserver struct {
+	c       chan struct{}
+	local   []WriteRange
+	changes [][]NodeChange
+	seqnos  []NotificationSeqnos
+}
+
+func (t *testSeqnoObserver) LocalChange(
+	_ context.Context, _ Node, write WriteRange) {
+	t.local = append(t.local, write)
+}
+
+func (t *testSeqnoObserver) BatchChanges(
+	ctx context.Context, changes []NodeChange, _ []NodeID) {
+	t.changes = append(t.changes, changes)
+	t.seqnos = append(t.seqnos,
+		ctx.Value(CtxNotificationSeqnosKey).(NotificationSeqnos))
+	t.c <- struct{}{}
+}
+
+func (t *testSeqnoObserver) TlfHandleChange(context.Context, *TlfHandle) {}
+
+func TestObserverListCoalesce(t *testing.T) {
+	ncs, parentNode, childNode, _, _, _ := setupNodeCache(
+		t, tlf.FakeID(1, tlf.Private), MasterBranch, false)
+	ol := newObserverList(ncs)
+
+	plain := &testSeqnoObserver{c: make(chan struct{}, 10)}
+	ol.add(plain)
+	coalesced := &testSeqnoObserver{c: make(chan struct{}, 10)}
+	ol.addWithFilter(coalesced, ObserverFilter{CoalesceWindow: time.Minute})
+
+	ctx := context.Background()
+	ol.batchChanges(ctx, []NodeChange{
+		{Node: parentNode, DirUpdated: []string{"a"}, Type: NodeChangeCreate},
+	}, nil)
+	ol.batchChanges(ctx, []NodeChange{
+		{Node: parentNode, DirUpdated: []string{"b", "a"},
+			Type: NodeChangeRemove},
+		{Node: childNode, Type: NodeChangeAttr},
+	}, nil)
+	ol.batchChanges(ctx, []NodeChange{
+		{Node: childNode, FileUpdated: []WriteRange{{Off: 0, Len: 10}},
+			Type: NodeChangeWrite},
+	}, nil)
+	ol.localChange(ctx, childNode, WriteRange{Off: 5, Len: 10})
+	ol.localChange(ctx, childNode, WriteRange{Off: 100, Len: 1})
+	ol.localChange(ctx, childNode, WriteRange{Off: 0, Len: 5})
+
+	require.Equal(t, []NotificationSeqnos{{1, 1}, {2, 2}, {3, 3}},
+		plain.seqnos)
+	require.Len(t, plain.local, 3)
+	require.Len(t, coalesced.changes, 0)
+	require.Len(t, coalesced.local, 0)
+
+	t.Log("Flushing delivers the changes merged per node")
+	ol.tlfHandleChange(ctx, nil)
+	<-coalesced.c
+	require.Equal(t, []NotificationSeqnos{{1, 3}}, coalesced.seqnos)
+	require.Equal(t, []NodeChange{
+		{
+			Node:       parentNode,
+			DirUpdated: []string{"a", "b"},
+			Type:       NodeChangeCreate | NodeChangeRemove,
+		},
+		{
+			Node:        childNode,
+			FileUpdated: []WriteRange{{Off: 0, Len: 10}},
+			Type:        NodeChangeAttr | NodeChangeWrite,
+		},
+	}, coalesced.changes[0])
+	require.Equal(t, []WriteRange{{Off: 0, Len: 15}, {Off: 100, Len: 1}},
+		coalesced.local)
+
+	t.Log("Changes are delivered when the window ends")
+	ol.remove(coalesced)
+	ol.addWithFilter(coalesced, ObserverFilter{
+		CoalesceWindow: time.Millisecond,
+		Types:          NodeChangeCreate,
+	})
+	ol.batchChanges(ctx, []NodeChange{
+		{Node: childNode, Type: NodeChangeAttr},
+	}, nil)
+	ol.batchChanges(ctx, []NodeChange{
+		{Node: parentNode, DirUpdated: []string{"c"}, Type: NodeChangeCreate},
+	}, nil)
+	select {
+	case <-coalesced.c:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for coalesced changes")
+	}
+	// The filtered-out batch is still covered by the sequence numbers.
+	require.Equal(t, NotificationSeqnos{4, 5}, coalesced.seqnos[1])
+	require.Len(t, coalesced.changes[1], 1)
+
+	t.Log("Removed observers get nothing that was pending")
+	ol.remove(plain)
+	ol.remove(coalesced)
+	ol.addWithFilter(coalesced, ObserverFilter{CoalesceWindow: time.Minute})
+	ol.batchChanges(ctx, []NodeChange{{Node: parentNode}}, nil)
+	ol.remove(coalesced)
+	ol.tlfHandleChange(ctx, nil)
+	require.Len(t, coalesced.changes, 2)
+}
+
+func TestObserverListRootAffected(t *testing.T) {
+	ncs, parentNode, childNode, _, _, _ := setupNodeCache(
+		t, tlf.FakeID(1, tlf.Private), MasterBranch, false)
+	ol := newObserverList(ncs)
+
+	obs := &testSeqnoObserver{c: make(chan struct{}, 10)}
+	ol.addWithFilter(obs, ObserverFilter{Root: childNode})
+
+	t.Log("Local syncs that only report other nodes are filtered out")
+	ctx := context.Background()
+	ol.batchChanges(ctx, nil, []NodeID{parentNode.GetID()})
+	require.Len(t, obs.changes, 0)
+
+	t.Log("Local syncs that update the root are delivered")
+	ol.batchChanges(ctx, nil, []NodeID{
+		parentNode.GetID(), childNode.GetID()})
+	require.Len(t, obs.changes, 1)
+	require.Len(t, obs.changes[0], 0)
+	require.Equal(t, NotificationSeqnos{1, 2}, obs.seqnos[0])
+}
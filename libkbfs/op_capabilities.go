@@ -0,0 +1,70 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+)
+
+// OpCapabilities is a set of classes of KBFSOps calls.  Each frontend
+// may be restricted to a set of them; see
+// Config.SetFrontendCapabilities.
+type OpCapabilities uint
+
+const (
+	// OpCapStat allows OpTimeoutStat calls.
+	OpCapStat OpCapabilities = 1 << iota
+	// OpCapRead allows OpTimeoutRead calls.
+	OpCapRead
+	// OpCapWrite allows OpTimeoutWrite calls.
+	OpCapWrite
+	// OpCapSync allows OpTimeoutSync calls.
+	OpCapSync
+)
+
+const (
+	// OpCapsReadOnly allows only the calls that don't modify any
+	// data.
+	OpCapsReadOnly = OpCapStat | OpCapRead
+	// OpCapsAll allows all calls.
+	OpCapsAll = OpCapStat | OpCapRead | OpCapWrite | OpCapSync
+)
+
+// opCapabilityForClass returns the capability needed for calls of the
+// given class.
+func opCapabilityForClass(class OpTimeoutClass) OpCapabilities {
+	switch class {
+	case OpTimeoutStat:
+		return OpCapStat
+	case OpTimeoutRead:
+		return OpCapRead
+	case OpTimeoutWrite:
+		return OpCapWrite
+	case OpTimeoutSync:
+		return OpCapSync
+	default:
+		return 0
+	}
+}
+
+// Allows returns whether c includes calls of the given class.
+func (c OpCapabilities) Allows(class OpTimeoutClass) bool {
+	needed := opCapabilityForClass(class)
+	return needed != 0 && c&needed == needed
+}
+
+func (c OpCapabilities) String() string {
+	var names []string
+	for _, class := range []OpTimeoutClass{
+		OpTimeoutStat, OpTimeoutRead, OpTimeoutWrite, OpTimeoutSync} {
+		if c.Allows(class) {
+			names = append(names, class.String())
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpCapabilities(t *testing.T) {
+	require.True(t, OpCapsReadOnly.Allows(OpTimeoutStat))
+	require.True(t, OpCapsReadOnly.Allows(OpTimeoutRead))
+	require.False(t, OpCapsReadOnly.Allows(OpTimeoutWrite))
+	require.False(t, OpCapsReadOnly.Allows(OpTimeoutSync))
+	require.False(t, OpCapsAll.Allows(OpTimeoutClass(100)))
+
+	require.Equal(t, "stat|read", OpCapsReadOnly.String())
+	require.Equal(t, "stat|read|write|sync", OpCapsAll.String())
+	require.Equal(t, "none", OpCapabilities(0).String())
+}
+
+func TestKBFSOpsFrontendCapabilities(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	require.Equal(t, OpCapsAll,
+		config.FrontendCapabilities(RequestFrontendHTTP))
+	config.SetFrontendCapabilities(RequestFrontendHTTP, OpCapsReadOnly)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	httpCtx := CtxWithRequestSource(ctx, RequestSource{
+		Frontend: RequestFrontendHTTP,
+	})
+
+	t.Log("Reads through the restricted frontend still work")
+	_, err := kbfsOps.Stat(httpCtx, rootNode)
+	require.NoError(t, err)
+	_, err = kbfsOps.GetDirChildren(httpCtx, rootNode)
+	require.NoError(t, err)
+
+	t.Log("Writes through the restricted frontend are refused")
+	_, _, err = kbfsOps.CreateFile(httpCtx, rootNode, "a", false, NoExcl)
+	require.Equal(t, OpNotPermittedError{
+		Frontend: RequestFrontendHTTP,
+		Class:    OpTimeoutWrite,
+	}, err)
+	err = kbfsOps.SyncAll(httpCtx, rootNode.GetFolderBranch())
+	require.IsType(t, OpNotPermittedError{}, err)
+
+	t.Log("Other frontends are unaffected")
+	aNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Calls that change state outside of the files are refused too")
+	kops := kbfsOps.(*KBFSOpsStandard)
+	fb := rootNode.GetFolderBranch()
+	notPermitted := func(class OpTimeoutClass, err error) {
+		require.Equal(t, OpNotPermittedError{
+			Frontend: RequestFrontendHTTP,
+			Class:    class,
+		}, err)
+	}
+	notPermitted(OpTimeoutSync, kops.MigrateToImplicitTeam(httpCtx, fb.Tlf))
+	notPermitted(OpTimeoutSync, kops.MigrateTLF(httpCtx, fb, nil, nil))
+	notPermitted(OpTimeoutWrite, kops.UnstageForTesting(httpCtx, fb))
+	notPermitted(OpTimeoutWrite, kops.SetQuotaUsageThresholds(
+		httpCtx, []float64{0.5}))
+	notPermitted(OpTimeoutWrite, kops.StartRechunkJob(httpCtx, fb))
+	notPermitted(OpTimeoutWrite, kops.StartMirrorJob(httpCtx,
+		MirrorJobConfig{Name: "m", Source: "private/u1", Dest: "public/u1/m"}))
+	require.Len(t, kops.MirrorJobStatuses(), 0)
+	notPermitted(OpTimeoutSync, kops.SetFolderSyncState(httpCtx, fb.Tlf, true))
+	require.False(t, config.IsSyncedTlf(fb.Tlf))
+	notPermitted(OpTimeoutSync, kops.PinFile(httpCtx, aNode))
+	notPermitted(OpTimeoutSync, kops.HydrateFile(httpCtx, aNode, nil))
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	_, err = kops.MirrorSubtree(httpCtx, rootNode, "a", pubRoot, "a")
+	notPermitted(OpTimeoutSync, err)
+	h, err := kops.GetTLFHandle(ctx, pubRoot)
+	require.NoError(t, err)
+	_, err = kops.FollowFolder(httpCtx, h)
+	notPermitted(OpTimeoutSync, err)
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// OpTimeoutClass groups together the KBFSOps calls that should share
+// a deadline.
+type OpTimeoutClass int
+
+const (
+	// OpTimeoutStat is for calls that only look up metadata, like
+	// Lookup, Stat, GetDirChildren and the status calls.
+	OpTimeoutStat OpTimeoutClass = iota
+	// OpTimeoutRead is for calls that read file data or history, or
+	// that fetch a TLF's root.
+	OpTimeoutRead
+	// OpTimeoutWrite is for calls that make local modifications,
+	// like CreateFile, Write, Rename and RemoveEntry.
+	OpTimeoutWrite
+	// OpTimeoutSync is for calls that push changes to or pull
+	// changes from the servers, like SyncAll and SyncFromServer.
+	OpTimeoutSync
+	// OpTimeoutRekey is for rekeys of a TLF, which are started in
+	// the background rather than by KBFSOps calls.
+	OpTimeoutRekey
+)
+
+func (c OpTimeoutClass) String() string {
+	switch c {
+	case OpTimeoutStat:
+		return "stat"
+	case OpTimeoutRead:
+		return "read"
+	case OpTimeoutWrite:
+		return "write"
+	case OpTimeoutSync:
+		return "sync"
+	case OpTimeoutRekey:
+		return "rekey"
+	default:
+		return fmt.Sprintf("OpTimeoutClass(%d)", int(c))
+	}
+}
+
+// OpTimeoutPolicy says how long each class of KBFSOps call may run
+// before its context is canceled.  A zero timeout means that no
+// deadline is imposed beyond the caller's own.  A caller's deadline
+// that is earlier than the policy's always wins.
+type OpTimeoutPolicy struct {
+	Stat  time.Duration
+	Read  time.Duration
+	Write time.Duration
+	Sync  time.Duration
+	Rekey time.Duration
+}
+
+// DefaultOpTimeoutPolicy returns the timeout policy KBFS starts out
+// with, which imposes no deadlines at all.  The policy applies to
+// every caller in the process, so frontends with their own time
+// limits, like dokan and osxfuse, put a deadline on their own
+// requests' contexts instead.
+func DefaultOpTimeoutPolicy() OpTimeoutPolicy {
+	return OpTimeoutPolicy{}
+}
+
+// For returns the timeout for the given class of call.
+func (p OpTimeoutPolicy) For(class OpTimeoutClass) time.Duration {
+	switch class {
+	case OpTimeoutStat:
+		return p.Stat
+	case OpTimeoutRead:
+		return p.Read
+	case OpTimeoutWrite:
+		return p.Write
+	case OpTimeoutSync:
+		return p.Sync
+	case OpTimeoutRekey:
+		return p.Rekey
+	default:
+		return 0
+	}
+}
+
+// withOpTimeout returns a context derived from ctx that is canceled
+// once the config's timeout for the given class of call has passed.
+// The returned cancel function must always be called.
+func withOpTimeout(ctx context.Context, config Config,
+	class OpTimeoutClass) (context.Context, context.CancelFunc) {
+	timeout := config.OpTimeouts().For(class)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// opTimeoutError returns a TimeoutError in place of err if err is
+// because ctx, as returned by withOpTimeout from parent for the given
+// class of call, ran out of time.  If it's the caller's own deadline
+// in parent that passed, err is returned unchanged, so that callers
+// can still recognize context.DeadlineExceeded.
+func opTimeoutError(parent, ctx context.Context, config Config,
+	class OpTimeoutClass, err error) error {
+	if err == nil || errors.Cause(err) != context.DeadlineExceeded ||
+		ctx.Err() != context.DeadlineExceeded || parent.Err() != nil {
+		return err
+	}
+	return TimeoutError{Class: class, Timeout: config.OpTimeouts().For(class)}
+}
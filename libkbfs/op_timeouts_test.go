@@ -0,0 +1,109 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestOpTimeoutPolicyFor(t *testing.T) {
+	p := OpTimeoutPolicy{
+		Stat:  5 * time.Second,
+		Write: time.Minute,
+	}
+	require.Equal(t, 5*time.Second, p.For(OpTimeoutStat))
+	require.Equal(t, time.Duration(0), p.For(OpTimeoutRead))
+	require.Equal(t, time.Minute, p.For(OpTimeoutWrite))
+	require.Equal(t, time.Duration(0), p.For(OpTimeoutClass(-1)))
+
+	// By default, nothing has a deadline beyond the caller's own.
+	require.Equal(t, OpTimeoutPolicy{}, DefaultOpTimeoutPolicy())
+}
+
+func TestOpTimeoutError(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "u1")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+
+	t.Log("Running out the policy's time gives a TimeoutError")
+	config.SetOpTimeouts(OpTimeoutPolicy{Read: time.Millisecond})
+	parent := context.Background()
+	ctx, cancel := withOpTimeout(parent, config, OpTimeoutRead)
+	defer cancel()
+	<-ctx.Done()
+	err := opTimeoutError(parent, ctx, config, OpTimeoutRead,
+		errors.WithStack(context.DeadlineExceeded))
+	require.Equal(t, TimeoutError{OpTimeoutRead, time.Millisecond}, err)
+	err = opTimeoutError(parent, ctx, config, OpTimeoutRead,
+		errors.New("other"))
+	require.EqualError(t, err, "other")
+
+	t.Log("An earlier deadline of the caller's own isn't converted")
+	config.SetOpTimeouts(OpTimeoutPolicy{Read: time.Hour})
+	parent, cancelParent := context.WithTimeout(
+		context.Background(), time.Millisecond)
+	defer cancelParent()
+	ctx, cancel = withOpTimeout(parent, config, OpTimeoutRead)
+	defer cancel()
+	<-ctx.Done()
+	err = opTimeoutError(parent, ctx, config, OpTimeoutRead,
+		context.DeadlineExceeded)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestKBFSOpsOpTimeout(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+
+	// A stalled sync gives up once the sync timeout has passed,
+	// even though the caller's context has no deadline.
+	policy := config.OpTimeouts()
+	config.SetOpTimeouts(OpTimeoutPolicy{Sync: 100 * time.Millisecond})
+	bserver := config.BlockServer()
+	defer config.SetBlockServer(bserver)
+	onSyncStalledCh, syncUnstallCh, ctxStallSync :=
+		StallBlockOp(ctx, config, StallableBlockPut, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- kbfsOps.SyncAll(ctxStallSync, rootNode.GetFolderBranch())
+	}()
+	select {
+	case <-onSyncStalledCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	select {
+	case err = <-errCh:
+		require.Equal(t, TimeoutError{
+			Class:   OpTimeoutSync,
+			Timeout: 100 * time.Millisecond,
+		}, errors.Cause(err))
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	close(syncUnstallCh)
+
+	// Other classes of calls are unaffected by the sync timeout.
+	_, err = kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+
+	config.SetOpTimeouts(policy)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
@@ -1121,8 +1121,25 @@ const (
 	exAttr attrChange = iota
 	mtimeAttr
 	sizeAttr // only used during conflict resolution
+	xattrAttr
+	modeAttr
 )
 
+// canApplyToDir returns true if the attribute can be set on
+// directories as well as on files.
+func (ac attrChange) canApplyToDir() bool {
+	return ac == mtimeAttr || ac == xattrAttr || ac == modeAttr
+}
+
+// isExtended returns true if the attribute is newer than some of the
+// clients that might read it.  Those clients treat any attribute
+// they don't know as one that only applies to files, which would
+// break conflict resolution for directories, so extended attributes
+// are sent to them as mtimeAttr instead; see setAttrOp.ExtAttr.
+func (ac attrChange) isExtended() bool {
+	return ac == xattrAttr || ac == modeAttr
+}
+
 func (ac attrChange) String() string {
 	switch ac {
 	case exAttr:
@@ -1131,6 +1148,10 @@ func (ac attrChange) String() string {
 		return "mtime"
 	case sizeAttr:
 		return "size"
+	case xattrAttr:
+		return "xattr"
+	case modeAttr:
+		return "mode"
 	}
 	return "<invalid attrChange>"
 }
@@ -1143,6 +1164,10 @@ type setAttrOp struct {
 	Dir  blockUpdate  `codec:"d"`
 	Attr attrChange   `codec:"a"`
 	File BlockPointer `codec:"f"`
+	// ExtAttr is the real attribute that changed, if it's extended,
+	// in which case Attr is mtimeAttr.  Use getAttr instead of
+	// reading either field directly.
+	ExtAttr attrChange `codec:"ea,omitempty"`
 
 	// If true, this says that if there is a conflict involving this
 	// op, we should keep the unmerged name rather than construct a
@@ -1160,11 +1185,29 @@ func newSetAttrOp(name string, oldDir BlockPointer,
 	if err != nil {
 		return nil, err
 	}
-	sao.Attr = attr
+	sao.setAttr(attr)
 	sao.File = file
 	return sao, nil
 }
 
+func (sao *setAttrOp) setAttr(attr attrChange) {
+	if attr.isExtended() {
+		sao.Attr = mtimeAttr
+		sao.ExtAttr = attr
+		return
+	}
+	sao.Attr = attr
+	sao.ExtAttr = 0
+}
+
+// getAttr returns the attribute changed by this op.
+func (sao *setAttrOp) getAttr() attrChange {
+	if sao.ExtAttr.isExtended() {
+		return sao.ExtAttr
+	}
+	return sao.Attr
+}
+
 func (sao *setAttrOp) deepCopy() op {
 	saoCopy := *sao
 	saoCopy.OpCommon = sao.OpCommon.deepCopy()
@@ -1207,7 +1250,7 @@ func (sao *setAttrOp) checkValid() error {
 }
 
 func (sao *setAttrOp) String() string {
-	return fmt.Sprintf("setAttr %s (%s)", sao.Name, sao.Attr)
+	return fmt.Sprintf("setAttr %s (%s)", sao.Name, sao.getAttr())
 }
 
 func (sao *setAttrOp) StringWithRefs(indent string) string {
@@ -1223,7 +1266,7 @@ func (sao *setAttrOp) checkConflict(
 	isFile bool) (crAction, error) {
 	switch realMergedOp := mergedOp.(type) {
 	case *setAttrOp:
-		if realMergedOp.Attr == sao.Attr {
+		if realMergedOp.getAttr() == sao.getAttr() {
 			var symPath string
 			var causedByAttr attrChange
 			if !isFile {
@@ -1231,7 +1274,7 @@ func (sao *setAttrOp) checkConflict(
 				// Create a symlink entry with the unmerged mtime
 				// pointing to the merged entry.
 				symPath = mergedOp.getFinalPath().tailName()
-				causedByAttr = sao.Attr
+				causedByAttr = sao.getAttr()
 			}
 
 			// A set attr for the same attribute on the same file is a
@@ -1264,7 +1307,7 @@ func (sao *setAttrOp) getDefaultAction(mergedPath path) crAction {
 	return &copyUnmergedAttrAction{
 		fromName: sao.getFinalPath().tailName(),
 		toName:   mergedPath.tailName(),
-		attr:     []attrChange{sao.Attr},
+		attr:     []attrChange{sao.getAttr()},
 	}
 }
 
@@ -1472,7 +1515,7 @@ func invertOpForLocalNotifications(oldOp op) (newOp op, err error) {
 		copy(so.Writes, op.Writes)
 		newOp = so
 	case *setAttrOp:
-		newOp, err = newSetAttrOp(op.Name, op.Dir.Ref, op.Attr, op.File)
+		newOp, err = newSetAttrOp(op.Name, op.Dir.Ref, op.getAttr(), op.File)
 		if err != nil {
 			return nil, err
 		}
@@ -126,6 +126,45 @@ func TestSetAttrOpCustomUpdate(t *testing.T) {
 	require.Equal(t, blockUpdate{Unref: oldDir, Ref: newDir}, sao.Dir)
 }
 
+func TestSetAttrOpExtendedAttr(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	oldDir := makeRandomBlockPointer(t)
+	sao, err := newSetAttrOp("name", oldDir, xattrAttr, oldDir)
+	require.NoError(t, err)
+	require.Equal(t, xattrAttr, sao.getAttr())
+
+	// Clients that don't know about extended attrs see an mtime
+	// change, which they know can apply to directories.
+	buf, err := codec.Encode(sao)
+	require.NoError(t, err)
+	var oldSao struct {
+		Attr attrChange `codec:"a"`
+	}
+	err = codec.Decode(buf, &oldSao)
+	require.NoError(t, err)
+	require.Equal(t, mtimeAttr, oldSao.Attr)
+
+	var decoded setAttrOp
+	err = codec.Decode(buf, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, xattrAttr, decoded.getAttr())
+	require.True(t, decoded.getAttr().canApplyToDir())
+}
+
+func TestSetAttrOpModeAttr(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	oldDir := makeRandomBlockPointer(t)
+	sao, err := newSetAttrOp("name", oldDir, modeAttr, oldDir)
+	require.NoError(t, err)
+	require.Equal(t, mtimeAttr, sao.Attr)
+	buf, err := codec.Encode(sao)
+	require.NoError(t, err)
+	var decoded setAttrOp
+	err = codec.Decode(buf, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, modeAttr, decoded.getAttr())
+}
+
 type writeRangeFuture struct {
 	WriteRange
 	kbfscodec.Extra
@@ -379,6 +418,7 @@ func makeFakeSetAttrOpFuture(t *testing.T) setAttrOpFuture {
 			makeFakeBlockUpdate(t),
 			mtimeAttr,
 			makeFakeBlockPointer(t),
+			0,
 			false,
 		},
 		kbfscodec.MakeExtraOrBust("setAttrOp", t),
@@ -0,0 +1,418 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxPinnedFileTagKey is the type used for unique context tags
+// within a pinned file.
+type CtxPinnedFileTagKey int
+
+const (
+	// CtxPinnedFileIDKey is the type of the tag for unique operation
+	// IDs within a pinned file.
+	CtxPinnedFileIDKey CtxPinnedFileTagKey = iota
+)
+
+// CtxPinnedFileOpID is the display name for the unique operation
+// pinned file ID tag.
+const CtxPinnedFileOpID = "PINID"
+
+// pinnedFile keeps the blocks of a file pinned in the disk block
+// cache as the file changes.
+type pinnedFile struct {
+	fs   *KBFSOpsStandard
+	node Node
+
+	changedCh  chan struct{}
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+	stopOnce   sync.Once
+
+	// blockIDs holds the blocks currently pinned for the file, and is
+	// protected by fs.pinnedFilesLock.
+	blockIDs map[kbfsblock.ID]bool
+	// repinnedCh is closed, and replaced, after each repin in the
+	// background, and is protected by fs.pinnedFilesLock.
+	repinnedCh chan struct{}
+}
+
+var _ Observer = (*pinnedFile)(nil)
+
+// pinningDiskBlockCache returns the disk block cache, if it's one
+// that can pin blocks.
+func (fs *KBFSOpsStandard) pinningDiskBlockCache() (
+	*diskBlockCacheWrapped, error) {
+	dbc, ok := fs.config.DiskBlockCache().(*diskBlockCacheWrapped)
+	if !ok {
+		return nil, errors.Errorf("invalid disk cache type to pin files: %T",
+			fs.config.DiskBlockCache())
+	}
+	return dbc, nil
+}
+
+// pinFileBlocks pins all the current blocks of file in dbc, first
+// fetching the ones that aren't cached on disk, and returns them.
+func (fs *KBFSOpsStandard) pinFileBlocks(ctx context.Context,
+	dbc *diskBlockCacheWrapped, file Node) (map[kbfsblock.ID]bool, error) {
+	ptrs, err := fs.getOpsByNode(ctx, file).fileBlockPointers(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]kbfsblock.ID, 0, len(ptrs))
+	ptrsByID := make(map[kbfsblock.ID]BlockPointer, len(ptrs))
+	for _, ptr := range ptrs {
+		ids = append(ids, ptr.ID)
+		ptrsByID[ptr.ID] = ptr
+	}
+	missing, err := dbc.setPinned(ctx, ids, true)
+	if err != nil {
+		return nil, err
+	}
+	missingPtrs := make([]BlockPointer, 0, len(missing))
+	for _, id := range missing {
+		missingPtrs = append(missingPtrs, ptrsByID[id])
+	}
+	err = fs.fetchToDiskCache(
+		ctx, dbc, file.GetFolderBranch().Tlf, missingPtrs, nil)
+	if err != nil {
+		return nil, err
+	}
+	missing, err = dbc.setPinned(ctx, missing, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf(
+			"Couldn't cache %d blocks of the file", len(missing))
+	}
+
+	pinned := make(map[kbfsblock.ID]bool, len(ids))
+	for _, id := range ids {
+		pinned[id] = true
+	}
+	return pinned, nil
+}
+
+// fetchToDiskCache fetches the given blocks of a file in the given
+// TLF from the block server, and puts them in dbc.  If fetched is
+// non-nil, it's called with the encoded size of each block after
+// it's stored.
+func (fs *KBFSOpsStandard) fetchToDiskCache(ctx context.Context,
+	dbc DiskBlockCache, tlfID tlf.ID, ptrs []BlockPointer,
+	fetched func(size int)) error {
+	for _, ptr := range ptrs {
+		buf, serverHalf, err := fs.config.BlockServer().Get(
+			ctx, tlfID, ptr.ID, ptr.Context)
+		if err != nil {
+			return err
+		}
+		err = dbc.Put(ctx, tlfID, ptr.ID, buf, serverHalf)
+		if err != nil {
+			return err
+		}
+		if fetched != nil {
+			fetched(len(buf))
+		}
+	}
+	return nil
+}
+
+// unpinBlocks unpins the given blocks in dbc, except for the ones
+// still pinned for some other file.
+func (fs *KBFSOpsStandard) unpinBlocks(ctx context.Context,
+	dbc *diskBlockCacheWrapped, ids []kbfsblock.ID) error {
+	unpin := make([]kbfsblock.ID, 0, len(ids))
+	func() {
+		fs.pinnedFilesLock.Lock()
+		defer fs.pinnedFilesLock.Unlock()
+	outer:
+		for _, id := range ids {
+			for _, pf := range fs.pinnedFiles {
+				if pf.blockIDs[id] {
+					continue outer
+				}
+			}
+			unpin = append(unpin, id)
+		}
+	}()
+	_, err := dbc.setPinned(ctx, unpin, false)
+	return err
+}
+
+// repin pins the current blocks of the file, and unpins the ones
+// that are no longer part of it.
+func (pf *pinnedFile) repin(ctx context.Context) error {
+	dbc, err := pf.fs.pinningDiskBlockCache()
+	if err != nil {
+		return err
+	}
+	blockIDs, err := pf.fs.pinFileBlocks(ctx, dbc, pf.node)
+	if err != nil {
+		return err
+	}
+
+	var old []kbfsblock.ID
+	func() {
+		pf.fs.pinnedFilesLock.Lock()
+		defer pf.fs.pinnedFilesLock.Unlock()
+		for id := range pf.blockIDs {
+			if !blockIDs[id] {
+				old = append(old, id)
+			}
+		}
+		pf.blockIDs = blockIDs
+	}()
+	return pf.fs.unpinBlocks(ctx, dbc, old)
+}
+
+// PinFile keeps all the blocks of the given file in the disk block
+// cache, so that it stays readable offline even if its TLF isn't
+// synced, for example to keep a few documents from a large shared
+// folder.  The file's unsynced changes can't be pinned, but once
+// they're synced, the blocks of the new version are pinned in the
+// background, until UnpinFile is called.  Pinned blocks stay in the
+// cache across restarts, though changes made after a restart are
+// only pinned if PinFile is called again.
+func (fs *KBFSOpsStandard) PinFile(
+	ctx context.Context, file Node) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	// Fetching the blocks can take much longer than a regular read.
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	fs.pinnedFilesLock.Lock()
+	pf, ok := fs.pinnedFiles[file.GetID()]
+	fs.pinnedFilesLock.Unlock()
+	if ok {
+		return pf.repin(ctx)
+	}
+
+	pf = &pinnedFile{
+		fs:         fs,
+		node:       file,
+		changedCh:  make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		repinnedCh: make(chan struct{}),
+	}
+	// Watch the file before pinning it, so that no change is missed.
+	fb := file.GetFolderBranch()
+	err = fs.RegisterForChangesWithFilter(
+		[]FolderBranch{fb}, pf, ObserverFilter{
+			Root:           file,
+			CoalesceWindow: subtreeMirrorCoalesceWindow,
+		})
+	if err != nil {
+		return err
+	}
+	err = pf.repin(ctx)
+	if err == nil {
+		fs.pinnedFilesLock.Lock()
+		defer fs.pinnedFilesLock.Unlock()
+		if _, ok := fs.pinnedFiles[file.GetID()]; !ok {
+			fs.pinnedFiles[file.GetID()] = pf
+			go pf.loop()
+			return nil
+		}
+		// Pinned concurrently, so the other one takes over.
+	}
+	if unregErr := fs.UnregisterFromChanges(
+		[]FolderBranch{fb}, pf); err == nil {
+		err = unregErr
+	}
+	return err
+}
+
+// UnpinFile undoes PinFile for the given file, letting its blocks be
+// evicted from the disk block cache again.  It also unpins the
+// current blocks of a file that was pinned before a restart.
+func (fs *KBFSOpsStandard) UnpinFile(ctx context.Context, file Node) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	dbc, err := fs.pinningDiskBlockCache()
+	if err != nil {
+		return err
+	}
+
+	fs.pinnedFilesLock.Lock()
+	pf, ok := fs.pinnedFiles[file.GetID()]
+	delete(fs.pinnedFiles, file.GetID())
+	fs.pinnedFilesLock.Unlock()
+
+	var ids []kbfsblock.ID
+	if ok {
+		err := pf.stop(ctx)
+		if err != nil {
+			return err
+		}
+		fs.pinnedFilesLock.Lock()
+		for id := range pf.blockIDs {
+			ids = append(ids, id)
+		}
+		fs.pinnedFilesLock.Unlock()
+	}
+	ptrs, err := fs.getOpsByNode(ctx, file).fileBlockPointers(ctx, file)
+	switch {
+	case err == nil:
+		for _, ptr := range ptrs {
+			ids = append(ids, ptr.ID)
+		}
+	case !ok:
+		return err
+	default:
+		// The file may be gone, but what was pinned for it can
+		// still be unpinned.
+		fs.log.CDebugf(ctx, "Couldn't get the current blocks of a "+
+			"pinned file: %+v", err)
+	}
+	return fs.unpinBlocks(ctx, dbc, ids)
+}
+
+// stopPinnedFiles stops following changes to all the pinned files,
+// leaving their blocks pinned.
+func (fs *KBFSOpsStandard) stopPinnedFiles(ctx context.Context) error {
+	fs.pinnedFilesLock.Lock()
+	pfs := make([]*pinnedFile, 0, len(fs.pinnedFiles))
+	for _, pf := range fs.pinnedFiles {
+		pfs = append(pfs, pf)
+	}
+	fs.pinnedFilesLock.Unlock()
+	for _, pf := range pfs {
+		err := pf.stop(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetPinnedFiles stops and forgets all the pinned files, and
+// unpins their blocks, since they may belong to a previous user.
+func (fs *KBFSOpsStandard) resetPinnedFiles(ctx context.Context) error {
+	fs.pinnedFilesLock.Lock()
+	pfs := fs.pinnedFiles
+	fs.pinnedFiles = make(map[NodeID]*pinnedFile)
+	fs.pinnedFilesLock.Unlock()
+	if len(pfs) == 0 {
+		return nil
+	}
+
+	var ids []kbfsblock.ID
+	for _, pf := range pfs {
+		err := pf.stop(ctx)
+		if err != nil {
+			return err
+		}
+		fs.pinnedFilesLock.Lock()
+		for id := range pf.blockIDs {
+			ids = append(ids, id)
+		}
+		fs.pinnedFilesLock.Unlock()
+	}
+	dbc, err := fs.pinningDiskBlockCache()
+	if err != nil {
+		return err
+	}
+	return fs.unpinBlocks(ctx, dbc, ids)
+}
+
+func (pf *pinnedFile) loop() {
+	defer close(pf.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxPinnedFileIDKey, CtxPinnedFileOpID, pf.fs.log))
+	defer cancel()
+	for {
+		select {
+		case <-pf.changedCh:
+			err := pf.repin(ctx)
+			if err != nil {
+				pf.fs.log.CWarningf(ctx, "Couldn't pin the new version "+
+					"of a pinned file: %+v", err)
+			}
+			pf.fs.pinnedFilesLock.Lock()
+			close(pf.repinnedCh)
+			pf.repinnedCh = make(chan struct{})
+			pf.fs.pinnedFilesLock.Unlock()
+		case <-pf.shutdownCh:
+			return
+		}
+	}
+}
+
+// waitForPinnedBlockForTest waits until the block `id` is pinned for
+// the given pinned file.
+func (fs *KBFSOpsStandard) waitForPinnedBlockForTest(
+	ctx context.Context, file Node, id kbfsblock.ID) error {
+	for {
+		fs.pinnedFilesLock.Lock()
+		pf, ok := fs.pinnedFiles[file.GetID()]
+		var pinned bool
+		var repinnedCh chan struct{}
+		if ok {
+			pinned, repinnedCh = pf.blockIDs[id], pf.repinnedCh
+		}
+		fs.pinnedFilesLock.Unlock()
+		if !ok {
+			return errors.New("The file isn't pinned")
+		}
+		if pinned {
+			return nil
+		}
+		select {
+		case <-repinnedCh:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "Block %s wasn't pinned", id)
+		}
+	}
+}
+
+// stop stops following changes to the file, and waits for any
+// pinning in progress to end.
+func (pf *pinnedFile) stop(ctx context.Context) error {
+	var err error
+	pf.stopOnce.Do(func() {
+		err = pf.fs.UnregisterFromChanges(
+			[]FolderBranch{pf.node.GetFolderBranch()}, pf)
+		close(pf.shutdownCh)
+	})
+	select {
+	case <-pf.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// LocalChange implements the Observer interface for pinnedFile.
+// Only synced changes can be pinned.
+func (pf *pinnedFile) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+// BatchChanges implements the Observer interface for pinnedFile.
+func (pf *pinnedFile) BatchChanges(
+	_ context.Context, _ []NodeChange, _ []NodeID) {
+	select {
+	case pf.changedCh <- struct{}{}:
+	default:
+		// A repin is already pending, and will pick this up.
+	}
+}
+
+// TlfHandleChange implements the Observer interface for pinnedFile.
+func (pf *pinnedFile) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
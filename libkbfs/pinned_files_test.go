@@ -0,0 +1,88 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsPinFile(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// The config shuts the cache down.
+	dbc, _ := initDiskBlockCacheTest(t)
+	config.diskBlockCache = dbc
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	t.Log("Make a file with a few blocks")
+	bsplitter, err := NewBlockSplitterSimple(20, 8*1024, config.Codec())
+	require.NoError(t, err)
+	config.SetBlockSplitter(bsplitter)
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	fileBlockIDs := func() []kbfsblock.ID {
+		ptrs, err := kbfsOps.getOpsByNode(ctx, fNode).fileBlockPointers(
+			ctx, fNode)
+		require.NoError(t, err)
+		ids := make([]kbfsblock.ID, 0, len(ptrs))
+		for _, ptr := range ptrs {
+			ids = append(ids, ptr.ID)
+		}
+		return ids
+	}
+	isPinned := func(id kbfsblock.ID) bool {
+		md, err := dbc.GetMetadata(ctx, id)
+		require.NoError(t, err)
+		return md.Pinned
+	}
+
+	t.Log("Pinning caches and pins all the blocks")
+	err = kbfsOps.PinFile(ctx, fNode)
+	require.NoError(t, err)
+	oldIDs := fileBlockIDs()
+	require.True(t, len(oldIDs) > 1)
+	for _, id := range oldIDs {
+		require.True(t, isPinned(id))
+	}
+
+	t.Log("A directory can't be pinned")
+	err = kbfsOps.PinFile(ctx, rootNode)
+	require.Error(t, err)
+
+	t.Log("The new version of a changed file gets pinned")
+	err = kbfsOps.Write(ctx, fNode, []byte("changed"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	newIDs := fileBlockIDs()
+	require.NotEqual(t, oldIDs[0], newIDs[0])
+	err = kbfsOps.waitForPinnedBlockForTest(ctx, fNode, newIDs[0])
+	require.NoError(t, err)
+	require.True(t, isPinned(newIDs[0]))
+
+	t.Log("Unpinning unpins all the current blocks")
+	err = kbfsOps.UnpinFile(ctx, fNode)
+	require.NoError(t, err)
+	for _, id := range newIDs {
+		require.False(t, isPinned(id))
+	}
+}
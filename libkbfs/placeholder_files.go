@@ -0,0 +1,319 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxHydrationTagKey is the type used for unique context tags within
+// a background hydration of a file.
+type CtxHydrationTagKey int
+
+const (
+	// CtxHydrationIDKey is the type of the tag for unique operation
+	// IDs within a background hydration of a file.
+	CtxHydrationIDKey CtxHydrationTagKey = iota
+)
+
+// CtxHydrationOpID is the display name for the unique operation
+// hydration ID tag.
+const CtxHydrationOpID = "HYDID"
+
+// FileHydration says how much of the data of a file is stored
+// locally, in the disk block cache.  Frontends can show a file that
+// isn't hydrated as a placeholder, with its size and other metadata
+// but no local data, which is fetched when it's read.
+type FileHydration int
+
+const (
+	// FileDehydrated means that none of the file's data is stored
+	// locally.
+	FileDehydrated FileHydration = iota
+	// FilePartiallyHydrated means that some, but not all, of the
+	// file's data is stored locally.
+	FilePartiallyHydrated
+	// FileHydrated means that all of the file's data is stored
+	// locally, and so can be read offline.
+	FileHydrated
+)
+
+func (h FileHydration) String() string {
+	switch h {
+	case FileDehydrated:
+		return "dehydrated"
+	case FilePartiallyHydrated:
+		return "partially hydrated"
+	case FileHydrated:
+		return "hydrated"
+	default:
+		return fmt.Sprintf("FileHydration(%d)", int(h))
+	}
+}
+
+// HydrationProgress describes how far along the hydration of a file
+// is.
+type HydrationProgress struct {
+	File Node
+	// BlocksDone counts the blocks of the file stored locally so far,
+	// out of BlocksTotal.
+	BlocksDone  int
+	BlocksTotal int
+	// BytesFetched is how much encoded data has been fetched from the
+	// server so far.
+	BytesFetched int64
+	// Err is set if the hydration failed, in which case nothing more
+	// is reported for it.
+	Err error
+}
+
+// fileHydrationBlocks returns the disk block cache, and the current
+// blocks of the given file, along with the ones that aren't in the
+// disk block cache.
+func (fs *KBFSOpsStandard) fileHydrationBlocks(
+	ctx context.Context, file Node) (
+	dbc DiskBlockCache, ptrs, missing []BlockPointer, err error) {
+	dbc = fs.config.DiskBlockCache()
+	if dbc == nil {
+		return nil, nil, nil, errors.New(
+			"No disk block cache to hold file data")
+	}
+	ptrs, err = fs.getOpsByNode(ctx, file).fileBlockPointers(ctx, file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	seen := make(map[kbfsblock.ID]bool, len(ptrs))
+	unique := ptrs[:0]
+	for _, ptr := range ptrs {
+		if seen[ptr.ID] {
+			continue
+		}
+		seen[ptr.ID] = true
+		unique = append(unique, ptr)
+		_, _, _, err := dbc.Get(ctx, file.GetFolderBranch().Tlf, ptr.ID)
+		switch errors.Cause(err).(type) {
+		case nil:
+		case NoSuchBlockError:
+			missing = append(missing, ptr)
+		default:
+			return nil, nil, nil, err
+		}
+	}
+	return dbc, unique, missing, nil
+}
+
+// GetFileHydration returns how much of the data of the given file is
+// stored locally.  Unsynced changes to the file are always local, so
+// only the blocks of the file as last synced are checked.
+func (fs *KBFSOpsStandard) GetFileHydration(
+	ctx context.Context, file Node) (FileHydration, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	_, ptrs, missing, err := fs.fileHydrationBlocks(ctx, file)
+	switch {
+	case err != nil:
+		return FileDehydrated, err
+	case len(missing) == 0:
+		return FileHydrated, nil
+	case len(missing) == len(ptrs):
+		return FileDehydrated, nil
+	default:
+		return FilePartiallyHydrated, nil
+	}
+}
+
+// HydrateFile fetches all of the data of the given file that isn't
+// stored locally yet into the disk block cache, calling progress, if
+// it's non-nil, as it goes along.  Unlike with PinFile, the data may
+// later be evicted from the cache, leaving a placeholder again.
+func (fs *KBFSOpsStandard) HydrateFile(ctx context.Context, file Node,
+	progress func(HydrationProgress)) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	// Fetching the blocks can take much longer than a regular read.
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	return fs.hydrateFile(ctx, file, progress)
+}
+
+func (fs *KBFSOpsStandard) hydrateFile(ctx context.Context, file Node,
+	progress func(HydrationProgress)) (err error) {
+	status := HydrationProgress{File: file}
+	report := func() {
+		if progress != nil {
+			progress(status)
+		}
+	}
+	defer func() {
+		if err != nil {
+			status.Err = err
+			report()
+		}
+	}()
+
+	dbc, ptrs, missing, err := fs.fileHydrationBlocks(ctx, file)
+	if err != nil {
+		return err
+	}
+	status.BlocksTotal = len(ptrs)
+	status.BlocksDone = len(ptrs) - len(missing)
+	report()
+	if len(missing) == 0 {
+		return nil
+	}
+	fs.log.CDebugf(ctx, "Hydrating %d of the %d blocks of a file",
+		len(missing), len(ptrs))
+	return fs.fetchToDiskCache(ctx, dbc, file.GetFolderBranch().Tlf,
+		missing, func(size int) {
+			status.BlocksDone++
+			status.BytesFetched += int64(size)
+			report()
+		})
+}
+
+// DehydrateFile removes the data of the given file, as last synced,
+// from the disk block cache, leaving a placeholder that is hydrated
+// again by HydrateFile, or by reading it with hydration on read
+// enabled.  A pinned file can't be dehydrated, and any of its blocks
+// still pinned from before a restart are kept.
+func (fs *KBFSOpsStandard) DehydrateFile(ctx context.Context, file Node) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	fs.pinnedFilesLock.Lock()
+	_, pinned := fs.pinnedFiles[file.GetID()]
+	fs.pinnedFilesLock.Unlock()
+	if pinned {
+		return errors.New("Can't dehydrate a pinned file")
+	}
+
+	dbc := fs.config.DiskBlockCache()
+	if dbc == nil {
+		return nil
+	}
+	ptrs, err := fs.getOpsByNode(ctx, file).fileBlockPointers(ctx, file)
+	if err != nil {
+		return err
+	}
+	pinning, _ := dbc.(*diskBlockCacheWrapped)
+	ids := make([]kbfsblock.ID, 0, len(ptrs))
+	for _, ptr := range ptrs {
+		if pinning != nil {
+			md, err := pinning.GetMetadata(ctx, ptr.ID)
+			if err == nil && md.Pinned {
+				continue
+			}
+		}
+		ids = append(ids, ptr.ID)
+	}
+	_, _, err = dbc.Delete(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	fs.hydrationLock.Lock()
+	defer fs.hydrationLock.Unlock()
+	delete(fs.hydrated, file.GetID())
+	return nil
+}
+
+// SetHydrateOnRead sets whether reading from a file that isn't
+// hydrated starts hydrating all of it in the background, so that the
+// rest of it can be read quickly, or offline, later on.  If progress
+// is non-nil, it is called as each such hydration goes along.
+func (fs *KBFSOpsStandard) SetHydrateOnRead(
+	enabled bool, progress func(HydrationProgress)) {
+	fs.hydrationLock.Lock()
+	defer fs.hydrationLock.Unlock()
+	fs.hydrateOnRead = enabled
+	fs.hydrateOnReadProgress = progress
+	if !enabled {
+		fs.hydrated = make(map[NodeID]BlockPointer)
+	}
+}
+
+// hydrateOnReadIfNeeded starts hydrating the given file in the
+// background, if hydration on read is enabled, and the file isn't
+// already hydrated or being hydrated.
+func (fs *KBFSOpsStandard) hydrateOnReadIfNeeded(
+	ops *folderBranchOps, file Node) {
+	if ops.nodeCache == nil {
+		return
+	}
+	ptr := ops.nodeCache.PathFromNode(file).tailPointer()
+
+	fs.hydrationLock.Lock()
+	defer fs.hydrationLock.Unlock()
+	id := file.GetID()
+	if !fs.hydrateOnRead || fs.hydrationShutdown || fs.hydrating[id] ||
+		fs.hydrated[id] == ptr {
+		return
+	}
+	fs.hydrating[id] = true
+	progress := fs.hydrateOnReadProgress
+	shutdownCh := fs.hydrationShutdownCh
+	fs.hydrations.Add(1)
+	go func() {
+		defer fs.hydrations.Done()
+		ctx, cancel := context.WithCancel(
+			CtxWithRandomIDReplayable(context.Background(),
+				CtxHydrationIDKey, CtxHydrationOpID, fs.log))
+		defer cancel()
+		go func() {
+			select {
+			case <-shutdownCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := fs.hydrateFile(ctx, file, progress)
+		if err != nil {
+			fs.log.CDebugf(ctx, "Couldn't hydrate a file on read: %+v", err)
+		}
+		fs.hydrationLock.Lock()
+		defer fs.hydrationLock.Unlock()
+		delete(fs.hydrating, id)
+		if err == nil && fs.hydrateOnRead {
+			// The file is hydrated up to the version that was read,
+			// so don't check it again until it changes.
+			fs.hydrated[id] = ptr
+		}
+	}()
+}
+
+// stopHydrations cancels all the hydrations started in the
+// background, and waits for them to end.
+func (fs *KBFSOpsStandard) stopHydrations() {
+	fs.hydrationLock.Lock()
+	if !fs.hydrationShutdown {
+		fs.hydrationShutdown = true
+		close(fs.hydrationShutdownCh)
+	}
+	fs.hydrationLock.Unlock()
+	fs.hydrations.Wait()
+}
+
+// resetHydrations cancels all the hydrations started in the
+// background, waits for them to end, and forgets which files were
+// hydrated, so that hydration can start over for a new user.
+func (fs *KBFSOpsStandard) resetHydrations() {
+	fs.stopHydrations()
+	fs.hydrationLock.Lock()
+	defer fs.hydrationLock.Unlock()
+	fs.hydrating = make(map[NodeID]bool)
+	fs.hydrated = make(map[NodeID]BlockPointer)
+	fs.hydrationShutdown = false
+	fs.hydrationShutdownCh = make(chan struct{})
+}
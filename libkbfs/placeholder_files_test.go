@@ -0,0 +1,96 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsHydrateFile(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// The config shuts the cache down.
+	dbc, _ := initDiskBlockCacheTest(t)
+	config.diskBlockCache = dbc
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	t.Log("Make a file with a few blocks")
+	bsplitter, err := NewBlockSplitterSimple(20, 8*1024, config.Codec())
+	require.NoError(t, err)
+	config.SetBlockSplitter(bsplitter)
+	fNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	dehydrate := func() {
+		ptrs, err := kbfsOps.getOpsByNode(ctx, fNode).fileBlockPointers(
+			ctx, fNode)
+		require.NoError(t, err)
+		ids := make([]kbfsblock.ID, 0, len(ptrs))
+		for _, ptr := range ptrs {
+			ids = append(ids, ptr.ID)
+		}
+		_, _, err = dbc.Delete(ctx, ids)
+		require.NoError(t, err)
+		h, err := kbfsOps.GetFileHydration(ctx, fNode)
+		require.NoError(t, err)
+		require.Equal(t, FileDehydrated, h)
+	}
+
+	t.Log("Hydrating fetches all the blocks, with progress")
+	dehydrate()
+	var last HydrationProgress
+	err = kbfsOps.HydrateFile(ctx, fNode, func(p HydrationProgress) {
+		require.True(t, p.BlocksDone >= last.BlocksDone)
+		last = p
+	})
+	require.NoError(t, err)
+	require.NoError(t, last.Err)
+	require.True(t, last.BlocksTotal > 1)
+	require.Equal(t, last.BlocksTotal, last.BlocksDone)
+	require.True(t, last.BytesFetched > 0)
+	h, err := kbfsOps.GetFileHydration(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, FileHydrated, h)
+
+	t.Log("Reading hydrates the file, if enabled")
+	dehydrate()
+	doneCh := make(chan HydrationProgress, 1)
+	kbfsOps.SetHydrateOnRead(true, func(p HydrationProgress) {
+		if p.Err != nil || p.BlocksDone == p.BlocksTotal {
+			select {
+			case doneCh <- p:
+			default:
+			}
+		}
+	})
+	buf := make([]byte, 10)
+	_, err = kbfsOps.Read(ctx, fNode, buf, 0)
+	require.NoError(t, err)
+	select {
+	case p := <-doneCh:
+		require.NoError(t, p.Err)
+	case <-ctx.Done():
+		t.Fatal("File wasn't hydrated on read")
+	}
+	h, err = kbfsOps.GetFileHydration(ctx, fNode)
+	require.NoError(t, err)
+	require.Equal(t, FileHydrated, h)
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// minAuditNameLen is the length below which names aren't looked for
+// during a plaintext audit, since short names would match random
+// ciphertext far too often.
+const minAuditNameLen = 4
+
+// auditChunkSize is how much of a file is scanned at once during a
+// plaintext audit.
+const auditChunkSize = 64 * 1024
+
+// LocalPlaintextLeak describes a private file or directory name that
+// was found unencrypted in KBFS's local storage.
+type LocalPlaintextLeak struct {
+	// Path is the local file in which the name was found.
+	Path string
+	// Name is the plaintext name that was found.
+	Name string
+	// InPath is true if the name appears in the local file's path,
+	// rather than in its contents.
+	InPath bool
+}
+
+// localDataRoots returns the directories in which KBFS keeps
+// persistent local state for the given config.
+func localDataRoots(config Config) (roots []string) {
+	if storageRoot := config.StorageRoot(); storageRoot != "" {
+		roots = append(roots,
+			filepath.Join(storageRoot, workingSetCacheFolderName),
+			filepath.Join(storageRoot, syncCacheFolderName),
+			filepath.Join(storageRoot, syncedTlfConfigFolderName))
+	}
+	if jServer, err := GetJournalServer(config); err == nil {
+		roots = append(roots, jServer.rootPath())
+	}
+	return roots
+}
+
+// auditLocalPlaintext looks for each of the given names in the paths
+// and contents of all the files under roots.  Roots that don't exist
+// are skipped.
+func auditLocalPlaintext(ctx context.Context, roots []string,
+	names []string) (leaks []LocalPlaintextLeak, err error) {
+	var needles [][]byte
+	maxLen := 0
+	for _, name := range names {
+		if len(name) < minAuditNameLen {
+			continue
+		}
+		needles = append(needles, []byte(name))
+		if len(name) > maxLen {
+			maxLen = len(name)
+		}
+	}
+	if len(needles) == 0 {
+		return nil, nil
+	}
+
+	for _, root := range roots {
+		err := filepath.Walk(root,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if ioutil.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+
+				relPath, err := filepath.Rel(root, path)
+				if err != nil {
+					return err
+				}
+				for _, needle := range needles {
+					if strings.Contains(relPath, string(needle)) {
+						leaks = append(leaks, LocalPlaintextLeak{
+							Path:   path,
+							Name:   string(needle),
+							InPath: true,
+						})
+					}
+				}
+
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				found, err := scanFileForNeedles(ctx, path, needles, maxLen)
+				if ioutil.IsNotExist(err) {
+					// Removed out from under us.
+					return nil
+				} else if err != nil {
+					return err
+				}
+				for _, needle := range found {
+					leaks = append(leaks, LocalPlaintextLeak{
+						Path: path,
+						Name: string(needle),
+					})
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return leaks, nil
+}
+
+// scanFileForNeedles returns the needles that appear in the contents
+// of the file at path.  maxLen must be the length of the longest
+// needle.
+func scanFileForNeedles(ctx context.Context, path string,
+	needles [][]byte, maxLen int) (found [][]byte, err error) {
+	f, err := ioutil.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	isFound := make([]bool, len(needles))
+	// Keep the tail of the previous chunk around, so that needles
+	// that straddle two chunks are still found.
+	overlap := maxLen - 1
+	buf := make([]byte, overlap+auditChunkSize)
+	carried := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		n, readErr := io.ReadFull(f, buf[carried:])
+		window := buf[:carried+n]
+		for i, needle := range needles {
+			if !isFound[i] && bytes.Contains(window, needle) {
+				isFound[i] = true
+				found = append(found, needle)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return found, nil
+		} else if readErr != nil {
+			return nil, errors.WithStack(readErr)
+		}
+		carried = copy(buf, window[len(window)-overlap:])
+	}
+}
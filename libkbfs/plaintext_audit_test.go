@@ -0,0 +1,150 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestAuditLocalPlaintextScan(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "plaintext_audit")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+	ctx := context.Background()
+
+	clean := filepath.Join(tempdir, "clean")
+	err = ioutil.WriteFile(clean, []byte("nothing to see here"), 0600)
+	require.NoError(t, err)
+
+	// Put the name right across the boundary between two scan
+	// chunks.
+	straddle := filepath.Join(tempdir, "straddle")
+	buf := make([]byte, 2*auditChunkSize)
+	copy(buf[auditChunkSize-3:], "secret.txt")
+	err = ioutil.WriteFile(straddle, buf, 0600)
+	require.NoError(t, err)
+
+	named := filepath.Join(tempdir, "dir-secret.txt")
+	err = ioutil.WriteFile(named, nil, 0600)
+	require.NoError(t, err)
+
+	leaks, err := auditLocalPlaintext(
+		ctx, []string{tempdir, filepath.Join(tempdir, "missing")},
+		[]string{"secret.txt", "see"})
+	require.NoError(t, err)
+	require.Len(t, leaks, 2)
+	for _, leak := range leaks {
+		require.Equal(t, "secret.txt", leak.Name)
+		switch leak.Path {
+		case straddle:
+			require.False(t, leak.InPath)
+		case named:
+			require.True(t, leak.InPath)
+		default:
+			t.Fatalf("Unexpected leak: %+v", leak)
+		}
+	}
+}
+
+func TestKBFSOpsAuditLocalPlaintext(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "plaintext_audit")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	err = config.EnableJournaling(
+		ctx, tempdir, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	jServer, err := GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.EnableAuto(ctx)
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	tlfID := rootNode.GetFolderBranch().Tlf
+	jServer.PauseBackgroundWork(ctx, tlfID)
+	kbfsOps := config.KBFSOps()
+	name := "quarterly-report.txt"
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, name, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	// The unflushed journal holds the new entry, but only
+	// encrypted.
+	leaks, err := kbfsOps.AuditLocalPlaintext(ctx)
+	require.NoError(t, err)
+	require.Len(t, leaks, 0)
+
+	leakPath := filepath.Join(jServer.rootPath(), "leak")
+	err = ioutil.WriteFile(leakPath, []byte("path: /"+name), 0600)
+	require.NoError(t, err)
+	leaks, err = kbfsOps.AuditLocalPlaintext(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []LocalPlaintextLeak{{Path: leakPath, Name: name}}, leaks)
+
+	jServer.ResumeBackgroundWork(ctx, tlfID)
+	err = jServer.Wait(ctx, tlfID)
+	require.NoError(t, err)
+}
+
+func TestConfigRedactLogPath(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "test_user")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+
+	name := "quarterly-report.txt"
+	require.Equal(t, LogPathsFull, config.LogPathsMode())
+	require.Equal(t, name, config.RedactLogPath(name))
+
+	err := config.SetLogPathsMode(LogPathsHashed)
+	require.NoError(t, err)
+	redacted := config.RedactLogPath(name)
+	require.NotEqual(t, name, redacted)
+	require.False(t, strings.Contains(redacted, "report"))
+	require.Equal(t, redacted, config.RedactLogPath(name))
+	require.NotEqual(t, redacted, config.RedactLogPath("other.txt"))
+
+	err = config.SetLogPathsMode(LogPathsNone)
+	require.NoError(t, err)
+	require.Equal(t, "<redacted>", config.RedactLogPath(name))
+	require.Equal(t, "<redacted>", config.RedactLogPath("other.txt"))
+
+	err = config.SetLogPathsMode(LogPathsFull)
+	require.NoError(t, err)
+	require.Equal(t, name, config.RedactLogPath(name))
+}
+
+func TestLogPathsModeSet(t *testing.T) {
+	var m LogPathsMode
+	for _, mode := range []LogPathsMode{
+		LogPathsFull, LogPathsHashed, LogPathsNone} {
+		err := m.Set(" " + strings.ToUpper(mode.String()) + "\n")
+		require.NoError(t, err)
+		require.Equal(t, mode, m)
+	}
+	err := m.Set("some")
+	require.Error(t, err)
+	require.Equal(t, LogPathsNone, m)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const prefetchProgressDbFilename = "prefetchProgress.leveldb"
+
+// prefetchProgressMarker records a block whose deep-sync prefetch
+// has been triggered but hasn't finished yet, so that the prefetch
+// can pick up from that block after a restart.
+type prefetchProgressMarker struct {
+	Ptr   BlockPointer
+	IsDir bool
+	// Parent is the block whose prefetch triggered this one, or the
+	// zero ID if this block is the root of its prefetch.
+	Parent kbfsblock.ID
+}
+
+// prefetchProgressDb persists the markers of the deep-sync
+// prefetches that are in progress, keyed by TLF.  It lives next to
+// the sync cache, since it only makes sense alongside the blocks
+// stored there.
+type prefetchProgressDb struct {
+	config codecGetter
+	db     *levelDb
+}
+
+func newPrefetchProgressDbFromStorage(
+	config codecGetter, stor storage.Storage) (*prefetchProgressDb, error) {
+	db, err := openLevelDB(stor)
+	if err != nil {
+		return nil, err
+	}
+	return &prefetchProgressDb{config: config, db: db}, nil
+}
+
+func newPrefetchProgressDb(config codecGetter, dirPath string) (
+	*prefetchProgressDb, error) {
+	stor, err := storage.OpenFile(
+		filepath.Join(dirPath, prefetchProgressDbFilename), false)
+	if err != nil {
+		return nil, err
+	}
+	return newPrefetchProgressDbFromStorage(config, stor)
+}
+
+func newPrefetchProgressDbForTest(config codecGetter) (
+	*prefetchProgressDb, error) {
+	return newPrefetchProgressDbFromStorage(config, storage.NewMemStorage())
+}
+
+func (*prefetchProgressDb) key(tlfID tlf.ID, blockID kbfsblock.ID) []byte {
+	return append(tlfID.Bytes(), blockID.Bytes()...)
+}
+
+// put records that the deep-sync prefetch of marker.Ptr is in
+// progress.
+func (ppd *prefetchProgressDb) put(
+	tlfID tlf.ID, marker prefetchProgressMarker) error {
+	buf, err := ppd.config.Codec().Encode(marker)
+	if err != nil {
+		return err
+	}
+	return ppd.db.Put(ppd.key(tlfID, marker.Ptr.ID), buf, nil)
+}
+
+// remove forgets about the prefetch of the given block, if there was
+// a marker for it.
+func (ppd *prefetchProgressDb) remove(
+	tlfID tlf.ID, blockID kbfsblock.ID) error {
+	return ppd.db.Delete(ppd.key(tlfID, blockID), nil)
+}
+
+// takeFrontier removes all the markers for the given TLF, and returns
+// the ones that no other marker names as its parent.  Those are the
+// deepest blocks whose prefetches were in progress, and thus the
+// places to resume from; prefetching their ancestors again would
+// mostly revisit blocks that are already done.
+func (ppd *prefetchProgressDb) takeFrontier(tlfID tlf.ID) (
+	frontier []prefetchProgressMarker, err error) {
+	iter := ppd.db.NewIterator(util.BytesPrefix(tlfID.Bytes()), nil)
+	defer iter.Release()
+	var markers []prefetchProgressMarker
+	isParent := make(map[kbfsblock.ID]bool)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+		var marker prefetchProgressMarker
+		err := ppd.config.Codec().Decode(iter.Value(), &marker)
+		if err != nil {
+			// Skip markers we can't read; they'll be dropped along
+			// with the rest.
+			continue
+		}
+		markers = append(markers, marker)
+		isParent[marker.Parent] = true
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	for _, marker := range markers {
+		if !isParent[marker.Ptr.ID] {
+			frontier = append(frontier, marker)
+		}
+	}
+	err = ppd.db.Write(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return frontier, nil
+}
+
+// removeTlf forgets about all the prefetches in the given TLF.
+func (ppd *prefetchProgressDb) removeTlf(tlfID tlf.ID) error {
+	_, err := ppd.takeFrontier(tlfID)
+	return err
+}
+
+// Close closes the underlying database.
+func (ppd *prefetchProgressDb) Close() error {
+	return ppd.db.Close()
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetchProgressDbFrontier(t *testing.T) {
+	progress, err := newPrefetchProgressDbForTest(
+		newTestCodecGetter())
+	require.NoError(t, err)
+	defer progress.Close()
+
+	tlfID1 := tlf.FakeID(1, tlf.Private)
+	tlfID2 := tlf.FakeID(2, tlf.Private)
+
+	t.Log("Record the tree root -> {a -> {aa, ab}, b} in one TLF, " +
+		"and a lone block in another")
+	root := makeRandomBlockPointer(t)
+	a := makeRandomBlockPointer(t)
+	aa := makeRandomBlockPointer(t)
+	ab := makeRandomBlockPointer(t)
+	b := makeRandomBlockPointer(t)
+	other := makeRandomBlockPointer(t)
+	for _, marker := range []prefetchProgressMarker{
+		{Ptr: root, IsDir: true},
+		{Ptr: a, IsDir: true, Parent: root.ID},
+		{Ptr: aa, IsDir: true, Parent: a.ID},
+		{Ptr: ab, Parent: a.ID},
+		{Ptr: b, IsDir: true, Parent: root.ID},
+	} {
+		err = progress.put(tlfID1, marker)
+		require.NoError(t, err)
+	}
+	err = progress.put(tlfID2, prefetchProgressMarker{Ptr: other})
+	require.NoError(t, err)
+
+	t.Log("Finishing a block forgets its marker")
+	err = progress.remove(tlfID1, ab.ID)
+	require.NoError(t, err)
+
+	t.Log("The frontier is made of the blocks that aren't anyone's parent")
+	frontier, err := progress.takeFrontier(tlfID1)
+	require.NoError(t, err)
+	byID := make(map[kbfsblock.ID]prefetchProgressMarker)
+	for _, marker := range frontier {
+		byID[marker.Ptr.ID] = marker
+	}
+	require.Equal(t, map[kbfsblock.ID]prefetchProgressMarker{
+		aa.ID: {Ptr: aa, IsDir: true, Parent: a.ID},
+		b.ID:  {Ptr: b, IsDir: true, Parent: root.ID},
+	}, byID)
+
+	t.Log("Taking the frontier clears the TLF's markers, but no others")
+	frontier, err = progress.takeFrontier(tlfID1)
+	require.NoError(t, err)
+	require.Len(t, frontier, 0)
+	err = progress.removeTlf(tlfID1)
+	require.NoError(t, err)
+	frontier, err = progress.takeFrontier(tlfID2)
+	require.NoError(t, err)
+	require.Equal(t, []prefetchProgressMarker{{Ptr: other}}, frontier)
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/keybase/kbfs/kbfsblock"
+)
+
+const (
+	// prefetchRecencyTrackerSize is how many recently-read blocks the
+	// prefetcher remembers, per prefetcher.
+	prefetchRecencyTrackerSize = 1000
+	// syncedRecentPrefetchPriority is the highest priority used for
+	// deep-sync prefetches: the entries of recently-read directories
+	// get this priority, minus the directory's access age.
+	syncedRecentPrefetchPriority = defaultOnDemandRequestPriority - 1
+	// syncedSiblingPrefetchPriority is the highest priority used for
+	// the siblings of recently-read entries.
+	syncedSiblingPrefetchPriority = syncedRecentPrefetchPriority - 1<<20
+	// syncedDefaultPrefetchPriority is used for every other deep-sync
+	// prefetch, in the usual breadth-first order.
+	syncedDefaultPrefetchPriority = syncedSiblingPrefetchPriority - 1<<20
+)
+
+// prefetchRecencyTracker remembers which blocks have been read on
+// demand most recently, so that deep-sync prefetches can be ordered
+// by how likely the user is to need them soon.
+type prefetchRecencyTracker struct {
+	lock sync.Mutex
+	// accessCount counts all the recorded accesses so far.
+	accessCount uint64
+	// accessed maps a block ID to the value of accessCount right
+	// after its latest access.
+	accessed *simplelru.LRU
+}
+
+func newPrefetchRecencyTracker(size int) *prefetchRecencyTracker {
+	accessed, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		// Only possible with a non-positive size.
+		panic(err)
+	}
+	return &prefetchRecencyTracker{accessed: accessed}
+}
+
+// recordAccess notes that the given block has just been read on
+// demand.
+func (t *prefetchRecencyTracker) recordAccess(id kbfsblock.ID) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.accessCount++
+	t.accessed.Add(id, t.accessCount)
+}
+
+// age returns how many other accesses have been recorded since the
+// given block was last read, or false if it hasn't been read
+// recently.
+func (t *prefetchRecencyTracker) age(id kbfsblock.ID) (int, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	count, ok := t.accessed.Peek(id)
+	if !ok {
+		return 0, false
+	}
+	return int(t.accessCount - count.(uint64)), true
+}
+
+// syncedDirEntriesPriority returns the starting priority for the
+// deep-sync prefetches of the entries of the given direct directory
+// block.  The entries of a recently-read directory come first, the
+// most recently read ones before the others.  Next come directories
+// with a recently-read entry, since the siblings of that entry are
+// likely to be read next.  Everything else follows in breadth-first
+// order.
+func (p *blockPrefetcher) syncedDirEntriesPriority(
+	dirBlockID kbfsblock.ID, entries dirEntries) int {
+	if age, ok := p.recency.age(dirBlockID); ok {
+		return syncedRecentPrefetchPriority - age
+	}
+	minAge := -1
+	for _, entry := range entries {
+		age, ok := p.recency.age(entry.ID)
+		if ok && (minAge < 0 || age < minAge) {
+			minAge = age
+		}
+	}
+	if minAge >= 0 {
+		return syncedSiblingPrefetchPriority - minAge
+	}
+	return syncedDefaultPrefetchPriority
+}
+
+// syncedIndirectPriority returns the starting priority for the
+// deep-sync prefetches of the indirect children of a block that was
+// itself fetched with the given priority, so that the rest of a file
+// or directory is fetched about as urgently as its top block.
+func syncedIndirectPriority(parentPriority int) int {
+	if parentPriority > syncedRecentPrefetchPriority {
+		return syncedRecentPrefetchPriority
+	}
+	return parentPriority
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetchRecencyTracker(t *testing.T) {
+	tracker := newPrefetchRecencyTracker(2)
+	id1 := kbfsblock.FakeID(1)
+	id2 := kbfsblock.FakeID(2)
+	id3 := kbfsblock.FakeID(3)
+
+	_, ok := tracker.age(id1)
+	require.False(t, ok)
+
+	tracker.recordAccess(id1)
+	tracker.recordAccess(id2)
+	age, ok := tracker.age(id2)
+	require.True(t, ok)
+	require.Equal(t, 0, age)
+	age, ok = tracker.age(id1)
+	require.True(t, ok)
+	require.Equal(t, 1, age)
+
+	t.Log("Reading a block again makes it the most recent one")
+	tracker.recordAccess(id1)
+	age, ok = tracker.age(id1)
+	require.True(t, ok)
+	require.Equal(t, 0, age)
+
+	t.Log("The least recently read block is forgotten first")
+	tracker.recordAccess(id3)
+	_, ok = tracker.age(id2)
+	require.False(t, ok)
+	_, ok = tracker.age(id1)
+	require.True(t, ok)
+}
+
+func TestPrefetcherSyncedDirEntriesPriority(t *testing.T) {
+	p := newBlockPrefetcher(nil, nil, nil)
+	readDir := kbfsblock.FakeID(1)
+	olderReadDir := kbfsblock.FakeID(2)
+	parentDir := kbfsblock.FakeID(3)
+	otherDir := kbfsblock.FakeID(4)
+	readFile := dirEntryWithName{DirEntry: DirEntry{BlockInfo: BlockInfo{
+		BlockPointer: BlockPointer{ID: kbfsblock.FakeID(5)}}}}
+	otherFile := dirEntryWithName{DirEntry: DirEntry{BlockInfo: BlockInfo{
+		BlockPointer: BlockPointer{ID: kbfsblock.FakeID(6)}}}}
+
+	p.recency.recordAccess(olderReadDir)
+	p.recency.recordAccess(readFile.ID)
+	p.recency.recordAccess(readDir)
+
+	readPriority := p.syncedDirEntriesPriority(readDir, nil)
+	olderReadPriority := p.syncedDirEntriesPriority(olderReadDir, nil)
+	siblingPriority := p.syncedDirEntriesPriority(
+		parentDir, dirEntries{otherFile, readFile})
+	otherPriority := p.syncedDirEntriesPriority(
+		otherDir, dirEntries{otherFile})
+
+	t.Log("Recently-read directories go first, then the siblings of " +
+		"recently-read entries, then everything else")
+	require.Equal(t, syncedRecentPrefetchPriority, readPriority)
+	require.True(t, readPriority > olderReadPriority)
+	require.True(t, olderReadPriority > siblingPriority)
+	require.True(t, siblingPriority > otherPriority)
+	require.Equal(t, syncedDefaultPrefetchPriority, otherPriority)
+	require.True(t, otherPriority >= lowestTriggerPrefetchPriority)
+
+	t.Log("Indirect blocks inherit the priority of their parent")
+	require.Equal(t, siblingPriority, syncedIndirectPriority(siblingPriority))
+	require.Equal(t, syncedRecentPrefetchPriority,
+		syncedIndirectPriority(defaultOnDemandRequestPriority))
+}
@@ -32,6 +32,7 @@ type prefetcherConfig interface {
 	logMaker
 	blockCacher
 	diskBlockCacheGetter
+	networkConstraintsGetter
 }
 
 type prefetchRequest struct {
@@ -91,6 +92,11 @@ type blockPrefetcher struct {
 	doneCh chan struct{}
 	// map to store prefetch metadata
 	prefetches map[kbfsblock.ID]*prefetch
+	// TLFs whose interrupted deep syncs have already been resumed
+	// by this prefetcher; only accessed by `run`.
+	resumedTlfs map[tlf.ID]bool
+	// the blocks of synced TLFs that were read on demand most recently
+	recency *prefetchRecencyTracker
 }
 
 var _ Prefetcher = (*blockPrefetcher)(nil)
@@ -107,6 +113,8 @@ func newBlockPrefetcher(retriever BlockRetriever,
 		almostDoneCh:      make(chan struct{}, 1),
 		doneCh:            make(chan struct{}),
 		prefetches:        make(map[kbfsblock.ID]*prefetch),
+		resumedTlfs:       make(map[tlf.ID]bool),
+		recency:           newPrefetchRecencyTracker(prefetchRecencyTrackerSize),
 	}
 	if config != nil {
 		p.log = config.MakeLogger("PRE")
@@ -189,6 +197,7 @@ func (p *blockPrefetcher) completePrefetch(
 		if pp.subtreeBlockCount == 0 {
 			delete(p.prefetches, blockID)
 			defer pp.Close()
+			p.removeProgressMarker(pp)
 			b := pp.req.block.NewEmpty()
 			// TODO: after we split out priority from whether to prefetch, make
 			// this a much higher priority.
@@ -223,6 +232,101 @@ func (p *blockPrefetcher) decrementPrefetch(_ kbfsblock.ID, pp *prefetch) {
 func (p *blockPrefetcher) cancelPrefetch(blockID kbfsblock.ID, pp *prefetch) {
 	delete(p.prefetches, blockID)
 	pp.Close()
+	p.removeProgressMarker(pp)
+}
+
+// getPrefetchProgress returns the database in which deep-sync
+// prefetches record their progress, or nil if there isn't one.
+func (p *blockPrefetcher) getPrefetchProgress() *prefetchProgressDb {
+	dbc, ok := p.config.DiskBlockCache().(*diskBlockCacheWrapped)
+	if !ok {
+		return nil
+	}
+	return dbc.getPrefetchProgress()
+}
+
+// putProgressMarker records that the deep sync of pre's subtree has
+// been triggered, so that it can be resumed from there if KBFS
+// restarts before it finishes.
+func (p *blockPrefetcher) putProgressMarker(pre *prefetch) {
+	progress := p.getPrefetchProgress()
+	if progress == nil {
+		return
+	}
+	marker := prefetchProgressMarker{Ptr: pre.req.ptr}
+	_, marker.IsDir = pre.req.block.(*DirBlock)
+	for parent := range pre.parents {
+		marker.Parent = parent
+		break
+	}
+	err := progress.put(pre.req.kmd.TlfID(), marker)
+	if err != nil {
+		p.log.CDebugf(pre.ctx, "couldn't record prefetch progress for "+
+			"block %s: %+v", pre.req.ptr.ID, err)
+	}
+}
+
+// removeProgressMarker forgets about the progress of pre, once it's
+// done or canceled.
+func (p *blockPrefetcher) removeProgressMarker(pre *prefetch) {
+	if pre.req == nil || !pre.req.isDeepSync {
+		return
+	}
+	progress := p.getPrefetchProgress()
+	if progress == nil {
+		return
+	}
+	err := progress.remove(pre.req.kmd.TlfID(), pre.req.ptr.ID)
+	if err != nil {
+		p.log.CDebugf(pre.ctx, "couldn't remove prefetch progress for "+
+			"block %s: %+v", pre.req.ptr.ID, err)
+	}
+}
+
+// resumeDeepSync restarts the deep-sync prefetches of the TLF of
+// req.kmd that were still in progress the last time KBFS ran, or the
+// last time the prefetcher was restarted.  Rather than waiting for
+// the traversal from the root to reach them again, the deepest of
+// them are requested right away.  Each one becomes the root of its
+// own prefetch until the traversal from the root catches up and
+// adopts it.  It only does anything the first time it's called for
+// a given TLF.
+func (p *blockPrefetcher) resumeDeepSync(req *prefetchRequest) {
+	tlfID := req.kmd.TlfID()
+	if p.resumedTlfs[tlfID] {
+		return
+	}
+	p.resumedTlfs[tlfID] = true
+	progress := p.getPrefetchProgress()
+	if progress == nil {
+		return
+	}
+	frontier, err := progress.takeFrontier(tlfID)
+	if err != nil {
+		p.log.CDebugf(p.ctx, "couldn't read prefetch progress for TLF %s: "+
+			"%+v", tlfID, err)
+		return
+	}
+	if len(frontier) == 0 {
+		return
+	}
+	p.log.CDebugf(p.ctx, "resuming the deep sync of TLF %s from %d "+
+		"block(s)", tlfID, len(frontier))
+	// We don't know whether the user is likely to need these blocks
+	// soon, so let any recently-read parts of the TLF go first.
+	priority := syncedDefaultPrefetchPriority
+	for _, marker := range frontier {
+		if _, ok := p.prefetches[marker.Ptr.ID]; ok {
+			continue
+		}
+		var block Block = &FileBlock{}
+		if marker.IsDir {
+			block = &DirBlock{}
+		}
+		ch := p.retriever.Request(p.ctx, priority, req.kmd, marker.Ptr,
+			block, req.lifetime)
+		p.inFlightFetches.In() <- ch
+	}
 }
 
 func (p *blockPrefetcher) isShutdown() bool {
@@ -306,12 +410,15 @@ func (p *blockPrefetcher) request(ctx context.Context, priority int,
 }
 
 func (p *blockPrefetcher) prefetchIndirectFileBlock(ctx context.Context,
-	parentBlockID kbfsblock.ID, b *FileBlock, kmd KeyMetadata,
-	lifetime BlockCacheLifetime, isPrefetchNew, isDeepSync bool) (numBlocks int,
-	isTail bool) {
+	parentBlockID kbfsblock.ID, parentPriority int, b *FileBlock,
+	kmd KeyMetadata, lifetime BlockCacheLifetime, isPrefetchNew,
+	isDeepSync bool) (numBlocks int, isTail bool) {
 	// Prefetch indirect block pointers.
 	startingPriority :=
 		p.calculatePriority(fileIndirectBlockPrefetchPriority, kmd.TlfID())
+	if p.config.IsSyncedTlf(kmd.TlfID()) {
+		startingPriority = syncedIndirectPriority(parentPriority)
+	}
 	for i, ptr := range b.IPtrs {
 		numBlocks += p.request(ctx, startingPriority-i, kmd,
 			ptr.BlockPointer, b.NewEmpty(), lifetime,
@@ -321,12 +428,15 @@ func (p *blockPrefetcher) prefetchIndirectFileBlock(ctx context.Context,
 }
 
 func (p *blockPrefetcher) prefetchIndirectDirBlock(ctx context.Context,
-	parentBlockID kbfsblock.ID, b *DirBlock, kmd KeyMetadata,
-	lifetime BlockCacheLifetime, isPrefetchNew, isDeepSync bool) (numBlocks int,
-	isTail bool) {
+	parentBlockID kbfsblock.ID, parentPriority int, b *DirBlock,
+	kmd KeyMetadata, lifetime BlockCacheLifetime, isPrefetchNew,
+	isDeepSync bool) (numBlocks int, isTail bool) {
 	// Prefetch indirect block pointers.
 	startingPriority :=
 		p.calculatePriority(fileIndirectBlockPrefetchPriority, kmd.TlfID())
+	if p.config.IsSyncedTlf(kmd.TlfID()) {
+		startingPriority = syncedIndirectPriority(parentPriority)
+	}
 	for i, ptr := range b.IPtrs {
 		numBlocks += p.request(ctx, startingPriority-i, kmd,
 			ptr.BlockPointer, b.NewEmpty(), lifetime,
@@ -344,8 +454,18 @@ func (p *blockPrefetcher) prefetchDirectDirBlock(ctx context.Context,
 	sort.Sort(dirEntries)
 	startingPriority :=
 		p.calculatePriority(dirEntryPrefetchPriority, kmd.TlfID())
+	if p.config.IsSyncedTlf(kmd.TlfID()) {
+		startingPriority = p.syncedDirEntriesPriority(
+			parentBlockID, dirEntries.dirEntries)
+	}
+	// Only the names of the children are known here, so only
+	// patterns that exclude a name everywhere in the TLF apply.
+	exclude := p.config.TlfExcludePatterns(kmd.TlfID())
 	totalChildEntries := 0
 	for i, entry := range dirEntries.dirEntries {
+		if exclude.ExcludedEverywhere(entry.entryName, entry.Type == Dir) {
+			continue
+		}
 		// Prioritize small files
 		priority := startingPriority - i
 		var block Block
@@ -397,15 +517,16 @@ func (p *blockPrefetcher) handlePrefetch(pre *prefetch, isPrefetchNew,
 	case *FileBlock:
 		if b.IsInd {
 			numBlocks, isTail = p.prefetchIndirectFileBlock(pre.ctx,
-				req.ptr.ID, b, req.kmd, req.lifetime, isPrefetchNew,
-				isDeepSync)
+				req.ptr.ID, req.priority, b, req.kmd, req.lifetime,
+				isPrefetchNew, isDeepSync)
 		} else {
 			isTail = true
 		}
 	case *DirBlock:
 		if b.IsInd {
 			numBlocks, isTail = p.prefetchIndirectDirBlock(pre.ctx, req.ptr.ID,
-				b, req.kmd, req.lifetime, isPrefetchNew, isDeepSync)
+				req.priority, b, req.kmd, req.lifetime, isPrefetchNew,
+				isDeepSync)
 		} else {
 			numBlocks, isTail = p.prefetchDirectDirBlock(pre.ctx, req.ptr.ID,
 				b, req.kmd, req.lifetime, isPrefetchNew, isDeepSync)
@@ -531,6 +652,9 @@ func (p *blockPrefetcher) run(testSyncCh <-chan struct{}) {
 					req.ptr.ID)
 				continue
 			}
+			if req.isDeepSync {
+				p.resumeDeepSync(req)
+			}
 			if req.prefetchStatus == TriggeredPrefetch && !req.isDeepSync {
 				p.log.CDebugf(ctx, "prefetch already triggered for block ID "+
 					"%s", req.ptr.ID)
@@ -649,6 +773,9 @@ func (p *blockPrefetcher) run(testSyncCh <-chan struct{}) {
 				// shouldn't block anything above it in the tree from
 				// completing.
 			}
+			if req.isDeepSync {
+				p.putProgressMarker(pre)
+			}
 			p.log.CDebugf(ctx, "prefetching %d block(s) with parent block %s",
 				numBlocks, req.ptr.ID)
 			// Walk up the block tree and add numBlocks to every parent,
@@ -692,6 +819,11 @@ func (p *blockPrefetcher) ProcessBlockForPrefetch(ctx context.Context,
 	ptr BlockPointer, block Block, kmd KeyMetadata, priority int,
 	lifetime BlockCacheLifetime, prefetchStatus PrefetchStatus) {
 	isDeepSync := p.config.IsSyncedTlf(kmd.TlfID())
+	if isDeepSync && priority >= defaultOnDemandRequestPriority {
+		// The user is reading this block right now, so the deep sync
+		// should favor the blocks around it.
+		p.recency.recordAccess(ptr.ID)
+	}
 	req := &prefetchRequest{ptr, block.NewEmpty(), kmd, priority, lifetime,
 		prefetchStatus, isDeepSync}
 	if prefetchStatus == FinishedPrefetch {
@@ -702,6 +834,15 @@ func (p *blockPrefetcher) ProcessBlockForPrefetch(ctx context.Context,
 		// prefetchStatus unchanged, but cache anyway.
 		p.retriever.PutInCaches(ctx, ptr, kmd.TlfID(), block, lifetime,
 			prefetchStatus)
+	} else if !isDeepSync && p.config.NetworkConstraints().Metered {
+		// Don't spend the user's data plan on speculative
+		// prefetches.  Synced TLFs are still prefetched, since the
+		// user explicitly asked for them to be available offline.
+		p.log.CDebugf(ctx, "skipping prefetch for block %s on a metered "+
+			"network", ptr.ID)
+		p.retriever.PutInCaches(ctx, ptr, kmd.TlfID(), block, lifetime,
+			prefetchStatus)
+		return
 	} else {
 		// Note that here we are caching `TriggeredPrefetch`, but the request
 		// will still reflect the passed-in `prefetchStatus`, since that's the
@@ -32,7 +32,10 @@ func makeRandomDirEntry(
 			101,
 			102,
 			"",
+			0,
+			"",
 		},
+		nil,
 		codec.UnknownFieldSetHandler{},
 	}
 }
@@ -469,6 +472,43 @@ func TestPrefetcherEmptyDirectDirBlock(t *testing.T) {
 		FinishedPrefetch, TransientEntry)
 }
 
+func TestPrefetcherMeteredNetwork(t *testing.T) {
+	t.Log("Test that nothing is prefetched on a metered network.")
+	q, bg, config := initPrefetcherTest(t)
+	defer shutdownPrefetcherTest(q)
+	config.testNetworkConstraintsGetter.constraints.Metered = true
+	prefetchSyncCh := make(chan struct{})
+	q.TogglePrefetcher(true, prefetchSyncCh)
+	notifySyncCh(t, prefetchSyncCh)
+
+	t.Log("Initialize a folder tree with structure: " +
+		"root -> {a}")
+	rootPtr := makeRandomBlockPointer(t)
+	root := &DirBlock{Children: map[string]DirEntry{
+		"a": makeRandomDirEntry(t, File, 10, "a"),
+	}}
+	aPtr := root.Children["a"].BlockPointer
+	_, contChRoot := bg.setBlockToReturn(rootPtr, root)
+
+	t.Log("Fetch dir root.")
+	var block Block = &DirBlock{}
+	ch := q.Request(context.Background(), defaultOnDemandRequestPriority,
+		makeKMD(), rootPtr, block, TransientEntry)
+	contChRoot <- nil
+	err := <-ch
+	require.NoError(t, err)
+	require.Equal(t, root, block)
+
+	t.Log("No prefetches should have been triggered.")
+	waitForPrefetchOrBust(t, q.Prefetcher().Shutdown())
+
+	t.Log("Ensure that the root is cached but its child wasn't prefetched.")
+	testPrefetcherCheckGet(t, config.BlockCache(), rootPtr, root,
+		NoPrefetch, TransientEntry)
+	_, err = config.BlockCache().Get(aPtr)
+	require.EqualError(t, err, NoSuchBlockError{aPtr.ID}.Error())
+}
+
 func TestPrefetcherForSyncedTLF(t *testing.T) {
 	t.Log("Test synced TLF prefetching.")
 	q, bg, config := initPrefetcherTest(t)
@@ -1475,3 +1515,80 @@ func TestPrefetcherUnsyncedPrefetchParentCanceled(t *testing.T) {
 	// Then we wait for the pending prefetches to complete.
 	waitForPrefetchOrBust(t, q.Prefetcher().Shutdown())
 }
+
+func TestPrefetcherResumesInterruptedDeepSync(t *testing.T) {
+	t.Log("Test that a deep sync picks up from its persisted progress.")
+	cache, dbcConfig := initDiskBlockCacheTest(t)
+	q, bg, config := initPrefetcherTestWithDiskCache(t, cache)
+	defer shutdownPrefetcherTest(q)
+	prefetchSyncCh := make(chan struct{})
+	q.TogglePrefetcher(true, prefetchSyncCh)
+	notifySyncCh(t, prefetchSyncCh)
+
+	kmd := makeKMD()
+	config.SetTlfSyncState(kmd.TlfID(), true)
+	dbcConfig.SetTlfSyncState(kmd.TlfID(), true)
+
+	t.Log("Initialize a folder tree with structure: " +
+		"root -> {b, a -> {aa}}")
+	rootPtr := makeRandomBlockPointer(t)
+	root := &DirBlock{Children: map[string]DirEntry{
+		"a": makeRandomDirEntry(t, Dir, 10, "a"),
+		"b": makeRandomDirEntry(t, File, 20, "b"),
+	}}
+	aPtr := root.Children["a"].BlockPointer
+	a := &DirBlock{Children: map[string]DirEntry{
+		"aa": makeRandomDirEntry(t, File, 30, "aa"),
+	}}
+	aaPtr := a.Children["aa"].BlockPointer
+	aa := makeFakeFileBlock(t, true)
+	bPtr := root.Children["b"].BlockPointer
+	b := makeFakeFileBlock(t, true)
+
+	ctx := context.Background()
+	for ptr, block := range map[BlockPointer]Block{aPtr: a, aaPtr: aa, bPtr: b} {
+		_, _ = bg.setBlockToReturn(ptr, block)
+		enc, serverHalf := setupRealBlockForDiskCache(t, ptr, block, dbcConfig)
+		err := cache.Put(ctx, kmd.TlfID(), ptr.ID, enc, serverHalf)
+		require.NoError(t, err)
+	}
+
+	t.Log("Pretend that a previous run was interrupted while " +
+		"prefetching under root and a.")
+	progress := cache.getPrefetchProgress()
+	err := progress.put(kmd.TlfID(), prefetchProgressMarker{
+		Ptr: rootPtr, IsDir: true})
+	require.NoError(t, err)
+	err = progress.put(kmd.TlfID(), prefetchProgressMarker{
+		Ptr: aPtr, IsDir: true, Parent: rootPtr.ID})
+	require.NoError(t, err)
+
+	t.Log("Fetching any block of the TLF resumes the prefetch of a, " +
+		"without going through root.")
+	var block Block = &FileBlock{}
+	ch := q.Request(ctx, defaultOnDemandRequestPriority, kmd, bPtr, block,
+		TransientEntry)
+	err = <-ch
+	require.NoError(t, err)
+	// Release after prefetching b, which resumes a.
+	notifySyncCh(t, prefetchSyncCh)
+	// Release after prefetching a.
+	notifySyncCh(t, prefetchSyncCh)
+	// Release after prefetching aa.
+	notifySyncCh(t, prefetchSyncCh)
+	waitForPrefetchOrBust(t, q.Prefetcher().Shutdown())
+	q.TogglePrefetcher(true, prefetchSyncCh)
+	notifySyncCh(t, prefetchSyncCh)
+
+	testPrefetcherCheckGet(t, config.BlockCache(), aPtr, a,
+		FinishedPrefetch, TransientEntry)
+	testPrefetcherCheckGet(t, config.BlockCache(), aaPtr, aa,
+		FinishedPrefetch, TransientEntry)
+	_, err = config.BlockCache().Get(rootPtr)
+	require.IsType(t, NoSuchBlockError{}, err)
+
+	t.Log("Nothing is left to resume.")
+	frontier, err := progress.takeFrontier(kmd.TlfID())
+	require.NoError(t, err)
+	require.Len(t, frontier, 0)
+}
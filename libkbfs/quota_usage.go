@@ -11,6 +11,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
@@ -31,6 +32,8 @@ type cachedQuotaUsage struct {
 	limitBytes    int64
 	gitUsageBytes int64
 	gitLimitBytes int64
+	// folders maps a TLF ID string to the usage of that TLF.
+	folders map[string]*kbfsblock.UsageStat
 }
 
 // EventuallyConsistentQuotaUsage keeps tracks of quota usage, in a way user of
@@ -84,7 +87,6 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 	}
 
 	q.mu.Lock()
-	defer q.mu.Unlock()
 	q.cached.limitBytes = quotaInfo.Limit
 	q.cached.gitLimitBytes = quotaInfo.GitLimit
 	if quotaInfo.Total != nil {
@@ -93,8 +95,16 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 	} else {
 		q.cached.usageBytes = 0
 	}
+	q.cached.folders = quotaInfo.Folders
 	q.cached.timestamp = q.config.Clock().Now()
+	usageBytes, limitBytes := q.cached.usageBytes, q.cached.limitBytes
+	q.mu.Unlock()
 
+	// Check the warning thresholds outside of the lock, since
+	// that might report an error.
+	if kbfsOps, ok := q.config.KBFSOps().(*KBFSOpsStandard); ok {
+		kbfsOps.quotaNotifier.update(ctx, q.tid, usageBytes, limitBytes)
+	}
 	return nil
 }
 
@@ -155,3 +165,18 @@ func (q *EventuallyConsistentQuotaUsage) GetAllTypes(
 	return c.timestamp,
 		c.usageBytes, c.limitBytes, c.gitUsageBytes, c.gitLimitBytes, nil
 }
+
+// GetFolderUsage returns the data and git bytes used by the given
+// TLF, as of the last time the usage was fetched by Get or
+// GetAllTypes.  It returns zeroes if the TLF doesn't use any space
+// yet, or if the usage hasn't been fetched at all.
+func (q *EventuallyConsistentQuotaUsage) GetFolderUsage(tlfID tlf.ID) (
+	usageBytes, gitUsageBytes int64) {
+	c := q.getCached()
+	usage, ok := c.folders[tlfID.String()]
+	if !ok || usage == nil {
+		return 0, 0
+	}
+	return usage.Bytes[kbfsblock.UsageWrite],
+		usage.Bytes[kbfsblock.UsageGitWrite]
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// defaultQuotaUsageThresholds are the fractions of the quota limit
+// at which the user is warned by default.  Going over the limit
+// itself is already reported as an OverQuotaWarning when blocks are
+// put.
+var defaultQuotaUsageThresholds = []float64{0.8, 0.95}
+
+// quotaUsageNotifierConfig specifies the interfaces that a
+// quotaUsageNotifier needs to perform its functions.
+type quotaUsageNotifierConfig interface {
+	logMaker
+	Reporter() Reporter
+}
+
+// quotaUsageNotifier watches the quota usage fetched from the block
+// server, and reports a QuotaUsageWarning whenever the usage of the
+// logged-in user or of a team crosses one of its thresholds on the
+// way up.  Once the usage drops back below a threshold, crossing it
+// again warns again.
+type quotaUsageNotifier struct {
+	config quotaUsageNotifierConfig
+	log    logger.Logger
+
+	lock       sync.Mutex
+	thresholds []float64
+	// levels maps a team ID, or the nil team ID for the logged-in
+	// user, to how many thresholds its usage was over last time.
+	levels map[keybase1.TeamID]int
+}
+
+func newQuotaUsageNotifier(
+	config quotaUsageNotifierConfig) *quotaUsageNotifier {
+	return &quotaUsageNotifier{
+		config:     config,
+		log:        config.MakeLogger("QUN"),
+		thresholds: defaultQuotaUsageThresholds,
+		levels:     make(map[keybase1.TeamID]int),
+	}
+}
+
+// setThresholds replaces the warning thresholds, each given as a
+// fraction of the limit.
+func (n *quotaUsageNotifier) setThresholds(thresholds []float64) {
+	sorted := make([]float64, len(thresholds))
+	copy(sorted, thresholds)
+	sort.Float64s(sorted)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.thresholds = sorted
+	// Start over, so the current usage is checked against the new
+	// thresholds.
+	n.levels = make(map[keybase1.TeamID]int)
+}
+
+// reset forgets the usage levels seen so far, e.g. because a
+// different user logged in.
+func (n *quotaUsageNotifier) reset() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.levels = make(map[keybase1.TeamID]int)
+}
+
+// update records the latest data usage and limit for the given team
+// (or for the logged-in user, if tid is nil), and reports a warning
+// if the usage has just crossed a threshold.
+func (n *quotaUsageNotifier) update(ctx context.Context,
+	tid keybase1.TeamID, usageBytes, limitBytes int64) {
+	if limitBytes <= 0 {
+		return
+	}
+
+	warning, ok := func() (QuotaUsageWarning, bool) {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		level := 0
+		for _, t := range n.thresholds {
+			if float64(usageBytes) < t*float64(limitBytes) {
+				break
+			}
+			level++
+		}
+		prevLevel := n.levels[tid]
+		n.levels[tid] = level
+		if level <= prevLevel {
+			return QuotaUsageWarning{}, false
+		}
+		return QuotaUsageWarning{
+			UsageBytes: usageBytes,
+			LimitBytes: limitBytes,
+			Threshold:  n.thresholds[level-1],
+		}, true
+	}()
+	if !ok {
+		return
+	}
+
+	n.log.CDebugf(ctx, "Quota usage of %d/%d bytes "+
+		"crossed the %v threshold", usageBytes, limitBytes,
+		warning.Threshold)
+	n.config.Reporter().ReportErr(ctx, "", tlf.Private, WriteMode, warning)
+}
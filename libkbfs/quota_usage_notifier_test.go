@@ -0,0 +1,66 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testQuotaUsageNotifierConfig struct {
+	logMaker
+	reporter *ReporterSimple
+}
+
+func (c testQuotaUsageNotifierConfig) Reporter() Reporter {
+	return c.reporter
+}
+
+func TestQuotaUsageNotifier(t *testing.T) {
+	config := testQuotaUsageNotifierConfig{
+		newTestLogMaker(t), NewReporterSimple(wallClock{}, 10),
+	}
+	n := newQuotaUsageNotifier(config)
+	n.setThresholds([]float64{0.9, 0.5})
+	ctx := context.Background()
+	var user keybase1.TeamID
+	team := keybase1.MakeTestTeamID(1, false)
+
+	t.Log("Below all thresholds: no warning")
+	n.update(ctx, user, 40, 100)
+	require.Len(t, config.reporter.AllKnownErrors(), 0)
+
+	t.Log("Crossing a threshold warns once")
+	n.update(ctx, user, 60, 100)
+	n.update(ctx, user, 70, 100)
+	errs := config.reporter.AllKnownErrors()
+	require.Len(t, errs, 1)
+	require.Equal(t, QuotaUsageWarning{
+		UsageBytes: 60,
+		LimitBytes: 100,
+		Threshold:  0.5,
+	}, errors.Cause(errs[0].Error))
+
+	t.Log("Teams are tracked separately from the user")
+	n.update(ctx, team, 95, 100)
+	errs = config.reporter.AllKnownErrors()
+	require.Len(t, errs, 2)
+	require.Equal(t, 0.9, errors.Cause(errs[1].Error).(QuotaUsageWarning).Threshold)
+	n.update(ctx, user, 80, 100)
+	require.Len(t, config.reporter.AllKnownErrors(), 2)
+
+	t.Log("Dropping back below a threshold re-arms it")
+	n.update(ctx, user, 10, 100)
+	n.update(ctx, user, 55, 100)
+	require.Len(t, config.reporter.AllKnownErrors(), 3)
+
+	t.Log("No limit means no warnings")
+	n.update(ctx, keybase1.MakeTestTeamID(2, false), 100, 0)
+	require.Len(t, config.reporter.AllKnownErrors(), 3)
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfssync"
+	"golang.org/x/net/context"
+)
+
+// ReadAheadParams controls how far ahead of sequential reads of a
+// file KBFS fetches the file's blocks.
+type ReadAheadParams struct {
+	// Blocks is how many blocks past the end of a sequential read
+	// are fetched.  Zero disables read-ahead.
+	Blocks int
+	// Workers is how many of those blocks may be fetched in
+	// parallel, per file.
+	Workers int
+}
+
+// DefaultReadAheadParams returns the read-ahead parameters KBFS
+// starts out with.
+func DefaultReadAheadParams() ReadAheadParams {
+	return ReadAheadParams{
+		Blocks:  8,
+		Workers: 4,
+	}
+}
+
+// maxReadAheadStreams is how many files per folder the read-ahead
+// tracks at once.
+const maxReadAheadStreams = 64
+
+type ctxReadAheadTagKey int
+
+const (
+	ctxReadAheadIDKey ctxReadAheadTagKey = iota
+
+	ctxReadAheadID = "RAID"
+)
+
+// readAheadBatch is one round of blocks being fetched ahead of a
+// sequential reader.
+type readAheadBatch struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// readAheadStream is what the read-ahead knows about the reads of
+// one file.
+type readAheadStream struct {
+	// nextOff is where the next read of the file must start to
+	// count as sequential.
+	nextOff int64
+	// batch is non-nil while blocks are being fetched for this
+	// file.
+	batch *readAheadBatch
+}
+
+// readAhead detects sequential reads of the files in one folder, and
+// fetches the blocks that follow each sequential read into the block
+// cache, in parallel, so that the next read doesn't wait on the
+// network for one block at a time.  The fetches for a file are
+// canceled as soon as its reads stop being sequential, or as soon as
+// one of its reads fails, e.g. because the reader canceled it.
+type readAhead struct {
+	config Config
+	log    logger.Logger
+
+	// fetches counts the in-flight batches, for tests.
+	fetches kbfssync.RepeatedWaitGroup
+
+	lock       sync.Mutex
+	streams    map[BlockRef]*readAheadStream
+	isShutdown bool
+}
+
+func newReadAhead(config Config, log logger.Logger) *readAhead {
+	return &readAhead{
+		config:  config,
+		log:     log,
+		streams: make(map[BlockRef]*readAheadStream),
+	}
+}
+
+func (ra *readAhead) cancelBatchLocked(s *readAheadStream) {
+	if s.batch != nil {
+		s.batch.cancel()
+		s.batch = nil
+	}
+}
+
+// evictOneLocked forgets about one of the tracked files, preferring
+// one that isn't being fetched for.
+func (ra *readAhead) evictOneLocked() {
+	var victim BlockRef
+	for ref, s := range ra.streams {
+		victim = ref
+		if s.batch == nil {
+			break
+		}
+	}
+	ra.cancelBatchLocked(ra.streams[victim])
+	delete(ra.streams, victim)
+}
+
+// startBatch records the read of `n` bytes at `off` in the file
+// whose top block is `ref`, and returns a new batch if the read
+// continues a sequential run and nothing is being fetched for the
+// file yet.  Otherwise it returns nil.
+func (ra *readAhead) startBatch(
+	ref BlockRef, off, n int64, readErr error) *readAheadBatch {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	if ra.isShutdown {
+		return nil
+	}
+
+	s, ok := ra.streams[ref]
+	if readErr != nil || n == 0 {
+		// Don't keep fetching on behalf of a reader that has
+		// failed, given up or reached the end of the file.
+		if ok {
+			ra.cancelBatchLocked(s)
+			delete(ra.streams, ref)
+		}
+		return nil
+	}
+
+	if !ok {
+		if len(ra.streams) >= maxReadAheadStreams {
+			ra.evictOneLocked()
+		}
+		ra.streams[ref] = &readAheadStream{nextOff: off + n}
+		return nil
+	} else if s.nextOff != off {
+		// The reader jumped somewhere else in the file, so whatever
+		// is being fetched is probably not going to be read.
+		ra.cancelBatchLocked(s)
+		s.nextOff = off + n
+		return nil
+	}
+
+	s.nextOff = off + n
+	if s.batch != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(CtxWithRandomIDReplayable(
+		context.Background(), ctxReadAheadIDKey, ctxReadAheadID, ra.log))
+	s.batch = &readAheadBatch{ctx, cancel}
+	return s.batch
+}
+
+// finishBatch marks `batch` as done, and releases its context.
+func (ra *readAhead) finishBatch(ref BlockRef, batch *readAheadBatch) {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	batch.cancel()
+	if s, ok := ra.streams[ref]; ok && s.batch == batch {
+		s.batch = nil
+	}
+}
+
+// afterRead must be called after every read of `fd` by a user, while
+// still holding `blockLock`, with the read's offset, byte count and
+// error.  If the read continues a sequential run, it starts fetching
+// the blocks after the read in the background.
+func (ra *readAhead) afterRead(
+	ctx context.Context, fd *fileData, off, n int64, readErr error) {
+	params := ra.config.ReadAhead()
+	if params.Blocks <= 0 {
+		return
+	}
+
+	ref := fd.rootBlockPointer().Ref()
+	batch := ra.startBatch(ref, off, n, readErr)
+	if batch == nil {
+		return
+	}
+
+	// The indirect blocks were just read, so this is usually served
+	// entirely from the block cache.
+	ptrs, err := fd.getLeafPtrsFromOffset(ctx, off+n, params.Blocks)
+	if err != nil || len(ptrs) == 0 {
+		if err != nil {
+			ra.log.CDebugf(ctx, "Couldn't find blocks to read ahead: %+v",
+				err)
+		}
+		ra.finishBatch(ref, batch)
+		return
+	}
+
+	ra.log.CDebugf(ctx, "Reading ahead %d blocks of %v from offset %d",
+		len(ptrs), fd.rootBlockPointer(), off+n)
+	ra.fetches.Add(1)
+	go func() {
+		defer ra.fetches.Done()
+		defer ra.finishBatch(ref, batch)
+		ra.fetch(batch.ctx, fd.kmd, ptrs, params.Workers)
+	}()
+}
+
+// fetch gets the given blocks into the block cache, with at most
+// `workers` of them being fetched at once.
+func (ra *readAhead) fetch(ctx context.Context, kmd KeyMetadata,
+	ptrs []BlockPointer, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, ptr := range ptrs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func(ptr BlockPointer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ch := ra.config.BlockOps().BlockRetriever().RequestNoPrefetch(
+				ctx, defaultOnDemandRequestPriority, kmd, ptr,
+				NewFileBlock(), TransientEntry)
+			select {
+			case err := <-ch:
+				if err != nil {
+					ra.log.CDebugf(ctx, "Couldn't read ahead block %v: %+v",
+						ptr, err)
+				}
+			case <-ctx.Done():
+			}
+		}(ptr)
+	}
+}
+
+// shutdown cancels all the read-ahead fetches, and stops new ones
+// from starting.
+func (ra *readAhead) shutdown() {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	ra.isShutdown = true
+	for ref, s := range ra.streams {
+		ra.cancelBatchLocked(s)
+		delete(ra.streams, ref)
+	}
+}
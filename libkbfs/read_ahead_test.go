@@ -0,0 +1,60 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadSequentialDetection(t *testing.T) {
+	ra := newReadAhead(nil, logger.NewTestLogger(t))
+	ref := BlockRef{ID: kbfsblock.FakeID(1)}
+
+	t.Log("The first read of a file doesn't trigger a read-ahead")
+	require.Nil(t, ra.startBatch(ref, 0, 10, nil))
+
+	t.Log("A read that continues where the last one ended does")
+	batch := ra.startBatch(ref, 10, 10, nil)
+	require.NotNil(t, batch)
+
+	t.Log("Only one batch per file is fetched at a time")
+	require.Nil(t, ra.startBatch(ref, 20, 10, nil))
+	ra.finishBatch(ref, batch)
+	require.Error(t, batch.ctx.Err())
+	batch = ra.startBatch(ref, 30, 10, nil)
+	require.NotNil(t, batch)
+
+	t.Log("A jump elsewhere in the file cancels the fetch")
+	require.Nil(t, ra.startBatch(ref, 100, 10, nil))
+	require.Error(t, batch.ctx.Err())
+	batch = ra.startBatch(ref, 110, 10, nil)
+	require.NotNil(t, batch)
+
+	t.Log("A failed read cancels the fetch and forgets the file")
+	require.Nil(t, ra.startBatch(ref, 120, 0, errors.New("canceled")))
+	require.Error(t, batch.ctx.Err())
+	require.Nil(t, ra.startBatch(ref, 120, 10, nil))
+
+	t.Log("No fetches start after shutdown")
+	batch = ra.startBatch(ref, 130, 10, nil)
+	require.NotNil(t, batch)
+	ra.shutdown()
+	require.Error(t, batch.ctx.Err())
+	require.Nil(t, ra.startBatch(ref, 140, 10, nil))
+}
+
+func TestReadAheadEviction(t *testing.T) {
+	ra := newReadAhead(nil, logger.NewTestLogger(t))
+	for i := 0; i < maxReadAheadStreams+1; i++ {
+		ref := BlockRef{ID: kbfsblock.FakeID(byte(i))}
+		require.Nil(t, ra.startBatch(ref, 0, 10, nil))
+	}
+	require.Len(t, ra.streams, maxReadAheadStreams)
+}
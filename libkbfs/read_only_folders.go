@@ -0,0 +1,62 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SetFolderReadOnly sets whether the given TLF is opened read-only.
+// A folder opened read-only still follows the changes other devices
+// make to it, but any attempt to change it fails with a
+// ReadOnlyError, and it never flushes, journals, rekeys or reclaims
+// quota in the background.  The mode takes effect the next time the
+// folder starts up, so if it's already running, it's shut down
+// first, which fails if it's in use; see SetFolderEviction for when
+// a folder counts as idle.
+func (fs *KBFSOpsStandard) SetFolderReadOnly(
+	ctx context.Context, tlfID tlf.ID, readOnly bool) error {
+	if fs.isFolderReadOnly(tlfID) == readOnly {
+		return nil
+	}
+	fs.setFolderReadOnly(tlfID, readOnly)
+
+	fb := FolderBranch{tlfID, MasterBranch}
+	ops, ok := fs.ops.get(fb)
+	if !ok {
+		return nil
+	}
+	_, uses := ops.getLastUsed()
+	evicted, err := fs.evictFolder(ctx, fb, ops, uses)
+	if err == nil && !evicted {
+		err = errors.Errorf(
+			"Can't change the open mode of %s while it's in use", fb)
+	}
+	if err != nil {
+		fs.setFolderReadOnly(tlfID, !readOnly)
+		return err
+	}
+	fs.log.CDebugf(ctx, "Shut down %s to reopen it with readOnly=%t",
+		fb, readOnly)
+	return nil
+}
+
+func (fs *KBFSOpsStandard) setFolderReadOnly(tlfID tlf.ID, readOnly bool) {
+	fs.readOnlyLock.Lock()
+	defer fs.readOnlyLock.Unlock()
+	if readOnly {
+		fs.readOnlyTlfs[tlfID] = true
+	} else {
+		delete(fs.readOnlyTlfs, tlfID)
+	}
+}
+
+func (fs *KBFSOpsStandard) isFolderReadOnly(tlfID tlf.ID) bool {
+	fs.readOnlyLock.Lock()
+	defer fs.readOnlyLock.Unlock()
+	return fs.readOnlyTlfs[tlfID]
+}
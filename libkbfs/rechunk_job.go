@@ -0,0 +1,611 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	stdpath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxRechunkJobTagKey is the type used for unique context tags within
+// a rechunk job.
+type CtxRechunkJobTagKey int
+
+const (
+	// CtxRechunkJobIDKey is the type of the tag for unique operation
+	// IDs within a rechunk job.
+	CtxRechunkJobIDKey CtxRechunkJobTagKey = iota
+)
+
+// CtxRechunkJobOpID is the display name for the unique operation
+// rechunk job ID tag.
+const CtxRechunkJobOpID = "RCID"
+
+const (
+	// rechunkJobIdleTime is how long a folder must go without being
+	// used before a rechunk job does any work in it.
+	rechunkJobIdleTime = 30 * time.Second
+	// rechunkJobRetryInterval is how long a rechunk job waits to
+	// start over after failing to list the files of its folder.
+	rechunkJobRetryInterval = time.Minute
+	// rechunkJobCopySize is how much file data a rechunk job copies
+	// at a time.  The job checks for activity in the folder between
+	// copies.
+	rechunkJobCopySize = 512 * 1024
+	// rechunkTempPrefix starts the names of the temporary files that
+	// rechunk jobs write new copies of files into.  It's one of the
+	// disallowedPrefixes, so users can't make files with such names.
+	rechunkTempPrefix = ".kbfs_rechunk."
+)
+
+// RechunkJobStatus describes the progress of a rechunk job.
+type RechunkJobStatus struct {
+	Folder FolderBranch
+	// Scanned is true once the job has listed all the files in the
+	// folder, and FilesTotal and BytesTotal are final.
+	Scanned    bool
+	FilesTotal uint64
+	BytesTotal uint64
+	// FilesChecked and BytesChecked count the files whose layout
+	// has been checked, including the ones that were rewritten.
+	FilesChecked uint64
+	BytesChecked uint64
+	// FilesRewritten and BytesRewritten count the files that were
+	// rewritten to the new layout.
+	FilesRewritten uint64
+	BytesRewritten uint64
+	// Current is the path of the file being checked or rewritten,
+	// relative to the root of the folder.
+	Current string
+	Paused  bool
+	Done    bool
+	// LastError is the last error the job ran into.  The job
+	// skips files it fails to rewrite.
+	LastError string
+}
+
+type rechunkCandidate struct {
+	dir  Node
+	name string
+	path string
+	size uint64
+}
+
+// rechunkJob rewrites the files of a folder whose blocks don't end
+// where the configured block splitter would end them, for example
+// because the block size or the splitting strategy changed since
+// they were written.  Each file is copied into a temporary file with
+// the same attributes, which then replaces the original, so readers
+// never see a partly rewritten file.  The job only works while
+// nothing else uses the folder, and a file that changes while it's
+// being copied is left alone.
+type rechunkJob struct {
+	fs       *KBFSOpsStandard
+	fb       FolderBranch
+	idleTime time.Duration
+
+	wakeCh     chan struct{}
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+
+	statusLock sync.Mutex
+	status     RechunkJobStatus
+}
+
+// StartRechunkJob starts rewriting, in the background, the files in
+// the given folder that don't match the current block size and
+// splitting strategy.  The job waits until the folder has been idle
+// for a while, and stops working whenever the folder is used again.
+// The folder is kept running until the job is done, and any errors
+// are recorded in the job's status.  Files being rewritten show up
+// briefly as hidden temporary files next to the originals.
+func (fs *KBFSOpsStandard) StartRechunkJob(
+	ctx context.Context, fb FolderBranch) (err error) {
+	_, done, err := fs.startOp(ctx, OpTimeoutWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	return fs.startRechunkJob(fb, rechunkJobIdleTime)
+}
+
+func (fs *KBFSOpsStandard) startRechunkJob(
+	fb FolderBranch, idleTime time.Duration) error {
+	if fb.Branch.IsArchived() {
+		return errors.Errorf("Can't rechunk archived folder %s", fb)
+	}
+	j := &rechunkJob{
+		fs:         fs,
+		fb:         fb,
+		idleTime:   idleTime,
+		wakeCh:     make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		status:     RechunkJobStatus{Folder: fb},
+	}
+	fs.rechunkJobsLock.Lock()
+	defer fs.rechunkJobsLock.Unlock()
+	if _, ok := fs.rechunkJobs[fb]; ok {
+		return errors.Errorf("A rechunk job for %s already exists", fb)
+	}
+	fs.rechunkJobs[fb] = j
+	go j.loop()
+	return nil
+}
+
+func (fs *KBFSOpsStandard) getRechunkJob(
+	fb FolderBranch) (*rechunkJob, error) {
+	fs.rechunkJobsLock.Lock()
+	defer fs.rechunkJobsLock.Unlock()
+	j, ok := fs.rechunkJobs[fb]
+	if !ok {
+		return nil, errors.Errorf("No rechunk job for %s", fb)
+	}
+	return j, nil
+}
+
+// PauseRechunkJob pauses the rechunk job for the given folder, after
+// the piece of work it's doing now, until ResumeRechunkJob is
+// called.
+func (fs *KBFSOpsStandard) PauseRechunkJob(fb FolderBranch) error {
+	j, err := fs.getRechunkJob(fb)
+	if err != nil {
+		return err
+	}
+	j.setPaused(true)
+	return nil
+}
+
+// ResumeRechunkJob resumes a paused rechunk job.
+func (fs *KBFSOpsStandard) ResumeRechunkJob(fb FolderBranch) error {
+	j, err := fs.getRechunkJob(fb)
+	if err != nil {
+		return err
+	}
+	j.setPaused(false)
+	return nil
+}
+
+// StopRechunkJob stops the rechunk job for the given folder, and
+// waits for it to finish the piece of work it's doing now.  Finished
+// jobs must be stopped too, before a new job can start.
+func (fs *KBFSOpsStandard) StopRechunkJob(
+	ctx context.Context, fb FolderBranch) error {
+	fs.rechunkJobsLock.Lock()
+	j, ok := fs.rechunkJobs[fb]
+	delete(fs.rechunkJobs, fb)
+	fs.rechunkJobsLock.Unlock()
+	if !ok {
+		return errors.Errorf("No rechunk job for %s", fb)
+	}
+	close(j.shutdownCh)
+	select {
+	case <-j.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopRechunkJobs stops all the rechunk jobs.
+func (fs *KBFSOpsStandard) stopRechunkJobs(ctx context.Context) error {
+	fs.rechunkJobsLock.Lock()
+	fbs := make([]FolderBranch, 0, len(fs.rechunkJobs))
+	for fb := range fs.rechunkJobs {
+		fbs = append(fbs, fb)
+	}
+	fs.rechunkJobsLock.Unlock()
+	for _, fb := range fbs {
+		err := fs.StopRechunkJob(ctx, fb)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RechunkJobStatuses returns the status of every rechunk job that
+// hasn't been stopped, sorted by folder.
+func (fs *KBFSOpsStandard) RechunkJobStatuses() []RechunkJobStatus {
+	fs.rechunkJobsLock.Lock()
+	defer fs.rechunkJobsLock.Unlock()
+	statuses := make([]RechunkJobStatus, 0, len(fs.rechunkJobs))
+	for _, j := range fs.rechunkJobs {
+		statuses = append(statuses, j.getStatus())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Folder.String() < statuses[j].Folder.String()
+	})
+	return statuses
+}
+
+func (j *rechunkJob) getStatus() RechunkJobStatus {
+	j.statusLock.Lock()
+	defer j.statusLock.Unlock()
+	return j.status
+}
+
+func (j *rechunkJob) updateStatus(f func(status *RechunkJobStatus)) {
+	j.statusLock.Lock()
+	defer j.statusLock.Unlock()
+	f(&j.status)
+}
+
+func (j *rechunkJob) setPaused(paused bool) {
+	j.updateStatus(func(status *RechunkJobStatus) {
+		status.Paused = paused
+	})
+	select {
+	case j.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (j *rechunkJob) setError(ctx context.Context, err error) {
+	j.fs.log.CWarningf(ctx, "Rechunk job for %s: %+v", j.fb, err)
+	j.updateStatus(func(status *RechunkJobStatus) {
+		status.LastError = err.Error()
+	})
+}
+
+func (j *rechunkJob) loop() {
+	defer close(j.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxRechunkJobIDKey, CtxRechunkJobOpID, j.fs.log))
+	defer cancel()
+	// Writes need a context that can delay their cancellation.
+	ctx, err := NewContextWithCancellationDelayer(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer CleanupCancellationDelayer(ctx)
+	go func() {
+		select {
+		case <-j.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		err := j.run(ctx)
+		if err == nil {
+			j.updateStatus(func(status *RechunkJobStatus) {
+				status.Current = ""
+				status.Done = true
+			})
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		j.setError(ctx, err)
+		select {
+		case <-time.After(rechunkJobRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// waitUntilIdle returns once the job isn't paused, and nothing has
+// used the folder for at least the job's idle time.  The job itself
+// calls `ops` directly, so its own work doesn't count as a use.
+func (j *rechunkJob) waitUntilIdle(
+	ctx context.Context, ops *folderBranchOps) error {
+	for {
+		var timerCh <-chan time.Time
+		if !j.getStatus().Paused {
+			lastUsed, _ := ops.getLastUsed()
+			idle := j.fs.config.Clock().Now().Sub(lastUsed)
+			if idle >= j.idleTime {
+				return nil
+			}
+			timerCh = time.After(j.idleTime - idle)
+		}
+		select {
+		case <-j.wakeCh:
+		case <-timerCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// run checks, and if needed rewrites, every file in the folder once.
+func (j *rechunkJob) run(ctx context.Context) error {
+	j.updateStatus(func(status *RechunkJobStatus) {
+		*status = RechunkJobStatus{Folder: j.fb, Paused: status.Paused}
+	})
+
+	// Holding on to the root node keeps the folder from being shut
+	// down while the job runs.
+	ops := j.fs.getOpsNoAdd(ctx, j.fb)
+	rootNode, _, _, err := ops.getRootNode(ctx)
+	if err != nil {
+		return err
+	}
+	err = j.waitUntilIdle(ctx, ops)
+	if err != nil {
+		return err
+	}
+	var files []rechunkCandidate
+	err = j.scan(ctx, ops, "", rootNode, &files)
+	if err != nil {
+		return err
+	}
+	j.updateStatus(func(status *RechunkJobStatus) {
+		status.Scanned = true
+	})
+
+	for _, f := range files {
+		err := j.waitUntilIdle(ctx, ops)
+		if err != nil {
+			return err
+		}
+		j.updateStatus(func(status *RechunkJobStatus) {
+			status.Current = f.path
+		})
+		rewritten, err := j.rechunkFile(ctx, ops, f)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			j.setError(ctx, errors.WithMessage(err, f.path))
+		}
+		j.updateStatus(func(status *RechunkJobStatus) {
+			status.FilesChecked++
+			status.BytesChecked += f.size
+			if rewritten {
+				status.FilesRewritten++
+				status.BytesRewritten += f.size
+			}
+		})
+	}
+	return nil
+}
+
+// scan appends every non-empty file under `dir` to `files`, in a
+// stable order.  Temporary files left behind by rechunk jobs are
+// skipped.
+func (j *rechunkJob) scan(ctx context.Context, ops *folderBranchOps,
+	p string, dir Node, files *[]rechunkCandidate) error {
+	children, err := ops.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ei := children[name]
+		childPath := stdpath.Join(p, name)
+		switch ei.Type {
+		case File, Exec:
+			if ei.Size == 0 || strings.HasPrefix(name, rechunkTempPrefix) {
+				continue
+			}
+			*files = append(*files, rechunkCandidate{
+				dir: dir, name: name, path: childPath, size: ei.Size})
+			j.updateStatus(func(status *RechunkJobStatus) {
+				status.FilesTotal++
+				status.BytesTotal += ei.Size
+			})
+		case Dir:
+			child, _, err := ops.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+			err = j.scan(ctx, ops, childPath, child, files)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rechunkFile rewrites the file described by `f`, if its layout
+// doesn't match the current block splitter, and returns whether it
+// did.  A file that was removed or changed since it was listed is
+// skipped.
+func (j *rechunkJob) rechunkFile(ctx context.Context, ops *folderBranchOps,
+	f rechunkCandidate) (rewritten bool, err error) {
+	file, ei, err := ops.Lookup(ctx, f.dir, f.name)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if ei.Type != File && ei.Type != Exec {
+		return false, nil
+	}
+	matches, err := ops.fileMatchesBlockSplitter(ctx, file)
+	if err != nil || matches {
+		return false, err
+	}
+	md, err := ops.GetNodeMetadata(ctx, file)
+	if err != nil {
+		return false, err
+	}
+	oldPtr := md.BlockInfo.BlockPointer
+
+	tmpName := rechunkTempPrefix +
+		strconv.FormatInt(j.fs.config.Clock().Now().UnixNano(), 36)
+	// The temporary name has a reserved prefix, so users can't
+	// collide with it, but the job itself needs to be allowed to
+	// use it.
+	ctx = context.WithValue(ctx, CtxAllowNameKey, tmpName)
+	tmp, _, err := ops.CreateFile(ctx, f.dir, tmpName, ei.Type == Exec,
+		WithExcl)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if rewritten {
+			return
+		}
+		removeErr := ops.RemoveEntry(ctx, f.dir, tmpName)
+		if removeErr != nil {
+			j.fs.log.CDebugf(ctx, "Couldn't remove %s: %+v",
+				tmpName, removeErr)
+		}
+	}()
+
+	err = j.copyFile(ctx, ops, file, tmp, ei)
+	if err != nil {
+		return false, err
+	}
+	err = ops.SyncAll(ctx, j.fb)
+	if err != nil {
+		return false, err
+	}
+
+	// Only replace the original if nobody changed it in the
+	// meantime.  There's still a short window for a change to slip
+	// in before the rename, but since the folder has to be idle
+	// first, that's very unlikely.
+	err = j.waitUntilIdle(ctx, ops)
+	if err != nil {
+		return false, err
+	}
+	if ops.blocks.GetState(makeFBOLockState()) != cleanState {
+		return false, nil
+	}
+	file, _, err = ops.Lookup(ctx, f.dir, f.name)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	md, err = ops.GetNodeMetadata(ctx, file)
+	if err != nil {
+		return false, err
+	}
+	if md.BlockInfo.BlockPointer != oldPtr {
+		j.fs.log.CDebugf(ctx, "%s changed while being rechunked", f.path)
+		return false, nil
+	}
+	err = ops.Rename(ctx, f.dir, tmpName, f.dir, f.name)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// copyFile copies the contents and attributes of `file`, whose entry
+// is `ei`, into the new file `tmp`.  Runs of zeroes are left as holes
+// where possible.
+func (j *rechunkJob) copyFile(ctx context.Context, ops *folderBranchOps,
+	file, tmp Node, ei EntryInfo) error {
+	buf := make([]byte, rechunkJobCopySize)
+	for off := int64(0); off < int64(ei.Size); {
+		err := j.waitUntilIdle(ctx, ops)
+		if err != nil {
+			return err
+		}
+		n, err := ops.Read(ctx, file, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if !isAllZeroes(buf[:n]) {
+			err = ops.Write(ctx, tmp, buf[:n], off)
+			if err != nil {
+				return err
+			}
+		}
+		off += n
+	}
+	err := ops.Truncate(ctx, tmp, ei.Size)
+	if err != nil {
+		return err
+	}
+
+	names, err := ops.ListXattr(ctx, file)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		value, err := ops.GetXattr(ctx, file, name)
+		if err != nil {
+			return err
+		}
+		err = ops.SetXattr(ctx, tmp, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	if ei.Mode != 0 {
+		err = ops.SetMode(ctx, tmp, ei.Perm())
+		if err != nil {
+			return err
+		}
+	}
+	mtime := time.Unix(0, ei.Mtime)
+	return ops.SetMtime(ctx, tmp, &mtime)
+}
+
+func isAllZeroes(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fileMatchesBlockSplitter returns whether the leaf blocks of `file`
+// all end where the configured block splitter would end them, if the
+// file were written again from scratch.
+func (fbo *folderBranchOps) fileMatchesBlockSplitter(
+	ctx context.Context, file Node) (matches bool, err error) {
+	fbo.log.CDebugf(ctx, "fileMatchesBlockSplitter %s", getNodeIDStr(file))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "fileMatchesBlockSplitter %s (%t) done: %+v",
+			getNodeIDStr(file), matches, err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return false, err
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call.
+	var result bool
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+		filePath, err := fbo.pathFromNodeForRead(file)
+		if err != nil {
+			return err
+		}
+		result, err = fbo.blocks.MatchesBlockSplitter(
+			ctx, lState, md, filePath)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return result, nil
+}
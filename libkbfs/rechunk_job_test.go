@@ -0,0 +1,117 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRechunkJob(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetBlockSplitter(&BlockSplitterSimple{5, 2, 100 * 1024})
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	ops := getOps(config, fb.Tlf)
+
+	t.Log("Write an exec file with 5-byte blocks, and a small file")
+	data := []byte("0123456789abcdefghij")
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, dNode, "f", true, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, fNode, "user.a", []byte("b"))
+	require.NoError(t, err)
+	mtime := time.Unix(1234, 0)
+	err = kbfsOps.SetMtime(ctx, fNode, &mtime)
+	require.NoError(t, err)
+	smallNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "small", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, smallNode, []byte("abc"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	matches, err := ops.fileMatchesBlockSplitter(ctx, fNode)
+	require.NoError(t, err)
+	require.True(t, matches)
+	smallMd, err := kbfsOps.GetNodeMetadata(ctx, smallNode)
+	require.NoError(t, err)
+
+	t.Log("Switch to 8-byte blocks")
+	config.SetBlockSplitter(&BlockSplitterSimple{8, 2, 100 * 1024})
+	matches, err = ops.fileMatchesBlockSplitter(ctx, fNode)
+	require.NoError(t, err)
+	require.False(t, matches)
+
+	t.Log("Rechunk the folder")
+	err = kbfsOps.startRechunkJob(fb, 0)
+	require.NoError(t, err)
+	err = kbfsOps.startRechunkJob(fb, 0)
+	require.Error(t, err)
+	err = kbfsOps.PauseRechunkJob(fb)
+	require.NoError(t, err)
+	require.True(t, kbfsOps.RechunkJobStatuses()[0].Paused)
+	err = kbfsOps.ResumeRechunkJob(fb)
+	require.NoError(t, err)
+	j, err := kbfsOps.getRechunkJob(fb)
+	require.NoError(t, err)
+	select {
+	case <-j.doneCh:
+	case <-ctx.Done():
+		t.Fatalf("Rechunk job isn't done: %+v", j.getStatus())
+	}
+	statuses := kbfsOps.RechunkJobStatuses()
+	require.Len(t, statuses, 1)
+	status := statuses[0]
+	require.Equal(t, RechunkJobStatus{
+		Folder:         fb,
+		Scanned:        true,
+		FilesTotal:     2,
+		BytesTotal:     23,
+		FilesChecked:   2,
+		BytesChecked:   23,
+		FilesRewritten: 1,
+		BytesRewritten: 20,
+		Done:           true,
+	}, status)
+
+	t.Log("The file has the new layout, and the same contents and attrs")
+	newFNode, ei, err := kbfsOps.Lookup(ctx, dNode, "f")
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	require.Equal(t, mtime.UnixNano(), ei.Mtime)
+	buf := make([]byte, len(data)+1)
+	n, err := kbfsOps.Read(ctx, newFNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+	value, err := kbfsOps.GetXattr(ctx, newFNode, "user.a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), value)
+	matches, err = ops.fileMatchesBlockSplitter(ctx, newFNode)
+	require.NoError(t, err)
+	require.True(t, matches)
+	children, err := kbfsOps.GetDirChildren(ctx, dNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+
+	t.Log("The small file already matched, and wasn't rewritten")
+	md, err := kbfsOps.GetNodeMetadata(ctx, smallNode)
+	require.NoError(t, err)
+	require.Equal(t, smallMd.BlockInfo, md.BlockInfo)
+
+	err = kbfsOps.StopRechunkJob(ctx, fb)
+	require.NoError(t, err)
+	require.Empty(t, kbfsOps.RechunkJobStatuses())
+}
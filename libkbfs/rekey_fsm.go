@@ -342,16 +342,21 @@ type rekeyStateStarted struct {
 }
 
 func newRekeyStateStarted(fsm *rekeyFSM, task rekeyTask) *rekeyStateStarted {
-	ctx := task.ctx.context()
-	var cancel context.CancelFunc
+	policyCtx, cancel := withOpTimeout(
+		task.ctx.context(), fsm.fbo.config, OpTimeoutRekey)
+	ctx := policyCtx
 	if task.timeout != nil {
-		ctx, cancel = context.WithTimeout(task.ctx.context(), *task.timeout)
+		var cancelTask context.CancelFunc
+		ctx, cancelTask = context.WithTimeout(ctx, *task.timeout)
+		cancelPolicy := cancel
+		cancel = func() {
+			cancelTask()
+			cancelPolicy()
+		}
 	}
 	go func() {
 		defer fsm.fbo.config.GetRekeyFSMLimiter().Done()
-		if cancel != nil {
-			defer cancel()
-		}
+		defer cancel()
 		fsm.log.CDebugf(ctx, "Processing rekey for %s", fsm.fbo.folderBranch.Tlf)
 		var res RekeyResult
 		err := fsm.fbo.doMDWriteWithRetryUnlessCanceled(ctx,
@@ -359,6 +364,8 @@ func newRekeyStateStarted(fsm *rekeyFSM, task rekeyTask) *rekeyStateStarted {
 				res, err = fsm.fbo.rekeyLocked(ctx, lState, task.promptPaper)
 				return err
 			})
+		err = opTimeoutError(task.ctx.context(), policyCtx, fsm.fbo.config,
+			OpTimeoutRekey, err)
 		fsm.log.CDebugf(ctx, "Rekey finished with res=%#+v, error=%v", res, err)
 		fsm.Event(newRekeyFinishedEvent(res, err))
 	}()
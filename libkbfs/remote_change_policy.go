@@ -0,0 +1,150 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// RemoteChangePolicy says what a folder does when another device
+// changes it while it has local changes that haven't been synced
+// yet.  Whatever the policy, remote changes are never applied on top
+// of unsynced local changes; once the local changes are synced,
+// conflict resolution merges the two, renaming any local copy of a
+// file that was changed on both sides.
+type RemoteChangePolicy int
+
+const (
+	// RemoteChangeLocalWins keeps showing the local changes, and
+	// only merges the remote ones once the local changes are synced,
+	// when the file is closed or flushed, or by the background
+	// flusher.
+	RemoteChangeLocalWins RemoteChangePolicy = iota
+	// RemoteChangeConflictNow syncs the local changes as soon as a
+	// remote change is seen, so conflict resolution starts right
+	// away.
+	RemoteChangeConflictNow
+	// RemoteChangeNotifyAndBlock reports the remote change to the
+	// user, and makes new local writes to the folder wait until the
+	// local and remote changes have been merged.
+	RemoteChangeNotifyAndBlock
+)
+
+func (p RemoteChangePolicy) String() string {
+	switch p {
+	case RemoteChangeLocalWins:
+		return "local-wins"
+	case RemoteChangeConflictNow:
+		return "conflict-now"
+	case RemoteChangeNotifyAndBlock:
+		return "notify-and-block"
+	default:
+		return fmt.Sprintf("RemoteChangePolicy(%d)", int(p))
+	}
+}
+
+// ParseRemoteChangePolicy parses a RemoteChangePolicy from its
+// string form.
+func ParseRemoteChangePolicy(s string) (RemoteChangePolicy, error) {
+	switch s {
+	case "local-wins":
+		return RemoteChangeLocalWins, nil
+	case "conflict-now":
+		return RemoteChangeConflictNow, nil
+	case "notify-and-block":
+		return RemoteChangeNotifyAndBlock, nil
+	default:
+		return 0, errors.Errorf("Unknown remote change policy %q", s)
+	}
+}
+
+// Set implements the flag.Value interface for RemoteChangePolicy.
+func (p *RemoteChangePolicy) Set(s string) error {
+	policy, err := ParseRemoteChangePolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// handleRemoteChangeWhileDirtyLocked applies the configured
+// RemoteChangePolicy after the remote revision `rev` couldn't be
+// applied because of unsynced local changes.
+func (fbo *folderBranchOps) handleRemoteChangeWhileDirtyLocked(
+	ctx context.Context, lState *lockState, rev kbfsmd.Revision) {
+	fbo.mdWriterLock.AssertLocked(lState)
+	fbo.headLock.AssertLocked(lState)
+
+	if rev <= fbo.remoteChangeRev {
+		// Already handled.
+		return
+	}
+	fbo.remoteChangeRev = rev
+
+	policy := fbo.config.RemoteChangePolicy()
+	fbo.log.CDebugf(ctx, "Remote revision %d arrived while dirty; "+
+		"policy=%s", rev, policy)
+	switch policy {
+	case RemoteChangeConflictNow:
+		// The background flusher needs mdWriterLock to sync, so
+		// don't wait for it here.
+		go func() {
+			select {
+			case fbo.blocks.forceSyncChan <- struct{}{}:
+			case <-fbo.shutdownChan:
+			}
+		}()
+	case RemoteChangeNotifyAndBlock:
+		if fbo.remoteChangeDone == nil {
+			fbo.remoteChangeDone = make(chan struct{})
+		}
+		handle := fbo.head.GetTlfHandle()
+		fbo.config.Reporter().ReportErr(
+			ctx, handle.GetCanonicalName(), handle.Type(), WriteMode,
+			RemoteChangeWhileDirtyError{rev})
+	}
+}
+
+// maybeUnblockRemoteChangeLocked lets local writes continue once
+// `md`, the new head, includes the remote change that blocked them.
+func (fbo *folderBranchOps) maybeUnblockRemoteChangeLocked(
+	ctx context.Context, lState *lockState, md ImmutableRootMetadata) {
+	fbo.headLock.AssertLocked(lState)
+	if fbo.remoteChangeDone == nil ||
+		md.MergedStatus() != kbfsmd.Merged ||
+		md.Revision() < fbo.remoteChangeRev {
+		return
+	}
+	fbo.log.CDebugf(ctx, "Remote revision %d has been merged; "+
+		"unblocking local writes", fbo.remoteChangeRev)
+	close(fbo.remoteChangeDone)
+	fbo.remoteChangeDone = nil
+}
+
+// waitForRemoteChangeMerged waits until any remote change that is
+// blocking local writes has been merged.
+func (fbo *folderBranchOps) waitForRemoteChangeMerged(
+	ctx context.Context, lState *lockState) error {
+	fbo.headLock.RLock(lState)
+	doneCh := fbo.remoteChangeDone
+	fbo.headLock.RUnlock(lState)
+	if doneCh == nil {
+		return nil
+	}
+
+	fbo.log.CDebugf(ctx, "Waiting for a remote change to be merged "+
+		"before writing")
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
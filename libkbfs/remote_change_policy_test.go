@@ -0,0 +1,88 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseRemoteChangePolicy(t *testing.T) {
+	for _, p := range []RemoteChangePolicy{
+		RemoteChangeLocalWins, RemoteChangeConflictNow,
+		RemoteChangeNotifyAndBlock} {
+		parsed, err := ParseRemoteChangePolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseRemoteChangePolicy("remote-wins")
+	require.Error(t, err)
+}
+
+func TestRemoteChangeNotifyAndBlock(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+	config1.SetRemoteChangePolicy(RemoteChangeNotifyAndBlock)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	_, err = DisableUpdatesForTesting(config1, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("User 2 changes the file, while user 1 has unsynced changes")
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.Write(ctx, fileNode2, []byte{2}, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fileNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1}, 0)
+	require.NoError(t, err)
+
+	t.Log("User 1 hears about the change, and is notified")
+	ops1 := getOps(config1, rootNode1.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	err = ops1.getAndApplyMDUpdates(ctx, lState, nil, ops1.applyMDUpdates)
+	require.IsType(t, NoUpdatesWhileDirtyError{}, errors.Cause(err))
+	errs := config1.Reporter().AllKnownErrors()
+	require.Len(t, errs, 1)
+	require.IsType(t, RemoteChangeWhileDirtyError{}, errs[0].Error)
+
+	t.Log("New writes wait until the changes are merged")
+	writeCtx, writeCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer writeCancel()
+	err = kbfsOps1.Write(writeCtx, fileNode1, []byte{3}, 1)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServer(ctx, rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{3}, 1)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+}
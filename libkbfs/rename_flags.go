@@ -0,0 +1,19 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+// RenameFlags changes the behavior of KBFSOps.RenameWithFlags.
+type RenameFlags uint
+
+const (
+	// RenameNoReplace makes the rename fail with NameExistsError,
+	// instead of replacing the existing entry, if the new name is
+	// already taken, like renameat2's RENAME_NOREPLACE.
+	RenameNoReplace RenameFlags = 1 << iota
+	// RenameExchange swaps the two entries, which must both exist,
+	// like renameat2's RENAME_EXCHANGE.  Neither entry is removed,
+	// and they may be of different types.
+	RenameExchange
+)
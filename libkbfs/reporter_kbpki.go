@@ -30,6 +30,8 @@ const (
 	errorParamLimitBytes          = "limitBytes"
 	errorParamUsageFiles          = "usageFiles"
 	errorParamLimitFiles          = "limitFiles"
+	errorParamAvailableBytes      = "availableBytes"
+	errorParamQuotaThreshold      = "quotaThreshold"
 	errorParamRenameOldFilename   = "oldFilename"
 	errorParamFoldersCreated      = "foldersCreated"
 	errorParamFolderLimit         = "folderLimit"
@@ -154,6 +156,11 @@ func (r *ReporterKBPKI) ReportErr(ctx context.Context,
 	case kbfsmd.NewMetadataVersionError:
 		code = keybase1.FSErrorType_OLD_VERSION
 		err = OutdatedVersionError{}
+	case NewMDFormatVersionError:
+		code = keybase1.FSErrorType_OLD_VERSION
+		err = OutdatedVersionError{}
+	case MDFormatReadOnlyError:
+		code = keybase1.FSErrorType_OLD_VERSION
 	case kbfsmd.NewMerkleVersionError:
 		code = keybase1.FSErrorType_OLD_VERSION
 		err = OutdatedVersionError{}
@@ -164,6 +171,17 @@ func (r *ReporterKBPKI) ReportErr(ctx context.Context,
 		code = keybase1.FSErrorType_OVER_QUOTA
 		params[errorParamUsageBytes] = strconv.FormatInt(e.UsageBytes, 10)
 		params[errorParamLimitBytes] = strconv.FormatInt(e.LimitBytes, 10)
+	case QuotaUsageWarning:
+		code = keybase1.FSErrorType_OVER_QUOTA
+		params[errorParamUsageBytes] = strconv.FormatInt(e.UsageBytes, 10)
+		params[errorParamLimitBytes] = strconv.FormatInt(e.LimitBytes, 10)
+		params[errorParamQuotaThreshold] =
+			strconv.FormatFloat(e.Threshold, 'f', -1, 64)
+	case DiskSpaceLowWarning:
+		code = keybase1.FSErrorType_DISK_LIMIT_REACHED
+		params[errorParamAvailableBytes] =
+			strconv.FormatInt(e.AvailableBytes, 10)
+		params[errorParamLimitBytes] = strconv.FormatInt(e.ThresholdBytes, 10)
 	case *ErrDiskLimitTimeout:
 		if !e.reportable {
 			return
@@ -189,7 +207,8 @@ func (r *ReporterKBPKI) ReportErr(ctx context.Context,
 		}
 	}
 
-	if code < 0 && err == context.DeadlineExceeded {
+	_, isTimeout := errors.Cause(err).(TimeoutError)
+	if code < 0 && (err == context.DeadlineExceeded || isTimeout) {
 		code = keybase1.FSErrorType_TIMEOUT
 		// Workaround for DESKTOP-2442
 		filename = string(tlfName)
@@ -0,0 +1,141 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/logger"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// RequestFrontend names the part of KBFS through which a request
+// entered.
+type RequestFrontend string
+
+const (
+	// RequestFrontendFUSE is for requests made through a FUSE mount.
+	RequestFrontendFUSE RequestFrontend = "fuse"
+	// RequestFrontendDokan is for requests made through a Dokan mount.
+	RequestFrontendDokan RequestFrontend = "dokan"
+	// RequestFrontendRPC is for requests made over RPC by the
+	// keybase service, e.g. through SimpleFS.
+	RequestFrontendRPC RequestFrontend = "rpc"
+	// RequestFrontendCLI is for requests made by a command-line tool
+	// that runs its own copy of KBFS.
+	RequestFrontendCLI RequestFrontend = "cli"
+	// RequestFrontendGit is for requests made by the git remote
+	// helper or by autogit.
+	RequestFrontendGit RequestFrontend = "git"
+	// RequestFrontendHTTP is for requests made through the local
+	// HTTP server.
+	RequestFrontendHTTP RequestFrontend = "http"
+	// RequestFrontendAPI is for requests made by other Go programs
+	// through the kbfsapi package.
+	RequestFrontendAPI RequestFrontend = "api"
+)
+
+// CtxRequestSourceTagKey is the type used for the context tags that
+// say where a request came from.
+type CtxRequestSourceTagKey int
+
+const (
+	// CtxRequestFrontendKey is the type of the tag for the frontend
+	// a request came through.
+	CtxRequestFrontendKey CtxRequestSourceTagKey = iota
+	// CtxRequestPIDKey is the type of the tag for the ID of the
+	// process that made a request.
+	CtxRequestPIDKey
+	// CtxRequestActionKey is the type of the tag for the user action
+	// that led to a request.
+	CtxRequestActionKey
+)
+
+const (
+	// CtxRequestFrontendOpID is the display name for the request
+	// frontend tag, in logs and in RPC headers.
+	CtxRequestFrontendOpID = "FE"
+	// CtxRequestPIDOpID is the display name for the request process
+	// ID tag, in logs and in RPC headers.
+	CtxRequestPIDOpID = "PID"
+	// CtxRequestActionOpID is the display name for the request action
+	// tag, in logs and in RPC headers.
+	CtxRequestActionOpID = "ACT"
+)
+
+// RequestSource says where a request came from.
+type RequestSource struct {
+	Frontend RequestFrontend
+	// PID is the ID of the process that made the request, or 0 if
+	// it isn't known.
+	PID int
+	// Action is a short description of what the user was doing,
+	// like "Lookup" or "git push", or empty if it isn't known.
+	Action string
+}
+
+// CtxWithRequestSource returns a context tagged with the non-empty
+// fields of src, replacing any earlier values for those fields.  The
+// tags show up in the log lines for the request, and, since they are
+// log tags, they are also sent along as headers on any RPCs made to
+// the servers on its behalf.
+func CtxWithRequestSource(
+	ctx context.Context, src RequestSource) context.Context {
+	return NewContextReplayable(ctx, func(ctx context.Context) context.Context {
+		logTags := make(logger.CtxLogTags)
+		if src.Frontend != "" {
+			logTags[CtxRequestFrontendKey] = CtxRequestFrontendOpID
+			ctx = context.WithValue(
+				ctx, CtxRequestFrontendKey, string(src.Frontend))
+		}
+		if src.PID != 0 {
+			logTags[CtxRequestPIDKey] = CtxRequestPIDOpID
+			ctx = context.WithValue(ctx, CtxRequestPIDKey, src.PID)
+		}
+		if src.Action != "" {
+			logTags[CtxRequestActionKey] = CtxRequestActionOpID
+			ctx = context.WithValue(ctx, CtxRequestActionKey, src.Action)
+		}
+		return logger.NewContextWithLogTags(ctx, logTags)
+	})
+}
+
+// RequestSourceFromContext returns the request source that ctx has
+// been tagged with.  Fields that haven't been tagged are left empty.
+func RequestSourceFromContext(ctx context.Context) (src RequestSource) {
+	if frontend, ok := ctx.Value(CtxRequestFrontendKey).(string); ok {
+		src.Frontend = RequestFrontend(frontend)
+	}
+	if pid, ok := ctx.Value(CtxRequestPIDKey).(int); ok {
+		src.PID = pid
+	}
+	if action, ok := ctx.Value(CtxRequestActionKey).(string); ok {
+		src.Action = action
+	}
+	return src
+}
+
+// requestMeterName returns the name of the meter counting the calls
+// of the given class that came through the given frontend.
+func requestMeterName(
+	frontend RequestFrontend, class OpTimeoutClass) string {
+	if frontend == "" {
+		frontend = "unknown"
+	}
+	return fmt.Sprintf("KBFSOps.Requests.%s.%s", frontend, class)
+}
+
+// markRequestSource counts a KBFSOps call of the given class against
+// the frontend ctx was tagged with, if metrics are enabled.
+func markRequestSource(
+	ctx context.Context, config Config, class OpTimeoutClass) {
+	registry := config.MetricsRegistry()
+	if registry == nil {
+		return
+	}
+	name := requestMeterName(RequestSourceFromContext(ctx).Frontend, class)
+	metrics.GetOrRegisterMeter(name, registry).Mark(1)
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCtxWithRequestSource(t *testing.T) {
+	ctx := CtxWithRequestSource(context.Background(), RequestSource{
+		Frontend: RequestFrontendFUSE,
+	})
+	ctx = CtxWithRequestSource(ctx, RequestSource{
+		PID:    1234,
+		Action: "Lookup",
+	})
+	expected := RequestSource{
+		Frontend: RequestFrontendFUSE,
+		PID:      1234,
+		Action:   "Lookup",
+	}
+	require.Equal(t, expected, RequestSourceFromContext(ctx))
+
+	// The tags are log tags, which is also what gets sent along as
+	// RPC headers.
+	logTags, ok := logger.LogTagsFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, CtxRequestFrontendOpID, logTags[CtxRequestFrontendKey])
+	require.Equal(t, CtxRequestPIDOpID, logTags[CtxRequestPIDKey])
+	require.Equal(t, CtxRequestActionOpID, logTags[CtxRequestActionKey])
+
+	// The tags survive being replayed onto a new context.
+	newCtx, err := NewContextWithCancellationDelayer(ctx)
+	require.NoError(t, err)
+	defer CleanupCancellationDelayer(newCtx)
+	require.Equal(t, expected, RequestSourceFromContext(newCtx))
+
+	ctx = CtxWithRequestSource(ctx, RequestSource{Action: "Read"})
+	expected.Action = "Read"
+	require.Equal(t, expected, RequestSourceFromContext(ctx))
+}
+
+func TestKBFSOpsRequestSourceMetrics(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	registry := metrics.NewRegistry()
+	config.SetMetricsRegistry(registry)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fuseCtx := CtxWithRequestSource(ctx, RequestSource{
+		Frontend: RequestFrontendFUSE,
+	})
+	kbfsOps := config.KBFSOps()
+	_, err := kbfsOps.Stat(fuseCtx, rootNode)
+	require.NoError(t, err)
+	_, err = kbfsOps.Stat(fuseCtx, rootNode)
+	require.NoError(t, err)
+	_, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+
+	fuseStats := registry.Get(
+		requestMeterName(RequestFrontendFUSE, OpTimeoutStat))
+	require.NotNil(t, fuseStats)
+	require.Equal(t, int64(2), fuseStats.(metrics.Meter).Count())
+	unknownStats := registry.Get(requestMeterName("", OpTimeoutStat))
+	require.NotNil(t, unknownStats)
+	require.Equal(t, int64(1), unknownStats.(metrics.Meter).Count())
+	require.Nil(t, registry.Get(
+		requestMeterName(RequestFrontendFUSE, OpTimeoutWrite)))
+}
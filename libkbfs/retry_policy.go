@@ -0,0 +1,158 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// RetryBudget limits how much a single server call may be retried.
+type RetryBudget struct {
+	// MaxRetries is the most times the call is retried after it
+	// first fails.  Zero means it isn't retried at all.
+	MaxRetries int
+	// MaxElapsed bounds the total time spent on the call, including
+	// the waits between attempts; no retry is made that would wait
+	// past it.  Zero means there's no bound.
+	MaxElapsed time.Duration
+}
+
+// ExponentialRetryPolicy is a RetryPolicy that retries transient
+// server errors (throttling, generic server-side errors and
+// disconnections) with jittered exponential backoff, within a budget
+// for each call.
+type ExponentialRetryPolicy struct {
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait before any one retry.
+	MaxBackoff time.Duration
+	// Multiplier scales the wait after each failure; values below 1
+	// are treated as 1.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of each wait that is
+	// randomized, so that clients that failed together don't all
+	// retry together.
+	Jitter float64
+	// DefaultBudget applies to every call not listed in Budgets.
+	DefaultBudget RetryBudget
+	// Budgets overrides DefaultBudget for specific calls, keyed by
+	// names like "MDOps.GetForTLF" or "BlockOps.Get".
+	Budgets map[string]RetryBudget
+}
+
+var _ RetryPolicy = (*ExponentialRetryPolicy)(nil)
+
+// DefaultRetryPolicy returns the retry policy KBFS starts out with.
+// MD puts aren't retried, since a put whose reply was lost might
+// have gone through, and retrying it would conflict with itself;
+// those are left to the usual conflict handling.
+func DefaultRetryPolicy() *ExponentialRetryPolicy {
+	return &ExponentialRetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		DefaultBudget: RetryBudget{
+			MaxRetries: 5,
+			MaxElapsed: 30 * time.Second,
+		},
+		Budgets: map[string]RetryBudget{
+			"MDOps.Put": {},
+		},
+	}
+}
+
+// isTransientServerError returns true if err is one that the MD or
+// block server might not return if the same call is made again a
+// little later.
+func isTransientServerError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case kbfsmd.ServerErrorThrottle, kbfsblock.ServerErrorThrottle,
+		kbfsmd.ServerError, kbfsblock.ServerError, errDisconnected:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *ExponentialRetryPolicy) budget(op string) RetryBudget {
+	if b, ok := p.Budgets[op]; ok {
+		return b
+	}
+	return p.DefaultBudget
+}
+
+// NextRetry implements the RetryPolicy interface for
+// ExponentialRetryPolicy.
+func (p *ExponentialRetryPolicy) NextRetry(op string, failures int,
+	elapsed time.Duration, err error) (wait time.Duration, retry bool) {
+	budget := p.budget(op)
+	if !isTransientServerError(err) || failures > budget.MaxRetries {
+		return 0, false
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < failures && backoff < float64(p.MaxBackoff); i++ {
+		backoff *= multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff -= backoff * p.Jitter * rand.Float64()
+	}
+	wait = time.Duration(backoff)
+
+	if budget.MaxElapsed > 0 && elapsed+wait > budget.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+// runWithRetry calls fn, and keeps calling it again for as long as it
+// fails and the given policy says to retry it.  op names the call to
+// the policy, in logs, and in the "<op>.Retries" meter of the given
+// registry, if there is one.  If ctx is done while waiting to retry,
+// its error is returned.
+func runWithRetry(ctx context.Context, policy RetryPolicy,
+	registry metrics.Registry, log logger.Logger, op string,
+	fn func() error) error {
+	start := time.Now()
+	for failures := 1; ; failures++ {
+		err := fn()
+		if err == nil || policy == nil || ctx.Err() != nil {
+			return err
+		}
+		wait, retry := policy.NextRetry(op, failures, time.Since(start), err)
+		if !retry {
+			return err
+		}
+
+		log.CDebugf(ctx, "Retrying %s in %s after failure %d: %+v",
+			op, wait, failures, err)
+		if registry != nil {
+			metrics.GetOrRegisterMeter(op+".Retries", registry).Mark(1)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
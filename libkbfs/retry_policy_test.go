@@ -0,0 +1,123 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestExponentialRetryPolicy(t *testing.T) {
+	p := &ExponentialRetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		DefaultBudget:  RetryBudget{MaxRetries: 5},
+		Budgets: map[string]RetryBudget{
+			"MDOps.Put":    {},
+			"BlockOps.Get": {MaxRetries: 5, MaxElapsed: 2 * time.Second},
+		},
+	}
+	throttle := kbfsmd.ServerErrorThrottle{}
+
+	t.Log("Waits grow exponentially up to the max")
+	for i, expected := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond,
+		400 * time.Millisecond, 800 * time.Millisecond, time.Second,
+	} {
+		wait, retry := p.NextRetry("MDOps.GetForTLF", i+1, 0, throttle)
+		require.True(t, retry)
+		require.Equal(t, expected, wait)
+	}
+
+	t.Log("The budget runs out")
+	_, retry := p.NextRetry("MDOps.GetForTLF", 6, 0, throttle)
+	require.False(t, retry)
+	_, retry = p.NextRetry("MDOps.Put", 1, 0, throttle)
+	require.False(t, retry)
+	_, retry = p.NextRetry("BlockOps.Get", 2, 1900*time.Millisecond,
+		kbfsblock.ServerErrorThrottle{})
+	require.False(t, retry)
+
+	t.Log("Only transient errors are retried")
+	_, retry = p.NextRetry("MDOps.GetForTLF", 1, 0,
+		errors.WithStack(errDisconnected{}))
+	require.True(t, retry)
+	_, retry = p.NextRetry("MDOps.GetForTLF", 1, 0,
+		kbfsmd.ServerErrorUnauthorized{})
+	require.False(t, retry)
+
+	t.Log("Jitter only ever shortens the wait")
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		wait, retry := p.NextRetry("MDOps.GetForTLF", 2, 0, throttle)
+		require.True(t, retry)
+		require.True(t, wait > 100*time.Millisecond)
+		require.True(t, wait <= 200*time.Millisecond)
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewTestLogger(t)
+	registry := metrics.NewRegistry()
+	p := &ExponentialRetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		DefaultBudget:  RetryBudget{MaxRetries: 3},
+	}
+
+	t.Log("Transient failures are retried until the call succeeds")
+	calls := 0
+	err := runWithRetry(ctx, p, registry, log, "MDOps.GetForTLF",
+		func() error {
+			calls++
+			if calls < 3 {
+				return kbfsmd.ServerErrorThrottle{}
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	meter := metrics.GetOrRegisterMeter("MDOps.GetForTLF.Retries", registry)
+	require.Equal(t, int64(2), meter.Count())
+
+	t.Log("The last error is returned once the budget runs out")
+	calls = 0
+	err = runWithRetry(ctx, p, registry, log, "BlockOps.Get",
+		func() error {
+			calls++
+			return kbfsblock.ServerError{Msg: "down"}
+		})
+	require.Equal(t, kbfsblock.ServerError{Msg: "down"}, err)
+	require.Equal(t, 4, calls)
+
+	t.Log("Without a policy, nothing is retried")
+	calls = 0
+	err = runWithRetry(ctx, nil, nil, log, "BlockOps.Get", func() error {
+		calls++
+		return kbfsmd.ServerErrorThrottle{}
+	})
+	require.Equal(t, kbfsmd.ServerErrorThrottle{}, err)
+	require.Equal(t, 1, calls)
+
+	t.Log("Canceling the context stops the waiting")
+	p.InitialBackoff = time.Hour
+	p.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = runWithRetry(ctx, p, nil, log, "BlockOps.Get", func() error {
+		return kbfsmd.ServerErrorThrottle{}
+	})
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
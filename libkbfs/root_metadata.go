@@ -36,6 +36,16 @@ type PrivateMetadata struct {
 	// was performed on this TLF.
 	LastGCRevision kbfsmd.Revision `codec:"lgc"`
 
+	// The oldest MD formats that a client must understand to read,
+	// or to write to, this TLF.  See MDFormatVer.
+	MinReaderFormat MDFormatVer `codec:"mrf,omitempty"`
+	MinWriterFormat MDFormatVer `codec:"mwf,omitempty"`
+	// The format migration in progress, if any.  See TLFMigration.
+	Migration *MigrationState `codec:"mig,omitempty"`
+	// The usages of some of the top-level entries of the TLF, as
+	// measured by GetUsageBreakdown.
+	SubtreeUsages []CachedSubtreeUsage `codec:"su,omitempty"`
+
 	codec.UnknownFieldSetHandler
 
 	// When the above Changes field gets unembedded into its own
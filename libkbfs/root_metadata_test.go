@@ -163,6 +163,10 @@ func makeFakePrivateMetadataFuture(t *testing.T) privateMetadataFuture {
 				0,
 			},
 			0,
+			0,
+			0,
+			nil,
+			nil,
 			codec.UnknownFieldSetHandler{},
 			BlockChanges{},
 		},
@@ -0,0 +1,274 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// selfTestDataSize is how much data the self-test writes and reads
+// back.  It's a bit more than one block, so that the block splitter
+// gets exercised too.
+const selfTestDataSize = 600 * 1024
+
+// startupSelfTestTimeout is how long the self-test requested by the
+// -self-test flag may take in total.
+const startupSelfTestTimeout = 5 * time.Minute
+
+// CtxSelfTestTagKey is the type used for unique context tags within
+// a self-test.
+type CtxSelfTestTagKey int
+
+const (
+	// CtxSelfTestIDKey is the type of the tag for unique operation
+	// IDs within a self-test.
+	CtxSelfTestIDKey CtxSelfTestTagKey = iota
+)
+
+// CtxSelfTestOpID is the display name for the unique operation
+// self-test ID tag.
+const CtxSelfTestOpID = "STID"
+
+// SelfTestStep is the outcome of a single step of a self-test.
+type SelfTestStep struct {
+	Name     string
+	Duration time.Duration
+	// Error is empty if the step succeeded.
+	Error string `json:",omitempty"`
+}
+
+// SelfTestResult is the outcome of a self-test run.
+type SelfTestResult struct {
+	// Folder is the canonical path of the TLF that was used.
+	Folder   string
+	Start    time.Time
+	Duration time.Duration
+	Steps    []SelfTestStep
+	// Passed is true if every step succeeded.
+	Passed bool
+}
+
+// String implements the fmt.Stringer interface for SelfTestResult.
+func (r SelfTestResult) String() string {
+	var b bytes.Buffer
+	status := "PASSED"
+	if !r.Passed {
+		status = "FAILED"
+	}
+	fmt.Fprintf(&b, "Self-test %s in %s (folder %q)\n",
+		status, r.Duration, r.Folder)
+	for _, s := range r.Steps {
+		if s.Error == "" {
+			fmt.Fprintf(&b, "  %-12s ok    %s\n", s.Name, s.Duration)
+		} else {
+			fmt.Fprintf(&b, "  %-12s ERROR %s: %s\n", s.Name, s.Duration,
+				s.Error)
+		}
+	}
+	return b.String()
+}
+
+// ParseSelfTestFolder parses a folder given as "<type>/<name>"
+// (e.g., "private/alice" or "team/acme") into a TLF name and type.
+// An empty string is returned as an empty name, which means the
+// current user's own private folder.
+func ParseSelfTestFolder(folder string) (string, tlf.Type, error) {
+	if folder == "" {
+		return "", tlf.Private, nil
+	}
+	parts := strings.SplitN(strings.Trim(folder, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", tlf.Unknown, errors.Errorf(
+			"Self-test folder %q is not of the form <type>/<name>", folder)
+	}
+	switch PathType(parts[0]) {
+	case PrivatePathType:
+		return parts[1], tlf.Private, nil
+	case PublicPathType:
+		return parts[1], tlf.Public, nil
+	case SingleTeamPathType:
+		return parts[1], tlf.SingleTeam, nil
+	default:
+		return "", tlf.Unknown, errors.Errorf(
+			"Unknown folder type %q in self-test folder %q", parts[0], folder)
+	}
+}
+
+// selfTester runs the steps of a single self-test, and records how
+// each of them went.
+type selfTester struct {
+	config Config
+	log    logger.Logger
+	result SelfTestResult
+}
+
+// step runs f as the named step, and returns whether it succeeded.
+func (st *selfTester) step(
+	ctx context.Context, name string, f func() error) bool {
+	start := st.config.Clock().Now()
+	err := f()
+	s := SelfTestStep{
+		Name:     name,
+		Duration: st.config.Clock().Now().Sub(start),
+	}
+	if err != nil {
+		s.Error = err.Error()
+		st.result.Passed = false
+		st.log.CWarningf(ctx, "Self-test step %s failed after %s: %+v",
+			name, s.Duration, err)
+	} else {
+		st.log.CDebugf(ctx, "Self-test step %s took %s", name, s.Duration)
+	}
+	st.result.Steps = append(st.result.Steps, s)
+	return err == nil
+}
+
+// RunSelfTest exercises the full KBFS stack in the given folder: it
+// makes a scratch directory, writes a file into it, syncs it, reads
+// it back, and then removes everything again.  If tlfName is empty,
+// the current user's own private folder is used.  The scratch
+// directory is removed even if an earlier step fails, if possible.
+// The returned result describes the timing and outcome of each step;
+// a failed self-test is not reported as an error.
+func RunSelfTest(ctx context.Context, config Config, tlfName string,
+	t tlf.Type) SelfTestResult {
+	ctx = CtxWithRandomIDReplayable(
+		ctx, CtxSelfTestIDKey, CtxSelfTestOpID, config.MakeLogger(""))
+	st := &selfTester{
+		config: config,
+		log:    config.MakeLogger(""),
+		result: SelfTestResult{
+			Start:  config.Clock().Now(),
+			Passed: true,
+		},
+	}
+	st.run(ctx, tlfName, t)
+	st.result.Duration = config.Clock().Now().Sub(st.result.Start)
+	st.log.CDebugf(ctx, "Self-test done: %s", st.result)
+	return st.result
+}
+
+func (st *selfTester) run(ctx context.Context, tlfName string, t tlf.Type) {
+	config := st.config
+	kbfsOps := config.KBFSOps()
+
+	var h *TlfHandle
+	if !st.step(ctx, "resolve", func() (err error) {
+		if tlfName == "" {
+			session, err := config.KBPKI().GetCurrentSession(ctx)
+			if err != nil {
+				return err
+			}
+			tlfName = string(session.Name)
+			t = tlf.Private
+		}
+		h, err = GetHandleFromFolderNameAndType(
+			ctx, config.KBPKI(), config.MDOps(), tlfName, t)
+		if err != nil {
+			return err
+		}
+		st.result.Folder = buildCanonicalPathForTlfName(
+			t, h.GetCanonicalName())
+		return nil
+	}) {
+		return
+	}
+
+	var rootNode Node
+	if !st.step(ctx, "root", func() (err error) {
+		rootNode, _, err = kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+		return err
+	}) {
+		return
+	}
+	fb := rootNode.GetFolderBranch()
+
+	var dirName string
+	var dirNode Node
+	if !st.step(ctx, "mkdir", func() error {
+		var suffix [8]byte
+		if err := kbfscrypto.RandRead(suffix[:]); err != nil {
+			return err
+		}
+		dirName = "kbfs-self-test-" + hex.EncodeToString(suffix[:])
+		var err error
+		dirNode, _, err = kbfsOps.CreateDir(ctx, rootNode, dirName)
+		return err
+	}) {
+		return
+	}
+
+	// Clean up the scratch directory no matter how the rest goes.
+	fileName := "data"
+	var fileNode Node
+	defer func() {
+		if fileNode != nil {
+			st.step(ctx, "remove", func() error {
+				return kbfsOps.RemoveEntry(ctx, dirNode, fileName)
+			})
+		}
+		if st.step(ctx, "rmdir", func() error {
+			return kbfsOps.RemoveDir(ctx, rootNode, dirName)
+		}) {
+			st.step(ctx, "final-sync", func() error {
+				return kbfsOps.SyncAll(ctx, fb)
+			})
+		}
+	}()
+
+	data := make([]byte, selfTestDataSize)
+	if !st.step(ctx, "create", func() (err error) {
+		if err := kbfscrypto.RandRead(data); err != nil {
+			return err
+		}
+		fileNode, _, err = kbfsOps.CreateFile(
+			ctx, dirNode, fileName, false, NoExcl)
+		return err
+	}) {
+		return
+	}
+
+	if !st.step(ctx, "write", func() error {
+		return kbfsOps.Write(ctx, fileNode, data, 0)
+	}) {
+		return
+	}
+
+	if !st.step(ctx, "sync", func() error {
+		return kbfsOps.SyncAll(ctx, fb)
+	}) {
+		return
+	}
+
+	if !st.step(ctx, "flush", func() error {
+		return WaitForTLFJournal(ctx, config, fb.Tlf, st.log)
+	}) {
+		return
+	}
+
+	st.step(ctx, "read", func() error {
+		buf := make([]byte, len(data))
+		n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+		if err != nil {
+			return err
+		}
+		if n != int64(len(data)) || !bytes.Equal(buf, data) {
+			return errors.Errorf(
+				"Read back %d bytes that don't match the %d written",
+				n, len(data))
+		}
+		return nil
+	})
+}
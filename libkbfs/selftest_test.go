@@ -0,0 +1,66 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelfTestFolder(t *testing.T) {
+	name, ty, err := ParseSelfTestFolder("")
+	require.NoError(t, err)
+	require.Equal(t, "", name)
+	require.Equal(t, tlf.Private, ty)
+
+	name, ty, err = ParseSelfTestFolder("team/acme")
+	require.NoError(t, err)
+	require.Equal(t, "acme", name)
+	require.Equal(t, tlf.SingleTeam, ty)
+
+	name, ty, err = ParseSelfTestFolder("/public/u1,u2/")
+	require.NoError(t, err)
+	require.Equal(t, "u1,u2", name)
+	require.Equal(t, tlf.Public, ty)
+
+	for _, folder := range []string{"u1", "private/", "shared/u1"} {
+		_, _, err = ParseSelfTestFolder(folder)
+		require.Error(t, err, folder)
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	result := RunSelfTest(ctx, config, "", tlf.Private)
+	require.True(t, result.Passed, "%s", result)
+	require.Equal(t, "/keybase/private/u1", result.Folder)
+	var names []string
+	for _, s := range result.Steps {
+		require.Equal(t, "", s.Error)
+		names = append(names, s.Name)
+	}
+	require.Equal(t, []string{
+		"resolve", "root", "mkdir", "create", "write", "sync", "flush",
+		"read", "remove", "rmdir", "final-sync",
+	}, names)
+
+	// The scratch directory is gone again.
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	children, err := config.KBFSOps().GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	result = RunSelfTest(ctx, config, "u1,nonexistent", tlf.Private)
+	require.False(t, result.Passed)
+	require.Len(t, result.Steps, 1)
+	require.Equal(t, "resolve", result.Steps[0].Name)
+	require.NotEqual(t, "", result.Steps[0].Error)
+}
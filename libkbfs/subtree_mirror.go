@@ -0,0 +1,327 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	stdpath "path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CtxSubtreeMirrorTagKey is the type used for unique context tags
+// within a SubtreeMirror.
+type CtxSubtreeMirrorTagKey int
+
+const (
+	// CtxSubtreeMirrorIDKey is the type of the tag for unique
+	// operation IDs within a SubtreeMirror.
+	CtxSubtreeMirrorIDKey CtxSubtreeMirrorTagKey = iota
+)
+
+// CtxSubtreeMirrorOpID is the display name for the unique operation
+// SubtreeMirror ID tag.
+const CtxSubtreeMirrorOpID = "SMID"
+
+// subtreeMirrorCoalesceWindow is how long a SubtreeMirror waits for
+// more changes to the source before publishing them.
+const subtreeMirrorCoalesceWindow = time.Second
+
+// syncEntry makes dstName in dstParent a copy of srcName in
+// srcParent, only copying the parts of the tree that differ.  Files
+// are assumed to be unchanged if their size and mtime match.
+func (c *crossTlfCopier) syncEntry(ctx context.Context, srcParent Node,
+	srcName string, dstParent Node, dstName string, relPath string) error {
+	srcOps := c.fs.getOpsByNode(ctx, srcParent)
+	dstOps := c.fs.getOpsByNode(ctx, dstParent)
+	srcNode, srcEI, err := srcOps.Lookup(ctx, srcParent, srcName)
+	if err != nil {
+		return err
+	}
+	dstNode, dstEI, err := dstOps.Lookup(ctx, dstParent, dstName)
+	switch errors.Cause(err).(type) {
+	case nil:
+	case NoSuchNameError:
+		_, err = c.copyEntry(ctx, srcParent, srcName, dstParent, dstName,
+			relPath)
+		return err
+	default:
+		return err
+	}
+
+	switch {
+	case srcEI.Type == Dir && dstEI.Type == Dir:
+		return c.syncDir(ctx, srcNode, dstNode, relPath)
+	case srcEI.Type == dstEI.Type && srcEI.Type == Sym &&
+		srcEI.SymPath == dstEI.SymPath:
+		return nil
+	case srcEI.Type == dstEI.Type && srcEI.Type != Sym &&
+		srcEI.Size == dstEI.Size && srcEI.Mtime == dstEI.Mtime:
+		return nil
+	}
+	err = dstOps.RemoveAll(ctx, dstParent, dstName)
+	if err != nil {
+		return err
+	}
+	_, err = c.copyEntry(ctx, srcParent, srcName, dstParent, dstName, relPath)
+	return err
+}
+
+// syncDir makes the contents of dstDir a copy of the contents of
+// srcDir.
+func (c *crossTlfCopier) syncDir(
+	ctx context.Context, srcDir Node, dstDir Node, relPath string) error {
+	srcOps := c.fs.getOpsByNode(ctx, srcDir)
+	dstOps := c.fs.getOpsByNode(ctx, dstDir)
+	srcChildren, err := srcOps.GetDirChildren(ctx, srcDir)
+	if err != nil {
+		return err
+	}
+	// Excluded entries are removed from the copy if they're there
+	// from before they were excluded.
+	srcChildren = c.exclude.filterChildren(
+		stdpath.Join(c.excludeBase, relPath), srcChildren)
+	dstChildren, err := dstOps.GetDirChildren(ctx, dstDir)
+	if err != nil {
+		return err
+	}
+
+	for name := range dstChildren {
+		if _, ok := srcChildren[name]; ok {
+			continue
+		}
+		err := dstOps.RemoveAll(ctx, dstDir, name)
+		if err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(srcChildren))
+	for name := range srcChildren {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := c.syncEntry(
+			ctx, srcDir, name, dstDir, name, stdpath.Join(relPath, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlfRelativePath returns the slash-separated path of node relative
+// to the root of its TLF.
+func (fs *KBFSOpsStandard) tlfRelativePath(ctx context.Context, node Node) string {
+	p := fs.getOpsByNode(ctx, node).nodeCache.PathFromNode(node)
+	names := make([]string, 0, len(p.path))
+	for _, pn := range p.path[1:] {
+		names = append(names, pn.Name)
+	}
+	return stdpath.Join(names...)
+}
+
+// PublishSubtree makes dstName in dstParent a copy of srcName in
+// srcParent, and syncs it.  The two parents must be in different
+// TLFs, for example to publish part of a private folder into a team
+// or public folder.  If dstName already exists, only the parts that
+// differ from the source are copied again, and anything that isn't
+// in the source is removed.  Entries under srcName matching the
+// exclude patterns of the source TLF are left out.  Like a rename across TLFs, the
+// data is re-encrypted with the keys of the destination TLF.
+func (fs *KBFSOpsStandard) PublishSubtree(ctx context.Context,
+	srcParent Node, srcName string, dstParent Node, dstName string) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	// Copying the data can take much longer than a regular write.
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	dstFB := dstParent.GetFolderBranch()
+	if srcParent.GetFolderBranch() == dstFB {
+		return errors.New("Can't publish a subtree into its own folder")
+	}
+
+	fs.log.CDebugf(ctx, "Publishing %s as %s",
+		fs.config.RedactLogPath(srcName), fs.config.RedactLogPath(dstName))
+	c := &crossTlfCopier{
+		fs:          fs,
+		exclude:     fs.config.TlfExcludePatterns(srcParent.GetFolderBranch().Tlf),
+		excludeBase: fs.tlfRelativePath(ctx, srcParent),
+	}
+	err = c.syncEntry(ctx, srcParent, srcName, dstParent, dstName, srcName)
+	if err != nil {
+		return err
+	}
+	return fs.getOpsByNode(ctx, dstParent).SyncAll(ctx, dstFB)
+}
+
+// SubtreeMirror keeps a subtree published with
+// KBFSOpsStandard.MirrorSubtree up to date, by publishing it again
+// whenever the source changes.
+type SubtreeMirror struct {
+	fs        *KBFSOpsStandard
+	srcParent Node
+	srcName   string
+	dstParent Node
+	dstName   string
+
+	changedCh  chan struct{}
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+	stopOnce   sync.Once
+
+	// publishLock protects publishes, the number of publishes
+	// attempted in the background, and publishedCh, which is
+	// closed and replaced after each one.
+	publishLock sync.Mutex
+	publishes   int
+	publishedCh chan struct{}
+}
+
+var _ Observer = (*SubtreeMirror)(nil)
+
+// MirrorSubtree publishes srcName in srcParent as dstName in
+// dstParent, like PublishSubtree, and then keeps publishing it in
+// the background shortly after each change to it, until the returned
+// SubtreeMirror is stopped.  Errors in the background are logged,
+// and the next change tries again.
+func (fs *KBFSOpsStandard) MirrorSubtree(ctx context.Context,
+	srcParent Node, srcName string, dstParent Node, dstName string) (
+	*SubtreeMirror, error) {
+	err := fs.PublishSubtree(ctx, srcParent, srcName, dstParent, dstName)
+	if err != nil {
+		return nil, err
+	}
+	srcNode, _, err := fs.Lookup(ctx, srcParent, srcName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SubtreeMirror{
+		fs:         fs,
+		srcParent:  srcParent,
+		srcName:    srcName,
+		dstParent:  dstParent,
+		dstName:    dstName,
+		changedCh:   make(chan struct{}, 1),
+		shutdownCh:  make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		publishedCh: make(chan struct{}),
+	}
+	err = fs.RegisterForChangesWithFilter(
+		[]FolderBranch{srcParent.GetFolderBranch()}, m, ObserverFilter{
+			Root:           srcNode,
+			Recursive:      true,
+			CoalesceWindow: subtreeMirrorCoalesceWindow,
+		})
+	if err != nil {
+		return nil, err
+	}
+	go m.loop()
+	return m, nil
+}
+
+func (m *SubtreeMirror) loop() {
+	defer close(m.doneCh)
+	ctx, cancel := context.WithCancel(
+		CtxWithRandomIDReplayable(context.Background(),
+			CtxSubtreeMirrorIDKey, CtxSubtreeMirrorOpID, m.fs.log))
+	defer cancel()
+	// Writes need a context that can delay their cancellation.
+	ctx, err := NewContextWithCancellationDelayer(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer CleanupCancellationDelayer(ctx)
+	go func() {
+		select {
+		case <-m.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-m.changedCh:
+			err := m.fs.PublishSubtree(
+				ctx, m.srcParent, m.srcName, m.dstParent, m.dstName)
+			if err != nil {
+				m.fs.log.CWarningf(ctx, "Couldn't update the mirror of %s: %+v",
+					m.fs.config.RedactLogPath(m.srcName), err)
+			}
+			m.publishLock.Lock()
+			m.publishes++
+			close(m.publishedCh)
+			m.publishedCh = make(chan struct{})
+			m.publishLock.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// waitForPublishesForTest waits until the mirror has tried to publish
+// the subtree again at least `publishes` times since it started.
+func (m *SubtreeMirror) waitForPublishesForTest(
+	ctx context.Context, publishes int) error {
+	for {
+		m.publishLock.Lock()
+		done, publishedCh := m.publishes >= publishes, m.publishedCh
+		m.publishLock.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-publishedCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stop stops mirroring, and waits for any publish in progress to
+// end.
+func (m *SubtreeMirror) Stop(ctx context.Context) error {
+	var err error
+	m.stopOnce.Do(func() {
+		err = m.fs.UnregisterFromChanges(
+			[]FolderBranch{m.srcParent.GetFolderBranch()}, m)
+		close(m.shutdownCh)
+	})
+	select {
+	case <-m.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// LocalChange implements the Observer interface for SubtreeMirror.
+// Only synced changes are mirrored.
+func (m *SubtreeMirror) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+// BatchChanges implements the Observer interface for SubtreeMirror.
+func (m *SubtreeMirror) BatchChanges(
+	_ context.Context, _ []NodeChange, _ []NodeID) {
+	select {
+	case m.changedCh <- struct{}{}:
+	default:
+		// A publish is already pending, and will pick this up.
+	}
+}
+
+// TlfHandleChange implements the Observer interface for
+// SubtreeMirror.
+func (m *SubtreeMirror) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
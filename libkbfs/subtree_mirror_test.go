@@ -0,0 +1,143 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsPublishSubtree(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	privRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	t.Log("Make a/{f,g,d/h} in the private TLF")
+	aNode, _, err := kbfsOps.CreateDir(ctx, privRoot, "a")
+	require.NoError(t, err)
+	fNode, _, err := kbfsOps.CreateFile(ctx, aNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	dNode, _, err := kbfsOps.CreateDir(ctx, aNode, "d")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "h", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+
+	checkFile := func(parent Node, name string, expected []byte) {
+		node, _, err := kbfsOps.Lookup(ctx, parent, name)
+		require.NoError(t, err)
+		buf := make([]byte, len(expected)+1)
+		n, err := kbfsOps.Read(ctx, node, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf[:n])
+	}
+
+	t.Log("Publish a as b in the public TLF")
+	err = kbfsOps.PublishSubtree(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.Lookup(ctx, pubRoot, "b")
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, bNode)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	checkFile(bNode, "f", []byte("hello"))
+
+	t.Log("Publishing again brings over changes and removals")
+	err = kbfsOps.Write(ctx, fNode, []byte("howdy, world"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, aNode, "g")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, dNode, "h")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps.PublishSubtree(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, bNode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	checkFile(bNode, "f", []byte("howdy, world"))
+	bdNode, _, err := kbfsOps.Lookup(ctx, bNode, "d")
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, bdNode)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	t.Log("A subtree can't be published into its own folder")
+	err = kbfsOps.PublishSubtree(ctx, privRoot, "a", privRoot, "b")
+	require.Error(t, err)
+
+	t.Log("A mirror picks up later changes")
+	m, err := kbfsOps.MirrorSubtree(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "i", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	err = m.waitForPublishesForTest(ctx, 1)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, bdNode, "i")
+	require.NoError(t, err)
+	err = m.Stop(ctx)
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsPublishSubtreeExclude(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	privRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Public)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+
+	t.Log("Make a/{f,node_modules/g} in the private TLF")
+	aNode, _, err := kbfsOps.CreateDir(ctx, privRoot, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	nmNode, _, err := kbfsOps.CreateDir(ctx, aNode, "node_modules")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, nmNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Publish everything, then exclude node_modules")
+	err = kbfsOps.PublishSubtree(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.Lookup(ctx, pubRoot, "b")
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, bNode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	err = config.SetTlfExcludePatterns(
+		privRoot.GetFolderBranch().Tlf, []string{"node_modules/"})
+	require.NoError(t, err)
+
+	t.Log("Publishing again removes the excluded directory")
+	err = kbfsOps.PublishSubtree(ctx, privRoot, "a", pubRoot, "b")
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, bNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "f")
+
+	status, _, err := kbfsOps.FolderStatus(ctx, privRoot.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, []string{"node_modules/"}, status.ExcludePatterns)
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// SetFolderSyncState sets whether the given TLF is synced to disk for
+// offline access.  Every block of a synced folder's latest revision
+// is fetched into the sync cache, where it is kept, and the folder
+// stays synced as its head advances.  The progress of the sync shows
+// up in the folder's FolderBranchStatus.  If the folder isn't running
+// yet, its sync starts once its head is first set.
+func (fs *KBFSOpsStandard) SetFolderSyncState(
+	ctx context.Context, tlfID tlf.ID, synced bool) (err error) {
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	err = fs.config.SetTlfSyncState(tlfID, synced)
+	if err != nil {
+		return err
+	}
+	if !synced {
+		return nil
+	}
+	ops, ok := fs.ops.get(FolderBranch{tlfID, MasterBranch})
+	if !ok {
+		return nil
+	}
+	ops.kickOffSync(ctx)
+	return nil
+}
+
+// kickOffSync starts fetching the blocks of the current head that
+// aren't in the caches yet, if there is a head.
+func (fbo *folderBranchOps) kickOffSync(ctx context.Context) {
+	lState := makeFBOLockState()
+	head, _ := fbo.getHead(lState)
+	if head == (ImmutableRootMetadata{}) {
+		return
+	}
+	fbo.kickOffRootBlockFetch(ctx, head)
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "golang.org/x/net/context"
+
+// tempFileNamePrefix starts the names that unlinked temp files are
+// given in the node cache.  They never appear in any directory.
+const tempFileNamePrefix = ".kbfs_tmpfile."
+
+// createTempFileLocked makes a new, empty file of type `entryType`
+// that isn't linked into any directory.  Its node starts out
+// unlinked, just like the node of an open file that was removed from
+// its directory, so that writes and attribute changes on it are kept
+// in the dirty caches.  Unlike for removed files, though, they aren't
+// thrown away by syncs, but kept until the file is linked by
+// linkTempFileLocked.
+func (fbo *folderBranchOps) createTempFileLocked(
+	ctx context.Context, lState *lockState, dir Node,
+	entryType EntryType) (node Node, de DirEntry, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if err := fbo.checkForUnlinkedDir(dir); err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	// Verify we have permission to write.
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	de, err = fbo.makeNewEntry(ctx, md, entryType)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	name := tempFileNamePrefix + de.ID.String()
+	node, err = fbo.nodeCache.GetOrCreate(de.BlockPointer, name, dir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	err = fbo.putNewEntryBlock(de)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	defer func() {
+		if err != nil {
+			// Delete should never fail.
+			_ = fbo.config.DirtyBlockCache().Delete(
+				fbo.id(), de.BlockPointer, fbo.branch())
+		}
+	}()
+
+	tempPath := dirPath.ChildPath(name, de.BlockPointer)
+	relinkFn := fbo.nodeCache.Unlink(de.Ref(), tempPath, de)
+	fbo.blocks.AddTempFile(lState, de.Ref(), relinkFn)
+
+	// Dirty the file with a zero-byte write, like createEntryLocked
+	// does, so that its new block gets synced once it's linked.
+	_, err = fbo.blocks.Write(ctx, lState, md.ReadOnly(), node, []byte{}, 0, 0)
+	if err != nil {
+		fbo.blocks.RemoveTempFile(lState, de.Ref())
+		_ = fbo.blocks.ClearCacheInfo(lState, tempPath)
+		return nil, DirEntry{}, err
+	}
+	return node, de, nil
+}
+
+// CreateTempFile implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) CreateTempFile(
+	ctx context.Context, dir Node, isExec bool) (
+	n Node, ei EntryInfo, err error) {
+	fbo.log.CDebugf(ctx, "CreateTempFile %s isExec=%v",
+		getNodeIDStr(dir), isExec)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "CreateTempFile %s isExec=%v done: %v %+v",
+			getNodeIDStr(dir), isExec, getNodeIDStr(n), err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, dir)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	entryType := File
+	if isExec {
+		entryType = Exec
+	}
+
+	var retNode Node
+	var retEntryInfo EntryInfo
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			// Don't set node and ei directly, as that can cause a
+			// race when the call is canceled.
+			node, de, err := fbo.createTempFileLocked(
+				ctx, lState, dir, entryType)
+			retNode = node
+			retEntryInfo = de.EntryInfo
+			return err
+		})
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	return retNode, retEntryInfo, nil
+}
+
+// linkTempFileLocked links `file`, which must be an unlinked temp
+// file made by createTempFileLocked, into `dir` as `name`, with a
+// create op.  From then on, the file is synced like any other new
+// file, and its contents are always synced in the same batch as its
+// new entry, so other clients never see the file before it's
+// complete.
+func (fbo *folderBranchOps) linkTempFileLocked(
+	ctx context.Context, lState *lockState, dir Node, name string,
+	file Node) (de DirEntry, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if err := checkDisallowedPrefixes(ctx, name); err != nil {
+		return DirEntry{}, err
+	}
+
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return DirEntry{}, NameTooLongError{name, fbo.config.MaxNameBytes()}
+	}
+
+	if err := fbo.checkForUnlinkedDir(dir); err != nil {
+		return DirEntry{}, err
+	}
+
+	filename, err := fbo.canonicalPath(ctx, dir, name)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	// Verify we have permission to write (but don't make a successor yet).
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, filename)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	dblock, err := fbo.blocks.GetDirtyDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockRead)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	if _, ok := dblock.Children[name]; ok {
+		return DirEntry{}, NameExistsError{name}
+	}
+
+	if err := fbo.checkNewDirSize(
+		ctx, lState, md.ReadOnly(), dirPath, name); err != nil {
+		return DirEntry{}, err
+	}
+
+	tempPath := fbo.nodeCache.PathFromNode(file)
+	ref := tempPath.tailRef()
+	relinkFn, ok := fbo.blocks.RemoveTempFile(lState, ref)
+	if !ok {
+		return DirEntry{}, NotTempFileError{file.GetID()}
+	}
+	cleanupFn := func() {
+		fbo.blocks.AddTempFile(lState, ref, relinkFn)
+	}
+	defer func() {
+		if err != nil {
+			cleanupFn()
+		}
+	}()
+
+	// The entry includes all the changes made to the file so far.
+	de, err = fbo.blocks.GetDirtyEntryEvenIfDeleted(
+		ctx, lState, md.ReadOnly(), tempPath)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	parentPtr := dirPath.tailPointer()
+	co, err := newCreateOp(name, parentPtr, de.Type)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	co.setFinalPath(dirPath)
+	co.AddRefBlock(de.BlockPointer)
+	co.AddSelfUpdate(parentPtr)
+
+	// Every change below is undone by `cleanupFn` if this fails,
+	// so that the file is still an unlinked temp file if
+	// doMDWriteWithRetry tries again.
+	relinkFn()
+	cleanupFn = func() {
+		relinkFn = fbo.nodeCache.Unlink(ref, tempPath, de)
+		fbo.blocks.AddTempFile(lState, ref, relinkFn)
+	}
+	moveUndoFn, err := fbo.nodeCache.Move(ref, dir, name)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	unlinkFn := cleanupFn
+	cleanupFn = func() {
+		if moveUndoFn != nil {
+			moveUndoFn()
+		}
+		unlinkFn()
+	}
+
+	dirCacheUndoFn, err := fbo.blocks.LinkTempFileInCache(
+		lState, dirPath, name, de)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	fbo.dirOps = append(fbo.dirOps, cachedDirOp{co, []Node{dir, file}})
+	added := fbo.status.addDirtyNode(dir)
+	unmoveFn := cleanupFn
+	cleanupFn = func() {
+		if added {
+			fbo.status.rmDirtyNode(dir)
+		}
+		fbo.dirOps = fbo.dirOps[:len(fbo.dirOps)-1]
+		if dirCacheUndoFn != nil {
+			dirCacheUndoFn(lState)
+		}
+		unmoveFn()
+	}
+
+	err = fbo.notifyOneOp(ctx, lState, co, md.ReadOnly(), false)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	return de, nil
+}
+
+// LinkTempFile implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) LinkTempFile(
+	ctx context.Context, dir Node, name string, file Node) (
+	ei EntryInfo, err error) {
+	logName := fbo.config.RedactLogPath(name)
+	fbo.log.CDebugf(ctx, "LinkTempFile %s %s %s",
+		getNodeIDStr(dir), logName, getNodeIDStr(file))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "LinkTempFile %s %s %s done: %+v",
+			getNodeIDStr(dir), logName, getNodeIDStr(file), err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, dir)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	err = fbo.checkNode(file)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	var retEntryInfo EntryInfo
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			de, err := fbo.linkTempFileLocked(ctx, lState, dir, name, file)
+			retEntryInfo = de.EntryInfo
+			return err
+		})
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	// Publish the new entry, and the file's contents, right away.
+	err = fbo.SyncAll(ctx, fbo.folderBranch)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	return retEntryInfo, nil
+}
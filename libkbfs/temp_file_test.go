@@ -0,0 +1,96 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempFile(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := getOps(config, fb.Tlf)
+	_, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Write a temp file; it isn't in any directory, and isn't synced")
+	tempNode, ei, err := kbfsOps.CreateTempFile(ctx, rootNode, true)
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	data := []byte("hello")
+	err = kbfsOps.Write(ctx, tempNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, tempNode, "user.a", []byte("b"))
+	require.NoError(t, err)
+	rev := ops.getCurrMDRevision(makeFBOLockState())
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, rev, ops.getCurrMDRevision(makeFBOLockState()))
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, tempNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+
+	t.Log("Linking over an existing name fails, and leaves the file as is")
+	_, err = kbfsOps.LinkTempFile(ctx, rootNode, "a", tempNode)
+	require.Equal(t, NameExistsError{"a"}, errors.Cause(err))
+	ei, err = kbfsOps.Stat(ctx, tempNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), ei.Size)
+
+	t.Log("Linking over an unsynced entry fails too")
+	_, _, err = kbfsOps.CreateFile(ctx, dNode, "e", false, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.LinkTempFile(ctx, dNode, "e", tempNode)
+	require.Equal(t, NameExistsError{"e"}, errors.Cause(err))
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	rev = ops.getCurrMDRevision(makeFBOLockState())
+
+	t.Log("Link it into a different directory, in a single MD update")
+	ei, err = kbfsOps.LinkTempFile(ctx, dNode, "b", tempNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), ei.Size)
+	require.Equal(t, rev+1, ops.getCurrMDRevision(makeFBOLockState()))
+	_, err = kbfsOps.LinkTempFile(ctx, dNode, "c", tempNode)
+	require.Equal(t, NotTempFileError{tempNode.GetID()}, errors.Cause(err))
+	bNode, _, err := kbfsOps.Lookup(ctx, dNode, "b")
+	require.NoError(t, err)
+	require.Equal(t, tempNode.GetID(), bNode.GetID())
+
+	t.Log("Another device sees the whole file")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	dNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "d")
+	require.NoError(t, err)
+	bNode2, ei, err := kbfsOps2.Lookup(ctx, dNode2, "b")
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	n, err = kbfsOps2.Read(ctx, bNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+	value, err := kbfsOps2.GetXattr(ctx, bNode2, "user.a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), value)
+}
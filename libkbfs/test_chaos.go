@@ -0,0 +1,277 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// errChaosKilled is returned by every server call made through a
+// config that has been killed by a ChaosKiller.
+var errChaosKilled = errors.New("Killed by chaos testing")
+
+// ChaosKiller simulates an abrupt process death at a random point
+// during the server operations of a single test config.  Each
+// mutating server call has a chance of "killing" the config, either
+// just before the call reaches the server or just after the server
+// has applied it (but before the caller learns about the result).
+// Once killed, every subsequent server call from that config fails
+// with errChaosKilled, as if the process had gone away.
+//
+// The servers themselves are shared with the other configs created
+// via ConfigAsUser, and are left running when a killed config is
+// shut down, so that a fresh config can be started against the same
+// server-side state to verify that nothing was lost or corrupted.
+type ChaosKiller struct {
+	killProb float64
+
+	lock     sync.Mutex
+	rng      *rand.Rand
+	killed   bool
+	killedCh chan struct{}
+}
+
+// InstallChaosKillerForTesting wraps the servers of the given config
+// so that each mutating server call kills the config with probability
+// killProb.  seed makes the kill points reproducible.  The given
+// config must be a test config made with MakeTestConfigOrBust or
+// ConfigAsUser, and it must not be the config that is responsible
+// for shutting down the shared servers.
+func InstallChaosKillerForTesting(
+	config Config, seed int64, killProb float64) *ChaosKiller {
+	ck := &ChaosKiller{
+		killProb: killProb,
+		rng:      rand.New(rand.NewSource(seed)),
+		killedCh: make(chan struct{}),
+	}
+	config.SetBlockServer(&chaosBlockServer{config.BlockServer(), ck})
+	config.SetMDServer(&chaosMDServer{config.MDServer(), ck})
+	config.SetKeyServer(&chaosKeyServer{config.KeyServer(), ck})
+	return ck
+}
+
+// Kill kills the config immediately, if it hasn't been killed already.
+func (ck *ChaosKiller) Kill() {
+	ck.lock.Lock()
+	defer ck.lock.Unlock()
+	ck.killLocked()
+}
+
+func (ck *ChaosKiller) killLocked() {
+	if ck.killed {
+		return
+	}
+	ck.killed = true
+	close(ck.killedCh)
+}
+
+// IsKilled returns whether the config has been killed.
+func (ck *ChaosKiller) IsKilled() bool {
+	ck.lock.Lock()
+	defer ck.lock.Unlock()
+	return ck.killed
+}
+
+// Killed returns a channel that is closed once the config has been
+// killed.
+func (ck *ChaosKiller) Killed() <-chan struct{} {
+	return ck.killedCh
+}
+
+// checkAlive returns errChaosKilled if the config has already been
+// killed.
+func (ck *ChaosKiller) checkAlive() error {
+	ck.lock.Lock()
+	defer ck.lock.Unlock()
+	if ck.killed {
+		return errChaosKilled
+	}
+	return nil
+}
+
+// maybeKill randomly decides whether the config dies at this point,
+// and returns errChaosKilled if the config is now dead.
+func (ck *ChaosKiller) maybeKill() error {
+	ck.lock.Lock()
+	defer ck.lock.Unlock()
+	if !ck.killed && ck.rng.Float64() < ck.killProb {
+		ck.killLocked()
+	}
+	if ck.killed {
+		return errChaosKilled
+	}
+	return nil
+}
+
+// mutate runs the given server mutation, possibly killing the config
+// before or after it reaches the server.
+func (ck *ChaosKiller) mutate(action func() error) error {
+	if err := ck.maybeKill(); err != nil {
+		return err
+	}
+	err := action()
+	if killErr := ck.maybeKill(); killErr != nil {
+		// The server may have applied the mutation, but the
+		// dead process never finds out.
+		return killErr
+	}
+	return err
+}
+
+// chaosBlockServer is a BlockServer whose calls are subject to a
+// ChaosKiller.
+type chaosBlockServer struct {
+	BlockServer
+	ck *ChaosKiller
+}
+
+var _ BlockServer = (*chaosBlockServer)(nil)
+
+func (b *chaosBlockServer) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, bctx kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	if err := b.ck.checkAlive(); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return b.BlockServer.Get(ctx, tlfID, id, bctx)
+}
+
+func (b *chaosBlockServer) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, bctx kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	return b.ck.mutate(func() error {
+		return b.BlockServer.Put(ctx, tlfID, id, bctx, buf, serverHalf)
+	})
+}
+
+func (b *chaosBlockServer) PutAgain(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, bctx kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	return b.ck.mutate(func() error {
+		return b.BlockServer.PutAgain(
+			ctx, tlfID, id, bctx, buf, serverHalf)
+	})
+}
+
+func (b *chaosBlockServer) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, bctx kbfsblock.Context) error {
+	return b.ck.mutate(func() error {
+		return b.BlockServer.AddBlockReference(ctx, tlfID, id, bctx)
+	})
+}
+
+func (b *chaosBlockServer) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	err = b.ck.mutate(func() error {
+		var errRemove error
+		liveCounts, errRemove = b.BlockServer.RemoveBlockReferences(
+			ctx, tlfID, contexts)
+		return errRemove
+	})
+	return liveCounts, err
+}
+
+func (b *chaosBlockServer) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	return b.ck.mutate(func() error {
+		return b.BlockServer.ArchiveBlockReferences(ctx, tlfID, contexts)
+	})
+}
+
+// Shutdown implements the BlockServer interface for
+// chaosBlockServer.  The underlying server is shared with other
+// configs, and outlives the killed process.
+func (b *chaosBlockServer) Shutdown(ctx context.Context) {}
+
+// chaosMDServer is an MDServer whose calls are subject to a
+// ChaosKiller.  Since it isn't an mdServerLocal, a config using it
+// won't check the server state on shutdown.
+type chaosMDServer struct {
+	MDServer
+	ck *ChaosKiller
+}
+
+var _ MDServer = (*chaosMDServer)(nil)
+
+func (md *chaosMDServer) GetForHandle(ctx context.Context,
+	handle tlf.Handle, mStatus kbfsmd.MergeStatus,
+	lockBeforeGet *keybase1.LockID) (
+	tlf.ID, *RootMetadataSigned, error) {
+	if err := md.ck.checkAlive(); err != nil {
+		return tlf.ID{}, nil, err
+	}
+	return md.MDServer.GetForHandle(ctx, handle, mStatus, lockBeforeGet)
+}
+
+func (md *chaosMDServer) GetForTLF(ctx context.Context, id tlf.ID,
+	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus,
+	lockBeforeGet *keybase1.LockID) (*RootMetadataSigned, error) {
+	if err := md.ck.checkAlive(); err != nil {
+		return nil, err
+	}
+	return md.MDServer.GetForTLF(ctx, id, bid, mStatus, lockBeforeGet)
+}
+
+func (md *chaosMDServer) GetRange(ctx context.Context, id tlf.ID,
+	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus,
+	start, stop kbfsmd.Revision, lockBeforeGet *keybase1.LockID) (
+	[]*RootMetadataSigned, error) {
+	if err := md.ck.checkAlive(); err != nil {
+		return nil, err
+	}
+	return md.MDServer.GetRange(
+		ctx, id, bid, mStatus, start, stop, lockBeforeGet)
+}
+
+func (md *chaosMDServer) Put(ctx context.Context, rmds *RootMetadataSigned,
+	extra kbfsmd.ExtraMetadata, lockContext *keybase1.LockContext,
+	priority keybase1.MDPriority) error {
+	return md.ck.mutate(func() error {
+		return md.MDServer.Put(ctx, rmds, extra, lockContext, priority)
+	})
+}
+
+func (md *chaosMDServer) PruneBranch(
+	ctx context.Context, id tlf.ID, bid kbfsmd.BranchID) error {
+	return md.ck.mutate(func() error {
+		return md.MDServer.PruneBranch(ctx, id, bid)
+	})
+}
+
+// Shutdown implements the MDServer interface for chaosMDServer.  The
+// underlying server is shared with other configs, and outlives the
+// killed process.
+func (md *chaosMDServer) Shutdown() {}
+
+// chaosKeyServer is a KeyServer whose calls are subject to a
+// ChaosKiller.
+type chaosKeyServer struct {
+	KeyServer
+	ck *ChaosKiller
+}
+
+var _ KeyServer = (*chaosKeyServer)(nil)
+
+func (ks *chaosKeyServer) PutTLFCryptKeyServerHalves(ctx context.Context,
+	keyServerHalves kbfsmd.UserDeviceKeyServerHalves) error {
+	return ks.ck.mutate(func() error {
+		return ks.KeyServer.PutTLFCryptKeyServerHalves(ctx, keyServerHalves)
+	})
+}
+
+// Shutdown implements the KeyServer interface for chaosKeyServer.
+// The underlying server is shared with other configs, and outlives
+// the killed process.
+func (ks *chaosKeyServer) Shutdown() {}
@@ -63,7 +63,13 @@ func MakeTestBlockServerOrBust(t logger.TestLogBackend,
 	config blockServerRemoteConfig,
 	rpcLogFactory rpc.LogFactory) BlockServer {
 	// see if a local remote server is specified
-	bserverAddr := os.Getenv(EnvTestBServerAddr)
+	return makeTestBlockServerOrBust(
+		t, config, rpcLogFactory, os.Getenv(EnvTestBServerAddr))
+}
+
+func makeTestBlockServerOrBust(t logger.TestLogBackend,
+	config blockServerRemoteConfig, rpcLogFactory rpc.LogFactory,
+	bserverAddr string) BlockServer {
 	switch {
 	case bserverAddr == TempdirServerAddr:
 		var err error
@@ -78,7 +84,8 @@ func MakeTestBlockServerOrBust(t logger.TestLogBackend,
 		if err != nil {
 			t.Fatal(err)
 		}
-		return NewBlockServerRemote(config, remote, rpcLogFactory)
+		return NewBlockServerRemote(
+			config, remote, rpcLogFactory, BServerDefaultGetConnections)
 
 	default:
 		return NewBlockServerMemory(config.MakeLogger(""))
@@ -118,6 +125,18 @@ func newTestRPCLogFactory(t testLogger) rpc.LogFactory {
 func MakeTestConfigOrBustLoggedInWithMode(
 	t logger.TestLogBackend, loggedInIndex int,
 	mode InitModeType, users ...libkb.NormalizedUsername) *ConfigLocal {
+	// see if local remote servers are specified
+	return makeTestConfigOrBust(t, loggedInIndex, mode,
+		os.Getenv(EnvTestBServerAddr), os.Getenv(EnvTestMDServerAddr),
+		users...)
+}
+
+// makeTestConfigOrBust creates a test config that uses the servers at
+// the given addresses, or in-memory servers if they are empty.
+func makeTestConfigOrBust(
+	t logger.TestLogBackend, loggedInIndex int, mode InitModeType,
+	bserverAddr, mdServerAddr string,
+	users ...libkb.NormalizedUsername) *ConfigLocal {
 	log := logger.NewTestLogger(t)
 	config := newConfigForTest(mode, func(m string) logger.Logger {
 		return log
@@ -146,12 +165,10 @@ func MakeTestConfigOrBustLoggedInWithMode(
 	crypto := NewCryptoLocal(config.Codec(), signingKey, cryptPrivateKey)
 	config.SetCrypto(crypto)
 
-	blockServer := MakeTestBlockServerOrBust(
-		t, config, newTestRPCLogFactory(t))
+	blockServer := makeTestBlockServerOrBust(
+		t, config, newTestRPCLogFactory(t), bserverAddr)
 	config.SetBlockServer(blockServer)
 
-	// see if a local remote server is specified
-	mdServerAddr := os.Getenv(EnvTestMDServerAddr)
 	var mdServer MDServer
 	var keyServer KeyServer
 	switch {
@@ -227,6 +244,57 @@ func MakeTestConfigOrBust(t logger.TestLogBackend,
 	return MakeTestConfigOrBustLoggedIn(t, 0, users...)
 }
 
+// NewTestConfigInMemory returns one config for each of the given
+// users, logged in as that user.  All of the configs share the same
+// in-memory block, MD and key servers and the same test clock, even
+// if test servers are given in the environment, and each user's keys
+// are derived from their name, so runs are repeatable.  Use
+// AddDeviceConfigForTestOrBust to get more devices for a user.  The
+// returned function shuts down every config sharing those servers.
+func NewTestConfigInMemory(t logger.TestLogBackend,
+	users ...libkb.NormalizedUsername) (
+	configs []*ConfigLocal, shutdown func()) {
+	if len(users) == 0 {
+		t.Fatal("NewTestConfigInMemory needs at least one user")
+	}
+	config := makeTestConfigOrBust(t, 0, InitDefault, "", "", users...)
+	configs = append(configs, config)
+	for _, u := range users[1:] {
+		configs = append(configs, ConfigAsUser(config, u))
+	}
+	return configs, func() {
+		ctx := context.Background()
+		for _, c := range *config.allKnownConfigsForTesting {
+			CheckConfigAndShutdown(ctx, t, c)
+		}
+	}
+}
+
+// AddDeviceConfigForTestOrBust adds a new device for the user logged
+// in to config, and returns a config logged in as that user on the
+// new device.  The device is added to every config sharing servers
+// with config, so that they all key folders for it.
+func AddDeviceConfigForTestOrBust(
+	t logger.TestLogBackend, config *ConfigLocal) *ConfigLocal {
+	session, err := config.KBPKI().GetCurrentSession(context.Background())
+	if err != nil {
+		t.Fatalf("Couldn't get UID: %+v", err)
+	}
+
+	index := -1
+	for _, c := range *config.allKnownConfigsForTesting {
+		i := AddDeviceForLocalUserOrBust(t, c, session.UID)
+		if index >= 0 && i != index {
+			t.Fatalf("Device index %d doesn't match %d", i, index)
+		}
+		index = i
+	}
+
+	c := ConfigAsUser(config, session.Name)
+	SwitchDeviceForLocalUserOrBust(t, c, index)
+	return c
+}
+
 // ConfigAsUserWithMode clones a test configuration in the given mode,
 // setting another user as the logged in user.  Journaling will not be
 // enabled in the returned Config, regardless of the journal status in
@@ -277,7 +345,8 @@ func ConfigAsUserWithMode(config *ConfigLocal,
 		if err != nil {
 			panic(err)
 		}
-		blockServer := NewBlockServerRemote(c, remote, s.putConn.rpcLogFactory)
+		blockServer := NewBlockServerRemote(
+			c, remote, s.putConn.rpcLogFactory, s.numGetConns())
 		c.SetBlockServer(blockServer)
 	} else {
 		c.SetBlockServer(config.BlockServer())
@@ -0,0 +1,53 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTestConfigInMemory(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	configs, shutdown := NewTestConfigInMemory(t, u1, u2)
+	defer shutdown()
+	require.Len(t, configs, 2)
+	ctx := BackgroundContextWithCancellationDelayer()
+
+	for i, u := range []libkb.NormalizedUsername{u1, u2} {
+		session, err := configs[i].KBPKI().GetCurrentSession(ctx)
+		require.NoError(t, err)
+		require.Equal(t, u, session.Name)
+		require.IsType(t, &BlockServerMemory{}, configs[i].BlockServer())
+	}
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, configs[0], name, tlf.Private)
+	kbfsOps1 := configs[0].KBFSOps()
+	_, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("The other user sees the new file")
+	rootNode2 := GetRootNodeOrBust(ctx, t, configs[1], name, tlf.Private)
+	_, _, err = configs[1].KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	t.Log("A new device for u1 can read the folder once it's rekeyed")
+	device := AddDeviceConfigForTestOrBust(t, configs[0])
+	session, err := device.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	require.Equal(t, u1, session.Name)
+	_, err = RequestRekeyAndWaitForOneFinishEvent(
+		ctx, kbfsOps1, rootNode1.GetFolderBranch().Tlf)
+	require.NoError(t, err)
+	rootNode3 := GetRootNodeOrBust(ctx, t, device, name, tlf.Private)
+	_, _, err = device.KBFSOps().Lookup(ctx, rootNode3, "a")
+	require.NoError(t, err)
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CloneTlfContents copies the current contents of the given
+// folder-branch into the TLF for dstHandle, which must not exist
+// yet, and syncs it, for example to start a new project from a
+// template folder.  Every block is encrypted with the keys of its
+// own TLF and referenced by TLF on the block server, so none of the
+// source blocks can be reused: the data of every file is re-encrypted
+// and uploaded again.  If the copy fails, the new TLF is left with
+// whatever was copied so far.
+func (fs *KBFSOpsStandard) CloneTlfContents(ctx context.Context,
+	srcFolderBranch FolderBranch, dstHandle *TlfHandle) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	// Copying the data can take much longer than a regular write.
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	fs.log.CDebugf(ctx, "Cloning %s into %s", srcFolderBranch,
+		dstHandle.GetCanonicalPath())
+	dstRoot, _, err := fs.getMaybeCreateRootNode(
+		ctx, dstHandle, MasterBranch, false)
+	if err != nil {
+		return err
+	}
+	if dstRoot != nil {
+		return errors.Errorf("%s already exists",
+			dstHandle.GetCanonicalPath())
+	}
+	dstRoot, _, err = fs.getMaybeCreateRootNode(
+		ctx, dstHandle, MasterBranch, true)
+	if err != nil {
+		return err
+	}
+
+	srcOps := fs.getOps(ctx, srcFolderBranch, FavoritesOpNoChange)
+	srcRoot, _, _, err := srcOps.getRootNode(ctx)
+	if err != nil {
+		return err
+	}
+	children, err := srcOps.GetDirChildren(ctx, srcRoot)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := &crossTlfCopier{fs: fs}
+	for _, name := range names {
+		_, err = c.copyEntry(ctx, srcRoot, name, dstRoot, name, name)
+		if err != nil {
+			return err
+		}
+	}
+	return fs.getOpsByNode(ctx, dstRoot).SyncAll(
+		ctx, dstRoot.GetFolderBranch())
+}
@@ -47,6 +47,7 @@ type tlfJournalConfig interface {
 	diskLimitTimeout() time.Duration
 	teamMembershipChecker() kbfsmd.TeamMembershipChecker
 	BGFlushDirOpBatchSize() int
+	NetworkConstraints() NetworkConstraints
 }
 
 // tlfJournalConfigWrapper is an adapter for Config objects to the
@@ -157,6 +158,7 @@ type tlfJournalPauseType int
 const (
 	journalPauseConflict tlfJournalPauseType = 1 << iota
 	journalPauseCommand
+	journalPauseNetworkMode
 )
 
 func (bws TLFJournalBackgroundWorkStatus) String() string {
@@ -599,7 +601,9 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 			case <-j.needPauseCh:
 				j.log.CDebugf(ctx,
 					"Got pause signal for %s", j.tlfID)
-				bws = TLFJournalBackgroundWorkPaused
+				if j.isPaused() {
+					bws = TLFJournalBackgroundWorkPaused
+				}
 
 			case <-j.needShutdownCh:
 				j.log.CDebugf(ctx,
@@ -639,6 +643,10 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 			case <-j.needPauseCh:
 				j.log.CDebugf(ctx,
 					"Got pause signal for %s", j.tlfID)
+				if !j.isPaused() {
+					// Keep waiting for the worker.
+					continue
+				}
 				bws = TLFJournalBackgroundWorkPaused
 
 			case <-j.needShutdownCh:
@@ -676,7 +684,9 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 			case <-j.needResumeCh:
 				j.log.CDebugf(ctx,
 					"Got resume signal for %s", j.tlfID)
-				bws = TLFJournalBackgroundWorkEnabled
+				if !j.isPaused() {
+					bws = TLFJournalBackgroundWorkEnabled
+				}
 
 			case <-j.needShutdownCh:
 				j.log.CDebugf(ctx,
@@ -709,9 +719,10 @@ func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
 // We don't guarantee that background pause/resume requests will be
 // processed in strict FIFO order. In particular, multiple pause
 // requests are collapsed into one (also multiple resume requests), so
-// it's possible that a pause-resume-pause sequence will be processed
-// as pause-resume. But that's okay, since these are just for
-// infrequent ad-hoc testing.
+// a signal may be stale by the time it's processed. The background
+// loop compensates by checking isPaused() whenever it gets a signal,
+// so it always ends up in the state implied by the most recent
+// request.
 
 func (j *tlfJournal) pause(pauseType tlfJournalPauseType) {
 	j.pauseLock.Lock()
@@ -731,6 +742,16 @@ func (j *tlfJournal) pause(pauseType tlfJournalPauseType) {
 	}
 }
 
+// isPaused returns whether anyone still wants the background work
+// paused.  The background work loop checks this upon receiving a
+// pause or resume signal, since a signal may be stale by the time it
+// is processed.
+func (j *tlfJournal) isPaused() bool {
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+	return j.pauseType != 0
+}
+
 func (j *tlfJournal) pauseBackgroundWork() {
 	j.pause(journalPauseCommand)
 }
@@ -1030,21 +1051,22 @@ func (j *tlfJournal) getNextBlockEntriesToFlush(
 }
 
 func (j *tlfJournal) removeFlushedBlockEntries(ctx context.Context,
-	entries blockEntriesToFlush, timeToFlush time.Duration) error {
+	entries blockEntriesToFlush, timeToFlush time.Duration) (
+	flushedBytes int64, err error) {
 	j.journalLock.Lock()
 	defer j.journalLock.Unlock()
 	if err := j.checkEnabledLocked(); err != nil {
-		return err
+		return 0, err
 	}
 
 	storedBytesBefore := j.blockJournal.getStoredBytes()
 
 	// TODO: Check storedFiles also.
 
-	flushedBytes, err := j.blockJournal.removeFlushedEntries(
+	flushedBytes, err = j.blockJournal.removeFlushedEntries(
 		ctx, entries, j.tlfID, j.config.Reporter())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	storedBytesAfter := j.blockJournal.getStoredBytes()
 
@@ -1063,7 +1085,32 @@ func (j *tlfJournal) removeFlushedBlockEntries(ctx context.Context,
 		"is %f bytes/sec", flushedBytes, timeToFlush,
 		j.bytesPerSecEstimate.Value())
 
-	return nil
+	return flushedBytes, nil
+}
+
+// throttleFlush waits long enough after a batch of block flushes to
+// keep the average upload rate under the configured
+// NetworkConstraints.MaxBytesPerSec, if any.  It returns early if ctx
+// is canceled, leaving the caller to notice the cancellation.
+func (j *tlfJournal) throttleFlush(ctx context.Context,
+	flushedBytes int64, timeToFlush time.Duration) {
+	maxRate := j.config.NetworkConstraints().MaxBytesPerSec
+	if maxRate <= 0 || flushedBytes <= 0 {
+		return
+	}
+	minTime := time.Duration(flushedBytes * int64(time.Second) / maxRate)
+	wait := minTime - timeToFlush
+	if wait <= 0 {
+		return
+	}
+	j.log.CDebugf(ctx, "Waiting %s before flushing more blocks, to "+
+		"respect the max upload rate of %d bytes/sec", wait, maxRate)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
 func (j *tlfJournal) flushBlockEntries(
@@ -1160,10 +1207,12 @@ func (j *tlfJournal) flushBlockEntries(
 		return 0, kbfsmd.RevisionUninitialized, false, err
 	}
 
-	err = j.removeFlushedBlockEntries(ctx, entries, endFlush.Sub(startFlush))
+	flushedBytes, err := j.removeFlushedBlockEntries(
+		ctx, entries, endFlush.Sub(startFlush))
 	if err != nil {
 		return 0, kbfsmd.RevisionUninitialized, false, err
 	}
+	j.throttleFlush(ctx, flushedBytes, endFlush.Sub(startFlush))
 
 	// If a conversion happened, the original `maxMDRevToFlush` only
 	// applies for sure if its mdRevMarker entry was already for a
@@ -1962,6 +2011,21 @@ func (e *ErrDiskLimitTimeout) Error() string {
 func (j *tlfJournal) putBlockData(
 	ctx context.Context, id kbfsblock.ID, blockCtx kbfsblock.Context, buf []byte,
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
+	return j.doPutBlockData(ctx, true, id, blockCtx, buf, serverHalf)
+}
+
+// putBlockDataAgain is like putBlockData, but for blocks with a
+// non-zero refnonce; see blockJournal.putDataAgain.
+func (j *tlfJournal) putBlockDataAgain(
+	ctx context.Context, id kbfsblock.ID, blockCtx kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
+	return j.doPutBlockData(ctx, false, id, blockCtx, buf, serverHalf)
+}
+
+func (j *tlfJournal) doPutBlockData(
+	ctx context.Context, isRegularPut bool, id kbfsblock.ID,
+	blockCtx kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
 	// Since beforeBlockPut can block, it should happen outside of
 	// the journal lock.
 
@@ -2006,8 +2070,13 @@ func (j *tlfJournal) putBlockData(
 
 	storedBytesBefore := j.blockJournal.getStoredBytes()
 
-	putData, err = j.blockJournal.putData(
-		ctx, id, blockCtx, buf, serverHalf)
+	if isRegularPut {
+		putData, err = j.blockJournal.putData(
+			ctx, id, blockCtx, buf, serverHalf)
+	} else {
+		putData, err = j.blockJournal.putDataAgain(
+			ctx, id, blockCtx, buf, serverHalf)
+	}
 	if err != nil {
 		return err
 	}
@@ -89,6 +89,7 @@ type testTLFJournalConfig struct {
 	nug          normalizedUsernameGetter
 	mdserver     MDServer
 	dlTimeout    time.Duration
+	testNetworkConstraintsGetter
 }
 
 func (c testTLFJournalConfig) BlockSplitter() BlockSplitter {
@@ -240,6 +241,7 @@ func setupTLFJournalTest(
 		nil, nil, NewMDCacheStandard(10), ver,
 		NewReporterSimple(newTestClockNow(), 10), uid, verifyingKey, ekg, nil,
 		mdserver, defaultDiskLimitMaxDelay + time.Second,
+		testNetworkConstraintsGetter{},
 	}
 
 	ctx, cancel = context.WithTimeout(
@@ -0,0 +1,318 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// migrationTakeoverTime is how long a migration can go without its
+// owner recording any progress before another device may take it
+// over.
+const migrationTakeoverTime = 10 * time.Minute
+
+// MigrationState is the record, kept in the private metadata of a
+// TLF, of a format migration in progress.  It's how devices
+// coordinate, so that only one of them migrates a TLF at a time,
+// and so that another one can pick up where it left off.
+type MigrationState struct {
+	// Name identifies the TLFMigration being run.
+	Name string `codec:"n"`
+	// ToFormat is the format the TLF will have once the migration
+	// is done.
+	ToFormat MDFormatVer `codec:"f"`
+	// Owner is the verifying key of the device running the
+	// migration.
+	Owner kbfscrypto.VerifyingKey `codec:"o"`
+	// Updated is when the owner last recorded progress, in
+	// nanoseconds since the epoch.
+	Updated int64 `codec:"u"`
+	// Progress is how far the migration got, as of Updated.
+	Progress MigrationProgress `codec:"p"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// MigrationProgress describes how far along a migration is.
+type MigrationProgress struct {
+	// Cursor is where the next step of the migration should start.
+	// Its meaning is up to the migration; it's empty before the
+	// first step.
+	Cursor string `codec:"c"`
+	// Done and Total measure the progress of the migration, in
+	// units of the migration's choosing.  Total may be zero if it's
+	// not known yet.
+	Done  uint64 `codec:"d"`
+	Total uint64 `codec:"t"`
+	// Finished is true once the migration has nothing left to do.
+	Finished bool `codec:"x,omitempty"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// TLFMigration upgrades the data of a TLF to a new MD format, in
+// steps that are each recorded in the TLF's metadata, so that the
+// migration can be resumed after a crash, or by another device.
+//
+// A step may be run more than once, if the device running it crashes
+// or loses ownership of the migration before recording its progress,
+// so steps must be idempotent.  Other devices that understand the
+// new format may keep writing to the TLF during the migration, so
+// steps must also cope with data that changes between them.  Devices
+// that don't understand the new format can only read the TLF once
+// the migration starts.
+type TLFMigration interface {
+	// Name uniquely identifies this migration.
+	Name() string
+	// FormatVer is the format the TLF has once this migration is
+	// done.  Older clients can't write to the TLF from the start of
+	// the migration on.
+	FormatVer() MDFormatVer
+	// ReaderFormatVer is the format clients need in order to read
+	// the TLF once this migration is done.  It may not be greater
+	// than FormatVer.
+	ReaderFormatVer() MDFormatVer
+	// Step does the next part of the migration, starting at
+	// `progress.Cursor`, and returns the new progress.  It should
+	// return regularly, so that progress gets recorded.
+	Step(ctx context.Context, config Config, root Node,
+		progress MigrationProgress) (MigrationProgress, error)
+}
+
+// MigrationStatus is passed to the progress callback of MigrateTLF
+// each time a step of a migration is recorded.
+type MigrationStatus struct {
+	Folder   FolderBranch
+	Name     string
+	Progress MigrationProgress
+}
+
+// updateMigrationState writes a new MD revision in which `update`
+// has changed the migration state or format markers of `md`.  Like
+// a rekey, this doesn't touch any data, so a rekeyOp, which every
+// client already knows to ignore, is used to carry it.
+func (fbo *folderBranchOps) updateMigrationState(ctx context.Context,
+	update func(md *RootMetadata, session SessionInfo) error) error {
+	lState := makeFBOLockState()
+	fbo.mdWriterLock.Lock(lState)
+	defer fbo.mdWriterLock.Unlock(lState)
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+
+	if md.MergedStatus() == kbfsmd.Unmerged {
+		return UnexpectedUnmergedPutError{}
+	}
+
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = update(md, session)
+	if err != nil {
+		return err
+	}
+	md.AddOp(newRekeyOp())
+	return fbo.finalizeMDOnlyWriteLocked(ctx, lState, md)
+}
+
+var errMigrationDone = errors.New("Migration is already done")
+
+// claimMigration records `m` as in progress in the TLF, owned by
+// this device, unless it's already done.  If another device owns
+// it, and has recorded progress recently enough, it returns
+// MigrationInProgressError.  Otherwise it returns the progress to
+// resume from.
+func (fbo *folderBranchOps) claimMigration(
+	ctx context.Context, m TLFMigration) (
+	progress MigrationProgress, alreadyDone bool, err error) {
+	err = fbo.updateMigrationState(ctx,
+		func(md *RootMetadata, session SessionInfo) error {
+			now := fbo.config.Clock().Now()
+			state := md.data.Migration
+			switch {
+			case state == nil:
+				if md.data.MinWriterFormat >= m.FormatVer() {
+					alreadyDone = true
+					// Don't write anything.
+					return errMigrationDone
+				}
+				state = &MigrationState{
+					Name:     m.Name(),
+					ToFormat: m.FormatVer(),
+				}
+			case state.Name != m.Name():
+				return MigrationInProgressError{
+					fbo.id(), state.Name, state.Owner}
+			case state.Owner != session.VerifyingKey &&
+				now.Sub(time.Unix(0, state.Updated)) <
+					migrationTakeoverTime:
+				return MigrationInProgressError{
+					fbo.id(), state.Name, state.Owner}
+			default:
+				// Take over the migration, or resume it after a
+				// crash.
+				fbo.log.CDebugf(ctx, "Resuming migration %s from %+v, "+
+					"previously owned by %s", state.Name, state.Progress,
+					state.Owner)
+			}
+
+			md.data.Migration = &MigrationState{
+				Name:     state.Name,
+				ToFormat: state.ToFormat,
+				Owner:    session.VerifyingKey,
+				Updated:  now.UnixNano(),
+				Progress: state.Progress,
+			}
+			// Keep older clients from writing while the data is
+			// in flux.
+			md.requireMDFormat(FirstMDFormatVer, m.FormatVer())
+			progress = state.Progress
+			return nil
+		})
+	if alreadyDone {
+		return MigrationProgress{}, true, nil
+	}
+	if err != nil {
+		return MigrationProgress{}, false, err
+	}
+	return progress, false, nil
+}
+
+// recordMigrationProgress records `progress` for `m`, as long as
+// this device still owns it.  Once the migration is finished, the
+// migration state is cleared and the final format markers are set,
+// in the same MD update.
+func (fbo *folderBranchOps) recordMigrationProgress(ctx context.Context,
+	m TLFMigration, progress MigrationProgress) error {
+	return fbo.updateMigrationState(ctx,
+		func(md *RootMetadata, session SessionInfo) error {
+			state := md.data.Migration
+			if state == nil || state.Name != m.Name() ||
+				state.Owner != session.VerifyingKey {
+				var name string
+				var owner kbfscrypto.VerifyingKey
+				if state != nil {
+					name, owner = state.Name, state.Owner
+				}
+				return MigrationInProgressError{fbo.id(), name, owner}
+			}
+
+			if progress.Finished {
+				md.data.Migration = nil
+				md.requireMDFormat(m.ReaderFormatVer(), m.FormatVer())
+				return nil
+			}
+
+			md.data.Migration = &MigrationState{
+				Name:     state.Name,
+				ToFormat: state.ToFormat,
+				Owner:    state.Owner,
+				Updated:  fbo.config.Clock().Now().UnixNano(),
+				Progress: progress,
+			}
+			return nil
+		})
+}
+
+// migrate runs `m` on this TLF until it's done.  See
+// KBFSOpsStandard.MigrateTLF.
+func (fbo *folderBranchOps) migrate(ctx context.Context, m TLFMigration,
+	progressFn func(MigrationStatus)) (err error) {
+	fbo.log.CDebugf(ctx, "Migrate %s to format %d", m.Name(), m.FormatVer())
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "Migrate %s done: %+v", m.Name(), err)
+	}()
+
+	if m.FormatVer() > CurrentMDFormatVer ||
+		m.ReaderFormatVer() > m.FormatVer() {
+		return errors.Errorf("Migration %s has invalid formats %d/%d",
+			m.Name(), m.ReaderFormatVer(), m.FormatVer())
+	}
+
+	// Start from the newest state, so a migration that another
+	// device finished or is working on is noticed right away.
+	err = fbo.SyncFromServer(ctx, fbo.folderBranch, nil)
+	if err != nil {
+		return err
+	}
+
+	progress, alreadyDone, err := fbo.claimMigration(ctx, m)
+	if err != nil {
+		return err
+	}
+	if alreadyDone {
+		fbo.log.CDebugf(ctx, "Migration %s is already done", m.Name())
+		return nil
+	}
+
+	root, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	for !progress.Finished {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		progress, err = m.Step(ctx, fbo.config, root, progress)
+		if err != nil {
+			return err
+		}
+		// Make sure the step's own changes are on the server before
+		// they're counted as done.
+		err = fbo.SyncAll(ctx, fbo.folderBranch)
+		if err != nil {
+			return err
+		}
+		err = fbo.recordMigrationProgress(ctx, m, progress)
+		if err != nil {
+			return err
+		}
+		if progressFn != nil {
+			progressFn(MigrationStatus{fbo.folderBranch, m.Name(), progress})
+		}
+	}
+	return nil
+}
+
+// MigrateTLF runs the given migration on the given folder until it's
+// done, calling `progressFn` (if it's not nil) after each step.  If
+// the folder has already been migrated, it returns nil right away.
+// If the migration was interrupted earlier, on this device or on
+// one that stopped making progress a while ago, it picks up where
+// the migration left off.  If another device is actively running
+// a migration on the folder, it returns MigrationInProgressError,
+// and the caller may try again later.
+func (fs *KBFSOpsStandard) MigrateTLF(ctx context.Context,
+	fb FolderBranch, m TLFMigration,
+	progressFn func(MigrationStatus)) (err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	ctx, done, err := fs.startOp(ctx, OpTimeoutSync)
+	if err != nil {
+		return err
+	}
+	defer func() { err = done(err) }()
+
+	if fb.Branch != MasterBranch {
+		return errors.Errorf("Can't migrate branch %s of %s",
+			fb.Branch, fb.Tlf)
+	}
+	ops := fs.getOps(ctx, fb, FavoritesOpNoChange)
+	return ops.migrate(ctx, m, progressFn)
+}
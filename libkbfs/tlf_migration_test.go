@@ -0,0 +1,136 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testTLFMigration creates one file per step, skipping files that
+// already exist, and fails at step `failAt` (if it's not zero).
+type testTLFMigration struct {
+	steps  uint64
+	failAt uint64
+	ran    []string
+}
+
+func (m *testTLFMigration) Name() string {
+	return "test"
+}
+
+func (m *testTLFMigration) FormatVer() MDFormatVer {
+	return CurrentMDFormatVer
+}
+
+func (m *testTLFMigration) ReaderFormatVer() MDFormatVer {
+	return FirstMDFormatVer
+}
+
+func (m *testTLFMigration) Step(ctx context.Context, config Config,
+	root Node, progress MigrationProgress) (MigrationProgress, error) {
+	if progress.Done+1 == m.failAt {
+		return progress, errors.New("Step failed")
+	}
+	name := fmt.Sprintf("m%d", progress.Done)
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.Lookup(ctx, root, name)
+	switch errors.Cause(err).(type) {
+	case nil:
+	case NoSuchNameError:
+		_, _, err = kbfsOps.CreateFile(ctx, root, name, false, NoExcl)
+		if err != nil {
+			return progress, err
+		}
+	default:
+		return progress, err
+	}
+	m.ran = append(m.ran, name)
+	return MigrationProgress{
+		Cursor:   name,
+		Done:     progress.Done + 1,
+		Total:    m.steps,
+		Finished: progress.Done+1 == m.steps,
+	}, nil
+}
+
+func TestMigrateTLF(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config1, uid, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	clock := newTestClockNow()
+	config1.SetClock(clock)
+
+	// Use a public folder, so a new device doesn't need a rekey.
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, u1.String(), tlf.Public)
+	fb := rootNode1.GetFolderBranch()
+	kbfsOps1 := config1.KBFSOps().(*KBFSOpsStandard)
+	ops1 := getOps(config1, fb.Tlf)
+
+	var statuses []MigrationStatus
+	progressFn := func(status MigrationStatus) {
+		statuses = append(statuses, status)
+	}
+
+	t.Log("The first device fails partway through a migration")
+	m1 := &testTLFMigration{steps: 3, failAt: 2}
+	err := kbfsOps1.MigrateTLF(ctx, fb, m1, progressFn)
+	require.Error(t, err)
+	require.Equal(t, []string{"m0"}, m1.ran)
+	require.Len(t, statuses, 1)
+	require.Equal(t, uint64(1), statuses[0].Progress.Done)
+	head := ops1.getCurrMDRevision(makeFBOLockState())
+	md, err := config1.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	require.Equal(t, head, md.Revision())
+	require.NotNil(t, md.data.Migration)
+	require.Equal(t, "m0", md.data.Migration.Progress.Cursor)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinWriterFormat)
+	require.Equal(t, FirstMDFormatVer, md.data.MinReaderFormat)
+
+	t.Log("A second device can't run it while it's recently owned")
+	config2 := ConfigAsUser(config1, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	AddDeviceForLocalUserOrBust(t, config1, uid)
+	devIndex := AddDeviceForLocalUserOrBust(t, config2, uid)
+	SwitchDeviceForLocalUserOrBust(t, config2, devIndex)
+	kbfsOps2 := config2.KBFSOps().(*KBFSOpsStandard)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Public)
+	m2 := &testTLFMigration{steps: 3}
+	err = kbfsOps2.MigrateTLF(ctx, fb, m2, progressFn)
+	_, ok := errors.Cause(err).(MigrationInProgressError)
+	require.True(t, ok, "Unexpected error: %+v", err)
+
+	t.Log("Once the owner goes quiet, the second device takes over")
+	clock.Add(migrationTakeoverTime + time.Second)
+	err = kbfsOps2.MigrateTLF(ctx, fb, m2, progressFn)
+	require.NoError(t, err)
+	require.Equal(t, []string{"m1", "m2"}, m2.ran)
+	require.Len(t, statuses, 3)
+	require.True(t, statuses[2].Progress.Finished)
+	children, err := kbfsOps2.GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+
+	t.Log("The migration is recorded as done for everyone")
+	err = kbfsOps1.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	md, err = config1.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	require.Nil(t, md.data.Migration)
+	require.Equal(t, CurrentMDFormatVer, md.data.MinWriterFormat)
+	m1.failAt = 0
+	err = kbfsOps1.MigrateTLF(ctx, fb, m1, progressFn)
+	require.NoError(t, err)
+	require.Equal(t, []string{"m0"}, m1.ran)
+	require.Len(t, statuses, 3)
+}
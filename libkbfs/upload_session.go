@@ -0,0 +1,158 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"sync"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+	"golang.org/x/net/context"
+)
+
+// uploadSessionReadSize is how much data is read back at a time when
+// an upload session checks the hash of the assembled file.
+const uploadSessionReadSize = 512 * 1024
+
+// UploadSession writes a new file in chunks, for integrations that
+// need to make sure a large transfer arrived intact.  Each chunk is
+// checked against its SHA-256 hash before it's written, and, on
+// commit, the whole file is read back and checked against the
+// expected SHA-256 hash before it's renamed to its final name and
+// synced.  Until then, the data lives in a hidden file next to the
+// final one, so a corrupted upload never shows up under the final
+// name.  An UploadSession is safe to use from multiple goroutines,
+// so chunks may be written in parallel.
+type UploadSession struct {
+	kbfsOps KBFSOps
+	dir     Node
+	name    string
+	tmpName string
+	node    Node
+
+	lock sync.RWMutex
+	done bool
+}
+
+// BeginUpload starts an upload session for a file that will be named
+// `name` in `dir` once the session is committed.
+func BeginUpload(ctx context.Context, kbfsOps KBFSOps, dir Node,
+	name string, isExec bool) (*UploadSession, error) {
+	var suffix [8]byte
+	if err := kbfscrypto.RandRead(suffix[:]); err != nil {
+		return nil, err
+	}
+	tmpName := "." + name + ".upload-" + hex.EncodeToString(suffix[:])
+	// The random suffix already keeps other writers away from the
+	// hidden file, so it isn't worth syncing the create right away,
+	// as WithExcl would.
+	node, _, err := kbfsOps.CreateFile(ctx, dir, tmpName, isExec, NoExcl)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadSession{
+		kbfsOps: kbfsOps,
+		dir:     dir,
+		name:    name,
+		tmpName: tmpName,
+		node:    node,
+	}, nil
+}
+
+// WriteChunk writes `data` at offset `off` of the file being
+// uploaded, if its SHA-256 hash matches `sha256Sum`.  Otherwise it
+// writes nothing and returns UploadChunkHashMismatchError.
+func (us *UploadSession) WriteChunk(ctx context.Context, data []byte,
+	off int64, sha256Sum []byte) error {
+	us.lock.RLock()
+	defer us.lock.RUnlock()
+	if us.done {
+		return UploadSessionDoneError{us.name}
+	}
+	sum := sha256.Sum256(data)
+	if subtle.ConstantTimeCompare(sum[:], sha256Sum) != 1 {
+		return UploadChunkHashMismatchError{us.name, off}
+	}
+	return us.kbfsOps.Write(ctx, us.node, data, off)
+}
+
+// hashLocked returns the SHA-256 hash of the file as it's been
+// written so far.
+func (us *UploadSession) hashLocked(ctx context.Context) ([]byte, error) {
+	ei, err := us.kbfsOps.Stat(ctx, us.node)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	buf := make([]byte, uploadSessionReadSize)
+	for off := int64(0); off < int64(ei.Size); {
+		n, err := us.kbfsOps.Read(ctx, us.node, buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		h.Write(buf[:n])
+		off += n
+	}
+	return h.Sum(nil), nil
+}
+
+// Commit checks that the SHA-256 hash of the assembled file matches
+// `sha256Sum`, and if it does, renames the file to its final name,
+// replacing any file already there, and syncs it.  If it doesn't,
+// the upload is aborted and UploadHashMismatchError is returned.
+// Either way, the session can't be used afterward.
+func (us *UploadSession) Commit(
+	ctx context.Context, sha256Sum []byte) (err error) {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	if us.done {
+		return UploadSessionDoneError{us.name}
+	}
+
+	sum, err := us.hashLocked(ctx)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(sum, sha256Sum) != 1 {
+		if err := us.abortLocked(ctx); err != nil {
+			return err
+		}
+		return UploadHashMismatchError{us.name}
+	}
+
+	err = us.kbfsOps.Rename(ctx, us.dir, us.tmpName, us.dir, us.name)
+	if err != nil {
+		return err
+	}
+	us.done = true
+	return us.kbfsOps.SyncAll(ctx, us.dir.GetFolderBranch())
+}
+
+func (us *UploadSession) abortLocked(ctx context.Context) error {
+	err := us.kbfsOps.RemoveEntry(ctx, us.dir, us.tmpName)
+	if err != nil {
+		return err
+	}
+	us.done = true
+	// Sync the removal, which also drops the dirty data of the
+	// unsynced chunks.
+	return us.kbfsOps.SyncAll(ctx, us.dir.GetFolderBranch())
+}
+
+// Abort throws away everything written in this session.  The
+// session can't be used afterward.
+func (us *UploadSession) Abort(ctx context.Context) error {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	if us.done {
+		return UploadSessionDoneError{us.name}
+	}
+	return us.abortLocked(ctx)
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadSession(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	kbfsOps := config.KBFSOps()
+
+	t.Log("Upload two chunks out of order")
+	us, err := BeginUpload(ctx, kbfsOps, rootNode, "a", false)
+	require.NoError(t, err)
+	chunk1, chunk2 := []byte("hello "), []byte("world")
+	sum2 := sha256.Sum256(chunk2)
+	err = us.WriteChunk(ctx, chunk2, int64(len(chunk1)), sum2[:])
+	require.NoError(t, err)
+
+	t.Log("A chunk with the wrong hash isn't written")
+	err = us.WriteChunk(ctx, []byte("HELLO "), 0, sum2[:])
+	require.Equal(t, UploadChunkHashMismatchError{"a", 0},
+		errors.Cause(err))
+	sum1 := sha256.Sum256(chunk1)
+	err = us.WriteChunk(ctx, chunk1, 0, sum1[:])
+	require.NoError(t, err)
+
+	t.Log("The file only shows up under its name after the commit")
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.IsType(t, NoSuchNameError{}, errors.Cause(err))
+	sum := sha256.Sum256([]byte("hello world"))
+	err = us.Commit(ctx, sum[:])
+	require.NoError(t, err)
+	aNode, ei, err := kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	buf := make([]byte, ei.Size)
+	_, err = kbfsOps.Read(ctx, aNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(buf))
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+
+	err = us.WriteChunk(ctx, chunk1, 0, sum1[:])
+	require.Equal(t, UploadSessionDoneError{"a"}, errors.Cause(err))
+
+	t.Log("A commit with the wrong hash leaves no trace")
+	us, err = BeginUpload(ctx, kbfsOps, rootNode, "b", false)
+	require.NoError(t, err)
+	err = us.WriteChunk(ctx, chunk1, 0, sum1[:])
+	require.NoError(t, err)
+	err = us.Commit(ctx, sum[:])
+	require.Equal(t, UploadHashMismatchError{"b"}, errors.Cause(err))
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "a")
+}
@@ -82,6 +82,16 @@ const (
 	CtxBackgroundSyncKey CtxBackgroundSyncKeyType = iota
 )
 
+// CtxNotificationSeqnosKeyType is the type for a context notification
+// sequence numbers key.
+type CtxNotificationSeqnosKeyType int
+
+const (
+	// CtxNotificationSeqnosKey is set in the context for every
+	// BatchChanges notification, to the NotificationSeqnos it covers.
+	CtxNotificationSeqnosKey CtxNotificationSeqnosKeyType = iota
+)
+
 // Warninger is an interface that only waprs the Warning method.
 type Warninger interface {
 	Warning(format string, args ...interface{})
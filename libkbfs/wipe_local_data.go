@@ -0,0 +1,165 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// wipeChunkSize is the size of the buffer used to overwrite files
+// while wiping local data.
+const wipeChunkSize = 64 * 1024
+
+// LocalDataWipeProgress describes how far along a call to
+// Config.WipeLocalData is.
+type LocalDataWipeProgress struct {
+	// Path is the file that was just wiped, or the empty string
+	// once all the files have been wiped and removed.
+	Path       string
+	FilesWiped int
+	FilesTotal int
+	BytesWiped int64
+	BytesTotal int64
+}
+
+// localDataWiper overwrites and then removes a set of directories,
+// reporting its progress along the way.
+type localDataWiper struct {
+	roots    []string
+	progress func(LocalDataWipeProgress)
+
+	files  []string
+	status LocalDataWipeProgress
+}
+
+func (w *localDataWiper) report() {
+	if w.progress != nil {
+		w.progress(w.status)
+	}
+}
+
+// collect finds all the regular files under w.roots, and tallies
+// their sizes.  Roots that don't exist are skipped.
+func (w *localDataWiper) collect(ctx context.Context) error {
+	for _, root := range w.roots {
+		err := filepath.Walk(root,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if ioutil.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				w.files = append(w.files, path)
+				w.status.FilesTotal++
+				w.status.BytesTotal += info.Size()
+				return nil
+			})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// overwriteFile replaces the contents of the file at path with
+// zeroes, and flushes them to disk, so that the old contents can't
+// be recovered from the freed blocks once the file is removed.
+func (w *localDataWiper) overwriteFile(
+	ctx context.Context, path string, zeroes []byte) (err error) {
+	f, err := ioutil.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = errors.WithStack(closeErr)
+		}
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for remaining := fi.Size(); remaining > 0; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		n := int64(len(zeroes))
+		if remaining < n {
+			n = remaining
+		}
+		written, err := f.Write(zeroes[:n])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		remaining -= int64(written)
+		w.status.BytesWiped += int64(written)
+	}
+	return errors.WithStack(f.Sync())
+}
+
+// wipe overwrites every file found by collect, removes all the
+// roots, and then verifies that none of them remain.
+func (w *localDataWiper) wipe(ctx context.Context) error {
+	err := w.collect(ctx)
+	if err != nil {
+		return err
+	}
+
+	zeroes := make([]byte, wipeChunkSize)
+	for _, path := range w.files {
+		err := w.overwriteFile(ctx, path, zeroes)
+		switch {
+		case ioutil.IsNotExist(err):
+			// Someone else removed it first; nothing to wipe.
+		case err != nil:
+			return err
+		}
+		w.status.Path = path
+		w.status.FilesWiped++
+		w.report()
+	}
+
+	for _, root := range w.roots {
+		err := ioutil.RemoveAll(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Verify that nothing was left behind, for example by a
+	// concurrent writer that wasn't shut down.
+	for _, root := range w.roots {
+		_, err := ioutil.Lstat(root)
+		switch {
+		case ioutil.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			return errors.WithStack(LocalDataWipeError{root})
+		}
+	}
+
+	w.status.Path = ""
+	w.report()
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWipeLocalData(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	config.storageRoot = tempdir
+	config.diskCacheMode = DiskCacheModeLocal
+	err := config.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	// Put a block into the journal for the current user.
+	tlfID := tlf.FakeID(2, tlf.Private)
+	err = jServer.Enable(ctx, tlfID, nil, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "test_user1", tlf.Private)
+	require.NoError(t, err)
+	id := h.ResolvedWriters()[0]
+	bCtx := kbfsblock.MakeFirstContext(id, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = config.BlockServer().Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	userJournalDir := jServer.tlfJournalPathLocked(tlfID)
+
+	// Make a journal that belongs to someone else.
+	otherJournalDir := filepath.Join(jServer.rootPath(), "other")
+	err = ioutil.MkdirAll(otherJournalDir, 0700)
+	require.NoError(t, err)
+	err = writeTLFJournalInfoFile(otherJournalDir, keybase1.MakeTestUID(5),
+		kbfscrypto.VerifyingKey{}, tlf.FakeID(3, tlf.Private),
+		keybase1.MakeTestUID(5).AsUserOrTeam())
+	require.NoError(t, err)
+
+	// Fake some on-disk cache contents.
+	cacheDir := filepath.Join(tempdir, workingSetCacheFolderName)
+	err = ioutil.MkdirAll(cacheDir, 0700)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(
+		filepath.Join(cacheDir, "blocks"), make([]byte, 3*wipeChunkSize), 0600)
+	require.NoError(t, err)
+
+	var updates []LocalDataWipeProgress
+	err = config.WipeLocalData(ctx, func(p LocalDataWipeProgress) {
+		updates = append(updates, p)
+	})
+	require.NoError(t, err)
+
+	require.True(t, len(updates) > 1)
+	last := updates[len(updates)-1]
+	require.Equal(t, "", last.Path)
+	require.Equal(t, last.FilesTotal, last.FilesWiped)
+	require.Equal(t, last.BytesTotal, last.BytesWiped)
+	require.True(t, last.BytesWiped >= 3*wipeChunkSize)
+
+	for _, dir := range []string{userJournalDir, cacheDir} {
+		_, err = ioutil.Stat(dir)
+		require.True(t, ioutil.IsNotExist(err), "%s: %+v", dir, err)
+	}
+	_, err = ioutil.Stat(otherJournalDir)
+	require.NoError(t, err)
+
+	require.Nil(t, config.DiskBlockCache())
+	_, ok := jServer.getTLFJournal(tlfID, nil)
+	require.False(t, ok)
+}
@@ -0,0 +1,17 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+// WriteFlags changes the behavior of KBFSOps.WriteWithFlags.
+type WriteFlags uint
+
+const (
+	// WriteAppend writes the data at the end of the file, wherever
+	// that is at the moment of the write, instead of at the given
+	// offset.  Concurrent local appends never overwrite or
+	// interleave with each other, which is what a file opened with
+	// O_APPEND expects.
+	WriteAppend WriteFlags = 1 << iota
+)
@@ -0,0 +1,61 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "github.com/pkg/errors"
+
+const (
+	// MaxXattrNameLength is the longest an extended attribute name
+	// can be.
+	MaxXattrNameLength = 255
+	// MaxXattrsSize is the most data, counting both names and
+	// values, that can be stored in the extended attributes of a
+	// single entry.  They live in the entry's parent directory
+	// block, so they have to stay small.
+	MaxXattrsSize = 64 * 1024
+)
+
+// xattrsSize returns the total size of the names and values in
+// `xattrs`.
+func xattrsSize(xattrs map[string][]byte) (size int) {
+	for name, value := range xattrs {
+		size += len(name) + len(value)
+	}
+	return size
+}
+
+// withXattr returns a copy of `xattrs` with `name` set to `value`,
+// or removed if `value` is nil, after checking that the result is
+// within the limits.  The result is nil if no attributes are left.
+func withXattr(xattrs map[string][]byte, name string, value []byte) (
+	map[string][]byte, error) {
+	if len(name) == 0 {
+		return nil, errors.New("Empty extended attribute name")
+	}
+	if len(name) > MaxXattrNameLength {
+		return nil, NameTooLongError{name, MaxXattrNameLength}
+	}
+	_, ok := xattrs[name]
+	if value == nil && !ok {
+		return nil, NoSuchXattrError{name}
+	}
+
+	newXattrs := make(map[string][]byte, len(xattrs)+1)
+	for n, v := range xattrs {
+		if n != name {
+			newXattrs[n] = v
+		}
+	}
+	if value != nil {
+		newXattrs[name] = append([]byte(nil), value...)
+	}
+	if size := xattrsSize(newXattrs); size > MaxXattrsSize {
+		return nil, XattrTooBigError{name, size, MaxXattrsSize}
+	}
+	if len(newXattrs) == 0 {
+		return nil, nil
+	}
+	return newXattrs, nil
+}
@@ -169,6 +169,9 @@ func (s *Server) handleError(w http.ResponseWriter, err error) {
 		config.ErrInvalidVersion, config.ErrUndefinedUsername:
 		http.Error(w, "invalid .kbp_config", http.StatusPreconditionFailed)
 		return
+	case ErrInvalidRedirects:
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
 	default:
 		// Don't write unknown errors in case we leak data unintentionally.
 		http.Error(w, "", http.StatusInternalServerError)
@@ -209,32 +212,32 @@ func (s *Server) handleUnauthorized(w http.ResponseWriter,
 }
 
 func (s *Server) isDirWithNoIndexHTML(
-	realFS *libfs.FS, requestPath string) (bool, error) {
+	realFS *libfs.FS, requestPath string) (isListing, exists bool, err error) {
 	fi, err := realFS.Stat(strings.Trim(path.Clean(requestPath), "/"))
 	switch {
 	case os.IsNotExist(err):
-		// It doesn't exist! So just let the http package handle it.
-		return false, nil
+		// It doesn't exist! The caller serves a 404 page.
+		return false, false, nil
 	case err != nil:
 		// Some other error happened. To be safe, error here.
-		return false, err
+		return false, false, err
 	default:
 		// continue
 	}
 
 	if !fi.IsDir() {
-		return false, nil
+		return false, true, nil
 	}
 
 	fi, err = realFS.Stat(path.Join(requestPath, "index.html"))
 	switch {
 	case err == nil:
-		return false, nil
+		return false, true, nil
 	case os.IsNotExist(err):
-		return true, nil
+		return true, true, nil
 	default:
 		// Some other error happened. To be safe, error here.
-		return false, err
+		return false, false, err
 	}
 }
 
@@ -399,11 +402,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	s.setCommonResponseHeaders(w)
 
-	// Don't serve the config file itself.
-	if path.Clean(strings.ToLower(r.URL.Path)) == config.DefaultConfigFilepath {
+	// Don't serve the config or redirects files themselves.
+	switch cleanPath := path.Clean(strings.ToLower(r.URL.Path)); cleanPath {
+	case config.DefaultConfigFilepath, RedirectsFilepath:
 		// TODO: integrate this check into Config?
 		http.Error(w, fmt.Sprintf("Reading %s directly is forbidden.",
-			config.DefaultConfigFilepath), http.StatusForbidden)
+			cleanPath), http.StatusForbidden)
 		return
 	}
 
@@ -451,15 +455,23 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Get a site config, which can be either a user-defined one, or the
 	// default one if it's missing from the site root.
-	cfg, err := st.getConfig(false)
+	cfg, rds, err := st.getConfig(false)
 	if err != nil {
-		// User has a .kbp_config file but it's invalid.
+		// User has a .kbp_config or .kbp_redirects file but it's
+		// invalid.
 		// TODO: error page to show the error message?
 		sri.InvalidConfig = true
 		s.handleError(w, err)
 		return
 	}
 
+	// Redirects only say where to go, so they apply before any
+	// permission checks; the target is checked when it's requested.
+	if to, status, ok := rds.match(r.URL.Path); ok {
+		http.Redirect(w, r, to, status)
+		return
+	}
+
 	var username *string
 	user, pass, ok := r.BasicAuth()
 	if ok && cfg.Authenticate(r.Context(), user, pass) {
@@ -473,11 +485,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if it's a directory containing no index.html before letting
-	// http.FileServer handle it.  This permission check should ideally
-	// happen inside the http package, but unfortunately there isn't a
-	// way today.
-	isListing, err := s.isDirWithNoIndexHTML(realFS, r.URL.Path)
+	// Check if it's a directory containing no index.html, which gets a
+	// generated index page, or a path that doesn't exist, which gets
+	// the site's 404 page, before letting http.FileServer handle the
+	// rest.
+	isListing, exists, err := s.isDirWithNoIndexHTML(realFS, r.URL.Path)
 	if err != nil {
 		s.handleError(w, err)
 		return
@@ -493,6 +505,26 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !exists {
+		// Only show the site's own 404 page to those who may read it.
+		canRead404, _, _, _, _, err := cfg.GetPermissions(
+			NotFoundPageFilepath, username)
+		if err != nil || !canRead404 {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveNotFound(w, r, realFS)
+		return
+	}
+
+	if isListing {
+		err = s.serveDirIndex(w, r, realFS, path.Clean(r.URL.Path))
+		if err != nil {
+			s.handleError(w, err)
+		}
+		return
+	}
+
 	http.FileServer(realFS.ToHTTPFileSystem(ctx)).ServeHTTP(w, r)
 }
 
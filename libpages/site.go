@@ -25,6 +25,7 @@ type site struct {
 	// TODO: replace this with a notification mechanism from the FBO.
 	cachedConfigLock      sync.RWMutex
 	cachedConfig          config.Config
+	cachedRedirects       redirects
 	cachedConfigExpiresAt time.Time
 }
 
@@ -41,13 +42,15 @@ func (s *site) shutdown() {
 	s.fsShutdown()
 }
 
-func (s *site) getCachedConfig() (cfg config.Config, expiresAt time.Time) {
+func (s *site) getCachedConfig() (
+	cfg config.Config, rds redirects, expiresAt time.Time) {
 	s.cachedConfigLock.RLock()
 	defer s.cachedConfigLock.RUnlock()
-	return s.cachedConfig, s.cachedConfigExpiresAt
+	return s.cachedConfig, s.cachedRedirects, s.cachedConfigExpiresAt
 }
 
-func (s *site) fetchConfigAndRefreshCache() (cfg config.Config, err error) {
+func (s *site) fetchConfigAndRefreshCache() (
+	cfg config.Config, rds redirects, err error) {
 	// Take the lock early to block other reads, since otherwise they would
 	// also reach here, causing unnecessary multiple fetches.
 	s.cachedConfigLock.Lock()
@@ -56,12 +59,12 @@ func (s *site) fetchConfigAndRefreshCache() (cfg config.Config, err error) {
 	if s.cachedConfigExpiresAt.After(time.Now()) {
 		// Some other goroutine beat us! The cached config is up-to-date now so
 		// just return it.
-		return s.cachedConfig, nil
+		return s.cachedConfig, s.cachedRedirects, nil
 	}
 
 	realFS, err := s.fs.Use()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	f, err := realFS.Open(config.DefaultConfigFilepath)
@@ -69,24 +72,41 @@ func (s *site) fetchConfigAndRefreshCache() (cfg config.Config, err error) {
 	case os.IsNotExist(err):
 		cfg = config.DefaultV1()
 	case err == nil:
+		defer f.Close()
 		cfg, err = config.ParseConfig(f)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	default:
-		return nil, err
+		return nil, nil, err
+	}
+
+	f, err = realFS.Open(RedirectsFilepath)
+	switch {
+	case os.IsNotExist(err):
+	case err == nil:
+		defer f.Close()
+		rds, err = parseRedirects(f)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, err
 	}
 
 	s.cachedConfig = cfg
+	s.cachedRedirects = rds
 	s.cachedConfigExpiresAt = time.Now().Add(configCacheTime)
 
-	return cfg, nil
+	return cfg, rds, nil
 }
 
-func (s *site) getConfig(forceRefresh bool) (cfg config.Config, err error) {
-	cachedConfig, cacheExpiresAt := s.getCachedConfig()
+// getConfig returns the site's config, and its redirects.
+func (s *site) getConfig(forceRefresh bool) (
+	cfg config.Config, rds redirects, err error) {
+	cachedConfig, cachedRedirects, cacheExpiresAt := s.getCachedConfig()
 	if !forceRefresh && cacheExpiresAt.After(time.Now()) {
-		return cachedConfig, nil
+		return cachedConfig, cachedRedirects, nil
 	}
 	return s.fetchConfigAndRefreshCache()
 }
@@ -0,0 +1,218 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libpages
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+)
+
+// RedirectsFilename is the name of the file, at the site root, that
+// lists the site's redirects.
+const RedirectsFilename = ".kbp_redirects"
+
+// RedirectsFilepath is the path of the redirects file under the site
+// root.
+const RedirectsFilepath = "/" + RedirectsFilename
+
+// NotFoundPageFilepath is the path, under the site root, of the page
+// served, with a 404 status, for paths that don't exist.
+const NotFoundPageFilepath = "/404.html"
+
+// ErrInvalidRedirects is returned when the site's redirects file
+// can't be parsed.
+type ErrInvalidRedirects struct {
+	Line   int
+	Reason string
+}
+
+// Error implements the error interface.
+func (e ErrInvalidRedirects) Error() string {
+	return fmt.Sprintf("invalid %s at line %d: %s",
+		RedirectsFilename, e.Line, e.Reason)
+}
+
+// redirect sends requests for `from` to `to`.  If `from` ends with
+// "*", it matches every path that starts with the rest of it, and
+// any ":splat" in `to` is replaced with the part of the path the "*"
+// matched.
+type redirect struct {
+	from   string
+	to     string
+	status int
+}
+
+type redirects []redirect
+
+// parseRedirects parses a redirects file.  Each line has the form
+//
+//     /from /to [status]
+//
+// where status is one of 301, 302, 307 or 308, and defaults to 302.
+// Blank lines and lines starting with "#" are ignored.  When several
+// lines match a request, the first one wins.
+func parseRedirects(r io.Reader) (rs redirects, err error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, ErrInvalidRedirects{
+				Line: line, Reason: "expected: /from /to [status]"}
+		}
+		rd := redirect{from: fields[0], to: fields[1], status: http.StatusFound}
+		if !strings.HasPrefix(rd.from, "/") {
+			return nil, ErrInvalidRedirects{
+				Line: line, Reason: "source must be an absolute path"}
+		}
+		if len(fields) == 3 {
+			rd.status, err = strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, ErrInvalidRedirects{
+					Line: line, Reason: "invalid status " + fields[2]}
+			}
+			switch rd.status {
+			case http.StatusMovedPermanently, http.StatusFound,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				return nil, ErrInvalidRedirects{
+					Line: line, Reason: "invalid status " + fields[2]}
+			}
+		}
+		rs = append(rs, rd)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// match returns where a request for `requestPath` should be
+// redirected to, if anywhere.
+func (rs redirects) match(requestPath string) (to string, status int, ok bool) {
+	for _, rd := range rs {
+		if strings.HasSuffix(rd.from, "*") {
+			prefix := strings.TrimSuffix(rd.from, "*")
+			if strings.HasPrefix(requestPath, prefix) {
+				splat := requestPath[len(prefix):]
+				return strings.Replace(rd.to, ":splat", splat, -1),
+					rd.status, true
+			}
+		} else if requestPath == rd.from {
+			return rd.to, rd.status, true
+		}
+	}
+	return "", 0, false
+}
+
+var dirIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Index of {{.Path}}</title>
+	</head>
+	<body>
+		<h1>Index of {{.Path}}</h1>
+		<table>
+			<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if ne .Path "/"}}
+			<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+			<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{- end}}
+		</table>
+	</body>
+</html>
+`))
+
+type dirIndexEntry struct {
+	Name     string
+	Href     string
+	Size     string
+	Modified string
+}
+
+// writeDirIndex writes an HTML index page listing `fis`, the entries
+// of the directory at `dirPath`.  Hidden entries, such as the site's
+// config and redirects files, aren't listed.
+func writeDirIndex(w http.ResponseWriter, dirPath string, fis []os.FileInfo) {
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	entries := make([]dirIndexEntry, 0, len(fis))
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		e := dirIndexEntry{
+			Name:     fi.Name(),
+			Href:     (&url.URL{Path: fi.Name()}).String(),
+			Modified: fi.ModTime().UTC().Format(time.RFC3339),
+		}
+		if fi.IsDir() {
+			e.Name += "/"
+			e.Href += "/"
+		} else {
+			e.Size = strconv.FormatInt(fi.Size(), 10)
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Nothing useful can be done about a failed write here.
+	_ = dirIndexTemplate.Execute(w, struct {
+		Path    string
+		Entries []dirIndexEntry
+	}{dirPath, entries})
+}
+
+// serveDirIndex serves an index page for the directory at
+// `requestPath`, which must exist.
+func (s *Server) serveDirIndex(w http.ResponseWriter, r *http.Request,
+	realFS *libfs.FS, requestPath string) error {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		// Make relative links in the index resolve inside the
+		// directory, like http.FileServer does.
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/",
+			http.StatusMovedPermanently)
+		return nil
+	}
+	fis, err := realFS.ReadDir(strings.Trim(requestPath, "/"))
+	if err != nil {
+		return err
+	}
+	writeDirIndex(w, requestPath, fis)
+	return nil
+}
+
+// serveNotFound serves the site's custom 404 page, or a plain one if
+// the site doesn't have one.
+func (s *Server) serveNotFound(
+	w http.ResponseWriter, r *http.Request, realFS *libfs.FS) {
+	f, err := realFS.Open(strings.TrimPrefix(NotFoundPageFilepath, "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	// The status is already written, so there's nothing to do about
+	// a failed copy.
+	_, _ = io.Copy(w, f)
+}
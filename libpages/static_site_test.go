@@ -0,0 +1,89 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libpages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rds, err := parseRedirects(strings.NewReader(`
+# Moved pages.
+/old /new
+/blog/* /posts/:splat 301
+/gone https://example.com/ 308
+`))
+	require.NoError(t, err)
+
+	to, status, ok := rds.match("/old")
+	require.True(t, ok)
+	require.Equal(t, "/new", to)
+	require.Equal(t, http.StatusFound, status)
+
+	to, status, ok = rds.match("/blog/2018/hello.html")
+	require.True(t, ok)
+	require.Equal(t, "/posts/2018/hello.html", to)
+	require.Equal(t, http.StatusMovedPermanently, status)
+
+	to, status, ok = rds.match("/gone")
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/", to)
+	require.Equal(t, http.StatusPermanentRedirect, status)
+
+	_, _, ok = rds.match("/old/page")
+	require.False(t, ok)
+
+	for _, bad := range []string{
+		"/from",
+		"/from /to 302 extra",
+		"from /to",
+		"/from /to 200",
+		"/from /to abc",
+	} {
+		_, err = parseRedirects(strings.NewReader(bad))
+		require.IsType(t, ErrInvalidRedirects{}, err, bad)
+	}
+}
+
+type testFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return fi.size }
+func (fi testFileInfo) Mode() os.FileMode  { return 0 }
+func (fi testFileInfo) ModTime() time.Time { return time.Unix(0, 0) }
+func (fi testFileInfo) IsDir() bool        { return fi.isDir }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+
+func TestWriteDirIndex(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeDirIndex(w, "/docs", []os.FileInfo{
+		testFileInfo{name: "b.txt", size: 12},
+		testFileInfo{name: "a dir", isDir: true},
+		testFileInfo{name: ".kbp_redirects", size: 3},
+		testFileInfo{name: "<script>.html", size: 1},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	require.Contains(t, body, "Index of /docs")
+	require.Contains(t, body, `<a href="../">`)
+	require.Contains(t, body, `<a href="a%20dir/">a dir/</a>`)
+	require.Contains(t, body, `<a href="b.txt">b.txt</a></td><td>12</td>`)
+	require.NotContains(t, body, ".kbp_redirects")
+	require.NotContains(t, body, "<script>")
+	require.True(t, strings.Index(body, "a dir") < strings.Index(body, "b.txt"))
+}
@@ -138,7 +138,10 @@ func NewSimpleFS(g *libkb.GlobalContext, config libkbfs.Config) keybase1.SimpleF
 }
 
 func (k *SimpleFS) makeContext(ctx context.Context) context.Context {
-	return libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, k.log)
+	ctx = libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, k.log)
+	return libkbfs.CtxWithRequestSource(ctx, libkbfs.RequestSource{
+		Frontend: libkbfs.RequestFrontendRPC,
+	})
 }
 
 // remoteTlfAndPath decodes a remote path for us.
@@ -287,7 +290,8 @@ func (k *SimpleFS) setResult(opid keybase1.OpID, val interface{}) {
 func (k *SimpleFS) startOp(ctx context.Context, opid keybase1.OpID,
 	opType keybase1.AsyncOps, desc keybase1.OpDescription) (
 	context.Context, error) {
-	ctx = k.makeContext(ctx)
+	ctx = libkbfs.CtxWithRequestSource(k.makeContext(ctx),
+		libkbfs.RequestSource{Action: opType.String()})
 	ctx, cancel := context.WithCancel(ctx)
 	k.lock.Lock()
 	k.inProgress[opid] = &inprogress{
@@ -847,7 +851,8 @@ func (k *SimpleFS) SimpleFSMove(ctx context.Context, arg keybase1.SimpleFSMoveAr
 }
 
 func (k *SimpleFS) startSyncOp(ctx context.Context, name string, logarg interface{}) (context.Context, error) {
-	ctx = k.makeContext(ctx)
+	ctx = libkbfs.CtxWithRequestSource(k.makeContext(ctx),
+		libkbfs.RequestSource{Action: name})
 	k.log.CDebugf(ctx, "start sync %s %v", name, logarg)
 	return k.startOpWrapContext(ctx)
 }